@@ -1,20 +1,35 @@
 package main
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/ralt/repogen/internal/cli"
 	"github.com/sirupsen/logrus"
 )
 
+// version is set at build time (-X main.version=...)
+var version = "dev"
+
 func main() {
 	// Setup logging format
 	logrus.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
 	})
 
-	rootCmd := cli.NewRootCmd()
-	if err := rootCmd.Execute(); err != nil {
+	// The first Ctrl-C or SIGTERM cancels the run, which stops between two
+	// files and removes what it wrote; a second one quits at once
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		logrus.Warn("Interrupted, stopping (interrupt again to quit at once)")
+		stop()
+	}()
+
+	rootCmd := cli.NewRootCmd(version)
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		logrus.Error(err)
 		os.Exit(1)
 	}
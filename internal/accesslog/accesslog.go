@@ -0,0 +1,174 @@
+package accesslog
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+// Request is the part of an access log entry needed to count downloads
+type Request struct {
+	Method string
+	Path   string // URL path, unescaped and without query string
+	Status int
+}
+
+// ParseLine extracts the request of a common/combined log format line
+// (Apache, nginx, Caddy, ...) or an S3 server access log line. Both quote
+// the request line ("GET /path HTTP/1.1") and follow it with the status code
+func ParseLine(line string) (Request, bool) {
+	for rest := line; ; {
+		start := strings.IndexByte(rest, '"')
+		if start < 0 {
+			return Request{}, false
+		}
+		end := strings.IndexByte(rest[start+1:], '"')
+		if end < 0 {
+			return Request{}, false
+		}
+		quoted := rest[start+1 : start+1+end]
+		rest = rest[start+1+end+1:]
+
+		fields := strings.Fields(quoted)
+		if len(fields) != 3 || !strings.HasPrefix(fields[2], "HTTP/") {
+			continue
+		}
+
+		statusField := strings.Fields(rest)
+		if len(statusField) == 0 {
+			return Request{}, false
+		}
+		status, err := strconv.Atoi(statusField[0])
+		if err != nil {
+			return Request{}, false
+		}
+
+		target := fields[1]
+		if u, err := url.ParseRequestURI(target); err == nil {
+			target = u.Path
+		}
+
+		return Request{Method: fields[0], Path: target, Status: status}, true
+	}
+}
+
+// Download counts the downloads of one published package file
+type Download struct {
+	Type         string `json:"type"`
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Architecture string `json:"arch"`
+	Path         string `json:"path"`
+	Count        int    `json:"downloads"`
+}
+
+// Report summarizes the downloads found in access logs
+type Report struct {
+	Downloads []Download `json:"packages"` // Most downloaded first
+	Total     int        `json:"total"`    // Package downloads counted
+	Requests  int        `json:"requests"` // Log lines holding a request
+	Skipped   int        `json:"skipped"`  // Lines that could not be parsed
+}
+
+// Analyzer matches requests against the package files of a repository
+type Analyzer struct {
+	files     map[string]*Download // Keyed by slash-separated path inside the repository
+	requests  int
+	skipped   int
+	downloads int
+}
+
+// NewAnalyzer creates an analyzer; register the published packages with AddPackage
+func NewAnalyzer() *Analyzer {
+	return &Analyzer{files: make(map[string]*Download)}
+}
+
+// AddPackage registers the file at path (relative to the repository root) as pkg
+func (a *Analyzer) AddPackage(pkgType, path string, pkg models.Package) {
+	path = strings.TrimPrefix(path, "/")
+	a.files[path] = &Download{
+		Type:         pkgType,
+		Name:         pkg.Name,
+		Version:      pkg.Version,
+		Architecture: pkg.Architecture,
+		Path:         path,
+	}
+}
+
+// Read counts the successful package downloads logged in r. Only complete
+// GET responses (200) are counted: ranged (206) responses would count a
+// resumed download several times, and HEAD or 304 transfer no package
+func (a *Analyzer) Read(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		req, ok := ParseLine(line)
+		if !ok {
+			a.skipped++
+			continue
+		}
+		a.requests++
+
+		if req.Method != "GET" || req.Status != 200 {
+			continue
+		}
+		if d := a.lookup(req.Path); d != nil {
+			d.Count++
+			a.downloads++
+		}
+	}
+
+	return sc.Err()
+}
+
+// lookup finds the package served at path. The repository may be served
+// below any prefix (e.g. /debian/pool/...), so leading path components are
+// dropped until a published file matches
+func (a *Analyzer) lookup(path string) *Download {
+	path = strings.TrimPrefix(path, "/")
+	for path != "" {
+		if d, ok := a.files[path]; ok {
+			return d
+		}
+		_, rest, ok := strings.Cut(path, "/")
+		if !ok {
+			break
+		}
+		path = rest
+	}
+	return nil
+}
+
+// Report returns the downloads counted so far. Packages that were never
+// downloaded are included with a zero count
+func (a *Analyzer) Report() *Report {
+	report := &Report{
+		Total:    a.downloads,
+		Requests: a.requests,
+		Skipped:  a.skipped,
+	}
+	for _, d := range a.files {
+		report.Downloads = append(report.Downloads, *d)
+	}
+
+	sort.Slice(report.Downloads, func(i, j int) bool {
+		di, dj := report.Downloads[i], report.Downloads[j]
+		if di.Count != dj.Count {
+			return di.Count > dj.Count
+		}
+		return di.Path < dj.Path
+	})
+
+	return report
+}
@@ -0,0 +1,96 @@
+package accesslog
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want Request
+		ok   bool
+	}{
+		{
+			name: "combined",
+			line: `203.0.113.7 - - [10/Oct/2026:13:55:36 +0000] "GET /debian/pool/main/h/hello/hello_1.0_amd64.deb HTTP/1.1" 200 2326 "-" "Debian APT-HTTP/1.3 (2.6.1)"`,
+			want: Request{Method: "GET", Path: "/debian/pool/main/h/hello/hello_1.0_amd64.deb", Status: 200},
+			ok:   true,
+		},
+		{
+			name: "escaped path and query",
+			line: `203.0.113.7 - - [10/Oct/2026:13:55:36 +0000] "GET /x86_64/hello%2B1.0-1-x86_64.pkg.tar.zst?token=1 HTTP/2.0" 304 0`,
+			want: Request{Method: "GET", Path: "/x86_64/hello+1.0-1-x86_64.pkg.tar.zst", Status: 304},
+			ok:   true,
+		},
+		{
+			name: "s3",
+			line: `79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be repo [06/Feb/2026:00:00:38 +0000] 192.0.2.3 - 3E57427F3EXAMPLE REST.GET.OBJECT 40/x86_64/hello-1.0-1.x86_64.rpm "GET /repo/40/x86_64/hello-1.0-1.x86_64.rpm HTTP/1.1" 200 - 113 113 7 - "-" "libdnf (Fedora Linux 40)" - s9lzHYrFp76ZVxRcpX9+5cjAnEH2ROuNkd2BHfIa6UkFVdtjf5mKR3/eTPFvsiP/XV/VLi31234= SigV4 ECDHE-RSA-AES128-GCM-SHA256 AuthHeader repo.s3.amazonaws.com TLSv1.2 - -`,
+			want: Request{Method: "GET", Path: "/repo/40/x86_64/hello-1.0-1.x86_64.rpm", Status: 200},
+			ok:   true,
+		},
+		{name: "garbage", line: "not a log line", ok: false},
+		{name: "no status", line: `"GET / HTTP/1.1"`, ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseLine(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("ParseLine ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && got != tt.want {
+				t.Errorf("ParseLine = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzerCountsDownloads(t *testing.T) {
+	a := NewAnalyzer()
+	a.AddPackage("deb", "pool/main/h/hello/hello_1.0_amd64.deb", models.Package{Name: "hello", Version: "1.0", Architecture: "amd64"})
+	a.AddPackage("deb", "pool/main/h/hello/hello_2.0_amd64.deb", models.Package{Name: "hello", Version: "2.0", Architecture: "amd64"})
+	a.AddPackage("deb", "pool/main/h/hello/hello_2.0_arm64.deb", models.Package{Name: "hello", Version: "2.0", Architecture: "arm64"})
+
+	logs := strings.Join([]string{
+		`1.1.1.1 - - [10/Oct/2026:13:55:36 +0000] "GET /debian/pool/main/h/hello/hello_2.0_amd64.deb HTTP/1.1" 200 2326`,
+		`1.1.1.2 - - [10/Oct/2026:13:55:37 +0000] "GET /pool/main/h/hello/hello_2.0_amd64.deb HTTP/1.1" 200 2326`,
+		`1.1.1.3 - - [10/Oct/2026:13:55:38 +0000] "GET /pool/main/h/hello/hello_1.0_amd64.deb HTTP/1.1" 200 2326`,
+		`1.1.1.4 - - [10/Oct/2026:13:55:39 +0000] "GET /pool/main/h/hello/hello_1.0_amd64.deb HTTP/1.1" 206 1000`,
+		`1.1.1.5 - - [10/Oct/2026:13:55:40 +0000] "HEAD /pool/main/h/hello/hello_1.0_amd64.deb HTTP/1.1" 200 0`,
+		`1.1.1.6 - - [10/Oct/2026:13:55:41 +0000] "GET /pool/main/h/hello/hello_1.0_amd64.deb HTTP/1.1" 404 0`,
+		`1.1.1.7 - - [10/Oct/2026:13:55:42 +0000] "GET /dists/stable/InRelease HTTP/1.1" 200 3000`,
+		``,
+		`garbage`,
+	}, "\n")
+
+	if err := a.Read(strings.NewReader(logs)); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	report := a.Report()
+	if report.Total != 3 || report.Requests != 7 || report.Skipped != 1 {
+		t.Errorf("Total/Requests/Skipped = %d/%d/%d, want 3/7/1", report.Total, report.Requests, report.Skipped)
+	}
+
+	want := []struct {
+		version, arch string
+		count         int
+	}{
+		{"2.0", "amd64", 2},
+		{"1.0", "amd64", 1},
+		{"2.0", "arm64", 0},
+	}
+	if len(report.Downloads) != len(want) {
+		t.Fatalf("Got %d packages, want %d", len(report.Downloads), len(want))
+	}
+	for i, w := range want {
+		d := report.Downloads[i]
+		if d.Version != w.version || d.Architecture != w.arch || d.Count != w.count {
+			t.Errorf("Downloads[%d] = %s %s: %d, want %s %s: %d", i, d.Version, d.Architecture, d.Count, w.version, w.arch, w.count)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package adopt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// SettingsPath records the settings a repository was imported with,
+// relative to the repository root. It is keyed by flag name like a --config
+// file, and applies to every command writing the repository
+const SettingsPath = ".repogen/settings.yaml"
+
+// Settings are the flags describing the layout of a repository created by
+// another tool, which repogen needs to read it
+type Settings struct {
+	Codename     string   `yaml:"codename,omitempty"`
+	Suite        string   `yaml:"suite,omitempty"`
+	Origin       string   `yaml:"origin,omitempty"`
+	Label        string   `yaml:"label,omitempty"`
+	Components   []string `yaml:"components,omitempty"`
+	Arches       []string `yaml:"arch,omitempty"`
+	RepoName     string   `yaml:"repo-name,omitempty"`
+	DebLayout    string   `yaml:"deb-layout,omitempty"`
+	PacmanLayout string   `yaml:"pacman-layout,omitempty"`
+}
+
+// Detect infers the settings of the repository in dir: Debian suites,
+// components and architectures from its Release files, and the
+// architectures of Alpine indexes and Pacman databases with the name of
+// the Pacman repository
+func Detect(dir string) (*Settings, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	s := &Settings{}
+	arches := make(map[string]bool)
+
+	// Debian: dists/<codename>/Release, or a flat Release at the root
+	releases, _ := filepath.Glob(filepath.Join(dir, "dists", "*", "Release"))
+	if len(releases) == 0 {
+		if _, err := os.Stat(filepath.Join(dir, "Release")); err == nil {
+			releases = []string{filepath.Join(dir, "Release")}
+			s.DebLayout = "flat"
+		}
+	}
+	var codenames []string
+	components := make(map[string]bool)
+	for _, release := range releases {
+		fields, err := readRelease(release)
+		if err != nil {
+			return nil, err
+		}
+
+		// Suite names are usually links to the codename directory
+		codename := fields["Codename"]
+		if codename == "" && s.DebLayout == "" {
+			codename = filepath.Base(filepath.Dir(release))
+		}
+		if codename != "" && !slices.Contains(codenames, codename) {
+			codenames = append(codenames, codename)
+		}
+		if s.Suite == "" {
+			s.Suite = fields["Suite"]
+		}
+		if s.Origin == "" {
+			s.Origin = fields["Origin"]
+		}
+		if s.Label == "" {
+			s.Label = fields["Label"]
+		}
+		for _, comp := range strings.Fields(fields["Components"]) {
+			components[comp] = true
+		}
+		for _, arch := range strings.Fields(fields["Architectures"]) {
+			if arch != "all" && arch != "source" {
+				arches[arch] = true
+			}
+		}
+	}
+	sort.Strings(codenames)
+	s.Codename = strings.Join(codenames, ",")
+	s.Components = sortedKeys(components)
+
+	// Alpine: <arch>/APKINDEX.tar.gz
+	indexes, _ := filepath.Glob(filepath.Join(dir, "*", "APKINDEX.tar.gz"))
+	for _, index := range indexes {
+		arches[filepath.Base(filepath.Dir(index))] = true
+	}
+
+	// Pacman: <arch>/<repo>.db, or os/<arch>/<repo>.db in the pool layout
+	databases, _ := filepath.Glob(filepath.Join(dir, "*", "*.db"))
+	if pooled, _ := filepath.Glob(filepath.Join(dir, "os", "*", "*.db")); len(pooled) > 0 {
+		databases = pooled
+		s.PacmanLayout = "pool"
+	}
+	for _, db := range databases {
+		arches[filepath.Base(filepath.Dir(db))] = true
+		if s.RepoName == "" {
+			s.RepoName = strings.TrimSuffix(filepath.Base(db), ".db")
+		}
+	}
+
+	s.Arches = sortedKeys(arches)
+	return s, nil
+}
+
+// Apply sets the detected settings in config
+func (s *Settings) Apply(config *models.RepositoryConfig) {
+	if s.Codename != "" {
+		config.Codename = s.Codename
+	}
+	if s.Suite != "" {
+		config.Suite = s.Suite
+	}
+	if s.Origin != "" {
+		config.Origin = s.Origin
+	}
+	if s.Label != "" {
+		config.Label = s.Label
+	}
+	if len(s.Components) > 0 {
+		config.Components = s.Components
+	}
+	if len(s.Arches) > 0 {
+		config.Arches = s.Arches
+	}
+	if s.RepoName != "" {
+		config.RepoName = s.RepoName
+	}
+	if s.DebLayout != "" {
+		config.DebLayout = s.DebLayout
+	}
+	if s.PacmanLayout != "" {
+		config.PacmanLayout = s.PacmanLayout
+	}
+}
+
+// Write records the settings in the repository in dir
+func Write(dir string, s *Settings) error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	header := "# Detected by repogen import; applies to every command writing this repository\n"
+	return utils.WriteFile(filepath.Join(dir, filepath.FromSlash(SettingsPath)), append([]byte(header), data...), 0644)
+}
+
+// VerifyFile checks that the package file at path has the size and
+// checksum recorded in the metadata of pkg, the strongest one it has
+func VerifyFile(path string, pkg models.Package) error {
+	sums, err := utils.CalculateChecksums(path)
+	if err != nil {
+		return err
+	}
+	if pkg.Size > 0 && sums.Size != pkg.Size {
+		return fmt.Errorf("size is %d bytes, metadata lists %d", sums.Size, pkg.Size)
+	}
+
+	// Alpine indexes record the SHA-1 of the control section, not the file
+	sha1Sum := pkg.SHA1Sum
+	if control, _ := pkg.Metadata["control_sha1"].(string); control == sha1Sum {
+		sha1Sum = ""
+	}
+
+	for _, c := range []struct {
+		name, want, got string
+	}{
+		{"sha512", pkg.SHA512Sum, sums.SHA512},
+		{"sha256", pkg.SHA256Sum, sums.SHA256},
+		{"sha1", sha1Sum, sums.SHA1},
+		{"md5", pkg.MD5Sum, sums.MD5},
+	} {
+		if c.want == "" {
+			continue
+		}
+		if !strings.EqualFold(c.want, c.got) {
+			return fmt.Errorf("%s is %s, metadata lists %s", c.name, c.got, c.want)
+		}
+		return nil
+	}
+	return nil
+}
+
+// readRelease reads the single-line fields of a Release file
+func readRelease(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, " ") {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	return fields, sc.Err()
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,137 @@
+package adopt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/generator/apk"
+	"github.com/ralt/repogen/internal/generator/deb"
+	"github.com/ralt/repogen/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+func TestDetectAndVerifyDebianRepository(t *testing.T) {
+	pkg, err := deb.ParsePackage("../../test/fixtures/debs/repogen-test_1.0.0_amd64.deb")
+	if err != nil {
+		t.Fatalf("Failed to parse package: %v", err)
+	}
+
+	dir := t.TempDir()
+	config := &models.RepositoryConfig{
+		OutputDir:  dir,
+		Codename:   "trixie",
+		Suite:      "testing",
+		Origin:     "Example",
+		Components: []string{"main", "contrib"},
+		Arches:     []string{"amd64", "arm64"},
+	}
+	gen := deb.NewGenerator(nil)
+	if err := gen.Generate(context.Background(), config, []models.Package{*pkg}); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	settings, err := Detect(dir)
+	if err != nil {
+		t.Fatalf("Detect failed: %v", err)
+	}
+	if settings.Codename != "trixie" || settings.Suite != "testing" || settings.Origin != "Example" {
+		t.Errorf("Detected %+v", settings)
+	}
+	if strings.Join(settings.Components, ",") != "contrib,main" || strings.Join(settings.Arches, ",") != "amd64,arm64" {
+		t.Errorf("Detected components %v and architectures %v", settings.Components, settings.Arches)
+	}
+
+	// The detected settings read the repository back
+	var detected models.RepositoryConfig
+	detected.OutputDir = dir
+	settings.Apply(&detected)
+	packages, err := gen.ParseExistingMetadata(&detected)
+	if err != nil || len(packages) != 1 {
+		t.Fatalf("ParseExistingMetadata returned %d packages: %v", len(packages), err)
+	}
+	path := gen.(generator.PackageLocator).PackageFiles(&detected, packages[0])[0]
+	if err := VerifyFile(path, packages[0]); err != nil {
+		t.Errorf("VerifyFile failed: %v", err)
+	}
+
+	// A file changed behind the metadata's back is reported
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFile(path, packages[0]); err == nil || !strings.Contains(err.Error(), "sha512") {
+		t.Errorf("VerifyFile of a changed file returned %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFile(path, packages[0]); err == nil {
+		t.Error("VerifyFile of a missing file succeeded")
+	}
+}
+
+func TestDetectAndVerifyAlpineRepository(t *testing.T) {
+	pkg, err := apk.ParsePackage("../../test/fixtures/apks/repogen-test-1.0.0-r0.apk")
+	if err != nil {
+		t.Fatalf("Failed to parse package: %v", err)
+	}
+
+	dir := t.TempDir()
+	config := &models.RepositoryConfig{OutputDir: dir, Arches: []string{"x86_64", "aarch64"}}
+	gen := apk.NewGenerator(nil, "")
+	if err := gen.Generate(context.Background(), config, []models.Package{*pkg}); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	settings, err := Detect(dir)
+	if err != nil {
+		t.Fatalf("Detect failed: %v", err)
+	}
+	if settings.Codename != "" || settings.DebLayout != "" || len(settings.Arches) == 0 {
+		t.Errorf("Detected %+v", settings)
+	}
+
+	// Index checksums cover the control section, not the whole file
+	var detected models.RepositoryConfig
+	detected.OutputDir = dir
+	settings.Apply(&detected)
+	packages, err := gen.ParseExistingMetadata(&detected)
+	if err != nil || len(packages) == 0 {
+		t.Fatalf("ParseExistingMetadata returned %d packages: %v", len(packages), err)
+	}
+	for _, p := range packages {
+		path := gen.(generator.PackageLocator).PackageFiles(&detected, p)[0]
+		if err := VerifyFile(path, p); err != nil {
+			t.Errorf("VerifyFile of %s failed: %v", path, err)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	settings := &Settings{Codename: "bookworm", Components: []string{"main"}, DebLayout: "flat"}
+	if err := Write(dir, settings); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Settings are keyed by flag name, like a --config file
+	data, err := os.ReadFile(filepath.Join(dir, SettingsPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		t.Fatalf("Settings are not YAML: %v", err)
+	}
+	if values["codename"] != "bookworm" || values["deb-layout"] != "flat" || len(values) != 3 {
+		t.Errorf("Settings are %v", values)
+	}
+}
@@ -0,0 +1,238 @@
+// Package browse writes index.html pages listing the directories of a
+// repository, for static hosts without directory listings (S3, GitHub Pages)
+package browse
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ralt/repogen/internal/clientsetup"
+	"github.com/ralt/repogen/internal/manifest"
+	"github.com/ralt/repogen/internal/snapshot"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// PageName is the page written into each directory
+const PageName = "index.html"
+
+// marker tells the pages repogen writes from those of package formats
+// serving their own, like PyPI simple indexes, which are left alone
+const marker = `<meta name="generator" content="repogen">`
+
+// Site describes the repository the pages browse
+type Site struct {
+	Title   string
+	Setup   clientsetup.Setup // Install instructions of the root page
+	Updated time.Time
+}
+
+// entry is a file or directory listed on a page
+type entry struct {
+	Name    string
+	Dir     bool
+	Size    string
+	Package *manifest.Entry // Set for published package files
+}
+
+// page is the listing of one directory
+type page struct {
+	Site
+	Path     string // URL path from the root, "/" for the root
+	Root     bool
+	Entries  []entry
+	Install  []clientsetup.Instruction
+	Packages []packageRow
+}
+
+// packageRow is a package listed on the root page
+type packageRow struct {
+	manifest.Entry
+	Size string
+}
+
+// Write writes a page into every directory of the repository in repoDir,
+// and returns how many it wrote. Snapshots aren't browsed, as they must
+// stay as they were taken
+func Write(repoDir string, site Site) (int, error) {
+	m, err := manifest.Read(repoDir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if m == nil {
+		m = &manifest.Manifest{}
+	}
+	packages := make(map[string]*manifest.Entry, len(m.Packages))
+	for i := range m.Packages {
+		if m.Packages[i].Path != "" {
+			packages[m.Packages[i].Path] = &m.Packages[i]
+		}
+	}
+
+	w := &writer{repoDir: repoDir, site: site, manifest: m, packages: packages}
+	if err := w.write(""); err != nil {
+		return w.pages, err
+	}
+	return w.pages, nil
+}
+
+type writer struct {
+	repoDir  string
+	site     Site
+	manifest *manifest.Manifest
+	packages map[string]*manifest.Entry // By path
+	pages    int
+}
+
+// write writes the page of dir, relative to the repository root, and of
+// its subdirectories
+func (w *writer) write(dir string) error {
+	abs := filepath.Join(w.repoDir, filepath.FromSlash(dir))
+	pagePath := filepath.Join(abs, PageName)
+	if data, err := os.ReadFile(pagePath); err == nil && !bytes.Contains(data, []byte(marker)) {
+		return nil
+	}
+
+	files, err := os.ReadDir(abs)
+	if err != nil {
+		return err
+	}
+	var entries []entry
+	var subdirs []string
+	for _, f := range files {
+		name := f.Name()
+		if strings.HasPrefix(name, ".") || name == PageName {
+			continue
+		}
+		rel := path.Join(dir, name)
+		info, err := os.Stat(filepath.Join(abs, name))
+		if err != nil {
+			// Dangling symlink
+			continue
+		}
+
+		if info.IsDir() {
+			entries = append(entries, entry{Name: name, Dir: true})
+			// Symlinked directories are browsed through their target
+			if f.Type()&os.ModeSymlink == 0 && !(dir == "" && name == snapshot.Dir) {
+				subdirs = append(subdirs, rel)
+			}
+			continue
+		}
+		entries = append(entries, entry{Name: name, Size: formatSize(info.Size()), Package: w.packages[rel]})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Dir != entries[j].Dir {
+			return entries[i].Dir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+
+	p := page{Site: w.site, Path: "/" + dir, Root: dir == "", Entries: entries}
+	if dir != "" {
+		p.Path += "/"
+	}
+	if p.Root {
+		p.Install = w.site.Setup.Instructions()
+		for _, pkg := range w.manifest.Packages {
+			p.Packages = append(p.Packages, packageRow{Entry: pkg, Size: formatSize(pkg.Size)})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pageTemplate.Execute(&buf, p); err != nil {
+		return fmt.Errorf("failed to generate %s: %w", pagePath, err)
+	}
+	if err := utils.WriteFile(pagePath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", pagePath, err)
+	}
+	w.pages++
+
+	for _, sub := range subdirs {
+		if err := w.write(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatSize returns size in binary units, empty when unknown
+func formatSize(size int64) string {
+	if size <= 0 {
+		return ""
+	}
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+var pageTemplate = template.Must(template.New(PageName).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+` + marker + `
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} - {{.Path}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em auto; max-width: 60em; padding: 0 1em; color: #222; }
+h1 { font-size: 1.5em; } h1 small { color: #666; font-weight: normal; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #eee; }
+th { border-bottom: 2px solid #ccc; }
+td.size { text-align: right; white-space: nowrap; }
+.deprecated { color: #a33; }
+pre { background: #f5f5f5; padding: 0.8em; overflow-x: auto; }
+a { color: #0b5cad; text-decoration: none; } a:hover { text-decoration: underline; }
+footer { color: #666; font-size: 0.85em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}} <small>{{.Path}}</small></h1>
+{{- if .Install}}
+<h2>Installation</h2>
+{{- range .Install}}
+<h3>{{.Client}}</h3>
+<pre>{{range .Commands}}{{.}}
+{{end}}</pre>
+{{- end}}
+{{- end}}
+{{- if .Packages}}
+<h2>Packages</h2>
+<table>
+<tr><th>Name</th><th>Version</th><th>Architecture</th><th>Type</th><th>Size</th><th>Note</th></tr>
+{{- range .Packages}}
+<tr><td>{{if .Path}}<a href="{{.Path}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}</td><td>{{.Version}}</td><td>{{.Architecture}}</td><td>{{.Type}}</td><td class="size">{{.Size}}</td><td class="deprecated">{{.Deprecated}}</td></tr>
+{{- end}}
+</table>
+<h2>Files</h2>
+{{- end}}
+<table>
+<tr><th>Name</th><th>Package</th><th>Version</th><th>Architecture</th><th>Size</th></tr>
+{{- if not .Root}}
+<tr><td><a href="../">../</a></td><td></td><td></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Entries}}
+{{- if .Dir}}
+<tr><td><a href="{{.Name}}/">{{.Name}}/</a></td><td></td><td></td><td></td><td></td></tr>
+{{- else}}
+<tr><td><a href="{{.Name}}">{{.Name}}</a></td>{{with .Package}}<td>{{.Name}}{{if .Deprecated}} <span class="deprecated" title="{{.Deprecated}}">(deprecated)</span>{{end}}</td><td>{{.Version}}</td><td>{{.Architecture}}</td>{{else}}<td></td><td></td><td></td>{{end}}<td class="size">{{.Size}}</td></tr>
+{{- end}}
+{{- end}}
+</table>
+<footer>Updated {{.Updated.Format "2006-01-02 15:04 UTC"}}</footer>
+</body>
+</html>
+`))
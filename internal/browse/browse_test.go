@@ -0,0 +1,122 @@
+package browse
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ralt/repogen/internal/clientsetup"
+	"github.com/ralt/repogen/internal/manifest"
+	"github.com/ralt/repogen/internal/snapshot"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readPage(t *testing.T, dir string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, PageName))
+	if err != nil {
+		t.Fatalf("No page in %s: %v", dir, err)
+	}
+	return string(data)
+}
+
+func TestWrite(t *testing.T) {
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{
+		"x86_64/APKINDEX.tar.gz":      "index",
+		"x86_64/hello-1.0-r0.apk":     "package",
+		"simple/index.html":           "<html>PyPI index</html>",
+		"simple/hello/index.html":     "<html>PyPI project</html>",
+		"snapshots/v1/x86_64/.keep":   "",
+		"install.sh":                  "#!/bin/sh",
+		"repositories":                "https://example.com/repo\n",
+		".repogen/settings.yaml":      "",
+		"x86_64/<script>alert(1).apk": "",
+	})
+	entries := []manifest.Entry{{Name: "hello", Version: "1.0-r0", Architecture: "x86_64", Path: "x86_64/hello-1.0-r0.apk", Size: 2048, Deprecated: "deprecated, replaced by hello2"}}
+	if err := manifest.Record(repo, "apk", entries, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	pages, err := Write(repo, Site{
+		Title:   "Example",
+		Setup:   clientsetup.Setup{BaseURL: "https://example.com/repo", APKRepositories: "repositories"},
+		Updated: time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if pages != 2 {
+		t.Errorf("Wrote %d pages, expected the root and x86_64", pages)
+	}
+
+	root := readPage(t, repo)
+	for _, expected := range []string{
+		"<title>Example - /</title>",
+		"curl -fsSL https://example.com/repo/install.sh | sudo sh",
+		`<a href="x86_64/hello-1.0-r0.apk">hello</a>`,
+		`<td class="deprecated">deprecated, replaced by hello2</td>`,
+		`<a href="x86_64/">x86_64/</a>`,
+		`<a href="snapshots/">snapshots/</a>`,
+		"Updated 2024-01-02 03:04 UTC",
+	} {
+		if !strings.Contains(root, expected) {
+			t.Errorf("Root page lacks %q:\n%s", expected, root)
+		}
+	}
+	if strings.Contains(root, ".repogen") {
+		t.Errorf("Root page lists hidden files:\n%s", root)
+	}
+
+	arch := readPage(t, filepath.Join(repo, "x86_64"))
+	for _, expected := range []string{
+		`<a href="../">../</a>`,
+		`<td>hello <span class="deprecated" title="deprecated, replaced by hello2">(deprecated)</span></td><td>1.0-r0</td><td>x86_64</td><td class="size">7 B</td>`,
+		"&lt;script&gt;alert(1).apk",
+	} {
+		if !strings.Contains(arch, expected) {
+			t.Errorf("x86_64 page lacks %q:\n%s", expected, arch)
+		}
+	}
+	if strings.Contains(arch, "Installation") {
+		t.Errorf("Install instructions outside the root page:\n%s", arch)
+	}
+
+	// The pages of package formats and snapshots are left alone
+	if page := readPage(t, filepath.Join(repo, "simple")); page != "<html>PyPI index</html>" {
+		t.Errorf("PyPI index replaced by:\n%s", page)
+	}
+	if _, err := os.Stat(filepath.Join(repo, snapshot.Dir, "v1", PageName)); err == nil {
+		t.Error("Snapshot was browsed")
+	}
+
+	// Pages are rewritten by later runs
+	if _, err := Write(repo, Site{Title: "Renamed"}); err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	if page := readPage(t, repo); !strings.Contains(page, "<title>Renamed - /</title>") || strings.Contains(page, "Installation") {
+		t.Errorf("Root page not rewritten:\n%s", page)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for size, expected := range map[int64]string{0: "", 512: "512 B", 2048: "2.0 KiB", 5 << 20: "5.0 MiB"} {
+		if got := formatSize(size); got != expected {
+			t.Errorf("formatSize(%d) = %q, expected %q", size, got, expected)
+		}
+	}
+}
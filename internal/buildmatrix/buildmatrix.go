@@ -0,0 +1,346 @@
+package buildmatrix
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ralt/repogen/internal/packager"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats are the package formats binaries can be packaged as
+var Formats = []string{"deb", "rpm", "apk", "pacman"}
+
+// archNames maps Go architectures (with their GOARM variant) to the
+// architecture names of each format
+var archNames = map[string]map[string]string{
+	"amd64":   {"deb": "amd64", "rpm": "x86_64", "apk": "x86_64", "pacman": "x86_64"},
+	"arm64":   {"deb": "arm64", "rpm": "aarch64", "apk": "aarch64", "pacman": "aarch64"},
+	"386":     {"deb": "i386", "rpm": "i686", "apk": "x86", "pacman": "i686"},
+	"arm/7":   {"deb": "armhf", "rpm": "armv7hl", "apk": "armv7", "pacman": "armv7h"},
+	"arm/6":   {"deb": "armel", "rpm": "armv6hl", "apk": "armhf", "pacman": "armv6h"},
+	"ppc64le": {"deb": "ppc64el", "rpm": "ppc64le", "apk": "ppc64le", "pacman": "powerpc64le"},
+	"s390x":   {"deb": "s390x", "rpm": "s390x", "apk": "s390x", "pacman": "s390x"},
+	"riscv64": {"deb": "riscv64", "rpm": "riscv64", "apk": "riscv64", "pacman": "riscv64"},
+}
+
+// Matrix describes binaries built for several platforms (like a
+// goreleaser build), packaged as every format for every target
+type Matrix struct {
+	Name        string                    `yaml:"name"`
+	Version     string                    `yaml:"version"` // A leading v, as in tags, is dropped
+	Release     string                    `yaml:"release"` // Package release, the format's default when empty
+	Maintainer  string                    `yaml:"maintainer"`
+	Description string                    `yaml:"description"` // First line is the summary
+	Homepage    string                    `yaml:"homepage"`
+	License     string                    `yaml:"license"`
+	Depends     []string                  `yaml:"depends"`
+	Formats     []string                  `yaml:"formats"`
+	Targets     []string                  `yaml:"targets"` // os/arch[/variant], e.g. linux/amd64 or linux/arm/7
+	Binaries    []Binary                  `yaml:"binaries"`
+	Contents    []Content                 `yaml:"contents"`
+	Overrides   map[string]FormatOverride `yaml:"overrides"` // Keyed by format
+
+	dir string // Directory of the matrix file, relative paths start from
+}
+
+// Binary is a program built for every target
+type Binary struct {
+	Path string `yaml:"path"` // Template expanded with the Target, e.g. dist/app_{{.Os}}_{{.Arch}}/app
+	Dst  string `yaml:"dst"`  // Installed path, /usr/bin/<file name> by default
+}
+
+// Content is a file installed by the packages of every target
+type Content struct {
+	Src  string `yaml:"src"`
+	Dst  string `yaml:"dst"`
+	Mode string `yaml:"mode"` // Octal, the mode of Src by default
+}
+
+// FormatOverride replaces settings for one format, whose packages often
+// name dependencies differently
+type FormatOverride struct {
+	Depends []string `yaml:"depends"`
+}
+
+// Target is a platform binaries are built for
+type Target struct {
+	Os      string
+	Arch    string
+	Variant string // GOARM for arm, GOAMD64 for amd64
+}
+
+// String returns the target as os/arch[/variant]
+func (t Target) String() string {
+	if t.Variant == "" {
+		return t.Os + "/" + t.Arch
+	}
+	return t.Os + "/" + t.Arch + "/" + t.Variant
+}
+
+// Load reads a build matrix (YAML or JSON)
+func Load(path string) (*Matrix, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read build matrix: %w", err)
+	}
+
+	var m Matrix
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse build matrix %s: %w", path, err)
+	}
+	m.dir = filepath.Dir(path)
+
+	return &m, nil
+}
+
+// Validate checks the matrix describes packages that can be built
+func (m *Matrix) Validate() error {
+	if m.Name == "" || m.Version == "" {
+		return fmt.Errorf("build matrix needs a name and a version")
+	}
+	if m.Maintainer == "" {
+		return fmt.Errorf("build matrix needs a maintainer")
+	}
+	if len(m.Formats) == 0 || len(m.Targets) == 0 || len(m.Binaries) == 0 {
+		return fmt.Errorf("build matrix needs formats, targets and binaries")
+	}
+	for _, format := range m.Formats {
+		if !isFormat(format) {
+			return fmt.Errorf("unsupported format %q, expected one of %s", format, strings.Join(Formats, ", "))
+		}
+	}
+	for format := range m.Overrides {
+		if !isFormat(format) {
+			return fmt.Errorf("override of unsupported format %q", format)
+		}
+	}
+	for _, target := range m.Targets {
+		if _, err := parseTarget(target); err != nil {
+			return err
+		}
+	}
+	for _, binary := range m.Binaries {
+		if _, err := template.New("path").Parse(binary.Path); err != nil {
+			return fmt.Errorf("invalid binary path %q: %w", binary.Path, err)
+		}
+	}
+	for _, content := range m.Contents {
+		if content.Src == "" || content.Dst == "" {
+			return fmt.Errorf("contents need a src and a dst")
+		}
+		if content.Mode != "" {
+			if _, err := strconv.ParseUint(content.Mode, 8, 32); err != nil {
+				return fmt.Errorf("invalid mode %q of %s", content.Mode, content.Src)
+			}
+		}
+	}
+	return nil
+}
+
+// Build packages the binaries of every target as every format into a
+// directory of dir per target, apk file names lacking the architecture,
+// and returns the paths of the packages
+func (m *Matrix) Build(dir string) ([]string, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	version := m.Version
+	if len(version) > 1 && version[0] == 'v' && version[1] >= '0' && version[1] <= '9' {
+		version = version[1:]
+	}
+	summary, description, _ := strings.Cut(strings.TrimSpace(m.Description), "\n")
+	if summary == "" {
+		summary = m.Name
+	}
+
+	// Packages are dated SOURCE_DATE_EPOCH, or when their newest file was
+	// modified, so that unchanged binaries give identical packages
+	var epoch time.Time
+	if s := os.Getenv("SOURCE_DATE_EPOCH"); s != "" {
+		seconds, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q", s)
+		}
+		epoch = time.Unix(seconds, 0)
+	}
+
+	contents, contentsTime, err := m.contentFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, spec := range m.Targets {
+		target, _ := parseTarget(spec)
+		files, buildTime, err := m.binaryFiles(target)
+		if err != nil {
+			return nil, err
+		}
+		if contentsTime.After(buildTime) {
+			buildTime = contentsTime
+		}
+		if !epoch.IsZero() {
+			buildTime = epoch
+		}
+
+		for _, format := range m.Formats {
+			depends := m.Depends
+			if override, ok := m.Overrides[format]; ok && override.Depends != nil {
+				depends = override.Depends
+			}
+
+			pkgPath, err := packager.Build(format, packager.Package{
+				Name:        m.Name,
+				Version:     version,
+				Release:     m.Release,
+				Arch:        archName(target, format),
+				Maintainer:  m.Maintainer,
+				Summary:     summary,
+				Description: strings.TrimSpace(description),
+				Homepage:    m.Homepage,
+				License:     m.License,
+				Depends:     depends,
+				Files:       append(append([]packager.File(nil), files...), contents...),
+				BuildTime:   buildTime,
+			}, filepath.Join(dir, strings.ReplaceAll(target.String(), "/", "_")))
+			if err != nil {
+				return nil, fmt.Errorf("failed to build the %s package for %s: %w", format, target, err)
+			}
+			paths = append(paths, pkgPath)
+		}
+	}
+
+	return paths, nil
+}
+
+// binaryFiles reads the binaries of a target, and returns the time the
+// newest one was modified
+func (m *Matrix) binaryFiles(target Target) ([]packager.File, time.Time, error) {
+	var files []packager.File
+	var newest time.Time
+	for _, binary := range m.Binaries {
+		tmpl, err := template.New("path").Option("missingkey=error").Parse(binary.Path)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, target); err != nil {
+			return nil, time.Time{}, fmt.Errorf("failed to expand %q: %w", binary.Path, err)
+		}
+
+		src := m.resolve(b.String())
+		data, info, err := readFile(src)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("binary for %s: %w", target, err)
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+
+		dst := binary.Dst
+		if dst == "" {
+			dst = path.Join("/usr/bin", filepath.Base(src))
+		}
+		files = append(files, packager.File{Path: dst, Mode: 0755, Data: data})
+	}
+	return files, newest, nil
+}
+
+// contentFiles reads the files installed for every target, and returns the
+// time the newest one was modified
+func (m *Matrix) contentFiles() ([]packager.File, time.Time, error) {
+	var files []packager.File
+	var newest time.Time
+	for _, content := range m.Contents {
+		data, info, err := readFile(m.resolve(content.Src))
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+
+		mode := int64(info.Mode().Perm())
+		if content.Mode != "" {
+			parsed, _ := strconv.ParseUint(content.Mode, 8, 32)
+			mode = int64(parsed)
+		}
+		files = append(files, packager.File{Path: content.Dst, Mode: mode, Data: data})
+	}
+	return files, newest, nil
+}
+
+// resolve returns a path relative to the matrix file
+func (m *Matrix) resolve(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(m.dir, p)
+}
+
+// readFile reads a regular file
+func readFile(path string) ([]byte, os.FileInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	data, err := os.ReadFile(path)
+	return data, info, err
+}
+
+// parseTarget parses os/arch[/variant]: only Linux targets can be
+// packaged, and arm ones need their GOARM variant
+func parseTarget(spec string) (Target, error) {
+	parts := strings.Split(spec, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Target{}, fmt.Errorf("invalid target %q, expected os/arch[/variant]", spec)
+	}
+	target := Target{Os: parts[0], Arch: parts[1]}
+	if len(parts) == 3 {
+		target.Variant = parts[2]
+	}
+
+	if target.Os != "linux" {
+		return Target{}, fmt.Errorf("target %s is not a Linux target", spec)
+	}
+	if _, ok := archNames[archKey(target)]; !ok {
+		return Target{}, fmt.Errorf("unsupported architecture in target %s", spec)
+	}
+	return target, nil
+}
+
+// archKey returns the archNames key of a target: arm variants are distinct
+// architectures, amd64 ones (v1 to v4) are not
+func archKey(target Target) string {
+	if target.Arch == "arm" {
+		return "arm/" + target.Variant
+	}
+	return target.Arch
+}
+
+// archName returns the architecture name of a target in a format
+func archName(target Target, format string) string {
+	return archNames[archKey(target)][format]
+}
+
+// isFormat reports whether binaries can be packaged as format
+func isFormat(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
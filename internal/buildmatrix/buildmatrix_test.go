@@ -0,0 +1,204 @@
+package buildmatrix
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/generator/apk"
+	"github.com/ralt/repogen/internal/generator/deb"
+	"github.com/ralt/repogen/internal/generator/rpm"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sassoftware/go-rpmutils"
+)
+
+const helloMatrix = `name: hello
+version: v1.2.3
+maintainer: Jane Doe <jane@example.com>
+description: |
+  Says hello
+  A friendly program.
+license: MIT
+depends: [libc6]
+overrides:
+  rpm:
+    depends: [glibc]
+  apk:
+    depends: []
+formats: [deb, rpm, apk]
+targets: [linux/amd64/v1, linux/arm/7]
+binaries:
+  - path: "dist/hello_{{.Os}}_{{.Arch}}_{{.Variant}}/hello"
+contents:
+  - src: hello.conf
+    dst: /etc/hello/hello.conf
+    mode: "0640"
+`
+
+// writeMatrix writes the matrix, its binaries and contents into a
+// directory
+func writeMatrix(t *testing.T, matrix string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for file, data := range map[string]string{
+		"matrix.yaml":                     matrix,
+		"dist/hello_linux_amd64_v1/hello": "\x7fELF amd64",
+		"dist/hello_linux_arm_7/hello":    "\x7fELF arm",
+		"hello.conf":                      "greeting = hello\n",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "matrix.yaml")
+}
+
+func TestBuild(t *testing.T) {
+	m, err := Load(writeMatrix(t, helloMatrix))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	paths, err := m.Build(t.TempDir())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	var names []string
+	for _, path := range paths {
+		names = append(names, filepath.ToSlash(filepath.Join(filepath.Base(filepath.Dir(path)), filepath.Base(path))))
+	}
+	sort.Strings(names)
+	expected := []string{
+		"linux_amd64_v1/hello-1.2.3-1.x86_64.rpm",
+		"linux_amd64_v1/hello-1.2.3-r0.apk",
+		"linux_amd64_v1/hello_1.2.3_amd64.deb",
+		"linux_arm_7/hello-1.2.3-1.armv7hl.rpm",
+		"linux_arm_7/hello-1.2.3-r0.apk",
+		"linux_arm_7/hello_1.2.3_armhf.deb",
+	}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected packages %v", names)
+	}
+
+	for _, path := range paths {
+		var pkg *models.Package
+		var err error
+		var depends string
+		switch filepath.Ext(path) {
+		case ".deb":
+			pkg, err = deb.ParsePackage(path)
+			depends = "libc6"
+		case ".rpm":
+			pkg, err = rpm.ParsePackage(path)
+			depends = "glibc,rpmlib(CompressedFileNames),rpmlib(FileDigests),rpmlib(PayloadFilesHavePrefix)"
+		case ".apk":
+			pkg, err = apk.ParsePackage(path)
+		}
+		if err != nil {
+			t.Fatalf("parsing %s failed: %v", path, err)
+		}
+		if pkg.Name != "hello" || pkg.Description == "" || strings.Join(pkg.Dependencies, ",") != depends {
+			t.Errorf("unexpected package %s: %+v", path, pkg)
+		}
+		if arm := strings.Contains(path, "arm"); arm != strings.Contains(pkg.Architecture, "arm") {
+			t.Errorf("unexpected package %s: %+v", path, pkg)
+		}
+	}
+}
+
+func TestBuildRpmPayload(t *testing.T) {
+	m, err := Load(writeMatrix(t, strings.Replace(helloMatrix, "formats: [deb, rpm, apk]", "formats: [rpm]", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := m.Build(t.TempDir())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	f, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, _, err := rpmutils.Verify(f, nil); err != nil {
+		t.Fatalf("digests don't verify: %v", err)
+	}
+	f.Seek(0, 0)
+	r, err := rpmutils.ReadRpm(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := t.TempDir()
+	if err := r.ExpandPayload(dest); err != nil {
+		t.Fatalf("ExpandPayload failed: %v", err)
+	}
+	for file, data := range map[string]string{
+		"usr/bin/hello":        "\x7fELF amd64",
+		"etc/hello/hello.conf": "greeting = hello\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(file)))
+		if err != nil || !bytes.Equal(got, []byte(data)) {
+			t.Errorf("unexpected %s %q: %v", file, got, err)
+		}
+	}
+}
+
+func TestBuildReproducible(t *testing.T) {
+	m, err := Load(writeMatrix(t, helloMatrix))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checksums := func() []string {
+		paths, err := m.Build(t.TempDir())
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		var sums []string
+		for _, path := range paths {
+			c, err := utils.CalculateChecksums(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sums = append(sums, c.SHA256)
+		}
+		return sums
+	}
+	if first, second := checksums(), checksums(); strings.Join(first, ",") != strings.Join(second, ",") {
+		t.Error("rebuilding unchanged binaries gave different packages")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]string{
+		"unknown format":  strings.Replace(helloMatrix, "formats: [deb, rpm, apk]", "formats: [msi]", 1),
+		"darwin target":   strings.Replace(helloMatrix, "linux/arm/7", "darwin/arm64", 1),
+		"arm variant":     strings.Replace(helloMatrix, "linux/arm/7", "linux/arm", 1),
+		"no maintainer":   strings.Replace(helloMatrix, "maintainer: Jane Doe <jane@example.com>\n", "", 1),
+		"invalid mode":    strings.Replace(helloMatrix, `mode: "0640"`, `mode: "rw"`, 1),
+		"missing binary":  strings.Replace(helloMatrix, "hello_{{.Os}}", "bye_{{.Os}}", 1),
+		"unknown field":   strings.Replace(helloMatrix, "{{.Variant}}", "{{.Goarm}}", 1),
+		"override format": strings.Replace(helloMatrix, "overrides:\n", "overrides:\n  msi:\n    depends: []\n", 1),
+	}
+	for name, matrix := range tests {
+		t.Run(name, func(t *testing.T) {
+			m, err := Load(writeMatrix(t, matrix))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := m.Build(t.TempDir()); err == nil {
+				t.Error("Build accepted an invalid matrix")
+			}
+		})
+	}
+}
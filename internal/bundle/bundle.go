@@ -0,0 +1,359 @@
+package bundle
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// manifestName is the first entry of every bundle
+const manifestName = "manifest.json"
+
+// formatVersion is bumped on incompatible changes to the bundle layout
+const formatVersion = 1
+
+// Manifest lists every file of a bundle with its checksum
+type Manifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Files   []File    `json:"files"`
+}
+
+// File is a file of a bundle, relative to the repository root
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Mode   uint32 `json:"mode"`
+}
+
+// Export packs repoDir, plus extra files (e.g. public keys) placed at the
+// root of the repository, into a zstd-compressed tarball at output.
+// Bundles only hold regular files: symlinks are bundled as copies of their
+// target, and dangling ones are left out
+func Export(repoDir string, extra []string, output string) (*Manifest, error) {
+	sources := make(map[string]string) // bundle path -> file on disk
+
+	// Never bundle a previous export written into the repository itself
+	absOutput, err := filepath.Abs(output)
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.Walk(repoDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(repoDir, p)
+		if err != nil {
+			return err
+		}
+		if utils.IsInternalPath(filepath.ToSlash(rel)) {
+			return nil
+		}
+		// Symlinked files (e.g. Pacman's myrepo.db) are bundled as copies, so
+		// imports never create links that could point outside dest
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(p); err == nil {
+				info = target
+			}
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if abs, err := filepath.Abs(p); err == nil && abs == absOutput {
+			return nil
+		}
+		sources[filepath.ToSlash(rel)] = p
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan repository: %w", err)
+	}
+
+	for _, p := range extra {
+		name := filepath.Base(p)
+		if _, ok := sources[name]; ok {
+			return nil, fmt.Errorf("%s is already part of the repository", name)
+		}
+		sources[name] = p
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("repository %s is empty", repoDir)
+	}
+
+	// Checksum everything up front so the manifest can lead the archive
+	// and imports can verify files as they stream past
+	manifest := &Manifest{Version: formatVersion, Created: time.Now().UTC()}
+	for name, p := range sources {
+		f, err := checksumFile(name, p)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, f)
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+
+	if err := writeBundle(output, manifest, sources); err != nil {
+		os.Remove(output)
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func checksumFile(name, p string) (File, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return File{}, err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return File{}, fmt.Errorf("failed to read %s: %w", p, err)
+	}
+
+	return File{
+		Path:   name,
+		Size:   info.Size(),
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+		Mode:   uint32(info.Mode().Perm()),
+	}, nil
+}
+
+func writeBundle(output string, manifest *Manifest, sources map[string]string) error {
+	out, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer out.Close()
+
+	zw, err := zstd.NewWriter(out)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    manifestName,
+		Mode:    0644,
+		Size:    int64(len(manifestData)),
+		ModTime: manifest.Created,
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifestData); err != nil {
+		return err
+	}
+
+	for _, file := range manifest.Files {
+		if err := addFile(tw, file, sources[file.Path], manifest.Created); err != nil {
+			return err
+		}
+		logrus.Debugf("Bundled %s", file.Path)
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func addFile(tw *tar.Writer, file File, src string, modTime time.Time) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    file.Path,
+		Mode:    int64(file.Mode),
+		Size:    file.Size,
+		ModTime: modTime,
+	}); err != nil {
+		return err
+	}
+
+	// A file changing size while being bundled fails here rather than
+	// producing a bundle that doesn't match its manifest
+	if _, err := io.CopyN(tw, f, file.Size); err != nil {
+		return fmt.Errorf("failed to bundle %s: %w", src, err)
+	}
+	return nil
+}
+
+// Import verifies a bundle against its manifest while unpacking it into a
+// staging directory next to dest, and only then moves it into place.
+// An existing, non-empty dest is replaced only when replace is set
+func Import(bundlePath, dest string, replace bool) (*Manifest, error) {
+	if entries, err := os.ReadDir(dest); err == nil && len(entries) > 0 && !replace {
+		return nil, fmt.Errorf("%s is not empty", dest)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filepath.Clean(dest)), 0755); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(filepath.Clean(dest)), "."+filepath.Base(dest)+".import-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	manifest, err := unpack(bundlePath, staging)
+	if err != nil {
+		return nil, err
+	}
+
+	// Swap the verified tree into place
+	old := ""
+	if _, err := os.Stat(dest); err == nil {
+		old = staging + ".old"
+		if err := os.Rename(dest, old); err != nil {
+			return nil, fmt.Errorf("failed to move %s aside: %w", dest, err)
+		}
+	}
+	if err := os.Rename(staging, dest); err != nil {
+		if old != "" {
+			os.Rename(old, dest)
+		}
+		return nil, fmt.Errorf("failed to move bundle into %s: %w", dest, err)
+	}
+	if old != "" {
+		if err := os.RemoveAll(old); err != nil {
+			logrus.Warnf("Failed to remove previous repository %s: %v", old, err)
+		}
+	}
+
+	return manifest, nil
+}
+
+func unpack(bundlePath, dir string) (*Manifest, error) {
+	in, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer in.Close()
+
+	zr, err := zstd.NewReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	if hdr.Name != manifestName {
+		return nil, fmt.Errorf("invalid bundle: %s must be the first entry", manifestName)
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	if manifest.Version != formatVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", manifest.Version)
+	}
+
+	expected := make(map[string]File, len(manifest.Files))
+	for _, f := range manifest.Files {
+		expected[f.Path] = f
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+
+		file, ok := expected[hdr.Name]
+		if !ok {
+			return nil, fmt.Errorf("bundle entry %s is not listed in the manifest", hdr.Name)
+		}
+		delete(expected, hdr.Name)
+
+		if err := extractFile(tr, dir, file); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(expected) > 0 {
+		var missing []string
+		for name := range expected {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("bundle is missing %d file(s) listed in the manifest: %s", len(missing), strings.Join(missing, ", "))
+	}
+
+	return &manifest, nil
+}
+
+func extractFile(r io.Reader, dir string, file File) error {
+	// Reject entries escaping the destination
+	clean := path.Clean(file.Path)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("invalid path in bundle: %s", file.Path)
+	}
+
+	dst := filepath.Join(dir, filepath.FromSlash(clean))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(file.Mode).Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), r)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", file.Path, err)
+	}
+
+	if n != file.Size || hex.EncodeToString(h.Sum(nil)) != file.SHA256 {
+		return fmt.Errorf("checksum mismatch for %s: bundle is corrupted", file.Path)
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,231 @@
+package bundle
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func writeFile(t *testing.T, dir, rel, content string) {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// entry is a file of a hand-made bundle
+type entry struct {
+	name, content string
+}
+
+// writeTestBundle writes a bundle whose manifest lists listed, followed by
+// the entries
+func writeTestBundle(t *testing.T, listed, entries []entry) string {
+	t.Helper()
+	manifest := Manifest{Version: formatVersion, Created: time.Now().UTC()}
+	for _, e := range listed {
+		sum := sha256.Sum256([]byte(e.content))
+		manifest.Files = append(manifest.Files, File{Path: e.name, Size: int64(len(e.content)), SHA256: hex.EncodeToString(sum[:]), Mode: 0644})
+	}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(t.TempDir(), "bundle.tar.zst")
+	out, err := os.Create(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	zw, err := zstd.NewWriter(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(zw)
+	for _, e := range append([]entry{{manifestName, string(manifestData)}}, entries...) {
+		if err := tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	repo := t.TempDir()
+	writeFile(t, repo, "dists/stable/Release", "Release")
+	writeFile(t, repo, "pool/main/h/hello/hello_1.0_amd64.deb", "hello")
+	writeFile(t, repo, "x86_64/myrepo.db.tar.gz", "db")
+	if err := os.Symlink("myrepo.db.tar.gz", filepath.Join(repo, "x86_64", "myrepo.db")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing", filepath.Join(repo, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, repo, ".repogen.lock", "1234")
+	writeFile(t, repo, ".repogen/status.json", "{}")
+	writeFile(t, repo, ".repogen/packages.json", `{"packages":[]}`)
+	key := filepath.Join(t.TempDir(), "public.asc")
+	writeFile(t, filepath.Dir(key), "public.asc", "key")
+
+	output := filepath.Join(t.TempDir(), "repo.tar.zst")
+	exported, err := Export(repo, []string{key}, output)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "repo")
+	imported, err := Import(output, dest, false)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if len(imported.Files) != len(exported.Files) {
+		t.Errorf("Imported %d files, exported %d", len(imported.Files), len(exported.Files))
+	}
+
+	want := map[string]string{
+		"dists/stable/Release":                  "Release",
+		"pool/main/h/hello/hello_1.0_amd64.deb": "hello",
+		"x86_64/myrepo.db.tar.gz":               "db",
+		"x86_64/myrepo.db":                      "db",
+		".repogen/packages.json":                `{"packages":[]}`,
+		"public.asc":                            "key",
+	}
+	got := make(map[string]string)
+	filepath.Walk(dest, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			t.Errorf("%s is not a regular file", p)
+		}
+		data, _ := os.ReadFile(p)
+		rel, _ := filepath.Rel(dest, p)
+		got[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if len(got) != len(want) {
+		t.Errorf("Imported %v, want %v", got, want)
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("%s = %q, want %q", name, got[name], content)
+		}
+	}
+
+	// An existing repository is only replaced on request
+	if _, err := Import(output, dest, false); err == nil || !strings.Contains(err.Error(), "is not empty") {
+		t.Errorf("Import into a non-empty directory = %v", err)
+	}
+	if _, err := Import(output, dest, true); err != nil {
+		t.Errorf("Import with replace failed: %v", err)
+	}
+}
+
+func TestImportRejectsInvalidBundles(t *testing.T) {
+	tests := []struct {
+		name    string
+		listed  []entry
+		entries []entry
+		wantErr string
+	}{
+		{
+			name:    "tampered entry",
+			listed:  []entry{{"Release", "signed"}, {"Packages", "hello"}},
+			entries: []entry{{"Release", "signed"}, {"Packages", "evil!"}},
+			wantErr: "checksum mismatch for Packages",
+		},
+		{
+			name:    "truncated entry",
+			listed:  []entry{{"Packages", "hello"}},
+			entries: []entry{{"Packages", "hell"}},
+			wantErr: "checksum mismatch for Packages",
+		},
+		{
+			name:    "entry missing from the manifest",
+			listed:  []entry{{"Release", "signed"}},
+			entries: []entry{{"Release", "signed"}, {"Packages", "hello"}},
+			wantErr: "bundle entry Packages is not listed in the manifest",
+		},
+		{
+			name:    "manifest entry missing from the archive",
+			listed:  []entry{{"Release", "signed"}, {"Packages", "hello"}, {"InRelease", "signed"}},
+			entries: []entry{{"Release", "signed"}},
+			wantErr: "bundle is missing 2 file(s) listed in the manifest: InRelease, Packages",
+		},
+		{
+			name:    "traversal",
+			listed:  []entry{{"../evil", "evil"}},
+			entries: []entry{{"../evil", "evil"}},
+			wantErr: "invalid path in bundle: ../evil",
+		},
+		{
+			name:    "nested traversal",
+			listed:  []entry{{"pool/../../evil", "evil"}},
+			entries: []entry{{"pool/../../evil", "evil"}},
+			wantErr: "invalid path in bundle: pool/../../evil",
+		},
+		{
+			name:    "absolute path",
+			listed:  []entry{{"/tmp/evil", "evil"}},
+			entries: []entry{{"/tmp/evil", "evil"}},
+			wantErr: "invalid path in bundle: /tmp/evil",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundlePath := writeTestBundle(t, tt.listed, tt.entries)
+			parent := t.TempDir()
+			dest := filepath.Join(parent, "repo")
+
+			_, err := Import(bundlePath, dest, false)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Import = %v, want %q", err, tt.wantErr)
+			}
+
+			// Nothing reaches dest, nor escapes the staging directory
+			entries, _ := os.ReadDir(parent)
+			if len(entries) != 0 {
+				t.Errorf("Import left %v in %s", entries, parent)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(parent), "evil")); !os.IsNotExist(err) {
+				t.Errorf("Import wrote outside dest: %v", err)
+			}
+		})
+	}
+}
+
+func TestImportKeepsExistingRepositoryOnFailure(t *testing.T) {
+	dest := t.TempDir()
+	writeFile(t, dest, "Release", "published")
+	bundlePath := writeTestBundle(t, []entry{{"Release", "new"}}, []entry{{"Release", "tampered"}})
+
+	if _, err := Import(bundlePath, dest, true); err == nil {
+		t.Fatal("Import of a tampered bundle succeeded")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "Release")); string(data) != "published" {
+		t.Errorf("Release = %q, want the published one", data)
+	}
+}
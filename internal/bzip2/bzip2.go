@@ -0,0 +1,397 @@
+// Package bzip2 writes the bzip2 format, which Go's standard library can
+// only read
+package bzip2
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// maxBlock is the largest block of the 900k block size, after the initial
+// run-length encoding
+const maxBlock = 9*100000 - 19
+
+// maxCodeLength is the longest Huffman code the encoder produces
+const maxCodeLength = 17
+
+// Compress compresses data as a bzip2 stream
+func Compress(data []byte) []byte {
+	w := &bitWriter{}
+	w.writeBytes([]byte("BZh9"))
+
+	var combinedCRC uint32
+	for len(data) > 0 {
+		block, n := runLengthEncode(data)
+		blockCRC := crc(data[:n])
+		combinedCRC = (combinedCRC<<1 | combinedCRC>>31) ^ blockCRC
+		writeBlock(w, block, blockCRC)
+		data = data[n:]
+	}
+
+	w.writeBits(48, 0x177245385090)
+	w.writeBits(32, uint64(combinedCRC))
+	return w.bytes()
+}
+
+// runLengthEncode applies the initial run-length encoding, runs of 4 to
+// 255 bytes becoming 4 bytes and a count, to as much of data as fits in a
+// block. It returns the block and the number of bytes of data it holds
+func runLengthEncode(data []byte) ([]byte, int) {
+	var block []byte
+	i := 0
+	for i < len(data) && len(block)+5 <= maxBlock {
+		run := 1
+		for i+run < len(data) && run < 255 && data[i+run] == data[i] {
+			run++
+		}
+		if run >= 4 {
+			block = append(block, data[i], data[i], data[i], data[i], byte(run-4))
+		} else {
+			for j := 0; j < run; j++ {
+				block = append(block, data[i])
+			}
+		}
+		i += run
+	}
+	return block, i
+}
+
+var crcTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		table[i] = c
+	}
+	return table
+}()
+
+// crc returns the big-endian CRC-32 bzip2 uses
+func crc(data []byte) uint32 {
+	c := uint32(0xffffffff)
+	for _, b := range data {
+		c = c<<8 ^ crcTable[byte(c>>24)^b]
+	}
+	return ^c
+}
+
+// writeBlock writes a compressed block: Burrows-Wheeler transform, move to
+// front with runs of zeros encoded as RUNA/RUNB, then Huffman coding
+func writeBlock(w *bitWriter, block []byte, blockCRC uint32) {
+	last, origPtr := transform(block)
+
+	var inUse [256]bool
+	for _, b := range block {
+		inUse[b] = true
+	}
+	var symbols []byte
+	var seq [256]byte
+	for b := 0; b < 256; b++ {
+		if inUse[b] {
+			seq[b] = byte(len(symbols))
+			symbols = append(symbols, byte(b))
+		}
+	}
+
+	codes := moveToFront(last, seq, len(symbols))
+	alphaSize := len(symbols) + 2
+
+	freq := make([]int, alphaSize)
+	for _, c := range codes {
+		freq[c]++
+	}
+	lengths := codeLengths(freq)
+	huffman := canonicalCodes(lengths)
+
+	w.writeBits(48, 0x314159265359)
+	w.writeBits(32, uint64(blockCRC))
+	w.writeBits(1, 0) // Not randomised
+	w.writeBits(24, uint64(origPtr))
+
+	// Symbol map: which of the 16 ranges of 16 bytes are used, then the
+	// bytes used in each
+	var ranges uint64
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				ranges |= 1 << (15 - i)
+				break
+			}
+		}
+	}
+	w.writeBits(16, ranges)
+	for i := 0; i < 16; i++ {
+		if ranges&(1<<(15-i)) == 0 {
+			continue
+		}
+		var used uint64
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				used |= 1 << (15 - j)
+			}
+		}
+		w.writeBits(16, used)
+	}
+
+	// The format requires at least two tables: both are the same, and
+	// every group of 50 symbols selects the first
+	const groups = 2
+	selectors := (len(codes) + 49) / 50
+	w.writeBits(3, groups)
+	w.writeBits(15, uint64(selectors))
+	for i := 0; i < selectors; i++ {
+		w.writeBits(1, 0)
+	}
+	for g := 0; g < groups; g++ {
+		current := lengths[0]
+		w.writeBits(5, uint64(current))
+		for _, length := range lengths {
+			for current < length {
+				w.writeBits(2, 2)
+				current++
+			}
+			for current > length {
+				w.writeBits(2, 3)
+				current--
+			}
+			w.writeBits(1, 0)
+		}
+	}
+
+	for _, c := range codes {
+		w.writeBits(uint(lengths[c]), uint64(huffman[c]))
+	}
+}
+
+// transform returns the last column of the sorted rotations of block, and
+// the row of the block itself
+func transform(block []byte) ([]byte, int) {
+	n := len(block)
+	rotations := make([]int, n)
+	rank := make([]int, n)
+	next := make([]int, n)
+	for i := range rotations {
+		rotations[i] = i
+		rank[i] = int(block[i])
+	}
+
+	// Prefix doubling: sort by the first k bytes, then 2k, until the ranks
+	// are distinct or the rotations are known to repeat
+	for k := 1; ; k *= 2 {
+		less := func(a, b int) bool {
+			if rank[a] != rank[b] {
+				return rank[a] < rank[b]
+			}
+			return rank[(a+k)%n] < rank[(b+k)%n]
+		}
+		sort.Slice(rotations, func(i, j int) bool { return less(rotations[i], rotations[j]) })
+
+		next[rotations[0]] = 0
+		for i := 1; i < n; i++ {
+			next[rotations[i]] = next[rotations[i-1]]
+			if less(rotations[i-1], rotations[i]) {
+				next[rotations[i]]++
+			}
+		}
+		copy(rank, next)
+		if rank[rotations[n-1]] == n-1 || k >= n {
+			break
+		}
+	}
+
+	last := make([]byte, n)
+	origPtr := 0
+	for i, r := range rotations {
+		if r == 0 {
+			origPtr = i
+		}
+		last[i] = block[(r+n-1)%n]
+	}
+	return last, origPtr
+}
+
+// moveToFront encodes the transformed block as symbols: RUNA (0) and RUNB
+// (1) for runs of repeated bytes, the move-to-front position plus one for
+// the others, and the end of block
+func moveToFront(last []byte, seq [256]byte, inUse int) []uint16 {
+	order := make([]byte, inUse)
+	for i := range order {
+		order[i] = byte(i)
+	}
+
+	var codes []uint16
+	zeros := 0
+	flushZeros := func() {
+		// Runs are written in bijective base 2, RUNA counting 1 and RUNB 2
+		for z := zeros - 1; zeros > 0; z = (z - 2) / 2 {
+			codes = append(codes, uint16(z&1))
+			if z < 2 {
+				break
+			}
+		}
+		zeros = 0
+	}
+
+	for _, b := range last {
+		s := seq[b]
+		pos := 0
+		for order[pos] != s {
+			pos++
+		}
+		if pos == 0 {
+			zeros++
+			continue
+		}
+		flushZeros()
+		copy(order[1:pos+1], order[:pos])
+		order[0] = s
+		codes = append(codes, uint16(pos+1))
+	}
+	flushZeros()
+	return append(codes, uint16(inUse+1))
+}
+
+// codeLengths returns Huffman code lengths of at most maxCodeLength bits for
+// every symbol, used or not
+func codeLengths(freq []int) []int {
+	weights := make([]int, len(freq))
+	for i, f := range freq {
+		weights[i] = f
+		if weights[i] == 0 {
+			weights[i] = 1
+		}
+	}
+
+	for {
+		lengths := huffmanLengths(weights)
+		longest := 0
+		for _, l := range lengths {
+			if l > longest {
+				longest = l
+			}
+		}
+		if longest <= maxCodeLength {
+			return lengths
+		}
+		// Flatten the distribution until the tree is shallow enough
+		for i := range weights {
+			weights[i] = 1 + weights[i]/2
+		}
+	}
+}
+
+type node struct {
+	weight, depth int
+	symbol        int
+	left, right   *node
+}
+
+type nodeHeap []*node
+
+func (h nodeHeap) Len() int { return len(h) }
+func (h nodeHeap) Less(i, j int) bool {
+	if h[i].weight != h[j].weight {
+		return h[i].weight < h[j].weight
+	}
+	return h[i].depth < h[j].depth
+}
+func (h nodeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nodeHeap) Push(x interface{}) { *h = append(*h, x.(*node)) }
+func (h *nodeHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// huffmanLengths returns the depth of each symbol in a Huffman tree
+func huffmanLengths(weights []int) []int {
+	h := &nodeHeap{}
+	for i, w := range weights {
+		*h = append(*h, &node{weight: w, symbol: i})
+	}
+	heap.Init(h)
+	for h.Len() > 1 {
+		a := heap.Pop(h).(*node)
+		b := heap.Pop(h).(*node)
+		depth := a.depth
+		if b.depth > depth {
+			depth = b.depth
+		}
+		heap.Push(h, &node{weight: a.weight + b.weight, depth: depth + 1, symbol: -1, left: a, right: b})
+	}
+
+	lengths := make([]int, len(weights))
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		if n.left == nil {
+			lengths[n.symbol] = depth
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(heap.Pop(h).(*node), 0)
+	return lengths
+}
+
+// canonicalCodes assigns codes in order of length, then symbol, as the
+// decoder does
+func canonicalCodes(lengths []int) []uint32 {
+	codes := make([]uint32, len(lengths))
+	var code uint32
+	for length := 1; length <= maxCodeLength; length++ {
+		for symbol, l := range lengths {
+			if l == length {
+				codes[symbol] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// bitWriter accumulates bits, most significant first
+type bitWriter struct {
+	out   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (w *bitWriter) writeBits(n uint, value uint64) {
+	for n > 0 {
+		take := n
+		if take > 32 {
+			take = 32
+		}
+		n -= take
+		w.acc = w.acc<<take | (value>>n)&(1<<take-1)
+		w.nbits += take
+		for w.nbits >= 8 {
+			w.nbits -= 8
+			w.out = append(w.out, byte(w.acc>>w.nbits))
+		}
+	}
+}
+
+func (w *bitWriter) writeBytes(data []byte) {
+	for _, b := range data {
+		w.writeBits(8, uint64(b))
+	}
+}
+
+// bytes returns the bits written, padded with zeros to a whole byte
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.out = append(w.out, byte(w.acc<<(8-w.nbits)))
+		w.nbits = 0
+	}
+	return w.out
+}
@@ -0,0 +1,38 @@
+package bzip2
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	random := make([]byte, 200000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := map[string][]byte{
+		"empty":    nil,
+		"byte":     []byte("a"),
+		"text":     []byte("<?xml version=\"1.0\"?>\n<metadata packages=\"1\"></metadata>\n"),
+		"runs":     bytes.Repeat([]byte("aaaaaaaaab"), 1000),
+		"periodic": bytes.Repeat([]byte("ab"), 5000),
+		"zeros":    make([]byte, 100000),
+		"random":   random,
+		"blocks":   bytes.Repeat(random[:1000], 1200),
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			compressed := Compress(data)
+			got, err := io.ReadAll(bzip2.NewReader(bytes.NewReader(compressed)))
+			if err != nil {
+				t.Fatalf("Failed to decompress: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("Round trip changed %d bytes into %d bytes", len(data), len(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Package checksums writes the SHA256SUMS of the package files of a
+// repository at its root, and optionally a .sha256 next to each file, so
+// users downloading packages directly, outside a package manager, can
+// check them with sha256sum -c
+package checksums
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// FileName is the checksum list published at the root of repositories
+const FileName = "SHA256SUMS"
+
+// SidecarExt is the suffix of the checksum file of a single package file
+const SidecarExt = ".sha256"
+
+// Write writes the SHA256SUMS of files, slash-separated paths relative to
+// repoDir, at its root, and the .sha256 of each file when sidecars is set.
+// Files unchanged since the last SHA256SUMS aren't hashed again. It
+// returns the contents of SHA256SUMS
+func Write(repoDir string, files []string, sidecars bool) ([]byte, error) {
+	sumsPath := filepath.Join(repoDir, FileName)
+	previous := make(map[string]string)
+	var written os.FileInfo
+	if data, err := os.ReadFile(sumsPath); err == nil {
+		previous = Parse(data)
+		written, _ = os.Stat(sumsPath)
+	}
+
+	files = append([]string(nil), files...)
+	sort.Strings(files)
+
+	var sums bytes.Buffer
+	for i, file := range files {
+		if i > 0 && file == files[i-1] {
+			continue
+		}
+		filePath := filepath.Join(repoDir, filepath.FromSlash(file))
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to checksum %s: %w", file, err)
+		}
+
+		sum, ok := previous[file]
+		if !ok || written == nil || info.ModTime().After(written.ModTime()) {
+			checksum, err := utils.CalculateChecksums(filePath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to checksum %s: %w", file, err)
+			}
+			sum = checksum.SHA256
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", sum, file)
+
+		if sidecars {
+			sidecar := fmt.Sprintf("%s  %s\n", sum, path.Base(file))
+			if err := writeIfChanged(filePath+SidecarExt, []byte(sidecar)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := utils.WriteFile(sumsPath, sums.Bytes(), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", FileName, err)
+	}
+	return sums.Bytes(), nil
+}
+
+// writeIfChanged writes data to path unless it already holds it, keeping
+// the modification time mirrors sync on
+func writeIfChanged(path string, data []byte) error {
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+	if err := utils.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write checksum file: %w", err)
+	}
+	return nil
+}
+
+// Parse reads a checksum list in the sha256sum format, by file
+func Parse(data []byte) map[string]string {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		sum, file, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || len(sum) != 64 {
+			continue
+		}
+		// A star marks files hashed in binary mode
+		sums[strings.TrimPrefix(strings.TrimPrefix(file, " "), "*")] = sum
+	}
+	return sums
+}
+
+// RemoveStaleSidecars removes the .sha256 files of package files no
+// longer in repoDir, skipping the directories of skip relative to it, and
+// returns how many it removed
+func RemoveStaleSidecars(repoDir string, skip ...string) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(repoDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(repoDir, p)
+		if d.IsDir() {
+			for _, dir := range skip {
+				if rel == dir {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if utils.IsInternalPath(filepath.ToSlash(rel)) {
+			return nil
+		}
+		if !strings.HasSuffix(d.Name(), SidecarExt) {
+			return nil
+		}
+		if _, err := os.Stat(strings.TrimSuffix(p, SidecarExt)); !os.IsNotExist(err) {
+			return nil
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,99 @@
+package checksums
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Checksums of "hello\n" and "world\n"
+const (
+	helloSum = "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+	worldSum = "e258d248fda94c63753607f7c4494ee0fcbe92f1a76bfdac795c9d84101eb317"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "pool/main/h/hello.deb"), "hello\n")
+	writeFile(t, filepath.Join(dir, "x86_64/world.rpm"), "world\n")
+
+	sums, err := Write(dir, []string{"x86_64/world.rpm", "pool/main/h/hello.deb", "x86_64/world.rpm"}, true)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	want := helloSum + "  pool/main/h/hello.deb\n" + worldSum + "  x86_64/world.rpm\n"
+	if string(sums) != want {
+		t.Errorf("SHA256SUMS = %q, want %q", sums, want)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, FileName)); string(data) != want {
+		t.Errorf("Written SHA256SUMS = %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "x86_64/world.rpm"+SidecarExt)); string(data) != worldSum+"  world.rpm\n" {
+		t.Errorf("Sidecar = %q", data)
+	}
+
+	if _, err := Write(dir, []string{"missing.deb"}, false); err == nil {
+		t.Error("Write accepted a missing file")
+	}
+}
+
+func TestWriteRehashesChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "hello.deb")
+	writeFile(t, file, "hello\n")
+	if _, err := Write(dir, []string{"hello.deb"}, false); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Unchanged files keep their recorded checksum
+	sumsPath := filepath.Join(dir, FileName)
+	writeFile(t, sumsPath, strings.Repeat("0", 64)+"  hello.deb\n")
+	sums, _ := Write(dir, []string{"hello.deb"}, false)
+	if !strings.HasPrefix(string(sums), strings.Repeat("0", 64)) {
+		t.Errorf("Unchanged file was hashed again: %q", sums)
+	}
+
+	writeFile(t, file, "world\n")
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(file, later, later)
+	sums, _ = Write(dir, []string{"hello.deb"}, false)
+	if string(sums) != worldSum+"  hello.deb\n" {
+		t.Errorf("Changed file wasn't hashed again: %q", sums)
+	}
+}
+
+func TestParse(t *testing.T) {
+	sums := Parse([]byte(helloSum + "  hello.deb\n" + worldSum + " *world.rpm\nnot a checksum\n"))
+	if len(sums) != 2 || sums["hello.deb"] != helloSum || sums["world.rpm"] != worldSum {
+		t.Errorf("Parse = %v", sums)
+	}
+}
+
+func TestRemoveStaleSidecars(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "hello.deb"), "hello\n")
+	writeFile(t, filepath.Join(dir, "hello.deb"+SidecarExt), helloSum+"  hello.deb\n")
+	writeFile(t, filepath.Join(dir, "old.deb"+SidecarExt), worldSum+"  old.deb\n")
+	writeFile(t, filepath.Join(dir, "snapshots/1/old.deb"+SidecarExt), worldSum+"  old.deb\n")
+
+	removed, err := RemoveStaleSidecars(dir, "snapshots")
+	if err != nil || removed != 1 {
+		t.Fatalf("RemoveStaleSidecars = %d, %v", removed, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old.deb"+SidecarExt)); !os.IsNotExist(err) {
+		t.Error("Stale sidecar wasn't removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "hello.deb"+SidecarExt)); err != nil {
+		t.Error("Sidecar of a published file was removed")
+	}
+}
@@ -0,0 +1,174 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ralt/repogen/internal/conflict"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewAddCmd creates the add command
+func NewAddCmd() *cobra.Command {
+	var config models.RepositoryConfig
+
+	cmd := &cobra.Command{
+		Use:   "add <package>...",
+		Short: "Add packages to an existing repository",
+		Long: `Parses the given package files, merges them into the existing repository
+metadata, copies them into the repository and re-signs it. Unlike generate
+--incremental, no input directory is scanned.
+
+Pass the same repository flags (signing keys, --arch, --repo-name, ...) as for
+generate so the metadata is regenerated identically.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateRepositoryConfig(&config); err != nil {
+				return err
+			}
+
+			return runAdd(cmd.Context(), &config, args, false)
+		},
+	}
+
+	addRepositoryFlags(cmd, &config)
+
+	return cmd
+}
+
+// runAdd publishes the given package files into the existing repository.
+// Packages that are already published are resolved as config.OnConflict
+// says, or skipped when skipPublished is set
+func runAdd(ctx context.Context, config *models.RepositoryConfig, paths []string, skipPublished bool) error {
+	unlock, err := lockOutput(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Parse the given packages; unlike a directory scan, every file must be a package
+	packagesByType := make(map[scanner.PackageType][]models.Package)
+	var order []scanner.PackageType
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return &models.RepoGenError{
+				Type: models.ErrFileOp,
+				Err:  fmt.Errorf("failed to read package %s: %w", path, err),
+			}
+		}
+
+		pkgType, err := scanner.DetectPackageType(path)
+		if err != nil || pkgType == scanner.TypeUnknown {
+			return &models.RepoGenError{
+				Type: models.ErrPackageParse,
+				Err:  fmt.Errorf("%s is not a recognized package", path),
+			}
+		}
+
+		logrus.Debugf("Parsing %s package: %s", pkgType, path)
+		scanned := scanner.ScannedPackage{Path: path, Type: pkgType, Size: info.Size()}
+		pkg, err := parsePackage(scanned)
+		if err != nil {
+			return &models.RepoGenError{
+				Type: models.ErrPackageParse,
+				Err:  fmt.Errorf("failed to parse %s: %w", path, err),
+			}
+		}
+		emitPackageParsed(scanned, pkg)
+
+		if _, ok := packagesByType[pkgType]; !ok {
+			order = append(order, pkgType)
+		}
+		packagesByType[pkgType] = append(packagesByType[pkgType], *pkg)
+	}
+
+	if _, ok := packagesByType[scanner.TypePacman]; ok && config.RepoName == "" {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("--repo-name is required for Pacman (Arch Linux) repository generation"),
+		}
+	}
+
+	if err := checkVulnerabilities(ctx, config, packagesByType); err != nil {
+		return err
+	}
+
+	settings, err := loadPackageSettings(config)
+	if err != nil {
+		return err
+	}
+
+	keys, err := newSigningKeys(config)
+	if err != nil {
+		return err
+	}
+	generators := keys.generators(config)
+	if _, ok := packagesByType[scanner.TypeDeb]; ok {
+		if err := keys.preflightApt(config); err != nil {
+			return err
+		}
+	}
+
+	for _, pkgType := range order {
+		gen := generators[pkgType]
+		newPackages := packagesByType[pkgType]
+
+		existingPackages, err := gen.ParseExistingMetadata(config)
+		if err != nil {
+			// Adding to an empty output directory creates the repository
+			logrus.Debugf("No existing %s repository: %v", pkgType, err)
+			existingPackages = nil
+		}
+
+		policy := config.OnConflict
+		if skipPublished {
+			policy = conflict.PolicySkip
+		}
+		resolved, err := resolveConflicts(config, gen, pkgType, existingPackages, newPackages, policy)
+		if err != nil {
+			return err
+		}
+		finalPackages, newPackages := resolved.Packages, resolved.Incoming
+		if len(newPackages) == 0 {
+			continue
+		}
+		logrus.Infof("Adding %d %s package(s) to %d existing", len(newPackages), pkgType, len(finalPackages)-len(newPackages))
+
+		if err := checkDependencies(config, gen, pkgType, finalPackages); err != nil {
+			return err
+		}
+
+		settings.apply(pkgType, finalPackages)
+
+		if err := generateRepository(ctx, config, gen, pkgType, finalPackages); err != nil {
+			return err
+		}
+		if err := recordProvenance(config, gen, pkgType, newPackages, keys.keyID(pkgType)); err != nil {
+			return err
+		}
+	}
+	if err := writeDownloadAids(config); err != nil {
+		return err
+	}
+	if err := writeChecksums(config, keys); err != nil {
+		return err
+	}
+	if err := keys.signSigstore(ctx, config); err != nil {
+		return err
+	}
+	if err := keys.signMinisign(ctx, config); err != nil {
+		return err
+	}
+	if err := writeClientFiles(config, keys); err != nil {
+		return err
+	}
+
+	logrus.Info("Packages added successfully!")
+	return nil
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/packager"
+	"github.com/spf13/cobra"
+)
+
+// newTestConfig returns the repository config of a command run with the
+// output directory dir and args, defaults included
+func newTestConfig(t *testing.T, dir string, args ...string) *models.RepositoryConfig {
+	t.Helper()
+	var config models.RepositoryConfig
+	cmd := &cobra.Command{}
+	addRepositoryFlags(cmd, &config)
+	if err := cmd.ParseFlags(append([]string{"--output-dir", dir}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateRepositoryConfig(&config); err != nil {
+		t.Fatalf("validateRepositoryConfig failed: %v", err)
+	}
+	return &config
+}
+
+// buildDeb builds a Debian package installing /usr/share/<name> with
+// content into its own directory
+func buildDeb(t *testing.T, name, version, content string) string {
+	t.Helper()
+	path, err := packager.Build("deb", packager.Package{
+		Name:    name,
+		Version: version,
+		Summary: "Test package",
+		Files:   []packager.File{{Path: "usr/share/" + name, Mode: 0644, Data: []byte(content)}},
+	}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// debPackages returns the Packages index of the stable suite in dir
+func debPackages(t *testing.T, dir string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "dists", "stable", "main", "binary-amd64", "Packages"))
+	if err != nil {
+		t.Fatalf("Failed to read Packages: %v", err)
+	}
+	return string(data)
+}
+
+func TestRunAddMergesIntoExistingRepository(t *testing.T) {
+	ctx := context.Background()
+	config := newTestConfig(t, t.TempDir())
+
+	if err := runAdd(ctx, config, []string{buildDeb(t, "hello", "1.0", "hello")}, false); err != nil {
+		t.Fatalf("runAdd failed: %v", err)
+	}
+	if err := runAdd(ctx, config, []string{buildDeb(t, "world", "1.0", "world")}, false); err != nil {
+		t.Fatalf("runAdd failed: %v", err)
+	}
+
+	packages := debPackages(t, config.OutputDir)
+	for _, name := range []string{"hello", "world"} {
+		if !strings.Contains(packages, "Package: "+name+"\n") {
+			t.Errorf("Packages does not list %s:\n%s", name, packages)
+		}
+		if _, err := os.Stat(filepath.Join(config.OutputDir, "pool", "main", name[:1], name, name+"_1.0_amd64.deb")); err != nil {
+			t.Errorf("%s was not copied into the pool: %v", name, err)
+		}
+	}
+}
+
+func TestRunAddSkipPublished(t *testing.T) {
+	ctx := context.Background()
+	config := newTestConfig(t, t.TempDir())
+
+	if err := runAdd(ctx, config, []string{buildDeb(t, "hello", "1.0", "first")}, false); err != nil {
+		t.Fatalf("runAdd failed: %v", err)
+	}
+	published := filepath.Join(config.OutputDir, "pool", "main", "h", "hello", "hello_1.0_amd64.deb")
+	before, err := os.ReadFile(published)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same version with other contents conflicts with the published one
+	rebuilt := buildDeb(t, "hello", "1.0", "second")
+	err = runAdd(ctx, config, []string{rebuilt}, false)
+	var repoErr *models.RepoGenError
+	if !errors.As(err, &repoErr) || repoErr.Type != models.ErrInvalidConfig {
+		t.Fatalf("runAdd of a published version = %v, want a conflict", err)
+	}
+
+	// skipPublished keeps the published one, and adds the others
+	if err := runAdd(ctx, config, []string{rebuilt, buildDeb(t, "world", "1.0", "world")}, true); err != nil {
+		t.Fatalf("runAdd with skipPublished failed: %v", err)
+	}
+	if after, _ := os.ReadFile(published); string(after) != string(before) {
+		t.Error("skipPublished replaced the published package")
+	}
+	packages := debPackages(t, config.OutputDir)
+	if strings.Count(packages, "Package: hello\n") != 1 || !strings.Contains(packages, "Package: world\n") {
+		t.Errorf("Unexpected Packages:\n%s", packages)
+	}
+}
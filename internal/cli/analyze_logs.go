@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ralt/repogen/internal/accesslog"
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewAnalyzeLogsCmd creates the analyze-logs command
+func NewAnalyzeLogsCmd() *cobra.Command {
+	var config models.RepositoryConfig
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "analyze-logs <access.log>...",
+		Short: "Count package downloads in web server or S3 access logs",
+		Long: `Reads common/combined format access logs (Apache, nginx, Caddy, ...) or S3
+server access logs and counts the successful downloads of every package
+published in the repository, per name, version and architecture.
+
+Gzip-compressed (.gz) logs are read transparently, and "-" reads standard
+input. The repository may be served below any URL prefix.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if config.Codename == "" || len(config.Components) == 0 || len(config.Arches) == 0 {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--codename, --components and --arch must not be empty"),
+				}
+			}
+
+			analyzer, err := newLogAnalyzer(&config)
+			if err != nil {
+				return err
+			}
+
+			for _, path := range args {
+				if err := readAccessLog(analyzer, path); err != nil {
+					return &models.RepoGenError{
+						Type: models.ErrFileOp,
+						Err:  fmt.Errorf("failed to read %s: %w", path, err),
+					}
+				}
+			}
+
+			report := analyzer.Report()
+			if report.Skipped > 0 {
+				logrus.Warnf("Skipped %d log lines that are not in a known format", report.Skipped)
+			}
+
+			if jsonOutput {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(report)
+			}
+			return printDownloads(cmd.OutOrStdout(), report)
+		},
+	}
+
+	cmd.Flags().StringVarP(&config.OutputDir, "output-dir", "o", "./repo", "Repository directory the logs were served from")
+	cmd.Flags().StringVar(&config.Codename, "codename", "stable", "Codename for Debian repos")
+	cmd.Flags().StringSliceVar(&config.Components, "components", []string{"main"}, "Components for Debian repos")
+	cmd.Flags().StringSliceVar(&config.Arches, "arch", []string{"amd64"}, "Architectures to support")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the report as JSON")
+
+	return cmd
+}
+
+// newLogAnalyzer registers every package published in the repository
+func newLogAnalyzer(config *models.RepositoryConfig) (*accesslog.Analyzer, error) {
+	generators, err := newGenerators(config)
+	if err != nil {
+		return nil, err
+	}
+
+	analyzer := accesslog.NewAnalyzer()
+	found := 0
+	for _, pkgType := range removableTypes {
+		gen := generators[pkgType]
+
+		existing, err := gen.ParseExistingMetadata(config)
+		if err != nil {
+			logrus.Debugf("No %s repository: %v", pkgType, err)
+			continue
+		}
+		locator, ok := gen.(generator.PackageLocator)
+		if !ok {
+			continue
+		}
+
+		for _, pkg := range existing {
+			rel, err := filepath.Rel(config.OutputDir, locator.PackageFiles(config, pkg)[0])
+			if err != nil {
+				continue
+			}
+			analyzer.AddPackage(pkgType.String(), filepath.ToSlash(rel), pkg)
+			found++
+		}
+	}
+
+	if found == 0 {
+		return nil, &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("no published packages found in %s", config.OutputDir),
+		}
+	}
+	logrus.Debugf("Matching access logs against %d package files", found)
+
+	return analyzer, nil
+}
+
+// readAccessLog feeds one log file, possibly gzip-compressed, to analyzer
+func readAccessLog(analyzer *accesslog.Analyzer, path string) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	return analyzer.Read(r)
+}
+
+// printDownloads writes the report as a table, most downloaded package first
+func printDownloads(w io.Writer, report *accesslog.Report) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOWNLOADS\tTYPE\tNAME\tVERSION\tARCH")
+	for _, d := range report.Downloads {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", d.Count, d.Type, d.Name, d.Version, d.Architecture)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d package downloads in %d requests\n", report.Total, report.Requests)
+	return err
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ralt/repogen/internal/checksums"
+	"github.com/ralt/repogen/internal/events"
+	"github.com/ralt/repogen/internal/manifest"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/signer"
+	"github.com/ralt/repogen/internal/snapshot"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// checksumsSignatureExt is the suffix of the detached GPG signature of
+// SHA256SUMS, as Ubuntu publishes it
+const checksumsSignatureExt = ".gpg"
+
+// writeChecksums writes the SHA256SUMS of every package file the manifest
+// lists, signed with the GPG key, when asked to. It runs before the
+// cosign and minisign signatures, which sign it too
+func writeChecksums(config *models.RepositoryConfig, keys *signingKeys) error {
+	if !config.Checksums {
+		return nil
+	}
+
+	m, err := manifest.Read(config.OutputDir)
+	if err != nil {
+		return &models.RepoGenError{Type: models.ErrFileOp, Err: fmt.Errorf("failed to read package manifest: %w", err)}
+	}
+	var files []string
+	for _, e := range m.Packages {
+		if e.Path == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(config.OutputDir, filepath.FromSlash(e.Path))); err != nil {
+			logrus.Debugf("Not listing %s in %s: %v", e.Path, checksums.FileName, err)
+			continue
+		}
+		files = append(files, e.Path)
+	}
+
+	sums, err := checksums.Write(config.OutputDir, files, config.ChecksumFiles)
+	if err != nil {
+		return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+	}
+	if config.ChecksumFiles {
+		removed, err := checksums.RemoveStaleSidecars(config.OutputDir, snapshot.Dir)
+		if err != nil {
+			return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+		}
+		if removed > 0 {
+			logrus.Infof("Removed %d checksum files of removed packages", removed)
+		}
+	}
+
+	sigPath := filepath.Join(config.OutputDir, checksums.FileName+checksumsSignatureExt)
+	if keys.gpg == nil {
+		// A signature of an older list would fail verification
+		if err := os.Remove(sigPath); err != nil && !os.IsNotExist(err) {
+			return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+		}
+		logrus.Infof("Listed %d package files in %s", len(files), checksums.FileName)
+		return nil
+	}
+
+	signature, err := keys.gpg.SignDetached(sums)
+	if err != nil {
+		return &models.RepoGenError{Type: models.ErrSigning, Err: fmt.Errorf("failed to sign %s: %w", checksums.FileName, err)}
+	}
+	if err := signer.CheckDetached(keys.gpg, signature, sums); err != nil {
+		return &models.RepoGenError{Type: models.ErrSigning, Err: fmt.Errorf("%s signature doesn't verify: %w", checksums.FileName, err)}
+	}
+	if err := utils.WriteFile(sigPath, signature, 0644); err != nil {
+		return &models.RepoGenError{Type: models.ErrFileOp, Err: fmt.Errorf("failed to write %s signature: %w", checksums.FileName, err)}
+	}
+	events.Emit(events.Signed, events.Fields{"path": sigPath, "kind": "detached"})
+	logrus.Infof("Listed and signed %d package files in %s", len(files), checksums.FileName)
+	return nil
+}
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ralt/repogen/internal/browse"
+	"github.com/ralt/repogen/internal/clientsetup"
+	"github.com/ralt/repogen/internal/generator/apk"
+	"github.com/ralt/repogen/internal/generator/deb"
+	"github.com/ralt/repogen/internal/generator/pacman"
+	"github.com/ralt/repogen/internal/generator/rpm"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/signer"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// writeClientFiles writes install.sh, and the index pages when asked for,
+// once the generators are done
+func writeClientFiles(config *models.RepositoryConfig, keys *signingKeys) error {
+	setup := clientSetup(config, keys)
+	if !setup.Empty() {
+		if err := clientsetup.Write(config.OutputDir, setup); err != nil {
+			return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+		}
+		logrus.Infof("Install script written to: %s", filepath.Join(config.OutputDir, clientsetup.ScriptName))
+	}
+
+	if config.IndexPages {
+		pages, err := browse.Write(config.OutputDir, browse.Site{
+			Title:   repositoryTitle(config),
+			Setup:   setup,
+			Updated: utils.Timestamp(config),
+		})
+		if err != nil {
+			return &models.RepoGenError{Type: models.ErrFileOp, Err: fmt.Errorf("failed to write index pages: %w", err)}
+		}
+		logrus.Infof("Wrote %d index pages", pages)
+	}
+	return nil
+}
+
+// clientSetup describes the client configuration files the generators wrote
+// into the output directory, this run or an earlier one. It is empty
+// without a base URL to fetch them from
+func clientSetup(config *models.RepositoryConfig, keys *signingKeys) clientsetup.Setup {
+	if config.BaseURL == "" {
+		return clientsetup.Setup{}
+	}
+
+	// published returns name if the output directory holds it
+	published := func(name string) string {
+		if _, err := os.Stat(filepath.Join(config.OutputDir, filepath.FromSlash(name))); err != nil {
+			return ""
+		}
+		return name
+	}
+
+	slug := utils.RepoSlug(config)
+	setup := clientsetup.Setup{
+		BaseURL:         config.BaseURL,
+		Name:            repositoryTitle(config),
+		AptSources:      published(deb.SourcesFileName(config)),
+		AptKeyring:      published(signer.PublicKeyPath(slug, signer.KeyBinary)),
+		AptKeyPath:      deb.KeyringPath(config),
+		RepoFile:        published(rpm.RepoFileName(config) + ".repo"),
+		APKRepositories: published(apk.RepositoriesFileName),
+		APKKey:          published(signer.PublicKeyPath("", apk.PublicKeyFileName(config.RSAKeyName))),
+		PacmanConf:      published(pacman.ConfFileName),
+	}
+	if setup.PacmanConf != "" {
+		if fingerprint := keys.keyID(scanner.TypePacman); fingerprint != "" {
+			setup.PacmanKey = published(signer.PublicKeyPath(slug, signer.KeyArmored))
+			setup.PacmanKeyID = fingerprint
+		}
+	}
+	return setup
+}
+
+// repositoryTitle names the repository to its users: its label, origin, or
+// the name of the output directory
+func repositoryTitle(config *models.RepositoryConfig) string {
+	switch {
+	case config.Label != "":
+		return config.Label
+	case config.Origin != "":
+		return config.Origin
+	}
+	return filepath.Base(config.OutputDir)
+}
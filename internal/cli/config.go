@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/ralt/repogen/internal/config"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// fromConfigAnnotation marks the flags set from a configuration file rather
+// than the command line
+const fromConfigAnnotation = "repogen_from_config"
+
+// applyConfigFile sets the flags of cmd that were not given on the command
+// line from the configuration file at path
+func applyConfigFile(cmd *cobra.Command, path string) error {
+	file, err := config.Load(path)
+	if err != nil {
+		return &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+	}
+
+	values, err := file.Values(config.DetectEnvironment("."))
+	if err != nil {
+		return &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+	}
+
+	return applyConfigValues(cmd, values)
+}
+
+// applyConfigValues sets the flags of cmd that are still unset from values
+func applyConfigValues(cmd *cobra.Command, values map[string]string) error {
+	for name, value := range values {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil {
+			// One file may configure several commands
+			logrus.Debugf("Config setting %q does not apply to %s", name, cmd.Name())
+			continue
+		}
+		if flag.Changed {
+			continue
+		}
+
+		if err := cmd.Flags().Set(name, value); err != nil {
+			return &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err:  fmt.Errorf("invalid config setting %q: %w", name, err),
+			}
+		}
+		cmd.Flags().SetAnnotation(name, fromConfigAnnotation, []string{"true"})
+		logrus.Debugf("Config: --%s=%s", name, value)
+	}
+
+	return nil
+}
+
+// fromCommandLine reports whether flag was given on the command line
+func fromCommandLine(flag *pflag.Flag) bool {
+	_, fromConfig := flag.Annotations[fromConfigAnnotation]
+	return flag.Changed && !fromConfig
+}
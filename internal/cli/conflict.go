@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ralt/repogen/internal/conflict"
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// resolveConflicts merges newPackages into the existing packages of pkgType
+// as policy says, logging which file won each collision
+func resolveConflicts(config *models.RepositoryConfig, gen generator.Generator, pkgType scanner.PackageType, existing, newPackages []models.Package, policy string) (*conflict.Result, error) {
+	resolved := conflict.Resolve(existing, newPackages, conflictKey(config, gen, pkgType), policy)
+	if resolved.Duplicates > 0 {
+		logrus.Debugf("%d %s package(s) already scanned or published with the same content", resolved.Duplicates, pkgType)
+	}
+	if len(resolved.Collisions) == 0 {
+		return resolved, nil
+	}
+
+	var collisions []string
+	for _, c := range resolved.Collisions {
+		id := fmt.Sprintf("%s-%s-%s", c.Kept.Name, c.Kept.Version, c.Kept.Architecture)
+		switch policy {
+		case conflict.PolicySkip:
+			logrus.Infof("%s: keeping %s, skipping %s", id, c.Kept.Filename, c.Dropped.Filename)
+		case conflict.PolicyReplace:
+			logrus.Infof("%s: replacing %s with %s", id, c.Dropped.Filename, c.Kept.Filename)
+		case conflict.PolicyKeepBoth:
+			logrus.Warnf("%s: publishing both %s and %s", id, c.Dropped.Filename, c.Kept.Filename)
+		default:
+			collisions = append(collisions, fmt.Sprintf("%s (%s and %s)", id, c.Kept.Filename, c.Dropped.Filename))
+		}
+	}
+	if len(collisions) > 0 {
+		return nil, &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err: fmt.Errorf("%d %s package(s) already scanned or published, pass --on-conflict to resolve them: %s",
+				len(collisions), pkgType, strings.Join(collisions, ", ")),
+		}
+	}
+	return resolved, nil
+}
+
+// conflictKey returns the identity of packages of pkgType. The RPM
+// repositories of each release version are separate, so there a package
+// only conflicts with the same one published in the same repository
+func conflictKey(config *models.RepositoryConfig, gen generator.Generator, pkgType scanner.PackageType) func(models.Package) string {
+	locator, ok := gen.(generator.PackageLocator)
+	if pkgType != scanner.TypeRpm || !ok {
+		return func(pkg models.Package) string {
+			return utils.PackageIdentity(pkg, pkgType)
+		}
+	}
+
+	return func(pkg models.Package) string {
+		// Without a file name, the directory of the repository
+		located := pkg
+		located.Filename = ""
+		return utils.PackageIdentity(pkg, pkgType) + "@" + locator.PackageFiles(config, located)[0]
+	}
+}
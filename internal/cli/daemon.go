@@ -0,0 +1,427 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ralt/repogen/internal/manifest"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// daemonTokenEnv holds an API token, for deployments passing secrets
+// through the environment
+const daemonTokenEnv = "REPOGEN_DAEMON_TOKEN"
+
+// NewDaemonCmd creates the daemon command
+func NewDaemonCmd() *cobra.Command {
+	var config models.RepositoryConfig
+	var listen, tokenFile string
+	var maxUpload int64
+
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Serve a repository with an HTTP API to publish packages",
+		Long: `Serves the repository in the output directory like serve, and accepts
+changes over an HTTP API, regenerating and re-signing the repository
+server-side:
+
+  POST   /packages                   Publish the package files uploaded as
+                                     multipart/form-data, like add
+                                     (?skip_published=true skips those
+                                     already published)
+  DELETE /packages/{name}/{version}  Remove a version of a package, like remove
+  DELETE /packages/{name}            Remove every version of a package
+  POST   /regenerate                 Regenerate and re-sign the metadata of
+                                     every published package
+
+The API requires an "Authorization: Bearer <token>" header, the tokens being
+the lines of --token-file and $` + daemonTokenEnv + `. Changes are applied one
+at a time.
+
+Pass the same repository flags (signing keys, --arch, --repo-name, ...) as for
+generate so the metadata is regenerated identically.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateRepositoryConfig(&config); err != nil {
+				return err
+			}
+			if maxUpload <= 0 {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--max-upload-size must be positive"),
+				}
+			}
+			tokens, err := loadDaemonTokens(tokenFile)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
+				return &models.RepoGenError{
+					Type: models.ErrFileOp,
+					Err:  fmt.Errorf("failed to create output directory: %w", err),
+				}
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			d := &daemon{ctx: ctx, config: &config, tokens: tokens, maxUpload: maxUpload}
+			return d.run(listen)
+		},
+	}
+
+	addRepositoryFlags(cmd, &config)
+	cmd.Flags().StringVar(&listen, "listen", ":9000", "Address to listen on")
+	cmd.Flags().StringVar(&tokenFile, "token-file", "", "File listing the API tokens, one per line (or set $"+daemonTokenEnv+")")
+	cmd.Flags().Int64Var(&maxUpload, "max-upload-size", 1<<30, "Largest upload accepted by POST /packages, in bytes")
+
+	return cmd
+}
+
+// loadDaemonTokens returns the API tokens of tokenFile and the environment
+func loadDaemonTokens(tokenFile string) ([]string, error) {
+	var tokens []string
+	if token := strings.TrimSpace(os.Getenv(daemonTokenEnv)); token != "" {
+		tokens = append(tokens, token)
+	}
+	if tokenFile != "" {
+		f, err := os.Open(tokenFile)
+		if err != nil {
+			return nil, &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err:  fmt.Errorf("failed to read token file: %w", err),
+			}
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				tokens = append(tokens, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err:  fmt.Errorf("failed to read token file: %w", err),
+			}
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("an API token is required: pass --token-file or set %s", daemonTokenEnv),
+		}
+	}
+	return tokens, nil
+}
+
+// daemon serves a repository and applies the changes submitted to its API
+type daemon struct {
+	ctx       context.Context // Lifetime of the daemon, which changes run under
+	config    *models.RepositoryConfig
+	tokens    []string
+	maxUpload int64
+	mu        sync.Mutex // Serializes changes to the repository
+}
+
+func (d *daemon) run(listen string) error {
+	outputDir := d.config.OutputDir
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           d.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		logrus.Infof("Serving %s with the publishing API on %s", outputDir, listen)
+		errc <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("server failed: %w", err),
+		}
+	case <-d.ctx.Done():
+	}
+
+	// Let in-flight downloads and changes finish
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("server shutdown failed: %w", err),
+		}
+	}
+	return nil
+}
+
+// handler routes the API, the probes and the files of the repository
+func (d *daemon) handler() http.Handler {
+	outputDir := d.config.OutputDir
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /packages", d.authenticate(d.handleUpload))
+	mux.HandleFunc("DELETE /packages/{name}", d.authenticate(d.handleRemove))
+	mux.HandleFunc("DELETE /packages/{name}/{version}", d.authenticate(d.handleRemove))
+	mux.HandleFunc("POST /regenerate", d.authenticate(d.handleRegenerate))
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		serveHealth(w, outputDir, 0)
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		serveMetrics(w, outputDir)
+	})
+	mux.Handle("GET /", repositoryFiles(outputDir))
+	return mux
+}
+
+// authenticate only lets requests bearing one of the API tokens through
+func (d *daemon) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok {
+			for _, known := range d.tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+					next(w, r)
+					return
+				}
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="repogen"`)
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid API token"})
+	}
+}
+
+// handleUpload publishes the package files of a multipart upload
+func (d *daemon) handleUpload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, d.maxUpload)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "expected a multipart/form-data upload: " + err.Error()})
+		return
+	}
+
+	uploadDir, err := os.MkdirTemp("", "repogen-upload-")
+	if err != nil {
+		respondError(w, &models.RepoGenError{Type: models.ErrFileOp, Err: err})
+		return
+	}
+	defer os.RemoveAll(uploadDir)
+
+	// Uploads are read before taking the lock, so slow clients don't hold up other changes
+	var paths, names []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid upload: " + err.Error()})
+			return
+		}
+		// Only the base name is kept, so uploads can't write outside uploadDir
+		name := filepath.Base(part.FileName())
+		if part.FileName() == "" || name == "." || name == ".." || name == string(filepath.Separator) {
+			part.Close()
+			continue
+		}
+		path := filepath.Join(uploadDir, fmt.Sprintf("%d", len(paths)), name)
+		if err := saveUpload(path, part); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				respondJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("upload larger than %d bytes", d.maxUpload)})
+				return
+			}
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid upload: " + err.Error()})
+			return
+		}
+		paths = append(paths, path)
+		names = append(names, name)
+	}
+	if len(paths) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "no package file uploaded"})
+		return
+	}
+
+	skipPublished := r.URL.Query().Get("skip_published") == "true"
+	logrus.Infof("Publishing %s", strings.Join(names, ", "))
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := runAdd(d.ctx, d.config, paths, skipPublished); err != nil {
+		respondError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{"status": "published", "files": names})
+}
+
+// saveUpload writes an uploaded file to path
+func saveUpload(path string, part io.ReadCloser) error {
+	defer part.Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, part); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// handleRemove removes a package, or one version of it
+func (d *daemon) handleRemove(w http.ResponseWriter, r *http.Request) {
+	name, version := r.PathValue("name"), r.PathValue("version")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.published(name, version) {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": strings.TrimSpace(fmt.Sprintf("package %s %s not found", name, version))})
+		return
+	}
+
+	// As for remove, RPM distro versions come from the existing repository layout
+	config := *d.config
+	config.Version = ""
+	if err := runRemove(d.ctx, &config, name, version); err != nil {
+		respondError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"status": "removed"})
+}
+
+// published reports whether the manifest lists the package, or that
+// version of it, which may name the RPM release the manifest leaves out.
+// Without a manifest, removal finds out by itself
+func (d *daemon) published(name, version string) bool {
+	m, err := manifest.Read(d.config.OutputDir)
+	if err != nil {
+		return !os.IsNotExist(err) || hasRepository(d.config.OutputDir)
+	}
+	for _, entry := range m.Packages {
+		if entry.Name == name && (version == "" || entry.Version == version || strings.HasPrefix(version, entry.Version+"-")) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRepository reports whether outputDir holds anything yet
+func hasRepository(outputDir string) bool {
+	entries, err := os.ReadDir(outputDir)
+	return err == nil && len(entries) > 0
+}
+
+// handleRegenerate regenerates and re-signs the published repositories
+func (d *daemon) handleRegenerate(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	regenerated, err := runRegenerate(d.ctx, d.config)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{"status": "regenerated", "packages": regenerated})
+}
+
+// runRegenerate regenerates the metadata of every repository type published
+// in the output directory from its existing packages, and returns how many
+// packages of each type it lists. Homebrew taps have no metadata to regenerate
+func runRegenerate(ctx context.Context, config *models.RepositoryConfig) (map[string]int, error) {
+	unlock, err := lockOutput(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	settings, err := loadPackageSettings(config)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := newSigningKeys(config)
+	if err != nil {
+		return nil, err
+	}
+	generators := keys.generators(config)
+
+	regenerated := make(map[string]int)
+	for _, pkgType := range removableTypes {
+		if isHomebrew(pkgType) {
+			continue
+		}
+		gen := generators[pkgType]
+		packages, err := gen.ParseExistingMetadata(config)
+		if err != nil || len(packages) == 0 {
+			logrus.Debugf("No %s repository: %v", pkgType, err)
+			continue
+		}
+
+		settings.apply(pkgType, packages)
+		if err := generateRepository(ctx, config, gen, pkgType, packages); err != nil {
+			return nil, err
+		}
+		regenerated[pkgType.String()] = len(packages)
+	}
+
+	if err := writeDownloadAids(config); err != nil {
+		return nil, err
+	}
+	if err := writeChecksums(config, keys); err != nil {
+		return nil, err
+	}
+	if err := keys.signSigstore(ctx, config); err != nil {
+		return nil, err
+	}
+	if err := keys.signMinisign(ctx, config); err != nil {
+		return nil, err
+	}
+	if err := writeClientFiles(config, keys); err != nil {
+		return nil, err
+	}
+	logrus.Info("Repository regenerated successfully!")
+	return regenerated, nil
+}
+
+// respondError reports a failed change, rejected changes being told from
+// server-side failures
+func respondError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	var repoErr *models.RepoGenError
+	if errors.As(err, &repoErr) {
+		switch repoErr.Type {
+		case models.ErrInvalidConfig:
+			code = http.StatusConflict
+		case models.ErrPackageParse, models.ErrVulnerable:
+			code = http.StatusUnprocessableEntity
+		case models.ErrTimeout:
+			code = http.StatusServiceUnavailable
+		}
+	}
+	logrus.Errorf("API request failed: %v", err)
+	respondJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func respondJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
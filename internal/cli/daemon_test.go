@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testToken = "s3cret"
+
+func newTestDaemon(t *testing.T, maxUpload int64) (*daemon, *httptest.Server) {
+	t.Helper()
+	d := &daemon{
+		ctx:       context.Background(),
+		config:    newTestConfig(t, t.TempDir()),
+		tokens:    []string{testToken},
+		maxUpload: maxUpload,
+	}
+	server := httptest.NewServer(d.handler())
+	t.Cleanup(server.Close)
+	return d, server
+}
+
+// uploadRequest returns a POST /packages request uploading the files at
+// paths under the given file names
+func uploadRequest(t *testing.T, url string, files map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part, err := w.CreateFormFile("package", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	w.Close()
+
+	req, err := http.NewRequest(http.MethodPost, url+"/packages", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	return req
+}
+
+// do sends req and returns the status and body of the response
+func do(t *testing.T, req *http.Request) (int, string) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestDaemonAuthentication(t *testing.T) {
+	_, server := newTestDaemon(t, 1<<20)
+
+	for _, header := range []string{"", "Bearer wrong", "Bearer " + testToken + "x", testToken} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/regenerate", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		code, body := do(t, req)
+		if code != http.StatusUnauthorized || !strings.Contains(body, "missing or invalid API token") {
+			t.Errorf("Authorization %q = %d %s, want 401", header, code, body)
+		}
+	}
+}
+
+func TestDaemonUploadAndRemove(t *testing.T) {
+	d, server := newTestDaemon(t, 1<<20)
+	hello := buildDeb(t, "hello", "1.0", "hello")
+
+	code, body := do(t, uploadRequest(t, server.URL, map[string]string{"hello_1.0_amd64.deb": hello}))
+	if code != http.StatusOK || !strings.Contains(body, `"published"`) {
+		t.Fatalf("Upload = %d %s", code, body)
+	}
+	if index := debPackages(t, d.config.OutputDir); !strings.Contains(index, "Package: hello\n") {
+		t.Errorf("Packages does not list hello:\n%s", index)
+	}
+	resp, err := http.Get(server.URL + "/pool/main/h/hello/hello_1.0_amd64.deb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET of the published package = %d", resp.StatusCode)
+	}
+
+	// The same version with other contents conflicts with the published one
+	rebuilt := buildDeb(t, "hello", "1.0", "rebuilt")
+	if code, body := do(t, uploadRequest(t, server.URL, map[string]string{"hello_1.0_amd64.deb": rebuilt})); code != http.StatusConflict {
+		t.Errorf("Duplicate upload = %d %s, want 409", code, body)
+	}
+
+	for _, path := range []string{"/packages/missing", "/packages/hello/9.9"} {
+		req, _ := http.NewRequest(http.MethodDelete, server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		if code, body := do(t, req); code != http.StatusNotFound {
+			t.Errorf("DELETE %s = %d %s, want 404", path, code, body)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/packages/hello/1.0", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	if code, body := do(t, req); code != http.StatusOK {
+		t.Fatalf("DELETE of hello 1.0 = %d %s", code, body)
+	}
+	if index := debPackages(t, d.config.OutputDir); strings.Contains(index, "Package: hello\n") {
+		t.Errorf("hello is still listed:\n%s", index)
+	}
+}
+
+func TestDaemonUploadTooLarge(t *testing.T) {
+	hello := buildDeb(t, "hello", "1.0", "hello")
+	info, err := os.Stat(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, server := newTestDaemon(t, info.Size()/2)
+
+	code, body := do(t, uploadRequest(t, server.URL, map[string]string{"hello_1.0_amd64.deb": hello}))
+	if code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Upload above maxUpload = %d %s, want 413", code, body)
+	}
+	if _, err := os.Stat(filepath.Join(d.config.OutputDir, "pool")); !os.IsNotExist(err) {
+		t.Errorf("The rejected upload was published: %v", err)
+	}
+}
+
+func TestDaemonUploadFileNames(t *testing.T) {
+	d, server := newTestDaemon(t, 1<<20)
+	hello := buildDeb(t, "hello", "1.0", "hello")
+
+	// Names without a base name are skipped
+	for _, name := range []string{"..", "/", "."} {
+		code, body := do(t, uploadRequest(t, server.URL, map[string]string{name: hello}))
+		if code != http.StatusBadRequest || !strings.Contains(body, "no package file uploaded") {
+			t.Errorf("Upload named %q = %d %s, want 400", name, code, body)
+		}
+	}
+
+	// Directories are dropped from the others
+	code, body := do(t, uploadRequest(t, server.URL, map[string]string{"../../hello_1.0_amd64.deb": hello}))
+	if code != http.StatusOK || !strings.Contains(body, `"files":["hello_1.0_amd64.deb"]`) {
+		t.Fatalf("Upload named ../../hello_1.0_amd64.deb = %d %s", code, body)
+	}
+	if index := debPackages(t, d.config.OutputDir); !strings.Contains(index, "Package: hello\n") {
+		t.Errorf("Packages does not list hello:\n%s", index)
+	}
+}
+
+func TestDaemonHidesState(t *testing.T) {
+	d, server := newTestDaemon(t, 1<<20)
+	writeTestFile(t, d.config.OutputDir, ".repogen.lock", "1234 build-host 2025-01-01T00:00:00Z")
+	writeTestFile(t, d.config.OutputDir, ".repogen/status.json", "{}")
+
+	for _, path := range []string{"/.repogen.lock", "/.repogen/status.json"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/telemetry"
+	"github.com/sirupsen/logrus"
+)
+
+// interruptGrace is how long an interrupted phase is given to stop writing,
+// so the cleanup of its partial output doesn't race with it
+const interruptGrace = 5 * time.Second
+
+// runPhase runs fn with a context expiring after timeout (zero meaning only
+// the deadline of ctx applies). fn runs on its own goroutine so that a
+// phase blocked in a system call (a hung NFS or FUSE mount, a pathological
+// archive) can't hold the run past its deadline: once the context expires,
+// runPhase returns a timeout error after waiting at most interruptGrace
+// for fn
+func runPhase(ctx context.Context, phase string, timeout time.Duration, fn func(ctx context.Context) error) (err error) {
+	ctx, span := telemetry.Start(ctx, phase, nil)
+	defer func() { span.End(err) }()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil && ctx.Err() != nil {
+			return timeoutError(phase, ctx)
+		}
+		return err
+	case <-ctx.Done():
+		select {
+		case <-done:
+		case <-time.After(interruptGrace):
+			logrus.Warnf("%s phase still running %s after it was interrupted, not waiting for it", phase, interruptGrace)
+		}
+		return timeoutError(phase, ctx)
+	}
+}
+
+// timeoutError reports why the context of phase ended
+func timeoutError(phase string, ctx context.Context) error {
+	err := ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("%s phase did not complete before the deadline", phase)
+	} else {
+		err = fmt.Errorf("%s phase interrupted: %w", phase, err)
+	}
+	return &models.RepoGenError{
+		Type: models.ErrTimeout,
+		Err:  err,
+	}
+}
+
+// outputSnapshot records the files present in an output directory, so the
+// files written by an interrupted run can be told apart from the repository
+// it started from
+type outputSnapshot struct {
+	dir   string
+	files map[string]bool
+}
+
+// snapshotOutput lists the files of dir; a missing dir is an empty snapshot
+func snapshotOutput(dir string) (*outputSnapshot, error) {
+	snap := &outputSnapshot{dir: dir, files: make(map[string]bool)}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		snap.files[path] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list output directory: %w", err)
+	}
+	return snap, nil
+}
+
+// removeNew deletes the files and directories created since the snapshot,
+// deepest first. Files that existed before are left as they are
+func (s *outputSnapshot) removeNew() {
+	var created []string
+	filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !s.files[path] {
+			created = append(created, path)
+		}
+		return nil
+	})
+
+	sort.Sort(sort.Reverse(sort.StringSlice(created)))
+	for _, path := range created {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logrus.Warnf("Failed to remove partial output %s: %v", path, err)
+			continue
+		}
+		logrus.Debugf("Removed partial output %s", path)
+	}
+	if len(created) > 0 {
+		logrus.Warnf("Removed %d files and directories written by the interrupted run", len(created))
+	}
+}
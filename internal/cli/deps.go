@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ralt/repogen/internal/depcheck"
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/sirupsen/logrus"
+)
+
+// checkDependencies looks for the dependencies of the packages of pkgType
+// none of them satisfies, failing or warning as config.DepsPolicy says
+func checkDependencies(config *models.RepositoryConfig, gen generator.Generator, pkgType scanner.PackageType, packages []models.Package) error {
+	if config.DepsPolicy == "" || config.DepsPolicy == depcheck.PolicyIgnore {
+		return nil
+	}
+	reader, ok := gen.(generator.DependencyReader)
+	if !ok {
+		return nil
+	}
+
+	checked := make([]depcheck.Package, 0, len(packages))
+	for _, pkg := range packages {
+		checked = append(checked, depcheck.Package{
+			ID:       fmt.Sprintf("%s %s (%s)", pkg.Name, pkg.Version, pkg.Architecture),
+			Requires: reader.Requires(pkg),
+			Provides: reader.Provides(pkg),
+		})
+	}
+
+	broken := depcheck.Check(checked, config.DepsAllow)
+	logrus.Infof("Checked the dependencies of %d %s packages", len(packages), pkgType)
+	if len(broken) == 0 {
+		return nil
+	}
+	var unsatisfied []string
+	for _, b := range broken {
+		logrus.Warnf("%s: %s, which no %s package provides", pkgType, b, pkgType)
+		unsatisfied = append(unsatisfied, b.String())
+	}
+	if config.DepsPolicy == depcheck.PolicyFail {
+		return &models.RepoGenError{
+			Type: models.ErrDependency,
+			Err:  fmt.Errorf("%d unsatisfied %s dependencies: %s", len(broken), pkgType, strings.Join(unsatisfied, "; ")),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ralt/repogen/internal/manifest"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewDiffCmd creates the diff command
+func NewDiffCmd() *cobra.Command {
+	var config models.RepositoryConfig
+	var jsonOutput bool
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "diff <old repository> <new repository>",
+		Short: "Compare the packages of two repositories or snapshots",
+		Long: `Lists the packages the new repository adds or removes compared to the old
+one, the packages whose versions differ, and the package versions published
+in both with different contents, as when a package was rebuilt without a
+version bump.
+
+Repositories are local directories, such as snapshots (<repository>/snapshots/<name>),
+or repositories served over HTTP(S). They are read through their package
+manifest (.repogen/packages.json); local repositories generated before it
+existed are read from their metadata instead, using --codename, --components
+and --arch for Debian ones.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := &http.Client{Timeout: timeout}
+			var entries [2][]manifest.Entry
+			for i, repo := range args {
+				e, err := repositoryPackages(cmd, client, config, repo)
+				if err != nil {
+					return &models.RepoGenError{
+						Type: models.ErrFileOp,
+						Err:  fmt.Errorf("failed to read %s: %w", repo, err),
+					}
+				}
+				entries[i] = e
+			}
+
+			diff := manifest.Diff(entries[0], entries[1])
+			if jsonOutput {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(diff)
+			}
+			if diff.Empty() {
+				logrus.Info("Both repositories publish the same packages")
+				return nil
+			}
+			return printDiff(cmd.OutOrStdout(), diff)
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the differences as JSON")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "Timeout of each request to a remote repository")
+	cmd.Flags().StringVar(&config.Codename, "codename", "stable", "Codename of local Debian repositories without a package manifest")
+	cmd.Flags().StringSliceVar(&config.Components, "components", []string{"main"}, "Components of local Debian repositories without a package manifest")
+	cmd.Flags().StringSliceVar(&config.Arches, "arch", []string{"amd64"}, "Architectures of local Debian repositories without a package manifest")
+
+	return cmd
+}
+
+// printDiff writes the differences as a table
+func printDiff(w io.Writer, diff *manifest.Difference) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANGE\tTYPE\tNAME\tARCH\tOLD\tNEW")
+	for _, e := range diff.Added {
+		fmt.Fprintf(tw, "added\t%s\t%s\t%s\t-\t%s\n", e.Type, e.Name, e.Architecture, e.Version)
+	}
+	for _, e := range diff.Removed {
+		fmt.Fprintf(tw, "removed\t%s\t%s\t%s\t%s\t-\n", e.Type, e.Name, e.Architecture, e.Version)
+	}
+	for _, u := range diff.Updated {
+		fmt.Fprintf(tw, "updated\t%s\t%s\t%s\t%s\t%s\n", u.Type, u.Name, u.Architecture, versionList(u.From), versionList(u.To))
+	}
+	for _, c := range diff.Changed {
+		fmt.Fprintf(tw, "changed\t%s\t%s\t%s\t%s\t%s\n", c.New.Type, c.New.Name, c.New.Architecture, entryContents(c.Old), entryContents(c.New))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d added, %d removed, %d updated, %d changed\n", len(diff.Added), len(diff.Removed), len(diff.Updated), len(diff.Changed))
+	return err
+}
+
+// versionList joins versions, "-" when there are none
+func versionList(versions []string) string {
+	if len(versions) == 0 {
+		return "-"
+	}
+	return strings.Join(versions, ", ")
+}
+
+// entryContents describes the version and contents of e, e.g.
+// "1.0 (sha256 3a7bd3e2360a, 1024 bytes)"
+func entryContents(e manifest.Entry) string {
+	sum := e.SHA256
+	if len(sum) > 12 {
+		sum = sum[:12]
+	}
+	return fmt.Sprintf("%s (sha256 %s, %d bytes)", e.Version, sum, e.Size)
+}
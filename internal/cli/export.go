@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/ralt/repogen/internal/bundle"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewExportCmd creates the export command
+func NewExportCmd() *cobra.Command {
+	var repoDir, output string
+	var publicKeys []string
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Pack a repository into a single portable bundle",
+		Long: `Packs the metadata, packages and public keys of a repository into one
+zstd-compressed tarball, led by a manifest of every file's SHA256 checksum.
+Use import --bundle to verify and unpack it, e.g. on an air-gapped host.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if repoDir == "" || output == "" {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--repo and --output are required"),
+				}
+			}
+
+			manifest, err := bundle.Export(repoDir, publicKeys, output)
+			if err != nil {
+				return &models.RepoGenError{
+					Type: models.ErrFileOp,
+					Err:  fmt.Errorf("failed to export repository: %w", err),
+				}
+			}
+
+			logrus.Infof("Exported %d files from %s to %s", len(manifest.Files), repoDir, output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&repoDir, "repo", "./repo", "Repository directory to export")
+	cmd.Flags().StringVar(&output, "output", "repo-bundle.tar.zst", "Bundle file to write")
+	cmd.Flags().StringSliceVar(&publicKeys, "public-key", nil, "Public key files to ship at the root of the repository")
+
+	return cmd
+}
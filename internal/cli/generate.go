@@ -2,20 +2,57 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/ralt/repogen/internal/buildmatrix"
+	"github.com/ralt/repogen/internal/conflict"
+	"github.com/ralt/repogen/internal/depcheck"
+	"github.com/ralt/repogen/internal/events"
+	"github.com/ralt/repogen/internal/fetch"
+	"github.com/ralt/repogen/internal/filter"
 	"github.com/ralt/repogen/internal/generator"
 	"github.com/ralt/repogen/internal/generator/apk"
+	"github.com/ralt/repogen/internal/generator/cargo"
+	"github.com/ralt/repogen/internal/generator/conda"
 	"github.com/ralt/repogen/internal/generator/deb"
+	"github.com/ralt/repogen/internal/generator/fdroid"
 	"github.com/ralt/repogen/internal/generator/homebrew"
+	"github.com/ralt/repogen/internal/generator/nuget"
 	"github.com/ralt/repogen/internal/generator/pacman"
+	"github.com/ralt/repogen/internal/generator/pypi"
 	"github.com/ralt/repogen/internal/generator/rpm"
+	"github.com/ralt/repogen/internal/generator/rubygems"
+	"github.com/ralt/repogen/internal/generator/terraform"
+	"github.com/ralt/repogen/internal/generator/xbps"
+	"github.com/ralt/repogen/internal/largefiles"
+	"github.com/ralt/repogen/internal/lock"
+	"github.com/ralt/repogen/internal/manifest"
 	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/objstore"
+	"github.com/ralt/repogen/internal/oci"
+	"github.com/ralt/repogen/internal/overrides"
+	"github.com/ralt/repogen/internal/parsecache"
+	"github.com/ralt/repogen/internal/progress"
+	"github.com/ralt/repogen/internal/provenance"
+	"github.com/ralt/repogen/internal/report"
 	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/sftp"
 	"github.com/ralt/repogen/internal/signer"
+	"github.com/ralt/repogen/internal/sigstore"
+	"github.com/ralt/repogen/internal/snapshot"
+	"github.com/ralt/repogen/internal/staging"
+	"github.com/ralt/repogen/internal/status"
+	"github.com/ralt/repogen/internal/telemetry"
+	"github.com/ralt/repogen/internal/translations"
 	"github.com/ralt/repogen/internal/utils"
+	"github.com/ralt/repogen/internal/vuln"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -23,99 +60,342 @@ import (
 // NewGenerateCmd creates the generate command
 func NewGenerateCmd() *cobra.Command {
 	var config models.RepositoryConfig
+	var all bool
 
 	cmd := &cobra.Command{
 		Use:   "generate",
 		Short: "Generate repository structure",
 		Long: `Scans input directory for packages and generates repository
-structures with appropriate metadata files and signatures.`,
+structures with appropriate metadata files and signatures.
+
+With --all, generates every repository defined under "repositories" in the
+--config file instead, in one process.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Validate configuration
-			if err := validateConfig(&config); err != nil {
-				return err
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+			if all {
+				return runAllRepositories(cmd, jsonOutput)
 			}
+			return runGenerate(cmd, &config, jsonOutput, nil)
+		},
+	}
 
-			logrus.Info("Starting repository generation...")
-			logrus.Debugf("Configuration: %+v", config)
+	addGenerateFlags(cmd, &config)
+	cmd.Flags().BoolVar(&all, "all", false, "Generate every repository defined under \"repositories\" in the --config file")
 
-			// Run generation
-			return runGeneration(cmd.Context(), &config)
-		},
+	return cmd
+}
+
+// runGenerate validates config, holding the flags of cmd, and generates its
+// repository, reusing the packages already parsed in cache if any
+func runGenerate(cmd *cobra.Command, config *models.RepositoryConfig, jsonOutput bool, cache *packageCache) error {
+	// A build matrix or input manifest replaces the input directory unless both are given
+	if (config.BuildMatrixPath != "" || config.InputManifestPath != "") && !cmd.Flags().Changed("input-dir") {
+		config.InputDir = ""
+	}
+
+	// A remote output directory is uploaded from a local staging directory
+	if isRemoteOutput(config.OutputDir) && config.Output == "" {
+		config.Output = config.OutputDir
+		config.OutputDir = outputStagingDir(config.Output)
+	}
+
+	// Writes to remote storage are verified unless told otherwise
+	if config.Output != "" && !cmd.Flags().Changed("verify-writes") {
+		config.VerifyWrites = true
+	}
+
+	// Validate configuration
+	if err := validateConfig(config); err != nil {
+		return err
+	}
+
+	logrus.Info("Starting repository generation...")
+	logrus.Debugf("Configuration: %+v", *config)
+
+	// Run generation
+	start := time.Now()
+	ctx, exportTelemetry := startTelemetry(cmd.Context(), config)
+	ctx, stopProgress := startProgress(ctx, config, jsonOutput)
+	err := runGeneration(ctx, config, cache)
+	stopProgress()
+	if exportErr := exportTelemetry(err); err == nil {
+		err = exportErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		return writeReport(config, start)
 	}
+	return nil
+}
 
+// addGenerateFlags registers the flags of generate describing one repository
+func addGenerateFlags(cmd *cobra.Command, config *models.RepositoryConfig) {
 	// Input/Output flags
 	cmd.Flags().StringVarP(&config.InputDir, "input-dir", "i", ".", "Input directory to scan")
+	addRepositoryFlags(cmd, config)
+	cmd.Flags().StringVar(&config.Output, "output", "", "Push the repository to an OCI registry (oci://registry/repository[:tag]), an SFTP server (sftp://user@host/path), a Google Cloud Storage bucket (gs://bucket/path) or an Azure Blob Storage container (az://account/container/path), --output-dir being the local staging directory")
+	cmd.Flags().StringVar(&config.SSHKeyPath, "ssh-key", "", "Private key authenticating to sftp:// outputs, besides ssh-agent and ~/.ssh/id_*")
+	cmd.Flags().StringVar(&config.SSHKnownHosts, "ssh-known-hosts", "", "Known hosts file checking the host keys of sftp:// outputs (default ~/.ssh/known_hosts)")
+
+	// Build matrix
+	cmd.Flags().StringVar(&config.BuildMatrixPath, "build-matrix", "", "YAML/JSON file describing binaries built for several targets, packaged as .deb/.rpm/.apk/.pkg.tar.zst before generation (--input-dir is then only scanned when given)")
+	cmd.Flags().StringVar(&config.BuildVersion, "build-version", "", "Version of the packages built from --build-matrix, instead of the version in the file")
+
+	// Remote packages
+	cmd.Flags().StringVar(&config.InputManifestPath, "input-manifest", "", "File listing package URLs to download before generation, one per line with an optional SHA-256, or YAML/JSON entries with url and sha256 (--input-dir is then only scanned when given)")
+	cmd.Flags().IntVar(&config.FetchConcurrency, "fetch-concurrency", fetch.DefaultConcurrency, "Packages of --input-manifest downloaded at once")
+	cmd.Flags().StringVar(&config.FetchCacheDir, "fetch-cache", defaultFetchCache(), "Directory keeping packages downloaded from --input-manifest across runs (empty for none)")
+
+	// Package filters
+	addFilterFlags(cmd, config)
+
+	// Incremental mode
+	cmd.Flags().BoolVar(&config.Incremental, "incremental", false, "Add new packages to existing repository without removing existing ones")
+	cmd.Flags().BoolVar(&config.ParseCache, "parse-cache", true, "Reuse the metadata of packages unchanged since the previous run, cached in "+parsecache.Path)
+
+	// Deadlines
+	cmd.Flags().DurationVar(&config.Timeout, "timeout", 0, "Abort the whole run after this long (e.g. 30m, 0 for no limit)")
+	cmd.Flags().DurationVar(&config.ScanTimeout, "scan-timeout", 0, "Abort if scanning the input directory takes longer than this")
+	cmd.Flags().DurationVar(&config.ParseTimeout, "parse-timeout", 0, "Abort if reading package metadata takes longer than this")
+	cmd.Flags().DurationVar(&config.PublishTimeout, "publish-timeout", 0, "Abort if writing the repository takes longer than this, removing the files it wrote")
+
+	// Monitoring
+	cmd.Flags().StringVar(&config.MetricsFile, "metrics-file", "", "Write the metrics and trace spans of the run to this JSON file")
+	cmd.Flags().StringVar(&config.MetricsPush, "metrics-push", "", "Push the metrics of the run to this Prometheus Pushgateway (e.g. http://pushgateway:9091)")
+	cmd.Flags().StringVar(&config.OTLPEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "Export the trace of the run to this OpenTelemetry collector over OTLP/HTTP (e.g. http://collector:4318)")
+	cmd.Flags().BoolVar(&config.NoProgress, "no-progress", false, "Don't report the progress of the run, on a bar redrawn below the logs on terminals or in a log line every 30s otherwise")
+}
+
+// addFilterFlags registers the flags selecting the packages published
+func addFilterFlags(cmd *cobra.Command, config *models.RepositoryConfig) {
+	cmd.Flags().StringSliceVar(&config.Include, "include", nil, "Only scan files whose name matches one of these globs (e.g. '*_amd64.deb'; globs with a / match the path below --input-dir)")
+	cmd.Flags().StringSliceVar(&config.Exclude, "exclude", nil, "Skip files whose name matches one of these globs (e.g. '*-dbgsym*')")
+	cmd.Flags().StringSliceVar(&config.OnlyArches, "only-arch", nil, "Only publish packages for these architectures (arch-independent packages are always kept)")
+	cmd.Flags().StringSliceVar(&config.OnlyPackages, "only-package", nil, "Only publish packages whose name matches one of these globs (e.g. 'myapp-*')")
+	cmd.Flags().StringVar(&config.MinVersion, "min-version", "", "Only publish packages at or above this version")
+	cmd.Flags().StringVar(&config.MaxVersion, "max-version", "", "Only publish packages at or below this version")
+}
+
+// addRepositoryFlags registers the flags describing the repository itself,
+// shared by every command that (re)generates metadata
+func addRepositoryFlags(cmd *cobra.Command, config *models.RepositoryConfig) {
+	// Output flags
 	cmd.Flags().StringVarP(&config.OutputDir, "output-dir", "o", "./repo", "Output directory")
+	cmd.Flags().BoolVar(&config.IndexPages, "index-pages", false, "Write index.html pages listing the packages and files of every directory, for static hosts without directory listings (S3, GitHub Pages)")
+	cmd.Flags().BoolVar(&config.Checksums, "checksums", false, "Write a SHA256SUMS of every package file at the repository root, signed into SHA256SUMS.gpg with the GPG key, for direct downloads")
+	cmd.Flags().BoolVar(&config.ChecksumFiles, "checksum-files", false, "With --checksums, also write a <file>.sha256 next to every package file")
+
+	// Large file flags
+	cmd.Flags().IntVar(&config.LargeFileSize, "large-file-size", 0, "Write download aids next to package files of at least this many MiB (0: none)")
+	cmd.Flags().StringSliceVar(&config.LargeFileAids, "large-file-aids", []string{largefiles.AidZsync, largefiles.AidMetalink}, "Download aids of large package files: zsync, metalink (.meta4 listing --base-url and --mirror-url) and torrent (web seeded from them)")
+	cmd.Flags().StringSliceVar(&config.MirrorURLs, "mirror-url", nil, "URL of a mirror of the repository, listed in metalinks and seeding torrents after --base-url")
+	cmd.Flags().StringSliceVar(&config.TorrentTrackers, "torrent-tracker", nil, "Tracker announce URL of the torrents of large package files")
 
 	// GPG signing flags (for Debian/RPM)
 	cmd.Flags().StringVarP(&config.GPGKeyPath, "gpg-key", "k", "", "Path to GPG private key")
+	cmd.Flags().StringVar(&config.GPGKeyID, "gpg-key-id", "", "Fingerprint of a key in your GPG keyring to sign with through gpg-agent, instead of --gpg-key")
+	cmd.Flags().StringVar(&config.PKCS11URI, "pkcs11-uri", "", "PKCS#11 URI of an RSA key on a hardware token to sign with through pkcs11-tool, instead of --gpg-key (e.g. 'pkcs11:token=YubiKey;object=release?module-path=/usr/lib/libykcs11.so')")
+	cmd.Flags().StringVar(&config.KMSKeyARN, "kms-key-arn", "", "AWS KMS RSA or ECDSA signing key (ARN, key ID or alias) to sign with through the aws CLI, instead of --gpg-key")
+	cmd.Flags().StringVar(&config.KMSKeyARN, "kms-key-id", "", "Same as --kms-key-arn")
+	cmd.Flags().MarkDeprecated("kms-key-id", "use --kms-key-arn")
 	cmd.Flags().StringVarP(&config.GPGPassphrase, "gpg-passphrase", "p", "", "GPG key passphrase")
+	cmd.Flags().BoolVar(&config.SignRPMs, "sign-rpms", false, "Embed GPG signatures into RPM packages (like rpmsign --addsign), required by gpgcheck=1")
+	cmd.Flags().IntVar(&config.KeyExpiryDays, "key-expiry-warning", 30, "Warn when the GPG signing key expires within this many days")
+	cmd.Flags().BoolVar(&config.StrictKeys, "strict", false, "Fail instead of warning when a signing key is expired, about to expire, weak or can't sign")
 
-	// RSA signing flags (for Alpine)
-	cmd.Flags().StringVar(&config.RSAKeyPath, "rsa-key", "", "Path to RSA private key (for Alpine)")
+	// Sigstore signing flags (for Debian, RPM and Alpine metadata)
+	cmd.Flags().BoolVar(&config.Cosign, "cosign", false, "Also sign Release, repomd.xml, APKINDEX.tar.gz and SHA256SUMS with cosign into .sig files, keyless with a .pem certificate unless --cosign-key is set")
+	cmd.Flags().StringVar(&config.CosignKey, "cosign-key", "", "cosign private key or KMS URI to sign with instead of keyless signing (its password is read from COSIGN_PASSWORD)")
+	cmd.Flags().StringVar(&config.RekorURL, "rekor-url", "", "Rekor transparency log recording the cosign signatures (default: the public instance)")
+	cmd.Flags().BoolVar(&config.CosignNoTlog, "cosign-no-tlog", false, "Don't record the cosign signatures in the Rekor transparency log")
+
+	// Minisign signing flags (for Debian, RPM and Alpine metadata)
+	cmd.Flags().StringVar(&config.MinisignKey, "minisign-key", "", "Also sign Release, repomd.xml, APKINDEX.tar.gz and SHA256SUMS with this minisign or unencrypted signify/usign secret key into .minisig files")
+	cmd.Flags().StringVar(&config.MinisignPass, "minisign-passphrase", "", "minisign key passphrase")
+
+	// RSA signing flags (for Alpine, F-Droid and xbps)
+	cmd.Flags().StringVar(&config.RSAKeyPath, "rsa-key", "", "Path to RSA private key (for Alpine, F-Droid and xbps)")
 	cmd.Flags().StringVar(&config.RSAPassphrase, "rsa-passphrase", "", "RSA key passphrase")
-	cmd.Flags().StringVar(&config.RSAKeyName, "key-name", "repogen", "Key name for Alpine signatures")
+	cmd.Flags().StringVar(&config.RSAKeyName, "key-name", "repogen", "Key name for Alpine signatures and the F-Droid repository certificate")
 
 	// Repository metadata flags
 	cmd.Flags().StringVar(&config.Origin, "origin", "", "Repository origin name")
 	cmd.Flags().StringVar(&config.Label, "label", "", "Repository label")
 	cmd.Flags().StringVar(&config.RepoName, "repo-name", "", "Repository name for Pacman database files and optional RPM .repo file naming")
-	cmd.Flags().StringVar(&config.Codename, "codename", "stable", "Codename for Debian repos")
-	cmd.Flags().StringVar(&config.Suite, "suite", "", "Suite for Debian repos (defaults to codename)")
+	cmd.Flags().StringVar(&config.Codename, "codename", "stable", "Codename for Debian repos, comma-separated to publish several suites sharing the pool (e.g. bookworm,trixie)")
+	cmd.Flags().StringVar(&config.Suite, "suite", "", "Suite for Debian repos (defaults to codename, single codename only)")
 	cmd.Flags().StringSliceVar(&config.Components, "components", []string{"main"}, "Components for Debian repos")
+	cmd.Flags().StringToStringVar(&config.ComponentMap, "component-map", nil, "Debian component of the packages of each input subdirectory (e.g. nonfree=non-free); subdirectories named after a component go to it, other packages to the first component")
+	cmd.Flags().StringSliceVar(&config.AptClients, "apt-clients", deb.DefaultAptClients, fmt.Sprintf("apt client releases that must accept the Debian repository signature, checked before publishing (known: %s; empty to skip)", strings.Join(deb.AptClientNames(), ", ")))
 	cmd.Flags().StringSliceVar(&config.Arches, "arch", []string{"amd64"}, "Architectures to support")
+	cmd.Flags().StringVar(&config.ReleaseValidFor, "release-valid-for", "", "Set the Valid-Until of Debian Release files this long after their date (e.g. 14d, 36h), the repository having to be regenerated before then")
+	cmd.Flags().BoolVar(&config.NotAutomatic, "not-automatic", false, "Mark Debian repositories NotAutomatic, apt only installing from them when asked to (e.g. experimental)")
+	cmd.Flags().BoolVar(&config.ButAutomaticUpgrades, "but-automatic-upgrades", false, "With --not-automatic, still upgrade packages installed from the Debian repository (e.g. backports)")
+	cmd.Flags().BoolVar(&config.AcquireByHash, "acquire-by-hash", false, "Also publish Debian indexes under by-hash/, so apt never mixes files of two generations")
 
 	// Type-specific options
-	cmd.Flags().StringVar(&config.BaseURL, "base-url", "", "Base URL for Homebrew bottles and RPM .repo files")
-	cmd.Flags().StringVar(&config.GPGKeyURL, "gpg-key-url", "", "GPG key URL for RPM .repo files (supports $releasever/$basearch variables)")
-	cmd.Flags().StringVar(&config.DistroVariant, "distro", "fedora", "Distribution variant for RPM repos (fedora, centos, rhel)")
+	cmd.Flags().StringVar(&config.BaseURL, "base-url", "", "Base URL for Homebrew bottles, client setup files (apt sources, RPM .repo files, install.sh), the Cargo registry config.json, the NuGet service index and the F-Droid repository address")
+	cmd.Flags().StringVar(&config.BottleRootURL, "bottle-root-url", "", "URL Homebrew bottles are downloaded from, the root_url of formula bottle blocks (defaults to <base-url>/bottles)")
+	cmd.Flags().StringVar(&config.BottleCollisions, "bottle-collisions", homebrew.CollisionError, "What to do when a Homebrew bottle would replace a published bottle with different content: error, or digest to publish the formula under bottles/<sha256>/")
+	cmd.Flags().StringVar(&config.GPGKeyURL, "gpg-key-url", "", "GPG key URL for RPM .repo files (supports $releasever/$basearch variables; defaults to the key published under keys/)")
+	cmd.Flags().StringVar(&config.DistroVariant, "distro", "fedora", "Distribution variant for RPM repos (fedora, centos, rhel, suse for zypper clients)")
 	cmd.Flags().StringVar(&config.Version, "version", "", "Release version for RPM repos (e.g., 40 for Fedora 40). Auto-detected from RPM metadata if not provided")
+	cmd.Flags().StringVar(&config.RPMGroupsPath, "rpm-groups", "", "YAML/JSON file describing package groups/environments, published as comps.xml for RPM repos")
+	cmd.Flags().StringVar(&config.RPMAdvisoriesPath, "rpm-advisories", "", "YAML/JSON file describing advisories (errata), published as updateinfo.xml for RPM repos")
+	cmd.Flags().BoolVar(&config.RPMSQLite, "rpm-sqlite", false, "Also publish the primary, filelists and other sqlite databases for RPM repos, read by yum and older tooling instead of the XML metadata")
+	cmd.Flags().BoolVar(&config.RPMZchunk, "rpm-zchunk", false, "Also publish zchunk (.zck) copies of RPM metadata, so dnf only downloads what changed since its last refresh")
+	cmd.Flags().StringVar(&config.DebLayout, "deb-layout", deb.LayoutPool, "Layout of the Debian repository: pool (dists/ and pool/), or flat for a \"deb URL ./\" repository with Packages and Release at the root")
+	cmd.Flags().StringVar(&config.RPMNoarch, "rpm-noarch", rpm.NoarchMerge, "Where noarch RPMs are published: merge to list them in the repository of every architecture, or repo for their own noarch repository, enabled next to $basearch in the .repo file")
+	cmd.Flags().StringVar(&config.PacmanLayout, "pacman-layout", pacman.LayoutArch, "Layout of the Pacman repository: arch (a directory per architecture), or pool to keep packages once under pool/, symlinked into os/<arch>/")
+	cmd.Flags().StringVar(&config.PacmanLinks, "pacman-links", pacman.LinkSymlink, "How myrepo.db, myrepo.files and pooled Pacman packages point at their files: symlink like repo-add, or copy for storage without symlinks")
+	cmd.Flags().StringVar(&config.DBCompression, "db-compression", "", "Compression of Pacman databases (default zstd) and Debian Packages indexes (default gz, next to the uncompressed one): zstd, xz, gz or none")
+	cmd.Flags().IntVar(&config.CompressionLevel, "db-compression-level", 0, "Level of --db-compression (1-9 for gz and xz, 1-22 for zstd; 0 for the default)")
+	cmd.Flags().StringVar(&config.RPMLayout, "layout", rpm.DefaultLayout, "Template of the RPM repository directories, from {{.Distro}}, {{.Version}} and {{.Arch}} (\".\" for a flat repository)")
 
-	// Incremental mode
-	cmd.Flags().BoolVar(&config.Incremental, "incremental", false, "Add new packages to existing repository without removing existing ones")
+	// Package overrides
+	cmd.Flags().StringVar(&config.OverridesPath, "overrides", "", "YAML/JSON file with per-package overrides (e.g. deprecation flags)")
+	cmd.Flags().StringVar(&config.TranslationsPath, "translations", "", "YAML/JSON file with localized package descriptions, keyed by language then package name")
 
-	return cmd
+	// Package conflicts
+	cmd.Flags().StringVar(&config.OnConflict, "on-conflict", conflict.PolicyFail, "What to do with a package whose name, version and architecture are already scanned or published: fail, skip (keep the first), replace (keep the last) or keep-both")
+
+	// Vulnerability gate
+	cmd.Flags().StringVar(&config.VulnPolicy, "vuln-policy", vuln.PolicyIgnore, "What to do when packages to publish have known vulnerabilities: fail, warn or ignore (don't check)")
+	cmd.Flags().StringVar(&config.VulnReport, "vuln-report", "", "grype or trivy JSON report to check packages against, instead of querying the OSV API")
+	cmd.Flags().StringVar(&config.OSVURL, "osv-url", vuln.DefaultOSVURL, "OSV API queried for the vulnerabilities of Debian, Alpine, PyPI, RubyGems, Cargo and NuGet packages")
+
+	// Dependency check
+	cmd.Flags().StringVar(&config.DepsPolicy, "deps-policy", depcheck.PolicyIgnore, "What to do when Debian, RPM, Alpine, Pacman or XBPS packages depend on packages the repository lacks: fail, warn or ignore (don't check)")
+	cmd.Flags().StringSliceVar(&config.DepsAllow, "deps-allow", nil, "Globs of package names provided outside the repository, e.g. by the distribution (e.g. libc6,lib*)")
+
+	// Integrity
+	cmd.Flags().Var((*timestampValue)(&config.Timestamp), "timestamp", "Time recorded in the metadata instead of now, in seconds since the epoch or RFC 3339, for reproducible output (default $SOURCE_DATE_EPOCH)")
+	cmd.Flags().DurationVar(&config.LockWait, "wait", 0, "Wait this long for another run writing the output directory to finish (e.g. 5m), instead of failing at once")
+	cmd.Flags().BoolVar(&config.VerifyWrites, "verify-writes", false, "Re-hash each copied package and compare it to the source before referencing it in metadata, and check the files uploaded to --output (default true with --output)")
+
+	// Parallelism
+	cmd.Flags().IntVar(&config.Concurrency, "concurrency", generator.DefaultConcurrency, "Architectures and package types generated at once (1 to generate them one after the other)")
+}
+
+// timestampValue is a flag holding a time given like SOURCE_DATE_EPOCH
+type timestampValue time.Time
+
+func (v *timestampValue) String() string {
+	if t := time.Time(*v); !t.IsZero() {
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+	return ""
+}
+
+func (v *timestampValue) Set(value string) error {
+	t, err := utils.ParseTimestamp(value)
+	if err != nil {
+		return err
+	}
+	*v = timestampValue(t)
+	return nil
+}
+
+func (v *timestampValue) Type() string {
+	return "time"
 }
 
 func validateConfig(config *models.RepositoryConfig) error {
-	if config.InputDir == "" {
+	if config.InputDir == "" && config.BuildMatrixPath == "" && config.InputManifestPath == "" {
 		return &models.RepoGenError{
 			Type: models.ErrInvalidConfig,
 			Err:  fmt.Errorf("input-dir is required"),
 		}
 	}
 
-	if config.OutputDir == "" {
+	if err := validateRepositoryConfig(config); err != nil {
+		return err
+	}
+
+	if sftp.IsURL(config.Output) {
+		if _, err := sftp.ParseURL(config.Output); err != nil {
+			return &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err:  fmt.Errorf("invalid --output: %w", err),
+			}
+		}
+	} else if objstore.IsURL(config.Output) {
+		if _, err := objstore.Open(config.Output); err != nil {
+			return &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err:  fmt.Errorf("invalid --output: %w", err),
+			}
+		}
+	} else if config.Output != "" {
+		if _, err := oci.ParseReference(config.Output); err != nil {
+			return &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err:  fmt.Errorf("invalid --output: %w", err),
+			}
+		}
+	}
+
+	for _, timeout := range []time.Duration{config.Timeout, config.ScanTimeout, config.ParseTimeout, config.PublishTimeout} {
+		if timeout < 0 {
+			return &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err:  fmt.Errorf("timeouts must not be negative"),
+			}
+		}
+	}
+
+	if err := filter.Validate(config); err != nil {
 		return &models.RepoGenError{
 			Type: models.ErrInvalidConfig,
-			Err:  fmt.Errorf("output-dir is required"),
+			Err:  err,
 		}
 	}
 
-	// Set Suite to Codename if not specified
-	if config.Suite == "" {
-		config.Suite = config.Codename
+	for flag, value := range map[string]string{"metrics-push": config.MetricsPush, "otlp-endpoint": config.OTLPEndpoint} {
+		if value == "" {
+			continue
+		}
+		if err := telemetry.ValidateURL(flag, value); err != nil {
+			return &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err:  err,
+			}
+		}
 	}
 
-	// Set default origin/label if not specified
-	if config.Origin == "" {
-		config.Origin = "Repogen Repository"
-	}
-	if config.Label == "" {
-		config.Label = config.Origin
+	var matrixPacman bool
+	if config.BuildMatrixPath != "" {
+		matrix, err := loadBuildMatrix(config)
+		if err != nil {
+			return err
+		}
+		for _, format := range matrix.Formats {
+			matrixPacman = matrixPacman || format == "pacman"
+		}
 	}
 
-	// Validate GPG key URL requirement for RPM .repo files
-	if config.BaseURL != "" && config.GPGKeyPath != "" && config.GPGKeyURL == "" {
-		return &models.RepoGenError{
-			Type: models.ErrInvalidConfig,
-			Err: fmt.Errorf("--gpg-key-url is required when both --base-url and --gpg-key are specified for signed RPM .repo files\n" +
-				"Example: --gpg-key-url 'https://example.com/repo/$releasever/$basearch/RPM-GPG-KEY-myrepo'"),
+	var remotePacman bool
+	if config.InputManifestPath != "" {
+		entries, err := loadInputManifest(config)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			remotePacman = remotePacman || strings.Contains(entry.FileName(), ".pkg.tar.")
+		}
+		if config.FetchConcurrency <= 0 {
+			return &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err:  fmt.Errorf("--fetch-concurrency must be positive"),
+			}
 		}
 	}
 
 	// Validate repo-name requirement for Pacman repositories
-	if hasPacmanPackages(config.InputDir) && config.RepoName == "" {
+	if (config.InputDir != "" && hasPacmanPackages(config.InputDir) || matrixPacman || remotePacman) && config.RepoName == "" {
 		return &models.RepoGenError{
 			Type: models.ErrInvalidConfig,
 			Err:  fmt.Errorf("--repo-name is required for Pacman (Arch Linux) repository generation"),
@@ -125,173 +405,1367 @@ func validateConfig(config *models.RepositoryConfig) error {
 	return nil
 }
 
-func runGeneration(ctx context.Context, config *models.RepositoryConfig) error {
-	// Step 1: Scan for packages
-	logrus.Infof("Scanning directory: %s", config.InputDir)
-	sc := scanner.NewFileSystemScanner()
-	scannedPackages, err := sc.Scan(ctx, config.InputDir)
-	if err != nil {
+// validateRepositoryConfig validates the flags registered by addRepositoryFlags
+// and fills in defaults
+func validateRepositoryConfig(config *models.RepositoryConfig) error {
+	if config.OutputDir == "" {
 		return &models.RepoGenError{
-			Type: models.ErrFileOp,
-			Err:  fmt.Errorf("failed to scan directory: %w", err),
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("output-dir is required"),
+		}
+	}
+	if isRemoteOutput(config.OutputDir) {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("sftp://, gs:// and az:// output directories are only supported by generate"),
 		}
 	}
 
-	if len(scannedPackages) == 0 {
-		logrus.Warn("No packages found in input directory")
-		return nil
+	if err := deb.ValidateCodenames(config.Codename, config.Suite, config.DebLayout); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  err,
+		}
 	}
 
-	logrus.Infof("Found %d packages", len(scannedPackages))
+	if err := vuln.ValidatePolicy(config.VulnPolicy); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  err,
+		}
+	}
+	if err := conflict.ValidatePolicy(config.OnConflict); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  err,
+		}
+	}
+	if err := depcheck.ValidatePolicy(config.DepsPolicy); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  err,
+		}
+	}
 
-	// Step 2: Parse packages by type
-	packagesByType := make(map[scanner.PackageType][]models.Package)
+	if !config.Cosign && (config.CosignKey != "" || config.RekorURL != "" || config.CosignNoTlog) {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("--cosign-key, --rekor-url and --cosign-no-tlog require --cosign"),
+		}
+	}
 
-	for _, scanned := range scannedPackages {
-		var pkg *models.Package
-		var parseErr error
-
-		logrus.Debugf("Parsing %s package: %s", scanned.Type, scanned.Path)
-
-		switch scanned.Type {
-		case scanner.TypeDeb:
-			pkg, parseErr = deb.ParsePackage(scanned.Path)
-		case scanner.TypeRpm:
-			pkg, parseErr = rpm.ParsePackage(scanned.Path)
-		case scanner.TypeApk:
-			pkg, parseErr = apk.ParsePackage(scanned.Path)
-		case scanner.TypePacman:
-			pkg, parseErr = pacman.ParsePackage(scanned.Path)
-		case scanner.TypeHomebrewBottle:
-			// Homebrew bottles don't need parsing, use basic info
-			pkg = &models.Package{
-				Filename: scanned.Path,
-				Size:     scanned.Size,
-			}
-			// Calculate checksums
-			checksums, csErr := utils.CalculateChecksums(scanned.Path)
-			if csErr == nil {
-				pkg.SHA256Sum = checksums.SHA256
+	if config.LargeFileSize < 0 {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("--large-file-size must not be negative"),
+		}
+	}
+	if err := largefiles.ValidateAids(config.LargeFileAids); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  err,
+		}
+	}
+	if config.LargeFileSize > 0 && config.BaseURL == "" && len(config.MirrorURLs) == 0 {
+		for _, aid := range config.LargeFileAids {
+			if aid == largefiles.AidMetalink || (aid == largefiles.AidTorrent && len(config.TorrentTrackers) == 0) {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("%s download aids need --base-url or --mirror-url to list where to download files", aid),
+				}
 			}
-		default:
-			logrus.Warnf("Unknown package type: %s", scanned.Type)
-			continue
 		}
+	}
 
-		if parseErr != nil {
-			logrus.Warnf("Failed to parse %s: %v", scanned.Path, parseErr)
-			continue
+	if config.ChecksumFiles && !config.Checksums {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("--checksum-files requires --checksums"),
 		}
+	}
 
-		packagesByType[scanned.Type] = append(packagesByType[scanned.Type], *pkg)
+	if config.MinisignPass != "" && config.MinisignKey == "" {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("--minisign-passphrase requires --minisign-key"),
+		}
 	}
 
-	// Step 3: Initialize signers
-	var gpgSigner signer.Signer
-	var rsaSigner signer.RSASigner
+	// Set Suite to Codename if not specified, several suites being named after their codename
+	if config.Suite == "" && len(deb.Codenames(config.Codename)) == 1 {
+		config.Suite = config.Codename
+	}
 
-	if config.GPGKeyPath != "" {
-		gpgSigner, err = signer.NewGPGSigner(config.GPGKeyPath, config.GPGPassphrase)
+	// Set default origin/label if not specified
+	if config.Origin == "" {
+		config.Origin = "Repogen Repository"
+	}
+	if config.Label == "" {
+		config.Label = config.Origin
+	}
+
+	// Reproducible builds set SOURCE_DATE_EPOCH for every tool they run
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" && config.Timestamp.IsZero() {
+		t, err := utils.ParseTimestamp(epoch)
 		if err != nil {
 			return &models.RepoGenError{
-				Type: models.ErrSigning,
-				Err:  fmt.Errorf("failed to initialize GPG signer: %w", err),
+				Type: models.ErrInvalidConfig,
+				Err:  fmt.Errorf("invalid SOURCE_DATE_EPOCH: %w", err),
 			}
 		}
-		logrus.Info("GPG signer initialized")
+		config.Timestamp = t
 	}
 
-	if config.RSAKeyPath != "" {
-		rsaSigner, err = signer.NewAlpineRSASigner(config.RSAKeyPath, config.RSAPassphrase)
-		if err != nil {
-			return &models.RepoGenError{
-				Type: models.ErrSigning,
-				Err:  fmt.Errorf("failed to initialize RSA signer: %w", err),
-			}
+	gpgKeySources := 0
+	for _, source := range []string{config.GPGKeyPath, config.GPGKeyID, config.PKCS11URI, config.KMSKeyARN} {
+		if source != "" {
+			gpgKeySources++
+		}
+	}
+	if gpgKeySources > 1 {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("--gpg-key, --gpg-key-id, --pkcs11-uri and --kms-key-arn are mutually exclusive"),
 		}
-		logrus.Info("RSA signer initialized")
 	}
+	gpgSigning := gpgKeySources > 0
 
-	// Step 4: Generate repositories for each type
-	generators := make(map[scanner.PackageType]generator.Generator)
-	generators[scanner.TypeDeb] = deb.NewGenerator(gpgSigner)
-	generators[scanner.TypeRpm] = rpm.NewGenerator(gpgSigner)
-	generators[scanner.TypeApk] = apk.NewGenerator(rsaSigner, config.RSAKeyName)
-	generators[scanner.TypePacman] = pacman.NewGenerator(gpgSigner)
-	generators[scanner.TypeHomebrewBottle] = homebrew.NewGenerator(config.BaseURL)
+	if config.SignRPMs && !gpgSigning {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("--sign-rpms requires --gpg-key, --gpg-key-id, --pkcs11-uri or --kms-key-arn"),
+		}
+	}
 
-	for pkgType, newPackages := range packagesByType {
-		gen, ok := generators[pkgType]
-		if !ok {
-			logrus.Warnf("No generator for package type: %s", pkgType)
-			continue
+	if err := deb.ValidateAptClients(config.AptClients); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  err,
 		}
+	}
 
-		var finalPackages []models.Package
+	if err := deb.ValidateComponentMap(config.ComponentMap, config.Components); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  err,
+		}
+	}
 
-		if config.Incremental {
-			logrus.Infof("Incremental mode: parsing existing %s metadata...", pkgType)
+	if config.ReleaseValidFor != "" {
+		if _, err := deb.ParseValidity(config.ReleaseValidFor); err != nil {
+			return &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err:  err,
+			}
+		}
+	}
 
-			// Parse existing packages from metadata
-			existingPackages, err := gen.ParseExistingMetadata(config)
-			if err != nil {
-				logrus.Warnf("Could not parse existing metadata for %s: %v. Falling back to normal mode.", pkgType, err)
-				finalPackages = newPackages
-			} else {
-				logrus.Infof("Found %d existing %s packages", len(existingPackages), pkgType)
+	if config.ButAutomaticUpgrades && !config.NotAutomatic {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("--but-automatic-upgrades requires --not-automatic"),
+		}
+	}
 
-				// Detect conflicts
-				conflicts := utils.DetectConflicts(existingPackages, newPackages, pkgType)
-				if len(conflicts) > 0 {
-					var conflictNames []string
-					for _, pkg := range conflicts {
-						conflictNames = append(conflictNames, fmt.Sprintf("%s-%s-%s", pkg.Name, pkg.Version, pkg.Architecture))
-					}
-					return &models.RepoGenError{
-						Type: models.ErrInvalidConfig,
-						Err: fmt.Errorf("incremental mode: %d package(s) already exist in repository: %s",
-							len(conflicts), strings.Join(conflictNames, ", ")),
-					}
-				}
+	if err := deb.ValidateLayout(config.DebLayout); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  err,
+		}
+	}
 
-				// Combine existing + new packages
-				finalPackages = append(existingPackages, newPackages...)
-				logrus.Infof("Combining %d existing + %d new = %d total %s packages",
-					len(existingPackages), len(newPackages), len(finalPackages), pkgType)
-			}
-		} else {
-			// Normal mode: only new packages
-			finalPackages = newPackages
+	if err := rpm.ValidateLayout(config.RPMLayout); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  err,
 		}
+	}
 
-		if len(finalPackages) == 0 {
-			logrus.Warn("No packages to process")
-			continue
+	if err := rpm.ValidateNoarchMode(config.RPMNoarch); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  err,
 		}
+	}
 
-		logrus.Infof("Generating %s repository with %d packages...", pkgType, len(finalPackages))
+	if err := pacman.ValidateLayout(config.PacmanLayout); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  err,
+		}
+	}
 
-		if err := gen.ValidatePackages(finalPackages); err != nil {
-			return &models.RepoGenError{
-				Type: models.ErrInvalidConfig,
-				Err:  fmt.Errorf("package validation failed for %s: %w", pkgType, err),
-			}
+	if err := pacman.ValidateLinkMode(config.PacmanLinks); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  err,
 		}
+	}
 
-		if err := gen.Generate(ctx, config, finalPackages); err != nil {
-			return &models.RepoGenError{
-				Type: models.ErrMetadataGen,
-				Err:  fmt.Errorf("failed to generate %s repository: %w", pkgType, err),
-			}
+	if err := utils.ValidateCompression(config.DBCompression, config.CompressionLevel); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  err,
 		}
 	}
 
-	logrus.Info("Repository generation completed successfully!")
-	logrus.Infof("Output directory: %s", config.OutputDir)
+	if err := homebrew.ValidateCollisionPolicy(config.BottleCollisions); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  err,
+		}
+	}
 
 	return nil
 }
 
+func runGeneration(ctx context.Context, config *models.RepositoryConfig, cache *packageCache) error {
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	unlock, err := lockOutput(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Incremental runs start from the repository published in the registry
+	// or on the server
+	if config.Output != "" && config.Incremental {
+		if err := pullOutput(ctx, config); err != nil {
+			return err
+		}
+	}
+
+	var inputDirs []string
+	if config.InputDir != "" {
+		inputDirs = append(inputDirs, config.InputDir)
+	}
+
+	// Step 0: Package the binaries of the build matrix
+	if config.BuildMatrixPath != "" {
+		builtDir, err := buildMatrixPackages(config)
+		if builtDir != "" {
+			defer os.RemoveAll(builtDir)
+		}
+		if err != nil {
+			return err
+		}
+		inputDirs = append(inputDirs, builtDir)
+	}
+
+	// Step 0: Download the packages of the input manifest
+	if config.InputManifestPath != "" {
+		fetchedDir, err := fetchPackages(ctx, config)
+		if fetchedDir != "" {
+			defer os.RemoveAll(fetchedDir)
+		}
+		if err != nil {
+			return err
+		}
+		inputDirs = append(inputDirs, fetchedDir)
+	}
+
+	// Step 1: Scan for packages, unless another repository of the run already did
+	progress.Phase(ctx, "scan", "", 0)
+	scannedByDir := make(map[string][]scanner.ScannedPackage)
+	found := 0
+	err = runPhase(ctx, "scan", config.ScanTimeout, func(ctx context.Context) error {
+		for _, dir := range inputDirs {
+			if cached, ok := cache.lookup(config, dir); ok {
+				logrus.Infof("Reusing the packages parsed from %s", dir)
+				for _, pkgs := range cached {
+					found += len(pkgs)
+				}
+				continue
+			}
+			logrus.Infof("Scanning directory: %s", dir)
+			scanned, err := scanner.NewFileSystemScanner(config.Include, config.Exclude).Scan(ctx, dir)
+			if err != nil {
+				return err
+			}
+			scannedByDir[dir] = scanned
+			found += len(scanned)
+		}
+		return nil
+	})
+	if err != nil {
+		var repoErr *models.RepoGenError
+		if errors.As(err, &repoErr) {
+			return err
+		}
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("failed to scan directory: %w", err),
+		}
+	}
+
+	if found == 0 {
+		logrus.Warn("No packages found in input directory")
+		return nil
+	}
+
+	logrus.Infof("Found %d packages", found)
+
+	// Step 2: Parse packages by type, skipping those unchanged since the last run
+	parseCache := openParseCache(config)
+	progress.Phase(ctx, "parse", "packages", found)
+	packagesByType := make(map[scanner.PackageType][]models.Package)
+	err = runPhase(ctx, "parse", config.ParseTimeout, func(ctx context.Context) error {
+		for _, dir := range inputDirs {
+			parsed, ok := cache.lookup(config, dir)
+			if !ok {
+				var err error
+				parsed, err = parsePackages(ctx, scannedByDir[dir], parseCache)
+				if err != nil {
+					return err
+				}
+				cache.store(config, dir, parsed)
+			}
+			for pkgType, pkgs := range parsed {
+				packagesByType[pkgType] = append(packagesByType[pkgType], pkgs...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := parseCache.Save(); err != nil {
+		logrus.Warnf("Failed to save the parse cache: %v", err)
+	}
+
+	// Steps 3 and 4 write to the output directory: if they can't complete
+	// in time, remove what they wrote rather than leave a half-published repository
+	snapshot, err := snapshotOutput(config.OutputDir)
+	if err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  err,
+		}
+	}
+	err = runPhase(ctx, "publish", config.PublishTimeout, func(ctx context.Context) error {
+		return publishPackages(ctx, config, packagesByType)
+	})
+	if err != nil {
+		if ctx.Err() != nil || isTimeout(err) {
+			snapshot.removeNew()
+		}
+		return err
+	}
+
+	logrus.Info("Repository generation completed successfully!")
+	logrus.Infof("Output directory: %s", config.OutputDir)
+
+	if config.Output != "" {
+		return pushOutput(ctx, config)
+	}
+	return nil
+}
+
+// writeReport writes the report of the generation started at start, for CI
+// pipelines to read the results from
+func writeReport(config *models.RepositoryConfig, start time.Time) error {
+	files, err := report.Written(config.OutputDir, start)
+	if err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  err,
+		}
+	}
+
+	// The packages now published, from the manifest of the repository
+	packages := make(map[string]int)
+	if m, err := manifest.Read(config.OutputDir); err == nil {
+		for _, entry := range m.Packages {
+			packages[entry.Type]++
+		}
+	}
+
+	if err := report.Write(config.OutputDir, &report.Report{
+		GeneratedAt:    utils.Timestamp(config),
+		RepogenVersion: buildVersion,
+		OutputDir:      config.OutputDir,
+		Packages:       packages,
+		Files:          files,
+	}); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  err,
+		}
+	}
+	logrus.Infof("Report written to %s", filepath.Join(config.OutputDir, filepath.FromSlash(report.Path)))
+	return nil
+}
+
+// lockOutput locks the output directory against other runs, for --wait at
+// most, and returns the function releasing it
+func lockOutput(ctx context.Context, config *models.RepositoryConfig) (func(), error) {
+	l, err := lock.Acquire(ctx, config.OutputDir, config.LockWait)
+	if err != nil {
+		return nil, &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  err,
+		}
+	}
+	return func() {
+		if err := l.Release(); err != nil {
+			logrus.Warnf("Failed to release the lock of %s: %v", config.OutputDir, err)
+		}
+	}, nil
+}
+
+// pullOutput downloads the repository published at config.Output into the
+// output directory, if there is one
+func pullOutput(ctx context.Context, config *models.RepositoryConfig) error {
+	if sftp.IsURL(config.Output) {
+		return pullSFTP(ctx, config)
+	}
+	if objstore.IsURL(config.Output) {
+		return pullBucket(ctx, config)
+	}
+	ref, err := oci.ParseReference(config.Output)
+	if err != nil {
+		return err
+	}
+
+	n, err := oci.NewClient().Pull(ctx, ref, config.OutputDir)
+	if errors.Is(err, oci.ErrNotFound) {
+		logrus.Infof("%s does not exist yet, starting a new repository", ref)
+		return nil
+	}
+	if err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("failed to pull %s: %w", ref, err),
+		}
+	}
+
+	logrus.Infof("Pulled %d files from %s", n, ref)
+	return nil
+}
+
+// pushOutput uploads the output directory to config.Output, as an artifact
+// oras pull can also restore
+func pushOutput(ctx context.Context, config *models.RepositoryConfig) error {
+	if sftp.IsURL(config.Output) {
+		return pushSFTP(ctx, config)
+	}
+	if objstore.IsURL(config.Output) {
+		return pushBucket(ctx, config)
+	}
+	ref, err := oci.ParseReference(config.Output)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Pushing repository to %s...", ref)
+	client := oci.NewClient()
+	client.Verify = config.VerifyWrites
+	digest, err := client.Push(ctx, ref, config.OutputDir)
+	if err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("failed to push %s: %w", ref, err),
+		}
+	}
+
+	logrus.Infof("Pushed repository to %s (%s)", ref, digest)
+	return nil
+}
+
+// isRemoteOutput tells whether outputDir is the URL of a server or bucket
+// rather than a local directory
+func isRemoteOutput(outputDir string) bool {
+	return sftp.IsURL(outputDir) || objstore.IsURL(outputDir)
+}
+
+// outputStagingDir returns the local directory staging the repository
+// uploaded to rawURL, in the user cache directory
+func outputStagingDir(rawURL string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	scheme, rest, _ := strings.Cut(rawURL, "://")
+	name := utils.Slug(strings.NewReplacer(":", "_", "/", "_").Replace(rest))
+	return filepath.Join(dir, "repogen", scheme, name)
+}
+
+// uploadStages orders the upload of the output directory: packages the
+// manifest lists first, then metadata, then its signatures
+func uploadStages(config *models.RepositoryConfig) ([][]string, error) {
+	files, err := staging.Files(config.OutputDir)
+	if err != nil {
+		return nil, &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  err,
+		}
+	}
+	var packages []string
+	if m, err := manifest.Read(config.OutputDir); err == nil {
+		for _, e := range m.Packages {
+			if e.Path != "" {
+				packages = append(packages, e.Path)
+			}
+		}
+	}
+	stages, err := staging.Stages(config.OutputDir, files, packages)
+	if err != nil {
+		return nil, &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  err,
+		}
+	}
+	return stages, nil
+}
+
+// isTimeout tells whether err is a phase deadline error
+func isTimeout(err error) bool {
+	var repoErr *models.RepoGenError
+	return errors.As(err, &repoErr) && repoErr.Type == models.ErrTimeout
+}
+
+// parsePackages reads the metadata of the scanned packages and groups them by type
+func parsePackages(ctx context.Context, scannedPackages []scanner.ScannedPackage, parseCache *parsecache.Cache) (map[scanner.PackageType][]models.Package, error) {
+	packagesByType := make(map[scanner.PackageType][]models.Package)
+
+	reused := 0
+	for _, scanned := range scannedPackages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		pkg, ok := parseCache.Get(scanned.Path)
+		if ok {
+			logrus.Debugf("Reusing cached %s package: %s", scanned.Type, scanned.Path)
+			reused++
+		} else {
+			logrus.Debugf("Parsing %s package: %s", scanned.Type, scanned.Path)
+			var err error
+			pkg, err = parsePackage(scanned)
+			if err != nil {
+				logrus.Warnf("Failed to parse %s: %v", scanned.Path, err)
+				continue
+			}
+			parseCache.Put(scanned.Path, pkg)
+		}
+		emitPackageParsed(scanned, pkg)
+
+		packagesByType[scanned.Type] = append(packagesByType[scanned.Type], *pkg)
+	}
+
+	if reused > 0 {
+		logrus.Infof("Reused the cached metadata of %d of %d packages", reused, len(scannedPackages))
+	}
+	return packagesByType, nil
+}
+
+// openParseCache returns the parse cache of the output directory, or nil
+// when disabled or unreadable
+func openParseCache(config *models.RepositoryConfig) *parsecache.Cache {
+	if !config.ParseCache {
+		return nil
+	}
+	parseCache, err := parsecache.Open(config.OutputDir)
+	if err != nil {
+		logrus.Warnf("Ignoring the parse cache: %v", err)
+	}
+	return parseCache
+}
+
+// publishPackages filters the parsed packages and generates the repository of each type
+func publishPackages(ctx context.Context, config *models.RepositoryConfig, packagesByType map[scanner.PackageType][]models.Package) error {
+	// Apply package filters, so one input directory can feed differently scoped repositories
+	if filter.Enabled(config) {
+		for pkgType, packages := range packagesByType {
+			matched := filter.Packages(config, pkgType, packages)
+			logrus.Infof("Filters kept %d of %d %s packages", len(matched), len(packages), pkgType)
+			if len(matched) == 0 {
+				delete(packagesByType, pkgType)
+				continue
+			}
+			packagesByType[pkgType] = matched
+		}
+	}
+
+	if err := checkVulnerabilities(ctx, config, packagesByType); err != nil {
+		return err
+	}
+
+	// Load per-package settings
+	settings, err := loadPackageSettings(config)
+	if err != nil {
+		return err
+	}
+
+	// Step 3: Initialize signers and generators
+	keys, err := newSigningKeys(config)
+	if err != nil {
+		return err
+	}
+	generators := keys.generators(config)
+	if _, ok := packagesByType[scanner.TypeDeb]; ok {
+		if err := keys.preflightApt(config); err != nil {
+			return err
+		}
+	}
+
+	// Step 4: Generate the repository of each type, several at once
+	pkgTypes := make([]scanner.PackageType, 0, len(packagesByType))
+	for pkgType := range packagesByType {
+		pkgTypes = append(pkgTypes, pkgType)
+	}
+	sort.Slice(pkgTypes, func(i, j int) bool { return pkgTypes[i] < pkgTypes[j] })
+	progress.Phase(ctx, "publish", "repositories", len(pkgTypes))
+
+	err = generator.ForEach(ctx, generator.Concurrency(config), len(pkgTypes), func(ctx context.Context, i int) error {
+		pkgType := pkgTypes[i]
+		newPackages := packagesByType[pkgType]
+
+		gen, ok := generators[pkgType]
+		if !ok {
+			logrus.Warnf("No generator for package type: %s", pkgType)
+			return nil
+		}
+
+		var existingPackages []models.Package
+
+		if config.Incremental {
+			logrus.Infof("Incremental mode: parsing existing %s metadata...", pkgType)
+
+			// Parse existing packages from metadata
+			var err error
+			existingPackages, err = gen.ParseExistingMetadata(config)
+			if err != nil {
+				logrus.Warnf("Could not parse existing metadata for %s: %v. Falling back to normal mode.", pkgType, err)
+				existingPackages = nil
+			} else {
+				logrus.Infof("Found %d existing %s packages", len(existingPackages), pkgType)
+			}
+		}
+
+		// Packages scanned twice, or already published, are resolved as --on-conflict says
+		resolved, err := resolveConflicts(config, gen, pkgType, existingPackages, newPackages, config.OnConflict)
+		if err != nil {
+			return err
+		}
+		finalPackages, newPackages := resolved.Packages, resolved.Incoming
+		if config.Incremental {
+			logrus.Infof("Combining %d existing + %d new = %d total %s packages",
+				len(finalPackages)-len(newPackages), len(newPackages), len(finalPackages), pkgType)
+		}
+
+		if len(finalPackages) == 0 {
+			logrus.Warn("No packages to process")
+			return nil
+		}
+
+		// Existing packages satisfy dependencies too
+		if err := checkDependencies(config, gen, pkgType, finalPackages); err != nil {
+			return err
+		}
+
+		// Overrides apply to existing packages too, so packages can be retired after publication
+		settings.apply(pkgType, finalPackages)
+
+		if err := generateRepository(ctx, config, gen, pkgType, finalPackages); err != nil {
+			return err
+		}
+		return recordProvenance(config, gen, pkgType, newPackages, keys.keyID(pkgType))
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeDownloadAids(config); err != nil {
+		return err
+	}
+	if err := writeChecksums(config, keys); err != nil {
+		return err
+	}
+	if err := keys.signSigstore(ctx, config); err != nil {
+		return err
+	}
+	if err := keys.signMinisign(ctx, config); err != nil {
+		return err
+	}
+	return writeClientFiles(config, keys)
+}
+
+// parsePackage reads the metadata of a scanned package file
+func parsePackage(scanned scanner.ScannedPackage) (*models.Package, error) {
+	switch scanned.Type {
+	case scanner.TypeDeb:
+		return deb.ParsePackage(scanned.Path)
+	case scanner.TypeRpm:
+		return rpm.ParsePackage(scanned.Path)
+	case scanner.TypeApk:
+		return apk.ParsePackage(scanned.Path)
+	case scanner.TypePacman:
+		return pacman.ParsePackage(scanned.Path)
+	case scanner.TypeHomebrewBottle:
+		return homebrew.ParseBottle(scanned.Path)
+	case scanner.TypeHomebrewCask:
+		return homebrew.ParseCask(scanned.Path)
+	case scanner.TypePypi:
+		return pypi.ParsePackage(scanned.Path)
+	case scanner.TypeRubygem:
+		return rubygems.ParsePackage(scanned.Path)
+	case scanner.TypeCargo:
+		return cargo.ParsePackage(scanned.Path)
+	case scanner.TypeNuget:
+		return nuget.ParsePackage(scanned.Path)
+	case scanner.TypeConda:
+		return conda.ParsePackage(scanned.Path)
+	case scanner.TypeFdroid:
+		return fdroid.ParsePackage(scanned.Path)
+	case scanner.TypeXbps:
+		return xbps.ParsePackage(scanned.Path)
+	case scanner.TypeTerraform:
+		return terraform.ParsePackage(scanned.Path)
+	default:
+		return nil, fmt.Errorf("unknown package type: %s", scanned.Type)
+	}
+}
+
+// emitPackageParsed reports a parsed package on the events stream
+func emitPackageParsed(scanned scanner.ScannedPackage, pkg *models.Package) {
+	events.Emit(events.PackageParsed, events.Fields{
+		"path":         scanned.Path,
+		"type":         scanned.Type.String(),
+		"name":         pkg.Name,
+		"version":      pkg.Version,
+		"architecture": pkg.Architecture,
+	})
+}
+
+// packageSettings holds the per-package settings loaded from the files
+// given on the command line
+type packageSettings struct {
+	overrides    *overrides.Overrides
+	translations translations.Translations
+}
+
+// loadPackageSettings loads the overrides and translations files, if any
+func loadPackageSettings(config *models.RepositoryConfig) (*packageSettings, error) {
+	settings := &packageSettings{}
+	var err error
+
+	if config.OverridesPath != "" {
+		settings.overrides, err = overrides.Load(config.OverridesPath)
+		if err != nil {
+			return nil, &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err:  err,
+			}
+		}
+	}
+
+	if config.TranslationsPath != "" {
+		settings.translations, err = translations.Load(config.TranslationsPath)
+		if err != nil {
+			return nil, &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err:  err,
+			}
+		}
+	}
+
+	return settings, nil
+}
+
+// apply sets the per-package settings on packages in place
+func (s *packageSettings) apply(pkgType scanner.PackageType, packages []models.Package) {
+	s.overrides.Apply(packages)
+	s.translations.Apply(packages)
+	for _, pkg := range packages {
+		if pkg.Deprecation != nil {
+			logrus.Warnf("%s %s-%s is %s", pkgType, pkg.Name, pkg.Version, pkg.Deprecation.Note())
+		}
+	}
+}
+
+// newGenerators initializes the signers and returns a generator per package type
+func newGenerators(config *models.RepositoryConfig) (map[scanner.PackageType]generator.Generator, error) {
+	keys, err := newSigningKeys(config)
+	if err != nil {
+		return nil, err
+	}
+	return keys.generators(config), nil
+}
+
+// signingKeys holds the signers repositories are signed with, nil when unsigned
+type signingKeys struct {
+	gpg        signer.Signer
+	rsa        signer.RSASigner
+	rsaKeyName string
+	cosign     *sigstore.Signer
+	minisign   *signer.MinisignSigner
+}
+
+// newSigningKeys initializes the signers configured on the command line
+func newSigningKeys(config *models.RepositoryConfig) (*signingKeys, error) {
+	var gpgSigner signer.Signer
+	var rsaSigner signer.RSASigner
+	var err error
+
+	if config.GPGKeyPath != "" {
+		gpgSigner, err = signer.NewGPGSigner(config.GPGKeyPath, config.GPGPassphrase)
+		if err != nil {
+			return nil, &models.RepoGenError{
+				Type: models.ErrSigning,
+				Err:  fmt.Errorf("failed to initialize GPG signer: %w", err),
+			}
+		}
+		logrus.Info("GPG signer initialized")
+	} else if config.GPGKeyID != "" {
+		gpgSigner, err = signer.NewAgentSigner(config.GPGKeyID, config.GPGPassphrase)
+		if err != nil {
+			return nil, &models.RepoGenError{
+				Type: models.ErrSigning,
+				Err:  fmt.Errorf("failed to initialize GPG agent signer: %w", err),
+			}
+		}
+		logrus.Infof("GPG signer initialized with keyring key %s", config.GPGKeyID)
+	} else if config.PKCS11URI != "" {
+		gpgSigner, err = signer.NewPKCS11Signer(config.PKCS11URI, config.GPGPassphrase, config.Origin)
+		if err != nil {
+			return nil, &models.RepoGenError{
+				Type: models.ErrSigning,
+				Err:  fmt.Errorf("failed to initialize PKCS#11 signer: %w", err),
+			}
+		}
+		logrus.Info("GPG signer initialized with PKCS#11 token key")
+	} else if config.KMSKeyARN != "" {
+		gpgSigner, err = signer.NewKMSSigner(config.KMSKeyARN, config.Origin)
+		if err != nil {
+			return nil, &models.RepoGenError{
+				Type: models.ErrSigning,
+				Err:  fmt.Errorf("failed to initialize AWS KMS signer: %w", err),
+			}
+		}
+		logrus.Infof("GPG signer initialized with AWS KMS key %s", config.KMSKeyARN)
+	}
+
+	if config.RSAKeyPath != "" {
+		rsaSigner, err = signer.NewAlpineRSASigner(config.RSAKeyPath, config.RSAPassphrase)
+		if err != nil {
+			return nil, &models.RepoGenError{
+				Type: models.ErrSigning,
+				Err:  fmt.Errorf("failed to initialize RSA signer: %w", err),
+			}
+		}
+		logrus.Info("RSA signer initialized")
+	}
+
+	var cosignSigner *sigstore.Signer
+	if config.Cosign {
+		cosignSigner = &sigstore.Signer{Key: config.CosignKey, RekorURL: config.RekorURL, NoTlog: config.CosignNoTlog}
+		if err := cosignSigner.Check(); err != nil {
+			return nil, &models.RepoGenError{
+				Type: models.ErrSigning,
+				Err:  fmt.Errorf("failed to initialize cosign signer: %w", err),
+			}
+		}
+		if config.CosignKey != "" {
+			logrus.Info("cosign signer initialized")
+		} else {
+			logrus.Info("cosign signer initialized for keyless signing")
+		}
+	}
+
+	var minisignSigner *signer.MinisignSigner
+	if config.MinisignKey != "" {
+		minisignSigner, err = signer.NewMinisignSigner(config.MinisignKey, config.MinisignPass)
+		if err != nil {
+			return nil, &models.RepoGenError{
+				Type: models.ErrSigning,
+				Err:  fmt.Errorf("failed to initialize minisign signer: %w", err),
+			}
+		}
+		logrus.Infof("minisign signer initialized with key %s", minisignSigner.KeyID())
+	}
+
+	keys := &signingKeys{gpg: gpgSigner, rsa: rsaSigner, rsaKeyName: config.RSAKeyName, cosign: cosignSigner, minisign: minisignSigner}
+	if err := keys.checkHealth(config); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// checkHealth warns about keys whose signatures clients refuse or soon
+// will, a common silent cause of apt rejecting repositories. With
+// config.StrictKeys, such keys fail the run instead
+func (k *signingKeys) checkHealth(config *models.RepositoryConfig) error {
+	var problems []string
+	if k.gpg != nil {
+		gpgProblems, err := signer.CheckKey(k.gpg, time.Now(), time.Duration(config.KeyExpiryDays)*24*time.Hour)
+		if err != nil {
+			return &models.RepoGenError{Type: models.ErrSigning, Err: fmt.Errorf("failed to check GPG key: %w", err)}
+		}
+		for _, p := range gpgProblems {
+			problems = append(problems, "GPG "+p)
+		}
+	}
+	if k.rsa != nil {
+		rsaProblems, err := signer.CheckRSAKey(k.rsa)
+		if err != nil {
+			return &models.RepoGenError{Type: models.ErrSigning, Err: fmt.Errorf("failed to check RSA key: %w", err)}
+		}
+		problems = append(problems, rsaProblems...)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	if config.StrictKeys {
+		return &models.RepoGenError{
+			Type: models.ErrSigning,
+			Err:  fmt.Errorf("signing key problems: %s", strings.Join(problems, "; ")),
+		}
+	}
+	for _, p := range problems {
+		logrus.Warnf("Signing key: %s", p)
+	}
+	return nil
+}
+
+// generators returns a generator per package type, signing with k
+func (k *signingKeys) generators(config *models.RepositoryConfig) map[scanner.PackageType]generator.Generator {
+	return generator.New(config, generator.Signers{GPG: k.gpg, RSA: k.rsa, RSAKeyName: k.rsaKeyName})
+}
+
+// The built-in generators, registered like those of other package formats
+func init() {
+	generator.Register(scanner.TypeDeb, func(config *models.RepositoryConfig, s generator.Signers) generator.Generator {
+		return deb.NewGenerator(s.GPG)
+	})
+	generator.Register(scanner.TypeRpm, func(config *models.RepositoryConfig, s generator.Signers) generator.Generator {
+		return rpm.NewGenerator(s.GPG)
+	})
+	generator.Register(scanner.TypeApk, func(config *models.RepositoryConfig, s generator.Signers) generator.Generator {
+		return apk.NewGenerator(s.RSA, s.RSAKeyName)
+	})
+	generator.Register(scanner.TypePacman, func(config *models.RepositoryConfig, s generator.Signers) generator.Generator {
+		return pacman.NewGenerator(s.GPG)
+	})
+	generator.Register(scanner.TypeHomebrewBottle, func(config *models.RepositoryConfig, s generator.Signers) generator.Generator {
+		return homebrew.NewGenerator(config.BaseURL, config.BottleRootURL)
+	})
+	generator.Register(scanner.TypeHomebrewCask, func(config *models.RepositoryConfig, s generator.Signers) generator.Generator {
+		return homebrew.NewCaskGenerator(config.BaseURL)
+	})
+	generator.Register(scanner.TypePypi, func(config *models.RepositoryConfig, s generator.Signers) generator.Generator {
+		return pypi.NewGenerator()
+	})
+	generator.Register(scanner.TypeRubygem, func(config *models.RepositoryConfig, s generator.Signers) generator.Generator {
+		return rubygems.NewGenerator()
+	})
+	generator.Register(scanner.TypeCargo, func(config *models.RepositoryConfig, s generator.Signers) generator.Generator {
+		return cargo.NewGenerator()
+	})
+	generator.Register(scanner.TypeNuget, func(config *models.RepositoryConfig, s generator.Signers) generator.Generator {
+		return nuget.NewGenerator()
+	})
+	generator.Register(scanner.TypeConda, func(config *models.RepositoryConfig, s generator.Signers) generator.Generator {
+		return conda.NewGenerator()
+	})
+	generator.Register(scanner.TypeFdroid, func(config *models.RepositoryConfig, s generator.Signers) generator.Generator {
+		return fdroid.NewGenerator(s.RSA, s.RSAKeyName)
+	})
+	generator.Register(scanner.TypeXbps, func(config *models.RepositoryConfig, s generator.Signers) generator.Generator {
+		return xbps.NewGenerator(s.RSA)
+	})
+	generator.Register(scanner.TypeTerraform, func(config *models.RepositoryConfig, s generator.Signers) generator.Generator {
+		return terraform.NewGenerator(s.GPG)
+	})
+}
+
+// keyID identifies the key signing repositories of pkgType: the OpenPGP
+// fingerprint, or the Alpine key name, which also signs F-Droid and xbps
+// repositories. It is empty when they are unsigned
+func (k *signingKeys) keyID(pkgType scanner.PackageType) string {
+	switch pkgType {
+	case scanner.TypeDeb, scanner.TypeRpm, scanner.TypePacman, scanner.TypeTerraform:
+		if k.gpg == nil {
+			return ""
+		}
+		fingerprint, err := signer.Fingerprint(k.gpg)
+		if err != nil {
+			logrus.Warnf("Failed to get signing key fingerprint: %v", err)
+			return ""
+		}
+		return fingerprint
+	case scanner.TypeApk, scanner.TypeFdroid, scanner.TypeXbps:
+		if k.rsa == nil {
+			return ""
+		}
+		return k.rsaKeyName
+	default:
+		return ""
+	}
+}
+
+// preflightApt checks the apt clients of config would accept the Debian
+// repository signature, failing before anything is published if one refuses it
+func (k *signingKeys) preflightApt(config *models.RepositoryConfig) error {
+	if k.gpg == nil || len(config.AptClients) == 0 {
+		return nil
+	}
+
+	verdicts, err := deb.PreflightSignature(k.gpg, config.AptClients)
+	if err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrSigning,
+			Err:  fmt.Errorf("apt signature preflight failed: %w", err),
+		}
+	}
+
+	var refused []string
+	for _, v := range verdicts {
+		switch {
+		case v.Refused:
+			logrus.Errorf("%s would refuse the repository: %s", v.Release, strings.Join(v.Problems, "; "))
+			refused = append(refused, v.Client)
+		case len(v.Problems) > 0:
+			logrus.Warnf("%s accepts the repository with warnings: %s", v.Release, strings.Join(v.Problems, "; "))
+		default:
+			logrus.Debugf("%s accepts the repository signature", v.Release)
+		}
+	}
+	if len(refused) > 0 {
+		return &models.RepoGenError{
+			Type: models.ErrSigning,
+			Err:  fmt.Errorf("apt on %s would refuse the repository signature; use a stronger key or leave these releases out of --apt-clients", strings.Join(refused, ", ")),
+		}
+	}
+	return nil
+}
+
+// signSigstore signs the metadata of the output directory with cosign, when
+// asked to. Snapshots keep the signatures they were taken with
+func (k *signingKeys) signSigstore(ctx context.Context, config *models.RepositoryConfig) (err error) {
+	if k.cosign == nil {
+		return nil
+	}
+	_, span := telemetry.Start(ctx, "sign", telemetry.Labels{"kind": "sigstore"})
+	defer func() { span.End(err) }()
+
+	files, err := sigstore.MetadataFiles(config.OutputDir, snapshot.Dir)
+	if err != nil {
+		return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+	}
+	signed := 0
+	for _, path := range files {
+		if sigstore.UpToDate(path) {
+			continue
+		}
+		written, err := k.cosign.SignFile(path)
+		if err != nil {
+			return &models.RepoGenError{Type: models.ErrSigning, Err: err}
+		}
+		for _, w := range written {
+			events.Emit(events.Signed, events.Fields{"path": w, "kind": "sigstore"})
+		}
+		signed++
+	}
+	logrus.Infof("Signed %d metadata files with cosign", signed)
+	return nil
+}
+
+// signMinisign signs the metadata of the output directory with the
+// minisign key into .minisig files next to them, and publishes the public
+// key as keys/<repository>.pub. Snapshots keep the signatures they were
+// taken with
+func (k *signingKeys) signMinisign(ctx context.Context, config *models.RepositoryConfig) (err error) {
+	if k.minisign == nil {
+		return nil
+	}
+	_, span := telemetry.Start(ctx, "sign", telemetry.Labels{"kind": "minisign"})
+	defer func() { span.End(err) }()
+
+	files, err := sigstore.MetadataFiles(config.OutputDir, snapshot.Dir)
+	if err != nil {
+		return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+	}
+	timestamp := utils.Timestamp(config).Unix()
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+		}
+		comment := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", timestamp, filepath.Base(path))
+		sig := k.minisign.Sign(data, comment)
+		if err := signer.VerifyMinisign(k.minisign.PublicKey(), sig, data); err != nil {
+			return &models.RepoGenError{Type: models.ErrSigning, Err: fmt.Errorf("minisign signature of %s doesn't verify: %w", path, err)}
+		}
+		if err := utils.WriteFile(path+signer.MinisignExt, sig, 0644); err != nil {
+			return &models.RepoGenError{Type: models.ErrFileOp, Err: fmt.Errorf("failed to write signature: %w", err)}
+		}
+		events.Emit(events.Signed, events.Fields{"path": path + signer.MinisignExt, "kind": "minisign"})
+	}
+
+	if err := signer.PublishMinisignPublicKey(k.minisign, config.OutputDir, utils.RepoSlug(config)); err != nil {
+		return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+	}
+	logrus.Infof("Signed %d metadata files with minisign", len(files))
+	return nil
+}
+
+// generateRepository validates packages and regenerates the repository of one package type
+func generateRepository(ctx context.Context, config *models.RepositoryConfig, gen generator.Generator, pkgType scanner.PackageType, packages []models.Package) (err error) {
+	ctx, span := telemetry.Start(ctx, "generate", telemetry.Labels{"type": pkgType.String()})
+	defer func() { span.End(err) }()
+
+	logrus.Infof("Generating %s repository with %d packages...", pkgType, len(packages))
+
+	if err := gen.ValidatePackages(packages); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("package validation failed for %s: %w", pkgType, err),
+		}
+	}
+
+	if err := gen.Generate(ctx, config, packages); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrMetadataGen,
+			Err:  fmt.Errorf("failed to generate %s repository: %w", pkgType, err),
+		}
+	}
+
+	// Served repositories report it on /healthz and /metrics
+	if err := status.Record(config.OutputDir, pkgType.String(), status.Generation{
+		GeneratedAt:    utils.Timestamp(config),
+		Packages:       len(packages),
+		VerifiedWrites: config.VerifyWrites,
+		RepogenVersion: buildVersion,
+	}); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  err,
+		}
+	}
+
+	if err := recordManifest(config, gen, pkgType); err != nil {
+		return err
+	}
+
+	events.Emit(events.Published, events.Fields{"type": pkgType.String(), "packages": len(packages), "output_dir": config.OutputDir})
+	return nil
+}
+
+// recordManifest lists the packages now published for pkgType in the
+// package manifest searched by repogen search
+func recordManifest(config *models.RepositoryConfig, gen generator.Generator, pkgType scanner.PackageType) error {
+	published, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		logrus.Debugf("No published %s packages: %v", pkgType, err)
+	}
+
+	// Deprecations come from the overrides, not from the published metadata
+	settings, err := loadPackageSettings(config)
+	if err != nil {
+		return err
+	}
+	settings.overrides.Apply(published)
+
+	if err := manifest.Record(config.OutputDir, pkgType.String(), manifestEntries(config, gen, published), utils.Timestamp(config)); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  err,
+		}
+	}
+	return nil
+}
+
+// manifestEntries describes published packages, with their path when the
+// generator can locate them
+func manifestEntries(config *models.RepositoryConfig, gen generator.Generator, published []models.Package) []manifest.Entry {
+	locator, _ := gen.(generator.PackageLocator)
+
+	entries := make([]manifest.Entry, 0, len(published))
+	for _, pkg := range published {
+		entry := manifest.Entry{
+			Type:         gen.GetSupportedType().String(),
+			Name:         pkg.Name,
+			Version:      pkg.Version,
+			Architecture: pkg.Architecture,
+			Size:         pkg.Size,
+			SHA256:       pkg.SHA256Sum,
+		}
+		if pkg.Deprecation != nil {
+			entry.Deprecated = pkg.Deprecation.Note()
+		}
+		if locator != nil {
+			if rel, err := filepath.Rel(config.OutputDir, locator.PackageFiles(config, pkg)[0]); err == nil {
+				entry.Path = filepath.ToSlash(rel)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// recordProvenance writes the provenance record of each package of
+// newPackages, read from the input in this run, now that it is published
+func recordProvenance(config *models.RepositoryConfig, gen generator.Generator, pkgType scanner.PackageType, newPackages []models.Package, keyID string) error {
+	locator, ok := gen.(generator.PackageLocator)
+	if !ok {
+		return nil
+	}
+
+	// Published packages tell where the generator put each file
+	published, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		logrus.Debugf("Locating published %s packages from their own metadata: %v", pkgType, err)
+	}
+	byFile := make(map[string][]models.Package)
+	for _, pkg := range published {
+		byFile[filepath.Base(pkg.Filename)] = append(byFile[filepath.Base(pkg.Filename)], pkg)
+	}
+
+	for _, pkg := range newPackages {
+		matches := byFile[filepath.Base(pkg.Filename)]
+		if len(matches) == 0 {
+			matches = []models.Package{pkg}
+		}
+
+		for _, pub := range matches {
+			publishedPath := locator.PackageFiles(config, pub)[0]
+			if _, err := os.Stat(publishedPath); err != nil {
+				logrus.Debugf("Not recording provenance of %s: %v", pkg.Filename, err)
+				continue
+			}
+			rel, err := filepath.Rel(config.OutputDir, publishedPath)
+			if err != nil {
+				continue
+			}
+
+			// Homebrew bottles are only named once published
+			if pkg.Name == "" {
+				pkg.Name, pkg.Version, pkg.Architecture = pub.Name, pub.Version, pub.Architecture
+			}
+			if pkg.SHA256Sum == "" {
+				pkg.Size, pkg.MD5Sum, pkg.SHA1Sum, pkg.SHA256Sum, pkg.SHA512Sum = pub.Size, pub.MD5Sum, pub.SHA1Sum, pub.SHA256Sum, pub.SHA512Sum
+			}
+
+			record := provenance.NewRecord(pkgType.String(), pkg, filepath.ToSlash(rel), pkg.Filename)
+			record.PublishedAt = utils.Timestamp(config)
+			record.SigningKey = keyID
+			record.RepogenVersion = buildVersion
+			if err := provenance.Write(config.OutputDir, record); err != nil {
+				return &models.RepoGenError{
+					Type: models.ErrFileOp,
+					Err:  err,
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadBuildMatrix reads and validates the build matrix, with the version
+// given on the command line
+func loadBuildMatrix(config *models.RepositoryConfig) (*buildmatrix.Matrix, error) {
+	matrix, err := buildmatrix.Load(config.BuildMatrixPath)
+	if err != nil {
+		return nil, &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+	}
+	if config.BuildVersion != "" {
+		matrix.Version = config.BuildVersion
+	}
+	if err := matrix.Validate(); err != nil {
+		return nil, &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("invalid build matrix %s: %w", config.BuildMatrixPath, err),
+		}
+	}
+	return matrix, nil
+}
+
+// buildMatrixPackages packages the binaries of the build matrix into a
+// temporary directory, which the caller removes
+func buildMatrixPackages(config *models.RepositoryConfig) (string, error) {
+	matrix, err := loadBuildMatrix(config)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := os.MkdirTemp("", "repogen-build-")
+	if err != nil {
+		return "", &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+	}
+	paths, err := matrix.Build(dir)
+	if err != nil {
+		return dir, &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("failed to package the build matrix: %w", err),
+		}
+	}
+
+	logrus.Infof("Packaged %s %s for %d targets (%d packages)", matrix.Name, matrix.Version, len(matrix.Targets), len(paths))
+	return dir, nil
+}
+
+// loadInputManifest reads and validates the list of packages to download
+func loadInputManifest(config *models.RepositoryConfig) ([]fetch.Entry, error) {
+	entries, err := fetch.Load(config.InputManifestPath)
+	if err != nil {
+		return nil, &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+	}
+	if err := fetch.Validate(entries); err != nil {
+		return nil, &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("invalid input manifest %s: %w", config.InputManifestPath, err),
+		}
+	}
+	return entries, nil
+}
+
+// fetchPackages downloads the packages of the input manifest into a
+// temporary directory, which the caller removes
+func fetchPackages(ctx context.Context, config *models.RepositoryConfig) (string, error) {
+	entries, err := loadInputManifest(config)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := os.MkdirTemp("", "repogen-fetch-")
+	if err != nil {
+		return "", &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+	}
+	fetcher := &fetch.Fetcher{Concurrency: config.FetchConcurrency, CacheDir: config.FetchCacheDir}
+	err = runPhase(ctx, "fetch", 0, func(ctx context.Context) error {
+		_, err := fetcher.Fetch(ctx, entries, dir)
+		return err
+	})
+	if err != nil {
+		var repoErr *models.RepoGenError
+		if errors.As(err, &repoErr) {
+			return dir, err
+		}
+		return dir, &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+	}
+
+	logrus.Infof("Fetched %d packages from %s", len(entries), config.InputManifestPath)
+	return dir, nil
+}
+
+// defaultFetchCache returns the directory keeping downloaded packages, in
+// the user cache directory
+func defaultFetchCache() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "repogen", "fetch")
+}
+
 // hasPacmanPackages checks if input directory contains Pacman packages
 func hasPacmanPackages(inputDir string) bool {
 	matches, _ := filepath.Glob(filepath.Join(inputDir, "*.pkg.tar.*"))
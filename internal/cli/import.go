@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/ralt/repogen/internal/adopt"
+	"github.com/ralt/repogen/internal/bundle"
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/status"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewImportCmd creates the import command
+func NewImportCmd() *cobra.Command {
+	var bundlePath, outputDir, dir string
+	var replace bool
+
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import a repository from a bundle, or one created by another tool",
+		Long: `Unpacks a bundle created by export into a staging directory, verifying
+every file against the bundle manifest, and only then moves it to the output
+directory. A corrupted bundle leaves the output directory untouched.
+
+With --dir, takes over a repository created by another tool instead: its
+formats and layout settings are detected, every package file is checked
+against the checksums of the metadata, and the package manifest, status and
+settings are recorded under .repogen/, so add, remove and prune work on it
+with no further flags.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if dir != "" {
+				if bundlePath != "" {
+					return &models.RepoGenError{
+						Type: models.ErrInvalidConfig,
+						Err:  fmt.Errorf("--dir and --bundle are mutually exclusive"),
+					}
+				}
+				return runImportDir(dir)
+			}
+			if bundlePath == "" {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--bundle or --dir is required"),
+				}
+			}
+			if outputDir == "" {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("output-dir is required"),
+				}
+			}
+
+			manifest, err := bundle.Import(bundlePath, outputDir, replace)
+			if err != nil {
+				return &models.RepoGenError{
+					Type: models.ErrFileOp,
+					Err:  fmt.Errorf("failed to import bundle: %w", err),
+				}
+			}
+
+			logrus.Infof("Imported %d verified files into %s", len(manifest.Files), outputDir)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&bundlePath, "bundle", "", "Bundle file created by export")
+	cmd.Flags().StringVarP(&outputDir, "output-dir", "o", "./repo", "Directory to unpack the repository into")
+	cmd.Flags().BoolVar(&replace, "replace", false, "Replace the output directory if it isn't empty")
+	cmd.Flags().StringVar(&dir, "dir", "", "Existing repository created by another tool to take over in place")
+
+	return cmd
+}
+
+// runImportDir takes over the repository in dir: it detects its formats and
+// settings, verifies its package files and records its state
+func runImportDir(dir string) error {
+	settings, err := adopt.Detect(dir)
+	if err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("failed to read %s: %w", dir, err),
+		}
+	}
+
+	// Read the repository as the other commands will, with their defaults
+	var config models.RepositoryConfig
+	addRepositoryFlags(&cobra.Command{}, &config)
+	config.OutputDir = dir
+	settings.Apply(&config)
+
+	generators := generator.New(&config, generator.Signers{})
+	types := make([]scanner.PackageType, 0, len(generators))
+	for pkgType := range generators {
+		types = append(types, pkgType)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	found := make(map[scanner.PackageType][]models.Package)
+	var problems []string
+	for _, pkgType := range types {
+		packages, err := generators[pkgType].ParseExistingMetadata(&config)
+		if err != nil || len(packages) == 0 {
+			logrus.Debugf("No %s repository: %v", pkgType, err)
+			continue
+		}
+		found[pkgType] = packages
+		logrus.Infof("Found %s repository with %d packages", pkgType, len(packages))
+
+		locator, ok := generators[pkgType].(generator.PackageLocator)
+		if !ok {
+			logrus.Warnf("Package files of %s repositories can't be verified", pkgType)
+			continue
+		}
+		for _, pkg := range packages {
+			path := locator.PackageFiles(&config, pkg)[0]
+			if err := adopt.VerifyFile(path, pkg); err != nil {
+				problems = append(problems, fmt.Sprintf("%s %s: %v", pkg.Name, pkg.Version, err))
+			}
+		}
+	}
+
+	if len(found) == 0 {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("no repository metadata found in %s", dir),
+		}
+	}
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			logrus.Errorf("%s", problem)
+		}
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("%d package files don't match the repository metadata", len(problems)),
+		}
+	}
+
+	// Record the state the other commands start from
+	if err := adopt.Write(dir, settings); err != nil {
+		return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+	}
+	for _, pkgType := range types {
+		packages, ok := found[pkgType]
+		if !ok {
+			continue
+		}
+		if err := status.Record(dir, pkgType.String(), status.Generation{
+			GeneratedAt:    utils.Timestamp(&config),
+			Packages:       len(packages),
+			RepogenVersion: buildVersion,
+		}); err != nil {
+			return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+		}
+		if err := recordManifest(&config, generators[pkgType], pkgType); err != nil {
+			return err
+		}
+	}
+
+	logrus.Infof("Imported %s: settings recorded in %s", dir, filepath.Join(dir, filepath.FromSlash(adopt.SettingsPath)))
+	return nil
+}
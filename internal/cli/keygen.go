@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/ralt/repogen/internal/keygen"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewKeygenCmd creates the keygen command
+func NewKeygenCmd() *cobra.Command {
+	var opts keygen.Options
+	var outputDir string
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a signing key",
+		Long: `Generates a signing key and exports its public key in the formats package
+managers expect, without a GnuPG keyring or OpenSSL:
+
+  gpg      OpenPGP RSA key for Debian, RPM and Pacman repositories (--gpg-key):
+           <name>.private.asc, and the public key as <name>.asc, .gpg and .kbx
+  rsa      RSA key for Alpine, F-Droid and xbps repositories (--rsa-key):
+           <name>.rsa, and <name>.rsa.pub to install in /etc/apk/keys
+  ed25519  usign key for opkg feeds and --minisign-key: <name>.sec, and
+           <name>.pub to install in /etc/opkg/keys under its fingerprint`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.Type == "" || opts.Name == "" {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--type and --name are required"),
+				}
+			}
+			if err := opts.Validate(); err != nil {
+				return &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+			}
+
+			if opts.Type != keygen.TypeEd25519 {
+				logrus.Infof("Generating %d-bit %s key...", opts.Bits, opts.Type)
+			}
+			files, fingerprint, err := keygen.Write(opts, outputDir, force)
+			if err != nil {
+				return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+			}
+
+			for _, f := range files {
+				if f.Private {
+					logrus.Infof("Private key: %s", f.Path)
+				} else {
+					logrus.Infof("Public key: %s", f.Path)
+				}
+			}
+			switch opts.Type {
+			case keygen.TypeGPG:
+				logrus.Infof("Fingerprint: %s", fingerprint)
+			case keygen.TypeRSA:
+				logrus.Infof("Sign with --rsa-key %s --key-name %s", files[0].Path, utils.Slug(opts.Name))
+			case keygen.TypeEd25519:
+				logrus.Infof("Fingerprint: %s (install the public key as /etc/opkg/keys/%s)", fingerprint, fingerprint)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Type, "type", "", "Key type: gpg, rsa or ed25519")
+	cmd.Flags().StringVar(&opts.Name, "name", "", "Key name: the user ID of GPG keys, and the base name of the key files")
+	cmd.Flags().StringVar(&opts.Email, "email", "", "Email of the user ID of GPG keys")
+	cmd.Flags().IntVar(&opts.Bits, "bits", keygen.DefaultBits, "Size of GPG and RSA keys")
+	cmd.Flags().StringVar(&opts.Passphrase, "passphrase", "", "Encrypt GPG and RSA private keys with this passphrase")
+	cmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Directory to write the key files to")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace existing key files")
+
+	return cmd
+}
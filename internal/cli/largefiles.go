@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/ralt/repogen/internal/largefiles"
+	"github.com/ralt/repogen/internal/manifest"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/snapshot"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// writeDownloadAids writes the zsync, metalink and torrent files of the
+// package files the manifest lists of at least --large-file-size, and
+// removes those of package files no longer published
+func writeDownloadAids(config *models.RepositoryConfig) error {
+	if config.LargeFileSize == 0 {
+		return nil
+	}
+	m, err := manifest.Read(config.OutputDir)
+	if err != nil {
+		logrus.Debugf("No package manifest to find large package files in: %v", err)
+		return nil
+	}
+
+	opts := largefiles.Options{
+		Aids:     config.LargeFileAids,
+		Trackers: config.TorrentTrackers,
+		Time:     utils.Timestamp(config),
+	}
+	if config.BaseURL != "" {
+		opts.BaseURLs = append(opts.BaseURLs, config.BaseURL)
+	}
+	opts.BaseURLs = append(opts.BaseURLs, config.MirrorURLs...)
+
+	threshold := int64(config.LargeFileSize) << 20
+	seen := make(map[string]bool)
+	large, written := 0, 0
+	for _, e := range m.Packages {
+		if e.Path == "" || seen[e.Path] {
+			continue
+		}
+		seen[e.Path] = true
+		info, err := os.Stat(filepath.Join(config.OutputDir, filepath.FromSlash(e.Path)))
+		if err != nil || info.Size() < threshold {
+			continue
+		}
+		large++
+		files, err := largefiles.Write(config.OutputDir, e.Path, opts)
+		written += len(files)
+		if err != nil {
+			return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+		}
+	}
+
+	removed, err := largefiles.RemoveStale(config.OutputDir, snapshot.Dir)
+	if err != nil {
+		return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+	}
+	logrus.Infof("Wrote %d download aids for %d large package files, removed %d stale ones", written, large, removed)
+	return nil
+}
@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/ralt/repogen/internal/lint"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewLintCmd creates the lint command
+func NewLintCmd() *cobra.Command {
+	var config models.RepositoryConfig
+	var rulesPath string
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "lint",
+		Short: "Check the quality of packages before publishing them",
+		Long: `Parses the packages of the input directory and reports those missing a
+maintainer, license or description, with a version their format doesn't
+accept, whose file name names another architecture than their metadata, or
+larger than 1 GiB.
+
+A rules file (YAML or JSON) sets the size limit, the severity of each rule
+(off, warn or error) and policies packages must follow:
+
+  max_size: 500MiB
+  name_pattern: '^[a-z0-9][a-z0-9.+-]*$'
+  maintainer_pattern: '@example\.com>$'
+  allowed_licenses: [MIT, Apache-2.0]
+  allowed_architectures: [amd64, arm64, all]
+  severity:
+    missing-license: error
+    missing-maintainer: off
+
+Rules: missing-maintainer, missing-license, missing-description (warn),
+invalid-version, arch-mismatch (error), oversized (warn), policy-name,
+policy-maintainer, policy-license and policy-arch (error).
+
+The command fails when a package breaks a rule of error severity.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rules := lint.DefaultRules()
+			if rulesPath != "" {
+				var err error
+				if rules, err = lint.Load(rulesPath); err != nil {
+					return &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+				}
+			}
+
+			scanned, err := scanner.NewFileSystemScanner(config.Include, config.Exclude).Scan(cmd.Context(), config.InputDir)
+			if err != nil {
+				return &models.RepoGenError{
+					Type: models.ErrFileOp,
+					Err:  fmt.Errorf("failed to scan %s: %w", config.InputDir, err),
+				}
+			}
+			packagesByType, err := parsePackages(cmd.Context(), scanned, nil)
+			if err != nil {
+				return err
+			}
+
+			pkgTypes := make([]scanner.PackageType, 0, len(packagesByType))
+			for pkgType := range packagesByType {
+				pkgTypes = append(pkgTypes, pkgType)
+			}
+			sort.Slice(pkgTypes, func(i, j int) bool { return pkgTypes[i] < pkgTypes[j] })
+
+			findings := []lint.Finding{}
+			checked := 0
+			for _, pkgType := range pkgTypes {
+				findings = append(findings, rules.Check(pkgType, packagesByType[pkgType])...)
+				checked += len(packagesByType[pkgType])
+			}
+
+			failures := 0
+			for _, f := range findings {
+				if f.Severity == lint.SeverityError {
+					failures++
+				}
+			}
+
+			if jsonOutput {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(findings); err != nil {
+					return err
+				}
+			} else if len(findings) > 0 {
+				if err := printFindings(cmd.OutOrStdout(), findings); err != nil {
+					return err
+				}
+			}
+			logrus.Infof("Checked %d packages: %d errors, %d warnings", checked, failures, len(findings)-failures)
+
+			if failures > 0 {
+				return &models.RepoGenError{
+					Type: models.ErrLint,
+					Err:  fmt.Errorf("%d package quality error(s)", failures),
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&config.InputDir, "input-dir", "i", ".", "Input directory to scan")
+	cmd.Flags().StringSliceVar(&config.Include, "include", nil, "Only check files whose name matches one of these globs (e.g. '*_amd64.deb')")
+	cmd.Flags().StringSliceVar(&config.Exclude, "exclude", nil, "Skip files whose name matches one of these globs (e.g. '*-dbgsym*')")
+	cmd.Flags().StringVar(&rulesPath, "rules", "", "YAML/JSON file setting rule severities, the size limit and package policies")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the findings as JSON")
+
+	return cmd
+}
+
+// printFindings writes the findings as a table
+func printFindings(w io.Writer, findings []lint.Finding) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEVERITY\tRULE\tFILE\tMESSAGE")
+	for _, f := range findings {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.Severity, f.Rule, f.File, f.Message)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,172 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ralt/repogen/internal/fetch"
+	"github.com/ralt/repogen/internal/filter"
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/mirror"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// mirrorTypes are the repository formats that can be mirrored
+var mirrorTypes = map[string]scanner.PackageType{
+	"deb": scanner.TypeDeb,
+	"rpm": scanner.TypeRpm,
+	"apk": scanner.TypeApk,
+}
+
+// NewMirrorCmd creates the mirror command
+func NewMirrorCmd() *cobra.Command {
+	var config models.RepositoryConfig
+	var from, repoType, keyringPath string
+
+	cmd := &cobra.Command{
+		Use:   "mirror",
+		Short: "Clone an upstream repository",
+		Long: `Reads the metadata of an upstream Debian, RPM or Alpine repository, downloads
+the packages selected by the filters and generates a repository of them in
+--output-dir, signed with your own keys.
+
+Debian suites, components and architectures are those of --codename,
+--components and --arch; Alpine architectures those of --arch.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if from == "" {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--from is required"),
+				}
+			}
+			if _, ok := mirrorTypes[repoType]; !ok {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("unknown repository type %q (expected %s)", repoType, strings.Join(mirrorTypeNames(), ", ")),
+				}
+			}
+			if keyringPath != "" && repoType == "apk" {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--from-keyring only applies to deb and rpm repositories"),
+				}
+			}
+			if config.FetchConcurrency <= 0 {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--fetch-concurrency must be positive"),
+				}
+			}
+			if err := validateRepositoryConfig(&config); err != nil {
+				return err
+			}
+			if err := filter.Validate(&config); err != nil {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  err,
+				}
+			}
+
+			var keyring openpgp.EntityList
+			if keyringPath != "" {
+				var err error
+				keyring, err = mirror.ReadKeyring(keyringPath)
+				if err != nil {
+					return &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+				}
+			}
+			upstream, err := mirror.New(from, keyring)
+			if err != nil {
+				return &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+			}
+
+			return runMirror(cmd.Context(), &config, upstream, mirrorTypes[repoType])
+		},
+	}
+
+	cmd.Flags().StringVar(&from, "from", "", "URL of the upstream repository (the directory holding dists/, repodata/ or the Alpine architectures)")
+	cmd.Flags().StringVar(&repoType, "type", "", "Format of the upstream repository: "+strings.Join(mirrorTypeNames(), ", "))
+	cmd.Flags().StringVar(&keyringPath, "from-keyring", "", "Public keys the upstream Release or repomd.xml must be signed with")
+	addRepositoryFlags(cmd, &config)
+	addFilterFlags(cmd, &config)
+	cmd.Flags().IntVar(&config.FetchConcurrency, "fetch-concurrency", fetch.DefaultConcurrency, "Packages downloaded at once")
+	cmd.Flags().StringVar(&config.FetchCacheDir, "fetch-cache", defaultFetchCache(), "Directory keeping downloaded packages across runs (empty for none)")
+
+	return cmd
+}
+
+// runMirror downloads the selected packages of upstream and generates the
+// repository of them
+func runMirror(ctx context.Context, config *models.RepositoryConfig, upstream *mirror.Upstream, pkgType scanner.PackageType) error {
+	reader, ok := generator.New(config, generator.Signers{})[pkgType].(generator.UpstreamReader)
+	if !ok {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("%s repositories can't be mirrored", pkgType),
+		}
+	}
+
+	packages, err := reader.ReadUpstream(ctx, config, upstream)
+	if err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrMetadataGen,
+			Err:  fmt.Errorf("failed to read upstream repository: %w", err),
+		}
+	}
+	selected := mirror.Select(config, pkgType, packages)
+	if len(selected) == 0 {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("none of the %d upstream packages were selected", len(packages)),
+		}
+	}
+	logrus.Infof("Mirroring %d of %d upstream packages", len(selected), len(packages))
+
+	dir, err := os.MkdirTemp("", "repogen-mirror-")
+	if err != nil {
+		return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+	}
+	defer os.RemoveAll(dir)
+
+	fetcher := &fetch.Fetcher{Concurrency: config.FetchConcurrency, CacheDir: config.FetchCacheDir}
+	err = runPhase(ctx, "fetch", 0, func(ctx context.Context) error {
+		for subdir, entries := range upstream.Entries(selected) {
+			if err := os.MkdirAll(filepath.Join(dir, subdir), 0755); err != nil {
+				return err
+			}
+			if _, err := fetcher.Fetch(ctx, entries, filepath.Join(dir, subdir)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		var repoErr *models.RepoGenError
+		if errors.As(err, &repoErr) {
+			return err
+		}
+		return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+	}
+
+	config.InputDir = dir
+	return runGeneration(ctx, config, nil)
+}
+
+// mirrorTypeNames returns the sorted names of the formats that can be mirrored
+func mirrorTypeNames() []string {
+	var names []string
+	for name := range mirrorTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ralt/repogen/internal/fixture"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewMkFixtureCmd creates the mkfixture command
+func NewMkFixtureCmd() *cobra.Command {
+	var pkg fixture.Package
+	var outputDir string
+
+	cmd := &cobra.Command{
+		Use:   "mkfixture",
+		Short: "Build a tiny valid package for testing",
+		Long: `Builds a tiny package of the given type, without any packaging tools, to
+smoke test a repository hosting setup without real artifacts. The package
+installs a single script, /usr/bin/<name> (bin/<name> in the bottle keg),
+printing a success message.
+
+Packages are unsigned: sign the repository, or allow untrusted packages
+when installing (apk add --allow-untrusted, dnf --nogpgcheck).`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := fixture.Build(pkg, outputDir)
+			if err != nil {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  err,
+				}
+			}
+
+			logrus.Infof("Built %s fixture %s %s", pkg.Type, pkg.Name, pkg.Version)
+			fmt.Fprintln(cmd.OutOrStdout(), path)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&pkg.Type, "type", "deb", "Package type ("+strings.Join(fixture.Types(), ", ")+")")
+	cmd.Flags().StringVar(&pkg.Name, "name", "repogen-fixture", "Package name")
+	cmd.Flags().StringVar(&pkg.Version, "version", "1.0.0", "Package version")
+	cmd.Flags().StringVar(&pkg.Release, "release", "", "Package release (the Debian revision, or the bottle rebuild number); the format's default when empty")
+	cmd.Flags().StringVar(&pkg.Arch, "arch", "", "Architecture, or bottle platform tag (default amd64, x86_64 or x86_64_linux)")
+	cmd.Flags().StringVar(&pkg.Compression, "compression", "", "Compression of deb and pacman packages: gzip, xz or zst (default xz for deb, zst for pacman)")
+	cmd.Flags().StringVarP(&outputDir, "output-dir", "o", ".", "Directory to write the package to")
+
+	return cmd
+}
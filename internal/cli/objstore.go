@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/objstore"
+	"github.com/sirupsen/logrus"
+)
+
+// pullBucket makes the output directory a copy of the repository in the
+// bucket, downloading the objects that changed since the last run
+func pullBucket(ctx context.Context, config *models.RepositoryConfig) error {
+	bucket, err := objstore.Open(config.Output)
+	if err != nil {
+		return &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+	}
+
+	result, err := objstore.Download(ctx, bucket, config.OutputDir)
+	if err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("failed to download %s: %w", bucket, err),
+		}
+	}
+
+	logrus.Infof("Downloaded %d files (%d bytes) from %s, %d unchanged", result.Transferred, result.Bytes, bucket, result.Unchanged)
+	return nil
+}
+
+// pushBucket uploads the files of the output directory whose content
+// changed to the bucket, in the order of pushSFTP, with the content types
+// clients expect
+func pushBucket(ctx context.Context, config *models.RepositoryConfig) error {
+	bucket, err := objstore.Open(config.Output)
+	if err != nil {
+		return &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+	}
+	stages, err := uploadStages(config)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Uploading repository to %s...", bucket)
+	result, err := objstore.Upload(ctx, bucket, config.OutputDir, stages, config.VerifyWrites)
+	if err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("failed to upload to %s: %w", bucket, err),
+		}
+	}
+
+	logrus.Infof("Uploaded %d files (%d bytes) to %s, %d unchanged, %d removed", result.Transferred, result.Bytes, bucket, result.Unchanged, result.Removed)
+	return nil
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/progress"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// barInterval is the delay between two redraws of the progress bar
+	barInterval = 200 * time.Millisecond
+
+	// progressLogInterval is the delay between two progress log lines,
+	// when the output isn't a terminal
+	progressLogInterval = 30 * time.Second
+)
+
+// startProgress reports the progress of the run, as a bar redrawn below the
+// logs on terminals or as periodic log lines otherwise, unless --no-progress
+// is set. It returns the context phases are reported through, and the
+// function ending the report
+func startProgress(ctx context.Context, config *models.RepositoryConfig, jsonOutput bool) (context.Context, func()) {
+	if config.NoProgress {
+		return ctx, func() {}
+	}
+
+	logger := logrus.StandardLogger()
+	out, ok := logger.Out.(*os.File)
+	if jsonOutput || !ok || !progress.IsTerminal(out) {
+		tracker := progress.Start(progress.Log{}, progressLogInterval)
+		return progress.WithTracker(ctx, tracker), tracker.Stop
+	}
+
+	// Logs go through the bar, keeping the colors of a terminal
+	bar := progress.NewBar(out)
+	formatter := logger.Formatter
+	logrus.SetFormatter(&logrus.TextFormatter{ForceColors: true})
+	logrus.SetOutput(bar)
+	tracker := progress.Start(bar, barInterval)
+	return progress.WithTracker(ctx, tracker), func() {
+		tracker.Stop()
+		logrus.SetOutput(out)
+		logrus.SetFormatter(formatter)
+	}
+}
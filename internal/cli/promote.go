@@ -0,0 +1,195 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// promotion is a package to promote: a name, and a version unless the
+// latest one is meant
+type promotion struct {
+	name, version string
+}
+
+// NewPromoteCmd creates the promote command
+func NewPromoteCmd() *cobra.Command {
+	var config models.RepositoryConfig
+	var from, fromCodename string
+	var specs []string
+
+	cmd := &cobra.Command{
+		Use:   "promote",
+		Short: "Copy packages from one repository to another, e.g. staging to stable",
+		Long: `Finds the given packages in the --from repository and adds their files to
+the --to repository (--output-dir), regenerating and re-signing only the
+metadata of their formats there. Packages already published in --to are
+skipped, so a promotion can be rerun.
+
+--from defaults to --to, to promote within one tree: between Debian suites
+with --from-codename and --codename, or between RPM release versions with
+--version. Every architecture of a package is promoted.
+
+Pass the same repository flags (signing keys, --arch, --repo-name, ...) as for
+generate so the metadata of --to is regenerated identically.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			promotions, err := parsePromotions(specs)
+			if err != nil {
+				return &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+			}
+			if err := validateRepositoryConfig(&config); err != nil {
+				return err
+			}
+
+			source := config
+			if from != "" {
+				source.OutputDir = from
+			}
+			if fromCodename != "" {
+				source.Codename = fromCodename
+			}
+			if source.OutputDir == config.OutputDir && source.Codename == config.Codename && !cmd.Flags().Changed("version") {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--from, --from-codename or --version must differ from the target repository"),
+				}
+			}
+
+			paths, err := promotedFiles(&source, promotions)
+			if err != nil {
+				return err
+			}
+			return runAdd(cmd.Context(), &config, paths, true)
+		},
+	}
+
+	cmd.Flags().StringVar(&from, "from", "", "Repository to promote the packages from (default: --to)")
+	cmd.Flags().StringVar(&fromCodename, "from-codename", "", "Debian suite of --from to promote the packages from (default: --codename)")
+	cmd.Flags().StringArrayVar(&specs, "package", nil, "Package to promote, as name=version, or name for its latest version (repeatable)")
+	addRepositoryFlags(cmd, &config)
+
+	// --to names the target repository, as --output-dir does for the other commands
+	cmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "to" {
+			name = "output-dir"
+		}
+		return pflag.NormalizedName(name)
+	})
+
+	return cmd
+}
+
+// parsePromotions parses the --package flags
+func parsePromotions(specs []string) ([]promotion, error) {
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("--package is required")
+	}
+
+	promotions := make([]promotion, 0, len(specs))
+	for _, spec := range specs {
+		name, version, _ := strings.Cut(spec, "=")
+		if name == "" || strings.HasSuffix(spec, "=") {
+			return nil, fmt.Errorf("invalid --package %q: expected name=version or name", spec)
+		}
+		promotions = append(promotions, promotion{name: name, version: version})
+	}
+	return promotions, nil
+}
+
+// promotedFiles returns the package files of the promotions published in
+// the source repository, every one of which must match a package
+func promotedFiles(source *models.RepositoryConfig, promotions []promotion) ([]string, error) {
+	generators := generator.New(source, generator.Signers{})
+	types := make([]scanner.PackageType, 0, len(generators))
+	for pkgType := range generators {
+		types = append(types, pkgType)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	found := make([]bool, len(promotions))
+	var paths []string
+	for _, pkgType := range types {
+		gen := generators[pkgType]
+		published, err := gen.ParseExistingMetadata(source)
+		if err != nil || len(published) == 0 {
+			continue
+		}
+
+		for i, p := range promotions {
+			matches := matchPromotion(pkgType, published, p)
+			if len(matches) == 0 {
+				continue
+			}
+			locator, ok := gen.(generator.PackageLocator)
+			if !ok {
+				return nil, &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("%s packages can't be promoted", pkgType),
+				}
+			}
+			found[i] = true
+			for _, pkg := range matches {
+				logrus.Infof("Promoting %s package %s %s (%s)", pkgType, pkg.Name, pkg.Version, pkg.Architecture)
+				paths = append(paths, locator.PackageFiles(source, pkg)[0])
+			}
+		}
+	}
+
+	var missing []string
+	for i, p := range promotions {
+		if !found[i] {
+			missing = append(missing, p.String())
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("not published in %s: %s", source.OutputDir, strings.Join(missing, ", ")),
+		}
+	}
+	return paths, nil
+}
+
+// matchPromotion returns the packages of pkgType published that p names:
+// every architecture of its version, or of the latest one. RPM versions may
+// include the release
+func matchPromotion(pkgType scanner.PackageType, published []models.Package, p promotion) []models.Package {
+	version := p.version
+	if version == "" {
+		compare := utils.VersionComparator(pkgType.String())
+		for _, pkg := range published {
+			if pkg.Name == p.name && (version == "" || compare(pkg.Version, version) > 0) {
+				version = pkg.Version
+			}
+		}
+	}
+
+	var matches []models.Package
+	for _, pkg := range published {
+		if pkg.Name != p.name {
+			continue
+		}
+		release, _ := pkg.Metadata["Release"].(string)
+		if pkg.Version == version || release != "" && pkg.Version+"-"+release == version {
+			matches = append(matches, pkg)
+		}
+	}
+	return matches
+}
+
+// String returns the promotion as given to --package
+func (p promotion) String() string {
+	if p.version == "" {
+		return p.name
+	}
+	return p.name + "=" + p.version
+}
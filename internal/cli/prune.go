@@ -0,0 +1,174 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/retention"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// prunableTypes lists the package types whose metadata can hold several
+// versions of a package. Homebrew formulae only ever reference one version
+var prunableTypes = []scanner.PackageType{
+	scanner.TypeDeb,
+	scanner.TypeRpm,
+	scanner.TypeApk,
+	scanner.TypePacman,
+	scanner.TypePypi,
+	scanner.TypeRubygem,
+	scanner.TypeCargo,
+	scanner.TypeNuget,
+	scanner.TypeConda,
+	scanner.TypeFdroid,
+	scanner.TypeXbps,
+	scanner.TypeTerraform,
+}
+
+// NewPruneCmd creates the prune command
+func NewPruneCmd() *cobra.Command {
+	var config models.RepositoryConfig
+	var policy retention.Policy
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove old package versions from a repository",
+		Long: `Reads the existing repository metadata, retires package versions that fall
+outside the retention policy, regenerates the metadata and deletes the retired
+package files. The newest version of each package is always kept.
+
+Pass the same repository flags (signing keys, --arch, --repo-name, ...) as for
+generate so the metadata is regenerated identically.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !policy.Enabled() {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("at least one of --keep or --keep-days is required"),
+				}
+			}
+			if policy.Keep < 0 || policy.KeepDays < 0 {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--keep and --keep-days must not be negative"),
+				}
+			}
+			if err := validateRepositoryConfig(&config); err != nil {
+				return err
+			}
+
+			policy.Now = time.Now()
+			return runPrune(cmd.Context(), &config, policy, dryRun)
+		},
+	}
+
+	addRepositoryFlags(cmd, &config)
+
+	// Retention policy
+	cmd.Flags().IntVar(&policy.Keep, "keep", 0, "Number of newest versions to keep per package and architecture")
+	cmd.Flags().IntVar(&policy.KeepDays, "keep-days", 0, "Keep versions built within this many days")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only report which versions would be removed")
+
+	return cmd
+}
+
+func runPrune(ctx context.Context, config *models.RepositoryConfig, policy retention.Policy, dryRun bool) error {
+	unlock, err := lockOutput(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	settings, err := loadPackageSettings(config)
+	if err != nil {
+		return err
+	}
+
+	generators, err := newGenerators(config)
+	if err != nil {
+		return err
+	}
+
+	for _, pkgType := range prunableTypes {
+		gen := generators[pkgType]
+
+		existing, err := gen.ParseExistingMetadata(config)
+		if err != nil {
+			logrus.Debugf("No %s repository to prune: %v", pkgType, err)
+			continue
+		}
+
+		locator, ok := gen.(generator.PackageLocator)
+		if !ok {
+			logrus.Warnf("Pruning is not supported for %s repositories", pkgType)
+			continue
+		}
+
+		keep, retire := policy.Select(pkgType, existing, func(pkg models.Package) (time.Time, bool) {
+			return packageBuildTime(config, locator, pkg)
+		})
+
+		logrus.Infof("%s: keeping %d of %d package versions", pkgType, len(keep), len(existing))
+		for _, pkg := range retire {
+			logrus.Infof("Retiring %s %s-%s (%s)", pkgType, pkg.Name, pkg.Version, pkg.Architecture)
+		}
+
+		if dryRun || len(retire) == 0 {
+			continue
+		}
+
+		if pkgType == scanner.TypePacman && config.RepoName == "" {
+			return &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err:  fmt.Errorf("--repo-name is required to regenerate Pacman (Arch Linux) repositories"),
+			}
+		}
+
+		// Regenerate first so the metadata never references deleted files
+		settings.apply(pkgType, keep)
+		if err := generateRepository(ctx, config, gen, pkgType, keep); err != nil {
+			return err
+		}
+
+		for _, pkg := range retire {
+			for _, path := range locator.PackageFiles(config, pkg) {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return &models.RepoGenError{
+						Type: models.ErrFileOp,
+						Err:  fmt.Errorf("failed to remove %s: %w", path, err),
+					}
+				}
+				logrus.Debugf("Removed %s", path)
+			}
+			removeProvenance(config, locator, pkg)
+		}
+	}
+
+	if dryRun {
+		logrus.Info("Dry run: no files were changed")
+	} else {
+		logrus.Info("Repository pruned successfully!")
+	}
+	return nil
+}
+
+// packageBuildTime returns when a package was built, falling back to the
+// modification time of its file when the metadata doesn't say
+func packageBuildTime(config *models.RepositoryConfig, locator generator.PackageLocator, pkg models.Package) (time.Time, bool) {
+	if built, ok := utils.BuildTime(pkg); ok {
+		return built, true
+	}
+
+	info, err := os.Stat(locator.PackageFiles(config, pkg)[0])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return info.ModTime(), true
+}
@@ -0,0 +1,296 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/generator/homebrew"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/provenance"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// removableTypes lists the package types remove looks for packages in
+var removableTypes = []scanner.PackageType{
+	scanner.TypeDeb,
+	scanner.TypeRpm,
+	scanner.TypeApk,
+	scanner.TypePacman,
+	scanner.TypeHomebrewBottle,
+	scanner.TypeHomebrewCask,
+	scanner.TypePypi,
+	scanner.TypeRubygem,
+	scanner.TypeCargo,
+	scanner.TypeNuget,
+	scanner.TypeConda,
+	scanner.TypeFdroid,
+	scanner.TypeXbps,
+	scanner.TypeTerraform,
+}
+
+// NewRemoveCmd creates the remove command
+func NewRemoveCmd() *cobra.Command {
+	var config models.RepositoryConfig
+	var name string
+
+	cmd := &cobra.Command{
+		Use:   "remove",
+		Short: "Remove a package from a repository",
+		Long: `Removes a package from the existing repository metadata, regenerates and
+re-signs the metadata of the architectures it was published for, then deletes
+the package file. Without --version, every version of the package is removed.
+
+Pass the same repository flags (signing keys, --arch, --repo-name, ...) as for
+generate so the metadata is regenerated identically.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if name == "" {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--name is required"),
+				}
+			}
+			if err := validateRepositoryConfig(&config); err != nil {
+				return err
+			}
+
+			// --version names the package version here; RPM distro versions
+			// come from the existing repository layout instead
+			version := config.Version
+			config.Version = ""
+
+			return runRemove(cmd.Context(), &config, name, version)
+		},
+	}
+
+	addRepositoryFlags(cmd, &config)
+
+	// Package selection
+	cmd.Flags().StringVar(&name, "name", "", "Name of the package to remove")
+	cmd.Flags().Lookup("version").Usage = "Version of the package to remove, with or without the RPM release (default: all versions)"
+
+	return cmd
+}
+
+// removal describes the packages removed from one repository type
+type removal struct {
+	pkgType    scanner.PackageType
+	gen        generator.Generator
+	locator    generator.PackageLocator
+	removed    []models.Package
+	regenerate []models.Package // Packages whose metadata is rewritten
+}
+
+func runRemove(ctx context.Context, config *models.RepositoryConfig, name, version string) error {
+	unlock, err := lockOutput(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	settings, err := loadPackageSettings(config)
+	if err != nil {
+		return err
+	}
+
+	generators, err := newGenerators(config)
+	if err != nil {
+		return err
+	}
+
+	// Plan every removal first, so a refused removal leaves the repository untouched
+	var plans []removal
+	for _, pkgType := range removableTypes {
+		gen := generators[pkgType]
+
+		existing, err := gen.ParseExistingMetadata(config)
+		if err != nil {
+			logrus.Debugf("No %s repository: %v", pkgType, err)
+			continue
+		}
+
+		plan := removal{pkgType: pkgType, gen: gen}
+		var remaining []models.Package
+		for _, pkg := range existing {
+			if pkg.Name == name && (version == "" || matchesVersion(pkg, version)) {
+				plan.removed = append(plan.removed, pkg)
+			} else {
+				remaining = append(remaining, pkg)
+			}
+		}
+		if len(plan.removed) == 0 {
+			continue
+		}
+
+		var ok bool
+		plan.locator, ok = gen.(generator.PackageLocator)
+		if !ok {
+			return &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err:  fmt.Errorf("removing packages is not supported for %s repositories", pkgType),
+			}
+		}
+
+		// Homebrew formulae and casks are self-contained: deleting them is enough
+		if !isHomebrew(pkgType) {
+			if pkgType == scanner.TypePacman && config.RepoName == "" {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--repo-name is required to regenerate Pacman (Arch Linux) repositories"),
+				}
+			}
+
+			plan.regenerate, err = affectedPackages(pkgType, remaining, plan.removed)
+			if err != nil {
+				return err
+			}
+		}
+
+		plans = append(plans, plan)
+	}
+
+	if len(plans) == 0 {
+		what := name
+		if version != "" {
+			what = fmt.Sprintf("%s %s", name, version)
+		}
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("package %s not found in %s", what, config.OutputDir),
+		}
+	}
+
+	for _, plan := range plans {
+		for _, pkg := range plan.removed {
+			logrus.Infof("Removing %s %s-%s (%s)", plan.pkgType, pkg.Name, pkg.Version, pkg.Architecture)
+		}
+
+		// Regenerate first so the metadata never references deleted files
+		if !isHomebrew(plan.pkgType) {
+			settings.apply(plan.pkgType, plan.regenerate)
+			if err := generateRepository(ctx, config, plan.gen, plan.pkgType, plan.regenerate); err != nil {
+				return err
+			}
+		}
+
+		for _, pkg := range plan.removed {
+			for _, path := range plan.locator.PackageFiles(config, pkg) {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return &models.RepoGenError{
+						Type: models.ErrFileOp,
+						Err:  fmt.Errorf("failed to remove %s: %w", path, err),
+					}
+				}
+				logrus.Debugf("Removed %s", path)
+			}
+			removeProvenance(config, plan.locator, pkg)
+		}
+
+		// Homebrew taps aren't regenerated: list what is left of them
+		if isHomebrew(plan.pkgType) {
+			if err := recordManifest(config, plan.gen, plan.pkgType); err != nil {
+				return err
+			}
+		}
+
+		// The JSON API index lists every formula document
+		if plan.pkgType == scanner.TypeHomebrewBottle {
+			if err := homebrew.RebuildAPIIndex(config.OutputDir); err != nil {
+				return &models.RepoGenError{
+					Type: models.ErrMetadataGen,
+					Err:  fmt.Errorf("failed to rebuild Homebrew API index: %w", err),
+				}
+			}
+		}
+	}
+
+	logrus.Info("Package removed successfully!")
+	return nil
+}
+
+// removeProvenance deletes the provenance record of a removed package
+func removeProvenance(config *models.RepositoryConfig, locator generator.PackageLocator, pkg models.Package) {
+	rel, err := filepath.Rel(config.OutputDir, locator.PackageFiles(config, pkg)[0])
+	if err != nil {
+		return
+	}
+	if err := provenance.Remove(config.OutputDir, filepath.ToSlash(rel)); err != nil {
+		logrus.Warnf("Failed to remove provenance of %s: %v", rel, err)
+	}
+}
+
+// isHomebrew reports whether pkgType is published in a Homebrew tap
+func isHomebrew(pkgType scanner.PackageType) bool {
+	return pkgType == scanner.TypeHomebrewBottle || pkgType == scanner.TypeHomebrewCask
+}
+
+// matchesVersion reports whether version names pkg, with or without the RPM release
+func matchesVersion(pkg models.Package, version string) bool {
+	if pkg.Version == version {
+		return true
+	}
+	release, ok := pkg.Metadata["Release"].(string)
+	return ok && release != "" && pkg.Version+"-"+release == version
+}
+
+// affectedPackages returns the remaining packages whose metadata has to be
+// regenerated: those published for the same architecture (and RPM distro
+// version) as a removed package. Debian repositories are regenerated as a
+// whole since a single Release file covers every architecture, PyPI,
+// RubyGems, Cargo, NuGet, F-Droid and Terraform ones since their indexes
+// cover every project, conda ones since subdirs without packages are emptied, and xbps
+// ones since noarch packages are listed in every architecture's index. So are
+// Pacman ones, listing "any" packages in every database, once the removed
+// packages are known not to empty one
+func affectedPackages(pkgType scanner.PackageType, remaining, removed []models.Package) ([]models.Package, error) {
+	if pkgType == scanner.TypeDeb || pkgType == scanner.TypePypi || pkgType == scanner.TypeRubygem || pkgType == scanner.TypeCargo || pkgType == scanner.TypeNuget || pkgType == scanner.TypeConda || pkgType == scanner.TypeFdroid || pkgType == scanner.TypeXbps || pkgType == scanner.TypeTerraform {
+		return remaining, nil
+	}
+
+	affected := make(map[string]bool)
+	for _, pkg := range removed {
+		affected[indexKey(pkg)] = true
+	}
+
+	var packages []models.Package
+	populated := make(map[string]bool)
+	for _, pkg := range remaining {
+		if key := indexKey(pkg); affected[key] {
+			packages = append(packages, pkg)
+			populated[key] = true
+		}
+	}
+
+	// Generators only write indexes for architectures that have packages,
+	// so an emptied index would keep referencing the deleted file
+	for _, pkg := range removed {
+		if !populated[indexKey(pkg)] && !(pkgType == scanner.TypePacman && pkg.Architecture == "any") {
+			return nil, &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err: fmt.Errorf("%s-%s is the last %s package for %s; delete that part of the repository or regenerate it instead",
+					pkg.Name, pkg.Version, pkgType, pkg.Architecture),
+			}
+		}
+	}
+
+	if pkgType == scanner.TypePacman {
+		return remaining, nil
+	}
+	return packages, nil
+}
+
+// indexKey identifies the metadata index a package is listed in
+func indexKey(pkg models.Package) string {
+	if dir, ok := pkg.Metadata["RepoDir"].(string); ok {
+		return dir
+	}
+	key := pkg.Architecture
+	if distroVersion, ok := pkg.Metadata["DistroVersion"].(string); ok {
+		key = distroVersion + "/" + key
+	}
+	return key
+}
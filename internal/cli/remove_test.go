@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+func TestRunRemove(t *testing.T) {
+	ctx := context.Background()
+	config := newTestConfig(t, t.TempDir())
+	packages := []string{
+		buildDeb(t, "hello", "1.0", "hello 1.0"),
+		buildDeb(t, "hello", "2.0", "hello 2.0"),
+		buildDeb(t, "world", "1.0", "world"),
+	}
+	if err := runAdd(ctx, config, packages, false); err != nil {
+		t.Fatalf("runAdd failed: %v", err)
+	}
+	pool := filepath.Join(config.OutputDir, "pool", "main")
+
+	// By name and version
+	if err := runRemove(ctx, config, "hello", "1.0"); err != nil {
+		t.Fatalf("runRemove of a version failed: %v", err)
+	}
+	index := debPackages(t, config.OutputDir)
+	if strings.Contains(index, "hello_1.0_amd64.deb") {
+		t.Errorf("hello 1.0 is still listed:\n%s", index)
+	}
+	if !strings.Contains(index, "hello_2.0_amd64.deb") || !strings.Contains(index, "Package: world\n") {
+		t.Errorf("Other packages were removed:\n%s", index)
+	}
+	if _, err := os.Stat(filepath.Join(pool, "h", "hello", "hello_1.0_amd64.deb")); !os.IsNotExist(err) {
+		t.Errorf("hello 1.0 is still in the pool: %v", err)
+	}
+
+	// No match leaves the repository as it was
+	err := runRemove(ctx, config, "missing", "")
+	var repoErr *models.RepoGenError
+	if !errors.As(err, &repoErr) || repoErr.Type != models.ErrInvalidConfig || !strings.Contains(err.Error(), "package missing not found") {
+		t.Fatalf("runRemove of a missing package = %v", err)
+	}
+	if err := runRemove(ctx, config, "world", "9.9"); err == nil {
+		t.Fatal("runRemove of a missing version succeeded")
+	}
+	if after := debPackages(t, config.OutputDir); after != index {
+		t.Errorf("Failed removals changed Packages:\n%s", after)
+	}
+
+	// By name, every version
+	if err := runRemove(ctx, config, "hello", ""); err != nil {
+		t.Fatalf("runRemove by name failed: %v", err)
+	}
+	index = debPackages(t, config.OutputDir)
+	if strings.Contains(index, "Package: hello\n") || !strings.Contains(index, "Package: world\n") {
+		t.Errorf("Unexpected Packages after removing hello:\n%s", index)
+	}
+	if _, err := os.Stat(filepath.Join(pool, "h", "hello", "hello_2.0_amd64.deb")); !os.IsNotExist(err) {
+		t.Errorf("hello 2.0 is still in the pool: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package cli
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ralt/repogen/internal/adopt"
+	"github.com/ralt/repogen/internal/config"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// runAllRepositories generates every repository of the --config file of
+// cmd, one after the other. A failed repository doesn't stop the others
+func runAllRepositories(cmd *cobra.Command, jsonOutput bool) error {
+	path, _ := cmd.Flags().GetString("config")
+	if path == "" {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("--all requires a --config file defining repositories"),
+		}
+	}
+	file, err := config.Load(path)
+	if err != nil {
+		return &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+	}
+	repos, err := file.Repositories()
+	if err != nil {
+		return &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+	}
+	if len(repos) == 0 {
+		return &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("%s defines no %s", path, config.RepositoriesKey),
+		}
+	}
+
+	env := config.DetectEnvironment(".")
+	cache := newPackageCache()
+	var failed []string
+	var firstErr error
+	for _, repo := range repos {
+		logrus.Infof("Generating repository %s...", repo.Name)
+		repoCmd, repoConfig, err := repositoryCommand(cmd, file, repo, *env)
+		if err == nil {
+			err = runGenerate(repoCmd, repoConfig, jsonOutput, cache)
+		}
+		if err != nil {
+			logrus.Errorf("Repository %s failed: %v", repo.Name, err)
+			failed = append(failed, repo.Name)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		errType := models.ErrMetadataGen
+		if repoErr, ok := firstErr.(*models.RepoGenError); ok {
+			errType = repoErr.Type
+		}
+		return &models.RepoGenError{
+			Type: errType,
+			Err:  fmt.Errorf("%d of %d repositories failed: %s", len(failed), len(repos), strings.Join(failed, ", ")),
+		}
+	}
+	logrus.Infof("Generated %d repositories", len(repos))
+	return nil
+}
+
+// repositoryCommand returns a generate command holding the flags of one
+// repository of a multi-repository file, and the configuration they fill.
+// Flags given on the command line win over the settings of the repository,
+// which win over the top-level ones of the file, then those of a repository
+// taken over by import --dir
+func repositoryCommand(cmd *cobra.Command, file config.File, repo config.Repository, env config.Environment) (*cobra.Command, *models.RepositoryConfig, error) {
+	var repoConfig models.RepositoryConfig
+	repoCmd := &cobra.Command{Use: cmd.Use}
+	repoCmd.SetContext(cmd.Context())
+	addGenerateFlags(repoCmd, &repoConfig)
+
+	var err error
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		target := repoCmd.Flags().Lookup(flag.Name)
+		if err != nil || target == nil || !fromCommandLine(flag) {
+			return
+		}
+		err = copyFlag(repoCmd.Flags(), target, flag)
+	})
+	if err != nil {
+		return nil, nil, &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+	}
+
+	env.Repository = repo.Name
+	for _, settings := range []config.File{repo.Settings, file} {
+		values, err := settings.Values(&env)
+		if err != nil {
+			return nil, nil, &models.RepoGenError{
+				Type: models.ErrInvalidConfig,
+				Err:  fmt.Errorf("repository %s: %w", repo.Name, err),
+			}
+		}
+		if err := applyConfigValues(repoCmd, values); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	settingsPath := filepath.Join(repoConfig.OutputDir, filepath.FromSlash(adopt.SettingsPath))
+	if _, err := os.Stat(settingsPath); err == nil {
+		if err := applyConfigFile(repoCmd, settingsPath); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return repoCmd, &repoConfig, nil
+}
+
+// copyFlag sets target, of flags, to the value of flag
+func copyFlag(flags *pflag.FlagSet, target, flag *pflag.Flag) error {
+	// The String of list flags is bracketed, not what Set takes
+	if from, ok := flag.Value.(pflag.SliceValue); ok {
+		if to, ok := target.Value.(pflag.SliceValue); ok {
+			target.Changed = true
+			return to.Replace(from.GetSlice())
+		}
+	}
+	return flags.Set(target.Name, flag.Value.String())
+}
+
+// packageCache keeps the packages parsed from input directories, so the
+// repositories of generate --all publishing from the same directory scan
+// and parse it once
+type packageCache struct {
+	parsed map[string]map[scanner.PackageType][]models.Package // By cacheKey
+}
+
+func newPackageCache() *packageCache {
+	return &packageCache{parsed: make(map[string]map[scanner.PackageType][]models.Package)}
+}
+
+// cacheKey identifies dir as scanned for config. Only the input directory
+// is cached: built and downloaded packages live in directories of their own
+func cacheKey(config *models.RepositoryConfig, dir string) (string, bool) {
+	if dir != config.InputDir {
+		return "", false
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	return strings.Join([]string{abs, strings.Join(config.Include, ","), strings.Join(config.Exclude, ",")}, "\x00"), true
+}
+
+// lookup returns a copy of the packages parsed from dir, which the caller
+// may modify
+func (c *packageCache) lookup(config *models.RepositoryConfig, dir string) (map[scanner.PackageType][]models.Package, bool) {
+	if c == nil {
+		return nil, false
+	}
+	key, ok := cacheKey(config, dir)
+	if !ok {
+		return nil, false
+	}
+	parsed, ok := c.parsed[key]
+	if !ok {
+		return nil, false
+	}
+	return clonePackages(parsed), true
+}
+
+// store records the packages parsed from dir
+func (c *packageCache) store(config *models.RepositoryConfig, dir string, parsed map[scanner.PackageType][]models.Package) {
+	if c == nil {
+		return
+	}
+	if key, ok := cacheKey(config, dir); ok {
+		c.parsed[key] = clonePackages(parsed)
+	}
+}
+
+// clonePackages copies packages deep enough for filters, overrides and
+// generators to modify the copy
+func clonePackages(packagesByType map[scanner.PackageType][]models.Package) map[scanner.PackageType][]models.Package {
+	clone := make(map[scanner.PackageType][]models.Package, len(packagesByType))
+	for pkgType, packages := range packagesByType {
+		copied := make([]models.Package, len(packages))
+		for i, pkg := range packages {
+			pkg.Translations = maps.Clone(pkg.Translations)
+			pkg.Metadata = maps.Clone(pkg.Metadata)
+			copied[i] = pkg
+		}
+		clone[pkgType] = copied
+	}
+	return clone
+}
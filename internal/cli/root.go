@@ -1,15 +1,32 @@
 package cli
 
 import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ralt/repogen/internal/adopt"
+	"github.com/ralt/repogen/internal/events"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+// buildVersion is the repogen version recorded in provenance records
+var buildVersion = "dev"
+
 // NewRootCmd creates the root command
-func NewRootCmd() *cobra.Command {
+func NewRootCmd(version string) *cobra.Command {
+	buildVersion = version
+
+	var eventsFd int
+	var eventsFile string
+	var eventsOut io.Closer
+	var configPath string
+
 	rootCmd := &cobra.Command{
-		Use:   "repogen",
-		Short: "Generate static repository structures for multiple package managers",
+		Use:     "repogen",
+		Version: version,
+		Short:   "Generate static repository structures for multiple package managers",
 		Long: `Repogen scans directories for package files and generates static
 repository structures that can be served as websites.
 
@@ -19,7 +36,7 @@ Supported package types:
   - Alpine/APK (.apk packages)
   - Arch/Pacman (.pkg.tar.* packages)
   - Homebrew (bottle files)`,
-		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			// Setup logging
 			verbose, _ := cmd.Flags().GetBool("verbose")
 			if verbose {
@@ -27,14 +44,68 @@ Supported package types:
 			} else {
 				logrus.SetLevel(logrus.InfoLevel)
 			}
+			if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
+				logrus.SetFormatter(&logrus.JSONFormatter{})
+			}
+
+			// Fill in the flags not given on the command line
+			if configPath != "" {
+				if err := applyConfigFile(cmd, configPath); err != nil {
+					return err
+				}
+			}
+
+			// Then from the settings of a repository taken over by import --dir
+			if flag := cmd.Flags().Lookup("output-dir"); flag != nil {
+				settings := filepath.Join(flag.Value.String(), filepath.FromSlash(adopt.SettingsPath))
+				if _, err := os.Stat(settings); err == nil {
+					if err := applyConfigFile(cmd, settings); err != nil {
+						return err
+					}
+				}
+			}
+
+			// Setup the events stream
+			var err error
+			eventsOut, err = events.Open(eventsFd, eventsFile)
+			return err
+		},
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			if eventsOut != nil {
+				events.SetOutput(nil)
+				eventsOut.Close()
+			}
 		},
 	}
 
 	// Global flags
 	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "Enable verbose logging")
+	rootCmd.PersistentFlags().Bool("json", false, "Log as JSON, and have generate write a .repogen/report.json report of the files written")
+	rootCmd.PersistentFlags().IntVar(&eventsFd, "events-fd", 0, "Write newline-delimited JSON progress events to this inherited file descriptor (e.g. 3)")
+	rootCmd.PersistentFlags().StringVar(&eventsFile, "events-file", "", "Append newline-delimited JSON progress events to this file")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "YAML/JSON file with default flag values, expanded as templates against git and CI environment")
 
 	// Add subcommands
 	rootCmd.AddCommand(NewGenerateCmd())
+	rootCmd.AddCommand(NewAddCmd())
+	rootCmd.AddCommand(NewRemoveCmd())
+	rootCmd.AddCommand(NewWatchCmd())
+	rootCmd.AddCommand(NewPruneCmd())
+	rootCmd.AddCommand(NewMirrorCmd())
+	rootCmd.AddCommand(NewSnapshotCmd())
+	rootCmd.AddCommand(NewPromoteCmd())
+	rootCmd.AddCommand(NewExportCmd())
+	rootCmd.AddCommand(NewImportCmd())
+	rootCmd.AddCommand(NewAnalyzeLogsCmd())
+	rootCmd.AddCommand(NewSelfUpdateCmd())
+	rootCmd.AddCommand(NewServeCmd())
+	rootCmd.AddCommand(NewDaemonCmd())
+	rootCmd.AddCommand(NewSearchCmd())
+	rootCmd.AddCommand(NewStatsCmd())
+	rootCmd.AddCommand(NewDiffCmd())
+	rootCmd.AddCommand(NewLintCmd())
+	rootCmd.AddCommand(NewMkFixtureCmd())
+	rootCmd.AddCommand(NewKeygenCmd())
 
 	return rootCmd
 }
@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ralt/repogen/internal/manifest"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// searchResult is a package found in one of the searched repositories
+type searchResult struct {
+	Repo string `json:"repo"`
+	manifest.Entry
+	Location string `json:"location,omitempty"` // Package file, as a local path or URL
+}
+
+// NewSearchCmd creates the search command
+func NewSearchCmd() *cobra.Command {
+	var config models.RepositoryConfig
+	var repos []string
+	var jsonOutput bool
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "search [field=value|field~regex]...",
+		Short: "Find packages across several repositories",
+		Long: `Searches the packages published in local repository directories and in
+repositories served over HTTP(S), and lists the matching versions with the
+location of their package files.
+
+Terms compare name, version, arch or type, exactly (name=hello) or against a
+regular expression (name~^lib); a bare term is a regular expression on the
+name, and every term must match. Without terms, every package is listed.
+
+Repositories are searched through the package manifest every generation
+writes (.repogen/packages.json). Local repositories generated before it
+existed are read from their metadata instead, using --codename, --components
+and --arch for Debian ones.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(repos) == 0 {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--repos is required"),
+				}
+			}
+			query, err := manifest.ParseQuery(args)
+			if err != nil {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  err,
+				}
+			}
+
+			client := &http.Client{Timeout: timeout}
+			var results []searchResult
+			searched := 0
+			for _, repo := range repos {
+				entries, err := repositoryPackages(cmd, client, config, repo)
+				if err != nil {
+					logrus.Warnf("Skipping %s: %v", repo, err)
+					continue
+				}
+				searched++
+
+				for _, entry := range entries {
+					if query.Match(entry) {
+						results = append(results, searchResult{Repo: repo, Entry: entry, Location: packageLocation(repo, entry.Path)})
+					}
+				}
+			}
+			if searched == 0 {
+				return &models.RepoGenError{
+					Type: models.ErrFileOp,
+					Err:  fmt.Errorf("none of the repositories could be searched"),
+				}
+			}
+
+			if jsonOutput {
+				if results == nil {
+					results = []searchResult{}
+				}
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(results)
+			}
+			if len(results) == 0 {
+				logrus.Info("No matching packages")
+				return nil
+			}
+			return printSearchResults(cmd.OutOrStdout(), results)
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&repos, "repos", nil, "Repository directories or URLs to search (comma-separated)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the matches as JSON")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "Timeout of each request to a remote repository")
+	cmd.Flags().StringVar(&config.Codename, "codename", "stable", "Codename of local Debian repositories without a package manifest")
+	cmd.Flags().StringSliceVar(&config.Components, "components", []string{"main"}, "Components of local Debian repositories without a package manifest")
+	cmd.Flags().StringSliceVar(&config.Arches, "arch", []string{"amd64"}, "Architectures of local Debian repositories without a package manifest")
+
+	return cmd
+}
+
+// isRemoteRepo reports whether repo is a URL rather than a directory
+func isRemoteRepo(repo string) bool {
+	return strings.HasPrefix(repo, "http://") || strings.HasPrefix(repo, "https://")
+}
+
+// repositoryPackages lists the packages published in repo, from its
+// manifest or, for local repositories without one, from its metadata
+func repositoryPackages(cmd *cobra.Command, client *http.Client, config models.RepositoryConfig, repo string) ([]manifest.Entry, error) {
+	if isRemoteRepo(repo) {
+		m, err := manifest.Fetch(cmd.Context(), client, repo)
+		if err != nil {
+			return nil, err
+		}
+		return m.Packages, nil
+	}
+
+	if _, err := os.Stat(repo); err != nil {
+		return nil, err
+	}
+	m, err := manifest.Read(repo)
+	if err == nil {
+		return m.Packages, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	logrus.Debugf("No package manifest in %s, reading its metadata", repo)
+	config.OutputDir = repo
+	generators, err := newGenerators(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []manifest.Entry
+	for _, pkgType := range removableTypes {
+		gen := generators[pkgType]
+		published, err := gen.ParseExistingMetadata(&config)
+		if err != nil {
+			logrus.Debugf("No %s repository in %s: %v", pkgType, repo, err)
+			continue
+		}
+		entries = append(entries, manifestEntries(&config, gen, published)...)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no published packages found")
+	}
+	return entries, nil
+}
+
+// packageLocation returns where the package file at rel is found in repo
+func packageLocation(repo, rel string) string {
+	if rel == "" {
+		return ""
+	}
+	if isRemoteRepo(repo) {
+		return strings.TrimRight(repo, "/") + "/" + rel
+	}
+	return filepath.Join(repo, filepath.FromSlash(rel))
+}
+
+// printSearchResults writes the matches as a table
+func printSearchResults(w io.Writer, results []searchResult) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPO\tTYPE\tNAME\tVERSION\tARCH\tLOCATION")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Repo, r.Type, r.Name, r.Version, r.Architecture, r.Location)
+	}
+	return tw.Flush()
+}
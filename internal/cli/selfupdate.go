@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/selfupdate"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewSelfUpdateCmd creates the self-update command
+func NewSelfUpdateCmd() *cobra.Command {
+	var checkOnly, force bool
+	var keyPath string
+
+	cmd := &cobra.Command{
+		Use:   "self-update",
+		Short: "Replace this binary with the latest release",
+		Long: `Checks the latest GitHub release of repogen and, when it is newer than
+this binary, downloads the archive for this platform, checks it against the
+release checksums.txt and atomically replaces the running binary.
+
+With --key, checksums.txt must carry a detached signature (checksums.txt.sig)
+by the given release key; without it only the checksums are verified.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var keyring openpgp.EntityList
+			if keyPath != "" {
+				var err error
+				keyring, err = selfupdate.ReadKeyring(keyPath)
+				if err != nil {
+					return &models.RepoGenError{
+						Type: models.ErrInvalidConfig,
+						Err:  err,
+					}
+				}
+			}
+
+			updater := selfupdate.New()
+			rel, err := updater.Latest(cmd.Context())
+			if err != nil {
+				return &models.RepoGenError{
+					Type: models.ErrFileOp,
+					Err:  err,
+				}
+			}
+
+			current := buildVersion
+			if !force && current != "dev" && utils.CompareSemanticVersions(rel.Version, current) <= 0 {
+				logrus.Infof("repogen %s is up to date (latest release: %s)", current, rel.Tag)
+				return nil
+			}
+			if checkOnly {
+				logrus.Infof("repogen %s is available (running %s)", rel.Tag, current)
+				return nil
+			}
+			if !force && current == "dev" {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("this is a development build; pass --force to replace it with %s", rel.Tag),
+				}
+			}
+
+			exe, err := os.Executable()
+			if err == nil {
+				exe, err = filepath.EvalSymlinks(exe)
+			}
+			if err != nil {
+				return &models.RepoGenError{
+					Type: models.ErrFileOp,
+					Err:  fmt.Errorf("cannot locate the running binary: %w", err),
+				}
+			}
+
+			if keyring == nil {
+				logrus.Warn("No --key given: checking the release checksums only, not their signature")
+			}
+			binary, err := updater.Download(cmd.Context(), rel, keyring)
+			if err != nil {
+				return &models.RepoGenError{
+					Type: models.ErrSigning,
+					Err:  fmt.Errorf("failed to verify release %s: %w", rel.Tag, err),
+				}
+			}
+
+			if err := selfupdate.Replace(exe, binary); err != nil {
+				return &models.RepoGenError{
+					Type: models.ErrFileOp,
+					Err:  err,
+				}
+			}
+
+			logrus.Infof("Updated %s from %s to %s", exe, current, rel.Tag)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&checkOnly, "check", false, "Only report whether a newer release is available")
+	cmd.Flags().BoolVar(&force, "force", false, "Install the latest release even if it is not newer, or over a development build")
+	cmd.Flags().StringVar(&keyPath, "key", "", "Public key (armored or binary) that must have signed the release checksums")
+
+	return cmd
+}
@@ -0,0 +1,235 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ralt/repogen/internal/manifest"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/status"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewServeCmd creates the serve command
+func NewServeCmd() *cobra.Command {
+	var outputDir, listen string
+	var maxAge time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a generated repository over HTTP",
+		Long: `Serves the repository in the output directory as static files, for small
+deployments behind a load balancer.
+
+/healthz answers 200 once the repository has been generated, and 503 while
+it hasn't or, with --max-age, when the last generation is older than that.
+/metrics exposes the last generation time, package counts and write
+verification of each package type in the Prometheus text format.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if maxAge < 0 {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--max-age must not be negative"),
+				}
+			}
+			if info, err := os.Stat(outputDir); err != nil || !info.IsDir() {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("output directory %s does not exist", outputDir),
+				}
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			return runServe(ctx, outputDir, listen, maxAge)
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputDir, "output-dir", "o", "./repo", "Repository to serve")
+	cmd.Flags().StringVar(&listen, "listen", ":8080", "Address to listen on")
+	cmd.Flags().DurationVar(&maxAge, "max-age", 0, "Report unhealthy when the last generation is older than this (0 to disable)")
+
+	return cmd
+}
+
+func runServe(ctx context.Context, outputDir, listen string, maxAge time.Duration) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		serveHealth(w, outputDir, maxAge)
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		serveMetrics(w, outputDir)
+	})
+	mux.Handle("/", repositoryFiles(outputDir))
+
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		logrus.Infof("Serving %s on %s", outputDir, listen)
+		errc <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("server failed: %w", err),
+		}
+	case <-ctx.Done():
+	}
+
+	// Let in-flight downloads finish
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("server shutdown failed: %w", err),
+		}
+	}
+	return nil
+}
+
+// repositoryFiles serves the files of outputDir, leaving out the dot files
+// and directories holding repogen's state (.repogen, the lock, the parse
+// cache) but the package manifest, which search reads
+func repositoryFiles(outputDir string) http.Handler {
+	return http.FileServer(publicFS{http.Dir(outputDir)})
+}
+
+// publicFS hides the dot-prefixed paths of a FileSystem, as if missing
+type publicFS struct {
+	http.FileSystem
+}
+
+func (fs publicFS) Open(name string) (http.File, error) {
+	if name != "/"+manifest.Path {
+		for _, segment := range strings.Split(name, "/") {
+			if strings.HasPrefix(segment, ".") {
+				return nil, os.ErrNotExist
+			}
+		}
+	}
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return publicFile{f}, nil
+}
+
+// publicFile leaves dot-prefixed entries out of directory listings
+type publicFile struct {
+	http.File
+}
+
+func (f publicFile) Readdir(n int) ([]os.FileInfo, error) {
+	entries, err := f.File.Readdir(n)
+	visible := entries[:0]
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".") {
+			visible = append(visible, entry)
+		}
+	}
+	return visible, err
+}
+
+// serveHealth reports whether the repository is generated, and recently
+// enough when maxAge is set
+func serveHealth(w http.ResponseWriter, outputDir string, maxAge time.Duration) {
+	response := struct {
+		Status         string                       `json:"status"`
+		Error          string                       `json:"error,omitempty"`
+		LastGeneration *time.Time                   `json:"last_generation,omitempty"`
+		Repositories   map[string]status.Generation `json:"repositories,omitempty"`
+	}{Status: "ok"}
+	code := http.StatusOK
+
+	s, err := status.Read(outputDir)
+	switch {
+	case os.IsNotExist(err):
+		code, response.Status, response.Error = http.StatusServiceUnavailable, "unavailable", "repository not generated yet"
+	case err != nil:
+		code, response.Status, response.Error = http.StatusServiceUnavailable, "unavailable", err.Error()
+	default:
+		last := s.LastGeneration()
+		response.LastGeneration = &last
+		response.Repositories = s.Repositories
+		if maxAge > 0 && time.Since(last) > maxAge {
+			code, response.Status = http.StatusServiceUnavailable, "stale"
+			response.Error = fmt.Sprintf("last generation is older than %s", maxAge)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
+
+// serveMetrics writes the generation status in the Prometheus text format
+func serveMetrics(w http.ResponseWriter, outputDir string) {
+	s, err := status.Read(outputDir)
+	if err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("Cannot read repository status: %v", err)
+	}
+	if s == nil {
+		s = &status.Status{}
+	}
+
+	var b strings.Builder
+	up := 0
+	if len(s.Repositories) > 0 {
+		up = 1
+	}
+	fmt.Fprintf(&b, "# HELP repogen_repository_generated Whether the repository has been generated.\n")
+	fmt.Fprintf(&b, "# TYPE repogen_repository_generated gauge\n")
+	fmt.Fprintf(&b, "repogen_repository_generated %d\n", up)
+
+	types := s.Types()
+	metrics := []struct {
+		name, help string
+		value      func(status.Generation) string
+	}{
+		{"repogen_last_generation_timestamp_seconds", "Time of the last generation of the repository, per package type.", func(gen status.Generation) string {
+			return fmt.Sprintf("%d", gen.GeneratedAt.Unix())
+		}},
+		{"repogen_packages", "Packages in the last generation of the repository, per package type.", func(gen status.Generation) string {
+			return fmt.Sprintf("%d", gen.Packages)
+		}},
+		{"repogen_verified_writes", "Whether the last generation verified the files it wrote, per package type.", func(gen status.Generation) string {
+			if gen.VerifiedWrites {
+				return "1"
+			}
+			return "0"
+		}},
+	}
+	for _, m := range metrics {
+		if len(types) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", m.name)
+		for _, pkgType := range types {
+			fmt.Fprintf(&b, "%s{type=%q} %s\n", m.name, pkgType, m.value(s.Repositories[pkgType]))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(b.String()))
+}
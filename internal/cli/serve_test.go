@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, dir, rel, content string) {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepositoryFilesHidesState(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "dists/stable/Release", "Release")
+	writeTestFile(t, dir, ".repogen.lock", "1234 build-host 2025-01-01T00:00:00Z")
+	writeTestFile(t, dir, ".repogen-cache", "/home/ci/dist")
+	writeTestFile(t, dir, ".repogen/status.json", "{}")
+	writeTestFile(t, dir, ".repogen/packages.json", `{"packages":[]}`)
+	writeTestFile(t, dir, "pool/.hidden/x.deb", "x")
+
+	server := httptest.NewServer(repositoryFiles(dir))
+	defer server.Close()
+
+	for path, want := range map[string]int{
+		"/dists/stable/Release":   http.StatusOK,
+		"/.repogen/packages.json": http.StatusOK,
+		"/.repogen.lock":          http.StatusNotFound,
+		"/.repogen-cache":         http.StatusNotFound,
+		"/.repogen/status.json":   http.StatusNotFound,
+		"/.repogen/":              http.StatusNotFound,
+		"/pool/.hidden/x.deb":     http.StatusNotFound,
+		"/pool/../.repogen.lock":  http.StatusNotFound,
+	} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(listing), "dists/") || strings.Contains(string(listing), ".repogen") {
+		t.Errorf("Listing = %s", listing)
+	}
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/sftp"
+	"github.com/sirupsen/logrus"
+)
+
+// dialSFTP connects to the server of config.Output
+func dialSFTP(config *models.RepositoryConfig) (*sftp.Client, *sftp.Target, error) {
+	target, err := sftp.ParseURL(config.Output)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := sftp.Dial(target, sftp.Options{
+		KeyPath:        config.SSHKeyPath,
+		KnownHostsPath: config.SSHKnownHosts,
+		Verify:         config.VerifyWrites,
+	})
+	if err != nil {
+		return nil, nil, &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  err,
+		}
+	}
+	return client, target, nil
+}
+
+// pullSFTP makes the output directory a copy of the repository on the
+// server, downloading the files that changed since the last run
+func pullSFTP(ctx context.Context, config *models.RepositoryConfig) error {
+	client, target, err := dialSFTP(config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	result, err := client.Download(ctx, config.OutputDir)
+	if err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("failed to download %s: %w", target, err),
+		}
+	}
+
+	logrus.Infof("Downloaded %d files (%d bytes) from %s, %d unchanged", result.Transferred, result.Bytes, target, result.Unchanged)
+	return nil
+}
+
+// pushSFTP uploads the files of the output directory that changed to the
+// server: packages first, then metadata, then signatures, so that clients
+// never see metadata referencing missing files
+func pushSFTP(ctx context.Context, config *models.RepositoryConfig) error {
+	client, target, err := dialSFTP(config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	stages, err := uploadStages(config)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Uploading repository to %s...", target)
+	result, err := client.Upload(ctx, config.OutputDir, stages)
+	if err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("failed to upload to %s: %w", target, err),
+		}
+	}
+
+	logrus.Infof("Uploaded %d files (%d bytes) to %s, %d unchanged, %d removed", result.Transferred, result.Bytes, target, result.Unchanged, result.Removed)
+	return nil
+}
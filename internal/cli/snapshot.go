@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ralt/repogen/internal/lock"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/snapshot"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewSnapshotCmd creates the snapshot command
+func NewSnapshotCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Capture and publish frozen views of a repository",
+		Long: `Snapshots are immutable copies of a repository kept in its snapshots/
+directory, whatever its formats: package files are hardlinked and metadata is
+copied, so each one costs little space. Every snapshot is a complete
+repository, served at <repository URL>/snapshots/<name>/.
+
+A snapshot can later be published to another directory, or over the
+repository itself to roll it back.`,
+	}
+
+	cmd.AddCommand(newSnapshotCreateCmd())
+	cmd.AddCommand(newSnapshotListCmd())
+	cmd.AddCommand(newSnapshotPublishCmd())
+
+	return cmd
+}
+
+func newSnapshotCreateCmd() *cobra.Command {
+	var config models.RepositoryConfig
+
+	cmd := &cobra.Command{
+		Use:   "create <name>",
+		Short: "Capture the current packages and metadata of a repository",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := snapshot.ValidateName(args[0]); err != nil {
+				return &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+			}
+
+			// Capture a repository no run is writing
+			unlock, err := lockOutput(cmd.Context(), &config)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+
+			info, err := snapshot.Create(config.OutputDir, args[0], time.Now())
+			if err != nil {
+				return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+			}
+			logrus.Infof("Created snapshot %s: %d files, %d packages", info.Name, info.Files, info.Packages)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&config.OutputDir, "output-dir", "o", "./repo", "Repository to snapshot")
+	cmd.Flags().DurationVar(&config.LockWait, "wait", 0, "Wait this long for another run writing the repository to finish (e.g. 5m), instead of failing at once")
+
+	return cmd
+}
+
+func newSnapshotListCmd() *cobra.Command {
+	var outputDir string
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the snapshots of a repository",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			snapshots, err := snapshot.List(outputDir)
+			if err != nil {
+				return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+			}
+
+			if jsonOutput {
+				if snapshots == nil {
+					snapshots = []snapshot.Info{}
+				}
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(snapshots)
+			}
+			if len(snapshots) == 0 {
+				logrus.Infof("%s has no snapshots", outputDir)
+				return nil
+			}
+
+			tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tCREATED\tPACKAGES\tFILES")
+			for _, s := range snapshots {
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%d\n", s.Name, s.Created.Format(time.RFC3339), s.Packages, s.Files)
+			}
+			return tw.Flush()
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputDir, "output-dir", "o", "./repo", "Repository whose snapshots to list")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the snapshots as JSON")
+
+	return cmd
+}
+
+func newSnapshotPublishCmd() *cobra.Command {
+	var outputDir, dest string
+	var wait time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "publish <name>",
+		Short: "Serve a snapshot from a directory, or roll the repository back to it",
+		Long: `Builds the snapshot in a staging directory next to --to and swaps it into
+place, so clients never see a partial repository. Package files are
+hardlinked and metadata is copied, so the published tree can be regenerated
+without changing the snapshot.
+
+--to defaults to the repository itself, rolling it back to the snapshot; its
+snapshots are kept. Another non-empty directory is only replaced when it
+holds a repository, such as a previously published snapshot.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := snapshot.ValidateName(args[0]); err != nil {
+				return &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+			}
+			if dest == "" {
+				dest = outputDir
+			}
+
+			// The published tree is replaced: wait for the runs writing it
+			l, err := lock.Acquire(cmd.Context(), dest, wait)
+			if err != nil {
+				return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+			}
+			defer func() {
+				if err := l.Release(); err != nil {
+					logrus.Warnf("Failed to release the lock of %s: %v", dest, err)
+				}
+			}()
+
+			info, err := snapshot.Publish(outputDir, args[0], dest)
+			if err != nil {
+				return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+			}
+			if filepath.Clean(dest) == filepath.Clean(outputDir) {
+				logrus.Infof("Rolled %s back to snapshot %s of %s", outputDir, info.Name, info.Created.Format(time.RFC3339))
+			} else {
+				logrus.Infof("Published snapshot %s to %s", info.Name, dest)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputDir, "output-dir", "o", "./repo", "Repository holding the snapshot")
+	cmd.Flags().StringVar(&dest, "to", "", "Directory to publish the snapshot to (default: the repository itself)")
+	cmd.Flags().DurationVar(&wait, "wait", 0, "Wait this long for another run writing --to to finish (e.g. 5m), instead of failing at once")
+
+	return cmd
+}
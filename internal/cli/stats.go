@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/stats"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewStatsCmd creates the stats command
+func NewStatsCmd() *cobra.Command {
+	var config models.RepositoryConfig
+	var top int
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Summarize the packages published in a repository",
+		Long: `Reads the metadata of a repository and reports, per package type, how many
+packages it publishes and how much space they take, the packages taking the
+most space, the packages published in several versions for an architecture
+(and the space all but their newest version take), the oldest and newest
+builds, and the packages each architecture lacks compared to the others.
+
+Packages that don't record when they were built are left out of the oldest
+and newest builds.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if config.Codename == "" || len(config.Components) == 0 || len(config.Arches) == 0 {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--codename, --components and --arch must not be empty"),
+				}
+			}
+
+			collector, err := collectStats(&config)
+			if err != nil {
+				return err
+			}
+
+			report := collector.Report(top)
+			if jsonOutput {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(report)
+			}
+			return printStats(cmd.OutOrStdout(), report)
+		},
+	}
+
+	cmd.Flags().StringVar(&config.OutputDir, "dir", "./repo", "Repository directory to summarize")
+	cmd.Flags().StringVar(&config.Codename, "codename", "stable", "Codename for Debian repos")
+	cmd.Flags().StringSliceVar(&config.Components, "components", []string{"main"}, "Components for Debian repos")
+	cmd.Flags().StringSliceVar(&config.Arches, "arch", []string{"amd64"}, "Architectures to support")
+	cmd.Flags().IntVar(&top, "top", 10, "Number of largest packages to list, 0 for all")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the report as JSON")
+
+	return cmd
+}
+
+// collectStats registers every package published in the repository
+func collectStats(config *models.RepositoryConfig) (*stats.Collector, error) {
+	generators, err := newGenerators(config)
+	if err != nil {
+		return nil, err
+	}
+
+	collector := stats.NewCollector()
+	found := 0
+	for _, pkgType := range removableTypes {
+		existing, err := generators[pkgType].ParseExistingMetadata(config)
+		if err != nil {
+			logrus.Debugf("No %s repository: %v", pkgType, err)
+			continue
+		}
+		collector.Add(pkgType.String(), existing)
+		found += len(existing)
+	}
+
+	if found == 0 {
+		return nil, &models.RepoGenError{
+			Type: models.ErrInvalidConfig,
+			Err:  fmt.Errorf("no published packages found in %s", config.OutputDir),
+		}
+	}
+	return collector, nil
+}
+
+// printStats writes the report as tables
+func printStats(w io.Writer, report *stats.Report) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tPACKAGES\tSIZE\tOLDEST BUILD\tNEWEST BUILD")
+	for _, t := range report.Types {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", t.Type, t.Packages, utils.FormatBytes(t.Size), buildDate(t.Oldest), buildDate(t.Newest))
+	}
+	fmt.Fprintf(tw, "total\t%d\t%s\t%s\t%s\n", report.Packages, utils.FormatBytes(report.Size), buildDate(report.Oldest), buildDate(report.Newest))
+
+	fmt.Fprintln(tw, "\nTYPE\tARCH\tPACKAGES\tMISSING")
+	for _, t := range report.Types {
+		for _, a := range t.Architectures {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", t.Type, a.Architecture, a.Packages, strings.Join(a.Missing, ", "))
+		}
+	}
+
+	fmt.Fprintln(tw, "\nTYPE\tNAME\tVERSIONS\tFILES\tSIZE")
+	for _, p := range report.Largest {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", p.Type, p.Name, p.Versions, p.Files, utils.FormatBytes(p.Size))
+	}
+
+	if len(report.Duplicates) > 0 {
+		fmt.Fprintln(tw, "\nTYPE\tNAME\tARCH\tVERSIONS\tRECLAIMABLE")
+		for _, d := range report.Duplicates {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", d.Type, d.Name, d.Architecture, strings.Join(d.Versions, ", "), utils.FormatBytes(d.Size))
+		}
+	}
+	return tw.Flush()
+}
+
+// buildDate returns the day b was built, "-" when unknown
+func buildDate(b *stats.Build) string {
+	if b == nil {
+		return "-"
+	}
+	return b.Time.Format("2006-01-02")
+}
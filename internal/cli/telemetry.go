@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"context"
+	"time"
+
+	"github.com/ralt/repogen/internal/events"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/telemetry"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// telemetryExportTimeout bounds pushing metrics and exporting the trace,
+// which happen after the deadlines of the run
+const telemetryExportTimeout = 30 * time.Second
+
+// startTelemetry has ctx record the metrics and trace of the run when they
+// are exported, and returns the function exporting them once the run ended
+// with err
+func startTelemetry(ctx context.Context, config *models.RepositoryConfig) (context.Context, func(err error) error) {
+	if config.MetricsFile == "" && config.MetricsPush == "" && config.OTLPEndpoint == "" {
+		return ctx, func(error) error { return nil }
+	}
+
+	rec := telemetry.New()
+	stop := events.Observe(rec.Observe)
+	ctx, span := telemetry.Start(telemetry.WithRecorder(ctx, rec), "run", nil)
+
+	return ctx, func(err error) error {
+		span.End(err)
+		stop()
+		run := rec.Finish(err)
+
+		// Monitoring being down mustn't fail a publish
+		exportCtx, cancel := context.WithTimeout(context.Background(), telemetryExportTimeout)
+		defer cancel()
+		slug := utils.RepoSlug(config)
+		if config.MetricsPush != "" {
+			if err := telemetry.Push(exportCtx, nil, config.MetricsPush, telemetry.Labels{"repository": slug}, run); err != nil {
+				logrus.Warnf("Failed to push metrics: %v", err)
+			} else {
+				logrus.Infof("Pushed metrics to %s", config.MetricsPush)
+			}
+		}
+		if config.OTLPEndpoint != "" {
+			resource := telemetry.Labels{"service.version": buildVersion, "repogen.repository": slug}
+			if err := telemetry.ExportTraces(exportCtx, nil, config.OTLPEndpoint, resource, run); err != nil {
+				logrus.Warnf("Failed to export trace: %v", err)
+			} else {
+				logrus.Infof("Exported trace to %s", config.OTLPEndpoint)
+			}
+		}
+
+		if config.MetricsFile != "" {
+			if err := telemetry.WriteFile(config.MetricsFile, run); err != nil {
+				return &models.RepoGenError{Type: models.ErrFileOp, Err: err}
+			}
+			logrus.Infof("Metrics written to %s", config.MetricsFile)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/vuln"
+	"github.com/sirupsen/logrus"
+)
+
+// checkVulnerabilities looks the packages about to be published up in the
+// vulnerability report or OSV, failing or warning as config.VulnPolicy says
+func checkVulnerabilities(ctx context.Context, config *models.RepositoryConfig, packagesByType map[scanner.PackageType][]models.Package) error {
+	if config.VulnPolicy == "" || config.VulnPolicy == vuln.PolicyIgnore {
+		return nil
+	}
+
+	var packages []vuln.Package
+	for pkgType, pkgs := range packagesByType {
+		for _, pkg := range pkgs {
+			version := pkg.Version
+			// RPM advisories name the release too
+			if release, _ := pkg.Metadata["Release"].(string); release != "" {
+				version += "-" + release
+			}
+			packages = append(packages, vuln.Package{Ecosystem: vuln.Ecosystem(pkgType.String()), Name: pkg.Name, Version: version})
+		}
+	}
+	sort.Slice(packages, func(i, j int) bool { return packages[i].String() < packages[j].String() })
+
+	var findings []vuln.Finding
+	if config.VulnReport != "" {
+		report, err := vuln.LoadReport(config.VulnReport)
+		if err != nil {
+			return &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+		}
+		findings = report.Check(packages)
+		logrus.Infof("Checked %d packages against %s", len(packages), config.VulnReport)
+	} else {
+		var err error
+		findings, err = vuln.QueryOSV(ctx, nil, config.OSVURL, packages)
+		if err != nil {
+			return &models.RepoGenError{Type: models.ErrFileOp, Err: fmt.Errorf("vulnerability check failed: %w", err)}
+		}
+		logrus.Infof("Checked %d packages against OSV", len(packages))
+	}
+
+	if len(findings) == 0 {
+		return nil
+	}
+	var vulnerable []string
+	for _, f := range findings {
+		logrus.Warnf("%s is vulnerable: %s", f.Package, strings.Join(f.IDs, ", "))
+		vulnerable = append(vulnerable, f.Package.String())
+	}
+	if config.VulnPolicy == vuln.PolicyFail {
+		return &models.RepoGenError{
+			Type: models.ErrVulnerable,
+			Err:  fmt.Errorf("%d package(s) with known vulnerabilities: %s", len(findings), strings.Join(vulnerable, ", ")),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/ralt/repogen/internal/filter"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewWatchCmd creates the watch command
+func NewWatchCmd() *cobra.Command {
+	var config models.RepositoryConfig
+	var debounce time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Publish packages as they appear in a directory",
+		Long: `Watches the input directory and publishes new packages into the repository,
+like add, once no file has changed for the debounce delay. Packages already
+present when watching starts are published too; packages already in the
+repository are skipped. Runs until interrupted.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if config.InputDir == "" {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("input-dir is required"),
+				}
+			}
+			if debounce <= 0 {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  fmt.Errorf("--debounce must be positive"),
+				}
+			}
+			if err := validateRepositoryConfig(&config); err != nil {
+				return err
+			}
+			if err := filter.Validate(&config); err != nil {
+				return &models.RepoGenError{
+					Type: models.ErrInvalidConfig,
+					Err:  err,
+				}
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			return runWatch(ctx, &config, debounce)
+		},
+	}
+
+	cmd.Flags().StringVarP(&config.InputDir, "input-dir", "i", ".", "Directory to watch for new packages")
+	addRepositoryFlags(cmd, &config)
+	cmd.Flags().StringSliceVar(&config.Include, "include", nil, "Only publish files whose name matches one of these globs (e.g. '*_amd64.deb')")
+	cmd.Flags().StringSliceVar(&config.Exclude, "exclude", nil, "Skip files whose name matches one of these globs (e.g. '*-dbgsym*')")
+	cmd.Flags().DurationVar(&debounce, "debounce", 2*time.Second, "Wait this long after the last change before publishing")
+
+	return cmd
+}
+
+// fileState identifies a version of a file, so rewritten packages are picked up again
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func runWatch(ctx context.Context, config *models.RepositoryConfig, debounce time.Duration) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("failed to create watcher: %w", err),
+		}
+	}
+	defer watcher.Close()
+
+	// fsnotify isn't recursive: watch every directory, including new ones
+	if err := watchTree(watcher, config.InputDir); err != nil {
+		return &models.RepoGenError{
+			Type: models.ErrFileOp,
+			Err:  fmt.Errorf("failed to watch %s: %w", config.InputDir, err),
+		}
+	}
+
+	logrus.Infof("Watching %s for new packages (debounce %s)", config.InputDir, debounce)
+
+	seen := make(map[string]fileState)
+	publishPending(ctx, config, seen)
+
+	w := &changeWatcher{
+		config:   config,
+		debounce: debounce,
+		events:   watcher.Events,
+		errors:   watcher.Errors,
+		addDir:   func(dir string) error { return watchTree(watcher, dir) },
+		publish:  func() { publishPending(ctx, config, seen) },
+	}
+	return w.run(ctx)
+}
+
+// changeWatcher batches the changes reported on events, and publishes once
+// no change has come in for debounce
+type changeWatcher struct {
+	config   *models.RepositoryConfig
+	debounce time.Duration
+	events   <-chan fsnotify.Event
+	errors   <-chan error
+	addDir   func(dir string) error // Watches a new directory and its subdirectories
+	publish  func()
+}
+
+func (w *changeWatcher) run(ctx context.Context) error {
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Info("Stopped watching")
+			return nil
+
+		case event, ok := <-w.events:
+			if !ok {
+				return nil
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) == 0 {
+				continue
+			}
+			// Directories moved in bring packages along without events of their own
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := w.addDir(event.Name); err != nil {
+						logrus.Warnf("Failed to watch %s: %v", event.Name, err)
+					}
+					timer.Reset(w.debounce)
+					continue
+				}
+			}
+			if !w.relevant(event.Name) {
+				logrus.Debugf("Ignoring change: %s", event)
+				continue
+			}
+			logrus.Debugf("Change detected: %s", event)
+			timer.Reset(w.debounce)
+
+		case err, ok := <-w.errors:
+			if !ok {
+				return nil
+			}
+			logrus.Warnf("Watch error: %v", err)
+
+		case <-timer.C:
+			w.publish()
+		}
+	}
+}
+
+// relevant reports whether a change to path may change the packages to
+// publish: partial files and files filtered out by --include/--exclude
+// don't delay publishing
+func (w *changeWatcher) relevant(path string) bool {
+	if isPartialFile(path) {
+		return false
+	}
+	rel, err := filepath.Rel(w.config.InputDir, path)
+	if err != nil {
+		return true
+	}
+	return scanner.Matches(w.config.Include, w.config.Exclude, filepath.ToSlash(rel))
+}
+
+// partialSuffixes end the names of files still being downloaded or uploaded
+var partialSuffixes = []string{".part", ".partial", ".tmp", ".crdownload", ".download", ".filepart", "~"}
+
+// isPartialFile reports whether path looks like a file still being written:
+// hidden files, like the temporary copies of rsync, and the leftovers of
+// browsers and transfer clients
+func isPartialFile(path string) bool {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+	for _, suffix := range partialSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchTree adds dir and all its subdirectories to watcher
+func watchTree(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// publishPending publishes the packages that are new or changed since they
+// were last seen. Failures are logged rather than returned so watching
+// goes on; failed packages are retried once they change again
+func publishPending(ctx context.Context, config *models.RepositoryConfig, seen map[string]fileState) {
+	scanned, err := scanner.NewFileSystemScanner(config.Include, config.Exclude).Scan(ctx, config.InputDir)
+	if err != nil {
+		logrus.Errorf("Failed to scan %s: %v", config.InputDir, err)
+		return
+	}
+
+	var pending []string
+	states := make(map[string]fileState)
+	for _, pkg := range scanned {
+		// A package shows up under its final name once complete
+		if isPartialFile(pkg.Path) {
+			logrus.Debugf("Skipping %s: partial file", pkg.Path)
+			continue
+		}
+		info, err := os.Stat(pkg.Path)
+		if err != nil {
+			continue
+		}
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		if seen[pkg.Path] != state {
+			pending = append(pending, pkg.Path)
+			states[pkg.Path] = state
+		}
+	}
+
+	if len(pending) == 0 {
+		return
+	}
+
+	logrus.Infof("Publishing %d new package(s)", len(pending))
+	if err := runAdd(ctx, config, pending, true); err != nil {
+		logrus.Errorf("Failed to publish packages: %v", err)
+	}
+
+	for path, state := range states {
+		seen[path] = state
+	}
+}
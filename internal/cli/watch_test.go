@@ -0,0 +1,214 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/ralt/repogen/internal/models"
+)
+
+func TestIsPartialFile(t *testing.T) {
+	for path, want := range map[string]bool{
+		"incoming/hello_1.0_amd64.deb":             false,
+		"incoming/hello-1.0-1.x86_64.rpm":          false,
+		"incoming/.hello_1.0_amd64.deb.Xy12ab":     true,
+		"incoming/hello_1.0_amd64.deb.part":        true,
+		"incoming/hello_1.0_amd64.deb.partial":     true,
+		"incoming/hello_1.0_amd64.deb.tmp":         true,
+		"incoming/hello_1.0_amd64.deb.crdownload":  true,
+		"incoming/hello_1.0_amd64.deb.download":    true,
+		"incoming/hello_1.0_amd64.deb.filepart":    true,
+		"incoming/hello_1.0_amd64.deb~":            true,
+		"incoming/partial/hello_1.0_amd64.deb":     false,
+		"incoming/.uploads/hello_1.0_amd64.deb":    false,
+		"incoming/hello_1.0_amd64.deb.part.sha256": false,
+	} {
+		if got := isPartialFile(path); got != want {
+			t.Errorf("isPartialFile(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+// startChangeWatcher runs a changeWatcher over config until the test ends,
+// and returns the channel it reports changes on and the one receiving the
+// times it published at
+func startChangeWatcher(t *testing.T, config *models.RepositoryConfig, debounce time.Duration) (chan<- fsnotify.Event, <-chan time.Time) {
+	t.Helper()
+	events := make(chan fsnotify.Event)
+	published := make(chan time.Time, 10)
+	w := &changeWatcher{
+		config:   config,
+		debounce: debounce,
+		events:   events,
+		errors:   make(chan error),
+		addDir:   func(string) error { return nil },
+		publish:  func() { published <- time.Now() },
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("run failed: %v", err)
+		}
+	})
+	return events, published
+}
+
+// expectNoPublish fails when published receives anything within wait
+func expectNoPublish(t *testing.T, published <-chan time.Time, wait time.Duration) {
+	t.Helper()
+	select {
+	case <-published:
+		t.Fatal("Published unexpectedly")
+	case <-time.After(wait):
+	}
+}
+
+func TestChangeWatcherDebounce(t *testing.T) {
+	const debounce = 100 * time.Millisecond
+	dir := t.TempDir()
+	events, published := startChangeWatcher(t, &models.RepositoryConfig{InputDir: dir}, debounce)
+
+	// A burst of changes is published once, after the last one
+	var last time.Time
+	for i := 0; i < 5; i++ {
+		events <- fsnotify.Event{Name: filepath.Join(dir, "hello_1.0_amd64.deb"), Op: fsnotify.Write}
+		last = time.Now()
+		time.Sleep(debounce / 4)
+	}
+	select {
+	case at := <-published:
+		if at.Sub(last) < debounce {
+			t.Errorf("Published %s after the last change, before the debounce delay", at.Sub(last))
+		}
+	case <-time.After(10 * debounce):
+		t.Fatal("The changes were never published")
+	}
+	expectNoPublish(t, published, 3*debounce)
+
+	// Later changes make another batch
+	events <- fsnotify.Event{Name: filepath.Join(dir, "world_1.0_amd64.deb"), Op: fsnotify.Create}
+	select {
+	case <-published:
+	case <-time.After(10 * debounce):
+		t.Fatal("The second batch was never published")
+	}
+}
+
+func TestChangeWatcherIgnoresChanges(t *testing.T) {
+	const debounce = 50 * time.Millisecond
+	dir := t.TempDir()
+	config := &models.RepositoryConfig{InputDir: dir, Include: []string{"*.deb"}, Exclude: []string{"*-dbgsym_*"}}
+	events, published := startChangeWatcher(t, config, debounce)
+
+	for _, event := range []fsnotify.Event{
+		{Name: filepath.Join(dir, "hello_1.0_amd64.deb"), Op: fsnotify.Chmod},
+		{Name: filepath.Join(dir, "hello_1.0_amd64.deb"), Op: fsnotify.Remove},
+		{Name: filepath.Join(dir, "README.txt"), Op: fsnotify.Create},
+		{Name: filepath.Join(dir, "hello-dbgsym_1.0_amd64.deb"), Op: fsnotify.Write},
+		{Name: filepath.Join(dir, ".hello_1.0_amd64.deb.Xy12ab"), Op: fsnotify.Write},
+		{Name: filepath.Join(dir, "hello_1.0_amd64.deb.part"), Op: fsnotify.Write},
+	} {
+		events <- event
+	}
+	expectNoPublish(t, published, 5*debounce)
+
+	// Until the upload is renamed to its final name
+	events <- fsnotify.Event{Name: filepath.Join(dir, "hello_1.0_amd64.deb"), Op: fsnotify.Create}
+	select {
+	case <-published:
+	case <-time.After(20 * debounce):
+		t.Fatal("The renamed upload was never published")
+	}
+}
+
+func TestChangeWatcherWatchesNewDirectories(t *testing.T) {
+	const debounce = 50 * time.Millisecond
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "batch")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan fsnotify.Event)
+	added := make(chan string, 1)
+	published := make(chan struct{}, 1)
+	w := &changeWatcher{
+		// Directories are watched whatever the globs
+		config:   &models.RepositoryConfig{InputDir: dir, Include: []string{"*.deb"}},
+		debounce: debounce,
+		events:   events,
+		errors:   make(chan error),
+		addDir:   func(dir string) error { added <- dir; return nil },
+		publish:  func() { published <- struct{}{} },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.run(ctx)
+
+	events <- fsnotify.Event{Name: sub, Op: fsnotify.Create}
+	select {
+	case got := <-added:
+		if got != sub {
+			t.Errorf("Watched %s, want %s", got, sub)
+		}
+	case <-time.After(20 * debounce):
+		t.Fatal("The new directory was never watched")
+	}
+	// Its packages came in without events of their own
+	select {
+	case <-published:
+	case <-time.After(20 * debounce):
+		t.Fatal("The new directory was never published")
+	}
+}
+
+func TestPublishPendingSkipsPartialFiles(t *testing.T) {
+	ctx := context.Background()
+	input := t.TempDir()
+	config := newTestConfig(t, t.TempDir())
+	config.InputDir = input
+
+	copyFile := func(src, name string) string {
+		t.Helper()
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst := filepath.Join(input, name)
+		if err := os.WriteFile(dst, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return dst
+	}
+	partial := copyFile(buildDeb(t, "hello", "1.0", "hello"), "hello_1.0_amd64.deb.part")
+	copyFile(buildDeb(t, "rsync", "1.0", "rsync"), ".rsync_1.0_amd64.deb.Xy12ab")
+	copyFile(buildDeb(t, "world", "1.0", "world"), "world_1.0_amd64.deb")
+
+	seen := make(map[string]fileState)
+	publishPending(ctx, config, seen)
+	index := debPackages(t, config.OutputDir)
+	if !strings.Contains(index, "Package: world\n") || strings.Contains(index, "Package: hello\n") || strings.Contains(index, "Package: rsync\n") {
+		t.Errorf("Unexpected Packages:\n%s", index)
+	}
+	if len(seen) != 1 {
+		t.Errorf("Seen %v, want world only", seen)
+	}
+
+	// Complete once renamed
+	if err := os.Rename(partial, strings.TrimSuffix(partial, ".part")); err != nil {
+		t.Fatal(err)
+	}
+	publishPending(ctx, config, seen)
+	if index := debPackages(t, config.OutputDir); !strings.Contains(index, "Package: hello\n") {
+		t.Errorf("hello was not published once renamed:\n%s", index)
+	}
+}
@@ -0,0 +1,221 @@
+// Package clientsetup writes install.sh, the script configuring a machine
+// to install packages from a repository with its package manager
+package clientsetup
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// ScriptName is the install script published at the root of repositories
+const ScriptName = "install.sh"
+
+// Setup describes the client configuration files a repository publishes,
+// as paths relative to its base URL. Those of the formats it doesn't
+// publish are empty, like the keys of unsigned repositories
+type Setup struct {
+	BaseURL string
+	Name    string // Repository name shown by the script
+
+	AptSources string // deb822 sources file, for /etc/apt/sources.list.d
+	AptKeyring string // Binary keyring Signed-By references
+	AptKeyPath string // Where Signed-By expects the keyring
+
+	RepoFile string // dnf and zypper .repo file
+
+	APKRepositories string // Line of /etc/apk/repositories
+	APKKey          string // RSA public key, for /etc/apk/keys
+
+	PacmanConf  string // pacman.conf section
+	PacmanKey   string // Armored public key for pacman-key --add
+	PacmanKeyID string // Fingerprint of PacmanKey, locally signed
+}
+
+// Empty reports whether the repository publishes no client configuration
+func (s Setup) Empty() bool {
+	return s.AptSources == "" && s.RepoFile == "" && s.APKRepositories == "" && s.PacmanConf == ""
+}
+
+var funcs = template.FuncMap{
+	"quote": shellQuote,
+	"base":  path.Base,
+	"dir":   path.Dir,
+}
+
+// The first package manager found configures the repository. Running the
+// script again leaves the configuration as it is
+var script = template.Must(template.New(ScriptName).Funcs(funcs).Parse(`#!/bin/sh
+# Configures the {{.Name}} repository on this machine:
+#
+#   curl -fsSL {{.BaseURL}}/install.sh | sudo sh
+#
+# Generated by repogen
+set -eu
+
+base_url={{quote .BaseURL}}
+
+fetch() {
+	if command -v curl >/dev/null 2>&1; then
+		curl -fsSL "$base_url/$1" -o "$2"
+	elif command -v wget >/dev/null 2>&1; then
+		wget -qO "$2" "$base_url/$1"
+	else
+		echo "curl or wget is required" >&2
+		exit 1
+	fi
+}
+
+if [ "$(id -u)" -ne 0 ]; then
+	echo "This script must run as root" >&2
+	exit 1
+fi
+{{- if .AptSources}}
+
+if command -v apt-get >/dev/null 2>&1; then
+{{- if .AptKeyring}}
+	install -d -m 0755 {{quote (dir .AptKeyPath)}}
+	fetch {{quote .AptKeyring}} {{quote .AptKeyPath}}
+	chmod 0644 {{quote .AptKeyPath}}
+{{- end}}
+	fetch {{quote .AptSources}} {{quote (print "/etc/apt/sources.list.d/" (base .AptSources))}}
+	apt-get update
+	exit 0
+fi
+{{- end}}
+{{- if .RepoFile}}
+
+if command -v dnf >/dev/null 2>&1 || command -v yum >/dev/null 2>&1; then
+	fetch {{quote .RepoFile}} {{quote (print "/etc/yum.repos.d/" (base .RepoFile))}}
+	exit 0
+fi
+
+if command -v zypper >/dev/null 2>&1; then
+	fetch {{quote .RepoFile}} {{quote (print "/etc/zypp/repos.d/" (base .RepoFile))}}
+	zypper --non-interactive --gpg-auto-import-keys refresh
+	exit 0
+fi
+{{- end}}
+{{- if .APKRepositories}}
+
+if command -v apk >/dev/null 2>&1; then
+{{- if .APKKey}}
+	fetch {{quote .APKKey}} {{quote (print "/etc/apk/keys/" (base .APKKey))}}
+{{- end}}
+	line=$(mktemp)
+	fetch {{quote .APKRepositories}} "$line"
+	grep -qxF -f "$line" /etc/apk/repositories || cat "$line" >> /etc/apk/repositories
+	rm -f "$line"
+	apk update
+	exit 0
+fi
+{{- end}}
+{{- if .PacmanConf}}
+
+if command -v pacman >/dev/null 2>&1; then
+{{- if .PacmanKey}}
+	key=$(mktemp)
+	fetch {{quote .PacmanKey}} "$key"
+	pacman-key --add "$key"
+	pacman-key --lsign-key {{quote .PacmanKeyID}}
+	rm -f "$key"
+{{- end}}
+	conf=$(mktemp)
+	fetch {{quote .PacmanConf}} "$conf"
+	if ! grep -qxF "$(head -n 1 "$conf")" /etc/pacman.conf; then
+		printf '\n' >> /etc/pacman.conf
+		cat "$conf" >> /etc/pacman.conf
+	fi
+	rm -f "$conf"
+	pacman -Sy
+	exit 0
+fi
+{{- end}}
+
+echo "No supported package manager found" >&2
+exit 1
+`))
+
+// Script returns the install script of s
+func Script(s Setup) ([]byte, error) {
+	s.BaseURL = strings.TrimSuffix(s.BaseURL, "/")
+	var buf bytes.Buffer
+	if err := script.Execute(&buf, s); err != nil {
+		return nil, fmt.Errorf("failed to generate %s: %w", ScriptName, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Write writes the install script of s into outputDir
+func Write(outputDir string, s Setup) error {
+	data, err := Script(s)
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFile(filepath.Join(outputDir, ScriptName), data, 0755); err != nil {
+		return fmt.Errorf("failed to write %s: %w", ScriptName, err)
+	}
+	return nil
+}
+
+// shellQuote quotes s as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Instruction shows how to configure one package manager by hand
+type Instruction struct {
+	Client   string
+	Commands []string
+}
+
+// Instructions returns the commands configuring each package manager s
+// publishes files for, starting with the install script
+func (s Setup) Instructions() []Instruction {
+	if s.Empty() {
+		return nil
+	}
+	base := strings.TrimSuffix(s.BaseURL, "/")
+	download := func(name, dest string) string {
+		return fmt.Sprintf("sudo curl -fsSL -o %s %s/%s", dest, base, name)
+	}
+
+	instructions := []Instruction{{Client: "Any", Commands: []string{fmt.Sprintf("curl -fsSL %s/%s | sudo sh", base, ScriptName)}}}
+	if s.AptSources != "" {
+		var commands []string
+		if s.AptKeyring != "" {
+			commands = append(commands, "sudo install -d -m 0755 "+path.Dir(s.AptKeyPath), download(s.AptKeyring, s.AptKeyPath))
+		}
+		commands = append(commands, download(s.AptSources, "/etc/apt/sources.list.d/"+path.Base(s.AptSources)), "sudo apt-get update")
+		instructions = append(instructions, Instruction{Client: "apt", Commands: commands})
+	}
+	if s.RepoFile != "" {
+		instructions = append(instructions,
+			Instruction{Client: "dnf", Commands: []string{download(s.RepoFile, "/etc/yum.repos.d/"+path.Base(s.RepoFile))}},
+			Instruction{Client: "zypper", Commands: []string{fmt.Sprintf("sudo zypper addrepo %s/%s", base, s.RepoFile)}})
+	}
+	if s.APKRepositories != "" {
+		var commands []string
+		if s.APKKey != "" {
+			commands = append(commands, download(s.APKKey, "/etc/apk/keys/"+path.Base(s.APKKey)))
+		}
+		commands = append(commands, fmt.Sprintf("curl -fsSL %s/%s | sudo tee -a /etc/apk/repositories", base, s.APKRepositories), "sudo apk update")
+		instructions = append(instructions, Instruction{Client: "apk", Commands: commands})
+	}
+	if s.PacmanConf != "" {
+		var commands []string
+		if s.PacmanKey != "" {
+			commands = append(commands,
+				fmt.Sprintf("curl -fsSL %s/%s | sudo pacman-key --add -", base, s.PacmanKey),
+				"sudo pacman-key --lsign-key "+s.PacmanKeyID)
+		}
+		commands = append(commands, fmt.Sprintf("curl -fsSL %s/%s | sudo tee -a /etc/pacman.conf", base, s.PacmanConf), "sudo pacman -Sy")
+		instructions = append(instructions, Instruction{Client: "pacman", Commands: commands})
+	}
+	return instructions
+}
@@ -0,0 +1,86 @@
+package clientsetup
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScript(t *testing.T) {
+	setup := Setup{
+		BaseURL:         "https://example.com/repo/",
+		Name:            "Example's Repo",
+		AptSources:      "example.sources",
+		AptKeyring:      "keys/example.gpg",
+		AptKeyPath:      "/etc/apt/keyrings/example.gpg",
+		APKRepositories: "repositories",
+		APKKey:          "keys/example.rsa.pub",
+	}
+	script, err := Script(setup)
+	if err != nil {
+		t.Fatalf("Script failed: %v", err)
+	}
+
+	for _, expected := range []string{
+		"base_url='https://example.com/repo'\n",
+		"\tfetch 'keys/example.gpg' '/etc/apt/keyrings/example.gpg'\n",
+		"\tfetch 'example.sources' '/etc/apt/sources.list.d/example.sources'\n",
+		"\tfetch 'keys/example.rsa.pub' '/etc/apk/keys/example.rsa.pub'\n",
+	} {
+		if !strings.Contains(string(script), expected) {
+			t.Errorf("Script lacks %q:\n%s", expected, script)
+		}
+	}
+
+	// Formats the repository doesn't publish are left out
+	for _, unexpected := range []string{"dnf", "zypper", "pacman"} {
+		if strings.Contains(string(script), unexpected) {
+			t.Errorf("Script configures %s:\n%s", unexpected, script)
+		}
+	}
+
+	if sh, err := exec.LookPath("sh"); err == nil {
+		path := filepath.Join(t.TempDir(), ScriptName)
+		if err := os.WriteFile(path, script, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command(sh, "-n", path).CombinedOutput(); err != nil {
+			t.Errorf("Script doesn't parse: %v\n%s", err, out)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	setup := Setup{
+		BaseURL:     "https://example.com/arch",
+		Name:        "Example",
+		PacmanConf:  "pacman.conf",
+		PacmanKey:   "keys/example.asc",
+		PacmanKeyID: "0123456789ABCDEF0123456789ABCDEF01234567",
+		RepoFile:    "fedora.repo",
+	}
+	if setup.Empty() || !(Setup{BaseURL: setup.BaseURL}).Empty() {
+		t.Error("Empty doesn't tell repositories without client configuration")
+	}
+	if err := Write(dir, setup); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, ScriptName))
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Fatalf("Script mode is %v: %v", info, err)
+	}
+	script, _ := os.ReadFile(filepath.Join(dir, ScriptName))
+	for _, expected := range []string{
+		"\tpacman-key --lsign-key '0123456789ABCDEF0123456789ABCDEF01234567'\n",
+		"\tfetch 'fedora.repo' '/etc/yum.repos.d/fedora.repo'\n",
+		"\tfetch 'fedora.repo' '/etc/zypp/repos.d/fedora.repo'\n",
+	} {
+		if !strings.Contains(string(script), expected) {
+			t.Errorf("Script lacks %q:\n%s", expected, script)
+		}
+	}
+}
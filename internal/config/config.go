@@ -0,0 +1,247 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File holds settings read from a configuration file, keyed by flag name
+// (e.g. "origin", "codename", "arch"). Values are Go templates expanded
+// against the build Environment, so one file can serve every pipeline
+type File map[string]interface{}
+
+// RepositoriesKey holds the repositories of a multi-repository file, by name
+const RepositoriesKey = "repositories"
+
+// Environment is the data configuration templates are expanded with
+type Environment struct {
+	Env        map[string]string // Process environment
+	Git        Git
+	Repository string // Name of the repository being configured, from a multi-repository file
+}
+
+// Git describes the commit being published, taken from CI variables or git itself
+type Git struct {
+	Tag         string // Tag pointing at the commit, if any (e.g. "v1.2.3")
+	Branch      string // Branch being built, empty for detached tag builds
+	Ref         string // Tag or branch name, as CI_COMMIT_REF_NAME
+	Commit      string // Full commit hash
+	ShortCommit string // First 7 characters of Commit
+}
+
+// Load reads a configuration file (YAML or JSON)
+func Load(path string) (File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return f, nil
+}
+
+// Repository is a repository of a multi-repository file
+type Repository struct {
+	Name     string
+	Settings File // Overriding the top-level settings of the file
+}
+
+// Repositories returns the repositories the file defines under
+// RepositoriesKey, sorted by name
+func (f File) Repositories() ([]Repository, error) {
+	raw, ok := asFile(f[RepositoriesKey])
+	if !ok {
+		if f[RepositoriesKey] != nil {
+			return nil, fmt.Errorf("%q must map repository names to their settings", RepositoriesKey)
+		}
+		return nil, nil
+	}
+
+	repos := make([]Repository, 0, len(raw))
+	for name, settings := range raw {
+		repo := Repository{Name: name, Settings: File{}}
+		if settings != nil {
+			if repo.Settings, ok = asFile(settings); !ok {
+				return nil, fmt.Errorf("settings of repository %q must be a mapping", name)
+			}
+		}
+		if _, nested := repo.Settings[RepositoriesKey]; nested {
+			return nil, fmt.Errorf("repository %q cannot define repositories", name)
+		}
+		repos = append(repos, repo)
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+	return repos, nil
+}
+
+// asFile returns v as settings if it is a mapping, which YAML decodes
+// nested in a File as a File itself
+func asFile(v interface{}) (File, bool) {
+	switch m := v.(type) {
+	case File:
+		return m, true
+	case map[string]interface{}:
+		return File(m), true
+	}
+	return nil, false
+}
+
+// Values expands every setting and returns it in flag syntax, lists being
+// joined with commas. The repositories of a multi-repository file are left out
+func (f File) Values(env *Environment) (map[string]string, error) {
+	values := make(map[string]string, len(f))
+
+	names := make([]string, 0, len(f))
+	for name := range f {
+		if name == RepositoriesKey {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var items []interface{}
+		switch v := f[name].(type) {
+		case nil:
+			continue
+		case []interface{}:
+			items = v
+		case File, map[string]interface{}:
+			return nil, fmt.Errorf("config setting %q must be a value or a list", name)
+		default:
+			items = []interface{}{v}
+		}
+
+		expanded := make([]string, 0, len(items))
+		for _, item := range items {
+			s, err := expand(name, fmt.Sprint(item), env)
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, s)
+		}
+		values[name] = strings.Join(expanded, ",")
+	}
+
+	return values, nil
+}
+
+// funcs are the helpers available to templates, taking the piped value last
+var funcs = template.FuncMap{
+	"default": func(def, value string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	},
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+}
+
+// expand evaluates one setting's template
+func expand(name, text string, env *Environment) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template for %q: %w", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, env); err != nil {
+		return "", fmt.Errorf("failed to expand %q: %w", name, err)
+	}
+
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// DetectEnvironment collects the process environment and describes the
+// commit being built from the variables of common CI systems (GitHub
+// Actions, GitLab CI, Buildkite, CircleCI, Drone), falling back to asking
+// git about dir
+func DetectEnvironment(dir string) *Environment {
+	env := &Environment{Env: make(map[string]string)}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env.Env[k] = v
+		}
+	}
+	get := func(names ...string) string {
+		for _, name := range names {
+			if v := env.Env[name]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	git := &env.Git
+	git.Tag = get("GIT_TAG", "CI_COMMIT_TAG", "BUILDKITE_TAG", "CIRCLE_TAG", "DRONE_TAG")
+	git.Branch = get("GIT_BRANCH", "CI_COMMIT_BRANCH", "GITHUB_HEAD_REF", "BUILDKITE_BRANCH", "CIRCLE_BRANCH", "DRONE_BRANCH")
+	switch env.Env["GITHUB_REF_TYPE"] {
+	case "tag":
+		if git.Tag == "" {
+			git.Tag = env.Env["GITHUB_REF_NAME"]
+		}
+	case "branch":
+		if git.Branch == "" {
+			git.Branch = env.Env["GITHUB_REF_NAME"]
+		}
+	}
+	git.Commit = get("GIT_COMMIT", "CI_COMMIT_SHA", "GITHUB_SHA", "BUILDKITE_COMMIT", "CIRCLE_SHA1", "DRONE_COMMIT")
+
+	// Outside CI, ask the checkout itself
+	if git.Tag == "" && git.Branch == "" {
+		git.Tag = runGit(dir, "describe", "--tags", "--exact-match")
+		if branch := runGit(dir, "rev-parse", "--abbrev-ref", "HEAD"); branch != "HEAD" {
+			git.Branch = branch
+		}
+	}
+	if git.Commit == "" {
+		git.Commit = runGit(dir, "rev-parse", "HEAD")
+	}
+
+	git.Ref = get("CI_COMMIT_REF_NAME")
+	if git.Ref == "" {
+		git.Ref = git.Tag
+	}
+	if git.Ref == "" {
+		git.Ref = git.Branch
+	}
+	git.ShortCommit = git.Commit
+	if len(git.ShortCommit) > 7 {
+		git.ShortCommit = git.ShortCommit[:7]
+	}
+
+	return env
+}
+
+// runGit returns the trimmed output of a git command, or "" when it fails
+// (no git, not a checkout, no tag, ...)
+func runGit(dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValuesExpandTemplates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repogen.yaml")
+	os.WriteFile(path, []byte(`
+origin: Example
+codename: '{{ if .Git.Tag }}stable{{ else }}nightly{{ end }}'
+version: '{{ .Git.Tag | trimPrefix "v" }}'
+label: '{{ .Env.REPO_LABEL | default "Example Packages" }}'
+arch:
+  - amd64
+  - '{{ .Env.EXTRA_ARCH }}'
+incremental: true
+suite:
+`), 0644)
+
+	f, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	env := &Environment{
+		Env: map[string]string{"EXTRA_ARCH": "arm64"},
+		Git: Git{Tag: "v1.2.3"},
+	}
+	values, err := f.Values(env)
+	if err != nil {
+		t.Fatalf("Values failed: %v", err)
+	}
+
+	want := map[string]string{
+		"origin":      "Example",
+		"codename":    "stable",
+		"version":     "1.2.3",
+		"label":       "Example Packages",
+		"arch":        "amd64,arm64",
+		"incremental": "true",
+	}
+	if len(values) != len(want) {
+		t.Errorf("Got %d values, want %d: %v", len(values), len(want), values)
+	}
+	for name, v := range want {
+		if values[name] != v {
+			t.Errorf("%s = %q, want %q", name, values[name], v)
+		}
+	}
+
+	env.Git.Tag = ""
+	values, _ = f.Values(env)
+	if values["codename"] != "nightly" {
+		t.Errorf("codename without tag = %q, want nightly", values["codename"])
+	}
+}
+
+func TestValuesRejectInvalidTemplates(t *testing.T) {
+	for _, f := range []File{
+		{"origin": "{{ .Git.Tag"},
+		{"origin": "{{ .Nope }}"},
+		{"origin": map[string]interface{}{"a": "b"}},
+	} {
+		if _, err := f.Values(&Environment{}); err == nil {
+			t.Errorf("Values(%v) succeeded", f)
+		}
+	}
+}
+
+func TestRepositories(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repogen.yaml")
+	os.WriteFile(path, []byte(`
+origin: Example
+output-dir: 'public/{{ .Repository }}'
+repositories:
+  product-b:
+    input-dir: ./dist/b
+    arch: [amd64, arm64]
+  product-a:
+    origin: Product A
+  empty:
+`), 0644)
+
+	f, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	repos, err := f.Repositories()
+	if err != nil {
+		t.Fatalf("Repositories failed: %v", err)
+	}
+	var names []string
+	for _, repo := range repos {
+		names = append(names, repo.Name)
+	}
+	if strings.Join(names, ",") != "empty,product-a,product-b" {
+		t.Fatalf("Repositories = %v", names)
+	}
+
+	env := &Environment{Repository: "product-b"}
+	values, err := f.Values(env)
+	if err != nil {
+		t.Fatalf("Values failed: %v", err)
+	}
+	if _, ok := values[RepositoriesKey]; ok || values["output-dir"] != "public/product-b" {
+		t.Errorf("Top-level values = %v", values)
+	}
+	values, err = repos[2].Settings.Values(env)
+	if err != nil || values["arch"] != "amd64,arm64" || values["input-dir"] != "./dist/b" {
+		t.Errorf("product-b values = %v, %v", values, err)
+	}
+	if len(repos[0].Settings) != 0 {
+		t.Errorf("empty settings = %v", repos[0].Settings)
+	}
+
+	if repos, err := (File{"origin": "Example"}).Repositories(); err != nil || repos != nil {
+		t.Errorf("Repositories of a single-repository file = %v, %v", repos, err)
+	}
+	for _, f := range []File{
+		{RepositoriesKey: []interface{}{"a"}},
+		{RepositoriesKey: map[string]interface{}{"a": "b"}},
+		{RepositoriesKey: map[string]interface{}{"a": map[string]interface{}{RepositoriesKey: nil}}},
+	} {
+		if _, err := f.Repositories(); err == nil {
+			t.Errorf("Repositories(%v) succeeded", f)
+		}
+	}
+}
+
+func TestDetectEnvironmentFromCI(t *testing.T) {
+	for _, name := range []string{"GIT_TAG", "CI_COMMIT_TAG", "GIT_BRANCH", "CI_COMMIT_BRANCH", "GIT_COMMIT", "CI_COMMIT_SHA", "CI_COMMIT_REF_NAME"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("GITHUB_REF_TYPE", "tag")
+	t.Setenv("GITHUB_REF_NAME", "v2.0.0")
+	t.Setenv("GITHUB_SHA", "0123456789abcdef0123456789abcdef01234567")
+
+	git := DetectEnvironment(t.TempDir()).Git
+	if git.Tag != "v2.0.0" || git.Ref != "v2.0.0" {
+		t.Errorf("Tag/Ref = %q/%q, want v2.0.0", git.Tag, git.Ref)
+	}
+	if git.ShortCommit != "0123456" {
+		t.Errorf("ShortCommit = %q, want 0123456", git.ShortCommit)
+	}
+}
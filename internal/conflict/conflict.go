@@ -0,0 +1,93 @@
+// Package conflict resolves packages with the same identity, published
+// twice in a run or already published, by the policy the user chose
+package conflict
+
+import (
+	"fmt"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+// Policies, what to do with a package whose identity is already taken
+const (
+	PolicyFail     = "fail"      // Publish nothing
+	PolicySkip     = "skip"      // Keep the package published or scanned first
+	PolicyReplace  = "replace"   // Publish the package scanned last in its place
+	PolicyKeepBoth = "keep-both" // Publish both
+)
+
+// ValidatePolicy checks that policy is a known policy
+func ValidatePolicy(policy string) error {
+	switch policy {
+	case "", PolicyFail, PolicySkip, PolicyReplace, PolicyKeepBoth:
+		return nil
+	}
+	return fmt.Errorf("unknown conflict policy %q (expected %s, %s, %s or %s)", policy, PolicyFail, PolicySkip, PolicyReplace, PolicyKeepBoth)
+}
+
+// Collision is an incoming package whose identity another package has
+type Collision struct {
+	Identity string
+	Kept     models.Package // Published, the incoming package unless skipped
+	Dropped  models.Package // Left out, but published too with keep-both
+}
+
+// Result is the outcome of merging incoming packages into existing ones
+type Result struct {
+	Packages   []models.Package // Existing and incoming packages to publish
+	Incoming   []models.Package // Incoming packages among Packages
+	Collisions []Collision
+	Duplicates int // Incoming packages left out as the same file is already there
+}
+
+// Resolve merges incoming packages into existing ones, which are already
+// published, in order. Packages with the same key collide: with skip and
+// fail the first one is kept, with replace the last one, with keep-both
+// all of them. Packages with the same checksum are the same file, not a
+// collision, and only the first one is kept whatever the policy. Existing
+// packages colliding with each other are left as they were published
+func Resolve(existing, incoming []models.Package, key func(models.Package) string, policy string) *Result {
+	result := &Result{Packages: append([]models.Package(nil), existing...)}
+	taken := make(map[string]int, len(existing)+len(incoming)) // Index in result.Packages
+	fromIncoming := make(map[int]bool)
+	for i, pkg := range existing {
+		taken[key(pkg)] = i
+	}
+
+	for _, pkg := range incoming {
+		k := key(pkg)
+		i, ok := taken[k]
+		if !ok {
+			taken[k] = len(result.Packages)
+			fromIncoming[len(result.Packages)] = true
+			result.Packages = append(result.Packages, pkg)
+			continue
+		}
+
+		previous := result.Packages[i]
+		if pkg.SHA256Sum != "" && pkg.SHA256Sum == previous.SHA256Sum {
+			result.Duplicates++
+			continue
+		}
+		switch policy {
+		case PolicyReplace:
+			result.Packages[i] = pkg
+			fromIncoming[i] = true
+			result.Collisions = append(result.Collisions, Collision{Identity: k, Kept: pkg, Dropped: previous})
+		case PolicyKeepBoth:
+			taken[k] = len(result.Packages)
+			fromIncoming[len(result.Packages)] = true
+			result.Packages = append(result.Packages, pkg)
+			result.Collisions = append(result.Collisions, Collision{Identity: k, Kept: pkg, Dropped: previous})
+		default:
+			result.Collisions = append(result.Collisions, Collision{Identity: k, Kept: previous, Dropped: pkg})
+		}
+	}
+
+	for i, pkg := range result.Packages {
+		if fromIncoming[i] {
+			result.Incoming = append(result.Incoming, pkg)
+		}
+	}
+	return result
+}
@@ -0,0 +1,107 @@
+package conflict
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+func key(pkg models.Package) string {
+	return pkg.Name + ":" + pkg.Version
+}
+
+func files(packages []models.Package) string {
+	var names []string
+	for _, pkg := range packages {
+		names = append(names, pkg.Filename)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestResolve(t *testing.T) {
+	existing := []models.Package{
+		{Name: "hello", Version: "1.0", Filename: "pool/hello-1.0"},
+		{Name: "tool", Version: "2.0", Filename: "pool/tool-2.0"},
+	}
+	incoming := []models.Package{
+		{Name: "hello", Version: "1.0", Filename: "a/hello-1.0"},
+		{Name: "hello", Version: "1.1", Filename: "a/hello-1.1"},
+		{Name: "hello", Version: "1.1", Filename: "b/hello-1.1"},
+	}
+
+	for _, tc := range []struct {
+		policy     string
+		packages   string
+		incoming   string
+		collisions int
+	}{
+		{PolicyFail, "pool/hello-1.0 pool/tool-2.0 a/hello-1.1", "a/hello-1.1", 2},
+		{PolicySkip, "pool/hello-1.0 pool/tool-2.0 a/hello-1.1", "a/hello-1.1", 2},
+		{PolicyReplace, "a/hello-1.0 pool/tool-2.0 b/hello-1.1", "a/hello-1.0 b/hello-1.1", 2},
+		{PolicyKeepBoth, "pool/hello-1.0 pool/tool-2.0 a/hello-1.0 a/hello-1.1 b/hello-1.1", "a/hello-1.0 a/hello-1.1 b/hello-1.1", 2},
+	} {
+		result := Resolve(existing, incoming, key, tc.policy)
+		if got := files(result.Packages); got != tc.packages {
+			t.Errorf("%s: Packages = %s, want %s", tc.policy, got, tc.packages)
+		}
+		if got := files(result.Incoming); got != tc.incoming {
+			t.Errorf("%s: Incoming = %s, want %s", tc.policy, got, tc.incoming)
+		}
+		if len(result.Collisions) != tc.collisions {
+			t.Errorf("%s: Collisions = %+v", tc.policy, result.Collisions)
+		}
+	}
+
+	result := Resolve(existing, incoming, key, PolicyReplace)
+	if c := result.Collisions[0]; c.Identity != "hello:1.0" || c.Kept.Filename != "a/hello-1.0" || c.Dropped.Filename != "pool/hello-1.0" {
+		t.Errorf("First collision = %+v", c)
+	}
+	if existing[0].Filename != "pool/hello-1.0" {
+		t.Error("Resolve modified the existing packages")
+	}
+}
+
+func TestResolveIdenticalFiles(t *testing.T) {
+	// An incremental run over the packages it already published
+	existing := []models.Package{
+		{Name: "hello", Version: "1.0", Filename: "pool/hello-1.0", SHA256Sum: "aaa"},
+		{Name: "tool", Version: "2.0", Filename: "pool/tool-2.0", SHA256Sum: "bbb"},
+	}
+	incoming := []models.Package{
+		{Name: "hello", Version: "1.0", Filename: "in/hello-1.0", SHA256Sum: "aaa"},
+		{Name: "tool", Version: "2.0", Filename: "in/tool-2.0", SHA256Sum: "ccc"},
+		{Name: "new", Version: "1.0", Filename: "in/new-1.0", SHA256Sum: "ddd"},
+		{Name: "new", Version: "1.0", Filename: "other/new-1.0", SHA256Sum: "ddd"},
+	}
+
+	for _, tc := range []struct {
+		policy   string
+		packages string
+	}{
+		{PolicyFail, "pool/hello-1.0 pool/tool-2.0 in/new-1.0"},
+		{PolicySkip, "pool/hello-1.0 pool/tool-2.0 in/new-1.0"},
+		{PolicyReplace, "pool/hello-1.0 in/tool-2.0 in/new-1.0"},
+		{PolicyKeepBoth, "pool/hello-1.0 pool/tool-2.0 in/tool-2.0 in/new-1.0"},
+	} {
+		result := Resolve(existing, incoming, key, tc.policy)
+		if got := files(result.Packages); got != tc.packages {
+			t.Errorf("%s: Packages = %s, want %s", tc.policy, got, tc.packages)
+		}
+		// Only tool, whose content changed, collides
+		if len(result.Collisions) != 1 || result.Collisions[0].Identity != "tool:2.0" || result.Duplicates != 2 {
+			t.Errorf("%s: Collisions = %+v, %d duplicates", tc.policy, result.Collisions, result.Duplicates)
+		}
+	}
+}
+
+func TestValidatePolicy(t *testing.T) {
+	for _, policy := range []string{"", PolicyFail, PolicySkip, PolicyReplace, PolicyKeepBoth} {
+		if err := ValidatePolicy(policy); err != nil {
+			t.Errorf("ValidatePolicy(%q) = %v", policy, err)
+		}
+	}
+	if err := ValidatePolicy("newest"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,95 @@
+// Package depcheck finds the dependencies of a repository's packages that
+// no package of the repository satisfies, which users couldn't install
+// without another repository providing them
+package depcheck
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Policies, what to do about unsatisfied dependencies
+const (
+	PolicyFail   = "fail"   // Publish nothing
+	PolicyWarn   = "warn"   // Publish, logging the unsatisfied dependencies
+	PolicyIgnore = "ignore" // Don't check dependencies
+)
+
+// ValidatePolicy checks that policy is a known policy
+func ValidatePolicy(policy string) error {
+	switch policy {
+	case "", PolicyFail, PolicyWarn, PolicyIgnore:
+		return nil
+	}
+	return fmt.Errorf("unknown dependency policy %q (expected %s, %s or %s)", policy, PolicyFail, PolicyWarn, PolicyIgnore)
+}
+
+// Package is a package whose dependencies are checked
+type Package struct {
+	ID       string     // Shown in findings, e.g. "hello 1.0 (amd64)"
+	Requires [][]string // Each dependency lists the names satisfying it
+	Provides []string   // Names the package satisfies dependencies on, its own included
+}
+
+// Broken is a dependency no package satisfies
+type Broken struct {
+	Package    string
+	Dependency string // Alternatives joined with " | "
+}
+
+func (b Broken) String() string {
+	return fmt.Sprintf("%s depends on %s", b.Package, b.Dependency)
+}
+
+// Check returns the dependencies of packages none of packages provides.
+// Dependencies on a name matching one of the allowed globs, such as the
+// packages of the distribution the repository extends, are satisfied
+func Check(packages []Package, allowed []string) []Broken {
+	provided := make(map[string]bool)
+	for _, pkg := range packages {
+		for _, name := range pkg.Provides {
+			provided[name] = true
+		}
+	}
+
+	var broken []Broken
+	for _, pkg := range packages {
+		for _, alternatives := range pkg.Requires {
+			if len(alternatives) == 0 || satisfied(alternatives, provided, allowed) {
+				continue
+			}
+			broken = append(broken, Broken{Package: pkg.ID, Dependency: strings.Join(alternatives, " | ")})
+		}
+	}
+	sort.SliceStable(broken, func(i, j int) bool {
+		return broken[i].Package < broken[j].Package
+	})
+	return broken
+}
+
+// satisfied reports whether one of alternatives is provided or allowed
+func satisfied(alternatives []string, provided map[string]bool, allowed []string) bool {
+	for _, name := range alternatives {
+		if provided[name] {
+			return true
+		}
+		for _, glob := range allowed {
+			if ok, _ := path.Match(glob, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TrimConstraint returns the name a dependency such as "libc6 (>= 2.34)",
+// "glibc>=2.38" or "foo=1.0" is on
+func TrimConstraint(dep string) string {
+	dep = strings.TrimSpace(dep)
+	if i := strings.IndexAny(dep, " (<>=~!"); i >= 0 {
+		dep = dep[:i]
+	}
+	return strings.TrimSpace(dep)
+}
@@ -0,0 +1,51 @@
+package depcheck
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	packages := []Package{
+		{ID: "app 1.0", Requires: [][]string{{"libfoo"}, {"mta", "postfix"}, {"libc6"}, {"missing"}}, Provides: []string{"app"}},
+		{ID: "libfoo 2.0", Requires: [][]string{{"libc6"}}, Provides: []string{"libfoo", "libfoo-abi-2"}},
+		{ID: "exim 4.0", Provides: []string{"exim", "mta"}},
+	}
+
+	var got []string
+	for _, b := range Check(packages, []string{"libc*"}) {
+		got = append(got, b.String())
+	}
+	if strings.Join(got, "; ") != "app 1.0 depends on missing" {
+		t.Errorf("Check() = %v", got)
+	}
+
+	if broken := Check(packages, nil); len(broken) != 3 {
+		t.Errorf("Check() without allowed names = %v", broken)
+	}
+}
+
+func TestTrimConstraint(t *testing.T) {
+	for dep, want := range map[string]string{
+		"libc6 (>= 2.34)": "libc6",
+		"glibc>=2.38":     "glibc",
+		"sh":              "sh",
+		"foo=1.0-1":       "foo",
+		" bar ":           "bar",
+	} {
+		if got := TrimConstraint(dep); got != want {
+			t.Errorf("TrimConstraint(%q) = %q, want %q", dep, got, want)
+		}
+	}
+}
+
+func TestValidatePolicy(t *testing.T) {
+	for _, policy := range []string{"", PolicyFail, PolicyWarn, PolicyIgnore} {
+		if err := ValidatePolicy(policy); err != nil {
+			t.Errorf("ValidatePolicy(%q) = %v", policy, err)
+		}
+	}
+	if ValidatePolicy("strict") == nil {
+		t.Error("ValidatePolicy accepted an unknown policy")
+	}
+}
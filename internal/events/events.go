@@ -0,0 +1,122 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Type identifies the kind of an event
+type Type string
+
+const (
+	PackageParsed   Type = "package_parsed"   // A package file was read
+	FileCopied      Type = "file_copied"      // A package file was copied into the repository
+	MetadataWritten Type = "metadata_written" // A metadata file was written
+	Signed          Type = "signed"           // A signature was created
+	Published       Type = "published"        // A repository was fully regenerated
+)
+
+// Fields holds the payload of an event
+type Fields map[string]interface{}
+
+var (
+	mu        sync.Mutex
+	out       io.Writer
+	observers = make(map[int]func(Type, Fields))
+	nextID    int
+
+	// now is the clock events are timestamped with
+	now = time.Now
+)
+
+// Open directs events to a file descriptor inherited from the parent process,
+// or to a file. Without either, events are discarded
+func Open(fd int, path string) (io.Closer, error) {
+	var f *os.File
+	switch {
+	case fd > 0 && path != "":
+		return nil, fmt.Errorf("--events-fd and --events-file are mutually exclusive")
+	case fd < 0:
+		return nil, fmt.Errorf("invalid events file descriptor %d", fd)
+	case fd > 0:
+		f = os.NewFile(uintptr(fd), fmt.Sprintf("events-fd-%d", fd))
+		// Fail now rather than on the first event when nothing was passed on fd
+		if _, err := f.Stat(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("invalid events file descriptor %d: %w", fd, err)
+		}
+	case path != "":
+		var err error
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open events file: %w", err)
+		}
+	default:
+		return nil, nil
+	}
+
+	SetOutput(f)
+	return f, nil
+}
+
+// SetOutput directs events to w; nil disables them
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+}
+
+// Observe has fn called with every event emitted, whether or not the events
+// stream is enabled, until the returned function is called
+func Observe(fn func(Type, Fields)) (remove func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	id := nextID
+	nextID++
+	observers[id] = fn
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(observers, id)
+	}
+}
+
+// Emit writes one event as a line of JSON. The "event" and "time" keys are
+// always set and take precedence over fields
+func Emit(t Type, fields Fields) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, fn := range observers {
+		fn(t, fields)
+	}
+
+	if out == nil {
+		return
+	}
+
+	record := make(map[string]interface{}, len(fields)+2)
+	for k, v := range fields {
+		record[k] = v
+	}
+	record["event"] = t
+	record["time"] = now().UTC().Format(time.RFC3339Nano)
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		logrus.Debugf("Failed to encode %s event: %v", t, err)
+		return
+	}
+
+	// One write per event so readers never see partial lines
+	if _, err := out.Write(append(line, '\n')); err != nil {
+		logrus.Warnf("Failed to write event, disabling the events stream: %v", err)
+		out = nil
+	}
+}
@@ -0,0 +1,165 @@
+package events
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// capture directs events to a buffer with a fixed clock until the test ends
+func capture(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	now = func() time.Time { return time.Date(2025, 1, 2, 3, 4, 5, 600000000, time.FixedZone("CET", 3600)) }
+	t.Cleanup(func() {
+		SetOutput(nil)
+		now = time.Now
+	})
+	return &buf
+}
+
+// TestEmitGolden pins the events read by wrapping tools, one of each type
+// with the fields their emitters set
+func TestEmitGolden(t *testing.T) {
+	buf := capture(t)
+
+	Emit(PackageParsed, Fields{"path": "packages/myapp_1.2.3_amd64.deb", "type": "deb", "name": "myapp", "version": "1.2.3", "architecture": "amd64"})
+	Emit(FileCopied, Fields{"src": "packages/myapp_1.2.3_amd64.deb", "dst": "repo/pool/main/m/myapp/myapp_1.2.3_amd64.deb", "size": int64(1024), "sha256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"})
+	Emit(MetadataWritten, Fields{"path": "repo/dists/stable/main/binary-amd64/Packages", "size": 512})
+	Emit(Signed, Fields{"path": "repo/dists/stable/InRelease", "kind": "cleartext"})
+	Emit(Published, Fields{"type": "deb", "packages": 3, "output_dir": "repo"})
+	// The event and time keys win over fields
+	Emit(Signed, Fields{"event": "spoofed", "time": "yesterday", "path": "repo/SHA256SUMS.asc", "kind": "detached"})
+	Emit(Published, nil)
+
+	golden := filepath.Join("testdata", "events.golden")
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != string(want) {
+		t.Errorf("Events differ from %s (rerun with -update if intended):\ngot:\n%s\nwant:\n%s", golden, got, want)
+	}
+}
+
+type failingWriter struct{ writes int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errors.New("broken pipe")
+}
+
+func TestEmitDisablesFailingOutput(t *testing.T) {
+	capture(t)
+	w := &failingWriter{}
+	SetOutput(w)
+
+	var observed []Type
+	defer Observe(func(t Type, _ Fields) { observed = append(observed, t) })()
+
+	Emit(PackageParsed, Fields{"name": "myapp"})
+	Emit(Published, Fields{"packages": 1})
+	if w.writes != 1 {
+		t.Errorf("Wrote %d times to a failing output, want 1", w.writes)
+	}
+	// Observers still see every event
+	if len(observed) != 2 {
+		t.Errorf("Observed %v", observed)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	capture(t)
+
+	// Neither: events are discarded
+	closer, err := Open(0, "")
+	if closer != nil || err != nil {
+		t.Errorf("Open(0, \"\") = %v, %v", closer, err)
+	}
+
+	for _, tt := range []struct {
+		fd      int
+		path    string
+		wantErr string
+	}{
+		{3, "events.ndjson", "--events-fd and --events-file are mutually exclusive"},
+		{-1, "", "invalid events file descriptor -1"},
+		{1 << 20, "", "invalid events file descriptor 1048576"},
+		{0, filepath.Join(t.TempDir(), "missing", "events.ndjson"), "failed to open events file"},
+	} {
+		if _, err := Open(tt.fd, tt.path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Open(%d, %q) = %v, want %q", tt.fd, tt.path, err, tt.wantErr)
+		}
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	capture(t)
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	if err := os.WriteFile(path, []byte("{\"event\":\"earlier\"}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Appended to, so several runs can share a file
+	closer, err := Open(0, path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	Emit(Published, Fields{"packages": 1})
+	SetOutput(nil)
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\"event\":\"earlier\"}\n{\"event\":\"published\",\"packages\":1,\"time\":\"2025-01-02T02:04:05.6Z\"}\n"
+	if string(data) != want {
+		t.Errorf("Events file = %q, want %q", data, want)
+	}
+}
+
+func TestOpenFd(t *testing.T) {
+	capture(t)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	closer, err := Open(int(w.Fd()), "")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	Emit(Signed, Fields{"path": "repo/Release.gpg", "kind": "detached"})
+	SetOutput(nil)
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The descriptor is closed already
+	w.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	want := "{\"event\":\"signed\",\"kind\":\"detached\",\"path\":\"repo/Release.gpg\",\"time\":\"2025-01-02T02:04:05.6Z\"}\n"
+	if buf.String() != want {
+		t.Errorf("Events on the descriptor = %q, want %q", buf.String(), want)
+	}
+}
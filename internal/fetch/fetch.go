@@ -0,0 +1,303 @@
+package fetch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConcurrency is the number of downloads run at once by default
+const DefaultConcurrency = 4
+
+// Entry is a package to download
+type Entry struct {
+	URL    string `yaml:"url"`
+	SHA256 string `yaml:"sha256"` // Verified when given
+	Name   string `yaml:"name"`   // File name, the last element of the URL path by default
+}
+
+// FileName returns the name the package is saved as
+func (e Entry) FileName() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return ""
+	}
+	return path.Base(u.Path)
+}
+
+// Load reads the packages to download from a YAML or JSON list of entries
+// (optionally under a packages key), or from text with one URL per line,
+// optionally followed by its SHA-256. Blank lines and # comments are skipped
+func Load(file string) ([]Entry, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input manifest: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml", ".json":
+		var entries []Entry
+		if err := yaml.Unmarshal(data, &entries); err == nil {
+			return entries, nil
+		}
+		var doc struct {
+			Packages []Entry `yaml:"packages"`
+		}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse input manifest %s: %w", file, err)
+		}
+		return doc.Packages, nil
+	}
+
+	var entries []Entry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: expected a URL and an optional SHA-256", file, line)
+		}
+		entry := Entry{URL: fields[0]}
+		if len(fields) == 2 {
+			entry.SHA256 = fields[1]
+		}
+		entries = append(entries, entry)
+	}
+	return entries, sc.Err()
+}
+
+// Validate checks that entries are HTTP(S) URLs with well-formed checksums,
+// saved under distinct file names
+func Validate(entries []Entry) error {
+	if len(entries) == 0 {
+		return fmt.Errorf("no packages listed")
+	}
+
+	names := make(map[string]string)
+	for _, entry := range entries {
+		u, err := url.Parse(entry.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid URL %q: expected http:// or https://", entry.URL)
+		}
+		if entry.SHA256 != "" {
+			if sum, err := hex.DecodeString(entry.SHA256); err != nil || len(sum) != sha256.Size {
+				return fmt.Errorf("invalid sha256 %q of %s", entry.SHA256, entry.URL)
+			}
+		}
+
+		name := entry.FileName()
+		if name == "" || name == "." || name == "/" || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("no file name in %s: give one with name", entry.URL)
+		}
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("%s and %s are both saved as %s", other, entry.URL, name)
+		}
+		names[name] = entry.URL
+	}
+	return nil
+}
+
+// Fetcher downloads packages
+type Fetcher struct {
+	Client      *http.Client // nil for http.DefaultClient
+	Concurrency int          // Downloads at once, DefaultConcurrency when not positive
+	CacheDir    string       // Keeps downloads across runs, none when empty
+}
+
+// Fetch downloads entries into dir, verifying their checksums, and returns
+// the paths of the files in the order of entries. The first failure stops
+// the other downloads
+func (f *Fetcher) Fetch(ctx context.Context, entries []Entry, dir string) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := f.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	paths := make([]string, len(entries))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				dst := filepath.Join(dir, entries[i].FileName())
+				if err := f.fetch(ctx, entries[i], dst); err != nil {
+					once.Do(func() {
+						firstErr = fmt.Errorf("failed to fetch %s: %w", entries[i].URL, err)
+						cancel()
+					})
+					continue
+				}
+				paths[i] = dst
+			}
+		}()
+	}
+
+	for i := range entries {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// fetch saves entry as dst, from the cache when it holds a copy that is
+// still valid
+func (f *Fetcher) fetch(ctx context.Context, entry Entry, dst string) error {
+	cached := f.cachePath(entry)
+
+	// A copy with the expected checksum needs no request
+	if cached != "" && entry.SHA256 != "" {
+		if sum, err := fileSHA256(cached); err == nil && strings.EqualFold(sum, entry.SHA256) {
+			logrus.Debugf("Using cached %s", entry.URL)
+			return utils.CopyFile(ctx, cached, dst)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, entry.URL, nil)
+	if err != nil {
+		return err
+	}
+	if cached != "" && entry.SHA256 == "" {
+		if info, err := os.Stat(cached); err == nil {
+			req.Header.Set("If-Modified-Since", info.ModTime().UTC().Format(http.TimeFormat))
+		}
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		logrus.Debugf("Using cached %s (not modified)", entry.URL)
+		return utils.CopyFile(ctx, cached, dst)
+	default:
+		return fmt.Errorf("GET %s: %s", entry.URL, resp.Status)
+	}
+
+	if err := download(resp.Body, dst, entry.SHA256); err != nil {
+		return err
+	}
+	logrus.Infof("Downloaded %s", entry.URL)
+
+	// Failing to cache only costs a download next time
+	if cached != "" {
+		modTime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+		if err != nil {
+			modTime = time.Now()
+		}
+		if err := saveCached(ctx, dst, cached, modTime); err != nil {
+			logrus.Warnf("Failed to cache %s: %v", entry.URL, err)
+		}
+	}
+	return nil
+}
+
+// cachePath returns the path of the cached copy of entry, empty without a
+// cache
+func (f *Fetcher) cachePath(entry Entry) string {
+	if f.CacheDir == "" {
+		return ""
+	}
+	key := sha256.Sum256([]byte(entry.URL))
+	return filepath.Join(f.CacheDir, hex.EncodeToString(key[:]))
+}
+
+// download writes body to dst, checking it has the expected SHA-256 when
+// given. dst is removed on failure
+func download(body io.Reader, dst, expected string) error {
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hasher), body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && expected != "" {
+		if got := hex.EncodeToString(hasher.Sum(nil)); !strings.EqualFold(got, expected) {
+			err = fmt.Errorf("checksum mismatch: expected sha256 %s, got %s", expected, got)
+		}
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// saveCached copies a download into the cache, dated like the server's copy
+// so it can be revalidated
+func saveCached(ctx context.Context, src, cached string, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(cached), 0755); err != nil {
+		return err
+	}
+	if err := utils.CopyFile(ctx, src, cached); err != nil {
+		return err
+	}
+	return os.Chtimes(cached, modTime, modTime)
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
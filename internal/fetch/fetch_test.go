@@ -0,0 +1,134 @@
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	sum := strings.Repeat("ab", 32)
+
+	for name, content := range map[string]string{
+		"urls.txt":     "# artifacts\nhttps://example.com/a/hello_1.0_amd64.deb " + sum + "\n\nhttps://example.com/b/world.rpm\n",
+		"list.yaml":    "- url: https://example.com/a/hello_1.0_amd64.deb\n  sha256: " + sum + "\n- url: https://example.com/b/world.rpm\n",
+		"doc.yml":      "packages:\n  - url: https://example.com/a/hello_1.0_amd64.deb\n    sha256: " + sum + "\n  - url: https://example.com/b/world.rpm\n",
+		"entries.json": `[{"url": "https://example.com/a/hello_1.0_amd64.deb", "sha256": "` + sum + `"}, {"url": "https://example.com/b/world.rpm"}]`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := Load(path)
+		if err != nil {
+			t.Fatalf("%s: Load failed: %v", name, err)
+		}
+		if len(entries) != 2 || entries[0].SHA256 != sum || entries[1].SHA256 != "" {
+			t.Fatalf("%s: got entries %+v", name, entries)
+		}
+		if got := entries[0].FileName(); got != "hello_1.0_amd64.deb" {
+			t.Errorf("%s: file name is %q", name, got)
+		}
+		if err := Validate(entries); err != nil {
+			t.Errorf("%s: Validate failed: %v", name, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		entries []Entry
+	}{
+		{"empty", nil},
+		{"scheme", []Entry{{URL: "ftp://example.com/a.deb"}}},
+		{"checksum", []Entry{{URL: "https://example.com/a.deb", SHA256: "abc"}}},
+		{"no file name", []Entry{{URL: "https://example.com/"}}},
+		{"duplicate", []Entry{{URL: "https://example.com/1/a.deb"}, {URL: "https://example.com/2/a.deb"}}},
+	} {
+		if err := Validate(tc.entries); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+
+	renamed := []Entry{{URL: "https://example.com/1/a.deb"}, {URL: "https://example.com/2/a.deb", Name: "b.deb"}}
+	if err := Validate(renamed); err != nil {
+		t.Errorf("Renamed entries rejected: %v", err)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	content := []byte("package contents")
+	digest := sha256.Sum256(content)
+	sum := hex.EncodeToString(digest[:])
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/missing.deb" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, modified, strings.NewReader(string(content)))
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	fetcher := &Fetcher{Concurrency: 2, CacheDir: cacheDir}
+	entries := []Entry{
+		{URL: server.URL + "/a.deb", SHA256: sum},
+		{URL: server.URL + "/b.rpm"},
+		{URL: server.URL + "/c.apk", SHA256: strings.ToUpper(sum)},
+	}
+
+	// Downloads are verified and saved in the order of the entries
+	dir := t.TempDir()
+	paths, err := fetcher.Fetch(context.Background(), entries, dir)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	for i, name := range []string{"a.deb", "b.rpm", "c.apk"} {
+		if paths[i] != filepath.Join(dir, name) {
+			t.Errorf("Path %d is %s", i, paths[i])
+		}
+		if data, err := os.ReadFile(paths[i]); err != nil || string(data) != string(content) {
+			t.Errorf("%s holds %q (%v)", name, data, err)
+		}
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("First fetch made %d requests", got)
+	}
+
+	// Checksummed copies come from the cache, the others are revalidated
+	requests.Store(0)
+	if _, err := fetcher.Fetch(context.Background(), entries, t.TempDir()); err != nil {
+		t.Fatalf("Cached fetch failed: %v", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Cached fetch made %d requests", got)
+	}
+
+	// Mismatched checksums and failed requests fail the fetch
+	for _, entry := range []Entry{
+		{URL: server.URL + "/d.deb", SHA256: strings.Repeat("0", 64)},
+		{URL: server.URL + "/missing.deb"},
+	} {
+		dir := t.TempDir()
+		if _, err := (&Fetcher{}).Fetch(context.Background(), []Entry{entry}, dir); err == nil {
+			t.Errorf("Fetching %s succeeded", entry.URL)
+		}
+		if files, _ := os.ReadDir(dir); len(files) != 0 {
+			t.Errorf("Failed fetch of %s left %d files", entry.URL, len(files))
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package filter
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// archIndependent lists the architecture names each format uses for
+// packages that install everywhere. They are never filtered out by arch
+var archIndependent = map[string]bool{
+	"all":    true, // Debian
+	"noarch": true, // RPM, Alpine, conda, F-Droid, xbps
+	"any":    true, // Pacman
+}
+
+// Validate checks the filter settings of config
+func Validate(config *models.RepositoryConfig) error {
+	for _, patterns := range []struct {
+		flag string
+		list []string
+	}{
+		{"--include", config.Include},
+		{"--exclude", config.Exclude},
+		{"--only-package", config.OnlyPackages},
+	} {
+		for _, pattern := range patterns.list {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid %s pattern %q: %w", patterns.flag, pattern, err)
+			}
+		}
+	}
+	if config.MinVersion != "" && config.MaxVersion != "" && reversed(config.MinVersion, config.MaxVersion) {
+		return fmt.Errorf("--min-version %s is above --max-version %s", config.MinVersion, config.MaxVersion)
+	}
+	return nil
+}
+
+// reversed reports whether minVersion is above maxVersion for every package
+// type, the bounds applying to all of them
+func reversed(minVersion, maxVersion string) bool {
+	for _, compare := range []func(a, b string) int{utils.CompareVersions, utils.CompareDebianVersions, utils.CompareSemanticVersions} {
+		if compare(minVersion, maxVersion) <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Enabled reports whether config restricts the packages to publish
+func Enabled(config *models.RepositoryConfig) bool {
+	return len(config.OnlyArches) > 0 || len(config.OnlyPackages) > 0 || config.MinVersion != "" || config.MaxVersion != ""
+}
+
+// Packages returns the packages of pkgType matching the filters of config,
+// versions being compared by the rules of pkgType
+func Packages(config *models.RepositoryConfig, pkgType scanner.PackageType, packages []models.Package) []models.Package {
+	if !Enabled(config) {
+		return packages
+	}
+
+	compare := utils.VersionComparator(pkgType.String())
+	var matched []models.Package
+	for _, pkg := range packages {
+		if matchArch(config.OnlyArches, pkg) && matchName(config.OnlyPackages, pkg) && matchVersion(config.MinVersion, config.MaxVersion, pkg, compare) {
+			matched = append(matched, pkg)
+		}
+	}
+	return matched
+}
+
+func matchArch(arches []string, pkg models.Package) bool {
+	if len(arches) == 0 || pkg.Architecture == "" || archIndependent[pkg.Architecture] {
+		return true
+	}
+	for _, arch := range arches {
+		if arch == pkg.Architecture {
+			return true
+		}
+	}
+	return false
+}
+
+func matchName(patterns []string, pkg models.Package) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	// Homebrew bottles are only known by their file name at this point
+	name := pkg.Name
+	if name == "" {
+		name = filepath.Base(pkg.Filename)
+	}
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchVersion(minVersion, maxVersion string, pkg models.Package, compare func(a, b string) int) bool {
+	if pkg.Version == "" {
+		return true
+	}
+	if minVersion != "" && compare(pkg.Version, minVersion) < 0 {
+		return false
+	}
+	return maxVersion == "" || compare(pkg.Version, maxVersion) <= 0
+}
@@ -0,0 +1,173 @@
+package filter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  models.RepositoryConfig
+		wantErr string
+	}{
+		{name: "empty"},
+		{name: "globs", config: models.RepositoryConfig{Include: []string{"*.deb", "nightly/*"}, Exclude: []string{"*-dbgsym_*"}, OnlyPackages: []string{"myapp*"}}},
+		{name: "invalid include", config: models.RepositoryConfig{Include: []string{"[a-"}}, wantErr: `invalid --include pattern "[a-"`},
+		{name: "invalid exclude", config: models.RepositoryConfig{Exclude: []string{"*.deb", "\\"}}, wantErr: `invalid --exclude pattern "\\"`},
+		{name: "invalid only-package", config: models.RepositoryConfig{OnlyPackages: []string{"[]"}}, wantErr: `invalid --only-package pattern "[]"`},
+		{name: "bounds", config: models.RepositoryConfig{MinVersion: "1.0", MaxVersion: "1.999"}},
+		{name: "equal bounds", config: models.RepositoryConfig{MinVersion: "1.0", MaxVersion: "1.0"}},
+		{name: "min only", config: models.RepositoryConfig{MinVersion: "2.0"}},
+		{name: "min above max", config: models.RepositoryConfig{MinVersion: "2.0", MaxVersion: "1.10"}, wantErr: "--min-version 2.0 is above --max-version 1.10"},
+		{name: "numeric, not lexical", config: models.RepositoryConfig{MinVersion: "1.9", MaxVersion: "1.10"}},
+		// Above for RPM, but not for semver
+		{name: "pre-release max", config: models.RepositoryConfig{MinVersion: "1.0.0-rc1", MaxVersion: "1.0.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(&tt.config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIncludeExclude(t *testing.T) {
+	tests := []struct {
+		include, exclude []string
+		rel              string
+		want             bool
+	}{
+		{nil, nil, "hello_1.0_amd64.deb", true},
+		{[]string{"*_amd64.deb"}, nil, "hello_1.0_amd64.deb", true},
+		{[]string{"*_amd64.deb"}, nil, "hello_1.0_arm64.deb", false},
+		{[]string{"*_amd64.deb"}, nil, "sub/dir/hello_1.0_amd64.deb", true},
+		{nil, []string{"*-dbgsym_*"}, "hello-dbgsym_1.0_amd64.deb", false},
+		{[]string{"*.deb"}, []string{"*-dbgsym_*"}, "hello-dbgsym_1.0_amd64.deb", false},
+		{[]string{"*.deb", "*.rpm"}, nil, "hello-1.0-1.x86_64.rpm", true},
+		{nil, []string{"nightly/*"}, "nightly/hello_1.0_amd64.deb", false},
+		{nil, []string{"nightly/*"}, "stable/nightly/hello_1.0_amd64.deb", true},
+		{nil, []string{"nightly/*"}, "nightly", true},
+		{[]string{"stable/*"}, nil, "hello_1.0_amd64.deb", false},
+	}
+	for _, tt := range tests {
+		if got := scanner.Matches(tt.include, tt.exclude, tt.rel); got != tt.want {
+			t.Errorf("Matches(%q, %q, %q) = %v, want %v", tt.include, tt.exclude, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestPackages(t *testing.T) {
+	packages := []models.Package{
+		{Name: "myapp", Version: "1.0", Architecture: "amd64"},
+		{Name: "myapp", Version: "1.9", Architecture: "arm64"},
+		{Name: "myapp", Version: "1.10", Architecture: "amd64"},
+		{Name: "myapp", Version: "2.0~rc1", Architecture: "amd64"},
+		{Name: "myapp", Version: "2.0", Architecture: "amd64"},
+		{Name: "myapp-data", Version: "2.0", Architecture: "all"},
+		{Name: "other", Version: "1.0", Architecture: "arm64"},
+		{Filename: "/in/myapp--1.0.arm64_sonoma.bottle.tar.gz"},
+	}
+
+	tests := []struct {
+		name    string
+		config  models.RepositoryConfig
+		pkgType scanner.PackageType
+		want    []string
+	}{
+		{
+			name: "disabled",
+			want: []string{"myapp 1.0", "myapp 1.9", "myapp 1.10", "myapp 2.0~rc1", "myapp 2.0", "myapp-data 2.0", "other 1.0", " "},
+		},
+		{
+			name:   "arch",
+			config: models.RepositoryConfig{OnlyArches: []string{"arm64"}},
+			want:   []string{"myapp 1.9", "myapp-data 2.0", "other 1.0", " "},
+		},
+		{
+			name:   "several arches",
+			config: models.RepositoryConfig{OnlyArches: []string{"arm64", "amd64"}},
+			want:   []string{"myapp 1.0", "myapp 1.9", "myapp 1.10", "myapp 2.0~rc1", "myapp 2.0", "myapp-data 2.0", "other 1.0", " "},
+		},
+		{
+			name:   "name glob",
+			config: models.RepositoryConfig{OnlyPackages: []string{"myapp-*", "oth?r"}},
+			want:   []string{"myapp-data 2.0", "other 1.0", " "},
+		},
+		{
+			name:   "bottle file name",
+			config: models.RepositoryConfig{OnlyPackages: []string{"myapp--*"}},
+			want:   []string{" "},
+		},
+		{
+			name:    "min version",
+			config:  models.RepositoryConfig{MinVersion: "1.9"},
+			pkgType: scanner.TypeDeb,
+			want:    []string{"myapp 1.9", "myapp 1.10", "myapp 2.0~rc1", "myapp 2.0", "myapp-data 2.0", " "},
+		},
+		{
+			name:    "max version",
+			config:  models.RepositoryConfig{MaxVersion: "1.10"},
+			pkgType: scanner.TypeDeb,
+			want:    []string{"myapp 1.0", "myapp 1.9", "myapp 1.10", "other 1.0", " "},
+		},
+		{
+			name:    "debian pre-release below its release",
+			config:  models.RepositoryConfig{MinVersion: "2.0", OnlyPackages: []string{"myapp"}},
+			pkgType: scanner.TypeDeb,
+			want:    []string{"myapp 2.0"},
+		},
+		{
+			name:    "all filters",
+			config:  models.RepositoryConfig{OnlyArches: []string{"amd64"}, OnlyPackages: []string{"myapp*"}, MinVersion: "1.5", MaxVersion: "1.999"},
+			pkgType: scanner.TypeRpm,
+			want:    []string{"myapp 1.10", " "},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, pkg := range Packages(&tt.config, tt.pkgType, packages) {
+				got = append(got, pkg.Name+" "+pkg.Version)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Packages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPackagesPreReleaseBounds(t *testing.T) {
+	tests := []struct {
+		pkgType scanner.PackageType
+		version string
+		want    bool
+	}{
+		{scanner.TypeDeb, "1.0.0~rc1", false},
+		{scanner.TypeRpm, "1.0.0~rc1", false},
+		{scanner.TypePypi, "1.0.0rc1", false},
+		{scanner.TypeCargo, "1.0.0-rc.1", false},
+		{scanner.TypeHomebrewCask, "1.0.0-rc1", false},
+		{scanner.TypeCargo, "1.0.0", true},
+		{scanner.TypePypi, "1.0.0.post1", true},
+	}
+	config := models.RepositoryConfig{MinVersion: "1.0.0"}
+	for _, tt := range tests {
+		matched := Packages(&config, tt.pkgType, []models.Package{{Name: "myapp", Version: tt.version}})
+		if got := len(matched) == 1; got != tt.want {
+			t.Errorf("%s %s at or above 1.0.0 = %v, want %v", tt.pkgType, tt.version, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package fixture
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/ralt/repogen/internal/packager"
+)
+
+// Package describes a fixture package to build
+type Package struct {
+	Type        string // deb, rpm, apk, pacman or bottle
+	Name        string
+	Version     string
+	Release     string // Package release, the format's default when empty
+	Arch        string // The format's usual 64-bit x86 name when empty
+	Compression string // gzip, xz or zst for deb and pacman, the format's default when empty
+	BuildTime   time.Time
+}
+
+// Description is the description of every fixture package
+const Description = "Fixture package built by repogen mkfixture"
+
+// Types returns the package types fixtures can be built for
+func Types() []string {
+	return packager.Formats()
+}
+
+// Build writes a tiny valid package installing a single script,
+// bin/<name>, into dir and returns its path
+func Build(pkg Package, dir string) (string, error) {
+	// Bottles hold a keg, other packages the whole installation root
+	program := path.Join("usr/bin", pkg.Name)
+	if pkg.Type == "bottle" {
+		program = path.Join("bin", pkg.Name)
+	}
+
+	return packager.Build(pkg.Type, packager.Package{
+		Name:        pkg.Name,
+		Version:     pkg.Version,
+		Release:     pkg.Release,
+		Arch:        pkg.Arch,
+		Maintainer:  "Repogen <fixtures@example.com>",
+		Summary:     Description,
+		Description: fmt.Sprintf("Installs /%s, a script printing a success message.", program),
+		Homepage:    "https://github.com/ralt/repogen",
+		License:     "MIT",
+		Files: []packager.File{{
+			Path: program,
+			Mode: 0755,
+			Data: []byte(fmt.Sprintf("#!/bin/sh\necho \"%s %s installed successfully!\"\n", pkg.Name, pkg.Version)),
+		}},
+		Compression: pkg.Compression,
+		BuildTime:   pkg.BuildTime,
+	}, dir)
+}
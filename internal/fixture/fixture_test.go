@@ -0,0 +1,117 @@
+package fixture
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ralt/repogen/internal/generator/apk"
+	"github.com/ralt/repogen/internal/generator/deb"
+	"github.com/ralt/repogen/internal/generator/homebrew"
+	"github.com/ralt/repogen/internal/generator/pacman"
+	"github.com/ralt/repogen/internal/generator/rpm"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/sassoftware/go-rpmutils"
+)
+
+func TestBuild(t *testing.T) {
+	buildTime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	tests := []struct {
+		pkg      Package
+		filename string
+		parse    func(string) (*models.Package, error)
+		version  string
+		arch     string
+	}{
+		{Package{Type: "deb"}, "hello_1.2.3_amd64.deb", deb.ParsePackage, "1.2.3", "amd64"},
+		{Package{Type: "deb", Release: "2", Compression: "gzip", Arch: "arm64"}, "hello_1.2.3-2_arm64.deb", deb.ParsePackage, "1.2.3-2", "arm64"},
+		{Package{Type: "deb", Compression: "zst"}, "hello_1.2.3_amd64.deb", deb.ParsePackage, "1.2.3", "amd64"},
+		{Package{Type: "rpm"}, "hello-1.2.3-1.x86_64.rpm", rpm.ParsePackage, "1.2.3", "x86_64"},
+		{Package{Type: "rpm", Arch: "src"}, "hello-1.2.3-1.src.rpm", rpm.ParsePackage, "1.2.3", "src"},
+		{Package{Type: "apk"}, "hello-1.2.3-r0.apk", apk.ParsePackage, "1.2.3-r0", "x86_64"},
+		{Package{Type: "pacman"}, "hello-1.2.3-1-x86_64.pkg.tar.zst", pacman.ParsePackage, "1.2.3-1", "x86_64"},
+		{Package{Type: "pacman", Compression: "xz", Arch: "any"}, "hello-1.2.3-1-any.pkg.tar.xz", pacman.ParsePackage, "1.2.3-1", "any"},
+		{Package{Type: "bottle", Arch: "arm64_sonoma"}, "hello--1.2.3.arm64_sonoma.bottle.tar.gz", homebrew.ParseBottle, "1.2.3", "arm64_sonoma"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			tt.pkg.Name, tt.pkg.Version, tt.pkg.BuildTime = "hello", "1.2.3", buildTime
+
+			path, err := Build(tt.pkg, t.TempDir())
+			if err != nil {
+				t.Fatalf("Build failed: %v", err)
+			}
+			if filepath.Base(path) != tt.filename {
+				t.Errorf("unexpected file name %s", filepath.Base(path))
+			}
+
+			pkg, err := tt.parse(path)
+			if err != nil {
+				t.Fatalf("parsing the fixture failed: %v", err)
+			}
+			if pkg.Name != "hello" || pkg.Version != tt.version || pkg.Architecture != tt.arch {
+				t.Errorf("unexpected package %s %s %s", pkg.Name, pkg.Version, pkg.Architecture)
+			}
+		})
+	}
+
+	if _, err := Build(Package{Type: "msi", Name: "hello", Version: "1"}, t.TempDir()); err == nil {
+		t.Error("Build accepted an unknown type")
+	}
+	if _, err := Build(Package{Type: "deb", Name: "hello", Version: "1", Compression: "lz4"}, t.TempDir()); err == nil {
+		t.Error("Build accepted an unknown compression")
+	}
+}
+
+func TestRpmDigests(t *testing.T) {
+	path, err := Build(Package{Type: "rpm", Name: "hello", Version: "1.0", Release: "3"}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, _, err := rpmutils.Verify(f, nil); err != nil {
+		t.Fatalf("digests don't verify: %v", err)
+	}
+
+	f.Seek(0, 0)
+	r, err := rpmutils.ReadRpm(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := t.TempDir()
+	if err := r.ExpandPayload(dest); err != nil {
+		t.Fatalf("ExpandPayload failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "usr", "bin", "hello"))
+	if err != nil || !bytes.Contains(data, []byte("hello 1.0 installed successfully")) {
+		t.Errorf("unexpected payload %q: %v", data, err)
+	}
+}
+
+func TestDebWithDpkg(t *testing.T) {
+	if _, err := exec.LookPath("dpkg-deb"); err != nil {
+		t.Skip("dpkg-deb not available")
+	}
+
+	for _, compression := range []string{"xz", "gzip"} {
+		path, err := Build(Package{Type: "deb", Name: "hello", Version: "1.0", Compression: compression}, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command("dpkg-deb", "--info", path).CombinedOutput(); err != nil {
+			t.Errorf("dpkg-deb --info failed: %v\n%s", err, out)
+		}
+		if out, err := exec.Command("dpkg-deb", "--contents", path).CombinedOutput(); err != nil || !bytes.Contains(out, []byte("./usr/bin/hello")) {
+			t.Errorf("dpkg-deb --contents failed: %v\n%s", err, out)
+		}
+	}
+}
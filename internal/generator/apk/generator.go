@@ -11,7 +11,9 @@ import (
 	"io"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/ralt/repogen/internal/events"
 	"github.com/ralt/repogen/internal/generator"
 	"github.com/ralt/repogen/internal/models"
 	"github.com/ralt/repogen/internal/scanner"
@@ -20,6 +22,10 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// RepositoriesFileName is the file holding the line clients add to
+// /etc/apk/repositories
+const RepositoriesFileName = "repositories"
+
 // Generator implements the generator.Generator interface for Alpine repositories
 type Generator struct {
 	rsaSigner signer.RSASigner
@@ -48,13 +54,37 @@ func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfi
 		archPackages[arch] = append(archPackages[arch], pkg)
 	}
 
-	// Generate repository for each architecture
+	// Generate repository for each architecture, several at once
+	var arches []string
 	for _, arch := range config.Arches {
-		if pkgs, ok := archPackages[arch]; ok {
-			if err := g.generateForArch(ctx, config, arch, pkgs); err != nil {
-				return fmt.Errorf("failed to generate for %s: %w", arch, err)
-			}
+		if _, ok := archPackages[arch]; ok {
+			arches = append(arches, arch)
+		}
+	}
+	err := generator.ForEach(ctx, generator.Concurrency(config), len(arches), func(ctx context.Context, i int) error {
+		if err := g.generateForArch(ctx, config, arches[i], archPackages[arches[i]]); err != nil {
+			return fmt.Errorf("failed to generate for %s: %w", arches[i], err)
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Published under the name apk looks the key up by in /etc/apk/keys
+	if g.rsaSigner != nil {
+		if err := signer.PublishRSAPublicKey(g.rsaSigner, config.OutputDir, PublicKeyFileName(g.keyName)); err != nil {
+			return err
+		}
+	}
+
+	// apk appends /<arch>/APKINDEX.tar.gz to the lines of /etc/apk/repositories
+	if config.BaseURL != "" {
+		path := filepath.Join(config.OutputDir, RepositoriesFileName)
+		if err := utils.WriteFile(path, []byte(strings.TrimSuffix(config.BaseURL, "/")+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write repositories file: %w", err)
+		}
+		logrus.Infof("Alpine repositories line written to: %s", path)
 	}
 
 	logrus.Info("Alpine repository generated successfully")
@@ -73,6 +103,9 @@ func (g *Generator) generateForArch(ctx context.Context, config *models.Reposito
 
 	// Copy APK files to architecture directory and recalculate checksums
 	for i := range packages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		pkg := &packages[i]
 		dstPath := filepath.Join(archDir, filepath.Base(pkg.Filename))
 
@@ -85,14 +118,16 @@ func (g *Generator) generateForArch(ctx context.Context, config *models.Reposito
 		if needsCopy {
 			logrus.Debugf("Copying package: %s -> %s", srcPath, finalDstPath)
 
-			if err := utils.CopyFile(srcPath, finalDstPath); err != nil {
+			// Copy package file, checksumming it on the way
+			checksums, err := utils.CopyFileWithChecksums(ctx, srcPath, finalDstPath)
+			if err != nil {
 				return fmt.Errorf("failed to copy %s: %w", srcPath, err)
 			}
 
-			// Recalculate checksums on the copied file to ensure accuracy
-			checksums, err := utils.CalculateChecksums(finalDstPath)
-			if err != nil {
-				return fmt.Errorf("failed to calculate checksums for %s: %w", filepath.Base(pkg.Filename), err)
+			if config.VerifyWrites {
+				if err := utils.VerifyCopy(finalDstPath, checksums); err != nil {
+					return fmt.Errorf("failed to verify %s: %w", srcPath, err)
+				}
 			}
 			pkg.Size = checksums.Size
 			pkg.MD5Sum = checksums.MD5
@@ -115,29 +150,28 @@ func (g *Generator) generateForArch(ctx context.Context, config *models.Reposito
 	descData := []byte(fmt.Sprintf("Alpine Package Index for %s", arch))
 
 	// Package into tar.gz
-	apkindexTarGz, err := createAPKINDEXTarGz(descData, apkindexData)
+	apkindexTarGz, err := createAPKINDEXTarGz(descData, apkindexData, utils.Timestamp(config))
 	if err != nil {
 		return fmt.Errorf("failed to create APKINDEX.tar.gz: %w", err)
 	}
 
-	apkindexPath := filepath.Join(archDir, "APKINDEX.tar.gz")
-	if err := utils.WriteFile(apkindexPath, apkindexTarGz, 0644); err != nil {
-		return fmt.Errorf("failed to write APKINDEX.tar.gz: %w", err)
-	}
-
-	// Sign if signer available
+	// Sign if signer available. The signature is embedded as the first
+	// gzip stream of APKINDEX.tar.gz, which is what apk verifies natively
 	if g.rsaSigner != nil {
-		signature, err := g.rsaSigner.SignRSA(apkindexTarGz)
+		apkindexTarGz, err = signIndex(g.rsaSigner, g.keyName, apkindexTarGz, utils.Timestamp(config))
 		if err != nil {
-			return fmt.Errorf("failed to sign APKINDEX: %w", err)
+			return err
 		}
 
-		sigPath := filepath.Join(archDir, fmt.Sprintf("APKINDEX.tar.gz.SIGN.RSA.%s.pub", g.keyName))
-		if err := utils.WriteFile(sigPath, signature, 0644); err != nil {
-			return fmt.Errorf("failed to write signature: %w", err)
-		}
+		logrus.Infof("APKINDEX signed successfully (install keys/%s as /etc/apk/keys/%s)", PublicKeyFileName(g.keyName), PublicKeyFileName(g.keyName))
+	}
 
-		logrus.Info("APKINDEX signed successfully")
+	apkindexPath := filepath.Join(archDir, "APKINDEX.tar.gz")
+	if err := utils.WriteFile(apkindexPath, apkindexTarGz, 0644); err != nil {
+		return fmt.Errorf("failed to write APKINDEX.tar.gz: %w", err)
+	}
+	if g.rsaSigner != nil {
+		events.Emit(events.Signed, events.Fields{"path": apkindexPath, "kind": "embedded"})
 	}
 
 	logrus.Infof("Generated APKINDEX for %s (%d packages)", arch, len(packages))
@@ -149,8 +183,14 @@ func generateAPKINDEX(packages []models.Package) ([]byte, error) {
 	var buf bytes.Buffer
 
 	for i, pkg := range packages {
-		// Convert SHA1 hex string to bytes, then base64 encode with Q1 prefix
-		sha1Bytes, err := hex.DecodeString(pkg.SHA1Sum)
+		// Convert SHA1 hex string to bytes, then base64 encode with Q1 prefix.
+		// apk identifies packages by the SHA1 of their control segment, which
+		// is the whole file only for single-stream packages
+		identity := pkg.SHA1Sum
+		if controlSHA1, ok := pkg.Metadata["control_sha1"].(string); ok && controlSHA1 != "" {
+			identity = controlSHA1
+		}
+		sha1Bytes, err := hex.DecodeString(identity)
 		if err != nil {
 			return nil, fmt.Errorf("failed to decode SHA1 for %s: %w", pkg.Name, err)
 		}
@@ -191,19 +231,20 @@ func generateAPKINDEX(packages []models.Package) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// createAPKINDEXTarGz creates a tar.gz archive containing DESCRIPTION and APKINDEX
-func createAPKINDEXTarGz(description, apkindex []byte) ([]byte, error) {
+// createAPKINDEXTarGz creates a tar.gz archive containing DESCRIPTION and
+// APKINDEX, modified at modTime
+func createAPKINDEXTarGz(description, apkindex []byte, modTime time.Time) ([]byte, error) {
 	var buf bytes.Buffer
 	gw := gzip.NewWriter(&buf)
 	tw := tar.NewWriter(gw)
 
 	// Add DESCRIPTION file
-	if err := addTarFile(tw, "DESCRIPTION", description); err != nil {
+	if err := addTarFile(tw, "DESCRIPTION", description, modTime); err != nil {
 		return nil, err
 	}
 
 	// Add APKINDEX file
-	if err := addTarFile(tw, "APKINDEX", apkindex); err != nil {
+	if err := addTarFile(tw, "APKINDEX", apkindex, modTime); err != nil {
 		return nil, err
 	}
 
@@ -217,12 +258,13 @@ func createAPKINDEXTarGz(description, apkindex []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// addTarFile adds a file to a tar archive
-func addTarFile(tw *tar.Writer, name string, data []byte) error {
+// addTarFile adds a file modified at modTime to a tar archive
+func addTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
 	header := &tar.Header{
-		Name: name,
-		Mode: 0644,
-		Size: int64(len(data)),
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
 	}
 
 	if err := tw.WriteHeader(header); err != nil {
@@ -1,13 +1,26 @@
 package apk
 
 import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/signer"
 )
 
 func TestIncrementalModeCopiesNewPackages(t *testing.T) {
@@ -141,3 +154,176 @@ func TestIncrementalModeCopiesNewPackages(t *testing.T) {
 
 	t.Logf("Incremental mode test passed for APK!")
 }
+
+func TestSignedAPKINDEXIsAbuildCompatible(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Create an RSA key the same way abuild-keygen does
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	keyPath := filepath.Join(tmpDir, "test.rsa")
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	})
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	rsaSigner, err := signer.NewAlpineRSASigner(keyPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	pkgPath := filepath.Join(tmpDir, "pkga-1.0-r0.apk")
+	os.WriteFile(pkgPath, []byte("fake apk package A"), 0644)
+
+	outputDir := filepath.Join(tmpDir, "output")
+	gen := NewGenerator(rsaSigner, "test")
+	config := &models.RepositoryConfig{
+		OutputDir: outputDir,
+		Arches:    []string{"x86_64"},
+		BaseURL:   "https://example.com/alpine/",
+	}
+
+	packages := []models.Package{
+		{
+			Name:         "pkga",
+			Version:      "1.0-r0",
+			Architecture: "x86_64",
+			Filename:     pkgPath,
+			SHA1Sum:      "0000000000000000000000000000000000000000",
+		},
+	}
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generation failed: %v", err)
+	}
+
+	// No detached signature should be written anymore
+	matches, _ := filepath.Glob(filepath.Join(outputDir, "x86_64", "*.SIGN.*"))
+	if len(matches) != 0 {
+		t.Errorf("Unexpected detached signature files: %v", matches)
+	}
+
+	// The public key is published under the name apk expects
+	publicKey, _ := rsaSigner.GetPublicKey()
+	published, err := os.ReadFile(filepath.Join(outputDir, "keys", "test.rsa.pub"))
+	if err != nil || !bytes.Equal(published, publicKey) {
+		t.Errorf("Public key not published as keys/test.rsa.pub: %v", err)
+	}
+	if line, err := os.ReadFile(filepath.Join(outputDir, RepositoriesFileName)); err != nil || string(line) != "https://example.com/alpine\n" {
+		t.Errorf("Unexpected /etc/apk/repositories line %q: %v", line, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "x86_64", "APKINDEX.tar.gz"))
+	if err != nil {
+		t.Fatalf("Failed to read APKINDEX.tar.gz: %v", err)
+	}
+
+	// Split the first gzip stream (signature) from the rest (index)
+	cr := &countingReader{r: bufio.NewReader(bytes.NewReader(data))}
+	gr, err := gzip.NewReader(cr)
+	if err != nil {
+		t.Fatalf("Failed to open signature stream: %v", err)
+	}
+	gr.Multistream(false)
+	sigTar, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("Failed to read signature stream: %v", err)
+	}
+	indexTarGz := data[cr.n:]
+
+	// The signature tar must be cut: one header block plus one data block
+	if len(sigTar) != 1024 {
+		t.Errorf("Signature tar should not contain end-of-archive blocks, got %d bytes", len(sigTar))
+	}
+
+	tr := tar.NewReader(bytes.NewReader(sigTar))
+	header, err := tr.Next()
+	if err != nil {
+		t.Fatalf("Failed to read signature entry: %v", err)
+	}
+	if header.Name != ".SIGN.RSA.test.rsa.pub" {
+		t.Errorf("Unexpected signature entry name: %s", header.Name)
+	}
+	signature, _ := io.ReadAll(tr)
+
+	hashed := sha1.Sum(indexTarGz)
+	if err := rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA1, hashed[:], signature); err != nil {
+		t.Errorf("Signature does not verify against index stream: %v", err)
+	}
+
+	// Existing metadata must still be readable from a signed index
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("Failed to parse signed APKINDEX: %v", err)
+	}
+	if len(existing) != 1 || existing[0].Name != "pkga" {
+		t.Errorf("Unexpected packages parsed from signed APKINDEX: %+v", existing)
+	}
+}
+
+func TestCalculateControlSHA1MultiStream(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Build a package the way abuild does: control and data as separate gzip streams
+	writeSegment := func(buf *bytes.Buffer, name string, content []byte, cut bool) []byte {
+		var tarBuf bytes.Buffer
+		tw := tar.NewWriter(&tarBuf)
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})
+		tw.Write(content)
+		if cut {
+			tw.Flush()
+		} else {
+			tw.Close()
+		}
+
+		var seg bytes.Buffer
+		gw := gzip.NewWriter(&seg)
+		gw.Write(tarBuf.Bytes())
+		gw.Close()
+		buf.Write(seg.Bytes())
+		return seg.Bytes()
+	}
+
+	var apk bytes.Buffer
+	control := writeSegment(&apk, ".PKGINFO", []byte("pkgname = pkga\npkgver = 1.0-r0\n"), true)
+	writeSegment(&apk, "usr/bin/pkga", []byte("#!/bin/sh\n"), false)
+
+	pkgPath := filepath.Join(tmpDir, "pkga-1.0-r0.apk")
+	if err := os.WriteFile(pkgPath, apk.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+
+	got, err := calculateControlSHA1(pkgPath)
+	if err != nil {
+		t.Fatalf("calculateControlSHA1 failed: %v", err)
+	}
+
+	expected := sha1.Sum(control)
+	if got != hex.EncodeToString(expected[:]) {
+		t.Errorf("Control checksum mismatch: got %s, want %x", got, expected)
+	}
+}
+
+func TestRequiresSkipsAutomaticDependencies(t *testing.T) {
+	pkg := models.Package{
+		Name:         "hello",
+		Dependencies: []string{"musl>=1.2", "so:libc.musl-x86_64.so.1", "cmd:sh", "!hello-legacy", "busybox"},
+		Metadata:     map[string]interface{}{"provides": "greeter=1.0 cmd:hello"},
+	}
+
+	gen := &Generator{}
+	var requires []string
+	for _, alternatives := range gen.Requires(pkg) {
+		requires = append(requires, strings.Join(alternatives, "|"))
+	}
+	if got := strings.Join(requires, ","); got != "musl,busybox" {
+		t.Errorf("Requires = %s", got)
+	}
+	if got := strings.Join(gen.Provides(pkg), ","); got != "hello,greeter,cmd:hello" {
+		t.Errorf("Provides = %s", got)
+	}
+}
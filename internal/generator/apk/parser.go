@@ -5,6 +5,7 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha1"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
@@ -14,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/ralt/repogen/internal/depcheck"
 	"github.com/ralt/repogen/internal/models"
 	"github.com/ralt/repogen/internal/utils"
 )
@@ -46,9 +48,100 @@ func ParsePackage(path string) (*models.Package, error) {
 	pkg.SHA256Sum = checksums.SHA256
 	pkg.SHA512Sum = checksums.SHA512
 
+	// Record the control segment checksum used by apk as package identity
+	controlSHA1, err := calculateControlSHA1(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum control segment: %w", err)
+	}
+	pkg.Metadata["control_sha1"] = controlSHA1
+
 	return pkg, nil
 }
 
+// countingReader tracks how many bytes have been consumed from the underlying reader.
+// It implements io.ByteReader so gzip does not add its own read-ahead buffering,
+// which keeps the count exact at gzip stream boundaries
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// calculateControlSHA1 returns the hex SHA1 of the gzip stream holding .PKGINFO.
+// Packages built by abuild are made of up to three concatenated gzip streams
+// (signature, control, data) and apk identifies them by the control one.
+// For single-stream packages this is the checksum of the whole file
+func calculateControlSHA1(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	cr := &countingReader{r: bufio.NewReader(f)}
+	var gr *gzip.Reader
+
+	for {
+		start := cr.n
+
+		if gr == nil {
+			gr, err = gzip.NewReader(cr)
+		} else {
+			err = gr.Reset(cr)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		gr.Multistream(false)
+
+		// Segments other than the last one are cut tar archives without
+		// end-of-archive blocks, so stop at the first read error
+		hasPKGINFO := false
+		tr := tar.NewReader(gr)
+		for {
+			header, err := tr.Next()
+			if err != nil {
+				break
+			}
+			if header.Name == ".PKGINFO" {
+				hasPKGINFO = true
+				break
+			}
+		}
+
+		// Drain the rest of the stream to find where it ends
+		if _, err := io.Copy(io.Discard, gr); err != nil {
+			return "", err
+		}
+
+		if hasPKGINFO {
+			h := sha1.New()
+			if _, err := io.Copy(h, io.NewSectionReader(f, start, cr.n-start)); err != nil {
+				return "", err
+			}
+			return hex.EncodeToString(h.Sum(nil)), nil
+		}
+	}
+
+	return "", fmt.Errorf(".PKGINFO not found in APK")
+}
+
 // extractPKGINFO extracts the .PKGINFO file from an APK package
 func extractPKGINFO(path string) ([]byte, error) {
 	f, err := os.Open(path)
@@ -164,7 +257,12 @@ func parseAPKINDEX(path string) ([]models.Package, error) {
 	}
 	defer f.Close()
 
-	gz, err := gzip.NewReader(f)
+	return parseAPKINDEXArchive(f)
+}
+
+// parseAPKINDEXArchive reads the packages of an APKINDEX.tar.gz
+func parseAPKINDEXArchive(r io.Reader) ([]models.Package, error) {
+	gz, err := gzip.NewReader(r)
 	if err != nil {
 		return nil, err
 	}
@@ -237,6 +335,7 @@ func parseAPKINDEXContent(data []byte) ([]models.Package, error) {
 				sha1Base64 := value[2:]
 				sha1Bytes, _ := base64.StdEncoding.DecodeString(sha1Base64)
 				currentPkg.SHA1Sum = hex.EncodeToString(sha1Bytes)
+				currentPkg.Metadata["control_sha1"] = currentPkg.SHA1Sum
 			}
 		case 'P': // Package name
 			currentPkg.Name = value
@@ -275,3 +374,33 @@ func parseAPKINDEXContent(data []byte) ([]models.Package, error) {
 
 	return packages, scanner.Err()
 }
+
+// PackageFiles returns the path of a package from existing metadata
+func (g *Generator) PackageFiles(config *models.RepositoryConfig, pkg models.Package) []string {
+	return []string{filepath.Join(config.OutputDir, pkg.Architecture, filepath.Base(pkg.Filename))}
+}
+
+// Requires returns the dependencies of pkg on packages. Automatic ones on
+// shared libraries, commands and pkg-config files (so:, cmd: and pc:)
+// aren't checked, nor are conflicts (!name)
+func (g *Generator) Requires(pkg models.Package) [][]string {
+	var requires [][]string
+	for _, dep := range pkg.Dependencies {
+		if strings.HasPrefix(dep, "!") || strings.HasPrefix(dep, "so:") || strings.HasPrefix(dep, "cmd:") || strings.HasPrefix(dep, "pc:") {
+			continue
+		}
+		requires = append(requires, []string{depcheck.TrimConstraint(dep)})
+	}
+	return requires
+}
+
+// Provides returns the name of pkg and the names it provides
+func (g *Generator) Provides(pkg models.Package) []string {
+	provides := []string{pkg.Name}
+	if value, ok := pkg.Metadata["provides"].(string); ok {
+		for _, name := range strings.Fields(value) {
+			provides = append(provides, depcheck.TrimConstraint(name))
+		}
+	}
+	return provides
+}
@@ -0,0 +1,86 @@
+package apk
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ralt/repogen/internal/signer"
+)
+
+// signatureFileName returns the name of the signature entry apk looks for.
+// apk matches the part after ".SIGN.RSA." against the file names in
+// /etc/apk/keys, so the key must be installed as <keyName>.rsa.pub
+func signatureFileName(keyName string) string {
+	return fmt.Sprintf(".SIGN.RSA.%s", PublicKeyFileName(keyName))
+}
+
+// PublicKeyFileName returns the file name the public key must have in /etc/apk/keys
+func PublicKeyFileName(keyName string) string {
+	if strings.HasSuffix(keyName, ".pub") {
+		return keyName
+	}
+	return keyName + ".rsa.pub"
+}
+
+// signIndex prepends an abuild-sign compatible signature segment to a
+// gzipped APKINDEX tarball.
+//
+// apk expects signed archives to be a concatenation of gzip streams, the first
+// one being a tar archive holding only the signature entry. That tar archive
+// must not contain the end-of-archive blocks (abuild-tar --cut), otherwise apk
+// stops reading before reaching the index itself. The signature covers the
+// compressed bytes of the index stream. The entry is modified at modTime.
+func signIndex(rsaSigner signer.RSASigner, keyName string, indexTarGz []byte, modTime time.Time) ([]byte, error) {
+	signature, err := rsaSigner.SignRSA(indexTarGz)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign APKINDEX: %w", err)
+	}
+	// Catch key misconfigurations before apk does
+	if err := signer.CheckRSA(rsaSigner, signature, indexTarGz); err != nil {
+		return nil, fmt.Errorf("APKINDEX signature self-check failed: %w", err)
+	}
+
+	var sigTar bytes.Buffer
+	tw := tar.NewWriter(&sigTar)
+
+	header := &tar.Header{
+		Name:     signatureFileName(keyName),
+		Mode:     0644,
+		Size:     int64(len(signature)),
+		ModTime:  modTime,
+		Uname:    "root",
+		Gname:    "root",
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(signature); err != nil {
+		return nil, err
+	}
+
+	// Flush pads the entry to a full block without writing the
+	// end-of-archive marker that Close would add
+	if err := tw.Flush(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gw.Write(sigTar.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	buf.Write(indexTarGz)
+	return buf.Bytes(), nil
+}
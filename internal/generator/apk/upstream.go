@@ -0,0 +1,35 @@
+package apk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+)
+
+// ReadUpstream reads the APKINDEX.tar.gz of each architecture of config.
+// Alpine indexes don't record the checksum of package files, and their
+// signatures are not checked
+func (g *Generator) ReadUpstream(ctx context.Context, config *models.RepositoryConfig, upstream generator.Upstream) ([]models.Package, error) {
+	var allPackages []models.Package
+	for _, arch := range config.Arches {
+		indexPath := path.Join(arch, "APKINDEX.tar.gz")
+		data, err := upstream.Get(ctx, indexPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", indexPath, err)
+		}
+		packages, err := parseAPKINDEXArchive(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", indexPath, err)
+		}
+
+		for _, pkg := range packages {
+			pkg.Filename = path.Join(arch, fmt.Sprintf("%s-%s.apk", pkg.Name, pkg.Version))
+			allPackages = append(allPackages, pkg)
+		}
+	}
+	return allPackages, nil
+}
@@ -0,0 +1,258 @@
+package cargo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Crates are served from cratesDir, next to the index files
+const cratesDir = "crates"
+
+// registryConfig is the config.json at the root of a sparse registry
+type registryConfig struct {
+	DL string `json:"dl"`
+}
+
+// Generator implements the generator.Generator interface for Cargo
+// sparse registries
+type Generator struct{}
+
+// NewGenerator creates a new Cargo generator
+func NewGenerator() generator.Generator {
+	return &Generator{}
+}
+
+// Generate copies the crates and writes config.json and the index file of
+// every crate
+func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfig, packages []models.Package) error {
+	logrus.Info("Generating Cargo sparse registry...")
+
+	// cargo requires absolute download URLs
+	if config.BaseURL == "" {
+		return fmt.Errorf("cargo registries require --base-url, the URL the registry is served from")
+	}
+	baseURL := strings.TrimRight(config.BaseURL, "/")
+
+	data, err := json.MarshalIndent(registryConfig{
+		DL: baseURL + "/" + cratesDir + "/{crate}/{crate}-{version}.crate",
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFile(filepath.Join(config.OutputDir, "config.json"), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write config.json: %w", err)
+	}
+
+	// Group versions by crate, crate names being case insensitive
+	versionsByCrate := make(map[string][]models.Package)
+	for i := range packages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pkg := &packages[i]
+		if err := publishCrate(ctx, config, pkg); err != nil {
+			return fmt.Errorf("failed to publish %s: %w", pkg.Name, err)
+		}
+		key := strings.ToLower(pkg.Name)
+		versionsByCrate[key] = append(versionsByCrate[key], *pkg)
+	}
+
+	ownIndex := "sparse+" + baseURL + "/"
+	for _, versions := range versionsByCrate {
+		sort.SliceStable(versions, func(i, j int) bool {
+			return utils.CompareSemanticVersions(versions[i].Version, versions[j].Version) < 0
+		})
+
+		var b bytes.Buffer
+		for _, pkg := range versions {
+			line, err := json.Marshal(entryFor(pkg, ownIndex))
+			if err != nil {
+				return err
+			}
+			b.Write(line)
+			b.WriteByte('\n')
+		}
+
+		file := filepath.Join(config.OutputDir, filepath.FromSlash(indexPath(versions[0].Name)))
+		if err := utils.WriteFile(file, b.Bytes(), 0644); err != nil {
+			return fmt.Errorf("failed to write index file: %w", err)
+		}
+	}
+
+	// Crates left without versions drop out of the index
+	if err := removeStaleIndexFiles(config.OutputDir, versionsByCrate); err != nil {
+		return err
+	}
+
+	logrus.Infof("Cargo registry generated successfully (%d crates, %d versions)", len(versionsByCrate), len(packages))
+	return nil
+}
+
+// publishCrate copies a crate to crates/<name>/
+func publishCrate(ctx context.Context, config *models.RepositoryConfig, pkg *models.Package) error {
+	dstPath := filepath.Join(config.OutputDir, filepath.FromSlash(crateFile(pkg.Name, pkg.Version)))
+
+	// Crates read back from the index are published already, possibly
+	// only on remote storage
+	srcPath, finalDstPath, needsCopy, err := utils.ShouldCopyPackage(pkg, dstPath, config.OutputDir)
+	if err != nil {
+		return fmt.Errorf("package copy check failed: %w", err)
+	}
+
+	if needsCopy {
+		logrus.Debugf("Copying package: %s -> %s", srcPath, finalDstPath)
+
+		checksums, err := utils.CopyFileWithChecksums(ctx, srcPath, finalDstPath)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", srcPath, err)
+		}
+
+		if config.VerifyWrites {
+			if err := utils.VerifyCopy(finalDstPath, checksums); err != nil {
+				return fmt.Errorf("failed to verify %s: %w", srcPath, err)
+			}
+		}
+
+		pkg.Size = checksums.Size
+		pkg.MD5Sum = checksums.MD5
+		pkg.SHA1Sum = checksums.SHA1
+		pkg.SHA256Sum = checksums.SHA256
+	}
+
+	pkg.Filename = filepath.FromSlash(crateFile(pkg.Name, pkg.Version))
+	return nil
+}
+
+// entryFor returns the index entry of a crate version: its checksum, and
+// yanked when the package is deprecated. Dependencies on ownIndex, this
+// registry, are written without a registry
+func entryFor(pkg models.Package, ownIndex string) indexEntry {
+	entry := indexEntry{
+		Name:     pkg.Name,
+		Vers:     pkg.Version,
+		Deps:     []indexDep{},
+		Features: map[string][]string{},
+	}
+	if known, ok := pkg.Metadata["IndexEntry"].(*indexEntry); ok {
+		entry = *known
+	}
+	entry.Cksum = pkg.SHA256Sum
+	entry.Yanked = pkg.Deprecation != nil
+
+	deps := make([]indexDep, len(entry.Deps))
+	for i, dep := range entry.Deps {
+		if dep.Registry != nil && strings.TrimRight(*dep.Registry, "/")+"/" == ownIndex {
+			dep.Registry = nil
+		}
+		deps[i] = dep
+	}
+	entry.Deps = deps
+
+	return entry
+}
+
+// removeStaleIndexFiles deletes the index files of crates without versions
+func removeStaleIndexFiles(outputDir string, versionsByCrate map[string][]models.Package) error {
+	files, err := indexFiles(outputDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		if _, ok := versionsByCrate[name]; ok {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return fmt.Errorf("failed to remove stale index file: %w", err)
+		}
+		logrus.Infof("Removed crate %s, which has no versions left", name)
+	}
+	return nil
+}
+
+// indexFiles lists the index files of a registry: the files whose path is
+// the index path of their name
+func indexFiles(outputDir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(outputDir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && file == outputDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		rel, err := filepath.Rel(outputDir, file)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if rel == cratesDir || strings.HasPrefix(d.Name(), ".") && rel != "." {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.ToSlash(rel) == indexPath(d.Name()) {
+			files = append(files, file)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// ValidatePackages checks if packages are crates
+func (g *Generator) ValidatePackages(packages []models.Package) error {
+	for _, pkg := range packages {
+		if !strings.HasSuffix(pkg.Filename, ".crate") {
+			return fmt.Errorf("package %s is not a crate", pkg.Filename)
+		}
+	}
+	return nil
+}
+
+// GetSupportedType returns the package type this generator supports
+func (g *Generator) GetSupportedType() scanner.PackageType {
+	return scanner.TypeCargo
+}
+
+// ParseExistingMetadata reads the index files of the registry
+func (g *Generator) ParseExistingMetadata(config *models.RepositoryConfig) ([]models.Package, error) {
+	files, err := indexFiles(config.OutputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []models.Package
+	for _, file := range files {
+		versions, err := parseIndexFile(file)
+		if err != nil {
+			logrus.Warnf("Skipping %s: %v", file, err)
+			continue
+		}
+		packages = append(packages, versions...)
+	}
+
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no existing Cargo index found in %s", config.OutputDir)
+	}
+
+	return packages, nil
+}
+
+// PackageFiles returns the path of a crate version
+func (g *Generator) PackageFiles(config *models.RepositoryConfig, pkg models.Package) []string {
+	return []string{filepath.Join(config.OutputDir, filepath.FromSlash(crateFile(pkg.Name, pkg.Version)))}
+}
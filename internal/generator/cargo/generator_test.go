@@ -0,0 +1,240 @@
+package cargo
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+// writeCrate writes a .crate holding the given Cargo.toml
+func writeCrate(t *testing.T, dir, name, version, manifest string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name+"-"+version+".crate")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for file, data := range map[string]string{
+		"Cargo.toml":      manifest,
+		"src/lib.rs":      "pub fn hello() {}\n",
+		"Cargo.toml.orig": "[package]\n",
+	} {
+		tw.WriteHeader(&tar.Header{Name: name + "-" + version + "/" + file, Mode: 0644, Size: int64(len(data))})
+		tw.Write([]byte(data))
+	}
+	tw.Close()
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const helloManifest = `# THIS FILE IS AUTOMATICALLY GENERATED BY CARGO
+[package]
+edition = "2021"
+rust-version = "1.70"
+name = "hello-world"
+version = "0.2.0"
+authors = ["Jane Doe <jane@example.com>"]
+description = """
+Says hello
+"""
+license = "MIT OR Apache-2.0"
+repository = 'https://example.com/hello'
+
+[[bin]]
+name = "hello"
+path = "src/main.rs"
+
+[dependencies.serde]
+version = "1.0.100"
+features = ["derive"]
+optional = true
+
+[dependencies.greeting]
+version = "0.1"
+registry-index = "sparse+https://crates.example.com/"
+
+[dependencies.rand2]
+version = ">=0.8, <0.9"
+package = "rand"
+default-features = false
+
+[dev-dependencies]
+tempfile = "3"
+
+[target."cfg(unix)".dependencies.libc]
+version = "0.2"
+
+[features]
+default = ["std"]
+std = []
+json = ["dep:serde", "serde?/std"]
+`
+
+func TestParseTOML(t *testing.T) {
+	doc, err := parseTOML(helloManifest + "inline = { a = 1, b.c = [true, 'x'] } # comment\n")
+	if err != nil {
+		t.Fatalf("parseTOML failed: %v", err)
+	}
+
+	pkg := doc["package"].(map[string]interface{})
+	if pkg["description"] != "Says hello\n" || pkg["repository"] != "https://example.com/hello" {
+		t.Errorf("unexpected package table %v", pkg)
+	}
+	if bins := doc["bin"].([]interface{}); len(bins) != 1 {
+		t.Errorf("unexpected bin tables %v", bins)
+	}
+	libc := doc["target"].(map[string]interface{})["cfg(unix)"].(map[string]interface{})["dependencies"].(map[string]interface{})["libc"]
+	if libc.(map[string]interface{})["version"] != "0.2" {
+		t.Errorf("unexpected target dependency %v", libc)
+	}
+	inline := doc["features"].(map[string]interface{})["inline"].(map[string]interface{})
+	if inline["a"] != int64(1) || inline["b"].(map[string]interface{})["c"].([]interface{})[1] != "x" {
+		t.Errorf("unexpected inline table %v", inline)
+	}
+
+	if _, err := parseTOML("[package\nname = 1\n"); err == nil {
+		t.Error("parseTOML accepted an unterminated table header")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputDir := filepath.Join(tmpDir, "output")
+
+	var packages []models.Package
+	for _, version := range []string{"0.1.0", "0.2.0"} {
+		manifest := strings.Replace(helloManifest, `version = "0.2.0"`, `version = "`+version+`"`, 1)
+		pkg, err := ParsePackage(writeCrate(t, tmpDir, "hello-world", version, manifest))
+		if err != nil {
+			t.Fatalf("ParsePackage failed: %v", err)
+		}
+		packages = append(packages, *pkg)
+	}
+	pkg, err := ParsePackage(writeCrate(t, tmpDir, "ab", "1.0.0", "[package]\nname = \"ab\"\nversion = \"1.0.0\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packages = append(packages, *pkg)
+
+	if packages[1].Description != "Says hello" || packages[1].Homepage != "https://example.com/hello" {
+		t.Errorf("unexpected package %+v", packages[1])
+	}
+	packages[0].Deprecation = &models.Deprecation{Message: "broken"}
+
+	gen := NewGenerator()
+	config := &models.RepositoryConfig{OutputDir: outputDir}
+	if err := gen.Generate(context.Background(), config, packages); err == nil {
+		t.Error("Generate succeeded without a base URL")
+	}
+
+	config.BaseURL = "https://crates.example.com/"
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(outputDir, "config.json"))
+	if !strings.Contains(string(data), `"dl": "https://crates.example.com/crates/{crate}/{crate}-{version}.crate"`) {
+		t.Errorf("unexpected config.json:\n%s", data)
+	}
+	for _, file := range []string{"crates/hello-world/hello-world-0.2.0.crate", "crates/ab/ab-1.0.0.crate", "2/ab"} {
+		if _, err := os.Stat(filepath.Join(outputDir, file)); err != nil {
+			t.Errorf("missing %s: %v", file, err)
+		}
+	}
+
+	data, err = os.ReadFile(filepath.Join(outputDir, "he", "ll", "hello-world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 index lines, got %d:\n%s", len(lines), data)
+	}
+
+	var old, entry indexEntry
+	json.Unmarshal([]byte(lines[0]), &old)
+	json.Unmarshal([]byte(lines[1]), &entry)
+	if !old.Yanked || entry.Yanked {
+		t.Errorf("unexpected yanked flags %v, %v", old.Yanked, entry.Yanked)
+	}
+	if entry.Vers != "0.2.0" || entry.Cksum != packages[1].SHA256Sum || entry.RustVersion != "1.70" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	if entry.V != 2 || len(entry.Features2["json"]) != 2 || len(entry.Features["default"]) != 1 {
+		t.Errorf("unexpected features %v / %v", entry.Features, entry.Features2)
+	}
+
+	deps := make(map[string]indexDep)
+	for _, dep := range entry.Deps {
+		deps[dep.Name] = dep
+	}
+	if dep := deps["rand2"]; dep.Req != ">=0.8, <0.9" || dep.Package == nil || *dep.Package != "rand" || dep.DefaultFeatures {
+		t.Errorf("unexpected renamed dependency %+v", dep)
+	}
+	if dep := deps["serde"]; dep.Req != "^1.0.100" || !dep.Optional || *dep.Registry != cratesIOIndex {
+		t.Errorf("unexpected crates.io dependency %+v", dep)
+	}
+	if dep := deps["greeting"]; dep.Registry != nil {
+		t.Errorf("dependency on this registry names it: %s", *dep.Registry)
+	}
+	if dep := deps["tempfile"]; dep.Kind != "dev" || dep.Req != "^3" {
+		t.Errorf("unexpected dev dependency %+v", dep)
+	}
+	if dep := deps["libc"]; dep.Target == nil || *dep.Target != "cfg(unix)" {
+		t.Errorf("unexpected target dependency %+v", dep)
+	}
+
+	// The index reads back, and regenerating from it reproduces it
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 3 {
+		t.Fatalf("expected 3 versions, got %d", len(existing))
+	}
+
+	var remaining []models.Package
+	for _, pkg := range existing {
+		if pkg.Name != "ab" {
+			remaining = append(remaining, pkg)
+		}
+	}
+	if err := gen.Generate(context.Background(), config, remaining); err != nil {
+		t.Fatalf("Generate from existing failed: %v", err)
+	}
+	again, _ := os.ReadFile(filepath.Join(outputDir, "he", "ll", "hello-world"))
+	if string(again) != string(data) {
+		t.Errorf("regenerated index file differs:\n%s\nwant:\n%s", again, data)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "2", "ab")); !os.IsNotExist(err) {
+		t.Error("index file of a crate without versions left behind")
+	}
+}
+
+func TestIndexPath(t *testing.T) {
+	for name, want := range map[string]string{
+		"a":      "1/a",
+		"ab":     "2/ab",
+		"abc":    "3/a/abc",
+		"Serde":  "se/rd/serde",
+		"cargo2": "ca/rg/cargo2",
+	} {
+		if got := indexPath(name); got != want {
+			t.Errorf("indexPath(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
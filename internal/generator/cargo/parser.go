@@ -0,0 +1,355 @@
+package cargo
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// cratesIOIndex is how the index names dependencies from crates.io
+const cratesIOIndex = "https://github.com/rust-lang/crates.io-index"
+
+// indexEntry is one version of a crate in its index file
+type indexEntry struct {
+	Name        string              `json:"name"`
+	Vers        string              `json:"vers"`
+	Deps        []indexDep          `json:"deps"`
+	Cksum       string              `json:"cksum"`
+	Features    map[string][]string `json:"features"`
+	Features2   map[string][]string `json:"features2,omitempty"`
+	Yanked      bool                `json:"yanked"`
+	Links       *string             `json:"links"`
+	V           int                 `json:"v,omitempty"`
+	RustVersion string              `json:"rust_version,omitempty"`
+}
+
+// indexDep is a dependency of an index entry
+type indexDep struct {
+	Name            string   `json:"name"` // The name the crate depends on it as
+	Req             string   `json:"req"`
+	Features        []string `json:"features"`
+	Optional        bool     `json:"optional"`
+	DefaultFeatures bool     `json:"default_features"`
+	Target          *string  `json:"target"`
+	Kind            string   `json:"kind"`
+	Registry        *string  `json:"registry"`          // nil for this registry
+	Package         *string  `json:"package,omitempty"` // The crate's name when renamed
+}
+
+// ParsePackage parses a .crate file and extracts metadata
+func ParsePackage(path string) (*models.Package, error) {
+	// Calculate checksums
+	checksums, err := utils.CalculateChecksums(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate checksums: %w", err)
+	}
+
+	manifest, err := extractManifest(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract Cargo.toml: %w", err)
+	}
+
+	pkg, err := parseManifest(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Cargo.toml: %w", err)
+	}
+
+	// Set file information
+	pkg.Filename = path
+	pkg.Size = checksums.Size
+	pkg.MD5Sum = checksums.MD5
+	pkg.SHA1Sum = checksums.SHA1
+	pkg.SHA256Sum = checksums.SHA256
+	pkg.SHA512Sum = checksums.SHA512
+
+	return pkg, nil
+}
+
+// extractManifest reads <name>-<version>/Cargo.toml from a .crate, a
+// gzipped tar archive
+func extractManifest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return "", err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		// Not the Cargo.toml of vendored or nested crates
+		_, name, ok := strings.Cut(strings.TrimPrefix(header.Name, "./"), "/")
+		if ok && name == "Cargo.toml" {
+			data, err := io.ReadAll(tr)
+			return string(data), err
+		}
+	}
+
+	return "", fmt.Errorf("Cargo.toml not found in crate")
+}
+
+// parseManifest reads the package and dependencies of a normalized Cargo.toml
+func parseManifest(manifest string) (*models.Package, error) {
+	doc, err := parseTOML(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgTable, _ := doc["package"].(map[string]interface{})
+	name := tomlString(pkgTable, "name")
+	version := tomlString(pkgTable, "version")
+	if name == "" || version == "" {
+		return nil, fmt.Errorf("manifest lacks package name or version")
+	}
+
+	entry := &indexEntry{
+		Name:        name,
+		Vers:        version,
+		Deps:        []indexDep{},
+		Features:    map[string][]string{},
+		RustVersion: tomlString(pkgTable, "rust-version"),
+	}
+	if links := tomlString(pkgTable, "links"); links != "" {
+		entry.Links = &links
+	}
+
+	// Features using the dep: and weak ?/ syntaxes go to features2
+	if features, ok := doc["features"].(map[string]interface{}); ok {
+		for feature, value := range features {
+			list := tomlStrings(value)
+			newSyntax := false
+			for _, item := range list {
+				if strings.HasPrefix(item, "dep:") || strings.Contains(item, "?/") {
+					newSyntax = true
+				}
+			}
+			if newSyntax {
+				if entry.Features2 == nil {
+					entry.Features2 = map[string][]string{}
+				}
+				entry.Features2[feature] = list
+				entry.V = 2
+			} else {
+				entry.Features[feature] = list
+			}
+		}
+	}
+
+	entry.Deps = append(entry.Deps, manifestDeps(doc, nil)...)
+	if targets, ok := doc["target"].(map[string]interface{}); ok {
+		for target, table := range targets {
+			if table, ok := table.(map[string]interface{}); ok {
+				target := target
+				entry.Deps = append(entry.Deps, manifestDeps(table, &target)...)
+			}
+		}
+	}
+	sort.SliceStable(entry.Deps, func(i, j int) bool {
+		a, b := entry.Deps[i], entry.Deps[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Kind < b.Kind
+	})
+
+	pkg := &models.Package{
+		Name:        name,
+		Version:     version,
+		Description: strings.TrimSpace(tomlString(pkgTable, "description")),
+		Homepage:    tomlString(pkgTable, "homepage"),
+		License:     tomlString(pkgTable, "license"),
+		Metadata:    map[string]interface{}{"IndexEntry": entry},
+	}
+	if pkg.Homepage == "" {
+		pkg.Homepage = tomlString(pkgTable, "repository")
+	}
+	pkg.Maintainer = strings.Join(tomlStrings(pkgTable["authors"]), ", ")
+	for _, dep := range entry.Deps {
+		if dep.Kind == "normal" && !dep.Optional {
+			pkg.Dependencies = append(pkg.Dependencies, dep.Name)
+		}
+	}
+
+	return pkg, nil
+}
+
+// manifestDeps reads the dependency tables of a manifest or of one of its
+// target tables. Dependencies without a version (path or git only) are
+// not published
+func manifestDeps(table map[string]interface{}, target *string) []indexDep {
+	var deps []indexDep
+	for _, section := range []struct{ key, kind string }{
+		{"dependencies", "normal"},
+		{"dev-dependencies", "dev"},
+		{"dev_dependencies", "dev"},
+		{"build-dependencies", "build"},
+		{"build_dependencies", "build"},
+	} {
+		specs, ok := table[section.key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for name, spec := range specs {
+			dep := indexDep{
+				Name:            name,
+				Features:        []string{},
+				DefaultFeatures: true,
+				Target:          target,
+				Kind:            section.kind,
+			}
+			registry := cratesIOIndex
+
+			switch spec := spec.(type) {
+			case string:
+				dep.Req = spec
+			case map[string]interface{}:
+				dep.Req = tomlString(spec, "version")
+				dep.Features = append(dep.Features, tomlStrings(spec["features"])...)
+				dep.Optional, _ = spec["optional"].(bool)
+				for _, key := range []string{"default-features", "default_features"} {
+					if v, ok := spec[key].(bool); ok {
+						dep.DefaultFeatures = v
+					}
+				}
+				if pkgName := tomlString(spec, "package"); pkgName != "" {
+					dep.Package = &pkgName
+				}
+				if index := tomlString(spec, "registry-index"); index != "" {
+					registry = index
+				}
+			}
+			if dep.Req == "" {
+				continue
+			}
+			dep.Req = normalizeReq(dep.Req)
+			dep.Registry = &registry
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// normalizeReq writes a version requirement the way cargo publishes it,
+// bare versions being caret requirements ("1.0" is "^1.0")
+func normalizeReq(req string) string {
+	parts := strings.Split(req, ",")
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part != "" && part[0] >= '0' && part[0] <= '9' {
+			part = "^" + part
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, ", ")
+}
+
+func tomlString(table map[string]interface{}, key string) string {
+	s, _ := table[key].(string)
+	return s
+}
+
+func tomlStrings(value interface{}) []string {
+	list, _ := value.([]interface{})
+	strs := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
+
+// indexPath returns the path of a crate's index file: 1/<name>, 2/<name>,
+// 3/<first char>/<name> or <first two>/<next two>/<name>, lowercased
+func indexPath(name string) string {
+	name = strings.ToLower(name)
+	switch len(name) {
+	case 1:
+		return path.Join("1", name)
+	case 2:
+		return path.Join("2", name)
+	case 3:
+		return path.Join("3", name[:1], name)
+	default:
+		return path.Join(name[:2], name[2:4], name)
+	}
+}
+
+// crateFile returns the path of a crate version, relative to the output directory
+func crateFile(name, version string) string {
+	return path.Join(cratesDir, name, fmt.Sprintf("%s-%s.crate", name, version))
+}
+
+// parseIndexFile reads back the versions listed by an index file
+func parseIndexFile(file string) ([]models.Package, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var packages []models.Package
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var entry indexEntry
+		if err := json.Unmarshal(sc.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid index entry: %w", err)
+		}
+		if entry.Deps == nil {
+			entry.Deps = []indexDep{}
+		}
+		if entry.Features == nil {
+			entry.Features = map[string][]string{}
+		}
+
+		pkg := models.Package{
+			Name:      entry.Name,
+			Version:   entry.Vers,
+			Filename:  filepath.FromSlash(crateFile(entry.Name, entry.Vers)),
+			SHA256Sum: entry.Cksum,
+			Metadata:  map[string]interface{}{"IndexEntry": &entry},
+		}
+		for _, dep := range entry.Deps {
+			if dep.Kind == "normal" && !dep.Optional {
+				pkg.Dependencies = append(pkg.Dependencies, dep.Name)
+			}
+		}
+		if entry.Yanked {
+			pkg.Deprecation = &models.Deprecation{Message: "yanked"}
+		}
+		packages = append(packages, pkg)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return packages, nil
+}
@@ -0,0 +1,69 @@
+package generator
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+// DefaultConcurrency is the number of architectures or package types
+// generated at once when the configuration doesn't say
+var DefaultConcurrency = runtime.NumCPU()
+
+// Concurrency returns the number of architectures or package types config
+// generates at once
+func Concurrency(config *models.RepositoryConfig) int {
+	if config.Concurrency > 0 {
+		return config.Concurrency
+	}
+	return DefaultConcurrency
+}
+
+// ForEach calls fn with every index below n, up to concurrency calls at
+// once. The first failure cancels the context of the other calls and is
+// returned once they are done
+func ForEach(ctx context.Context, concurrency, n int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	concurrency = min(concurrency, n)
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := fn(ctx, i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,81 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+func TestForEachLimitsConcurrency(t *testing.T) {
+	var running, peak atomic.Int32
+	var mu sync.Mutex
+	seen := make(map[int]bool)
+
+	err := ForEach(context.Background(), 2, 10, func(ctx context.Context, i int) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		mu.Lock()
+		seen[i] = true
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEach failed: %v", err)
+	}
+	if len(seen) != 10 {
+		t.Errorf("Called for %d of 10 indexes", len(seen))
+	}
+	if peak.Load() > 2 {
+		t.Errorf("%d calls at once, want at most 2", peak.Load())
+	}
+}
+
+func TestForEachStopsAtFirstError(t *testing.T) {
+	failure := errors.New("disk full")
+	var calls atomic.Int32
+
+	err := ForEach(context.Background(), 1, 10, func(ctx context.Context, i int) error {
+		calls.Add(1)
+		if i == 2 {
+			return failure
+		}
+		return ctx.Err()
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("ForEach() = %v, want %v", err, failure)
+	}
+	if calls.Load() > 4 {
+		t.Errorf("Called %d times after the failure", calls.Load())
+	}
+}
+
+func TestForEachCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := ForEach(ctx, 4, 10, func(ctx context.Context, i int) error {
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ForEach() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	if got := Concurrency(&models.RepositoryConfig{Concurrency: 3}); got != 3 {
+		t.Errorf("Concurrency() = %d, want 3", got)
+	}
+	if got := Concurrency(&models.RepositoryConfig{}); got != DefaultConcurrency {
+		t.Errorf("Concurrency() = %d, want %d", got, DefaultConcurrency)
+	}
+}
@@ -0,0 +1,276 @@
+package conda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// noarchSubdir holds architecture independent packages, and must exist in
+// every channel
+const noarchSubdir = "noarch"
+
+// repodata is the repodata.json of a channel subdir
+type repodata struct {
+	Info            repodataInfo      `json:"info"`
+	Packages        map[string]record `json:"packages"`
+	PackagesConda   map[string]record `json:"packages.conda"`
+	Removed         []string          `json:"removed"`
+	RepodataVersion int               `json:"repodata_version"`
+}
+
+type repodataInfo struct {
+	Subdir string `json:"subdir"`
+}
+
+// Generator implements the generator.Generator interface for conda channels
+type Generator struct{}
+
+// NewGenerator creates a new conda generator
+func NewGenerator() generator.Generator {
+	return &Generator{}
+}
+
+// Generate copies the packages to their subdir and writes the
+// repodata.json of every subdir, with its zstd compressed variant
+func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfig, packages []models.Package) error {
+	logrus.Info("Generating conda channel...")
+
+	// conda reads noarch/repodata.json even when there are no noarch packages
+	bySubdir := map[string]*repodata{noarchSubdir: newRepodata(noarchSubdir)}
+	for i := range packages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pkg := &packages[i]
+		if err := publishPackage(ctx, config, pkg); err != nil {
+			return fmt.Errorf("failed to publish %s: %w", pkg.Name, err)
+		}
+
+		subdir := pkg.Architecture
+		data, ok := bySubdir[subdir]
+		if !ok {
+			data = newRepodata(subdir)
+			bySubdir[subdir] = data
+		}
+		fn := fileName(*pkg)
+		if strings.HasSuffix(fn, extConda) {
+			data.PackagesConda[fn] = recordFor(*pkg)
+		} else {
+			data.Packages[fn] = recordFor(*pkg)
+		}
+	}
+
+	// Subdirs left without packages keep an empty repodata.json, for
+	// clients configured with them
+	existing, err := repodataFiles(config.OutputDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range existing {
+		subdir := filepath.Base(filepath.Dir(file))
+		if _, ok := bySubdir[subdir]; !ok {
+			bySubdir[subdir] = newRepodata(subdir)
+			logrus.Infof("Emptied subdir %s, which has no packages left", subdir)
+		}
+	}
+
+	for subdir, data := range bySubdir {
+		if err := writeRepodata(filepath.Join(config.OutputDir, subdir), data); err != nil {
+			return fmt.Errorf("failed to write repodata of %s: %w", subdir, err)
+		}
+	}
+
+	logrus.Infof("Conda channel generated successfully (%d subdirs, %d packages)", len(bySubdir), len(packages))
+	return nil
+}
+
+// newRepodata returns the empty repodata of a subdir
+func newRepodata(subdir string) *repodata {
+	return &repodata{
+		Info:            repodataInfo{Subdir: subdir},
+		Packages:        map[string]record{},
+		PackagesConda:   map[string]record{},
+		Removed:         []string{},
+		RepodataVersion: 1,
+	}
+}
+
+// publishPackage copies a package to <subdir>/<name>-<version>-<build>
+func publishPackage(ctx context.Context, config *models.RepositoryConfig, pkg *models.Package) error {
+	rel := filepath.Join(pkg.Architecture, fileName(*pkg))
+	dstPath := filepath.Join(config.OutputDir, rel)
+
+	// Packages read back from repodata are published already, possibly
+	// only on remote storage
+	srcPath, finalDstPath, needsCopy, err := utils.ShouldCopyPackage(pkg, dstPath, config.OutputDir)
+	if err != nil {
+		return fmt.Errorf("package copy check failed: %w", err)
+	}
+
+	if needsCopy {
+		logrus.Debugf("Copying package: %s -> %s", srcPath, finalDstPath)
+
+		checksums, err := utils.CopyFileWithChecksums(ctx, srcPath, finalDstPath)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", srcPath, err)
+		}
+
+		if config.VerifyWrites {
+			if err := utils.VerifyCopy(finalDstPath, checksums); err != nil {
+				return fmt.Errorf("failed to verify %s: %w", srcPath, err)
+			}
+		}
+
+		pkg.Size = checksums.Size
+		pkg.MD5Sum = checksums.MD5
+		pkg.SHA1Sum = checksums.SHA1
+		pkg.SHA256Sum = checksums.SHA256
+	}
+
+	pkg.Filename = rel
+	return nil
+}
+
+// recordFor returns the repodata entry of a package, with the size and
+// checksums of the published file
+func recordFor(pkg models.Package) record {
+	rec := record{}
+	if known, ok := pkg.Metadata["Record"].(record); ok {
+		for key, value := range known {
+			rec[key] = value
+		}
+	}
+	rec["name"] = pkg.Name
+	rec["version"] = pkg.Version
+	rec["subdir"] = pkg.Architecture
+	rec["size"] = pkg.Size
+	rec["md5"] = pkg.MD5Sum
+	rec["sha256"] = pkg.SHA256Sum
+	if _, ok := rec["depends"]; !ok {
+		rec["depends"] = []string{}
+	}
+	return rec
+}
+
+// writeRepodata writes repodata.json and repodata.json.zst into dir
+func writeRepodata(dir string, data *repodata) error {
+	// encoding/json sorts map keys, so unchanged subdirs give the same files
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+	if err := utils.WriteFile(filepath.Join(dir, "repodata.json"), content, 0644); err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	zw, err := zstd.NewWriter(&b)
+	if err != nil {
+		return err
+	}
+	if _, err := zw.Write(content); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return utils.WriteFile(filepath.Join(dir, "repodata.json.zst"), b.Bytes(), 0644)
+}
+
+// repodataFiles lists the repodata.json of every subdir of a channel
+func repodataFiles(outputDir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(outputDir, "*", "repodata.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ValidatePackages checks if packages are conda packages
+func (g *Generator) ValidatePackages(packages []models.Package) error {
+	for _, pkg := range packages {
+		if !strings.HasSuffix(pkg.Filename, extConda) && !strings.HasSuffix(pkg.Filename, extTarBz2) {
+			return fmt.Errorf("package %s is not a conda package", pkg.Filename)
+		}
+	}
+	return nil
+}
+
+// GetSupportedType returns the package type this generator supports
+func (g *Generator) GetSupportedType() scanner.PackageType {
+	return scanner.TypeConda
+}
+
+// ParseExistingMetadata reads the repodata.json of every subdir
+func (g *Generator) ParseExistingMetadata(config *models.RepositoryConfig) ([]models.Package, error) {
+	files, err := repodataFiles(config.OutputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []models.Package
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.UseNumber()
+		var data struct {
+			Packages      map[string]record `json:"packages"`
+			PackagesConda map[string]record `json:"packages.conda"`
+		}
+		if err := dec.Decode(&data); err != nil {
+			logrus.Warnf("Skipping %s: %v", file, err)
+			continue
+		}
+
+		subdir := filepath.Base(filepath.Dir(file))
+		for _, records := range []map[string]record{data.Packages, data.PackagesConda} {
+			names := make([]string, 0, len(records))
+			for fn := range records {
+				names = append(names, fn)
+			}
+			sort.Strings(names)
+
+			for _, fn := range names {
+				rec := records[fn]
+				rec["subdir"] = subdir
+				pkg := recordPackage(rec)
+				pkg.Filename = filepath.Join(subdir, fn)
+				pkg.MD5Sum = rec.str("md5")
+				pkg.SHA256Sum = rec.str("sha256")
+				if size, ok := rec["size"].(json.Number); ok {
+					pkg.Size, _ = size.Int64()
+				}
+				packages = append(packages, *pkg)
+			}
+		}
+	}
+
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no existing conda channel found in %s", config.OutputDir)
+	}
+
+	return packages, nil
+}
+
+// PackageFiles returns the path of a package
+func (g *Generator) PackageFiles(config *models.RepositoryConfig, pkg models.Package) []string {
+	return []string{filepath.Join(config.OutputDir, pkg.Architecture, fileName(pkg))}
+}
@@ -0,0 +1,223 @@
+package conda
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ralt/repogen/internal/models"
+)
+
+// infoTar returns a tar archive of the info files of a package
+func infoTar(t *testing.T, index string) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for name, data := range map[string]string{
+		"info/index.json": index,
+		"info/about.json": `{"home": "https://example.com/hello", "license": "MIT", "summary": "Says hello"}`,
+		"info/paths.json": `{"paths": [], "paths_version": 1}`,
+	} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))})
+		tw.Write([]byte(data))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// writeConda writes a .conda package with the given info/index.json
+func writeConda(t *testing.T, dir, dist, index string) string {
+	t.Helper()
+
+	var info bytes.Buffer
+	zw, _ := zstd.NewWriter(&info)
+	zw.Write(infoTar(t, index))
+	zw.Close()
+
+	path := filepath.Join(dir, dist+".conda")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for name, data := range map[string][]byte{
+		"metadata.json":             []byte(`{"conda_pkg_format_version": 2}`),
+		"info-" + dist + ".tar.zst": info.Bytes(),
+		"pkg-" + dist + ".tar.zst":  info.Bytes(),
+	} {
+		fw, _ := w.Create(name)
+		fw.Write(data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const helloIndex = `{
+  "arch": "x86_64",
+  "build": "py312_0",
+  "build_number": 0,
+  "depends": ["python >=3.12,<3.13.0a0", "libzlib >=1.2.13"],
+  "constrains": ["hello-extras 1.2.*"],
+  "license": "MIT",
+  "name": "hello",
+  "platform": "linux",
+  "subdir": "linux-64",
+  "timestamp": 1714990089123,
+  "version": "1.2.0"
+}`
+
+func TestParsePackage(t *testing.T) {
+	pkg, err := ParsePackage(writeConda(t, t.TempDir(), "hello-1.2.0-py312_0", helloIndex))
+	if err != nil {
+		t.Fatalf("ParsePackage failed: %v", err)
+	}
+
+	if pkg.Name != "hello" || pkg.Version != "1.2.0" || pkg.Architecture != "linux-64" || pkg.Description != "Says hello" {
+		t.Errorf("unexpected package %+v", pkg)
+	}
+	if strings.Join(pkg.Dependencies, ",") != "python,libzlib" {
+		t.Errorf("unexpected dependencies %v", pkg.Dependencies)
+	}
+	if pkg.Metadata["Build"] != "py312_0" || pkg.Metadata["BuildTime"] != int64(1714990089) {
+		t.Errorf("unexpected metadata %v", pkg.Metadata)
+	}
+
+	// Packages built before index.json named the subdir
+	old := strings.Replace(helloIndex, `"subdir": "linux-64",`, "", 1)
+	pkg, err = ParsePackage(writeConda(t, t.TempDir(), "hello-1.2.0-py312_0", old))
+	if err != nil || pkg.Architecture != "linux-64" {
+		t.Errorf("unexpected subdir %q: %v", pkg.Architecture, err)
+	}
+}
+
+func TestParseTarBz2(t *testing.T) {
+	if _, err := exec.LookPath("bzip2"); err != nil {
+		t.Skip("bzip2 not available")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hello-1.2.0-py312_0.tar")
+	if err := os.WriteFile(path, infoTar(t, helloIndex), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("bzip2", path).CombinedOutput(); err != nil {
+		t.Fatalf("bzip2 failed: %v\n%s", err, out)
+	}
+
+	pkg, err := ParsePackage(path + ".bz2")
+	if err != nil {
+		t.Fatalf("ParsePackage failed: %v", err)
+	}
+	if pkg.Name != "hello" || fileName(*pkg) != "hello-1.2.0-py312_0.tar.bz2" {
+		t.Errorf("unexpected package %+v", pkg)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputDir := filepath.Join(tmpDir, "output")
+
+	var packages []models.Package
+	for _, build := range []string{"py311_0", "py312_0"} {
+		index := strings.Replace(helloIndex, "py312_0", build, 1)
+		pkg, err := ParsePackage(writeConda(t, tmpDir, "hello-1.2.0-"+build, index))
+		if err != nil {
+			t.Fatalf("ParsePackage failed: %v", err)
+		}
+		packages = append(packages, *pkg)
+	}
+	noarch := `{"name": "helper", "version": "0.1", "build": "pyhd8ed1ab_0", "build_number": 0, "noarch": "python", "subdir": "noarch", "depends": []}`
+	pkg, err := ParsePackage(writeConda(t, tmpDir, "helper-0.1-pyhd8ed1ab_0", noarch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packages = append(packages, *pkg)
+
+	gen := NewGenerator()
+	config := &models.RepositoryConfig{OutputDir: outputDir}
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	data := readRepodata(t, filepath.Join(outputDir, "linux-64"))
+	if data.Info.Subdir != "linux-64" || len(data.PackagesConda) != 2 || len(data.Packages) != 0 {
+		t.Fatalf("unexpected repodata %+v", data)
+	}
+	rec := data.PackagesConda["hello-1.2.0-py312_0.conda"]
+	if rec["sha256"] != packages[1].SHA256Sum || rec["timestamp"] != json.Number("1714990089123") || rec["constrains"] == nil {
+		t.Errorf("unexpected record %v", rec)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "linux-64", "hello-1.2.0-py311_0.conda")); err != nil {
+		t.Errorf("package not published: %v", err)
+	}
+
+	// The compressed variant holds the same repodata
+	compressed, err := os.ReadFile(filepath.Join(outputDir, "noarch", "repodata.json.zst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, _ := zstd.NewReader(nil)
+	decompressed, err := zr.DecodeAll(compressed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := os.ReadFile(filepath.Join(outputDir, "noarch", "repodata.json"))
+	if !bytes.Equal(decompressed, plain) {
+		t.Error("repodata.json.zst differs from repodata.json")
+	}
+
+	// Read back, then drop the linux-64 packages
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 3 {
+		t.Fatalf("expected 3 packages, got %d", len(existing))
+	}
+	var kept []models.Package
+	for _, pkg := range existing {
+		if pkg.Architecture == "noarch" {
+			kept = append(kept, pkg)
+		}
+	}
+	if err := gen.Generate(context.Background(), config, kept); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if data := readRepodata(t, filepath.Join(outputDir, "linux-64")); len(data.PackagesConda) != 0 {
+		t.Errorf("linux-64 still lists %v", data.PackagesConda)
+	}
+	if data := readRepodata(t, filepath.Join(outputDir, "noarch")); len(data.PackagesConda) != 1 {
+		t.Errorf("unexpected noarch repodata %v", data.PackagesConda)
+	}
+}
+
+func readRepodata(t *testing.T, dir string) repodata {
+	t.Helper()
+
+	content, err := os.ReadFile(filepath.Join(dir, "repodata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	var data repodata
+	if err := dec.Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
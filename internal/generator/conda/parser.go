@@ -0,0 +1,241 @@
+package conda
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Package formats: the legacy bzip2 tarball, and the zip of zstd
+// compressed info and pkg tarballs
+const (
+	extTarBz2 = ".tar.bz2"
+	extConda  = ".conda"
+)
+
+// record is the repodata entry of a package: its info/index.json, with the
+// size and checksums of the package file. Fields repogen doesn't model
+// (constrains, track_features, ...) are carried through
+type record map[string]interface{}
+
+// about is the info/about.json of a package
+type about struct {
+	Home        string `json:"home"`
+	License     string `json:"license"`
+	Summary     string `json:"summary"`
+	Description string `json:"description"`
+}
+
+// ParsePackage parses a .conda or .tar.bz2 conda package and extracts
+// metadata
+func ParsePackage(path string) (*models.Package, error) {
+	// Calculate checksums
+	checksums, err := utils.CalculateChecksums(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate checksums: %w", err)
+	}
+
+	var info map[string][]byte
+	if strings.HasSuffix(path, extConda) {
+		info, err = readCondaInfo(path)
+	} else {
+		info, err = readTarBz2Info(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	index, ok := info["info/index.json"]
+	if !ok {
+		return nil, fmt.Errorf("no info/index.json found in package")
+	}
+	rec, err := decodeRecord(index)
+	if err != nil {
+		return nil, fmt.Errorf("invalid info/index.json: %w", err)
+	}
+	if rec.str("name") == "" || rec.str("version") == "" || rec.str("build") == "" {
+		return nil, fmt.Errorf("info/index.json lacks a name, version or build")
+	}
+	if rec.str("subdir") == "" {
+		rec["subdir"] = defaultSubdir(rec)
+	}
+
+	rec["size"] = checksums.Size
+	rec["md5"] = checksums.MD5
+	rec["sha256"] = checksums.SHA256
+
+	pkg := recordPackage(rec)
+	pkg.Filename = path
+	pkg.Size = checksums.Size
+	pkg.MD5Sum = checksums.MD5
+	pkg.SHA1Sum = checksums.SHA1
+	pkg.SHA256Sum = checksums.SHA256
+
+	var a about
+	if data, ok := info["info/about.json"]; ok && json.Unmarshal(data, &a) == nil {
+		pkg.Homepage = a.Home
+		pkg.Description = a.Summary
+		if pkg.Description == "" {
+			pkg.Description = a.Description
+		}
+		if pkg.License == "" {
+			pkg.License = a.License
+		}
+	}
+
+	return pkg, nil
+}
+
+// readTarBz2Info reads the info/*.json files of a .tar.bz2 package
+func readTarBz2Info(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readInfoTar(bzip2.NewReader(f))
+}
+
+// readCondaInfo reads the info/*.json files of a .conda package, from its
+// info-<dist>.tar.zst member
+func readCondaInfo(path string) (map[string][]byte, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, "info-") || !strings.HasSuffix(f.Name, ".tar.zst") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		zd, err := zstd.NewReader(rc)
+		if err != nil {
+			return nil, err
+		}
+		defer zd.Close()
+		return readInfoTar(zd)
+	}
+
+	return nil, fmt.Errorf("no info tarball found in package")
+}
+
+// readInfoTar returns the info/*.json files of a tar stream
+func readInfoTar(r io.Reader) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read package: %w", err)
+		}
+
+		name := strings.TrimPrefix(header.Name, "./")
+		if path.Dir(name) != "info" || path.Ext(name) != ".json" {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = data
+	}
+	return files, nil
+}
+
+// decodeRecord decodes a record, keeping numbers as written
+func decodeRecord(data []byte) (record, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var rec record
+	if err := dec.Decode(&rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// str returns a string field of a record
+func (r record) str(key string) string {
+	s, _ := r[key].(string)
+	return s
+}
+
+// defaultSubdir returns the subdir of packages built before index.json
+// named it: noarch ones, or <platform>-<bits>
+func defaultSubdir(rec record) string {
+	if rec["noarch"] != nil || rec.str("platform") == "" {
+		return "noarch"
+	}
+	switch arch := rec.str("arch"); arch {
+	case "x86_64":
+		return rec.str("platform") + "-64"
+	case "x86":
+		return rec.str("platform") + "-32"
+	default:
+		return rec.str("platform") + "-" + arch
+	}
+}
+
+// recordPackage returns the package a record describes
+func recordPackage(rec record) *models.Package {
+	pkg := &models.Package{
+		Name:         rec.str("name"),
+		Version:      rec.str("version"),
+		Architecture: rec.str("subdir"),
+		License:      rec.str("license"),
+		Metadata: map[string]interface{}{
+			"Record": rec,
+			"Build":  rec.str("build"),
+		},
+	}
+
+	// Dependencies are match specs: "python >=3.8,<3.13"
+	if depends, ok := rec["depends"].([]interface{}); ok {
+		for _, dep := range depends {
+			if s, ok := dep.(string); ok && strings.TrimSpace(s) != "" {
+				pkg.Dependencies = append(pkg.Dependencies, strings.Fields(s)[0])
+			}
+		}
+	}
+
+	// Timestamps are in milliseconds
+	if ts, ok := rec["timestamp"].(json.Number); ok {
+		if ms, err := ts.Int64(); err == nil {
+			pkg.Metadata["BuildTime"] = ms / 1000
+		}
+	}
+
+	return pkg
+}
+
+// fileName returns the canonical file name of a package,
+// <name>-<version>-<build> with the extension of its format
+func fileName(pkg models.Package) string {
+	ext := extTarBz2
+	if strings.HasSuffix(pkg.Filename, extConda) {
+		ext = extConda
+	}
+	build, _ := pkg.Metadata["Build"].(string)
+	return fmt.Sprintf("%s-%s-%s%s", pkg.Name, pkg.Version, build, ext)
+}
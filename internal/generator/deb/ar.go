@@ -0,0 +1,100 @@
+package deb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// arMagic starts every ar archive
+	arMagic = "!<arch>\n"
+
+	// arHeaderSize is the size of the header of each ar member
+	arHeaderSize = 60
+)
+
+// arReader reads the members of an ar archive, the container of .deb
+// packages, one after the other without loading them in memory
+type arReader struct {
+	r         io.Reader
+	remaining *io.LimitedReader // Data of the current member
+	padding   int64             // Byte aligning the current member on 2 bytes
+}
+
+// newArReader checks the ar magic of r and returns a reader of its members
+func newArReader(r io.Reader) (*arReader, error) {
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, fmt.Errorf("failed to read ar magic: %w", err)
+	}
+	if string(magic) != arMagic {
+		return nil, fmt.Errorf("not an ar archive")
+	}
+	return &arReader{r: r}, nil
+}
+
+// Next skips the rest of the current member and returns the name and size
+// of the next one, whose data Read then returns. io.EOF ends the archive
+func (a *arReader) Next() (string, int64, error) {
+	if a.remaining != nil {
+		if err := a.skip(a.remaining.N + a.padding); err != nil {
+			return "", 0, err
+		}
+		a.remaining = nil
+	}
+
+	header := make([]byte, arHeaderSize)
+	if _, err := io.ReadFull(a.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return "", 0, fmt.Errorf("truncated ar header")
+		}
+		return "", 0, err
+	}
+	if !bytes.Equal(header[58:60], []byte("`\n")) {
+		return "", 0, fmt.Errorf("invalid ar header")
+	}
+
+	// GNU ar ends names with a slash
+	name := strings.TrimRight(strings.TrimSpace(string(header[0:16])), "/")
+	size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+	if err != nil || size < 0 {
+		return "", 0, fmt.Errorf("invalid size of ar member %q", name)
+	}
+
+	a.remaining = &io.LimitedReader{R: a.r, N: size}
+	a.padding = size % 2
+	return name, size, nil
+}
+
+// Read reads the data of the current member
+func (a *arReader) Read(p []byte) (int, error) {
+	if a.remaining == nil {
+		return 0, io.EOF
+	}
+	n, err := a.remaining.Read(p)
+	if err == io.EOF && a.remaining.N > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// skip discards n bytes, seeking past them when the archive is a file
+func (a *arReader) skip(n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if seeker, ok := a.r.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekCurrent)
+		return err
+	}
+	if _, err := io.CopyN(io.Discard, a.r, n); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package deb
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+// ValidateComponentMap checks that the component map only sends packages
+// to components of the repository
+func ValidateComponentMap(componentMap map[string]string, components []string) error {
+	for dir, component := range componentMap {
+		if !slices.Contains(components, component) {
+			return fmt.Errorf("component map sends %s to %s, which is not one of the components (%s)", dir, component, strings.Join(components, ", "))
+		}
+	}
+	return nil
+}
+
+// componentOf returns the component a package is published in: the one its
+// input subdirectory maps to or is named after, the one of its pool
+// directory for published packages, or else the first component
+func componentOf(config *models.RepositoryConfig, pkg models.Package) string {
+	if config.InputDir != "" {
+		if rel, err := filepath.Rel(config.InputDir, pkg.Filename); err == nil && !strings.HasPrefix(rel, "..") {
+			if dir, _, ok := strings.Cut(filepath.ToSlash(rel), "/"); ok {
+				if component, ok := config.ComponentMap[dir]; ok {
+					return component
+				}
+				if slices.Contains(config.Components, dir) {
+					return dir
+				}
+			}
+		}
+	}
+
+	// pool/<component>/<letter>/<name>/<file>
+	if parts := strings.Split(filepath.ToSlash(pkg.Filename), "/"); len(parts) == 5 && parts[0] == "pool" && slices.Contains(config.Components, parts[1]) {
+		return parts[1]
+	}
+
+	if len(config.Components) == 0 {
+		return "main"
+	}
+	return config.Components[0]
+}
@@ -3,13 +3,18 @@ package deb
 import (
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 
+	"github.com/ralt/repogen/internal/events"
 	"github.com/ralt/repogen/internal/generator"
 	"github.com/ralt/repogen/internal/models"
 	"github.com/ralt/repogen/internal/scanner"
 	"github.com/ralt/repogen/internal/signer"
+	"github.com/ralt/repogen/internal/translations"
 	"github.com/ralt/repogen/internal/utils"
 	"github.com/sirupsen/logrus"
 )
@@ -30,40 +35,150 @@ func NewGenerator(s signer.Signer) generator.Generator {
 func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfig, packages []models.Package) error {
 	logrus.Info("Generating Debian repository...")
 
-	// Group packages by architecture
-	archPackages := make(map[string][]models.Package)
-	for _, pkg := range packages {
-		arch := pkg.Architecture
-		if arch == "" {
-			arch = "amd64"
+	if config.DebLayout == LayoutFlat {
+		if err := g.generateFlat(ctx, config, packages); err != nil {
+			return err
 		}
-		archPackages[arch] = append(archPackages[arch], pkg)
+		return g.writeSourcesFile(config)
 	}
 
-	// Generate repository for each architecture
-	for _, arch := range config.Arches {
-		if err := g.generateForArch(ctx, config, arch, archPackages[arch]); err != nil {
-			return fmt.Errorf("failed to generate for %s: %w", arch, err)
+	// Suites share the pool, each with its own signed Release
+	codenames := Codenames(config.Codename)
+	if len(codenames) == 1 {
+		if err := g.generateSuite(ctx, config, packages); err != nil {
+			return err
 		}
+	} else {
+		for _, codename := range codenames {
+			suite := *config
+			suite.Codename = codename
+			suite.Suite = codename
+			if err := g.generateSuite(ctx, &suite, packages); err != nil {
+				return fmt.Errorf("failed to generate %s: %w", codename, err)
+			}
+		}
+	}
+	if err := g.writeSourcesFile(config); err != nil {
+		return err
+	}
+
+	logrus.Info("Debian repository generated successfully")
+	return nil
+}
+
+// generateSuite creates the dists/<codename> metadata of packages, and
+// copies them to the pool
+func (g *Generator) generateSuite(ctx context.Context, config *models.RepositoryConfig, packages []models.Package) error {
+	logrus.Infof("Generating suite: %s", config.Codename)
+	prefsPath := filepath.Join(config.OutputDir, "dists", config.Codename, "deprecated.pref")
+	dropStalePhasing(packages, prefsPath)
+
+	// Group packages by component and architecture
+	componentPackages := make(map[string][]models.Package)
+	for _, pkg := range packages {
+		component := componentOf(config, pkg)
+		componentPackages[component] = append(componentPackages[component], pkg)
+	}
+
+	// Generate repository for each component and architecture
+	languages := make(map[string][]string)
+	for _, component := range config.Components {
+		archPackages := make(map[string][]models.Package)
+		for _, pkg := range componentPackages[component] {
+			arch := pkg.Architecture
+			if arch == "" {
+				arch = "amd64"
+			}
+			archPackages[arch] = append(archPackages[arch], pkg)
+		}
+
+		err := generator.ForEach(ctx, generator.Concurrency(config), len(config.Arches), func(ctx context.Context, i int) error {
+			arch := config.Arches[i]
+			if err := g.generateForArch(ctx, config, component, arch, archPackages[arch]); err != nil {
+				return fmt.Errorf("failed to generate for %s/%s: %w", component, arch, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Write long and localized descriptions, shared by all architectures
+		languages[component] = descriptionLanguages(componentPackages[component])
+		if err := g.generateTranslations(config, component, languages[component], componentPackages[component]); err != nil {
+			return err
+		}
+	}
+
+	// Write pin hints for deprecated packages next to the suite metadata
+	if err := writeDeprecationPreferences(config, prefsPath, packages); err != nil {
+		return err
 	}
 
 	// Generate Release file at repository root
-	if err := g.generateRelease(config); err != nil {
+	if err := g.generateRelease(config, languages); err != nil {
 		return fmt.Errorf("failed to generate Release: %w", err)
 	}
 
-	logrus.Info("Debian repository generated successfully")
 	return nil
 }
 
-// generateForArch generates repository files for a specific architecture
-func (g *Generator) generateForArch(ctx context.Context, config *models.RepositoryConfig, arch string, packages []models.Package) error {
-	logrus.Infof("Generating for architecture: %s", arch)
+// writeDeprecationPreferences writes the apt pin hints of the deprecated
+// packages to path, or removes those of a previous run when none is
+// deprecated any more
+func writeDeprecationPreferences(config *models.RepositoryConfig, path string, packages []models.Package) error {
+	prefs := GenerateDeprecationPreferences(config, packages)
+	if prefs == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove deprecated.pref: %w", err)
+		}
+		return nil
+	}
+	if err := utils.WriteFile(path, prefs, 0644); err != nil {
+		return fmt.Errorf("failed to write deprecated.pref: %w", err)
+	}
+	logrus.Infof("Apt pin hints for deprecated packages written to: %s", path)
+	return nil
+}
+
+// dropStalePhasing removes the phasing of the packages no longer deprecated
+// that the pin hints of the previous run, at prefsPath, list: repogen phased
+// them itself. The phasing of other packages, e.g. adopted from an upstream
+// archive, is theirs and kept
+func dropStalePhasing(packages []models.Package, prefsPath string) {
+	data, err := os.ReadFile(prefsPath)
+	if err != nil {
+		return
+	}
+	pinned := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		if name, ok := strings.CutPrefix(line, "Package: "); ok {
+			pinned[strings.TrimSpace(name)] = true
+		}
+	}
+
+	for i, pkg := range packages {
+		if _, ok := pkg.Metadata[phasedField]; !ok || pkg.Deprecation != nil || !pinned[pkg.Name] {
+			continue
+		}
+		metadata := make(map[string]interface{}, len(pkg.Metadata))
+		for key, value := range pkg.Metadata {
+			if key != phasedField {
+				metadata[key] = value
+			}
+		}
+		packages[i].Metadata = metadata
+	}
+}
+
+// generateForArch generates repository files for a specific component and architecture
+func (g *Generator) generateForArch(ctx context.Context, config *models.RepositoryConfig, component, arch string, packages []models.Package) error {
+	logrus.Infof("Generating for %s architecture: %s", component, arch)
 
 	// Create directory structure
-	// dists/{codename}/main/binary-{arch}/
-	distsDir := filepath.Join(config.OutputDir, "dists", config.Codename, "main", fmt.Sprintf("binary-%s", arch))
-	poolDir := filepath.Join(config.OutputDir, "pool", "main")
+	// dists/{codename}/{component}/binary-{arch}/
+	distsDir := filepath.Join(config.OutputDir, "dists", config.Codename, component, fmt.Sprintf("binary-%s", arch))
+	poolDir := filepath.Join(config.OutputDir, "pool", component)
 
 	if err := utils.EnsureDir(distsDir); err != nil {
 		return err
@@ -74,6 +189,9 @@ func (g *Generator) generateForArch(ctx context.Context, config *models.Reposito
 
 	// Copy packages to pool and update filenames
 	for i := range packages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		pkg := &packages[i]
 
 		// Determine pool subdirectory (first letter of package name)
@@ -84,78 +202,170 @@ func (g *Generator) generateForArch(ctx context.Context, config *models.Reposito
 			firstLetter = "0" // Use "0" for packages starting with numbers/special chars
 		}
 
-		// Create package directory: pool/main/{letter}/{name}/
+		// Create package directory: pool/{component}/{letter}/{name}/
 		pkgDir := filepath.Join(poolDir, firstLetter, pkg.Name)
 		if err := utils.EnsureDir(pkgDir); err != nil {
 			return err
 		}
 
-		// Determine destination path
-		dstPath := filepath.Join(pkgDir, filepath.Base(pkg.Filename))
-
-		// Check if package needs to be copied
-		srcPath, finalDstPath, needsCopy, err := utils.ShouldCopyPackage(pkg, dstPath, config.OutputDir)
-		if err != nil {
-			return fmt.Errorf("package copy check failed for %s: %w", pkg.Name, err)
+		if err := publishPackage(ctx, config, pkg, pkgDir); err != nil {
+			return err
 		}
+	}
 
-		if needsCopy {
-			logrus.Debugf("Copying package: %s -> %s", srcPath, finalDstPath)
+	if err := writePackagesFiles(config, distsDir, packages, true); err != nil {
+		return err
+	}
 
-			// Copy package file
-			if err := utils.CopyFile(srcPath, finalDstPath); err != nil {
-				return fmt.Errorf("failed to copy %s: %w", srcPath, err)
-			}
+	logrus.Infof("Generated Packages files for %s/%s (%d packages)", component, arch, len(packages))
+	return nil
+}
 
-			// Recalculate checksums on the copied file to ensure accuracy
-			checksums, err := utils.CalculateChecksums(finalDstPath)
-			if err != nil {
-				return fmt.Errorf("failed to calculate checksums for %s: %w", filepath.Base(pkg.Filename), err)
-			}
-			pkg.Size = checksums.Size
-			pkg.MD5Sum = checksums.MD5
-			pkg.SHA1Sum = checksums.SHA1
-			pkg.SHA256Sum = checksums.SHA256
-		} else {
-			logrus.Debugf("Skipping copy for package: %s", pkg.Name)
-		}
+// publishPackage copies a package into dir, and makes its filename relative
+// to the repository root
+func publishPackage(ctx context.Context, config *models.RepositoryConfig, pkg *models.Package, dir string) error {
+	// Determine destination path
+	dstPath := filepath.Join(dir, filepath.Base(pkg.Filename))
+
+	// Check if package needs to be copied
+	srcPath, finalDstPath, needsCopy, err := utils.ShouldCopyPackage(pkg, dstPath, config.OutputDir)
+	if err != nil {
+		return fmt.Errorf("package copy check failed for %s: %w", pkg.Name, err)
+	}
 
-		// Update filename to be relative to repository root
-		relPath, err := filepath.Rel(config.OutputDir, finalDstPath)
+	if needsCopy {
+		logrus.Debugf("Copying package: %s -> %s", srcPath, finalDstPath)
+
+		// Copy package file, checksumming it on the way
+		checksums, err := utils.CopyFileWithChecksums(ctx, srcPath, finalDstPath)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to copy %s: %w", srcPath, err)
 		}
-		pkg.Filename = relPath
+
+		if config.VerifyWrites {
+			if err := utils.VerifyCopy(finalDstPath, checksums); err != nil {
+				return fmt.Errorf("failed to verify %s: %w", srcPath, err)
+			}
+		}
+		pkg.Size = checksums.Size
+		pkg.MD5Sum = checksums.MD5
+		pkg.SHA1Sum = checksums.SHA1
+		pkg.SHA256Sum = checksums.SHA256
+	} else {
+		logrus.Debugf("Skipping copy for package: %s", pkg.Name)
+	}
+
+	// Update filename to be relative to repository root
+	relPath, err := filepath.Rel(config.OutputDir, finalDstPath)
+	if err != nil {
+		return err
 	}
+	pkg.Filename = relPath
+	return nil
+}
 
+// writePackagesFiles writes the Packages indexes of packages into dir, split
+// leaving long descriptions to Translation-en
+func writePackagesFiles(config *models.RepositoryConfig, dir string, packages []models.Package, split bool) error {
 	// Generate Packages file
-	packagesData, err := GeneratePackagesFile(packages)
+	packagesData, err := generatePackagesFile(packages, split)
 	if err != nil {
 		return fmt.Errorf("failed to generate Packages file: %w", err)
 	}
 
-	packagesPath := filepath.Join(distsDir, "Packages")
+	packagesPath := filepath.Join(dir, "Packages")
 	if err := utils.WriteFile(packagesPath, packagesData, 0644); err != nil {
 		return fmt.Errorf("failed to write Packages: %w", err)
 	}
 
-	// Compress Packages file
-	packagesGz, err := utils.GzipCompress(packagesData)
+	// Compress Packages file, removing the copies of another compression
+	algorithm := packagesCompression(config)
+	for _, suffix := range []string{".gz", ".xz", ".zst"} {
+		if suffix == utils.CompressionSuffix(algorithm) {
+			continue
+		}
+		if err := os.Remove(packagesPath + suffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove Packages%s: %w", suffix, err)
+		}
+	}
+	if algorithm == utils.CompressionNone {
+		return nil
+	}
+
+	compressed, err := utils.Compress(algorithm, config.CompressionLevel, packagesData)
 	if err != nil {
 		return fmt.Errorf("failed to compress Packages: %w", err)
 	}
 
-	packagesGzPath := filepath.Join(distsDir, "Packages.gz")
-	if err := utils.WriteFile(packagesGzPath, packagesGz, 0644); err != nil {
-		return fmt.Errorf("failed to write Packages.gz: %w", err)
+	compressedPath := packagesPath + utils.CompressionSuffix(algorithm)
+	if err := utils.WriteFile(compressedPath, compressed, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(compressedPath), err)
+	}
+
+	return nil
+}
+
+// packagesCompression returns the compression of Packages, gzip by default
+// as every apt reads it
+func packagesCompression(config *models.RepositoryConfig) string {
+	if config.DBCompression == "" {
+		return utils.CompressionGzip
+	}
+	return config.DBCompression
+}
+
+// packagesIndexes returns the names of the Packages indexes written
+func packagesIndexes(config *models.RepositoryConfig) []string {
+	indexes := []string{"Packages"}
+	if algorithm := packagesCompression(config); algorithm != utils.CompressionNone {
+		indexes = append(indexes, "Packages"+utils.CompressionSuffix(algorithm))
+	}
+	return indexes
+}
+
+// descriptionLanguages returns the languages of the Translation indexes of
+// packages: English for the long descriptions, and each translation
+func descriptionLanguages(packages []models.Package) []string {
+	languages := translations.Languages(packages)
+	for _, pkg := range packages {
+		if pkg.Description != "" && !slices.Contains(languages, "en") {
+			languages = append(languages, "en")
+			sort.Strings(languages)
+			break
+		}
+	}
+	return languages
+}
+
+// generateTranslations writes <component>/i18n/Translation-<lang> for each language
+func (g *Generator) generateTranslations(config *models.RepositoryConfig, component string, languages []string, packages []models.Package) error {
+	i18nDir := filepath.Join(config.OutputDir, "dists", config.Codename, component, "i18n")
+
+	for _, lang := range languages {
+		data := GenerateTranslationFile(lang, packages)
+
+		translationPath := filepath.Join(i18nDir, "Translation-"+lang)
+		if err := utils.WriteFile(translationPath, data, 0644); err != nil {
+			return fmt.Errorf("failed to write Translation-%s: %w", lang, err)
+		}
+
+		dataGz, err := utils.GzipCompress(data)
+		if err != nil {
+			return fmt.Errorf("failed to compress Translation-%s: %w", lang, err)
+		}
+		if err := utils.WriteFile(translationPath+".gz", dataGz, 0644); err != nil {
+			return fmt.Errorf("failed to write Translation-%s.gz: %w", lang, err)
+		}
 	}
 
-	logrus.Infof("Generated Packages files for %s (%d packages)", arch, len(packages))
+	if len(languages) > 0 {
+		logrus.Infof("Generated %s translated descriptions for: %s", component, strings.Join(languages, ", "))
+	}
 	return nil
 }
 
 // generateRelease generates the Release, InRelease, and Release.gpg files
-func (g *Generator) generateRelease(config *models.RepositoryConfig) error {
+func (g *Generator) generateRelease(config *models.RepositoryConfig, languages map[string][]string) error {
 	logrus.Info("Generating Release file...")
 
 	distsDir := filepath.Join(config.OutputDir, "dists", config.Codename)
@@ -166,21 +376,37 @@ func (g *Generator) generateRelease(config *models.RepositoryConfig) error {
 		for _, comp := range config.Components {
 			binDir := fmt.Sprintf("%s/binary-%s", comp, arch)
 
-			// Add Packages
-			packagesPath := filepath.Join(binDir, "Packages")
-			metadataFiles = append(metadataFiles, packagesPath)
+			// Add Packages and its compressed copy
+			for _, index := range packagesIndexes(config) {
+				metadataFiles = append(metadataFiles, filepath.Join(binDir, index))
+			}
+		}
+	}
 
-			// Add Packages.gz
-			packagesGzPath := filepath.Join(binDir, "Packages.gz")
-			metadataFiles = append(metadataFiles, packagesGzPath)
+	// Add translated descriptions
+	for _, comp := range config.Components {
+		for _, lang := range languages[comp] {
+			translationPath := filepath.Join(comp, "i18n", "Translation-"+lang)
+			metadataFiles = append(metadataFiles, translationPath, translationPath+".gz")
 		}
 	}
 
+	return g.writeRelease(config, distsDir, metadataFiles)
+}
+
+// writeRelease writes the Release of the metadataFiles of dir, with its
+// InRelease and Release.gpg signatures
+func (g *Generator) writeRelease(config *models.RepositoryConfig, distsDir string, metadataFiles []string) error {
 	// Calculate checksums for metadata files
 	fileInfos, err := CalculateReleaseFileInfos(distsDir, metadataFiles)
 	if err != nil {
 		return err
 	}
+	if config.AcquireByHash {
+		if err := writeByHash(distsDir, fileInfos); err != nil {
+			return err
+		}
+	}
 
 	// Generate Release file
 	releaseData, err := GenerateReleaseFile(config, fileInfos)
@@ -200,22 +426,36 @@ func (g *Generator) generateRelease(config *models.RepositoryConfig) error {
 		if err != nil {
 			return fmt.Errorf("failed to sign InRelease: %w", err)
 		}
+		// Catch key or algorithm misconfigurations before apt does
+		if err := signer.CheckCleartext(g.signer, inReleaseData, releaseData); err != nil {
+			return fmt.Errorf("InRelease self-check failed: %w", err)
+		}
 
 		inReleasePath := filepath.Join(distsDir, "InRelease")
 		if err := utils.WriteFile(inReleasePath, inReleaseData, 0644); err != nil {
 			return fmt.Errorf("failed to write InRelease: %w", err)
 		}
+		events.Emit(events.Signed, events.Fields{"path": inReleasePath, "kind": "cleartext"})
 
 		// Create Release.gpg (detached signature)
 		releaseGpg, err := g.signer.SignDetached(releaseData)
 		if err != nil {
 			return fmt.Errorf("failed to create Release.gpg: %w", err)
 		}
+		if err := signer.CheckDetached(g.signer, releaseGpg, releaseData); err != nil {
+			return fmt.Errorf("Release.gpg self-check failed: %w", err)
+		}
 
 		releaseGpgPath := filepath.Join(distsDir, "Release.gpg")
 		if err := utils.WriteFile(releaseGpgPath, releaseGpg, 0644); err != nil {
 			return fmt.Errorf("failed to write Release.gpg: %w", err)
 		}
+		events.Emit(events.Signed, events.Fields{"path": releaseGpgPath, "kind": "detached"})
+
+		// apt's Signed-By wants the binary keyring, published with the other encodings
+		if err := signer.PublishPublicKey(g.signer, config.OutputDir, utils.RepoSlug(config), utils.Timestamp(config)); err != nil {
+			return err
+		}
 
 		logrus.Info("Release file signed successfully")
 	} else {
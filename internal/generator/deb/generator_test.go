@@ -3,11 +3,21 @@ package deb
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/signer"
+	"github.com/ralt/repogen/internal/utils"
 )
 
 func TestGenerateReleaseUnsigned(t *testing.T) {
@@ -224,3 +234,946 @@ func TestIncrementalModeCopiesNewPackages(t *testing.T) {
 	t.Logf("Incremental mode test passed!")
 	t.Logf("Packages file content:\n%s", packagesStr)
 }
+
+func TestPackagesRoundTripPreservesFields(t *testing.T) {
+	input := `Package: pkga
+Version: 1.0
+Architecture: amd64
+Filename: pool/main/p/pkga/pkga_1.0_amd64.deb
+Size: 18
+MD5sum: abc123
+SHA1: def456
+SHA256: ghi789
+SHA512: jkl012
+Description: Package A
+ Longer description of package A.
+ .
+ Second paragraph.
+Depends: libc6 (>= 2.34),
+ libfoo1
+Section: utils
+X-Custom-Field: kept
+Built-Using: gcc-12 (= 12.2.0-14)
+
+`
+
+	packages, err := parsePackagesReader(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Failed to parse Packages: %v", err)
+	}
+	if len(packages) != 1 {
+		t.Fatalf("Expected 1 package, got %d", len(packages))
+	}
+
+	if len(packages[0].Dependencies) != 2 {
+		t.Errorf("Expected folded Depends to yield 2 dependencies, got %v", packages[0].Dependencies)
+	}
+
+	output, err := GeneratePackagesFile(packages)
+	if err != nil {
+		t.Fatalf("Failed to generate Packages: %v", err)
+	}
+
+	for _, expected := range []string{
+		"Description: Package A\n Longer description of package A.\n .\n Second paragraph.\n",
+		"Section: utils\n",
+		"X-Custom-Field: kept\n",
+		"Built-Using: gcc-12 (= 12.2.0-14)\n",
+	} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("Regenerated Packages missing %q:\n%s", expected, output)
+		}
+	}
+}
+
+func TestDeprecatedPackagesEmitPinHints(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	pkgPath := filepath.Join(tmpDir, "oldtool_1.0_amd64.deb")
+	os.WriteFile(pkgPath, []byte("fake deb package"), 0644)
+
+	gen := NewGenerator(nil)
+	config := &models.RepositoryConfig{
+		OutputDir:  filepath.Join(tmpDir, "output"),
+		Codename:   "testing",
+		Suite:      "testing",
+		Origin:     "Test",
+		Label:      "Test",
+		Components: []string{"main"},
+		Arches:     []string{"amd64"},
+	}
+
+	packages := []models.Package{
+		{
+			Name:         "oldtool",
+			Version:      "1.0",
+			Architecture: "amd64",
+			Filename:     pkgPath,
+			Deprecation:  &models.Deprecation{ReplacedBy: "newtool"},
+		},
+	}
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	packagesContent, _ := os.ReadFile(filepath.Join(config.OutputDir, "dists", "testing", "main", "binary-amd64", "Packages"))
+	if !bytes.Contains(packagesContent, []byte("Phased-Update-Percentage: 0\n")) {
+		t.Errorf("Deprecated package should have Phased-Update-Percentage: 0:\n%s", packagesContent)
+	}
+
+	prefs, err := os.ReadFile(filepath.Join(config.OutputDir, "dists", "testing", "deprecated.pref"))
+	if err != nil {
+		t.Fatalf("deprecated.pref not written: %v", err)
+	}
+	for _, expected := range []string{"Package: oldtool\n", "Pin: release o=Test,n=testing\n", "Pin-Priority: -1\n", "replaced by newtool"} {
+		if !bytes.Contains(prefs, []byte(expected)) {
+			t.Errorf("deprecated.pref missing %q:\n%s", expected, prefs)
+		}
+	}
+
+	// Once no longer deprecated, the phasing parsed back from the published
+	// index isn't carried over
+	packages[0].Deprecation = nil
+	packages[0].Metadata = map[string]interface{}{"Phased-Update-Percentage": "0"}
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	packagesContent, _ = os.ReadFile(filepath.Join(config.OutputDir, "dists", "testing", "main", "binary-amd64", "Packages"))
+	if bytes.Contains(packagesContent, []byte("Phased-Update-Percentage")) {
+		t.Errorf("Package no longer deprecated is still phased:\n%s", packagesContent)
+	}
+
+	// Nor are the pin hints
+	if _, err := os.Stat(filepath.Join(config.OutputDir, "dists", "testing", "deprecated.pref")); !os.IsNotExist(err) {
+		t.Errorf("Stale deprecated.pref was kept: %v", err)
+	}
+}
+
+func TestPhasingOfAdoptedPackagesIsKept(t *testing.T) {
+	tmpDir := t.TempDir()
+	gen := NewGenerator(nil)
+
+	for _, layout := range []string{LayoutPool, LayoutFlat} {
+		t.Run(layout, func(t *testing.T) {
+			pkgPath := filepath.Join(tmpDir, layout+"_1.0_amd64.deb")
+			os.WriteFile(pkgPath, []byte("fake deb package"), 0644)
+			config := &models.RepositoryConfig{
+				OutputDir:  filepath.Join(tmpDir, layout),
+				Codename:   "testing",
+				Suite:      "testing",
+				Origin:     "Test",
+				Label:      "Test",
+				Components: []string{"main"},
+				Arches:     []string{"amd64"},
+				DebLayout:  layout,
+			}
+			packagesPath := filepath.Join(config.OutputDir, "dists", "testing", "main", "binary-amd64", "Packages")
+			prefsPath := filepath.Join(config.OutputDir, "dists", "testing", "deprecated.pref")
+			if layout == LayoutFlat {
+				packagesPath = filepath.Join(config.OutputDir, "Packages")
+				prefsPath = filepath.Join(config.OutputDir, "deprecated.pref")
+			}
+
+			// Phased upstream, and deprecated here
+			packages := []models.Package{
+				{Name: "phased", Version: "1.0", Architecture: "amd64", Filename: pkgPath, Metadata: map[string]interface{}{"Phased-Update-Percentage": "30"}},
+				{Name: "oldtool", Version: "1.0", Architecture: "amd64", Filename: pkgPath, Deprecation: &models.Deprecation{}},
+			}
+			if err := gen.Generate(context.Background(), config, packages); err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			content, _ := os.ReadFile(packagesPath)
+			if !bytes.Contains(content, []byte("Package: phased\n")) || !bytes.Contains(content, []byte("Phased-Update-Percentage: 30\n")) {
+				t.Errorf("Upstream phasing was dropped:\n%s", content)
+			}
+			if _, err := os.Stat(prefsPath); err != nil {
+				t.Fatalf("deprecated.pref not written: %v", err)
+			}
+
+			// Parsed back once oldtool is no longer deprecated, only repogen's phasing goes
+			packages = []models.Package{
+				{Name: "phased", Version: "1.0", Architecture: "amd64", Filename: pkgPath, Metadata: map[string]interface{}{"Phased-Update-Percentage": "30"}},
+				{Name: "oldtool", Version: "1.0", Architecture: "amd64", Filename: pkgPath, Metadata: map[string]interface{}{"Phased-Update-Percentage": "0"}},
+			}
+			if err := gen.Generate(context.Background(), config, packages); err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			content, _ = os.ReadFile(packagesPath)
+			if !bytes.Contains(content, []byte("Phased-Update-Percentage: 30\n")) || bytes.Contains(content, []byte("Phased-Update-Percentage: 0\n")) {
+				t.Errorf("Unexpected phasing:\n%s", content)
+			}
+			if _, err := os.Stat(prefsPath); !os.IsNotExist(err) {
+				t.Errorf("Stale deprecated.pref was kept: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyWritesAcceptsIntactCopies(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	srcPath := filepath.Join(tmpDir, "pkga_1.0_amd64.deb")
+	os.WriteFile(srcPath, []byte("fake deb package A"), 0644)
+
+	config := &models.RepositoryConfig{
+		OutputDir:    filepath.Join(tmpDir, "output"),
+		Codename:     "stable",
+		Components:   []string{"main"},
+		Arches:       []string{"amd64"},
+		VerifyWrites: true,
+	}
+	packages := []models.Package{
+		{Name: "pkga", Version: "1.0", Architecture: "amd64", Filename: srcPath},
+	}
+
+	gen := NewGenerator(nil)
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate with verified writes failed: %v", err)
+	}
+
+	srcChecksums, _ := utils.CalculateChecksums(srcPath)
+	packagesFile, err := os.ReadFile(filepath.Join(config.OutputDir, "dists", "stable", "main", "binary-amd64", "Packages"))
+	if err != nil {
+		t.Fatalf("Failed to read Packages: %v", err)
+	}
+	if !strings.Contains(string(packagesFile), "SHA256: "+srcChecksums.SHA256) {
+		t.Errorf("Packages does not reference the source digest:\n%s", packagesFile)
+	}
+
+	// A destination that differs from its source must be rejected
+	corrupted := filepath.Join(tmpDir, "corrupted.deb")
+	os.WriteFile(corrupted, []byte("fake deb package B"), 0644)
+	if err := utils.VerifyCopy(corrupted, srcChecksums); err == nil {
+		t.Errorf("Expected checksum mismatch to be reported")
+	}
+}
+
+func TestTranslationsGenerateI18nIndexes(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	srcPath := filepath.Join(tmpDir, "pkga_1.0_amd64.deb")
+	os.WriteFile(srcPath, []byte("fake deb package A"), 0644)
+
+	config := &models.RepositoryConfig{
+		OutputDir:  filepath.Join(tmpDir, "output"),
+		Codename:   "stable",
+		Components: []string{"main"},
+		Arches:     []string{"amd64"},
+	}
+	packages := []models.Package{
+		{
+			Name:         "pkga",
+			Version:      "1.0",
+			Architecture: "amd64",
+			Filename:     srcPath,
+			Description:  "Package A\nLong description.\n\nSecond paragraph.",
+			Translations: map[string]string{"de": "Paket A\nLange Beschreibung."},
+		},
+	}
+
+	gen := NewGenerator(nil)
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	translation, err := os.ReadFile(filepath.Join(config.OutputDir, "dists", "stable", "main", "i18n", "Translation-de"))
+	if err != nil {
+		t.Fatalf("Translation-de not written: %v", err)
+	}
+
+	// apt hashes the description exactly as it appears in Packages
+	expectedMD5 := md5.Sum([]byte("Package A\n Long description.\n .\n Second paragraph.\n"))
+	expected := fmt.Sprintf("Package: pkga\nDescription-md5: %x\nDescription-de: Paket A\n Lange Beschreibung.\n\n", expectedMD5)
+	if string(translation) != expected {
+		t.Errorf("Unexpected Translation-de:\n%s\nwant:\n%s", translation, expected)
+	}
+
+	release, _ := os.ReadFile(filepath.Join(config.OutputDir, "dists", "stable", "Release"))
+	if !strings.Contains(string(release), "main/i18n/Translation-de.gz") {
+		t.Errorf("Release does not reference Translation-de.gz:\n%s", release)
+	}
+}
+
+func TestCopyResumesInterruptedPackageCopy(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1<<20)
+	srcPath := filepath.Join(tmpDir, "big_1.0_amd64.deb")
+	os.WriteFile(srcPath, content, 0644)
+
+	// Simulate an interrupted copy that wrote the first 5 MiB, plus a corrupted tail
+	dstPath := filepath.Join(tmpDir, "pool", "big_1.0_amd64.deb")
+	os.MkdirAll(filepath.Dir(dstPath), 0755)
+	partial := append(append([]byte{}, content[:5<<20]...), []byte("garbage")...)
+	os.WriteFile(dstPath+".part", partial, 0644)
+
+	checksums, err := utils.CopyFileWithChecksums(context.Background(), srcPath, dstPath)
+	if err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+
+	copied, err := os.ReadFile(dstPath)
+	if err != nil {
+		t.Fatalf("Destination missing: %v", err)
+	}
+	if !bytes.Equal(copied, content) {
+		t.Errorf("Resumed copy differs from source")
+	}
+	if _, err := os.Stat(dstPath + ".part"); !os.IsNotExist(err) {
+		t.Errorf("Partial file should be renamed away")
+	}
+
+	expected, _ := utils.CalculateChecksums(srcPath)
+	if *checksums != *expected {
+		t.Errorf("Checksums of resumed copy %+v, want %+v", checksums, expected)
+	}
+}
+
+// newTestSigner creates a GPG signer with a throwaway key, and the keyring
+// verifying its signatures
+func newTestSigner(t *testing.T) (*signer.GPGSigner, openpgp.EntityList) {
+	return newTestSignerWithConfig(t, nil)
+}
+
+// newTestSignerWithConfig is newTestSigner, the key being generated as
+// described by cfg
+func newTestSignerWithConfig(t *testing.T, cfg *packet.Config) (*signer.GPGSigner, openpgp.EntityList) {
+	t.Helper()
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not available")
+	}
+
+	entity, err := openpgp.NewEntity("Repogen Test", "", "test@repogen.local", cfg)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	var keyBuf bytes.Buffer
+	w, _ := armor.Encode(&keyBuf, openpgp.PrivateKeyType, nil)
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatalf("Failed to serialize key: %v", err)
+	}
+	w.Close()
+
+	keyPath := filepath.Join(t.TempDir(), "key.asc")
+	os.WriteFile(keyPath, keyBuf.Bytes(), 0600)
+
+	gpgSigner, err := signer.NewGPGSigner(keyPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return gpgSigner, openpgp.EntityList{entity}
+}
+
+func TestSignedInReleaseVerifies(t *testing.T) {
+	gpgSigner, keyring := newTestSigner(t)
+	tmpDir := t.TempDir()
+
+	config := &models.RepositoryConfig{
+		OutputDir:  tmpDir,
+		Codename:   "testing",
+		Suite:      "testing",
+		Origin:     "Test",
+		Label:      "Test",
+		Components: []string{"main"},
+		Arches:     []string{"amd64"},
+	}
+	if err := NewGenerator(gpgSigner).Generate(context.Background(), config, []models.Package{}); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	distsDir := filepath.Join(tmpDir, "dists", "testing")
+	releaseData, _ := os.ReadFile(filepath.Join(distsDir, "Release"))
+	inReleaseData, _ := os.ReadFile(filepath.Join(distsDir, "InRelease"))
+
+	// apt reads the Release fields out of InRelease, so they must match Release exactly
+	message, err := signer.VerifyCleartext(inReleaseData, keyring)
+	if err != nil {
+		t.Fatalf("InRelease does not verify: %v", err)
+	}
+	if !bytes.Equal(message, releaseData) {
+		t.Errorf("InRelease signed text differs from Release:\n%s", message)
+	}
+
+	releaseGpg, _ := os.ReadFile(filepath.Join(distsDir, "Release.gpg"))
+	if _, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(releaseData), bytes.NewReader(releaseGpg), nil); err != nil {
+		t.Errorf("Release.gpg does not verify: %v", err)
+	}
+
+	// Tampering anywhere must be caught
+	tampered := bytes.Replace(inReleaseData, []byte("Origin: Test"), []byte("Origin: Evil"), 1)
+	if _, err := signer.VerifyCleartext(tampered, keyring); err == nil {
+		t.Errorf("Tampered InRelease verified")
+	}
+	if _, err := signer.VerifyCleartext(append([]byte("Origin: Evil\n"), inReleaseData...), keyring); err == nil {
+		t.Errorf("InRelease with data before the signed message verified")
+	}
+	if _, err := signer.VerifyCleartext(append(inReleaseData, []byte("Origin: Evil\n")...), keyring); err == nil {
+		t.Errorf("InRelease with data after the signature verified")
+	}
+}
+
+func TestCleartextSignatureCanonicalization(t *testing.T) {
+	gpgSigner, keyring := newTestSigner(t)
+
+	tests := []struct {
+		name    string
+		message string
+	}{
+		{"trailing whitespace", "Origin: Test  \nLabel: Test\t\n"},
+		{"dash lines", "-----BEGIN PGP SIGNATURE-----\n- already escaped\n--\n"},
+		{"blank lines", "Origin: Test\n\n\nLabel: Test\n"},
+		{"from lines", "From the start\nOrigin: Test\n"},
+		{"no final newline", "Origin: Test"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed, err := gpgSigner.SignCleartext([]byte(tt.message))
+			if err != nil {
+				t.Fatalf("SignCleartext failed: %v", err)
+			}
+
+			message, err := signer.VerifyCleartext(signed, keyring)
+			if err != nil {
+				t.Fatalf("Signature does not verify: %v\n%s", err, signed)
+			}
+
+			want := strings.TrimSuffix(tt.message, "\n") + "\n"
+			if string(message) != want {
+				t.Errorf("Signed text = %q, want %q", message, want)
+			}
+
+			// CRLF line endings are equivalent to LF
+			crlf := bytes.ReplaceAll(signed, []byte("\n"), []byte("\r\n"))
+			if _, err := signer.VerifyCleartext(crlf, keyring); err != nil {
+				t.Errorf("CRLF copy does not verify: %v", err)
+			}
+
+			// Trailing whitespace is not signed, leading whitespace is. Both
+			// are added to the text as signed, dash-escaped lines included
+			headerEnd := bytes.Index(signed, []byte("\n\n")) + 2
+			textEnd := bytes.Index(signed, []byte("\n-----BEGIN PGP SIGNATURE-----"))
+			text := signed[headerEnd:textEnd]
+			if strings.HasPrefix(tt.message, "-") && !bytes.HasPrefix(text, []byte("- -")) {
+				t.Errorf("Dash lines are not escaped:\n%s", text)
+			}
+			trailing := append(append(append([]byte{}, signed[:headerEnd]...), bytes.ReplaceAll(text, []byte("\n"), []byte(" \t \n"))...), signed[textEnd:]...)
+			if _, err := signer.VerifyCleartext(trailing, keyring); err != nil {
+				t.Errorf("Trailing whitespace change does not verify: %v\n%s", err, trailing)
+			}
+			leading := bytes.Replace(signed, []byte("\n\n"), []byte("\n\n \t"), 1)
+			if _, err := signer.VerifyCleartext(leading, keyring); err == nil {
+				t.Errorf("Leading whitespace change verified")
+			}
+		})
+	}
+
+	// The Hash header must name the algorithm used (SHA512)
+	signed, _ := gpgSigner.SignCleartext([]byte("Origin: Test\n"))
+	wrongHash := bytes.Replace(signed, []byte("Hash: SHA512"), []byte("Hash: SHA256"), 1)
+	if _, err := signer.VerifyCleartext(wrongHash, keyring); err == nil {
+		t.Errorf("Signature with mismatching Hash header verified")
+	}
+}
+
+func TestPreflightSignature(t *testing.T) {
+	clients := []string{"ubuntu-16.04", "debian-12", "ubuntu-24.04", "debian-13"}
+
+	tests := []struct {
+		name    string
+		config  *packet.Config
+		refused []string
+	}{
+		{"rsa3072", &packet.Config{RSABits: 3072}, nil},
+		{"rsa1024", &packet.Config{RSABits: 1024}, []string{"ubuntu-24.04", "debian-13"}},
+		{"ed25519", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}, []string{"ubuntu-16.04"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gpgSigner, _ := newTestSignerWithConfig(t, tt.config)
+
+			verdicts, err := PreflightSignature(gpgSigner, clients)
+			if err != nil {
+				t.Fatalf("PreflightSignature failed: %v", err)
+			}
+
+			var refused []string
+			for _, v := range verdicts {
+				if v.Refused {
+					if len(v.Problems) == 0 {
+						t.Errorf("%s refuses without a reason", v.Client)
+					}
+					refused = append(refused, v.Client)
+				}
+			}
+			if strings.Join(refused, ",") != strings.Join(tt.refused, ",") {
+				t.Errorf("refused by %v, want %v", refused, tt.refused)
+			}
+		})
+	}
+
+	if err := ValidateAptClients([]string{"debian-12", "debian-99"}); err == nil {
+		t.Error("ValidateAptClients accepted an unknown release")
+	}
+}
+
+func TestFlatLayout(t *testing.T) {
+	gpgSigner, keyring := newTestSigner(t)
+	tmpDir := t.TempDir()
+	outputDir := filepath.Join(tmpDir, "output")
+
+	debPath := filepath.Join(tmpDir, "hello_1.0_amd64.deb")
+	os.WriteFile(debPath, []byte("fake deb"), 0644)
+	packages := []models.Package{{
+		Name:         "hello",
+		Version:      "1.0",
+		Architecture: "amd64",
+		Filename:     debPath,
+		Size:         8,
+		SHA256Sum:    "abc",
+	}}
+
+	config := &models.RepositoryConfig{
+		OutputDir:  outputDir,
+		Codename:   "testing",
+		Suite:      "testing",
+		Origin:     "Test",
+		Label:      "Test",
+		Components: []string{"main"},
+		Arches:     []string{"amd64"},
+		DebLayout:  LayoutFlat,
+	}
+	gen := NewGenerator(gpgSigner)
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	for _, name := range []string{"hello_1.0_amd64.deb", "Packages", "Packages.gz", "Release", "InRelease"} {
+		if _, err := os.Stat(filepath.Join(outputDir, name)); err != nil {
+			t.Errorf("%s missing: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "dists")); !os.IsNotExist(err) {
+		t.Errorf("flat repository should have no dists directory")
+	}
+
+	release, _ := os.ReadFile(filepath.Join(outputDir, "Release"))
+	if strings.Contains(string(release), "Components:") || !strings.Contains(string(release), " Packages.gz\n") {
+		t.Errorf("unexpected Release:\n%s", release)
+	}
+	inRelease, _ := os.ReadFile(filepath.Join(outputDir, "InRelease"))
+	if _, err := signer.VerifyCleartext(inRelease, keyring); err != nil {
+		t.Errorf("InRelease does not verify: %v", err)
+	}
+
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 1 || existing[0].Filename != "hello_1.0_amd64.deb" {
+		t.Errorf("unexpected packages %+v", existing)
+	}
+}
+
+func TestComponentsFromInputDirectories(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	outputDir := filepath.Join(tmpDir, "output")
+
+	var packages []models.Package
+	for _, p := range []struct{ dir, name string }{
+		{"", "hello"},
+		{"contrib", "helper"},
+		{"nonfree", "firmware"},
+	} {
+		debPath := filepath.Join(inputDir, p.dir, p.name+"_1.0_amd64.deb")
+		os.MkdirAll(filepath.Dir(debPath), 0755)
+		os.WriteFile(debPath, []byte("fake deb"), 0644)
+		packages = append(packages, models.Package{Name: p.name, Version: "1.0", Architecture: "amd64", Filename: debPath})
+	}
+
+	config := &models.RepositoryConfig{
+		InputDir:     inputDir,
+		OutputDir:    outputDir,
+		Codename:     "testing",
+		Suite:        "testing",
+		Origin:       "Test",
+		Label:        "Test",
+		Components:   []string{"main", "contrib", "non-free"},
+		ComponentMap: map[string]string{"nonfree": "non-free"},
+		Arches:       []string{"amd64"},
+	}
+	gen := NewGenerator(nil)
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	for component, name := range map[string]string{"main": "hello", "contrib": "helper", "non-free": "firmware"} {
+		index, err := os.ReadFile(filepath.Join(outputDir, "dists", "testing", component, "binary-amd64", "Packages"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(index), "Filename: pool/"+component+"/") || strings.Count(string(index), "Package: ") != 1 || !strings.Contains(string(index), "Package: "+name+"\n") {
+			t.Errorf("unexpected %s Packages:\n%s", component, index)
+		}
+	}
+
+	release, _ := os.ReadFile(filepath.Join(outputDir, "dists", "testing", "Release"))
+	if !strings.Contains(string(release), "Components: main contrib non-free\n") || !strings.Contains(string(release), " non-free/binary-amd64/Packages\n") {
+		t.Errorf("unexpected Release:\n%s", release)
+	}
+
+	// Published packages stay in their component when regenerated
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	config.InputDir = ""
+	if err := gen.Generate(context.Background(), config, existing); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	index, _ := os.ReadFile(filepath.Join(outputDir, "dists", "testing", "contrib", "binary-amd64", "Packages"))
+	if !strings.Contains(string(index), "Package: helper\n") {
+		t.Errorf("helper left contrib:\n%s", index)
+	}
+
+	if err := ValidateComponentMap(map[string]string{"extra": "universe"}, config.Components); err == nil {
+		t.Error("expected an error for a component that isn't published")
+	}
+}
+
+func TestSeveralSuitesSharePool(t *testing.T) {
+	gpgSigner, keyring := newTestSigner(t)
+	tmpDir := t.TempDir()
+	outputDir := filepath.Join(tmpDir, "output")
+
+	debPath := filepath.Join(tmpDir, "hello_1.0_amd64.deb")
+	os.WriteFile(debPath, []byte("fake deb"), 0644)
+	packages := []models.Package{{Name: "hello", Version: "1.0", Architecture: "amd64", Filename: debPath}}
+
+	config := &models.RepositoryConfig{
+		OutputDir:  outputDir,
+		Codename:   "bookworm,trixie",
+		Origin:     "Test",
+		Label:      "Test",
+		Components: []string{"main"},
+		Arches:     []string{"amd64"},
+	}
+	gen := NewGenerator(gpgSigner)
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	for _, codename := range []string{"bookworm", "trixie"} {
+		distsDir := filepath.Join(outputDir, "dists", codename)
+		inRelease, err := os.ReadFile(filepath.Join(distsDir, "InRelease"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		release, err := signer.VerifyCleartext(inRelease, keyring)
+		if err != nil {
+			t.Fatalf("%s InRelease does not verify: %v", codename, err)
+		}
+		if !strings.Contains(string(release), "Suite: "+codename+"\nCodename: "+codename+"\n") {
+			t.Errorf("unexpected %s Release:\n%s", codename, release)
+		}
+		index, _ := os.ReadFile(filepath.Join(distsDir, "main", "binary-amd64", "Packages"))
+		if !strings.Contains(string(index), "Filename: pool/main/h/hello/hello_1.0_amd64.deb\n") {
+			t.Errorf("unexpected %s Packages:\n%s", codename, index)
+		}
+	}
+
+	// Packages published in both suites are read back once
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 1 {
+		t.Errorf("expected 1 package, got %d", len(existing))
+	}
+
+	if err := ValidateCodenames("bookworm,trixie", "stable", LayoutPool); err == nil {
+		t.Error("expected an error for a suite shared by several codenames")
+	}
+	if err := ValidateCodenames("bookworm,bookworm", "", LayoutPool); err == nil {
+		t.Error("expected an error for a repeated codename")
+	}
+}
+
+func TestLongDescriptionsInTranslationEn(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	srcPath := filepath.Join(tmpDir, "pkga_1.0_amd64.deb")
+	os.WriteFile(srcPath, []byte("fake deb package A"), 0644)
+
+	config := &models.RepositoryConfig{
+		OutputDir:  filepath.Join(tmpDir, "output"),
+		Codename:   "stable",
+		Components: []string{"main"},
+		Arches:     []string{"amd64"},
+	}
+	description := "Package A\nLong description.\n\nSecond paragraph."
+	packages := []models.Package{{
+		Name:         "pkga",
+		Version:      "1.0",
+		Architecture: "amd64",
+		Filename:     srcPath,
+		Description:  description,
+	}}
+
+	gen := NewGenerator(nil)
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	expectedMD5 := fmt.Sprintf("%x", md5.Sum([]byte("Package A\n Long description.\n .\n Second paragraph.\n")))
+	index, _ := os.ReadFile(filepath.Join(config.OutputDir, "dists", "stable", "main", "binary-amd64", "Packages"))
+	if !strings.Contains(string(index), "Description: Package A\nDescription-md5: "+expectedMD5+"\n") {
+		t.Errorf("Packages should list the synopsis and Description-md5:\n%s", index)
+	}
+
+	translation, err := os.ReadFile(filepath.Join(config.OutputDir, "dists", "stable", "main", "i18n", "Translation-en"))
+	if err != nil {
+		t.Fatalf("Translation-en not written: %v", err)
+	}
+	expected := "Package: pkga\nDescription-md5: " + expectedMD5 + "\nDescription-en: Package A\n Long description.\n .\n Second paragraph.\n\n"
+	if string(translation) != expected {
+		t.Errorf("Unexpected Translation-en:\n%s\nwant:\n%s", translation, expected)
+	}
+
+	release, _ := os.ReadFile(filepath.Join(config.OutputDir, "dists", "stable", "Release"))
+	if !strings.Contains(string(release), "main/i18n/Translation-en.gz") {
+		t.Errorf("Release does not reference Translation-en.gz:\n%s", release)
+	}
+
+	// The long description survives regeneration
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 1 || existing[0].Description != description {
+		t.Fatalf("long description not restored: %+v", existing)
+	}
+	if _, ok := existing[0].Metadata["Description-md5"]; ok {
+		t.Errorf("Description-md5 should not be kept as metadata")
+	}
+}
+
+func TestReleaseFields(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	config := &models.RepositoryConfig{
+		OutputDir:            tmpDir,
+		Codename:             "experimental",
+		Suite:                "experimental",
+		Origin:               "Test",
+		Label:                "Test",
+		Components:           []string{"main"},
+		Arches:               []string{"amd64"},
+		ReleaseValidFor:      "14d",
+		NotAutomatic:         true,
+		ButAutomaticUpgrades: true,
+		AcquireByHash:        true,
+	}
+	if err := NewGenerator(nil).Generate(context.Background(), config, []models.Package{}); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	release, _ := os.ReadFile(filepath.Join(tmpDir, "dists", "experimental", "Release"))
+	for _, expected := range []string{"NotAutomatic: yes\n", "ButAutomaticUpgrades: yes\n", "Acquire-By-Hash: yes\n"} {
+		if !strings.Contains(string(release), expected) {
+			t.Errorf("Release missing %q:\n%s", expected, release)
+		}
+	}
+
+	fields := make(map[string]string)
+	for _, line := range strings.Split(string(release), "\n") {
+		if key, value, ok := strings.Cut(line, ": "); ok {
+			fields[key] = value
+		}
+	}
+	date, err := time.Parse(time.RFC1123Z, fields["Date"])
+	if err != nil {
+		t.Fatalf("invalid Date: %v", err)
+	}
+	validUntil, err := time.Parse(time.RFC1123Z, fields["Valid-Until"])
+	if err != nil {
+		t.Fatalf("invalid Valid-Until: %v", err)
+	}
+	if validUntil.Sub(date) != 14*24*time.Hour {
+		t.Errorf("Valid-Until is %s after Date", validUntil.Sub(date))
+	}
+
+	// Indexes are also available by the hash listed in Release
+	packagesPath := filepath.Join(tmpDir, "dists", "experimental", "main", "binary-amd64", "Packages")
+	checksums, err := utils.CalculateChecksums(packagesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(packagesPath), "by-hash", "SHA256", checksums.SHA256)); err != nil {
+		t.Errorf("by-hash copy missing: %v", err)
+	}
+
+	for _, invalid := range []string{"14", "-1d", "soon"} {
+		if _, err := ParseValidity(invalid); err == nil {
+			t.Errorf("ParseValidity(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestPackagesRelationshipFields(t *testing.T) {
+	control := []byte(`Package: hello
+Version: 1.0
+Architecture: amd64
+Multi-Arch: foreign
+Section: utils
+Priority: optional
+Maintainer: Test <test@example.com>
+Depends: libc6 (>= 2.34)
+Recommends: hello-doc
+Suggests: hello-extras
+Breaks: hello-legacy (<< 1.0)
+Conflicts: hello-ng
+Provides: greeter,
+ hello-tool (= 1.0)
+Replaces: hello-legacy (<< 1.0)
+Installed-Size: 42
+Description: Says hello
+`)
+	pkg, err := parseControl(control)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Section != "utils" || pkg.Priority != "optional" || pkg.MultiArch != "foreign" {
+		t.Errorf("unexpected classification %q %q %q", pkg.Section, pkg.Priority, pkg.MultiArch)
+	}
+	if len(pkg.Provides) != 2 || pkg.Provides[1] != "hello-tool (= 1.0)" || len(pkg.Breaks) != 1 || len(pkg.Recommends) != 1 {
+		t.Errorf("unexpected relationships %+v", pkg)
+	}
+	if len(pkg.Metadata) != 0 {
+		t.Errorf("no field should be left in metadata, got %v", pkg.Metadata)
+	}
+	if pkg.InstalledSize != 42*1024 {
+		t.Errorf("InstalledSize = %d, want %d", pkg.InstalledSize, 42*1024)
+	}
+
+	pkg.Filename = "pool/main/h/hello/hello_1.0_amd64.deb"
+	output, err := GeneratePackagesFile([]models.Package{*pkg})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fields follow the Debian policy order
+	var fields []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if key, _, ok := strings.Cut(line, ":"); ok && line[0] != ' ' {
+			fields = append(fields, key)
+		}
+	}
+	expected := "Package Version Section Priority Architecture Multi-Arch Depends Recommends Suggests Breaks Conflicts Provides Replaces Installed-Size Maintainer Description Filename Size MD5sum SHA1 SHA256 SHA512"
+	if strings.Join(fields, " ") != expected {
+		t.Errorf("unexpected field order:\n%s", output)
+	}
+
+	// And survive a round trip through the index
+	packages, err := parsePackagesReader(bytes.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 1 || packages[0].Section != "utils" || strings.Join(packages[0].Provides, ", ") != "greeter, hello-tool (= 1.0)" || len(packages[0].Conflicts) != 1 {
+		t.Errorf("unexpected packages %+v", packages)
+	}
+}
+
+func TestPackagesCompression(t *testing.T) {
+	tmpDir := t.TempDir()
+	debPath := filepath.Join(tmpDir, "hello_1.0_amd64.deb")
+	os.WriteFile(debPath, []byte("fake deb"), 0644)
+	packages := []models.Package{{
+		Name:         "hello",
+		Version:      "1.0",
+		Architecture: "amd64",
+		Filename:     debPath,
+		Size:         8,
+		SHA256Sum:    "abc",
+	}}
+
+	config := &models.RepositoryConfig{
+		OutputDir:        filepath.Join(tmpDir, "output"),
+		Codename:         "testing",
+		Components:       []string{"main"},
+		Arches:           []string{"amd64"},
+		DBCompression:    utils.CompressionXz,
+		CompressionLevel: 6,
+	}
+	if err := NewGenerator(nil).Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	binDir := filepath.Join(config.OutputDir, "dists", "testing", "main", "binary-amd64")
+	packagesData, _ := os.ReadFile(filepath.Join(binDir, "Packages"))
+	compressed, err := os.ReadFile(filepath.Join(binDir, "Packages.xz"))
+	if err != nil {
+		t.Fatalf("Packages.xz missing: %v", err)
+	}
+	if data, err := utils.Decompress(compressed); err != nil || !bytes.Equal(data, packagesData) {
+		t.Errorf("Packages.xz doesn't decompress to Packages: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(binDir, "Packages.gz")); !os.IsNotExist(err) {
+		t.Errorf("Packages.gz written with xz compression")
+	}
+
+	release, _ := os.ReadFile(filepath.Join(config.OutputDir, "dists", "testing", "Release"))
+	if !strings.Contains(string(release), " main/binary-amd64/Packages.xz\n") || strings.Contains(string(release), "Packages.gz") {
+		t.Errorf("unexpected Release:\n%s", release)
+	}
+}
+
+func TestSourcesFile(t *testing.T) {
+	gpgSigner, _ := newTestSigner(t)
+	tmpDir := t.TempDir()
+	outputDir := filepath.Join(tmpDir, "output")
+
+	debPath := filepath.Join(tmpDir, "hello_1.0_amd64.deb")
+	os.WriteFile(debPath, []byte("fake deb"), 0644)
+	packages := []models.Package{{Name: "hello", Version: "1.0", Architecture: "amd64", Filename: debPath}}
+
+	config := &models.RepositoryConfig{
+		OutputDir:  outputDir,
+		Codename:   "bookworm,trixie",
+		Origin:     "Test Repo",
+		Label:      "Test",
+		Components: []string{"main", "contrib"},
+		Arches:     []string{"amd64", "arm64"},
+		BaseURL:    "https://example.com/apt/",
+	}
+	if err := NewGenerator(gpgSigner).Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	sources, err := os.ReadFile(filepath.Join(outputDir, "test-repo.sources"))
+	if err != nil {
+		t.Fatalf("Sources file not written: %v", err)
+	}
+	expected := "Types: deb\nURIs: https://example.com/apt\nSuites: bookworm trixie\nComponents: main contrib\n" +
+		"Architectures: amd64 arm64\nSigned-By: /etc/apt/keyrings/test-repo.gpg\n"
+	if string(sources) != expected {
+		t.Errorf("Unexpected sources file:\n%s", sources)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "keys", "test-repo.gpg")); err != nil {
+		t.Errorf("Signed-By keyring not published: %v", err)
+	}
+
+	// Flat repositories have no suites, unsigned ones no Signed-By
+	config.DebLayout = LayoutFlat
+	config.Codename = "stable"
+	config.OutputDir = filepath.Join(tmpDir, "flat")
+	if err := NewGenerator(nil).Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	sources, _ = os.ReadFile(filepath.Join(config.OutputDir, "test-repo.sources"))
+	if !strings.Contains(string(sources), "Suites: ./\n") || strings.Contains(string(sources), "Components:") || strings.Contains(string(sources), "Signed-By:") {
+		t.Errorf("Unexpected flat sources file:\n%s", sources)
+	}
+}
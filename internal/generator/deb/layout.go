@@ -0,0 +1,68 @@
+package deb
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/translations"
+	"github.com/sirupsen/logrus"
+)
+
+// Repository layouts
+const (
+	LayoutPool = "pool" // dists/<codename>/<component>/binary-<arch>/, packages under pool/
+	LayoutFlat = "flat" // Packages and Release at the root, next to the packages
+)
+
+// ValidateLayout checks that layout is a known repository layout
+func ValidateLayout(layout string) error {
+	switch layout {
+	case "", LayoutPool, LayoutFlat:
+		return nil
+	}
+	return fmt.Errorf("unknown Debian layout %q (expected %s or %s)", layout, LayoutPool, LayoutFlat)
+}
+
+// generateFlat creates a flat repository, used as "deb URL ./": a single
+// Packages file lists the packages of every architecture, which sit next
+// to it at the root
+func (g *Generator) generateFlat(ctx context.Context, config *models.RepositoryConfig, packages []models.Package) error {
+	prefsPath := filepath.Join(config.OutputDir, "deprecated.pref")
+	dropStalePhasing(packages, prefsPath)
+
+	for i := range packages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := publishPackage(ctx, config, &packages[i], config.OutputDir); err != nil {
+			return err
+		}
+	}
+
+	if err := writePackagesFiles(config, config.OutputDir, packages, false); err != nil {
+		return err
+	}
+	logrus.Infof("Generated Packages files (%d packages)", len(packages))
+
+	// apt looks for neither translations nor components in flat repositories,
+	// so Packages keeps the long descriptions
+	if languages := translations.Languages(packages); len(languages) > 0 {
+		logrus.Warn("Translated descriptions are not published in flat repositories")
+	}
+	flat := *config
+	flat.Components = nil
+
+	if err := writeDeprecationPreferences(&flat, prefsPath, packages); err != nil {
+		return err
+	}
+
+	logrus.Info("Generating Release file...")
+	if err := g.writeRelease(&flat, config.OutputDir, packagesIndexes(config)); err != nil {
+		return fmt.Errorf("failed to generate Release: %w", err)
+	}
+
+	logrus.Info("Debian repository generated successfully")
+	return nil
+}
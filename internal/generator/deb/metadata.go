@@ -7,10 +7,18 @@ import (
 	"strings"
 
 	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
 )
 
 // GeneratePackagesFile creates a Debian Packages file from package metadata
 func GeneratePackagesFile(packages []models.Package) ([]byte, error) {
+	return generatePackagesFile(packages, false)
+}
+
+// generatePackagesFile creates a Packages file, split listing the synopsis
+// and Description-md5 of descriptions whose long form is published in
+// Translation-en, as in the Debian archive
+func generatePackagesFile(packages []models.Package, split bool) ([]byte, error) {
 	var buf bytes.Buffer
 
 	// Sort packages alphabetically by name
@@ -19,45 +27,80 @@ func GeneratePackagesFile(packages []models.Package) ([]byte, error) {
 	})
 
 	for _, pkg := range packages {
-		// Required fields
-		fmt.Fprintf(&buf, "Package: %s\n", pkg.Name)
-		fmt.Fprintf(&buf, "Version: %s\n", pkg.Version)
-		fmt.Fprintf(&buf, "Architecture: %s\n", pkg.Architecture)
+		fields := map[string]string{
+			"Package":      pkg.Name,
+			"Version":      pkg.Version,
+			"Architecture": pkg.Architecture,
+			"Filename":     pkg.Filename,
+			"Size":         fmt.Sprintf("%d", pkg.Size),
+			"MD5sum":       pkg.MD5Sum,
+			"SHA1":         pkg.SHA1Sum,
+			"SHA256":       pkg.SHA256Sum,
+			"SHA512":       pkg.SHA512Sum,
+		}
 
-		// File information
-		fmt.Fprintf(&buf, "Filename: %s\n", pkg.Filename)
-		fmt.Fprintf(&buf, "Size: %d\n", pkg.Size)
-		fmt.Fprintf(&buf, "MD5sum: %s\n", pkg.MD5Sum)
-		fmt.Fprintf(&buf, "SHA1: %s\n", pkg.SHA1Sum)
-		fmt.Fprintf(&buf, "SHA256: %s\n", pkg.SHA256Sum)
-		fmt.Fprintf(&buf, "SHA512: %s\n", pkg.SHA512Sum)
+		// Other metadata fields, unless written from the package itself
+		for key, value := range pkg.Metadata {
+			// Description-md5 is only valid next to the description it was computed from
+			if generatedFields[key] || key == "Description-md5" {
+				continue
+			}
+			fields[key] = fmt.Sprintf("%v", value)
+		}
 
 		// Optional fields
-		if pkg.Maintainer != "" {
-			fmt.Fprintf(&buf, "Maintainer: %s\n", pkg.Maintainer)
+		for key, value := range map[string]string{
+			"Maintainer": pkg.Maintainer,
+			"Homepage":   pkg.Homepage,
+			"Section":    pkg.Section,
+			"Priority":   pkg.Priority,
+			"Multi-Arch": pkg.MultiArch,
+			"Depends":    strings.Join(pkg.Dependencies, ", "),
+			"Recommends": strings.Join(pkg.Recommends, ", "),
+			"Suggests":   strings.Join(pkg.Suggests, ", "),
+			"Breaks":     strings.Join(pkg.Breaks, ", "),
+			"Conflicts":  strings.Join(pkg.Conflicts, ", "),
+			"Provides":   strings.Join(pkg.Provides, ", "),
+			"Replaces":   strings.Join(pkg.Replaces, ", "),
+		} {
+			if value != "" {
+				fields[key] = value
+			}
 		}
 
-		if pkg.Homepage != "" {
-			fmt.Fprintf(&buf, "Homepage: %s\n", pkg.Homepage)
+		if pkg.InstalledSize > 0 {
+			fields["Installed-Size"] = fmt.Sprintf("%d", (pkg.InstalledSize+1023)/1024)
 		}
 
-		if pkg.Description != "" {
-			fmt.Fprintf(&buf, "Description: %s\n", pkg.Description)
+		if pkg.Description != "" && split {
+			synopsis, _, _ := strings.Cut(pkg.Description, "\n")
+			fields["Description"] = synopsis
+			fields["Description-md5"] = descriptionMD5(pkg.Description)
+		} else if pkg.Description != "" {
+			fields["Description"] = pkg.Description
 		}
 
-		if len(pkg.Dependencies) > 0 {
-			fmt.Fprintf(&buf, "Depends: %s\n", strings.Join(pkg.Dependencies, ", "))
+		// Deprecated packages are never offered as upgrades by phasing-aware apt
+		if pkg.Deprecation != nil {
+			fields[phasedField] = "0"
 		}
 
-		// Add other metadata fields
-		for key, value := range pkg.Metadata {
-			// Skip fields we've already handled
-			if key == "Package" || key == "Version" || key == "Architecture" ||
-				key == "Maintainer" || key == "Homepage" || key == "Description" ||
-				key == "Depends" {
-				continue
+		// Write fields in the Debian policy order, then the others
+		// alphabetically, so output is stable across runs
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			ri, rj := fieldRank(keys[i]), fieldRank(keys[j])
+			if ri != rj {
+				return ri < rj
 			}
-			fmt.Fprintf(&buf, "%s: %v\n", key, value)
+			return keys[i] < keys[j]
+		})
+
+		for _, key := range keys {
+			writeField(&buf, key, fields[key])
 		}
 
 		// Blank line between packages
@@ -66,3 +109,179 @@ func GeneratePackagesFile(packages []models.Package) ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// generatedFields lists the fields GeneratePackagesFile writes itself,
+// which must not be duplicated from package metadata
+var generatedFields = map[string]bool{
+	"Package":      true,
+	"Version":      true,
+	"Architecture": true,
+	"Filename":     true,
+	"Size":         true,
+	"MD5sum":       true,
+	"SHA1":         true,
+	"SHA256":       true,
+	"SHA512":       true,
+	"Maintainer":   true,
+	"Homepage":     true,
+	"Description":  true,
+	"Section":      true,
+	"Priority":     true,
+	"Multi-Arch":   true,
+	"Depends":      true,
+	"Recommends":   true,
+	"Suggests":     true,
+	"Breaks":       true,
+	"Conflicts":    true,
+	"Provides":     true,
+	"Replaces":     true,
+}
+
+// phasedField holds the phasing of a package, which repogen sets to 0 for
+// deprecated packages
+const phasedField = "Phased-Update-Percentage"
+
+// fieldOrder is the order of the fields of Packages entries, as in binary
+// package control files (Debian policy 5.3) followed by the index fields
+var fieldOrder = []string{
+	"Package",
+	"Source",
+	"Version",
+	"Section",
+	"Priority",
+	"Architecture",
+	"Multi-Arch",
+	"Essential",
+	"Depends",
+	"Pre-Depends",
+	"Recommends",
+	"Suggests",
+	"Breaks",
+	"Conflicts",
+	"Provides",
+	"Replaces",
+	"Enhances",
+	"Installed-Size",
+	"Maintainer",
+	"Description",
+	"Description-md5",
+	"Homepage",
+	"Built-Using",
+	"Filename",
+	"Size",
+	"MD5sum",
+	"SHA1",
+	"SHA256",
+	"SHA512",
+	"Phased-Update-Percentage",
+}
+
+// fieldRank returns the position of a field in fieldOrder, fields missing
+// from it coming last
+func fieldRank(field string) int {
+	for i, f := range fieldOrder {
+		if f == field {
+			return i
+		}
+	}
+	return len(fieldOrder)
+}
+
+// writeField writes a deb822 field, folding multi-line values into
+// continuation lines and encoding empty lines as " ."
+func writeField(buf *bytes.Buffer, key, value string) {
+	fmt.Fprintf(buf, "%s: %s\n", key, foldValue(value))
+}
+
+// foldValue returns a field value the way it appears after "Key: " in a
+// deb822 file, without the trailing newline
+func foldValue(value string) string {
+	lines := strings.Split(value, "\n")
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" {
+			lines[i] = "."
+		}
+		lines[i] = " " + lines[i]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// descriptionMD5 returns the Description-md5 apt uses to match a package
+// with its translated descriptions
+func descriptionMD5(description string) string {
+	sum, _ := utils.CalculateChecksum([]byte(foldValue(description)+"\n"), "md5")
+	return sum
+}
+
+// GenerateTranslationFile creates a Translation-<lang> index holding the
+// localized descriptions of packages, Translation-en holding the original
+// long descriptions. Returns nil when no package is translated to lang
+func GenerateTranslationFile(lang string, packages []models.Package) []byte {
+	var translated []models.Package
+	for _, pkg := range packages {
+		if translation(pkg, lang) != "" && pkg.Description != "" {
+			translated = append(translated, pkg)
+		}
+	}
+	sort.SliceStable(translated, func(i, j int) bool {
+		return translated[i].Name < translated[j].Name
+	})
+
+	var buf bytes.Buffer
+	seen := make(map[string]bool)
+	for _, pkg := range translated {
+		// Entries are keyed by the original description, not the version
+		md5 := descriptionMD5(pkg.Description)
+		if seen[pkg.Name+"/"+md5] {
+			continue
+		}
+		seen[pkg.Name+"/"+md5] = true
+
+		writeField(&buf, "Package", pkg.Name)
+		writeField(&buf, "Description-md5", md5)
+		writeField(&buf, "Description-"+lang, translation(pkg, lang))
+		buf.WriteString("\n")
+	}
+
+	if buf.Len() == 0 {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// translation returns the description of pkg in lang, English defaulting
+// to the original description
+func translation(pkg models.Package, lang string) string {
+	if text := pkg.Translations[lang]; text != "" || lang != "en" {
+		return text
+	}
+	return pkg.Description
+}
+
+// GenerateDeprecationPreferences creates an apt preferences file pinning
+// deprecated packages from this repository to a negative priority.
+// Returns nil when no package is deprecated
+func GenerateDeprecationPreferences(config *models.RepositoryConfig, packages []models.Package) []byte {
+	var buf bytes.Buffer
+	seen := make(map[string]bool)
+
+	for _, pkg := range packages {
+		if pkg.Deprecation == nil || seen[pkg.Name] {
+			continue
+		}
+		seen[pkg.Name] = true
+
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "Explanation: %s is %s\n", pkg.Name, pkg.Deprecation.Note())
+		fmt.Fprintf(&buf, "Package: %s\n", pkg.Name)
+		fmt.Fprintf(&buf, "Pin: release o=%s,n=%s\n", config.Origin, config.Codename)
+		buf.WriteString("Pin-Priority: -1\n")
+	}
+
+	if buf.Len() == 0 {
+		return nil
+	}
+	return buf.Bytes()
+}
@@ -7,12 +7,14 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/klauspost/compress/zstd"
+	"github.com/ralt/repogen/internal/depcheck"
 	"github.com/ralt/repogen/internal/models"
 	"github.com/ralt/repogen/internal/utils"
 	"github.com/ulikunitz/xz"
@@ -56,55 +58,27 @@ func extractControl(path string) ([]byte, error) {
 		return nil, err
 	}
 	defer f.Close()
+	return readControl(f)
+}
 
-	// .deb files are ar archives
-	// Skip the first 8 bytes ("!<arch>\n")
-	header := make([]byte, 8)
-	if _, err := f.Read(header); err != nil {
+// readControl reads the control file of the .deb package read by r,
+// streaming its control.tar member rather than loading it in memory
+func readControl(r io.Reader) ([]byte, error) {
+	ar, err := newArReader(r)
+	if err != nil {
 		return nil, err
 	}
 
-	// Read ar archive entries
 	for {
-		// Read ar header (60 bytes)
-		arHeader := make([]byte, 60)
-		n, err := f.Read(arHeader)
+		name, _, err := ar.Next()
 		if err == io.EOF {
 			break
 		}
-		if err != nil || n != 60 {
-			return nil, fmt.Errorf("failed to read ar header")
-		}
-
-		// Parse filename (first 16 bytes, space-padded)
-		// Also trim trailing slash that ar format may include
-		filename := strings.TrimRight(strings.TrimSpace(string(arHeader[0:16])), "/")
-
-		// Parse file size (bytes 48-58, decimal)
-		sizeStr := strings.TrimSpace(string(arHeader[48:58]))
-		var size int64
-		fmt.Sscanf(sizeStr, "%d", &size)
-
-		// Check if this is the control archive
-		if strings.HasPrefix(filename, "control.tar") {
-			// Read control archive data
-			data := make([]byte, size)
-			if _, err := io.ReadFull(f, data); err != nil {
-				return nil, err
-			}
-
-			// Extract control file from control.tar
-			return extractControlFromTar(data, filename)
-		}
-
-		// Skip this file's data
-		if _, err := f.Seek(size, io.SeekCurrent); err != nil {
+		if err != nil {
 			return nil, err
 		}
-
-		// Align to 2-byte boundary
-		if size%2 != 0 {
-			f.Seek(1, io.SeekCurrent)
+		if strings.HasPrefix(name, "control.tar") {
+			return extractControlFromTar(ar, name)
 		}
 	}
 
@@ -112,32 +86,36 @@ func extractControl(path string) ([]byte, error) {
 }
 
 // extractControlFromTar extracts the control file from control.tar*
-func extractControlFromTar(data []byte, filename string) ([]byte, error) {
+func extractControlFromTar(r io.Reader, filename string) ([]byte, error) {
 	var tarReader *tar.Reader
 
 	// Decompress based on extension
-	if strings.HasSuffix(filename, ".gz") {
-		gr, err := gzip.NewReader(bytes.NewReader(data))
+	switch {
+	case strings.HasSuffix(filename, ".gz"):
+		gr, err := gzip.NewReader(r)
 		if err != nil {
 			return nil, err
 		}
 		defer gr.Close()
 		tarReader = tar.NewReader(gr)
-	} else if strings.HasSuffix(filename, ".xz") {
-		xr, err := xz.NewReader(bytes.NewReader(data))
+	case strings.HasSuffix(filename, ".xz"):
+		xr, err := xz.NewReader(r)
 		if err != nil {
 			return nil, err
 		}
 		tarReader = tar.NewReader(xr)
-	} else if strings.HasSuffix(filename, ".zst") {
-		zr, err := zstd.NewReader(bytes.NewReader(data))
+	case strings.HasSuffix(filename, ".zst"):
+		// A single decoder goroutine is enough for a few kilobytes
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
 		if err != nil {
 			return nil, err
 		}
 		defer zr.Close()
 		tarReader = tar.NewReader(zr)
-	} else {
-		tarReader = tar.NewReader(bytes.NewReader(data))
+	case filename == "control.tar":
+		tarReader = tar.NewReader(r)
+	default:
+		return nil, fmt.Errorf("unsupported compression of %s", filename)
 	}
 
 	// Find and read control file
@@ -151,6 +129,9 @@ func extractControlFromTar(data []byte, filename string) ([]byte, error) {
 		}
 
 		if header.Name == "./control" || header.Name == "control" {
+			if header.Size > maxControlSize {
+				return nil, fmt.Errorf("control file too large (%d bytes)", header.Size)
+			}
 			return io.ReadAll(tarReader)
 		}
 	}
@@ -158,6 +139,10 @@ func extractControlFromTar(data []byte, filename string) ([]byte, error) {
 	return nil, fmt.Errorf("control file not found in control.tar")
 }
 
+// maxControlSize bounds the control file read from a package, which is a
+// few kilobytes in practice
+const maxControlSize = 1 << 20
+
 // parseControl parses the Debian control file format
 func parseControl(data []byte) (*models.Package, error) {
 	pkg := &models.Package{
@@ -219,46 +204,114 @@ func setValue(pkg *models.Package, key, value string) {
 		pkg.Homepage = value
 	case "License":
 		pkg.License = value
+	default:
+		if !setCommonField(pkg, key, value) {
+			// Store other fields in metadata
+			pkg.Metadata[key] = value
+		}
+	}
+}
+
+// setCommonField sets the fields control files and Packages indexes share,
+// returning false for the others
+func setCommonField(pkg *models.Package, field, value string) bool {
+	switch field {
+	case "Section":
+		pkg.Section = value
+	case "Priority":
+		pkg.Priority = value
+	case "Multi-Arch":
+		pkg.MultiArch = value
 	case "Depends":
-		// Parse dependencies (comma-separated)
-		deps := strings.Split(value, ",")
-		for _, dep := range deps {
-			pkg.Dependencies = append(pkg.Dependencies, strings.TrimSpace(dep))
+		pkg.Dependencies = append(pkg.Dependencies, splitRelationships(value)...)
+	case "Recommends":
+		pkg.Recommends = append(pkg.Recommends, splitRelationships(value)...)
+	case "Suggests":
+		pkg.Suggests = append(pkg.Suggests, splitRelationships(value)...)
+	case "Breaks":
+		pkg.Breaks = append(pkg.Breaks, splitRelationships(value)...)
+	case "Conflicts":
+		pkg.Conflicts = append(pkg.Conflicts, splitRelationships(value)...)
+	case "Provides":
+		pkg.Provides = append(pkg.Provides, splitRelationships(value)...)
+	case "Replaces":
+		pkg.Replaces = append(pkg.Replaces, splitRelationships(value)...)
+	case "Installed-Size":
+		// In KiB, ignored when invalid as dpkg does
+		kib, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || kib < 0 || kib > math.MaxInt64/1024 {
+			return false
 		}
+		pkg.InstalledSize = kib * 1024
 	default:
-		// Store other fields in metadata
-		pkg.Metadata[key] = value
+		return false
+	}
+	return true
+}
+
+// splitRelationships splits a comma-separated relationship field such as
+// Depends, possibly folded over several lines
+func splitRelationships(value string) []string {
+	var relationships []string
+	for _, relationship := range strings.Split(value, ",") {
+		if relationship = strings.TrimSpace(relationship); relationship != "" {
+			relationships = append(relationships, relationship)
+		}
 	}
+	return relationships
 }
 
 // ParseExistingMetadata reads Packages files and returns existing packages
 func (g *Generator) ParseExistingMetadata(config *models.RepositoryConfig) ([]models.Package, error) {
-	var allPackages []models.Package
+	if config.DebLayout == LayoutFlat {
+		packagesPath := filepath.Join(config.OutputDir, "Packages")
+		packages, err := parsePackagesFile(packagesPath)
+		if err != nil {
+			packages, err = parsePackagesGzFile(packagesPath + ".gz")
+		}
+		if err != nil || len(packages) == 0 {
+			return nil, fmt.Errorf("no existing Debian metadata found in %s", config.OutputDir)
+		}
+		return packages, nil
+	}
 
-	// Iterate through all architectures and components
-	for _, arch := range config.Arches {
-		for _, comp := range config.Components {
-			packagesPath := filepath.Join(
-				config.OutputDir,
-				"dists",
-				config.Codename,
-				comp,
-				fmt.Sprintf("binary-%s", arch),
-				"Packages",
-			)
-
-			// Try Packages first, fall back to Packages.gz
-			packages, err := parsePackagesFile(packagesPath)
-			if err != nil {
-				packagesGzPath := packagesPath + ".gz"
-				packages, err = parsePackagesGzFile(packagesGzPath)
+	var allPackages []models.Package
+	seen := make(map[string]bool)
+
+	// Iterate through all suites, architectures and components
+	for _, codename := range Codenames(config.Codename) {
+		for _, arch := range config.Arches {
+			for _, comp := range config.Components {
+				packagesPath := filepath.Join(
+					config.OutputDir,
+					"dists",
+					codename,
+					comp,
+					fmt.Sprintf("binary-%s", arch),
+					"Packages",
+				)
+
+				// Try Packages first, fall back to Packages.gz
+				packages, err := parsePackagesFile(packagesPath)
 				if err != nil {
-					// No existing metadata for this arch/comp, skip
-					continue
+					packagesGzPath := packagesPath + ".gz"
+					packages, err = parsePackagesGzFile(packagesGzPath)
+					if err != nil {
+						// No existing metadata for this arch/comp, skip
+						continue
+					}
 				}
-			}
 
-			allPackages = append(allPackages, packages...)
+				restoreDescriptions(packages, filepath.Join(config.OutputDir, "dists", codename, comp, "i18n"))
+
+				// Suites share the pool, so list each of its packages once
+				for _, pkg := range packages {
+					if !seen[pkg.Filename] {
+						seen[pkg.Filename] = true
+						allPackages = append(allPackages, pkg)
+					}
+				}
+			}
 		}
 	}
 
@@ -269,6 +322,37 @@ func (g *Generator) ParseExistingMetadata(config *models.RepositoryConfig) ([]mo
 	return allPackages, nil
 }
 
+// restoreDescriptions puts back the long descriptions that Packages indexes
+// leave to the Translation-en of i18nDir
+func restoreDescriptions(packages []models.Package, i18nDir string) {
+	translationPath := filepath.Join(i18nDir, "Translation-en")
+	entries, err := parsePackagesFile(translationPath)
+	if err != nil {
+		entries, err = parsePackagesGzFile(translationPath + ".gz")
+		if err != nil {
+			return
+		}
+	}
+
+	descriptions := make(map[string]string)
+	for _, entry := range entries {
+		md5, _ := entry.Metadata["Description-md5"].(string)
+		description, _ := entry.Metadata["Description-en"].(string)
+		descriptions[entry.Name+"/"+md5] = description
+	}
+
+	for i := range packages {
+		md5, ok := packages[i].Metadata["Description-md5"].(string)
+		if !ok {
+			continue
+		}
+		if description, ok := descriptions[packages[i].Name+"/"+md5]; ok && description != "" {
+			packages[i].Description = description
+		}
+		delete(packages[i].Metadata, "Description-md5")
+	}
+}
+
 func parsePackagesFile(path string) ([]models.Package, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -297,13 +381,26 @@ func parsePackagesGzFile(path string) ([]models.Package, error) {
 func parsePackagesReader(r io.Reader) ([]models.Package, error) {
 	var packages []models.Package
 	var currentPkg *models.Package
+	var currentField string
+	var currentValue strings.Builder
+
+	// flushField stores the field being read, including its continuation lines
+	flushField := func() {
+		if currentPkg != nil && currentField != "" {
+			setPackagesField(currentPkg, currentField, currentValue.String())
+		}
+		currentField = ""
+		currentValue.Reset()
+	}
 
 	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
 	for scanner.Scan() {
 		line := scanner.Text()
 
 		// Empty line = end of package entry
 		if line == "" {
+			flushField()
 			if currentPkg != nil {
 				packages = append(packages, *currentPkg)
 				currentPkg = nil
@@ -311,14 +408,25 @@ func parsePackagesReader(r io.Reader) ([]models.Package, error) {
 			continue
 		}
 
+		// Continuation line (multi-line fields such as Description)
+		if line[0] == ' ' || line[0] == '\t' {
+			if currentField != "" {
+				currentValue.WriteString("\n")
+				// " ." encodes an empty line
+				if line[1:] != "." {
+					currentValue.WriteString(line[1:])
+				}
+			}
+			continue
+		}
+
 		// Parse field: value
-		parts := strings.SplitN(line, ": ", 2)
+		parts := strings.SplitN(line, ":", 2)
 		if len(parts) != 2 {
 			continue
 		}
 
-		field := parts[0]
-		value := parts[1]
+		flushField()
 
 		if currentPkg == nil {
 			currentPkg = &models.Package{
@@ -326,44 +434,93 @@ func parsePackagesReader(r io.Reader) ([]models.Package, error) {
 			}
 		}
 
-		// Parse known fields
-		switch field {
-		case "Package":
-			currentPkg.Name = value
-		case "Version":
-			currentPkg.Version = value
-		case "Architecture":
-			currentPkg.Architecture = value
-		case "Filename":
-			currentPkg.Filename = value
-		case "Size":
-			size, _ := strconv.ParseInt(value, 10, 64)
-			currentPkg.Size = size
-		case "MD5sum":
-			currentPkg.MD5Sum = value
-		case "SHA1":
-			currentPkg.SHA1Sum = value
-		case "SHA256":
-			currentPkg.SHA256Sum = value
-		case "SHA512":
-			currentPkg.SHA512Sum = value
-		case "Description":
-			currentPkg.Description = value
-		case "Maintainer":
-			currentPkg.Maintainer = value
-		case "Homepage":
-			currentPkg.Homepage = value
-		case "Depends":
-			currentPkg.Dependencies = strings.Split(value, ", ")
-		default:
-			currentPkg.Metadata[field] = value
-		}
+		currentField = parts[0]
+		currentValue.WriteString(strings.TrimSpace(parts[1]))
 	}
 
 	// Don't forget last package
+	flushField()
 	if currentPkg != nil {
 		packages = append(packages, *currentPkg)
 	}
 
 	return packages, scanner.Err()
 }
+
+// setPackagesField sets a field read from a Packages index. Fields repogen
+// doesn't model are kept in Metadata so they survive regeneration
+func setPackagesField(pkg *models.Package, field, value string) {
+	switch field {
+	case "Package":
+		pkg.Name = value
+	case "Version":
+		pkg.Version = value
+	case "Architecture":
+		pkg.Architecture = value
+	case "Filename":
+		pkg.Filename = value
+	case "Size":
+		size, _ := strconv.ParseInt(value, 10, 64)
+		pkg.Size = size
+	case "MD5sum":
+		pkg.MD5Sum = value
+	case "SHA1":
+		pkg.SHA1Sum = value
+	case "SHA256":
+		pkg.SHA256Sum = value
+	case "SHA512":
+		pkg.SHA512Sum = value
+	case "Description":
+		pkg.Description = value
+	case "Maintainer":
+		pkg.Maintainer = value
+	case "Homepage":
+		pkg.Homepage = value
+	default:
+		if !setCommonField(pkg, field, value) {
+			pkg.Metadata[field] = value
+		}
+	}
+}
+
+// PackageFiles returns the pool path of a package from existing metadata
+func (g *Generator) PackageFiles(config *models.RepositoryConfig, pkg models.Package) []string {
+	return []string{filepath.Join(config.OutputDir, pkg.Filename)}
+}
+
+// Requires returns the Depends and Pre-Depends of pkg, alternatives being
+// separated by "|"
+func (g *Generator) Requires(pkg models.Package) [][]string {
+	relationships := pkg.Dependencies
+	if preDepends, ok := pkg.Metadata["Pre-Depends"].(string); ok {
+		relationships = append(splitRelationships(preDepends), relationships...)
+	}
+
+	var requires [][]string
+	for _, relationship := range relationships {
+		var alternatives []string
+		for _, alternative := range strings.Split(relationship, "|") {
+			alternatives = append(alternatives, relationshipName(alternative))
+		}
+		requires = append(requires, alternatives)
+	}
+	return requires
+}
+
+// Provides returns the name of pkg and the virtual packages it provides
+func (g *Generator) Provides(pkg models.Package) []string {
+	provides := []string{pkg.Name}
+	for _, relationship := range pkg.Provides {
+		provides = append(provides, relationshipName(relationship))
+	}
+	return provides
+}
+
+// relationshipName returns the package a relationship such as
+// "python3:any (>= 3.9) [amd64]" is on
+func relationshipName(relationship string) string {
+	name := depcheck.TrimConstraint(strings.TrimSpace(relationship))
+	name, _, _ = strings.Cut(name, "[")
+	name, _, _ = strings.Cut(name, ":")
+	return name
+}
@@ -0,0 +1,171 @@
+package deb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/packager"
+)
+
+// buildDeb builds a package whose members are compressed with compression
+func buildDeb(t testing.TB, compression string) string {
+	t.Helper()
+	path, err := packager.Build("deb", packager.Package{
+		Name:        "hello",
+		Version:     "1.0",
+		Maintainer:  "Test <test@example.com>",
+		Summary:     "Says hello",
+		Compression: compression,
+		Files:       []packager.File{{Path: "usr/bin/hello", Mode: 0755, Data: bytes.Repeat([]byte("x"), 5000)}},
+	}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParsePackageCompressions(t *testing.T) {
+	for _, compression := range []string{"gzip", "xz", "zst"} {
+		t.Run(compression, func(t *testing.T) {
+			pkg, err := ParsePackage(buildDeb(t, compression))
+			if err != nil {
+				t.Fatalf("ParsePackage failed: %v", err)
+			}
+			if pkg.Name != "hello" || pkg.Version != "1.0" || pkg.Architecture != "amd64" {
+				t.Errorf("unexpected package %s %s %s", pkg.Name, pkg.Version, pkg.Architecture)
+			}
+			if pkg.InstalledSize != 5*1024 {
+				t.Errorf("InstalledSize = %d, want %d", pkg.InstalledSize, 5*1024)
+			}
+			if _, ok := pkg.Metadata["Installed-Size"]; ok {
+				t.Error("Installed-Size left in metadata")
+			}
+		})
+	}
+}
+
+// arMember returns an ar member header and data, padded to 2 bytes
+func arMember(name string, data []byte) []byte {
+	member := []byte(fmt.Sprintf("%-16s%-12d%-6d%-6d%-8s%-10d`\n", name+"/", 0, 0, 0, "100644", len(data)))
+	member = append(member, data...)
+	if len(data)%2 != 0 {
+		member = append(member, '\n')
+	}
+	return member
+}
+
+func TestArReader(t *testing.T) {
+	archive := []byte(arMagic)
+	archive = append(archive, arMember("debian-binary", []byte("2.0\n"))...)
+	archive = append(archive, arMember("odd", []byte("abc"))...)
+	archive = append(archive, arMember("last", []byte("data"))...)
+
+	// Members are skipped by seeking in files, and by reading otherwise
+	for _, r := range []io.Reader{bytes.NewReader(archive), io.MultiReader(bytes.NewReader(archive))} {
+		ar, err := newArReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for {
+			name, _, err := ar.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Next failed: %v", err)
+			}
+			names = append(names, name)
+			if name == "last" {
+				data, err := io.ReadAll(ar)
+				if err != nil || string(data) != "data" {
+					t.Errorf("last member = %q, %v", data, err)
+				}
+			}
+		}
+		if strings.Join(names, " ") != "debian-binary odd last" {
+			t.Errorf("members = %v", names)
+		}
+	}
+}
+
+func TestReadControlRejectsInvalidArchives(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"no magic":     []byte("PK\x03\x04"),
+		"bad header":   append([]byte(arMagic), bytes.Repeat([]byte(" "), arHeaderSize)...),
+		"bad size":     append([]byte(arMagic), []byte(fmt.Sprintf("%-16s%-32s%-10s`\n", "control.tar.gz", "", "-1"))...),
+		"truncated":    append([]byte(arMagic), arMember("control.tar.gz", []byte("abcd"))[:arHeaderSize+2]...),
+		"no control":   append([]byte(arMagic), arMember("debian-binary", []byte("2.0\n"))...),
+		"unknown type": append([]byte(arMagic), arMember("control.tar.lz4", []byte("abcd"))...),
+	} {
+		if _, err := readControl(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: readControl succeeded", name)
+		}
+	}
+}
+
+func FuzzReadControl(f *testing.F) {
+	for _, compression := range []string{"gzip", "xz", "zst"} {
+		data, err := os.ReadFile(buildDeb(f, compression))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte(arMagic))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		control, err := readControl(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if len(control) > maxControlSize {
+			t.Errorf("read a %d bytes control file", len(control))
+		}
+		if _, err := parseControl(control); err != nil && !strings.Contains(err.Error(), "too long") {
+			t.Errorf("parseControl failed: %v", err)
+		}
+	})
+}
+
+func FuzzParseControl(f *testing.F) {
+	f.Add([]byte("Package: hello\nVersion: 1.0\nInstalled-Size: 42\nDepends: a, b\nDescription: x\n more\n"))
+	f.Add([]byte("Installed-Size: -1\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkg, err := parseControl(data)
+		if err != nil {
+			return
+		}
+		if pkg.InstalledSize < 0 {
+			t.Errorf("negative InstalledSize %d", pkg.InstalledSize)
+		}
+	})
+}
+
+func TestRequiresAndProvides(t *testing.T) {
+	pkg := models.Package{
+		Name:         "hello",
+		Dependencies: []string{"libc6 (>= 2.34)", "python3:any | python3-minimal [amd64]"},
+		Provides:     []string{"greeter (= 1.0)"},
+		Metadata:     map[string]interface{}{"Pre-Depends": "dpkg (>= 1.19)"},
+	}
+
+	gen := &Generator{}
+	var requires []string
+	for _, alternatives := range gen.Requires(pkg) {
+		requires = append(requires, strings.Join(alternatives, "|"))
+	}
+	if got := strings.Join(requires, ","); got != "dpkg,libc6,python3|python3-minimal" {
+		t.Errorf("Requires = %s", got)
+	}
+	if got := strings.Join(gen.Provides(pkg), ","); got != "hello,greeter" {
+		t.Errorf("Provides = %s", got)
+	}
+}
@@ -0,0 +1,252 @@
+package deb
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/ralt/repogen/internal/signer"
+)
+
+// policy is how an apt release treats a weak but recognized algorithm
+type policy int
+
+const (
+	accept policy = iota
+	warn
+	refuse
+)
+
+// aptClient describes the signature acceptance rules of the apt (and gpgv
+// or sqv) shipped by a distribution release
+type aptClient struct {
+	name        string
+	release     string
+	sha1Digest  policy // Signatures over SHA1 (or RIPEMD160) digests
+	minRSABits  int    // Shorter RSA keys are refused
+	ecc         bool   // ECDSA and EdDSA keys are understood (GnuPG >= 2.1)
+	dsa         bool   // DSA keys are accepted
+	sha1Binding bool   // Keys bound by SHA1 self-signatures are accepted
+}
+
+// aptClients are the releases the preflight knows, oldest first
+var aptClients = []aptClient{
+	{name: "ubuntu-16.04", release: "Ubuntu 16.04 (xenial)", sha1Digest: warn, dsa: true, sha1Binding: true},
+	{name: "debian-9", release: "Debian 9 (stretch)", sha1Digest: refuse, ecc: true, dsa: true, sha1Binding: true},
+	{name: "ubuntu-18.04", release: "Ubuntu 18.04 (bionic)", sha1Digest: refuse, ecc: true, dsa: true, sha1Binding: true},
+	{name: "debian-10", release: "Debian 10 (buster)", sha1Digest: refuse, ecc: true, dsa: true, sha1Binding: true},
+	{name: "ubuntu-20.04", release: "Ubuntu 20.04 (focal)", sha1Digest: refuse, ecc: true, dsa: true, sha1Binding: true},
+	{name: "debian-11", release: "Debian 11 (bullseye)", sha1Digest: refuse, ecc: true, dsa: true, sha1Binding: true},
+	{name: "ubuntu-22.04", release: "Ubuntu 22.04 (jammy)", sha1Digest: refuse, ecc: true, dsa: true, sha1Binding: true},
+	{name: "debian-12", release: "Debian 12 (bookworm)", sha1Digest: refuse, ecc: true, dsa: true, sha1Binding: true},
+	// apt 2.7.13 and later assert ">=rsa2048,ed25519,ed448,nistp256,nistp384,nistp521"
+	{name: "ubuntu-24.04", release: "Ubuntu 24.04 (noble)", sha1Digest: refuse, minRSABits: 2048, ecc: true, sha1Binding: true},
+	// sqv's policy also rejects SHA1 binding signatures
+	{name: "debian-13", release: "Debian 13 (trixie)", sha1Digest: refuse, minRSABits: 2048, ecc: true},
+}
+
+// DefaultAptClients are the releases still supported by their distribution
+var DefaultAptClients = []string{"debian-11", "debian-12", "debian-13", "ubuntu-20.04", "ubuntu-22.04", "ubuntu-24.04"}
+
+// AptClientNames lists the releases the preflight knows
+func AptClientNames() []string {
+	names := make([]string, len(aptClients))
+	for i, c := range aptClients {
+		names[i] = c.name
+	}
+	return names
+}
+
+// ValidateAptClients checks that every name is a release the preflight knows
+func ValidateAptClients(names []string) error {
+	for _, name := range names {
+		if _, ok := findAptClient(name); !ok {
+			return fmt.Errorf("unknown apt client %q (known: %s)", name, strings.Join(AptClientNames(), ", "))
+		}
+	}
+	return nil
+}
+
+func findAptClient(name string) (aptClient, bool) {
+	for _, c := range aptClients {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return aptClient{}, false
+}
+
+// AptVerdict is how a client release would take the repository signatures
+type AptVerdict struct {
+	Client   string   // Release name, e.g. "debian-13"
+	Release  string   // Human readable release, e.g. "Debian 13 (trixie)"
+	Refused  bool     // apt update would fail
+	Problems []string // Why the signatures are refused, or the warnings apt prints
+}
+
+// signingKey is a key that made one of the probe signatures
+type signingKey struct {
+	sig         *packet.Signature
+	key         *packet.PublicKey
+	bindingHash crypto.Hash // Digest of the self-signature binding the key
+}
+
+// PreflightSignature signs a probe the way InRelease is signed and checks
+// it against the acceptance rules of each named apt client release. A
+// release accepts the repository when at least one signature passes, so
+// signing with several algorithms keeps older and newer clients working
+func PreflightSignature(s signer.Signer, clients []string) ([]AptVerdict, error) {
+	keys, err := probeSignature(s)
+	if err != nil {
+		return nil, err
+	}
+
+	verdicts := make([]AptVerdict, 0, len(clients))
+	for _, name := range clients {
+		client, ok := findAptClient(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown apt client %q", name)
+		}
+		verdicts = append(verdicts, client.verdict(keys))
+	}
+	return verdicts, nil
+}
+
+// probeSignature clearsigns a probe with s and returns the keys of its signatures
+func probeSignature(s signer.Signer) ([]signingKey, error) {
+	signed, err := s.SignCleartext([]byte("Origin: repogen preflight\n"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign preflight probe: %w", err)
+	}
+	block, _ := clearsign.Decode(signed)
+	if block == nil {
+		return nil, fmt.Errorf("preflight probe is not a cleartext signature")
+	}
+
+	armored, err := s.GetPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key: %w", err)
+	}
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+
+	var keys []signingKey
+	packets := packet.NewReader(block.ArmoredSignature.Body)
+	for {
+		p, err := packets.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read preflight signature: %w", err)
+		}
+		sig, ok := p.(*packet.Signature)
+		if !ok {
+			continue
+		}
+		key, err := issuer(entities, sig)
+		if err != nil {
+			return nil, err
+		}
+		key.sig = sig
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("preflight probe carries no signature")
+	}
+	return keys, nil
+}
+
+// issuer finds the published key that made sig
+func issuer(entities openpgp.EntityList, sig *packet.Signature) (signingKey, error) {
+	if sig.IssuerKeyId == nil {
+		return signingKey{}, fmt.Errorf("preflight signature does not name its key")
+	}
+	for _, entity := range entities {
+		if entity.PrimaryKey.KeyId == *sig.IssuerKeyId {
+			key := signingKey{key: entity.PrimaryKey}
+			if ident := entity.PrimaryIdentity(); ident != nil && ident.SelfSignature != nil {
+				key.bindingHash = ident.SelfSignature.Hash
+			}
+			return key, nil
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PublicKey.KeyId == *sig.IssuerKeyId {
+				key := signingKey{key: subkey.PublicKey}
+				if subkey.Sig != nil {
+					key.bindingHash = subkey.Sig.Hash
+				}
+				return key, nil
+			}
+		}
+	}
+	return signingKey{}, fmt.Errorf("preflight signature was made by key %016X, which is not in the published public key", *sig.IssuerKeyId)
+}
+
+// verdict applies the rules of c to the signatures, accepting the first
+// one that passes
+func (c aptClient) verdict(keys []signingKey) AptVerdict {
+	v := AptVerdict{Client: c.name, Release: c.release, Refused: true}
+	var refusals []string
+	for _, key := range keys {
+		problems, refused := c.check(key)
+		if !refused {
+			v.Refused = false
+			v.Problems = problems
+			return v
+		}
+		refusals = append(refusals, problems...)
+	}
+	v.Problems = refusals
+	return v
+}
+
+// check returns the problems apt reports with one signature, and whether
+// they make it refuse the signature
+func (c aptClient) check(key signingKey) (problems []string, refused bool) {
+	switch key.sig.Hash {
+	case crypto.MD5:
+		problems = append(problems, "signature uses weak digest algorithm (MD5)")
+		refused = true
+	case crypto.SHA1, crypto.RIPEMD160:
+		problems = append(problems, fmt.Sprintf("signature uses weak digest algorithm (%s)", key.sig.Hash))
+		refused = refused || c.sha1Digest == refuse
+	}
+
+	bits, _ := key.key.BitLength()
+	switch key.key.PubKeyAlgo {
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSASignOnly:
+		if int(bits) < c.minRSABits {
+			problems = append(problems, fmt.Sprintf("key uses weak algorithm (rsa%d)", bits))
+			refused = true
+		}
+	case packet.PubKeyAlgoDSA:
+		if !c.dsa {
+			problems = append(problems, fmt.Sprintf("key uses weak algorithm (dsa%d)", bits))
+			refused = true
+		}
+	case packet.PubKeyAlgoECDSA, packet.PubKeyAlgoEdDSA:
+		if !c.ecc {
+			problems = append(problems, "gpgv does not support elliptic curve keys")
+			refused = true
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("key algorithm %d cannot sign", key.key.PubKeyAlgo))
+		refused = true
+	}
+
+	if !c.sha1Binding && (key.bindingHash == crypto.SHA1 || key.bindingHash == crypto.MD5) {
+		problems = append(problems, fmt.Sprintf("key is bound by a %s self-signature", key.bindingHash))
+		refused = true
+	}
+
+	return problems, refused
+}
@@ -2,8 +2,11 @@ package deb
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,8 +30,28 @@ func GenerateReleaseFile(config *models.RepositoryConfig, files []ReleaseFileInf
 	fmt.Fprintf(&buf, "Suite: %s\n", config.Suite)
 	fmt.Fprintf(&buf, "Codename: %s\n", config.Codename)
 	fmt.Fprintf(&buf, "Architectures: %s\n", strings.Join(config.Arches, " "))
-	fmt.Fprintf(&buf, "Components: %s\n", strings.Join(config.Components, " "))
-	fmt.Fprintf(&buf, "Date: %s\n", time.Now().UTC().Format(time.RFC1123Z))
+	// Flat repositories have no components
+	if len(config.Components) > 0 {
+		fmt.Fprintf(&buf, "Components: %s\n", strings.Join(config.Components, " "))
+	}
+	now := utils.Timestamp(config)
+	fmt.Fprintf(&buf, "Date: %s\n", now.Format(time.RFC1123Z))
+	if config.ReleaseValidFor != "" {
+		validFor, err := ParseValidity(config.ReleaseValidFor)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "Valid-Until: %s\n", now.Add(validFor).Format(time.RFC1123Z))
+	}
+	if config.NotAutomatic {
+		buf.WriteString("NotAutomatic: yes\n")
+	}
+	if config.ButAutomaticUpgrades {
+		buf.WriteString("ButAutomaticUpgrades: yes\n")
+	}
+	if config.AcquireByHash {
+		buf.WriteString("Acquire-By-Hash: yes\n")
+	}
 
 	// MD5Sum section
 	buf.WriteString("MD5Sum:\n")
@@ -57,6 +80,48 @@ func GenerateReleaseFile(config *models.RepositoryConfig, files []ReleaseFileInf
 	return buf.Bytes(), nil
 }
 
+// ParseValidity parses how long a Release stays valid: a Go duration such
+// as "36h", or a number of days such as "14d"
+func ParseValidity(s string) (time.Duration, error) {
+	var validFor time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		validFor = time.Duration(n) * 24 * time.Hour
+	} else {
+		validFor, err = time.ParseDuration(s)
+	}
+	if err != nil || validFor <= 0 {
+		return 0, fmt.Errorf("invalid Release validity %q (expected e.g. 14d or 36h)", s)
+	}
+	return validFor, nil
+}
+
+// writeByHash copies the metadata files of a Release to by-hash/<hash
+// type>/<hash> next to them, so clients fetching them while the repository
+// is updated get consistent files. Earlier copies are kept for them
+func writeByHash(basePath string, files []ReleaseFileInfo) error {
+	for _, file := range files {
+		for _, hash := range []struct{ name, sum string }{
+			{"SHA256", file.Checksum.SHA256},
+			{"SHA512", file.Checksum.SHA512},
+		} {
+			dst := filepath.Join(basePath, filepath.Dir(file.Path), "by-hash", hash.name, hash.sum)
+			if _, err := os.Stat(dst); err == nil {
+				continue
+			}
+			if err := utils.EnsureDir(filepath.Dir(dst)); err != nil {
+				return err
+			}
+			if err := utils.CopyFile(context.Background(), filepath.Join(basePath, file.Path), dst); err != nil {
+				return fmt.Errorf("failed to write by-hash copy of %s: %w", file.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
 // CalculateReleaseFileInfos calculates checksums for all metadata files
 func CalculateReleaseFileInfos(basePath string, files []string) ([]ReleaseFileInfo, error) {
 	var infos []ReleaseFileInfo
@@ -0,0 +1,64 @@
+package deb
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/signer"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// KeyringDir is where apt clients install the keyrings of third-party
+// repositories, referenced by Signed-By
+const KeyringDir = "/etc/apt/keyrings"
+
+// SourcesFileName returns the name of the deb822 sources file of the
+// repository, to install in /etc/apt/sources.list.d
+func SourcesFileName(config *models.RepositoryConfig) string {
+	return utils.RepoSlug(config) + ".sources"
+}
+
+// KeyringPath returns where clients install the binary keyring published
+// under keys/
+func KeyringPath(config *models.RepositoryConfig) string {
+	return KeyringDir + "/" + utils.RepoSlug(config) + signer.KeyBinary
+}
+
+// generateSourcesFile creates the deb822 sources file of the repository at
+// config.BaseURL, listing every suite, and trusting only its own key when signed
+func generateSourcesFile(config *models.RepositoryConfig, isSigned bool) []byte {
+	var b strings.Builder
+	b.WriteString("Types: deb\n")
+	fmt.Fprintf(&b, "URIs: %s\n", strings.TrimSuffix(config.BaseURL, "/"))
+	if config.DebLayout == LayoutFlat {
+		// "deb URL ./": flat repositories have no components
+		b.WriteString("Suites: ./\n")
+	} else {
+		fmt.Fprintf(&b, "Suites: %s\n", strings.Join(Codenames(config.Codename), " "))
+		fmt.Fprintf(&b, "Components: %s\n", strings.Join(config.Components, " "))
+	}
+	if len(config.Arches) > 0 {
+		fmt.Fprintf(&b, "Architectures: %s\n", strings.Join(config.Arches, " "))
+	}
+	if isSigned {
+		fmt.Fprintf(&b, "Signed-By: %s\n", KeyringPath(config))
+	}
+	return []byte(b.String())
+}
+
+// writeSourcesFile writes the sources file of the repository when it has a
+// base URL
+func (g *Generator) writeSourcesFile(config *models.RepositoryConfig) error {
+	if config.BaseURL == "" {
+		return nil
+	}
+	path := filepath.Join(config.OutputDir, SourcesFileName(config))
+	if err := utils.WriteFile(path, generateSourcesFile(config, g.signer != nil), 0644); err != nil {
+		return fmt.Errorf("failed to write sources file: %w", err)
+	}
+	logrus.Infof("Apt sources file written to: %s", path)
+	return nil
+}
@@ -0,0 +1,46 @@
+package deb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Codenames splits the comma-separated codenames of the suites a
+// repository is published in
+func Codenames(codename string) []string {
+	var codenames []string
+	for _, c := range strings.Split(codename, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			codenames = append(codenames, c)
+		}
+	}
+	return codenames
+}
+
+// ValidateCodenames checks the codenames of a repository, a suite name or a
+// flat layout only fitting a single suite
+func ValidateCodenames(codename, suite, layout string) error {
+	codenames := Codenames(codename)
+	if len(codenames) == 0 {
+		return fmt.Errorf("a Debian codename is required")
+	}
+	seen := make(map[string]bool)
+	for _, c := range codenames {
+		if strings.ContainsAny(c, "/ ") {
+			return fmt.Errorf("invalid Debian codename %q", c)
+		}
+		if seen[c] {
+			return fmt.Errorf("codename %s is given twice", c)
+		}
+		seen[c] = true
+	}
+	if len(codenames) > 1 {
+		if suite != "" {
+			return fmt.Errorf("--suite can't be used with several codenames, each suite is named after its codename")
+		}
+		if layout == LayoutFlat {
+			return fmt.Errorf("flat Debian repositories have a single suite")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package deb
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// upstreamSuffixes are the compressions of Packages indexes read from
+// upstream, in order of preference
+var upstreamSuffixes = []string{".xz", ".zst", ".gz", ""}
+
+// ReadUpstream reads the Release file of each suite of config, and the
+// Packages indexes it lists for the components and architectures of config,
+// checked against their SHA256 in the Release file
+func (g *Generator) ReadUpstream(ctx context.Context, config *models.RepositoryConfig, upstream generator.Upstream) ([]models.Package, error) {
+	var allPackages []models.Package
+	seen := make(map[string]bool)
+
+	for _, codename := range Codenames(config.Codename) {
+		releasePath := path.Join("dists", codename, "Release")
+		release, err := upstream.Get(ctx, releasePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", releasePath, err)
+		}
+		if upstream.Verifies() {
+			sig, err := upstream.Get(ctx, releasePath+".gpg")
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch %s.gpg: %w", releasePath, err)
+			}
+			if err := upstream.Verify(release, sig); err != nil {
+				return nil, fmt.Errorf("%s: %w", releasePath, err)
+			}
+		}
+
+		sums := releaseChecksums(release)
+		for _, comp := range config.Components {
+			for _, arch := range config.Arches {
+				index := path.Join(comp, "binary-"+arch, "Packages")
+				packages, err := readUpstreamPackages(ctx, upstream, path.Join("dists", codename), index, sums)
+				if err != nil {
+					return nil, err
+				}
+
+				// Suites share the pool, so list each of its packages once
+				for _, pkg := range packages {
+					if !seen[pkg.Filename] {
+						seen[pkg.Filename] = true
+						pkg.Metadata["Component"] = comp
+						allPackages = append(allPackages, pkg)
+					}
+				}
+			}
+		}
+	}
+
+	return allPackages, nil
+}
+
+// releaseChecksums returns the SHA256 of the files listed by a Release file
+func releaseChecksums(release []byte) map[string]string {
+	sums := make(map[string]string)
+	inSHA256 := false
+	for _, line := range strings.Split(string(release), "\n") {
+		if !strings.HasPrefix(line, " ") {
+			inSHA256 = strings.TrimSpace(line) == "SHA256:"
+			continue
+		}
+		if fields := strings.Fields(line); inSHA256 && len(fields) == 3 {
+			sums[fields[2]] = fields[0]
+		}
+	}
+	return sums
+}
+
+// readUpstreamPackages fetches the Packages index below suiteDir in the
+// first compression the Release file lists. Components and architectures
+// the suite doesn't publish have no packages
+func readUpstreamPackages(ctx context.Context, upstream generator.Upstream, suiteDir, index string, sums map[string]string) ([]models.Package, error) {
+	for _, suffix := range upstreamSuffixes {
+		want, ok := sums[index+suffix]
+		if !ok {
+			continue
+		}
+
+		indexPath := path.Join(suiteDir, index+suffix)
+		data, err := upstream.Get(ctx, indexPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", indexPath, err)
+		}
+		sum := sha256.Sum256(data)
+		if got := hex.EncodeToString(sum[:]); got != want {
+			return nil, fmt.Errorf("checksum mismatch for %s: Release lists sha256 %s, got %s", indexPath, want, got)
+		}
+
+		data, err = utils.Decompress(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %w", indexPath, err)
+		}
+		return parsePackagesReader(bytes.NewReader(data))
+	}
+	return nil, nil
+}
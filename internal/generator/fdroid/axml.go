@@ -0,0 +1,271 @@
+package fdroid
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Chunk types of Android binary XML, as compiled by aapt into
+// AndroidManifest.xml
+const (
+	chunkStringPool   = 0x0001
+	chunkXML          = 0x0003
+	chunkStartElement = 0x0102
+	chunkEndElement   = 0x0103
+	chunkResourceMap  = 0x0180
+)
+
+// Typed value types of attributes
+const (
+	typeString = 0x03
+	typeIntDec = 0x10
+	typeIntHex = 0x11
+)
+
+// Resource IDs of the android: attributes repogen reads. Shrinkers may strip
+// attribute names, which are then only known by their ID
+var attrIDs = map[string]uint32{
+	"label":            0x01010001,
+	"name":             0x01010003,
+	"minSdkVersion":    0x0101020c,
+	"versionCode":      0x0101021b,
+	"versionName":      0x0101021c,
+	"targetSdkVersion": 0x01010270,
+	"maxSdkVersion":    0x01010271,
+}
+
+// xmlElement is an element of a binary XML document
+type xmlElement struct {
+	Name   string
+	Parent string // Name of the enclosing element
+	Attrs  []xmlAttr
+}
+
+// xmlAttr is an attribute of a binary XML element
+type xmlAttr struct {
+	Name     string
+	ID       uint32 // Resource ID of the name, 0 for non-android: attributes
+	Raw      string // Value as written, for string values
+	DataType uint8
+	Data     uint32
+}
+
+// attr returns the attribute called name, or nil
+func (e *xmlElement) attr(name string) *xmlAttr {
+	id := attrIDs[name]
+	for i := range e.Attrs {
+		a := &e.Attrs[i]
+		if id != 0 && a.ID == id || a.ID == 0 && a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// str returns the string value of the attribute called name. References to
+// resources (@string/app_name) aren't resolved and give ""
+func (e *xmlElement) str(name string) string {
+	if a := e.attr(name); a != nil {
+		return a.Raw
+	}
+	return ""
+}
+
+// int returns the integer value of the attribute called name, or 0
+func (e *xmlElement) int(name string) int64 {
+	a := e.attr(name)
+	if a == nil {
+		return 0
+	}
+	switch a.DataType {
+	case typeIntDec, typeIntHex:
+		return int64(int32(a.Data))
+	case typeString:
+		// Some build tools write numbers as strings
+		var n int64
+		if _, err := fmt.Sscan(a.Raw, &n); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+// parseBinaryXML returns the elements of a binary XML document, in
+// document order
+func parseBinaryXML(data []byte) ([]xmlElement, error) {
+	le := binary.LittleEndian
+	if len(data) < 8 || le.Uint16(data) != chunkXML {
+		return nil, fmt.Errorf("not a binary XML document")
+	}
+
+	var pool []string
+	var resourceIDs []uint32
+	var elements []xmlElement
+	var stack []string
+
+	headerSize := int(le.Uint16(data[2:]))
+	for offset := headerSize; offset+8 <= len(data); {
+		chunkType := le.Uint16(data[offset:])
+		chunkHeaderSize := int(le.Uint16(data[offset+2:]))
+		chunkSize := int(le.Uint32(data[offset+4:]))
+		if chunkSize < 8 || offset+chunkSize > len(data) || chunkHeaderSize > chunkSize {
+			return nil, fmt.Errorf("truncated chunk at offset %d", offset)
+		}
+		chunk := data[offset : offset+chunkSize]
+		offset += chunkSize
+
+		switch chunkType {
+		case chunkStringPool:
+			var err error
+			if pool, err = parseStringPool(chunk); err != nil {
+				return nil, err
+			}
+		case chunkResourceMap:
+			for i := chunkHeaderSize; i+4 <= len(chunk); i += 4 {
+				resourceIDs = append(resourceIDs, le.Uint32(chunk[i:]))
+			}
+		case chunkStartElement:
+			// Node header (line number, comment), then namespace and name
+			body := chunk[chunkHeaderSize:]
+			if len(body) < 20 {
+				return nil, fmt.Errorf("truncated element")
+			}
+			el := xmlElement{Name: lookup(pool, le.Uint32(body[4:]))}
+			if len(stack) > 0 {
+				el.Parent = stack[len(stack)-1]
+			}
+
+			attrStart := int(le.Uint16(body[8:]))
+			attrSize := int(le.Uint16(body[10:]))
+			attrCount := int(le.Uint16(body[12:]))
+			if attrSize < 20 || attrStart+attrSize*attrCount > len(body) {
+				return nil, fmt.Errorf("truncated attributes of <%s>", el.Name)
+			}
+			for i := 0; i < attrCount; i++ {
+				a := body[attrStart+i*attrSize:]
+				nameIndex := le.Uint32(a[4:])
+				attr := xmlAttr{
+					Name:     lookup(pool, nameIndex),
+					Raw:      lookup(pool, le.Uint32(a[8:])),
+					DataType: a[15],
+					Data:     le.Uint32(a[16:]),
+				}
+				if int(nameIndex) < len(resourceIDs) {
+					attr.ID = resourceIDs[nameIndex]
+				}
+				if attr.DataType == typeString && attr.Raw == "" {
+					attr.Raw = lookup(pool, attr.Data)
+				}
+				el.Attrs = append(el.Attrs, attr)
+			}
+
+			elements = append(elements, el)
+			stack = append(stack, el.Name)
+		case chunkEndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	return elements, nil
+}
+
+// lookup returns a string of the pool, or "" for the no-string index
+// 0xFFFFFFFF and indexes out of range
+func lookup(pool []string, index uint32) string {
+	if int64(index) >= int64(len(pool)) {
+		return ""
+	}
+	return pool[index]
+}
+
+// parseStringPool decodes a string pool chunk, in UTF-8 or UTF-16
+func parseStringPool(chunk []byte) ([]string, error) {
+	le := binary.LittleEndian
+	if len(chunk) < 28 {
+		return nil, fmt.Errorf("truncated string pool")
+	}
+	headerSize := int(le.Uint16(chunk[2:]))
+	count := int(le.Uint32(chunk[8:]))
+	isUTF8 := le.Uint32(chunk[16:])&(1<<8) != 0
+	stringsStart := int(le.Uint32(chunk[20:]))
+	if headerSize+4*count > len(chunk) {
+		return nil, fmt.Errorf("truncated string pool")
+	}
+
+	strs := make([]string, count)
+	for i := range strs {
+		pos := stringsStart + int(le.Uint32(chunk[headerSize+4*i:]))
+		var s string
+		var err error
+		if isUTF8 {
+			s, err = decodeUTF8(chunk, pos)
+		} else {
+			s, err = decodeUTF16(chunk, pos)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("string %d: %w", i, err)
+		}
+		strs[i] = s
+	}
+	return strs, nil
+}
+
+// decodeUTF8 decodes a UTF-8 pool string: its length in characters, then in
+// bytes, each on one or two bytes
+func decodeUTF8(chunk []byte, pos int) (string, error) {
+	length := func() (int, error) {
+		if pos >= len(chunk) {
+			return 0, fmt.Errorf("out of range")
+		}
+		n := int(chunk[pos])
+		pos++
+		if n&0x80 != 0 {
+			if pos >= len(chunk) {
+				return 0, fmt.Errorf("out of range")
+			}
+			n = (n&0x7f)<<8 | int(chunk[pos])
+			pos++
+		}
+		return n, nil
+	}
+	if _, err := length(); err != nil {
+		return "", err
+	}
+	n, err := length()
+	if err != nil {
+		return "", err
+	}
+	if pos+n > len(chunk) {
+		return "", fmt.Errorf("out of range")
+	}
+	return string(chunk[pos : pos+n]), nil
+}
+
+// decodeUTF16 decodes a UTF-16 pool string: its length in code units, on one
+// or two units
+func decodeUTF16(chunk []byte, pos int) (string, error) {
+	le := binary.LittleEndian
+	if pos+2 > len(chunk) {
+		return "", fmt.Errorf("out of range")
+	}
+	n := int(le.Uint16(chunk[pos:]))
+	pos += 2
+	if n&0x8000 != 0 {
+		if pos+2 > len(chunk) {
+			return "", fmt.Errorf("out of range")
+		}
+		n = (n&0x7fff)<<16 | int(le.Uint16(chunk[pos:]))
+		pos += 2
+	}
+	if pos+2*n > len(chunk) {
+		return "", fmt.Errorf("out of range")
+	}
+	units := make([]uint16, n)
+	for i := range units {
+		units[i] = le.Uint16(chunk[pos+2*i:])
+	}
+	return string(utf16.Decode(units)), nil
+}
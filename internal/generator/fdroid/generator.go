@@ -0,0 +1,437 @@
+package fdroid
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/signer"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Files of the repository index. F-Droid clients download the signed
+// entry.jar, then the index it names
+const (
+	indexFile = "index-v2.json"
+	entryFile = "entry.json"
+	entryJar  = "entry.jar"
+)
+
+// indexVersion is the version of the index-v2 format written
+const indexVersion = 20002
+
+// defaultLocale is the locale of texts repogen has no translation for
+const defaultLocale = "en-US"
+
+// localized is a text keyed by locale
+type localized map[string]string
+
+// indexV2 is the index-v2.json of a repository
+type indexV2 struct {
+	Repo     repoV2                `json:"repo"`
+	Packages map[string]*packageV2 `json:"packages"`
+}
+
+type repoV2 struct {
+	Name      localized `json:"name"`
+	Address   string    `json:"address"`
+	Timestamp int64     `json:"timestamp"`
+}
+
+// packageV2 is an app, with its versions keyed by the SHA-256 of their APK
+type packageV2 struct {
+	Metadata metadataV2           `json:"metadata"`
+	Versions map[string]versionV2 `json:"versions"`
+}
+
+type metadataV2 struct {
+	Added           int64     `json:"added"`
+	LastUpdated     int64     `json:"lastUpdated"`
+	Name            localized `json:"name,omitempty"`
+	Summary         localized `json:"summary,omitempty"`
+	License         string    `json:"license,omitempty"`
+	WebSite         string    `json:"webSite,omitempty"`
+	PreferredSigner string    `json:"preferredSigner,omitempty"`
+}
+
+type versionV2 struct {
+	Added    int64      `json:"added"`
+	File     fileV2     `json:"file"`
+	Manifest manifestV2 `json:"manifest"`
+}
+
+type fileV2 struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// manifestV2 is what the index tells of the AndroidManifest.xml of a version
+type manifestV2 struct {
+	VersionName         string         `json:"versionName"`
+	VersionCode         int64          `json:"versionCode"`
+	UsesSdk             *usesSdkV2     `json:"usesSdk,omitempty"`
+	MaxSdkVersion       int            `json:"maxSdkVersion,omitempty"`
+	Signer              *signerV2      `json:"signer,omitempty"`
+	UsesPermission      []permissionV2 `json:"usesPermission,omitempty"`
+	UsesPermissionSdk23 []permissionV2 `json:"usesPermissionSdk23,omitempty"`
+	Nativecode          []string       `json:"nativecode,omitempty"`
+	Features            []featureV2    `json:"features,omitempty"`
+}
+
+type usesSdkV2 struct {
+	MinSdkVersion    int `json:"minSdkVersion"`
+	TargetSdkVersion int `json:"targetSdkVersion"`
+}
+
+type signerV2 struct {
+	SHA256 []string `json:"sha256"`
+}
+
+type permissionV2 struct {
+	Name          string `json:"name"`
+	MaxSdkVersion int    `json:"maxSdkVersion,omitempty"`
+}
+
+type featureV2 struct {
+	Name string `json:"name"`
+}
+
+// entryV2 is the entry.json signed into entry.jar
+type entryV2 struct {
+	Timestamp int64        `json:"timestamp"`
+	Version   int          `json:"version"`
+	Index     entryIndexV2 `json:"index"`
+}
+
+type entryIndexV2 struct {
+	Name        string `json:"name"`
+	SHA256      string `json:"sha256"`
+	Size        int64  `json:"size"`
+	NumPackages int    `json:"numPackages"`
+}
+
+// Generator implements the generator.Generator interface for F-Droid repositories
+type Generator struct {
+	rsaSigner signer.RSASigner
+	keyName   string
+}
+
+// NewGenerator creates a new F-Droid generator, signing the index with the
+// RSA key also used for Alpine repositories
+func NewGenerator(rsaSigner signer.RSASigner, keyName string) generator.Generator {
+	return &Generator{
+		rsaSigner: rsaSigner,
+		keyName:   keyName,
+	}
+}
+
+// Generate copies the APKs to the repository and writes its index-v2.json,
+// with the entry.jar signing it
+func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfig, packages []models.Package) error {
+	logrus.Info("Generating F-Droid repository...")
+
+	// The index tells clients the canonical address of the repository
+	if config.BaseURL == "" {
+		return fmt.Errorf("F-Droid repositories require --base-url")
+	}
+
+	// Versions keep the time they were first published at
+	previous, err := readIndex(config.OutputDir)
+	if err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("Ignoring unreadable %s: %v", indexFile, err)
+	}
+	now := utils.Timestamp(config).UnixMilli()
+
+	name := config.Label
+	if name == "" {
+		name = config.Origin
+	}
+	index := &indexV2{
+		Repo: repoV2{
+			Name:      localized{defaultLocale: name},
+			Address:   strings.TrimSuffix(config.BaseURL, "/"),
+			Timestamp: now,
+		},
+		Packages: make(map[string]*packageV2),
+	}
+
+	published := make(map[string]string)
+	newest := make(map[string]*models.Package)
+	for i := range packages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pkg := &packages[i]
+		if err := publishPackage(ctx, config, pkg); err != nil {
+			return fmt.Errorf("failed to publish %s: %w", pkg.Name, err)
+		}
+
+		// Splits of a version must have their own versionCode
+		if sum, ok := published[pkg.Filename]; ok && sum != pkg.SHA256Sum {
+			return fmt.Errorf("%s is the file name of two different APKs", pkg.Filename)
+		}
+		published[pkg.Filename] = pkg.SHA256Sum
+
+		mf, ok := pkg.Metadata["Manifest"].(*manifestV2)
+		if !ok {
+			return fmt.Errorf("package %s has no manifest", pkg.Name)
+		}
+		version := versionV2{
+			Added:    now,
+			File:     fileV2{Name: "/" + pkg.Filename, SHA256: pkg.SHA256Sum, Size: pkg.Size},
+			Manifest: *mf,
+		}
+		if previous != nil {
+			if app, ok := previous.Packages[pkg.Name]; ok {
+				if v, ok := app.Versions[pkg.SHA256Sum]; ok {
+					version.Added = v.Added
+				}
+			}
+		}
+
+		app, ok := index.Packages[pkg.Name]
+		if !ok {
+			app = &packageV2{Versions: make(map[string]versionV2)}
+			index.Packages[pkg.Name] = app
+		}
+		app.Versions[pkg.SHA256Sum] = version
+		if n, ok := newest[pkg.Name]; !ok || versionCode(*pkg) > versionCode(*n) {
+			newest[pkg.Name] = pkg
+		}
+	}
+	for id, app := range index.Packages {
+		app.Metadata = appMetadata(*newest[id], app.Versions)
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if err := utils.WriteFile(filepath.Join(config.OutputDir, indexFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", indexFile, err)
+	}
+
+	sum := sha256.Sum256(data)
+	entry, err := json.MarshalIndent(entryV2{
+		Timestamp: now,
+		Version:   indexVersion,
+		Index: entryIndexV2{
+			Name:        "/" + indexFile,
+			SHA256:      hex.EncodeToString(sum[:]),
+			Size:        int64(len(data)),
+			NumPackages: len(index.Packages),
+		},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	entry = append(entry, '\n')
+	if err := utils.WriteFile(filepath.Join(config.OutputDir, entryFile), entry, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", entryFile, err)
+	}
+
+	if g.rsaSigner != nil {
+		if err := g.signEntry(config.OutputDir, entry); err != nil {
+			return fmt.Errorf("failed to sign %s: %w", entryFile, err)
+		}
+	} else {
+		logrus.Warn("F-Droid clients only accept signed repositories, use --rsa-key to write entry.jar")
+	}
+
+	logrus.Infof("F-Droid repository generated successfully (%d apps, %d APKs)", len(index.Packages), len(packages))
+	return nil
+}
+
+// signEntry writes entry.jar, the signed entry.json
+func (g *Generator) signEntry(outputDir string, entry []byte) error {
+	cert, err := repoCertificate(g.rsaSigner, g.keyName)
+	if err != nil {
+		return fmt.Errorf("failed to create the repository certificate: %w", err)
+	}
+	jar, err := signJar(g.rsaSigner, cert, []string{entryFile}, map[string][]byte{entryFile: entry})
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFile(filepath.Join(outputDir, entryJar), jar, 0644); err != nil {
+		return err
+	}
+
+	// Users add the repository with its fingerprint, so that the client
+	// trusts this key only
+	logrus.Infof("F-Droid repository fingerprint: %s", strings.ToUpper(certificateFingerprint(cert)))
+	return nil
+}
+
+// appMetadata returns the metadata of an app, described by its newest
+// version
+func appMetadata(newest models.Package, versions map[string]versionV2) metadataV2 {
+	var meta metadataV2
+	for _, v := range versions {
+		if meta.Added == 0 || v.Added < meta.Added {
+			meta.Added = v.Added
+		}
+		if v.Added > meta.LastUpdated {
+			meta.LastUpdated = v.Added
+		}
+	}
+
+	if label, _ := newest.Metadata["Label"].(string); label != "" {
+		meta.Name = localized{defaultLocale: label}
+	}
+	// Summaries are the synopsis of the descriptions, their first line
+	texts := make(map[string]string)
+	if newest.Description != "" {
+		texts[defaultLocale] = newest.Description
+	}
+	for lang, text := range newest.Translations {
+		texts[strings.ReplaceAll(lang, "_", "-")] = text
+	}
+	for locale, text := range texts {
+		if meta.Summary == nil {
+			meta.Summary = make(localized)
+		}
+		meta.Summary[locale], _, _ = strings.Cut(text, "\n")
+	}
+	meta.License = newest.License
+	meta.WebSite = newest.Homepage
+	if mf := versions[newest.SHA256Sum].Manifest; mf.Signer != nil && len(mf.Signer.SHA256) > 0 {
+		meta.PreferredSigner = mf.Signer.SHA256[0]
+	}
+	return meta
+}
+
+// versionCode returns the versionCode of an APK
+func versionCode(pkg models.Package) int64 {
+	code, _ := pkg.Metadata["VersionCode"].(int64)
+	return code
+}
+
+// publishPackage copies an APK to <package>_<versionCode>.apk
+func publishPackage(ctx context.Context, config *models.RepositoryConfig, pkg *models.Package) error {
+	rel := fileName(*pkg)
+	dstPath := filepath.Join(config.OutputDir, rel)
+
+	// APKs read back from the index are published already, possibly only on
+	// remote storage
+	srcPath, finalDstPath, needsCopy, err := utils.ShouldCopyPackage(pkg, dstPath, config.OutputDir)
+	if err != nil {
+		return fmt.Errorf("package copy check failed: %w", err)
+	}
+
+	if needsCopy {
+		logrus.Debugf("Copying package: %s -> %s", srcPath, finalDstPath)
+
+		checksums, err := utils.CopyFileWithChecksums(ctx, srcPath, finalDstPath)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", srcPath, err)
+		}
+
+		if config.VerifyWrites {
+			if err := utils.VerifyCopy(finalDstPath, checksums); err != nil {
+				return fmt.Errorf("failed to verify %s: %w", srcPath, err)
+			}
+		}
+
+		pkg.Size = checksums.Size
+		pkg.MD5Sum = checksums.MD5
+		pkg.SHA1Sum = checksums.SHA1
+		pkg.SHA256Sum = checksums.SHA256
+	}
+
+	pkg.Filename = rel
+	return nil
+}
+
+// readIndex reads the index-v2.json of a repository
+func readIndex(outputDir string) (*indexV2, error) {
+	data, err := os.ReadFile(filepath.Join(outputDir, indexFile))
+	if err != nil {
+		return nil, err
+	}
+	var index indexV2
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// ValidatePackages checks if packages are Android APKs
+func (g *Generator) ValidatePackages(packages []models.Package) error {
+	for _, pkg := range packages {
+		if _, ok := pkg.Metadata["Manifest"].(*manifestV2); !ok {
+			return fmt.Errorf("package %s is not an Android APK", pkg.Filename)
+		}
+	}
+	return nil
+}
+
+// GetSupportedType returns the package type this generator supports
+func (g *Generator) GetSupportedType() scanner.PackageType {
+	return scanner.TypeFdroid
+}
+
+// ParseExistingMetadata reads the APKs listed in index-v2.json
+func (g *Generator) ParseExistingMetadata(config *models.RepositoryConfig) ([]models.Package, error) {
+	index, err := readIndex(config.OutputDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no existing F-Droid repository found in %s", config.OutputDir)
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", indexFile, err)
+	}
+
+	ids := make([]string, 0, len(index.Packages))
+	for id := range index.Packages {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var packages []models.Package
+	for _, id := range ids {
+		app := index.Packages[id]
+		for _, v := range app.Versions {
+			mf := v.Manifest
+			pkg := manifestPackage(id, &mf)
+			pkg.Filename = strings.TrimPrefix(v.File.Name, "/")
+			pkg.Size = v.File.Size
+			pkg.SHA256Sum = v.File.SHA256
+			pkg.Metadata["Label"] = app.Metadata.Name[defaultLocale]
+			pkg.Description = app.Metadata.Summary[defaultLocale]
+			pkg.License = app.Metadata.License
+			pkg.Homepage = app.Metadata.WebSite
+			for locale, text := range app.Metadata.Summary {
+				if locale == defaultLocale {
+					continue
+				}
+				if pkg.Translations == nil {
+					pkg.Translations = make(map[string]string)
+				}
+				pkg.Translations[strings.ReplaceAll(locale, "-", "_")] = text
+			}
+			packages = append(packages, *pkg)
+		}
+	}
+
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no existing F-Droid repository found in %s", config.OutputDir)
+	}
+
+	return packages, nil
+}
+
+// PackageFiles returns the path of an APK
+func (g *Generator) PackageFiles(config *models.RepositoryConfig, pkg models.Package) []string {
+	return []string{filepath.Join(config.OutputDir, fileName(pkg))}
+}
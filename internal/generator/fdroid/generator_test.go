@@ -0,0 +1,498 @@
+package fdroid
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/signer"
+)
+
+// node is an element of a manifest to compile into binary XML
+type node struct {
+	name     string
+	attrs    []attr
+	children []node
+}
+
+// attr is a string attribute, or an integer one when str is empty
+type attr struct {
+	name string
+	str  string
+	num  int
+}
+
+// compileManifest compiles a manifest the way aapt does: a string pool
+// starting with the android: attribute names, the resource map of their IDs,
+// then a chunk per element start and end. With strip, android: attribute
+// names are left out of the pool, as shrinkers do
+func compileManifest(root node, utf8, strip bool) []byte {
+	le := binary.LittleEndian
+
+	// android: attribute names come first, their index is their position in
+	// the resource map
+	var pool []string
+	var ids []uint32
+	index := make(map[string]uint32)
+	var walk func(n node, android bool)
+	walk = func(n node, android bool) {
+		for _, a := range n.attrs {
+			if _, ok := attrIDs[a.name]; ok == android {
+				if _, ok := index[a.name]; !ok {
+					index[a.name] = uint32(len(pool))
+					if android {
+						ids = append(ids, attrIDs[a.name])
+						if strip {
+							pool = append(pool, "")
+							continue
+						}
+					}
+					pool = append(pool, a.name)
+				}
+			}
+			if a.str != "" {
+				if _, ok := index["="+a.str]; !ok && !android {
+					index["="+a.str] = uint32(len(pool))
+					pool = append(pool, a.str)
+				}
+			}
+		}
+		if !android {
+			if _, ok := index[n.name]; !ok {
+				index[n.name] = uint32(len(pool))
+				pool = append(pool, n.name)
+			}
+		}
+		for _, c := range n.children {
+			walk(c, android)
+		}
+	}
+	walk(root, true)
+	walk(root, false)
+
+	var strs []byte
+	var offsets []byte
+	for _, s := range pool {
+		offsets = le.AppendUint32(offsets, uint32(len(strs)))
+		if utf8 {
+			strs = append(strs, byte(len(s)), byte(len(s)))
+			strs = append(strs, s...)
+			strs = append(strs, 0)
+		} else {
+			units := utf16.Encode([]rune(s))
+			strs = le.AppendUint16(strs, uint16(len(units)))
+			for _, u := range units {
+				strs = le.AppendUint16(strs, u)
+			}
+			strs = le.AppendUint16(strs, 0)
+		}
+	}
+	for len(strs)%4 != 0 {
+		strs = append(strs, 0)
+	}
+	var flags uint32
+	if utf8 {
+		flags = 1 << 8
+	}
+
+	chunk := func(chunkType uint16, headerSize int, body ...[]byte) []byte {
+		var b []byte
+		for _, part := range body {
+			b = append(b, part...)
+		}
+		c := le.AppendUint16(nil, chunkType)
+		c = le.AppendUint16(c, uint16(headerSize))
+		c = le.AppendUint32(c, uint32(8+len(b)))
+		return append(c, b...)
+	}
+	u32 := func(values ...uint32) []byte {
+		var b []byte
+		for _, v := range values {
+			b = le.AppendUint32(b, v)
+		}
+		return b
+	}
+
+	body := chunk(chunkStringPool, 28,
+		u32(uint32(len(pool)), 0, flags, uint32(28+len(offsets)), 0), offsets, strs)
+	body = append(body, chunk(chunkResourceMap, 8, u32(ids...))...)
+
+	var element func(n node)
+	element = func(n node) {
+		start := u32(1, 0xffffffff, 0xffffffff, index[n.name])
+		start = append(start, 20, 0, 20, 0, byte(len(n.attrs)), 0, 0, 0, 0, 0, 0, 0)
+		for _, a := range n.attrs {
+			start = append(start, u32(0xffffffff, index[a.name])...)
+			if a.str != "" {
+				start = append(start, u32(index["="+a.str], 0x03000008, index["="+a.str])...)
+			} else {
+				start = append(start, u32(0xffffffff, 0x10000008, uint32(a.num))...)
+			}
+		}
+		body = append(body, chunk(chunkStartElement, 16, start)...)
+		for _, c := range n.children {
+			element(c)
+		}
+		body = append(body, chunk(chunkEndElement, 16, u32(1, 0xffffffff, 0xffffffff, index[n.name]))...)
+	}
+	element(root)
+
+	return chunk(chunkXML, 8, body)
+}
+
+// helloManifest returns the manifest of a version of org.example.hello
+func helloManifest(code int, name string) node {
+	return node{
+		name: "manifest",
+		attrs: []attr{
+			{name: "versionCode", num: code},
+			{name: "versionName", str: name},
+			{name: "package", str: "org.example.hello"},
+		},
+		children: []node{
+			{name: "uses-sdk", attrs: []attr{{name: "minSdkVersion", num: 24}, {name: "targetSdkVersion", num: 34}}},
+			{name: "uses-permission", attrs: []attr{{name: "name", str: "android.permission.INTERNET"}}},
+			{name: "application", attrs: []attr{{name: "label", str: "Hello"}}, children: []node{
+				{name: "activity", attrs: []attr{{name: "name", str: ".MainActivity"}}},
+			}},
+		},
+	}
+}
+
+// writeAPK writes an APK holding the given files, with an APK Signing Block
+// carrying cert when it isn't nil
+func writeAPK(t *testing.T, path string, files map[string][]byte, cert []byte) {
+	t.Helper()
+
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for name, data := range files {
+		fw, _ := w.Create(name)
+		fw.Write(data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := b.Bytes()
+
+	if cert != nil {
+		lp := func(parts ...[]byte) []byte {
+			var v []byte
+			for _, p := range parts {
+				v = binary.LittleEndian.AppendUint32(v, uint32(len(p)))
+				v = append(v, p...)
+			}
+			return v
+		}
+		signedData := append(lp(nil), lp(lp(cert))...)
+		signedData = append(signedData, lp(nil)...)
+		value := lp(lp(append(append(lp(signedData), lp(nil)...), lp(nil)...)))
+
+		pairs := binary.LittleEndian.AppendUint64(nil, uint64(4+len(value)))
+		pairs = binary.LittleEndian.AppendUint32(pairs, apkSigV2)
+		pairs = append(pairs, value...)
+		size := uint64(len(pairs) + 8 + 16)
+		block := binary.LittleEndian.AppendUint64(nil, size)
+		block = append(block, pairs...)
+		block = binary.LittleEndian.AppendUint64(block, size)
+		block = append(block, apkSigBlockMagic...)
+
+		// The block goes right before the central directory
+		eocd := bytes.LastIndex(data, []byte{0x50, 0x4b, 0x05, 0x06})
+		cd := binary.LittleEndian.Uint32(data[eocd+16:])
+		signed := append(append(append([]byte{}, data[:cd]...), block...), data[cd:]...)
+		binary.LittleEndian.PutUint32(signed[eocd+len(block)+16:], cd+uint32(len(block)))
+		data = signed
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newSigner returns an RSA signer of a fresh key, and the key
+func newSigner(t *testing.T) (signer.RSASigner, *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "repo.rsa")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := signer.NewAlpineRSASigner(keyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, key
+}
+
+func TestParsePackage(t *testing.T) {
+	rsaSigner, _ := newSigner(t)
+	cert, err := repoCertificate(rsaSigner, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		utf8, strip bool
+	}{
+		{"utf16", false, false},
+		{"utf8", true, false},
+		{"stripped names", true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "hello.apk")
+			writeAPK(t, path, map[string][]byte{
+				manifestFile:                  compileManifest(helloManifest(3, "1.2"), tc.utf8, tc.strip),
+				"classes.dex":                 []byte("dex\n035"),
+				"lib/arm64-v8a/libhello.so":   []byte("\x7fELF"),
+				"lib/armeabi-v7a/libhello.so": []byte("\x7fELF"),
+				"res/drawable/icon.png":       []byte("PNG"),
+			}, cert)
+
+			pkg, err := ParsePackage(path)
+			if err != nil {
+				t.Fatalf("ParsePackage failed: %v", err)
+			}
+			if pkg.Name != "org.example.hello" || pkg.Version != "1.2" || pkg.Metadata["Label"] != "Hello" {
+				t.Errorf("unexpected package %+v", pkg)
+			}
+			if pkg.Architecture != "arm64-v8a,armeabi-v7a" || fileName(*pkg) != "org.example.hello_3.apk" {
+				t.Errorf("unexpected package %+v", pkg)
+			}
+
+			mf := pkg.Metadata["Manifest"].(*manifestV2)
+			if mf.VersionCode != 3 || mf.UsesSdk == nil || mf.UsesSdk.MinSdkVersion != 24 || mf.UsesSdk.TargetSdkVersion != 34 {
+				t.Errorf("unexpected manifest %+v", mf)
+			}
+			if len(mf.UsesPermission) != 1 || mf.UsesPermission[0].Name != "android.permission.INTERNET" {
+				t.Errorf("unexpected permissions %+v", mf.UsesPermission)
+			}
+			if mf.Signer == nil || mf.Signer.SHA256[0] != certificateFingerprint(cert) {
+				t.Errorf("unexpected signer %+v", mf.Signer)
+			}
+		})
+	}
+}
+
+func TestParsePackageJarSignature(t *testing.T) {
+	rsaSigner, _ := newSigner(t)
+	cert, err := repoCertificate(rsaSigner, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An APK signed with the v1 scheme only is a signed JAR
+	manifest := compileManifest(helloManifest(1, "1.0"), false, false)
+	jar, err := signJar(rsaSigner, cert, []string{manifestFile}, map[string][]byte{manifestFile: manifest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "hello.apk")
+	if err := os.WriteFile(path, jar, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg, err := ParsePackage(path)
+	if err != nil {
+		t.Fatalf("ParsePackage failed: %v", err)
+	}
+	mf := pkg.Metadata["Manifest"].(*manifestV2)
+	if mf.Signer == nil || mf.Signer.SHA256[0] != certificateFingerprint(cert) {
+		t.Errorf("unexpected signer %+v", mf.Signer)
+	}
+	if pkg.Architecture != "noarch" {
+		t.Errorf("unexpected architecture %s", pkg.Architecture)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputDir := filepath.Join(tmpDir, "output")
+	rsaSigner, key := newSigner(t)
+
+	var packages []models.Package
+	for code, name := range []string{"1.0", "1.1"} {
+		code++
+		path := filepath.Join(tmpDir, name+".apk")
+		writeAPK(t, path, map[string][]byte{manifestFile: compileManifest(helloManifest(code, name), true, false)}, nil)
+		pkg, err := ParsePackage(path)
+		if err != nil {
+			t.Fatalf("ParsePackage failed: %v", err)
+		}
+		pkg.Description = "Says hello"
+		pkg.Translations = map[string]string{"pt_BR": "Diz olá\nUm programa simpático."}
+		packages = append(packages, *pkg)
+	}
+
+	gen := NewGenerator(rsaSigner, "test")
+	config := &models.RepositoryConfig{OutputDir: outputDir, Label: "Example"}
+	if err := gen.Generate(context.Background(), config, packages); err == nil {
+		t.Fatal("Generate accepted a repository without --base-url")
+	}
+	config.BaseURL = "https://example.com/fdroid/repo/"
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	index, err := readIndex(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Repo.Address != "https://example.com/fdroid/repo" || index.Repo.Name[defaultLocale] != "Example" {
+		t.Errorf("unexpected repo %+v", index.Repo)
+	}
+	app := index.Packages["org.example.hello"]
+	if app == nil || len(app.Versions) != 2 {
+		t.Fatalf("unexpected packages %+v", index.Packages)
+	}
+	if app.Metadata.Name[defaultLocale] != "Hello" || app.Metadata.Summary["pt-BR"] != "Diz olá" {
+		t.Errorf("unexpected metadata %+v", app.Metadata)
+	}
+	v := app.Versions[packages[1].SHA256Sum]
+	if v.File.Name != "/org.example.hello_2.apk" || v.Manifest.VersionName != "1.1" {
+		t.Errorf("unexpected version %+v", v)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "org.example.hello_2.apk")); err != nil {
+		t.Errorf("APK not published: %v", err)
+	}
+
+	// entry.json names the index, and entry.jar signs entry.json
+	entry := readJar(t, filepath.Join(outputDir, entryJar))
+	var e entryV2
+	if err := json.Unmarshal(entry[entryFile], &e); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(outputDir, indexFile))
+	sum := sha256.Sum256(data)
+	if e.Index.Name != "/index-v2.json" || e.Index.SHA256 != hex.EncodeToString(sum[:]) || e.Index.NumPackages != 1 {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	verifyJar(t, entry, &key.PublicKey)
+
+	// Regenerating keeps the certificate, and when versions were added
+	fingerprint := certificateFingerprint(entry["META-INF/REPOGEN.CERT"])
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 2 || existing[0].Translations["pt_BR"] != "Diz olá" {
+		t.Fatalf("unexpected packages %+v", existing)
+	}
+	if err := gen.Generate(context.Background(), config, existing[:1]); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	regenerated, err := readIndex(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := regenerated.Packages["org.example.hello"]
+	if len(kept.Versions) != 1 {
+		t.Fatalf("unexpected versions %+v", kept.Versions)
+	}
+	for sum, v := range kept.Versions {
+		if v.Added != app.Versions[sum].Added {
+			t.Errorf("version %s added at %d, was %d", sum, v.Added, app.Versions[sum].Added)
+		}
+	}
+	if certificateFingerprint(readJar(t, filepath.Join(outputDir, entryJar))["META-INF/REPOGEN.CERT"]) != fingerprint {
+		t.Error("the repository certificate changed")
+	}
+}
+
+// readJar returns the files of a JAR, with the certificate of its signature
+// as META-INF/REPOGEN.CERT
+func readJar(t *testing.T, path string) map[string][]byte {
+	t.Helper()
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	cert, err := pkcs7Certificate(files["META-INF/REPOGEN.RSA"])
+	if err != nil {
+		t.Fatalf("invalid signature block: %v", err)
+	}
+	files["META-INF/REPOGEN.CERT"] = cert
+	return files
+}
+
+// verifyJar checks the digests and signature of a JAR, as Android's
+// JarVerifier does
+func verifyJar(t *testing.T, files map[string][]byte, pub *rsa.PublicKey) {
+	t.Helper()
+
+	digest := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+	manifest := string(files["META-INF/MANIFEST.MF"])
+	sf := string(files["META-INF/REPOGEN.SF"])
+	if !strings.Contains(manifest, "Name: entry.json\r\nSHA-256-Digest: "+digest(files[entryFile])+"\r\n") {
+		t.Errorf("manifest lacks the entry.json digest:\n%s", manifest)
+	}
+	if !strings.Contains(sf, "SHA-256-Digest-Manifest: "+digest([]byte(manifest))+"\r\n") {
+		t.Errorf("signature file lacks the manifest digest:\n%s", sf)
+	}
+
+	cert, err := x509.ParseCertificate(files["META-INF/REPOGEN.CERT"])
+	if err != nil {
+		t.Fatalf("invalid certificate: %v", err)
+	}
+	if !cert.PublicKey.(*rsa.PublicKey).Equal(pub) || cert.Subject.CommonName != "test" {
+		t.Errorf("unexpected certificate %+v", cert.Subject)
+	}
+	// Go refuses to check SHA-1 signatures, so check the RSA ones directly
+	tbs := sha1.Sum(cert.RawTBSCertificate)
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA1, tbs[:], cert.Signature); err != nil {
+		t.Errorf("certificate signature doesn't verify: %v", err)
+	}
+
+	var ci contentInfo
+	var sd signedData
+	if _, err := asn1.Unmarshal(files["META-INF/REPOGEN.RSA"], &ci); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatal(err)
+	}
+	hashed := sha1.Sum([]byte(sf))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA1, hashed[:], sd.SignerInfos[0].EncryptedDigest); err != nil {
+		t.Errorf("signature file signature doesn't verify: %v", err)
+	}
+	if sd.SignerInfos[0].IssuerAndSerialNumber.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Error("signer info doesn't name the certificate")
+	}
+}
@@ -0,0 +1,177 @@
+package fdroid
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// manifestFile is the binary XML manifest every APK holds
+const manifestFile = "AndroidManifest.xml"
+
+// ParsePackage parses an Android APK and extracts metadata
+func ParsePackage(path string) (*models.Package, error) {
+	// Calculate checksums
+	checksums, err := utils.CalculateChecksums(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate checksums: %w", err)
+	}
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open APK: %w", err)
+	}
+	defer zr.Close()
+
+	var data []byte
+	abis := make(map[string]bool)
+	for _, f := range zr.File {
+		if f.Name == manifestFile {
+			if data, err = readZipFile(f); err != nil {
+				return nil, err
+			}
+		}
+		// Native libraries live in lib/<abi>/
+		if parts := strings.Split(f.Name, "/"); len(parts) == 3 && parts[0] == "lib" && parts[2] != "" {
+			abis[parts[1]] = true
+		}
+	}
+	if data == nil {
+		return nil, fmt.Errorf("no %s found in APK", manifestFile)
+	}
+
+	elements, err := parseBinaryXML(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", manifestFile, err)
+	}
+	mf, label, err := manifestOf(elements)
+	if err != nil {
+		return nil, err
+	}
+	for abi := range abis {
+		mf.Nativecode = append(mf.Nativecode, abi)
+	}
+	sort.Strings(mf.Nativecode)
+
+	cert, err := apkSignerCertificate(path, &zr.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read APK signature: %w", err)
+	}
+	if cert != nil {
+		mf.Signer = &signerV2{SHA256: []string{certificateFingerprint(cert)}}
+	}
+
+	pkg := manifestPackage(elements[0].str("package"), mf)
+	pkg.Filename = path
+	pkg.Size = checksums.Size
+	pkg.MD5Sum = checksums.MD5
+	pkg.SHA1Sum = checksums.SHA1
+	pkg.SHA256Sum = checksums.SHA256
+	pkg.Metadata["Label"] = label
+
+	return pkg, nil
+}
+
+// readZipFile returns the content of a zip entry
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// manifestOf returns the index manifest of the elements of
+// AndroidManifest.xml, and the application label when it is written out
+// rather than a resource reference
+func manifestOf(elements []xmlElement) (*manifestV2, string, error) {
+	if len(elements) == 0 || elements[0].Name != "manifest" {
+		return nil, "", fmt.Errorf("%s has no <manifest> element", manifestFile)
+	}
+	root := &elements[0]
+	if root.str("package") == "" {
+		return nil, "", fmt.Errorf("%s lacks a package name", manifestFile)
+	}
+
+	mf := &manifestV2{
+		VersionName: root.str("versionName"),
+		VersionCode: root.int("versionCode"),
+	}
+	if mf.VersionCode <= 0 {
+		return nil, "", fmt.Errorf("%s lacks a versionCode", manifestFile)
+	}
+
+	var label string
+	for i := range elements[1:] {
+		el := &elements[i+1]
+		if el.Parent != "manifest" {
+			continue
+		}
+		switch el.Name {
+		case "uses-sdk":
+			mf.UsesSdk = &usesSdkV2{
+				MinSdkVersion:    int(el.int("minSdkVersion")),
+				TargetSdkVersion: int(el.int("targetSdkVersion")),
+			}
+			// Android defaults both to 1
+			if mf.UsesSdk.MinSdkVersion == 0 {
+				mf.UsesSdk.MinSdkVersion = 1
+			}
+			if mf.UsesSdk.TargetSdkVersion == 0 {
+				mf.UsesSdk.TargetSdkVersion = mf.UsesSdk.MinSdkVersion
+			}
+			mf.MaxSdkVersion = int(el.int("maxSdkVersion"))
+		case "uses-permission", "uses-permission-sdk-23":
+			perm := permissionV2{Name: el.str("name"), MaxSdkVersion: int(el.int("maxSdkVersion"))}
+			if el.Name == "uses-permission" {
+				mf.UsesPermission = append(mf.UsesPermission, perm)
+			} else {
+				mf.UsesPermissionSdk23 = append(mf.UsesPermissionSdk23, perm)
+			}
+		case "uses-feature":
+			if name := el.str("name"); name != "" {
+				mf.Features = append(mf.Features, featureV2{Name: name})
+			}
+		case "application":
+			label = el.str("label")
+		}
+	}
+
+	return mf, label, nil
+}
+
+// manifestPackage returns the package of an app version
+func manifestPackage(id string, mf *manifestV2) *models.Package {
+	version := mf.VersionName
+	if version == "" {
+		version = strconv.FormatInt(mf.VersionCode, 10)
+	}
+
+	arch := "noarch"
+	if len(mf.Nativecode) > 0 {
+		arch = strings.Join(mf.Nativecode, ",")
+	}
+
+	return &models.Package{
+		Name:         id,
+		Version:      version,
+		Architecture: arch,
+		Metadata: map[string]interface{}{
+			"VersionCode": mf.VersionCode,
+			"Manifest":    mf,
+		},
+	}
+}
+
+// fileName returns the canonical file name of an app version,
+// <package>_<versionCode>.apk as fdroidserver names them
+func fileName(pkg models.Package) string {
+	return fmt.Sprintf("%s_%d.apk", pkg.Name, versionCode(pkg))
+}
@@ -0,0 +1,407 @@
+package fdroid
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ralt/repogen/internal/signer"
+)
+
+// IDs of the APK Signature Scheme blocks holding signer certificates
+const (
+	apkSigV2 = 0x7109871a
+	apkSigV3 = 0xf05368c0
+)
+
+// apkSigBlockMagic ends the APK Signing Block, right before the central
+// directory
+var apkSigBlockMagic = []byte("APK Sig Block 42")
+
+// OIDs of the PKCS#7 structures and algorithms of JAR signatures
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA1WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	oidSHA1          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+// jarSignerName is the base name of the signature files in entry.jar
+const jarSignerName = "REPOGEN"
+
+// certificateFingerprint returns the SHA-256 of a DER certificate, as F-Droid
+// identifies signers
+func certificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// apkSignerCertificate returns the certificate an APK is signed with: the
+// first signer of the v3 or v2 signature scheme block, or of the v1 JAR
+// signature. It returns nil for unsigned APKs
+func apkSignerCertificate(file string, zr *zip.Reader) ([]byte, error) {
+	blocks, err := apkSigningBlock(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range []uint32{apkSigV3, apkSigV2} {
+		if value, ok := blocks[id]; ok {
+			return schemeCertificate(value)
+		}
+	}
+
+	for _, f := range zr.File {
+		dir, name := path.Split(f.Name)
+		ext := strings.ToUpper(path.Ext(name))
+		if dir != "META-INF/" || ext != ".RSA" && ext != ".DSA" && ext != ".EC" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		return pkcs7Certificate(data)
+	}
+
+	return nil, nil
+}
+
+// apkSigningBlock returns the ID-value pairs of the APK Signing Block, which
+// sits between the zip entries and the central directory
+func apkSigningBlock(file string) (map[uint32][]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+
+	// The end of central directory record is followed by a comment of at
+	// most 64KiB
+	eocd := -1
+	for i := len(data) - 22; i >= 0 && i >= len(data)-22-0xffff; i-- {
+		if le.Uint32(data[i:]) == 0x06054b50 {
+			eocd = i
+			break
+		}
+	}
+	if eocd < 0 {
+		return nil, fmt.Errorf("no zip end of central directory found")
+	}
+	cdOffset := int(le.Uint32(data[eocd+16:]))
+	if cdOffset < 24 || cdOffset > len(data) || !bytes.Equal(data[cdOffset-16:cdOffset], apkSigBlockMagic) {
+		return nil, nil
+	}
+
+	// The block size, excluding its first size field, is repeated before
+	// the magic
+	size := int(le.Uint64(data[cdOffset-24:]))
+	start := cdOffset - size - 8
+	if size < 24 || start < 0 {
+		return nil, fmt.Errorf("invalid APK Signing Block")
+	}
+
+	blocks := make(map[uint32][]byte)
+	pairs := data[start+8 : cdOffset-24]
+	for len(pairs) > 0 {
+		if len(pairs) < 12 {
+			return nil, fmt.Errorf("invalid APK Signing Block")
+		}
+		n := le.Uint64(pairs)
+		if n < 4 || n > uint64(len(pairs)-8) {
+			return nil, fmt.Errorf("invalid APK Signing Block")
+		}
+		blocks[le.Uint32(pairs[8:])] = pairs[12 : 8+n]
+		pairs = pairs[8+n:]
+	}
+	return blocks, nil
+}
+
+// schemeCertificate returns the first certificate of the first signer of a
+// v2 or v3 signature scheme block. Every level is a sequence of
+// length-prefixed values
+func schemeCertificate(value []byte) ([]byte, error) {
+	signers, _, err := lengthPrefixed(value)
+	if err != nil {
+		return nil, err
+	}
+	signerData, _, err := lengthPrefixed(signers)
+	if err != nil {
+		return nil, err
+	}
+	signedData, _, err := lengthPrefixed(signerData)
+	if err != nil {
+		return nil, err
+	}
+	// Signed data: digests, then certificates
+	_, rest, err := lengthPrefixed(signedData)
+	if err != nil {
+		return nil, err
+	}
+	certificates, _, err := lengthPrefixed(rest)
+	if err != nil {
+		return nil, err
+	}
+	cert, _, err := lengthPrefixed(certificates)
+	if err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// lengthPrefixed splits a value prefixed with its uint32 length from the
+// bytes following it
+func lengthPrefixed(data []byte) (value, rest []byte, err error) {
+	if len(data) < 4 {
+		return nil, nil, fmt.Errorf("truncated APK signature")
+	}
+	n := binary.LittleEndian.Uint32(data)
+	if uint64(n) > uint64(len(data)-4) {
+		return nil, nil, fmt.Errorf("truncated APK signature")
+	}
+	return data[4 : 4+n], data[4+n:], nil
+}
+
+// contentInfo is a PKCS#7 ContentInfo
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+// signedData is a PKCS#7 SignedData, with a detached content
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// pkcs7Certificate returns the first certificate of a PKCS#7 SignedData, as
+// found in META-INF/*.RSA
+func pkcs7Certificate(data []byte) ([]byte, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(data, &ci); err != nil {
+		return nil, fmt.Errorf("invalid JAR signature: %w", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("JAR signature isn't PKCS#7 signed data")
+	}
+
+	// Only the certificates matter, the rest of SignedData is left unparsed
+	var sd struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      asn1.RawValue
+		Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("invalid JAR signature: %w", err)
+	}
+
+	var cert asn1.RawValue
+	if _, err := asn1.Unmarshal(sd.Certificates.Bytes, &cert); err != nil {
+		return nil, fmt.Errorf("JAR signature has no certificate: %w", err)
+	}
+	return cert.FullBytes, nil
+}
+
+// repoCertificate returns a self-signed certificate of the repository key.
+// F-Droid clients pin the SHA-256 of the certificate, so everything in it
+// derives from the key: the same key always gives the same certificate
+func repoCertificate(rsaSigner signer.RSASigner, keyName string) ([]byte, error) {
+	pemKey, err := rsaSigner.GetPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode public key")
+	}
+
+	if keyName == "" {
+		keyName = "repogen"
+	}
+	name, err := asn1.Marshal(pkix.Name{CommonName: keyName}.ToRDNSequence())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(block.Bytes)
+
+	sigAlg := pkix.AlgorithmIdentifier{Algorithm: oidSHA1WithRSA, Parameters: asn1.NullRawValue}
+	tbs, err := asn1.Marshal(struct {
+		Version      int `asn1:"explicit,tag:0"`
+		SerialNumber *big.Int
+		Signature    pkix.AlgorithmIdentifier
+		Issuer       asn1.RawValue
+		Validity     struct{ NotBefore, NotAfter time.Time }
+		Subject      asn1.RawValue
+		PublicKey    asn1.RawValue
+	}{
+		Version:      2, // X.509 v3
+		SerialNumber: new(big.Int).SetBytes(sum[:8]),
+		Signature:    sigAlg,
+		Issuer:       asn1.RawValue{FullBytes: name},
+		// No well-defined expiration date, RFC 5280 4.1.2.5
+		Validity: struct{ NotBefore, NotAfter time.Time }{
+			time.Unix(0, 0).UTC(),
+			time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC),
+		},
+		Subject:   asn1.RawValue{FullBytes: name},
+		PublicKey: asn1.RawValue{FullBytes: block.Bytes},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// SignRSA hashes with SHA-1, like the rest of the JAR signature
+	signature, err := rsaSigner.SignRSA(tbs)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(struct {
+		TBSCertificate     asn1.RawValue
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		SignatureValue     asn1.BitString
+	}{
+		TBSCertificate:     asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: sigAlg,
+		SignatureValue:     asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
+	})
+}
+
+// signJar returns a JAR holding the given files, signed with the v1 JAR
+// signature F-Droid clients verify entry.jar with: a manifest of the file
+// digests, a signature file of the manifest digests, and its PKCS#7
+// signature by the repository certificate
+func signJar(rsaSigner signer.RSASigner, cert []byte, names []string, files map[string][]byte) ([]byte, error) {
+	digest := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	manifest := []byte("Manifest-Version: 1.0\r\nCreated-By: repogen\r\n\r\n")
+	sf := []byte("Signature-Version: 1.0\r\nCreated-By: repogen\r\n")
+	var sections []byte
+	for _, name := range names {
+		section := []byte(fmt.Sprintf("Name: %s\r\nSHA-256-Digest: %s\r\n\r\n", name, digest(files[name])))
+		manifest = append(manifest, section...)
+		sections = append(sections, fmt.Sprintf("Name: %s\r\nSHA-256-Digest: %s\r\n\r\n", name, digest(section))...)
+	}
+	sf = append(sf, fmt.Sprintf("SHA-256-Digest-Manifest: %s\r\n\r\n", digest(manifest))...)
+	sf = append(sf, sections...)
+
+	signature, err := rsaSigner.SignRSA(sf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	pkcs7, err := signaturePKCS7(cert, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	entries := []struct {
+		name string
+		data []byte
+	}{
+		{"META-INF/MANIFEST.MF", manifest},
+		{"META-INF/" + jarSignerName + ".SF", sf},
+		{"META-INF/" + jarSignerName + ".RSA", pkcs7},
+	}
+	for _, name := range names {
+		entries = append(entries, struct {
+			name string
+			data []byte
+		}{name, files[name]})
+	}
+	for _, e := range entries {
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: e.name, Method: zip.Deflate})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(e.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// signaturePKCS7 wraps a SHA1withRSA signature of the signature file into a
+// detached PKCS#7 SignedData carrying the signer certificate
+func signaturePKCS7(cert, signature []byte) ([]byte, error) {
+	// The issuer and serial number identify the signer certificate
+	var parsed struct {
+		TBS struct {
+			Version      int `asn1:"optional,explicit,tag:0"`
+			SerialNumber *big.Int
+			Signature    asn1.RawValue
+			Issuer       asn1.RawValue
+		}
+	}
+	if _, err := asn1.Unmarshal(cert, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+
+	sha1 := pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha1},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: parsed.TBS.Issuer.FullBytes},
+				SerialNumber: parsed.TBS.SerialNumber,
+			},
+			DigestAlgorithm:           sha1,
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedDigest:           signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
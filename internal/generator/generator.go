@@ -21,3 +21,48 @@ type Generator interface {
 	// ParseExistingMetadata reads existing repository metadata and returns packages already in the repo
 	ParseExistingMetadata(config *models.RepositoryConfig) ([]models.Package, error)
 }
+
+// PackageLocator is implemented by generators that can locate the files
+// backing packages returned by ParseExistingMetadata
+type PackageLocator interface {
+	// PackageFiles returns the paths of the package file and its companion
+	// files (e.g. detached signatures) inside the output directory
+	PackageFiles(config *models.RepositoryConfig, pkg models.Package) []string
+}
+
+// DependencyReader is implemented by generators of formats whose packages
+// depend on other packages by name, so a repository can be checked for
+// dependencies none of its packages satisfy
+type DependencyReader interface {
+	// Requires returns the dependencies of pkg, each listing the names
+	// satisfying it without their version constraints
+	Requires(pkg models.Package) [][]string
+
+	// Provides returns the names pkg satisfies dependencies on, its own
+	// name included
+	Provides(pkg models.Package) []string
+}
+
+// UpstreamReader is implemented by generators that can read the metadata of
+// a remote repository, for mirroring it
+type UpstreamReader interface {
+	// ReadUpstream returns the packages listed by the repository, selected
+	// by the codenames, components and architectures of config. Their
+	// Filename is the path of the package below the repository URL, and
+	// Metadata["Component"] the Debian component they belong to
+	ReadUpstream(ctx context.Context, config *models.RepositoryConfig, upstream Upstream) ([]models.Package, error)
+}
+
+// Upstream is a remote repository
+type Upstream interface {
+	// Get fetches the file at path below the repository URL
+	Get(ctx context.Context, path string) ([]byte, error)
+
+	// Verify checks the detached signature of signed metadata, accepting
+	// anything when no keyring was given
+	Verify(data, signature []byte) error
+
+	// Verifies reports whether signatures are checked, so signatures are
+	// only fetched when needed
+	Verifies() bool
+}
@@ -0,0 +1,218 @@
+package homebrew
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// apiDir holds the JSON API documents, laid out as on formulae.brew.sh
+const apiDir = "api"
+
+// apiFormula is a formula in the schema of the Homebrew JSON API
+// (formulae.brew.sh/api/formula.json). Fields repogen knows nothing about
+// are kept with their empty value, as brew expects them to be present
+type apiFormula struct {
+	Name                    string         `json:"name"`
+	FullName                string         `json:"full_name"`
+	Tap                     string         `json:"tap"`
+	Oldnames                []string       `json:"oldnames"`
+	Aliases                 []string       `json:"aliases"`
+	VersionedFormulae       []string       `json:"versioned_formulae"`
+	Desc                    string         `json:"desc"`
+	License                 *string        `json:"license"`
+	Homepage                string         `json:"homepage"`
+	Versions                apiVersions    `json:"versions"`
+	URLs                    apiURLs        `json:"urls"`
+	Revision                int            `json:"revision"`
+	VersionScheme           int            `json:"version_scheme"`
+	Bottle                  apiBottles     `json:"bottle"`
+	KegOnly                 bool           `json:"keg_only"`
+	BuildDependencies       []string       `json:"build_dependencies"`
+	Dependencies            []string       `json:"dependencies"`
+	TestDependencies        []string       `json:"test_dependencies"`
+	RecommendedDependencies []string       `json:"recommended_dependencies"`
+	OptionalDependencies    []string       `json:"optional_dependencies"`
+	ConflictsWith           []string       `json:"conflicts_with"`
+	Caveats                 *string        `json:"caveats"`
+	Deprecated              bool           `json:"deprecated"`
+	DeprecationDate         *string        `json:"deprecation_date"`
+	DeprecationReason       *string        `json:"deprecation_reason"`
+	Disabled                bool           `json:"disabled"`
+	DisableDate             *string        `json:"disable_date"`
+	DisableReason           *string        `json:"disable_reason"`
+	Requirements            []string       `json:"requirements"`
+	Variations              map[string]any `json:"variations"`
+}
+
+type apiVersions struct {
+	Stable string  `json:"stable"`
+	Head   *string `json:"head"`
+	Bottle bool    `json:"bottle"`
+}
+
+type apiURLs struct {
+	Stable apiURL `json:"stable"`
+}
+
+type apiURL struct {
+	URL      string  `json:"url"`
+	Tag      *string `json:"tag"`
+	Revision *string `json:"revision"`
+	Using    *string `json:"using"`
+	Checksum string  `json:"checksum"`
+}
+
+type apiBottles struct {
+	Stable *apiBottle `json:"stable,omitempty"`
+}
+
+type apiBottle struct {
+	Rebuild int                      `json:"rebuild"`
+	RootURL string                   `json:"root_url"`
+	Files   map[string]apiBottleFile `json:"files"`
+}
+
+type apiBottleFile struct {
+	Cellar string `json:"cellar"`
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+}
+
+// apiFormulaFor describes the formula of name, published with bottles
+func (g *Generator) apiFormulaFor(tap, name string, bottles []models.Package) *apiFormula {
+	bottles = sortedBottles(bottles)
+	first := bottles[0]
+
+	f := &apiFormula{
+		Name:                    name,
+		FullName:                name,
+		Tap:                     tap,
+		Oldnames:                []string{},
+		Aliases:                 []string{},
+		VersionedFormulae:       []string{},
+		Desc:                    fmt.Sprintf("%s package", name),
+		Homepage:                "https://example.com",
+		BuildDependencies:       []string{},
+		Dependencies:            []string{},
+		TestDependencies:        []string{},
+		RecommendedDependencies: []string{},
+		OptionalDependencies:    []string{},
+		ConflictsWith:           []string{},
+		Requirements:            []string{},
+		Variations:              map[string]any{},
+	}
+	if tap != "" {
+		f.FullName = tap + "/" + name
+	}
+	f.Versions.Stable = "1.0.0"
+	if first.Version != "" {
+		f.Versions.Stable = first.Version
+	}
+	if first.Description != "" {
+		f.Desc = first.Description
+	}
+	if first.Homepage != "" {
+		f.Homepage = first.Homepage
+	}
+	if first.License != "" {
+		f.License = &first.License
+	}
+	if first.Deprecation != nil {
+		f.Deprecated = true
+		if first.Deprecation.EOL != "" {
+			f.DeprecationDate = &first.Deprecation.EOL
+		}
+		if first.Deprecation.Message != "" {
+			f.DeprecationReason = &first.Deprecation.Message
+		}
+	}
+
+	// The stable URL is the one the Ruby formula installs from
+	f.URLs.Stable.URL = g.getBottleURL(first)
+	f.URLs.Stable.Checksum = first.SHA256Sum
+
+	files := make(map[string]apiBottleFile)
+	rebuild := 0
+	for _, bottle := range bottles {
+		info, ok := parseBottleFilename(bottle.Filename)
+		if !ok {
+			continue
+		}
+		if info.Rebuild > rebuild {
+			rebuild = info.Rebuild
+		}
+		files[info.Tag] = apiBottleFile{
+			Cellar: ":any",
+			URL:    g.getBottleURL(bottle),
+			SHA256: bottle.SHA256Sum,
+		}
+	}
+	if len(files) > 0 {
+		f.Versions.Bottle = true
+		f.Bottle.Stable = &apiBottle{
+			Rebuild: rebuild,
+			RootURL: g.formulaRootURL(first),
+			Files:   files,
+		}
+	}
+
+	return f
+}
+
+// writeAPI writes api/formula/<name>.json for each formula and rebuilds
+// the api/formula.json index
+func writeAPI(outputDir string, formulas []*apiFormula) error {
+	for _, f := range formulas {
+		data, err := json.MarshalIndent(f, "", "  ")
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(outputDir, apiDir, "formula", fmt.Sprintf("%s.json", f.Name))
+		if err := utils.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+
+	return RebuildAPIIndex(outputDir)
+}
+
+// RebuildAPIIndex writes api/formula.json, listing every formula document
+// under api/formula/, so formulas published by earlier runs stay listed
+func RebuildAPIIndex(outputDir string) error {
+	docs, err := filepath.Glob(filepath.Join(outputDir, apiDir, "formula", "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(docs)
+
+	formulas := make([]json.RawMessage, 0, len(docs))
+	for _, doc := range docs {
+		data, err := os.ReadFile(doc)
+		if err != nil {
+			return err
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, data); err != nil {
+			return fmt.Errorf("invalid formula document %s: %w", doc, err)
+		}
+		formulas = append(formulas, compact.Bytes())
+	}
+
+	data, err := json.Marshal(formulas)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(outputDir, apiDir, "formula.json")
+	if err := utils.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
+}
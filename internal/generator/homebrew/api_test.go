@@ -0,0 +1,81 @@
+package homebrew
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+func TestGenerateJSONAPI(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	outputDir := filepath.Join(tmpDir, "output")
+	os.MkdirAll(inputDir, 0755)
+
+	var packages []models.Package
+	for _, name := range []string{"hello--2.1.arm64_sonoma.bottle.tar.gz", "hello--2.1.x86_64_linux.bottle.tar.gz"} {
+		path := filepath.Join(inputDir, name)
+		os.WriteFile(path, []byte(name), 0644)
+		packages = append(packages, models.Package{Name: "hello", Version: "2.1", Filename: path, License: "MIT"})
+	}
+
+	gen := NewGenerator("https://example.com/tap/", "")
+	config := &models.RepositoryConfig{OutputDir: outputDir, RepoName: "acme/tools"}
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "api", "formula.json"))
+	if err != nil {
+		t.Fatalf("formula.json not written: %v", err)
+	}
+	var index []apiFormula
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatalf("formula.json does not parse: %v", err)
+	}
+	if len(index) != 1 {
+		t.Fatalf("expected 1 formula, got %d", len(index))
+	}
+
+	f := index[0]
+	if f.Name != "hello" || f.FullName != "acme/tools/hello" || f.Versions.Stable != "2.1" || !f.Versions.Bottle {
+		t.Errorf("unexpected formula %+v", f)
+	}
+	if f.License == nil || *f.License != "MIT" {
+		t.Errorf("license not published")
+	}
+	if f.Bottle.Stable == nil || f.Bottle.Stable.RootURL != "https://example.com/tap/bottles" {
+		t.Fatalf("unexpected bottle %+v", f.Bottle.Stable)
+	}
+	for tag, file := range map[string]string{
+		"arm64_sonoma": "hello--2.1.arm64_sonoma.bottle.tar.gz",
+		"x86_64_linux": "hello--2.1.x86_64_linux.bottle.tar.gz",
+	} {
+		got, ok := f.Bottle.Stable.Files[tag]
+		if !ok {
+			t.Errorf("missing bottle for %s", tag)
+			continue
+		}
+		if got.URL != "https://example.com/tap/bottles/"+file || len(got.SHA256) != 64 {
+			t.Errorf("unexpected %s bottle %+v", tag, got)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(outputDir, "api", "formula", "hello.json")); err != nil {
+		t.Errorf("per-formula document not written: %v", err)
+	}
+
+	// Removing the document drops the formula from the index
+	os.Remove(filepath.Join(outputDir, "api", "formula", "hello.json"))
+	if err := RebuildAPIIndex(outputDir); err != nil {
+		t.Fatalf("RebuildAPIIndex failed: %v", err)
+	}
+	data, _ = os.ReadFile(filepath.Join(outputDir, "api", "formula.json"))
+	if string(data) != "[]\n" {
+		t.Errorf("index still lists removed formula: %s", data)
+	}
+}
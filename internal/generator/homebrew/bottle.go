@@ -0,0 +1,132 @@
+package homebrew
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// bottleFilenameRe splits a bottle file name as written by brew bottle:
+// <name>--<version>.<tag>.bottle[.<rebuild>].tar.gz
+var bottleFilenameRe = regexp.MustCompile(`^(.+?)--(.+)\.([a-z0-9_]+)\.bottle\.(?:(\d+)\.)?tar(?:\.gz)?$`)
+
+// bottleInfo is what a bottle file name says about the bottle
+type bottleInfo struct {
+	Name    string
+	Version string
+	Tag     string // Platform tag, e.g. "arm64_sonoma" or "x86_64_linux"
+	Rebuild int
+}
+
+// parseBottleFilename reads the name, version, platform tag and rebuild
+// number of a bottle from its file name (or URL)
+func parseBottleFilename(filename string) (bottleInfo, bool) {
+	m := bottleFilenameRe.FindStringSubmatch(filepath.Base(filename))
+	if m == nil {
+		return bottleInfo{}, false
+	}
+
+	info := bottleInfo{Name: m[1], Version: m[2], Tag: m[3]}
+	if m[4] != "" {
+		info.Rebuild, _ = strconv.Atoi(m[4])
+	}
+	return info, true
+}
+
+// bottleFilename returns the file name brew downloads a bottle as
+func bottleFilename(info bottleInfo) string {
+	if info.Rebuild > 0 {
+		return fmt.Sprintf("%s--%s.%s.bottle.%d.tar.gz", info.Name, info.Version, info.Tag, info.Rebuild)
+	}
+	return fmt.Sprintf("%s--%s.%s.bottle.tar.gz", info.Name, info.Version, info.Tag)
+}
+
+// ParseBottle reads the metadata of a bottle from its file name
+func ParseBottle(path string) (*models.Package, error) {
+	info, ok := parseBottleFilename(path)
+	if !ok {
+		return nil, fmt.Errorf("%s is not named <name>--<version>.<tag>.bottle[.<rebuild>].tar.gz", filepath.Base(path))
+	}
+
+	checksums, err := utils.CalculateChecksums(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Package{
+		Name:         info.Name,
+		Version:      info.Version,
+		Architecture: info.Tag,
+		Filename:     path,
+		Size:         checksums.Size,
+		SHA256Sum:    checksums.SHA256,
+		Metadata:     make(map[string]interface{}),
+	}, nil
+}
+
+// sortedBottles returns bottles ordered by platform tag, for stable output
+func sortedBottles(bottles []models.Package) []models.Package {
+	sorted := append([]models.Package(nil), bottles...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return filepath.Base(sorted[i].Filename) < filepath.Base(sorted[j].Filename)
+	})
+	return sorted
+}
+
+// Bottle collision policies: what to do when a bottle would overwrite a
+// published bottle of the same file name but different content
+const (
+	CollisionError  = "error"  // Refuse to publish
+	CollisionDigest = "digest" // Publish the formula's bottles under bottles/<digest>/
+)
+
+// ValidateCollisionPolicy checks that policy is a known collision policy
+func ValidateCollisionPolicy(policy string) error {
+	switch policy {
+	case "", CollisionError, CollisionDigest:
+		return nil
+	}
+	return fmt.Errorf("unknown bottle collision policy %q (expected %s or %s)", policy, CollisionError, CollisionDigest)
+}
+
+// digestDirRe matches the directories of the digest layout
+var digestDirRe = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// dedupeBottles drops bottles listed twice, refusing two different
+// bottles of the same file name: a formula lists one bottle per platform
+func dedupeBottles(bottles []models.Package) ([]models.Package, error) {
+	seen := make(map[string]models.Package)
+	var deduped []models.Package
+	for _, bottle := range bottles {
+		name := filepath.Base(bottle.Filename)
+		prev, ok := seen[name]
+		if !ok {
+			seen[name] = bottle
+			deduped = append(deduped, bottle)
+			continue
+		}
+		if prev.SHA256Sum != bottle.SHA256Sum {
+			return nil, fmt.Errorf("bottle collision: %s is provided twice with different content (%s and %s)",
+				name, prev.Filename, bottle.Filename)
+		}
+	}
+	return deduped, nil
+}
+
+// bottleSetDigest names the digest directory of a formula: the SHA-256 of
+// its bottles' checksums. brew downloads every bottle of a formula from
+// one root_url, so the directory holds the whole set rather than one bottle
+func bottleSetDigest(bottles []models.Package) string {
+	h := sha256.New()
+	for _, bottle := range sortedBottles(bottles) {
+		fmt.Fprintf(h, "%s %s\n", bottle.SHA256Sum, filepath.Base(bottle.Filename))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,419 @@
+package homebrew
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Cask artifacts are served from appsDir, not casks/: the tap already has a
+// Casks directory and macOS file systems are usually case-insensitive
+const (
+	casksDir = "Casks"
+	appsDir  = "apps"
+)
+
+// caskFilenameRe splits an artifact name (without extension) into name,
+// version and optional architecture, e.g. "MyApp-1.2.3-arm64"
+var caskFilenameRe = regexp.MustCompile(`^(.+?)[-_ ]v?(\d[\w.]*?)(?:[-_](arm64|aarch64|x86_64|x64|amd64|intel|universal))?$`)
+
+// CaskGenerator implements the generator.Generator interface for Homebrew
+// Casks, which install macOS apps from .dmg, .pkg or .zip artifacts
+type CaskGenerator struct {
+	baseURL string
+}
+
+// NewCaskGenerator creates a new Homebrew Cask generator
+func NewCaskGenerator(baseURL string) generator.Generator {
+	return &CaskGenerator{
+		baseURL: baseURL,
+	}
+}
+
+// ParseCask reads the name, version and architecture of a macOS app
+// artifact from its file name
+func ParseCask(path string) (*models.Package, error) {
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+
+	matches := caskFilenameRe.FindStringSubmatch(strings.TrimSuffix(base, ext))
+	if matches == nil {
+		return nil, fmt.Errorf("cannot determine name and version of %s, expected <name>-<version>[-<arch>]%s", base, ext)
+	}
+
+	checksums, err := utils.CalculateChecksums(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := &models.Package{
+		Name:         matches[1],
+		Version:      matches[2],
+		Architecture: caskArch(matches[3]),
+		Filename:     path,
+		Size:         checksums.Size,
+		SHA256Sum:    checksums.SHA256,
+		Metadata:     make(map[string]interface{}),
+	}
+
+	// Zip archives name the app they hold; disk images are opaque and
+	// assumed to hold <name>.app
+	if strings.EqualFold(ext, ".zip") {
+		if app := zippedApp(path); app != "" {
+			pkg.Metadata["App"] = app
+		}
+	}
+
+	return pkg, nil
+}
+
+// caskArch normalizes the architecture found in an artifact name
+func caskArch(arch string) string {
+	switch arch {
+	case "arm64", "aarch64":
+		return "arm64"
+	case "x86_64", "x64", "amd64", "intel":
+		return "x86_64"
+	default:
+		return "all"
+	}
+}
+
+// zippedApp returns the name of the first app bundle at the top of a zip archive
+func zippedApp(path string) string {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		top, _, _ := strings.Cut(f.Name, "/")
+		if strings.HasSuffix(top, ".app") {
+			return top
+		}
+	}
+	return ""
+}
+
+// caskToken returns the cask token of a package name: lowercase, words
+// separated by hyphens
+func caskToken(name string) string {
+	token := strings.ToLower(name)
+	token = strings.ReplaceAll(token, "_", "-")
+	token = strings.ReplaceAll(token, " ", "-")
+	return token
+}
+
+// Generate copies the artifacts and writes Casks/<token>.rb for each app
+func (g *CaskGenerator) Generate(ctx context.Context, config *models.RepositoryConfig, packages []models.Package) error {
+	logrus.Info("Generating Homebrew casks...")
+
+	caskDir := filepath.Join(config.OutputDir, casksDir)
+	artifactDir := filepath.Join(config.OutputDir, appsDir)
+
+	if err := utils.EnsureDir(caskDir); err != nil {
+		return err
+	}
+	if err := utils.EnsureDir(artifactDir); err != nil {
+		return err
+	}
+
+	// Group artifacts by cask
+	artifactsByCask := make(map[string][]models.Package)
+	for _, pkg := range packages {
+		token := caskToken(pkg.Name)
+		artifactsByCask[token] = append(artifactsByCask[token], pkg)
+	}
+
+	for token, artifacts := range artifactsByCask {
+		published := make([]models.Package, len(artifacts))
+		for i, artifact := range artifacts {
+			dstPath := filepath.Join(artifactDir, filepath.Base(artifact.Filename))
+
+			// Artifacts read back from an existing cask are published already,
+			// possibly only on remote storage
+			if _, err := os.Stat(artifact.Filename); os.IsNotExist(err) {
+				published[i] = artifact
+				continue
+			}
+
+			checksums, err := utils.CopyFileWithChecksums(ctx, artifact.Filename, dstPath)
+			if err != nil {
+				return fmt.Errorf("failed to copy %s: %w", artifact.Filename, err)
+			}
+
+			if config.VerifyWrites {
+				if err := utils.VerifyCopy(dstPath, checksums); err != nil {
+					return fmt.Errorf("failed to verify %s: %w", artifact.Filename, err)
+				}
+			}
+
+			artifact.Size = checksums.Size
+			artifact.SHA256Sum = checksums.SHA256
+			published[i] = artifact
+		}
+
+		cask := g.generateCask(token, published)
+		caskPath := filepath.Join(caskDir, fmt.Sprintf("%s.rb", token))
+		if err := utils.WriteFile(caskPath, []byte(cask), 0644); err != nil {
+			return fmt.Errorf("failed to write cask: %w", err)
+		}
+
+		logrus.Infof("Generated cask %s (%s.rb)", token, token)
+	}
+
+	logrus.Infof("Homebrew casks generated successfully (%d casks)", len(artifactsByCask))
+	return nil
+}
+
+// generateCask creates the Ruby cask of the latest version among artifacts,
+// with one download per architecture when several are published
+func (g *CaskGenerator) generateCask(token string, artifacts []models.Package) string {
+	latest := artifacts[0].Version
+	for _, artifact := range artifacts[1:] {
+		if utils.CompareSemanticVersions(artifact.Version, latest) > 0 {
+			latest = artifact.Version
+		}
+	}
+
+	byArch := make(map[string]models.Package)
+	for _, artifact := range artifacts {
+		if artifact.Version == latest {
+			byArch[artifact.Architecture] = artifact
+		}
+	}
+	arm, hasArm := byArch["arm64"]
+	intel, hasIntel := byArch["x86_64"]
+
+	// The stanzas describing the install come from any of the downloads
+	main := arm
+	if all, ok := byArch["all"]; ok {
+		main = all
+	} else if !hasArm {
+		main = intel
+	}
+
+	var cask strings.Builder
+	fmt.Fprintf(&cask, "cask \"%s\" do\n", token)
+	fmt.Fprintf(&cask, "  version \"%s\"\n", latest)
+
+	if hasArm && hasIntel && main.Architecture != "all" {
+		cask.WriteString("\n  on_arm do\n")
+		fmt.Fprintf(&cask, "    sha256 \"%s\"\n", arm.SHA256Sum)
+		fmt.Fprintf(&cask, "    url \"%s\"\n", g.getArtifactURL(arm.Filename))
+		cask.WriteString("  end\n")
+		cask.WriteString("  on_intel do\n")
+		fmt.Fprintf(&cask, "    sha256 \"%s\"\n", intel.SHA256Sum)
+		fmt.Fprintf(&cask, "    url \"%s\"\n", g.getArtifactURL(intel.Filename))
+		cask.WriteString("  end\n")
+	} else {
+		fmt.Fprintf(&cask, "  sha256 \"%s\"\n", main.SHA256Sum)
+		cask.WriteString("\n")
+		fmt.Fprintf(&cask, "  url \"%s\"\n", g.getArtifactURL(main.Filename))
+	}
+
+	fmt.Fprintf(&cask, "  name \"%s\"\n", main.Name)
+	desc := main.Description
+	if desc == "" {
+		desc = fmt.Sprintf("%s app", main.Name)
+	}
+	fmt.Fprintf(&cask, "  desc \"%s\"\n", desc)
+	homepage := main.Homepage
+	if homepage == "" {
+		homepage = "https://example.com"
+	}
+	fmt.Fprintf(&cask, "  homepage \"%s\"\n", homepage)
+
+	if main.Architecture == "arm64" && !hasIntel {
+		cask.WriteString("\n  depends_on arch: :arm64\n")
+	} else if main.Architecture == "x86_64" && !hasArm {
+		cask.WriteString("\n  depends_on arch: :x86_64\n")
+	}
+
+	cask.WriteString("\n")
+	if strings.EqualFold(filepath.Ext(main.Filename), ".pkg") {
+		fmt.Fprintf(&cask, "  pkg \"%s\"\n", filepath.Base(main.Filename))
+	} else {
+		app, _ := main.Metadata["App"].(string)
+		if app == "" {
+			app = main.Name + ".app"
+		}
+		fmt.Fprintf(&cask, "  app \"%s\"\n", app)
+	}
+
+	cask.WriteString("end\n")
+	return cask.String()
+}
+
+// getArtifactURL constructs the URL for an artifact
+func (g *CaskGenerator) getArtifactURL(filename string) string {
+	if g.baseURL != "" {
+		return fmt.Sprintf("%s/%s/%s", strings.TrimRight(g.baseURL, "/"), appsDir, filepath.Base(filename))
+	}
+	return fmt.Sprintf("%s/%s", appsDir, filepath.Base(filename))
+}
+
+// ValidatePackages checks if packages are macOS app artifacts
+func (g *CaskGenerator) ValidatePackages(packages []models.Package) error {
+	for _, pkg := range packages {
+		switch strings.ToLower(filepath.Ext(pkg.Filename)) {
+		case ".dmg", ".pkg", ".zip":
+		default:
+			return fmt.Errorf("package %s is not a .dmg, .pkg or .zip app artifact", pkg.Filename)
+		}
+	}
+	return nil
+}
+
+// GetSupportedType returns the package type this generator supports
+func (g *CaskGenerator) GetSupportedType() scanner.PackageType {
+	return scanner.TypeHomebrewCask
+}
+
+// ParseExistingMetadata reads Casks/*.rb files
+func (g *CaskGenerator) ParseExistingMetadata(config *models.RepositoryConfig) ([]models.Package, error) {
+	caskFiles, err := filepath.Glob(filepath.Join(config.OutputDir, casksDir, "*.rb"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(caskFiles) == 0 {
+		return nil, fmt.Errorf("no existing Homebrew casks found in %s", config.OutputDir)
+	}
+	sort.Strings(caskFiles)
+
+	var packages []models.Package
+	for _, caskPath := range caskFiles {
+		pkgs, err := parseCaskFile(caskPath)
+		if err != nil {
+			continue
+		}
+		packages = append(packages, pkgs...)
+	}
+
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no packages found in Cask files")
+	}
+
+	return packages, nil
+}
+
+// parseCaskFile reads back the downloads of a cask written by generateCask
+func parseCaskFile(path string) ([]models.Package, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	versionRe := regexp.MustCompile(`^version\s+"([^"]+)"`)
+	nameRe := regexp.MustCompile(`^name\s+"([^"]+)"`)
+	descRe := regexp.MustCompile(`^desc\s+"([^"]+)"`)
+	homepageRe := regexp.MustCompile(`^homepage\s+"([^"]+)"`)
+	urlRe := regexp.MustCompile(`^url\s+"([^"]+)"`)
+	sha256Re := regexp.MustCompile(`^sha256\s+"([^"]+)"`)
+	appRe := regexp.MustCompile(`^app\s+"([^"]+)"`)
+
+	type download struct{ arch, url, sha256 string }
+	var downloads []download
+	var current download
+	var version, name, desc, homepage, app string
+	arch := "all"
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+
+		switch {
+		case line == "on_arm do":
+			arch = "arm64"
+		case line == "on_intel do":
+			arch = "x86_64"
+		case line == "end":
+			arch = "all"
+		case line == "depends_on arch: :arm64", line == "depends_on arch: :x86_64":
+			for i := range downloads {
+				downloads[i].arch = strings.TrimPrefix(line, "depends_on arch: :")
+			}
+		}
+
+		if m := versionRe.FindStringSubmatch(line); m != nil {
+			version = m[1]
+		}
+		if m := nameRe.FindStringSubmatch(line); m != nil {
+			name = m[1]
+		}
+		if m := descRe.FindStringSubmatch(line); m != nil {
+			desc = m[1]
+		}
+		if m := homepageRe.FindStringSubmatch(line); m != nil {
+			homepage = m[1]
+		}
+		if m := appRe.FindStringSubmatch(line); m != nil {
+			app = m[1]
+		}
+		if m := sha256Re.FindStringSubmatch(line); m != nil {
+			current.sha256 = m[1]
+		}
+		if m := urlRe.FindStringSubmatch(line); m != nil {
+			current.url = m[1]
+		}
+
+		// sha256 + url = one download
+		if current.sha256 != "" && current.url != "" {
+			current.arch = arch
+			downloads = append(downloads, current)
+			current = download{}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), ".rb")
+	}
+
+	var packages []models.Package
+	for _, d := range downloads {
+		pkg := models.Package{
+			Name:         name,
+			Version:      version,
+			Architecture: d.arch,
+			Description:  desc,
+			Homepage:     homepage,
+			Filename:     d.url,
+			SHA256Sum:    d.sha256,
+			Metadata:     make(map[string]interface{}),
+		}
+		if app != "" {
+			pkg.Metadata["App"] = app
+		}
+		packages = append(packages, pkg)
+	}
+
+	return packages, nil
+}
+
+// PackageFiles returns the artifact backing a package and the cask that
+// references it. A cask holds a single version, so removing the version
+// removes the cask too
+func (g *CaskGenerator) PackageFiles(config *models.RepositoryConfig, pkg models.Package) []string {
+	return []string{
+		filepath.Join(config.OutputDir, appsDir, filepath.Base(pkg.Filename)),
+		filepath.Join(config.OutputDir, casksDir, fmt.Sprintf("%s.rb", caskToken(pkg.Name))),
+	}
+}
@@ -0,0 +1,149 @@
+package homebrew
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+func TestParseCaskFilename(t *testing.T) {
+	tests := []struct {
+		file, name, version, arch string
+	}{
+		{"MyApp-1.2.3.dmg", "MyApp", "1.2.3", "all"},
+		{"MyApp-1.2.3-arm64.dmg", "MyApp", "1.2.3", "arm64"},
+		{"my-app_v2.0_x86_64.pkg", "my-app", "2.0", "x86_64"},
+		{"my-app-2-1.0b1-universal.zip", "my-app-2", "1.0b1", "all"},
+	}
+
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.file)
+		if err := os.WriteFile(path, []byte("artifact"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		pkg, err := ParseCask(path)
+		if err != nil {
+			t.Errorf("ParseCask(%s): %v", tt.file, err)
+			continue
+		}
+		if pkg.Name != tt.name || pkg.Version != tt.version || pkg.Architecture != tt.arch {
+			t.Errorf("ParseCask(%s) = %s %s %s, want %s %s %s",
+				tt.file, pkg.Name, pkg.Version, pkg.Architecture, tt.name, tt.version, tt.arch)
+		}
+	}
+
+	path := filepath.Join(dir, "noversion.dmg")
+	os.WriteFile(path, []byte("artifact"), 0644)
+	if _, err := ParseCask(path); err == nil {
+		t.Error("ParseCask accepted an artifact without version")
+	}
+}
+
+func TestGenerateCaskPerArch(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	outputDir := filepath.Join(tmpDir, "output")
+	os.MkdirAll(inputDir, 0755)
+
+	// A zip for each architecture, holding the app bundle
+	var packages []models.Package
+	for _, name := range []string{"Viewer-2.0-arm64.zip", "Viewer-2.0-x86_64.zip", "Viewer-1.0-arm64.zip"} {
+		path := filepath.Join(inputDir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw := zip.NewWriter(f)
+		w, _ := zw.Create("Image Viewer.app/Contents/Info.plist")
+		w.Write([]byte(name))
+		zw.Close()
+		f.Close()
+
+		pkg, err := ParseCask(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packages = append(packages, *pkg)
+	}
+
+	gen := NewCaskGenerator("https://example.com/tap")
+	config := &models.RepositoryConfig{OutputDir: outputDir}
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "Casks", "viewer.rb"))
+	if err != nil {
+		t.Fatalf("cask not written: %v", err)
+	}
+	cask := string(data)
+	for _, want := range []string{
+		`cask "viewer" do`,
+		`version "2.0"`,
+		"on_arm do",
+		`url "https://example.com/tap/apps/Viewer-2.0-arm64.zip"`,
+		"on_intel do",
+		`url "https://example.com/tap/apps/Viewer-2.0-x86_64.zip"`,
+		`sha256 "` + packages[0].SHA256Sum + `"`,
+		`app "Image Viewer.app"`,
+	} {
+		if !strings.Contains(cask, want) {
+			t.Errorf("cask lacks %q:\n%s", want, cask)
+		}
+	}
+	if strings.Contains(cask, "Viewer-1.0") {
+		t.Errorf("cask references the older version:\n%s", cask)
+	}
+
+	for _, name := range []string{"Viewer-2.0-arm64.zip", "Viewer-2.0-x86_64.zip", "Viewer-1.0-arm64.zip"} {
+		if _, err := os.Stat(filepath.Join(outputDir, "apps", name)); err != nil {
+			t.Errorf("artifact %s not copied: %v", name, err)
+		}
+	}
+
+	// The cask reads back as its two downloads
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 2 {
+		t.Fatalf("expected 2 downloads, got %d", len(existing))
+	}
+	for _, pkg := range existing {
+		if pkg.Name != "Viewer" || pkg.Version != "2.0" {
+			t.Errorf("unexpected package %s %s", pkg.Name, pkg.Version)
+		}
+	}
+	if existing[0].Architecture != "arm64" || existing[1].Architecture != "x86_64" {
+		t.Errorf("unexpected architectures %s, %s", existing[0].Architecture, existing[1].Architecture)
+	}
+}
+
+func TestGeneratePkgCask(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "Agent-3.1.pkg")
+	os.WriteFile(path, []byte("pkg installer"), 0644)
+
+	pkg, err := ParseCask(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gen := &CaskGenerator{}
+	cask := gen.generateCask("agent", []models.Package{*pkg})
+	for _, want := range []string{`url "apps/Agent-3.1.pkg"`, `pkg "Agent-3.1.pkg"`} {
+		if !strings.Contains(cask, want) {
+			t.Errorf("cask lacks %q:\n%s", want, cask)
+		}
+	}
+	if strings.Contains(cask, "app \"") {
+		t.Errorf("pkg cask has an app stanza:\n%s", cask)
+	}
+}
@@ -3,8 +3,8 @@ package homebrew
 import (
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 
 	"github.com/ralt/repogen/internal/generator"
@@ -17,12 +17,14 @@ import (
 // Generator implements the generator.Generator interface for Homebrew taps
 type Generator struct {
 	baseURL string
+	rootURL string // Where bottles are downloaded from, defaults to baseURL/bottles
 }
 
 // NewGenerator creates a new Homebrew generator
-func NewGenerator(baseURL string) generator.Generator {
+func NewGenerator(baseURL, bottleRootURL string) generator.Generator {
 	return &Generator{
 		baseURL: baseURL,
+		rootURL: bottleRootURL,
 	}
 }
 
@@ -49,19 +51,49 @@ func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfi
 	}
 
 	// Generate formulas
+	var formulas []*apiFormula
 	for pkgName, bottles := range bottlesByPkg {
+		bottles, err := dedupeBottles(bottles)
+		if err != nil {
+			return err
+		}
+
+		dir, err := g.publishDir(config, bottlesDir, bottles)
+		if err != nil {
+			return err
+		}
+
 		// Copy bottles and recalculate checksums
 		updatedBottles := make([]models.Package, len(bottles))
 		for i, bottle := range bottles {
-			dstPath := filepath.Join(bottlesDir, filepath.Base(bottle.Filename))
-			if err := utils.CopyFile(bottle.Filename, dstPath); err != nil {
-				return fmt.Errorf("failed to copy %s: %w", bottle.Filename, err)
+			srcPath := bottle.Filename
+			dstPath := filepath.Join(bottlesDir, dir, filepath.Base(bottle.Filename))
+
+			// Bottles read back from an existing formula are published already,
+			// possibly only on remote storage
+			if _, err := os.Stat(srcPath); os.IsNotExist(err) {
+				current := g.bottleDir(bottle)
+				if current == dir {
+					updatedBottles[i] = bottle
+					continue
+				}
+				// The formula moves to another directory: take its local copy along
+				srcPath = filepath.Join(bottlesDir, current, filepath.Base(bottle.Filename))
+				if _, err := os.Stat(srcPath); err != nil {
+					return fmt.Errorf("cannot move %s to %s: %w", bottle.Filename, dstPath, err)
+				}
 			}
 
-			// Recalculate checksums on the copied file to ensure accuracy
-			checksums, err := utils.CalculateChecksums(dstPath)
+			// Copy bottle, checksumming it on the way
+			checksums, err := utils.CopyFileWithChecksums(ctx, srcPath, dstPath)
 			if err != nil {
-				return fmt.Errorf("failed to calculate checksums for %s: %w", filepath.Base(bottle.Filename), err)
+				return fmt.Errorf("failed to copy %s: %w", bottle.Filename, err)
+			}
+
+			if config.VerifyWrites {
+				if err := utils.VerifyCopy(dstPath, checksums); err != nil {
+					return fmt.Errorf("failed to verify %s: %w", bottle.Filename, err)
+				}
 			}
 
 			// Update bottle with copied file information
@@ -70,6 +102,11 @@ func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfi
 			updatedBottle.MD5Sum = checksums.MD5
 			updatedBottle.SHA1Sum = checksums.SHA1
 			updatedBottle.SHA256Sum = checksums.SHA256
+			updatedBottle.Metadata = make(map[string]interface{}, len(bottle.Metadata)+1)
+			for k, v := range bottle.Metadata {
+				updatedBottle.Metadata[k] = v
+			}
+			updatedBottle.Metadata["BottleDir"] = dir
 			updatedBottles[i] = updatedBottle
 		}
 
@@ -86,145 +123,180 @@ func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfi
 		}
 
 		logrus.Infof("Generated formula for %s (%s.rb)", pkgName, className)
+
+		formulas = append(formulas, g.apiFormulaFor(config.RepoName, pkgName, updatedBottles))
+	}
+
+	// The same formulas for clients of the JSON API, which don't clone the tap
+	if err := writeAPI(config.OutputDir, formulas); err != nil {
+		return err
 	}
 
 	logrus.Infof("Homebrew tap generated successfully (%d formulas)", len(bottlesByPkg))
 	return nil
 }
 
-// generateFormula creates a Ruby formula file
+// generateFormula creates a Ruby formula file. The formula installs from
+// the first bottle and lists every bottle in its bottle block, keyed by
+// platform tag, so brew pours the one matching the client
 func (g *Generator) generateFormula(name string, bottles []models.Package) (string, error) {
 	className := toClassName(name)
 
-	// Extract version from first bottle
+	bottles = sortedBottles(bottles)
+	first := bottles[0]
+
 	version := "1.0.0"
-	if len(bottles) > 0 && bottles[0].Version != "" {
-		version = bottles[0].Version
+	if first.Version != "" {
+		version = first.Version
 	}
 
-	// Get description
 	desc := fmt.Sprintf("%s package", name)
-	if len(bottles) > 0 && bottles[0].Description != "" {
-		desc = bottles[0].Description
+	if first.Description != "" {
+		desc = first.Description
 	}
 
-	// Get homepage
 	homepage := "https://example.com"
-	if len(bottles) > 0 && bottles[0].Homepage != "" {
-		homepage = bottles[0].Homepage
+	if first.Homepage != "" {
+		homepage = first.Homepage
+	}
+
+	// All bottles of a version share its rebuild number, brew deriving
+	// the file names from it
+	rebuilds := make(map[int]bool)
+	rebuild := 0
+	for _, bottle := range bottles {
+		info, _ := parseBottleFilename(bottle.Filename)
+		rebuilds[info.Rebuild] = true
+		if info.Rebuild > rebuild {
+			rebuild = info.Rebuild
+		}
+	}
+	if len(rebuilds) > 1 {
+		logrus.Warnf("Bottles of %s have different rebuild numbers, using %d", name, rebuild)
 	}
 
-	// Build formula
 	var formula strings.Builder
 
 	fmt.Fprintf(&formula, "class %s < Formula\n", className)
 	fmt.Fprintf(&formula, "  desc \"%s\"\n", desc)
 	fmt.Fprintf(&formula, "  homepage \"%s\"\n", homepage)
+	fmt.Fprintf(&formula, "  url \"%s\"\n", g.getBottleURL(first))
 	fmt.Fprintf(&formula, "  version \"%s\"\n", version)
-	formula.WriteString("\n")
-
-	// Group bottles by platform
-	macosBottles := []models.Package{}
-	linuxBottles := []models.Package{}
+	fmt.Fprintf(&formula, "  sha256 \"%s\"\n", first.SHA256Sum)
 
+	formula.WriteString("\n  bottle do\n")
+	fmt.Fprintf(&formula, "    root_url \"%s\"\n", g.formulaRootURL(first))
+	if rebuild > 0 {
+		fmt.Fprintf(&formula, "    rebuild %d\n", rebuild)
+	}
 	for _, bottle := range bottles {
-		platform := extractPlatform(bottle.Filename)
-		if strings.Contains(platform, "linux") {
-			linuxBottles = append(linuxBottles, bottle)
-		} else {
-			macosBottles = append(macosBottles, bottle)
+		info, ok := parseBottleFilename(bottle.Filename)
+		if !ok {
+			continue
 		}
+		fmt.Fprintf(&formula, "    sha256 cellar: :any, %s: \"%s\"\n", info.Tag, bottle.SHA256Sum)
 	}
+	formula.WriteString("  end\n")
 
-	// macOS bottles
-	if len(macosBottles) > 0 {
-		formula.WriteString("  on_macos do\n")
+	formula.WriteString("end\n")
 
-		armBottle := findBottleForArch(macosBottles, "arm64")
-		x86Bottle := findBottleForArch(macosBottles, "x86_64")
+	return formula.String(), nil
+}
 
-		if armBottle != nil {
-			formula.WriteString("    if Hardware::CPU.arm?\n")
-			url := g.getBottleURL(armBottle.Filename)
-			fmt.Fprintf(&formula, "      url \"%s\"\n", url)
-			fmt.Fprintf(&formula, "      sha256 \"%s\"\n", armBottle.SHA256Sum)
-			formula.WriteString("    end\n")
-		}
+// getBottleURL constructs the URL for a bottle
+func (g *Generator) getBottleURL(bottle models.Package) string {
+	return fmt.Sprintf("%s/%s", g.formulaRootURL(bottle), filepath.Base(bottle.Filename))
+}
 
-		if x86Bottle != nil {
-			if armBottle != nil {
-				formula.WriteString("    if Hardware::CPU.intel?\n")
-			}
-			url := g.getBottleURL(x86Bottle.Filename)
-			fmt.Fprintf(&formula, "      url \"%s\"\n", url)
-			fmt.Fprintf(&formula, "      sha256 \"%s\"\n", x86Bottle.SHA256Sum)
-			if armBottle != nil {
-				formula.WriteString("    end\n")
-			}
-		}
+// formulaRootURL returns the root_url of the formula publishing bottle:
+// the bottle root URL, or its digest directory
+func (g *Generator) formulaRootURL(bottle models.Package) string {
+	if dir := g.bottleDir(bottle); dir != "" {
+		return g.bottleRootURL() + "/" + dir
+	}
+	return g.bottleRootURL()
+}
 
-		formula.WriteString("  end\n")
+// bottleDir returns the directory under bottles/ a bottle is published
+// in, "" for bottles/ itself
+func (g *Generator) bottleDir(bottle models.Package) string {
+	if dir, ok := bottle.Metadata["BottleDir"].(string); ok {
+		return dir
+	}
+	// Bottles read back from a formula are named by their URL
+	if i := strings.LastIndex(bottle.Filename, "/"); i >= 0 {
+		dir, ok := strings.CutPrefix(bottle.Filename[:i], g.bottleRootURL()+"/")
+		if ok && digestDirRe.MatchString(dir) {
+			return dir
+		}
 	}
+	return ""
+}
 
-	// Linux bottles
-	if len(linuxBottles) > 0 {
-		formula.WriteString("\n  on_linux do\n")
-		bottle := linuxBottles[0]
-		url := g.getBottleURL(bottle.Filename)
-		fmt.Fprintf(&formula, "    url \"%s\"\n", url)
-		fmt.Fprintf(&formula, "    sha256 \"%s\"\n", bottle.SHA256Sum)
-		formula.WriteString("  end\n")
+// publishDir returns the directory under bottles/ the bottles of a formula
+// are published in. They go to bottles/ itself unless one of them would
+// replace a published bottle with different content there, which brew
+// clients holding the previous formula would then fail to verify
+func (g *Generator) publishDir(config *models.RepositoryConfig, bottlesDir string, bottles []models.Package) (string, error) {
+	// Without new bottles the formula stays where it is
+	fresh := false
+	for _, bottle := range bottles {
+		if _, err := os.Stat(bottle.Filename); err == nil {
+			fresh = true
+			break
+		}
+	}
+	if !fresh {
+		return g.bottleDir(bottles[0]), nil
 	}
 
-	formula.WriteString("end\n")
+	var collisions []string
+	for _, bottle := range bottles {
+		published, err := utils.CalculateChecksums(filepath.Join(bottlesDir, filepath.Base(bottle.Filename)))
+		if err != nil {
+			continue
+		}
+		if published.SHA256 != bottle.SHA256Sum {
+			collisions = append(collisions, filepath.Base(bottle.Filename))
+		}
+	}
+	if len(collisions) == 0 {
+		return "", nil
+	}
 
-	return formula.String(), nil
+	if config.BottleCollisions == CollisionDigest {
+		dir := bottleSetDigest(bottles)
+		logrus.Infof("Publishing %s under bottles/%s: %s differs from the published bottle", extractPackageName(bottles[0].Filename), dir, strings.Join(collisions, ", "))
+		return dir, nil
+	}
+	return "", fmt.Errorf("bottle collision: %s would replace a published bottle with different content "+
+		"(bump the bottle rebuild number, or pass --bottle-collisions %s)", strings.Join(collisions, ", "), CollisionDigest)
 }
 
-// getBottleURL constructs the URL for a bottle
-func (g *Generator) getBottleURL(filename string) string {
+// bottleRootURL returns the URL bottles are downloaded from, the root_url
+// of the bottle block
+func (g *Generator) bottleRootURL() string {
+	if g.rootURL != "" {
+		return strings.TrimRight(g.rootURL, "/")
+	}
 	if g.baseURL != "" {
-		return fmt.Sprintf("%s/bottles/%s", strings.TrimRight(g.baseURL, "/"), filepath.Base(filename))
+		return fmt.Sprintf("%s/bottles", strings.TrimRight(g.baseURL, "/"))
 	}
-	return fmt.Sprintf("bottles/%s", filepath.Base(filename))
+	return "bottles"
 }
 
 // extractPackageName extracts package name from bottle filename
-// Format: package--version.platform.bottle.tar.gz
+// Format: package--version.platform.bottle[.rebuild].tar.gz
 func extractPackageName(filename string) string {
-	base := filepath.Base(filename)
-	// Remove .bottle.tar.gz or .bottle.tar
-	base = strings.TrimSuffix(base, ".bottle.tar.gz")
-	base = strings.TrimSuffix(base, ".bottle.tar")
-
-	// Split by -- to get package name
-	parts := strings.Split(base, "--")
-	if len(parts) > 0 {
-		return parts[0]
+	if info, ok := parseBottleFilename(filename); ok {
+		return info.Name
 	}
 
-	return base
-}
-
-// extractPlatform extracts platform from bottle filename
-func extractPlatform(filename string) string {
 	base := filepath.Base(filename)
-	re := regexp.MustCompile(`--(.*)\.(bottle\.tar\.gz|bottle\.tar)`)
-	matches := re.FindStringSubmatch(base)
-	if len(matches) > 1 {
-		return matches[1]
+	if name, _, ok := strings.Cut(base, "--"); ok {
+		return name
 	}
-	return "unknown"
-}
-
-// findBottleForArch finds a bottle for a specific architecture
-func findBottleForArch(bottles []models.Package, arch string) *models.Package {
-	for _, bottle := range bottles {
-		if strings.Contains(bottle.Filename, arch) {
-			return &bottle
-		}
-	}
-	return nil
+	return base
 }
 
 // toClassName converts a package name to a Ruby class name
@@ -246,7 +318,7 @@ func toClassName(name string) string {
 // ValidatePackages checks if packages are valid Homebrew bottles
 func (g *Generator) ValidatePackages(packages []models.Package) error {
 	for _, pkg := range packages {
-		if !strings.Contains(pkg.Filename, ".bottle.tar") {
+		if _, ok := parseBottleFilename(pkg.Filename); !ok {
 			return fmt.Errorf("package %s is not a Homebrew bottle", pkg.Filename)
 		}
 	}
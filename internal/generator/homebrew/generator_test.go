@@ -0,0 +1,177 @@
+package homebrew
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+func TestParseBottleFilename(t *testing.T) {
+	tests := []struct {
+		file string
+		want bottleInfo
+	}{
+		{"hello--2.1.arm64_sonoma.bottle.tar.gz", bottleInfo{"hello", "2.1", "arm64_sonoma", 0}},
+		{"hello--2.1_1.x86_64_linux.bottle.2.tar.gz", bottleInfo{"hello", "2.1_1", "x86_64_linux", 2}},
+		{"https://example.com/bottles/my-tool--1.0.0.ventura.bottle.1.tar.gz", bottleInfo{"my-tool", "1.0.0", "ventura", 1}},
+	}
+	for _, tt := range tests {
+		got, ok := parseBottleFilename(tt.file)
+		if !ok || got != tt.want {
+			t.Errorf("parseBottleFilename(%s) = %+v, %v, want %+v", tt.file, got, ok, tt.want)
+		}
+		if filepath.Base(tt.file) != bottleFilename(got) {
+			t.Errorf("bottleFilename(%+v) = %s, want %s", got, bottleFilename(got), filepath.Base(tt.file))
+		}
+	}
+
+	if _, ok := parseBottleFilename("hello-2.1.tar.gz"); ok {
+		t.Error("parseBottleFilename accepted a non-bottle")
+	}
+}
+
+func TestGenerateBottleBlock(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	outputDir := filepath.Join(tmpDir, "output")
+	os.MkdirAll(inputDir, 0755)
+
+	var packages []models.Package
+	for _, name := range []string{"hello--2.1.x86_64_linux.bottle.1.tar.gz", "hello--2.1.arm64_sonoma.bottle.1.tar.gz"} {
+		path := filepath.Join(inputDir, name)
+		os.WriteFile(path, []byte(name), 0644)
+		pkg, err := ParseBottle(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packages = append(packages, *pkg)
+	}
+
+	gen := NewGenerator("https://example.com/tap", "https://dl.example.com/bottles/")
+	config := &models.RepositoryConfig{OutputDir: outputDir}
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "Formula", "hello.rb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	formula := string(data)
+	for _, want := range []string{
+		`version "2.1"`,
+		"  bottle do\n",
+		`    root_url "https://dl.example.com/bottles"`,
+		"    rebuild 1\n",
+		`    sha256 cellar: :any, arm64_sonoma: "`,
+		`    sha256 cellar: :any, x86_64_linux: "`,
+	} {
+		if !strings.Contains(formula, want) {
+			t.Errorf("formula lacks %q:\n%s", want, formula)
+		}
+	}
+
+	// The bottle block reads back as one package per bottle
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 2 {
+		t.Fatalf("expected 2 bottles, got %d", len(existing))
+	}
+	for _, pkg := range existing {
+		if pkg.Name != "hello" || pkg.Version != "2.1" {
+			t.Errorf("unexpected package %+v", pkg)
+		}
+		want := "https://dl.example.com/bottles/hello--2.1." + pkg.Architecture + ".bottle.1.tar.gz"
+		if pkg.Filename != want {
+			t.Errorf("Filename = %s, want %s", pkg.Filename, want)
+		}
+	}
+
+	// Regenerating from the published formula reproduces it
+	if err := gen.Generate(context.Background(), config, existing); err != nil {
+		t.Fatalf("Generate from existing failed: %v", err)
+	}
+	again, _ := os.ReadFile(filepath.Join(outputDir, "Formula", "hello.rb"))
+	if string(again) != formula {
+		t.Errorf("regenerated formula differs:\n%s\nwant:\n%s", again, formula)
+	}
+}
+
+func TestBottleCollisions(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputDir := filepath.Join(tmpDir, "output")
+	name := "hello--2.1.arm64_sonoma.bottle.tar.gz"
+
+	bottle := func(dir, content string) models.Package {
+		path := filepath.Join(tmpDir, dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+		pkg, err := ParseBottle(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *pkg
+	}
+
+	gen := NewGenerator("https://example.com/tap", "")
+	config := &models.RepositoryConfig{OutputDir: outputDir}
+	if err := gen.Generate(context.Background(), config, []models.Package{bottle("v1", "first build")}); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	// Two different bottles of the same name can't share a formula
+	err := gen.Generate(context.Background(), config, []models.Package{bottle("a", "one"), bottle("b", "two")})
+	if err == nil || !strings.Contains(err.Error(), "bottle collision") {
+		t.Errorf("expected a collision between inputs, got %v", err)
+	}
+
+	// Republishing different content under the published name is refused
+	rebuilt := bottle("v2", "second build")
+	err = gen.Generate(context.Background(), config, []models.Package{rebuilt})
+	if err == nil || !strings.Contains(err.Error(), "bottle collision") {
+		t.Errorf("expected a collision with the published bottle, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(outputDir, "bottles", name)); string(data) != "first build" {
+		t.Errorf("published bottle was overwritten: %q", data)
+	}
+
+	// The digest policy publishes the formula beside the previous bottle
+	config.BottleCollisions = CollisionDigest
+	if err := gen.Generate(context.Background(), config, []models.Package{rebuilt}); err != nil {
+		t.Fatalf("Generate with digest layout failed: %v", err)
+	}
+	digest := bottleSetDigest([]models.Package{rebuilt})
+	if data, _ := os.ReadFile(filepath.Join(outputDir, "bottles", digest, name)); string(data) != "second build" {
+		t.Errorf("rebuilt bottle not under its digest directory: %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(outputDir, "bottles", name)); string(data) != "first build" {
+		t.Errorf("published bottle was overwritten: %q", data)
+	}
+
+	formula, _ := os.ReadFile(filepath.Join(outputDir, "Formula", "hello.rb"))
+	rootURL := "https://example.com/tap/bottles/" + digest
+	if !strings.Contains(string(formula), `root_url "`+rootURL+`"`) || !strings.Contains(string(formula), `url "`+rootURL+"/"+name+`"`) {
+		t.Errorf("formula does not point at the digest directory:\n%s", formula)
+	}
+
+	// The digest directory reads back and stays put
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil || len(existing) != 1 {
+		t.Fatalf("ParseExistingMetadata = %v, %v", existing, err)
+	}
+	if files := gen.(*Generator).PackageFiles(config, existing[0]); files[0] != filepath.Join(outputDir, "bottles", digest, name) {
+		t.Errorf("PackageFiles = %v", files)
+	}
+	if err := gen.Generate(context.Background(), config, existing); err != nil {
+		t.Fatalf("Generate from existing failed: %v", err)
+	}
+	if again, _ := os.ReadFile(filepath.Join(outputDir, "Formula", "hello.rb")); string(again) != string(formula) {
+		t.Errorf("regenerated formula differs:\n%s\nwant:\n%s", again, formula)
+	}
+}
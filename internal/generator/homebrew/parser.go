@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/ralt/repogen/internal/models"
@@ -40,6 +41,9 @@ func (g *Generator) ParseExistingMetadata(config *models.RepositoryConfig) ([]mo
 	return packages, nil
 }
 
+// parseFormula reads the bottles referenced by a formula: those of its
+// bottle block, or for formulas written before bottle blocks, each
+// url/sha256 pair
 func parseFormula(path string) ([]models.Package, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -50,18 +54,43 @@ func parseFormula(path string) ([]models.Package, error) {
 	var packages []models.Package
 
 	// Regex patterns
-	versionRe := regexp.MustCompile(`version\s+"([^"]+)"`)
-	descRe := regexp.MustCompile(`desc\s+"([^"]+)"`)
-	homepageRe := regexp.MustCompile(`homepage\s+"([^"]+)"`)
-	urlRe := regexp.MustCompile(`url\s+"([^"]+)"`)
-	sha256Re := regexp.MustCompile(`sha256\s+"([^"]+)"`)
+	versionRe := regexp.MustCompile(`^version\s+"([^"]+)"`)
+	descRe := regexp.MustCompile(`^desc\s+"([^"]+)"`)
+	homepageRe := regexp.MustCompile(`^homepage\s+"([^"]+)"`)
+	urlRe := regexp.MustCompile(`^url\s+"([^"]+)"`)
+	sha256Re := regexp.MustCompile(`^sha256\s+"([^"]+)"`)
+	rootURLRe := regexp.MustCompile(`^root_url\s+"([^"]+)"`)
+	rebuildRe := regexp.MustCompile(`^rebuild\s+(\d+)`)
+	bottleSHA256Re := regexp.MustCompile(`^sha256\s+(?:cellar:\s+\S+,\s+)?(\w+):\s+"([^"]+)"`)
 
 	scanner := bufio.NewScanner(f)
-	var version, desc, homepage, url, sha256 string
+	var version, desc, homepage, url, sha256, rootURL string
+	var rebuild int
+	inBottle := false
+	tags := make(map[string]string)
+	var tagOrder []string
 
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 
+		if line == "bottle do" {
+			inBottle = true
+			continue
+		}
+		if inBottle {
+			if line == "end" {
+				inBottle = false
+			} else if matches := rootURLRe.FindStringSubmatch(line); len(matches) > 1 {
+				rootURL = matches[1]
+			} else if matches := rebuildRe.FindStringSubmatch(line); len(matches) > 1 {
+				rebuild, _ = strconv.Atoi(matches[1])
+			} else if matches := bottleSHA256Re.FindStringSubmatch(line); len(matches) > 2 {
+				tags[matches[1]] = matches[2]
+				tagOrder = append(tagOrder, matches[1])
+			}
+			continue
+		}
+
 		if matches := versionRe.FindStringSubmatch(line); len(matches) > 1 {
 			version = matches[1]
 		}
@@ -76,46 +105,60 @@ func parseFormula(path string) ([]models.Package, error) {
 		}
 		if matches := sha256Re.FindStringSubmatch(line); len(matches) > 1 {
 			sha256 = matches[1]
+		}
 
-			// URL + SHA256 = one package/bottle
-			if url != "" {
-				pkg := models.Package{
-					Name:        extractPackageNameFromURL(url),
-					Version:     version,
-					Description: desc,
-					Homepage:    homepage,
-					Filename:    url,
-					SHA256Sum:   sha256,
-					Metadata:    make(map[string]interface{}),
-				}
-				packages = append(packages, pkg)
-
-				// Reset for next bottle
-				url = ""
-				sha256 = ""
-			}
+		// URL + SHA256 = one package/bottle
+		if url != "" && sha256 != "" {
+			packages = append(packages, models.Package{
+				Name:      extractPackageName(url),
+				Filename:  url,
+				SHA256Sum: sha256,
+			})
+
+			// Reset for next bottle
+			url = ""
+			sha256 = ""
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 
-	return packages, scanner.Err()
-}
+	// The bottle block lists every bottle; the url stanza is one of them
+	if len(tags) > 0 {
+		name := strings.TrimSuffix(filepath.Base(path), ".rb")
+		packages = packages[:0]
+		for _, tag := range tagOrder {
+			filename := bottleFilename(bottleInfo{Name: name, Version: version, Tag: tag, Rebuild: rebuild})
+			if rootURL != "" {
+				filename = rootURL + "/" + filename
+			}
+			packages = append(packages, models.Package{
+				Name:         name,
+				Architecture: tag,
+				Filename:     filename,
+				SHA256Sum:    tags[tag],
+			})
+		}
+	}
 
-func extractPackageNameFromURL(url string) string {
-	// Extract from URL like "bottles/package--1.0.0.platform.bottle.tar.gz"
-	parts := strings.Split(url, "/")
-	if len(parts) == 0 {
-		return ""
+	for i := range packages {
+		packages[i].Version = version
+		packages[i].Description = desc
+		packages[i].Homepage = homepage
+		packages[i].Metadata = make(map[string]interface{})
 	}
-	filename := parts[len(parts)-1]
 
-	// Remove .bottle.tar.gz
-	filename = strings.TrimSuffix(filename, ".bottle.tar.gz")
-	filename = strings.TrimSuffix(filename, ".bottle.tar")
+	return packages, nil
+}
 
-	// Split by --
-	nameParts := strings.Split(filename, "--")
-	if len(nameParts) > 0 {
-		return nameParts[0]
+// PackageFiles returns the bottle backing a package and the formula (Ruby
+// and JSON API document) that references it. A formula holds a single
+// version, so removing the version removes the formula too
+func (g *Generator) PackageFiles(config *models.RepositoryConfig, pkg models.Package) []string {
+	return []string{
+		filepath.Join(config.OutputDir, "bottles", g.bottleDir(pkg), filepath.Base(pkg.Filename)),
+		filepath.Join(config.OutputDir, "Formula", fmt.Sprintf("%s.rb", pkg.Name)),
+		filepath.Join(config.OutputDir, apiDir, "formula", fmt.Sprintf("%s.json", pkg.Name)),
 	}
-	return filename
 }
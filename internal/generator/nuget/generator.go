@@ -0,0 +1,385 @@
+package nuget
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Packages are served from flatContainerDir, and registrations from
+// registrationDir
+const (
+	flatContainerDir = "v3-flatcontainer"
+	registrationDir  = "v3/registration"
+)
+
+// serviceIndex is the index.json clients are pointed at
+type serviceIndex struct {
+	Version   string     `json:"version"`
+	Resources []resource `json:"resources"`
+}
+
+type resource struct {
+	ID      string `json:"@id"`
+	Type    string `json:"@type"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// versionList is the flat container index of a package
+type versionList struct {
+	Versions []string `json:"versions"`
+}
+
+// registrationIndex lists the versions of a package, in a single page
+type registrationIndex struct {
+	ID    string             `json:"@id"`
+	Type  []string           `json:"@type,omitempty"`
+	Count int                `json:"count"`
+	Items []registrationPage `json:"items"`
+}
+
+type registrationPage struct {
+	ID     string             `json:"@id"`
+	Type   string             `json:"@type,omitempty"`
+	Count  int                `json:"count"`
+	Items  []registrationLeaf `json:"items"`
+	Lower  string             `json:"lower"`
+	Upper  string             `json:"upper"`
+	Parent string             `json:"parent,omitempty"`
+}
+
+type registrationLeaf struct {
+	ID             string        `json:"@id"`
+	Type           interface{}   `json:"@type,omitempty"`
+	CatalogEntry   *catalogEntry `json:"catalogEntry,omitempty"`
+	Listed         *bool         `json:"listed,omitempty"`
+	PackageContent string        `json:"packageContent"`
+	Published      string        `json:"published,omitempty"`
+	Registration   string        `json:"registration"`
+}
+
+// Generator implements the generator.Generator interface for NuGet v3
+// static feeds
+type Generator struct{}
+
+// NewGenerator creates a new NuGet generator
+func NewGenerator() generator.Generator {
+	return &Generator{}
+}
+
+// Generate copies the packages and their nuspecs to the flat container,
+// and writes the service index and the registration of every package
+func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfig, packages []models.Package) error {
+	logrus.Info("Generating NuGet v3 feed...")
+
+	// Resources are absolute URLs in the service index
+	if config.BaseURL == "" {
+		return fmt.Errorf("NuGet feeds require --base-url, the URL the feed is served from")
+	}
+	baseURL := strings.TrimRight(config.BaseURL, "/")
+
+	registrationsURL := baseURL + "/" + registrationDir + "/"
+	index := serviceIndex{
+		Version: "3.0.0",
+		Resources: []resource{
+			{ID: baseURL + "/" + flatContainerDir + "/", Type: "PackageBaseAddress/3.0.0"},
+			{ID: registrationsURL, Type: "RegistrationsBaseUrl"},
+			{ID: registrationsURL, Type: "RegistrationsBaseUrl/3.0.0-beta"},
+			{ID: registrationsURL, Type: "RegistrationsBaseUrl/3.0.0-rc"},
+		},
+	}
+	if err := writeJSON(filepath.Join(config.OutputDir, "index.json"), index); err != nil {
+		return fmt.Errorf("failed to write service index: %w", err)
+	}
+
+	// Group versions by id, package ids being case insensitive
+	versionsByID := make(map[string][]models.Package)
+	for i := range packages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pkg := &packages[i]
+		if err := publishPackage(ctx, config, pkg); err != nil {
+			return fmt.Errorf("failed to publish %s: %w", pkg.Name, err)
+		}
+		key := strings.ToLower(pkg.Name)
+		versionsByID[key] = append(versionsByID[key], *pkg)
+	}
+
+	now := utils.Timestamp(config).Format(time.RFC3339)
+	for id, versions := range versionsByID {
+		sort.SliceStable(versions, func(i, j int) bool {
+			return utils.CompareSemanticVersions(versions[i].Version, versions[j].Version) < 0
+		})
+
+		if err := writePackageIndexes(config.OutputDir, baseURL, id, versions, now); err != nil {
+			return fmt.Errorf("failed to write registration of %s: %w", id, err)
+		}
+	}
+
+	// Packages left without versions drop out of the feed
+	if err := removeStalePackages(config.OutputDir, versionsByID); err != nil {
+		return err
+	}
+
+	logrus.Infof("NuGet feed generated successfully (%d packages, %d versions)", len(versionsByID), len(packages))
+	return nil
+}
+
+// publishPackage copies a package and its nuspec to
+// v3-flatcontainer/<id>/<version>/
+func publishPackage(ctx context.Context, config *models.RepositoryConfig, pkg *models.Package) error {
+	dstPath := filepath.Join(config.OutputDir, filepath.FromSlash(packageFile(pkg.Name, pkg.Version)))
+
+	// Packages read back from the registration are published already,
+	// possibly only on remote storage
+	srcPath, finalDstPath, needsCopy, err := utils.ShouldCopyPackage(pkg, dstPath, config.OutputDir)
+	if err != nil {
+		return fmt.Errorf("package copy check failed: %w", err)
+	}
+
+	if needsCopy {
+		logrus.Debugf("Copying package: %s -> %s", srcPath, finalDstPath)
+
+		checksums, err := utils.CopyFileWithChecksums(ctx, srcPath, finalDstPath)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", srcPath, err)
+		}
+
+		if config.VerifyWrites {
+			if err := utils.VerifyCopy(finalDstPath, checksums); err != nil {
+				return fmt.Errorf("failed to verify %s: %w", srcPath, err)
+			}
+		}
+
+		pkg.Size = checksums.Size
+		pkg.MD5Sum = checksums.MD5
+		pkg.SHA1Sum = checksums.SHA1
+		pkg.SHA256Sum = checksums.SHA256
+		pkg.SHA512Sum = checksums.SHA512
+	}
+
+	// Clients read the nuspec from the flat container before downloading
+	nuspecPath := filepath.Join(config.OutputDir, filepath.FromSlash(nuspecFile(pkg.Name, pkg.Version)))
+	if _, err := os.Stat(nuspecPath); needsCopy || os.IsNotExist(err) {
+		if _, err := os.Stat(finalDstPath); err == nil {
+			data, err := extractNuspec(finalDstPath)
+			if err != nil {
+				return fmt.Errorf("failed to extract nuspec: %w", err)
+			}
+			if err := utils.WriteFile(nuspecPath, data, 0644); err != nil {
+				return fmt.Errorf("failed to write nuspec: %w", err)
+			}
+		}
+	}
+
+	pkg.Filename = filepath.FromSlash(packageFile(pkg.Name, pkg.Version))
+	return nil
+}
+
+// writePackageIndexes writes the flat container version list and the
+// registration of a package, and removes the leaves of versions gone
+func writePackageIndexes(outputDir, baseURL, id string, versions []models.Package, published string) error {
+	registrationURL := fmt.Sprintf("%s/%s/%s/index.json", baseURL, registrationDir, id)
+
+	list := versionList{Versions: []string{}}
+	var leaves []registrationLeaf
+	leafFiles := make(map[string]bool)
+	for _, pkg := range versions {
+		version := strings.ToLower(pkg.Version)
+		list.Versions = append(list.Versions, version)
+
+		entry := entryFor(pkg, baseURL, published)
+		leafURL := fmt.Sprintf("%s/%s/%s/%s.json", baseURL, registrationDir, id, version)
+		leaves = append(leaves, registrationLeaf{
+			ID:             leafURL,
+			Type:           "Package",
+			CatalogEntry:   entry,
+			PackageContent: entry.PackageContent,
+			Registration:   registrationURL,
+		})
+
+		listed := entry.Listed
+		leafFiles[version+".json"] = true
+		if err := writeJSON(filepath.Join(outputDir, filepath.FromSlash(registrationDir), id, version+".json"), registrationLeaf{
+			ID:             leafURL,
+			Type:           []string{"Package", "http://schema.nuget.org/catalog#Permalink"},
+			Listed:         &listed,
+			PackageContent: entry.PackageContent,
+			Published:      entry.Published,
+			Registration:   registrationURL,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := writeJSON(filepath.Join(outputDir, flatContainerDir, id, "index.json"), list); err != nil {
+		return err
+	}
+
+	lower, upper := versions[0].Version, versions[len(versions)-1].Version
+	index := registrationIndex{
+		ID:    registrationURL,
+		Type:  []string{"catalog:CatalogRoot", "PackageRegistration", "catalog:Permalink"},
+		Count: 1,
+		Items: []registrationPage{{
+			ID:     fmt.Sprintf("%s#page/%s/%s", registrationURL, lower, upper),
+			Type:   "catalog:CatalogPage",
+			Count:  len(leaves),
+			Items:  leaves,
+			Lower:  lower,
+			Upper:  upper,
+			Parent: registrationURL,
+		}},
+	}
+	if err := writeJSON(filepath.Join(outputDir, filepath.FromSlash(registrationDir), id, "index.json"), index); err != nil {
+		return err
+	}
+
+	// Leaves of removed versions
+	dir := filepath.Join(outputDir, filepath.FromSlash(registrationDir), id)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.Name() == "index.json" || leafFiles[f.Name()] || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, f.Name())); err != nil {
+			return fmt.Errorf("failed to remove stale registration leaf: %w", err)
+		}
+	}
+	return nil
+}
+
+// entryFor returns the catalog entry of a package version: its hash and
+// download URL, and its deprecation. Versions already published keep
+// their publication time
+func entryFor(pkg models.Package, baseURL, published string) *catalogEntry {
+	entry := catalogEntry{ID: pkg.Name, Version: pkg.Version, Listed: true}
+	if known, ok := pkg.Metadata["CatalogEntry"].(*catalogEntry); ok {
+		entry = *known
+	}
+
+	id, version := strings.ToLower(entry.ID), strings.ToLower(entry.Version)
+	entry.URL = fmt.Sprintf("%s/%s/%s/%s.json#catalog", baseURL, registrationDir, id, version)
+	entry.Type = "PackageDetails"
+	entry.PackageContent = baseURL + "/" + packageFile(entry.ID, entry.Version)
+	entry.PackageSize = pkg.Size
+	if sum, err := hex.DecodeString(pkg.SHA512Sum); err == nil && len(sum) > 0 {
+		entry.PackageHash = base64.StdEncoding.EncodeToString(sum)
+		entry.PackageHashAlgorithm = "SHA512"
+	}
+	if entry.Published == "" {
+		entry.Published = published
+	}
+
+	entry.Deprecation = nil
+	if d := pkg.Deprecation; d != nil {
+		entry.Deprecation = &deprecation{Reasons: []string{"Legacy"}, Message: d.Message}
+		if d.Message == "" && d.EOL != "" {
+			entry.Deprecation.Message = "end of life since " + d.EOL
+		}
+		if d.ReplacedBy != "" {
+			entry.Deprecation.AlternatePackage = &alternatePackage{ID: d.ReplacedBy, Range: "*"}
+		}
+	}
+
+	return &entry
+}
+
+// removeStalePackages deletes the registration and version list of
+// packages without versions
+func removeStalePackages(outputDir string, versionsByID map[string][]models.Package) error {
+	dirs, err := os.ReadDir(filepath.Join(outputDir, filepath.FromSlash(registrationDir)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		if _, ok := versionsByID[d.Name()]; ok {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(outputDir, filepath.FromSlash(registrationDir), d.Name())); err != nil {
+			return fmt.Errorf("failed to remove stale registration: %w", err)
+		}
+		if err := os.Remove(filepath.Join(outputDir, flatContainerDir, d.Name(), "index.json")); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale version list: %w", err)
+		}
+		logrus.Infof("Removed package %s, which has no versions left", d.Name())
+	}
+	return nil
+}
+
+// writeJSON writes v as indented JSON
+func writeJSON(file string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFile(file, append(data, '\n'), 0644)
+}
+
+// ValidatePackages checks if packages are NuGet packages
+func (g *Generator) ValidatePackages(packages []models.Package) error {
+	for _, pkg := range packages {
+		if !strings.HasSuffix(strings.ToLower(pkg.Filename), ".nupkg") {
+			return fmt.Errorf("package %s is not a NuGet package", pkg.Filename)
+		}
+	}
+	return nil
+}
+
+// GetSupportedType returns the package type this generator supports
+func (g *Generator) GetSupportedType() scanner.PackageType {
+	return scanner.TypeNuget
+}
+
+// ParseExistingMetadata reads the registrations of the feed
+func (g *Generator) ParseExistingMetadata(config *models.RepositoryConfig) ([]models.Package, error) {
+	files, err := filepath.Glob(filepath.Join(config.OutputDir, filepath.FromSlash(registrationDir), "*", "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var packages []models.Package
+	for _, file := range files {
+		versions, err := parseRegistration(file)
+		if err != nil {
+			logrus.Warnf("Skipping %s: %v", file, err)
+			continue
+		}
+		packages = append(packages, versions...)
+	}
+
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no existing NuGet feed found in %s", config.OutputDir)
+	}
+
+	return packages, nil
+}
+
+// PackageFiles returns the paths of a package version and its nuspec
+func (g *Generator) PackageFiles(config *models.RepositoryConfig, pkg models.Package) []string {
+	return []string{
+		filepath.Join(config.OutputDir, filepath.FromSlash(packageFile(pkg.Name, pkg.Version))),
+		filepath.Join(config.OutputDir, filepath.FromSlash(nuspecFile(pkg.Name, pkg.Version))),
+	}
+}
@@ -0,0 +1,223 @@
+package nuget
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+// writeNupkg writes a .nupkg holding the given nuspec
+func writeNupkg(t *testing.T, dir, id, version, spec string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, id+"."+version+".nupkg")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, data := range map[string]string{
+		id + ".nuspec":               spec,
+		"lib/net8.0/" + id + ".dll":  "MZ",
+		"[Content_Types].xml":        "<Types/>",
+		"package/services/metadata/": "",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const helloNuspec = `<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://schemas.microsoft.com/packaging/2013/05/nuspec.xsd">
+  <metadata>
+    <id>Hello.World</id>
+    <version>1.02.0.0</version>
+    <authors>Jane Doe</authors>
+    <description>
+      Says hello
+    </description>
+    <license type="expression">MIT</license>
+    <projectUrl>https://example.com/hello</projectUrl>
+    <tags>greeting hello</tags>
+    <dependencies>
+      <group targetFramework="net8.0">
+        <dependency id="Newtonsoft.Json" version="13.0.1" exclude="Build" />
+        <dependency id="Hello.Core" version="[1.0.0, 2.0.0)" />
+      </group>
+      <group targetFramework=".NETStandard2.0" />
+    </dependencies>
+  </metadata>
+</package>
+`
+
+func TestNormalizeVersion(t *testing.T) {
+	tests := map[string]string{
+		"1.0":                "1.0.0",
+		"1.02.0.0":           "1.2.0",
+		"1.0.0.4":            "1.0.0.4",
+		"2.0.0-Beta.1+abcde": "2.0.0-Beta.1",
+		"3":                  "3.0.0",
+	}
+	for version, want := range tests {
+		if got := normalizeVersion(version); got != want {
+			t.Errorf("normalizeVersion(%q) = %q, want %q", version, got, want)
+		}
+	}
+}
+
+func TestParsePackage(t *testing.T) {
+	pkg, err := ParsePackage(writeNupkg(t, t.TempDir(), "Hello.World", "1.2.0", helloNuspec))
+	if err != nil {
+		t.Fatalf("ParsePackage failed: %v", err)
+	}
+
+	if pkg.Name != "Hello.World" || pkg.Version != "1.2.0" || pkg.Description != "Says hello" || pkg.License != "MIT" {
+		t.Errorf("unexpected package %+v", pkg)
+	}
+	if strings.Join(pkg.Dependencies, ",") != "Newtonsoft.Json,Hello.Core" {
+		t.Errorf("unexpected dependencies %v", pkg.Dependencies)
+	}
+
+	entry := pkg.Metadata["CatalogEntry"].(*catalogEntry)
+	if len(entry.DependencyGroups) != 2 || entry.DependencyGroups[0].Dependencies[0].Range != "[13.0.1, )" {
+		t.Errorf("unexpected dependency groups %+v", entry.DependencyGroups)
+	}
+	if strings.Join(entry.Tags, ",") != "greeting,hello" {
+		t.Errorf("unexpected tags %v", entry.Tags)
+	}
+
+	if _, err := ParsePackage(writeNupkg(t, t.TempDir(), "Empty", "1.0.0", "<package><metadata/></package>")); err == nil {
+		t.Error("ParsePackage accepted a nuspec without id")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputDir := filepath.Join(tmpDir, "output")
+
+	var packages []models.Package
+	for _, version := range []string{"1.0.0", "1.2.0"} {
+		spec := strings.Replace(helloNuspec, "1.02.0.0", version, 1)
+		pkg, err := ParsePackage(writeNupkg(t, tmpDir, "Hello.World", version, spec))
+		if err != nil {
+			t.Fatalf("ParsePackage failed: %v", err)
+		}
+		packages = append(packages, *pkg)
+	}
+	spec := "<package><metadata><id>Other</id><version>0.1.0-RC1</version><description>Other</description></metadata></package>"
+	pkg, err := ParsePackage(writeNupkg(t, tmpDir, "Other", "0.1.0-RC1", spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packages = append(packages, *pkg)
+	packages[0].Deprecation = &models.Deprecation{Message: "broken", ReplacedBy: "Hello.Next"}
+
+	gen := NewGenerator()
+	config := &models.RepositoryConfig{OutputDir: outputDir}
+	if err := gen.Generate(context.Background(), config, packages); err == nil {
+		t.Error("Generate succeeded without a base URL")
+	}
+
+	config.BaseURL = "https://nuget.example.com/"
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	var index serviceIndex
+	readJSON(t, filepath.Join(outputDir, "index.json"), &index)
+	if index.Version != "3.0.0" || index.Resources[0].ID != "https://nuget.example.com/v3-flatcontainer/" || index.Resources[0].Type != "PackageBaseAddress/3.0.0" {
+		t.Errorf("unexpected service index %+v", index)
+	}
+
+	var list versionList
+	readJSON(t, filepath.Join(outputDir, "v3-flatcontainer", "hello.world", "index.json"), &list)
+	if strings.Join(list.Versions, ",") != "1.0.0,1.2.0" {
+		t.Errorf("unexpected versions %v", list.Versions)
+	}
+	for _, file := range []string{
+		"v3-flatcontainer/hello.world/1.2.0/hello.world.1.2.0.nupkg",
+		"v3-flatcontainer/hello.world/1.2.0/hello.world.nuspec",
+		"v3-flatcontainer/other/0.1.0-rc1/other.0.1.0-rc1.nupkg",
+		"v3/registration/other/0.1.0-rc1.json",
+	} {
+		if _, err := os.Stat(filepath.Join(outputDir, filepath.FromSlash(file))); err != nil {
+			t.Errorf("missing %s: %v", file, err)
+		}
+	}
+
+	var registration registrationIndex
+	readJSON(t, filepath.Join(outputDir, "v3", "registration", "hello.world", "index.json"), &registration)
+	page := registration.Items[0]
+	if page.Lower != "1.0.0" || page.Upper != "1.2.0" || page.Count != 2 {
+		t.Errorf("unexpected page %+v", page)
+	}
+	entry := page.Items[0].CatalogEntry
+	if entry.PackageContent != "https://nuget.example.com/v3-flatcontainer/hello.world/1.0.0/hello.world.1.0.0.nupkg" || entry.PackageHashAlgorithm != "SHA512" || entry.Published == "" {
+		t.Errorf("unexpected catalog entry %+v", entry)
+	}
+	if entry.Deprecation == nil || entry.Deprecation.Message != "broken" || entry.Deprecation.AlternatePackage.ID != "Hello.Next" {
+		t.Errorf("unexpected deprecation %+v", entry.Deprecation)
+	}
+
+	// Read back, then drop a package
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 3 {
+		t.Fatalf("expected 3 packages, got %d", len(existing))
+	}
+	for _, pkg := range existing {
+		if pkg.Name == "Hello.World" && pkg.Version == "1.0.0" {
+			if pkg.SHA512Sum != packages[0].SHA512Sum || pkg.Deprecation == nil || pkg.Deprecation.ReplacedBy != "Hello.Next" {
+				t.Errorf("unexpected package read back %+v", pkg)
+			}
+		}
+	}
+
+	var kept []models.Package
+	for _, pkg := range existing {
+		if pkg.Name != "Other" {
+			kept = append(kept, pkg)
+		}
+	}
+	if err := gen.Generate(context.Background(), config, kept); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "v3", "registration", "other")); !os.IsNotExist(err) {
+		t.Error("stale registration was not removed")
+	}
+
+	var again registrationIndex
+	readJSON(t, filepath.Join(outputDir, "v3", "registration", "hello.world", "index.json"), &again)
+	if published := again.Items[0].Items[0].CatalogEntry.Published; published != entry.Published {
+		t.Errorf("publication time changed from %s to %s", entry.Published, published)
+	}
+}
+
+func readJSON(t *testing.T, file string, v interface{}) {
+	t.Helper()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("invalid %s: %v", file, err)
+	}
+}
@@ -0,0 +1,327 @@
+package nuget
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// nuspec is the manifest at the root of a .nupkg
+type nuspec struct {
+	Metadata struct {
+		ID                       string `xml:"id"`
+		Version                  string `xml:"version"`
+		Title                    string `xml:"title"`
+		Authors                  string `xml:"authors"`
+		Description              string `xml:"description"`
+		Summary                  string `xml:"summary"`
+		ReleaseNotes             string `xml:"releaseNotes"`
+		Copyright                string `xml:"copyright"`
+		Language                 string `xml:"language"`
+		Tags                     string `xml:"tags"`
+		ProjectURL               string `xml:"projectUrl"`
+		IconURL                  string `xml:"iconUrl"`
+		LicenseURL               string `xml:"licenseUrl"`
+		RequireLicenseAcceptance bool   `xml:"requireLicenseAcceptance"`
+		License                  struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"license"`
+		Dependencies struct {
+			Groups []struct {
+				TargetFramework string             `xml:"targetFramework,attr"`
+				Dependencies    []nuspecDependency `xml:"dependency"`
+			} `xml:"group"`
+			Dependencies []nuspecDependency `xml:"dependency"` // Before dependency groups
+		} `xml:"dependencies"`
+	} `xml:"metadata"`
+}
+
+type nuspecDependency struct {
+	ID      string `xml:"id,attr"`
+	Version string `xml:"version,attr"`
+}
+
+// catalogEntry describes a package version in its registration, with the
+// package hash and size of catalog entries
+type catalogEntry struct {
+	URL                      string            `json:"@id,omitempty"`
+	Type                     string            `json:"@type,omitempty"`
+	ID                       string            `json:"id"`
+	Version                  string            `json:"version"`
+	Authors                  string            `json:"authors,omitempty"`
+	Copyright                string            `json:"copyright,omitempty"`
+	DependencyGroups         []dependencyGroup `json:"dependencyGroups,omitempty"`
+	Deprecation              *deprecation      `json:"deprecation,omitempty"`
+	Description              string            `json:"description,omitempty"`
+	IconURL                  string            `json:"iconUrl,omitempty"`
+	Language                 string            `json:"language,omitempty"`
+	LicenseExpression        string            `json:"licenseExpression,omitempty"`
+	LicenseURL               string            `json:"licenseUrl,omitempty"`
+	Listed                   bool              `json:"listed"`
+	PackageContent           string            `json:"packageContent,omitempty"`
+	PackageHash              string            `json:"packageHash,omitempty"`
+	PackageHashAlgorithm     string            `json:"packageHashAlgorithm,omitempty"`
+	PackageSize              int64             `json:"packageSize,omitempty"`
+	ProjectURL               string            `json:"projectUrl,omitempty"`
+	Published                string            `json:"published,omitempty"`
+	ReleaseNotes             string            `json:"releaseNotes,omitempty"`
+	RequireLicenseAcceptance bool              `json:"requireLicenseAcceptance"`
+	Summary                  string            `json:"summary,omitempty"`
+	Tags                     []string          `json:"tags,omitempty"`
+	Title                    string            `json:"title,omitempty"`
+}
+
+type dependencyGroup struct {
+	TargetFramework string       `json:"targetFramework,omitempty"`
+	Dependencies    []dependency `json:"dependencies,omitempty"`
+}
+
+type dependency struct {
+	ID    string `json:"id"`
+	Range string `json:"range,omitempty"`
+}
+
+type deprecation struct {
+	Reasons          []string          `json:"reasons"`
+	Message          string            `json:"message,omitempty"`
+	AlternatePackage *alternatePackage `json:"alternatePackage,omitempty"`
+}
+
+type alternatePackage struct {
+	ID    string `json:"id"`
+	Range string `json:"range"`
+}
+
+// ParsePackage parses a .nupkg file and extracts metadata
+func ParsePackage(path string) (*models.Package, error) {
+	// Calculate checksums
+	checksums, err := utils.CalculateChecksums(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate checksums: %w", err)
+	}
+
+	data, err := extractNuspec(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract nuspec: %w", err)
+	}
+
+	var spec nuspec
+	if err := xml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse nuspec: %w", err)
+	}
+	entry, err := specEntry(&spec)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := entryPackage(entry)
+	pkg.Filename = path
+	pkg.Size = checksums.Size
+	pkg.MD5Sum = checksums.MD5
+	pkg.SHA1Sum = checksums.SHA1
+	pkg.SHA256Sum = checksums.SHA256
+	pkg.SHA512Sum = checksums.SHA512
+
+	return pkg, nil
+}
+
+// extractNuspec reads the .nuspec at the root of a .nupkg
+func extractNuspec(path string) ([]byte, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if strings.Contains(f.Name, "/") || !strings.EqualFold(filepath.Ext(f.Name), ".nuspec") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+
+	return nil, fmt.Errorf("no .nuspec found in package")
+}
+
+// specEntry returns the catalog entry of a nuspec
+func specEntry(spec *nuspec) (*catalogEntry, error) {
+	m := spec.Metadata
+	if m.ID == "" || m.Version == "" {
+		return nil, fmt.Errorf("nuspec lacks package id or version")
+	}
+
+	entry := &catalogEntry{
+		ID:                       m.ID,
+		Version:                  normalizeVersion(m.Version),
+		Authors:                  m.Authors,
+		Copyright:                m.Copyright,
+		Description:              strings.TrimSpace(m.Description),
+		IconURL:                  m.IconURL,
+		Language:                 m.Language,
+		LicenseURL:               m.LicenseURL,
+		Listed:                   true,
+		ProjectURL:               m.ProjectURL,
+		ReleaseNotes:             strings.TrimSpace(m.ReleaseNotes),
+		RequireLicenseAcceptance: m.RequireLicenseAcceptance,
+		Summary:                  m.Summary,
+		Tags:                     strings.Fields(strings.ReplaceAll(m.Tags, ",", " ")),
+		Title:                    m.Title,
+	}
+	if m.License.Type == "expression" {
+		entry.LicenseExpression = strings.TrimSpace(m.License.Value)
+	}
+
+	if len(m.Dependencies.Groups) > 0 {
+		for _, group := range m.Dependencies.Groups {
+			entry.DependencyGroups = append(entry.DependencyGroups, dependencyGroup{
+				TargetFramework: group.TargetFramework,
+				Dependencies:    dependencies(group.Dependencies),
+			})
+		}
+	} else if len(m.Dependencies.Dependencies) > 0 {
+		entry.DependencyGroups = []dependencyGroup{{Dependencies: dependencies(m.Dependencies.Dependencies)}}
+	}
+
+	return entry, nil
+}
+
+// dependencies converts nuspec dependencies, a bare version being a
+// minimum version
+func dependencies(deps []nuspecDependency) []dependency {
+	var converted []dependency
+	for _, dep := range deps {
+		r := strings.TrimSpace(dep.Version)
+		if r != "" && !strings.HasPrefix(r, "[") && !strings.HasPrefix(r, "(") {
+			r = "[" + r + ", )"
+		}
+		converted = append(converted, dependency{ID: dep.ID, Range: r})
+	}
+	return converted
+}
+
+// entryPackage returns the package a catalog entry describes
+func entryPackage(entry *catalogEntry) *models.Package {
+	pkg := &models.Package{
+		Name:        entry.ID,
+		Version:     entry.Version,
+		Description: entry.Description,
+		Homepage:    entry.ProjectURL,
+		License:     entry.LicenseExpression,
+		Maintainer:  entry.Authors,
+		Metadata:    map[string]interface{}{"CatalogEntry": entry},
+	}
+	if entry.Summary != "" {
+		pkg.Description = entry.Summary
+	}
+
+	seen := make(map[string]bool)
+	for _, group := range entry.DependencyGroups {
+		for _, dep := range group.Dependencies {
+			if !seen[strings.ToLower(dep.ID)] {
+				seen[strings.ToLower(dep.ID)] = true
+				pkg.Dependencies = append(pkg.Dependencies, dep.ID)
+			}
+		}
+	}
+
+	if d := entry.Deprecation; d != nil {
+		pkg.Deprecation = &models.Deprecation{Message: d.Message}
+		if d.AlternatePackage != nil {
+			pkg.Deprecation.ReplacedBy = d.AlternatePackage.ID
+		}
+	}
+	return pkg
+}
+
+// normalizeVersion returns the normalized form of a NuGet version: no
+// build metadata, no leading zeros, at least three parts and no fourth
+// part when it is zero
+func normalizeVersion(version string) string {
+	version, _, _ = strings.Cut(strings.TrimSpace(version), "+")
+	release, prerelease, hasPrerelease := strings.Cut(version, "-")
+
+	parts := strings.Split(release, ".")
+	for i, part := range parts {
+		if n, err := strconv.ParseUint(part, 10, 64); err == nil {
+			parts[i] = strconv.FormatUint(n, 10)
+		}
+	}
+	for len(parts) < 3 {
+		parts = append(parts, "0")
+	}
+	if len(parts) == 4 && parts[3] == "0" {
+		parts = parts[:3]
+	}
+
+	normalized := strings.Join(parts, ".")
+	if hasPrerelease {
+		normalized += "-" + prerelease
+	}
+	return normalized
+}
+
+// packageDir returns the flat container directory of a package version,
+// relative to the output directory
+func packageDir(id, version string) string {
+	return path.Join(flatContainerDir, strings.ToLower(id), strings.ToLower(version))
+}
+
+// packageFile returns the path of a .nupkg, relative to the output directory
+func packageFile(id, version string) string {
+	return path.Join(packageDir(id, version), fmt.Sprintf("%s.%s.nupkg", strings.ToLower(id), strings.ToLower(version)))
+}
+
+// nuspecFile returns the path of the .nuspec of a package version,
+// relative to the output directory
+func nuspecFile(id, version string) string {
+	return path.Join(packageDir(id, version), strings.ToLower(id)+".nuspec")
+}
+
+// parseRegistration reads back the versions listed by a registration index
+func parseRegistration(file string) ([]models.Package, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var index registrationIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid registration index: %w", err)
+	}
+
+	var packages []models.Package
+	for _, page := range index.Items {
+		for _, leaf := range page.Items {
+			entry := leaf.CatalogEntry
+			if entry == nil {
+				continue
+			}
+			pkg := entryPackage(entry)
+			pkg.Filename = filepath.FromSlash(packageFile(entry.ID, entry.Version))
+			pkg.Size = entry.PackageSize
+			if sum, err := base64.StdEncoding.DecodeString(entry.PackageHash); err == nil && entry.PackageHashAlgorithm == "SHA512" {
+				pkg.SHA512Sum = hex.EncodeToString(sum)
+			}
+			packages = append(packages, *pkg)
+		}
+	}
+	return packages, nil
+}
@@ -0,0 +1,28 @@
+package pacman
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+// ConfFileName is the pacman.conf snippet configuring the repository
+const ConfFileName = "pacman.conf"
+
+// generateConf creates the pacman.conf section of the repository at
+// config.BaseURL. pacman substitutes $arch in Server
+func generateConf(config *models.RepositoryConfig, isSigned bool) []byte {
+	server := strings.TrimSuffix(config.BaseURL, "/")
+	if config.PacmanLayout == LayoutPool {
+		server += "/os"
+	}
+
+	// Signed repositories sign both the databases and the packages
+	sigLevel := "Optional TrustAll"
+	if isSigned {
+		sigLevel = "Required"
+	}
+
+	return []byte(fmt.Sprintf("[%s]\nSigLevel = %s\nServer = %s/$arch\n", DatabaseName(config), sigLevel, server))
+}
@@ -5,10 +5,12 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
-	"github.com/klauspost/compress/zstd"
+	"github.com/ralt/repogen/internal/events"
 	"github.com/ralt/repogen/internal/generator"
 	"github.com/ralt/repogen/internal/models"
 	"github.com/ralt/repogen/internal/scanner"
@@ -43,18 +45,40 @@ func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfi
 		}
 		archPackages[arch] = append(archPackages[arch], pkg)
 	}
+	archPackages = spreadAnyPackages(config, archPackages)
 
-	// Generate repository for each architecture
-	for arch, pkgs := range archPackages {
-		if err := g.generateForArch(ctx, config, arch, pkgs); err != nil {
-			return fmt.Errorf("failed to generate for %s: %w", arch, err)
+	// Generate repository for each architecture, several at once
+	arches := make([]string, 0, len(archPackages))
+	for arch := range archPackages {
+		arches = append(arches, arch)
+	}
+	sort.Strings(arches)
+	err := generator.ForEach(ctx, generator.Concurrency(config), len(arches), func(ctx context.Context, i int) error {
+		if err := g.generateForArch(ctx, config, arches[i], archPackages[arches[i]]); err != nil {
+			return fmt.Errorf("failed to generate for %s: %w", arches[i], err)
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	if g.signer != nil {
+		// pacman-key --add takes the armored key
+		if err := signer.PublishPublicKey(g.signer, config.OutputDir, utils.RepoSlug(config), utils.Timestamp(config)); err != nil {
+			return err
+		}
 		logrus.Info("Repository signed successfully")
 	}
 
+	if config.BaseURL != "" {
+		path := filepath.Join(config.OutputDir, ConfFileName)
+		if err := utils.WriteFile(path, generateConf(config, g.signer != nil), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", ConfFileName, err)
+		}
+		logrus.Infof("pacman.conf snippet written to: %s", path)
+	}
+
 	logrus.Infof("Pacman repository generated successfully (%d packages)", len(packages))
 	return nil
 }
@@ -63,16 +87,24 @@ func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfi
 func (g *Generator) generateForArch(ctx context.Context, config *models.RepositoryConfig, arch string, packages []models.Package) error {
 	logrus.Infof("Generating for architecture: %s", arch)
 
-	// Create directory structure: OutputDir/arch/
-	archDir := filepath.Join(config.OutputDir, arch)
+	// Create directory structure: OutputDir/arch/, or OutputDir/os/arch/
+	// and the pool
+	archDir := archDir(config, arch)
 	if err := utils.EnsureDir(archDir); err != nil {
 		return err
 	}
+	pkgDir := packageDir(config, arch)
+	if err := utils.EnsureDir(pkgDir); err != nil {
+		return err
+	}
 
-	// Copy packages to arch directory and recalculate checksums
+	// Copy packages to the package directory and recalculate checksums
 	for i := range packages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		pkg := &packages[i]
-		dstPath := filepath.Join(archDir, filepath.Base(pkg.Filename))
+		dstPath := filepath.Join(pkgDir, filepath.Base(pkg.Filename))
 
 		// Check if package needs to be copied
 		srcPath, finalDstPath, needsCopy, err := utils.ShouldCopyPackage(pkg, dstPath, config.OutputDir)
@@ -83,14 +115,16 @@ func (g *Generator) generateForArch(ctx context.Context, config *models.Reposito
 		if needsCopy {
 			logrus.Debugf("Copying package: %s -> %s", srcPath, finalDstPath)
 
-			if err := utils.CopyFile(srcPath, finalDstPath); err != nil {
+			// Copy package file, checksumming it on the way
+			checksums, err := utils.CopyFileWithChecksums(ctx, srcPath, finalDstPath)
+			if err != nil {
 				return fmt.Errorf("failed to copy package: %w", err)
 			}
 
-			// Recalculate checksums on the copied file to ensure accuracy
-			checksums, err := utils.CalculateChecksums(finalDstPath)
-			if err != nil {
-				return fmt.Errorf("failed to calculate checksums for %s: %w", filepath.Base(pkg.Filename), err)
+			if config.VerifyWrites {
+				if err := utils.VerifyCopy(finalDstPath, checksums); err != nil {
+					return fmt.Errorf("failed to verify %s: %w", srcPath, err)
+				}
 			}
 			pkg.Size = checksums.Size
 			pkg.MD5Sum = checksums.MD5
@@ -102,56 +136,36 @@ func (g *Generator) generateForArch(ctx context.Context, config *models.Reposito
 
 		// Update package filename to be relative
 		pkg.Filename = filepath.Base(pkg.Filename)
-	}
-
-	// Generate database name from repo-name, origin, or default
-	dbName := "custom"
-	if config.RepoName != "" {
-		dbName = sanitizeRepoName(config.RepoName)
-	} else if config.Origin != "" {
-		dbName = sanitizeRepoName(config.Origin)
-	}
 
-	// Generate database
-	dbData, err := g.generateDatabase(config, packages)
-	if err != nil {
-		return fmt.Errorf("failed to generate database: %w", err)
-	}
-
-	// Write database file to arch directory without arch suffix
-	dbPath := filepath.Join(archDir, fmt.Sprintf("%s.db.tar.zst", dbName))
-	if err := utils.WriteFile(dbPath, dbData, 0644); err != nil {
-		return fmt.Errorf("failed to write database: %w", err)
+		// Pooled packages are found next to the database through a symlink
+		if pkgDir != archDir {
+			if err := linkPackage(ctx, config, archDir, pkg.Filename); err != nil {
+				return fmt.Errorf("failed to link %s: %w", pkg.Filename, err)
+			}
+		}
 	}
 
-	// Also write .db file (copy of .db.tar.zst for Pacman compatibility)
-	dbCopyPath := filepath.Join(archDir, fmt.Sprintf("%s.db", dbName))
-	if err := utils.WriteFile(dbCopyPath, dbData, 0644); err != nil {
-		return fmt.Errorf("failed to write database copy: %w", err)
-	}
+	dbName := DatabaseName(config)
 
-	// Sign database if signer available
-	if g.signer != nil {
-		// Use binary signatures for Pacman (not ASCII-armored)
-		signature, err := g.signer.SignDetachedBinary(dbData)
+	// Write the databases like repo-add: the .files one also lists the
+	// files of each package, for pacman -F
+	for _, kind := range []string{"db", "files"} {
+		dbData, err := g.generateDatabase(config, packages, kind == "files")
 		if err != nil {
-			return fmt.Errorf("failed to sign database: %w", err)
-		}
-
-		sigPath := fmt.Sprintf("%s.sig", dbPath)
-		if err := utils.WriteFile(sigPath, signature, 0644); err != nil {
-			return fmt.Errorf("failed to write database signature: %w", err)
+			return fmt.Errorf("failed to generate database: %w", err)
 		}
-
-		// Also write .db.sig file (copy of .db.tar.zst.sig for Pacman compatibility)
-		sigCopyPath := filepath.Join(archDir, fmt.Sprintf("%s.db.sig", dbName))
-		if err := utils.WriteFile(sigCopyPath, signature, 0644); err != nil {
-			return fmt.Errorf("failed to write signature copy: %w", err)
+		if err := g.writeDatabase(ctx, config, archDir, fmt.Sprintf("%s.%s", dbName, kind), dbData); err != nil {
+			return err
 		}
+	}
 
-		// Sign each package file with binary signatures
+	// Sign each package file with binary signatures
+	if g.signer != nil {
 		for _, pkg := range packages {
-			pkgPath := filepath.Join(archDir, pkg.Filename)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			pkgPath := filepath.Join(pkgDir, pkg.Filename)
 
 			// Use streaming signing to avoid loading entire package into memory
 			pkgSig, err := g.signer.SignDetachedBinaryFromFile(pkgPath)
@@ -163,6 +177,13 @@ func (g *Generator) generateForArch(ctx context.Context, config *models.Reposito
 			if err := utils.WriteFile(pkgSigPath, pkgSig, 0644); err != nil {
 				return fmt.Errorf("failed to write package signature: %w", err)
 			}
+			events.Emit(events.Signed, events.Fields{"path": pkgSigPath, "kind": "detached"})
+
+			if pkgDir != archDir {
+				if err := linkPackage(ctx, config, archDir, pkg.Filename+".sig"); err != nil {
+					return fmt.Errorf("failed to link %s.sig: %w", pkg.Filename, err)
+				}
+			}
 		}
 	}
 
@@ -170,11 +191,75 @@ func (g *Generator) generateForArch(ctx context.Context, config *models.Reposito
 	return nil
 }
 
-// generateDatabase creates the Pacman database (.db.tar.zst)
-func (g *Generator) generateDatabase(config *models.RepositoryConfig, packages []models.Package) ([]byte, error) {
+// writeDatabase writes the database name (e.g. "myrepo.db") as
+// name.tar.zst, or the suffix of --db-compression, signs it, and links name
+// to it like repo-add does
+func (g *Generator) writeDatabase(ctx context.Context, config *models.RepositoryConfig, archDir, name string, data []byte) error {
+	dbPath := filepath.Join(archDir, name+".tar"+utils.CompressionSuffix(dbCompression(config)))
+
+	// repo-add keeps the previous database as .old, which pacman never
+	// reads, and databases of another compression would be read back
+	stale := []string{name + ".old", name + ".sig.old"}
+	for _, algorithm := range []string{utils.CompressionZstd, utils.CompressionXz, utils.CompressionGzip, utils.CompressionNone} {
+		path := name + ".tar" + utils.CompressionSuffix(algorithm)
+		stale = append(stale, path+".old", path+".sig.old")
+		if path != filepath.Base(dbPath) {
+			stale = append(stale, path, path+".sig")
+		}
+	}
+	for _, file := range stale {
+		if err := os.Remove(filepath.Join(archDir, file)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", file, err)
+		}
+	}
+
+	if err := utils.WriteFile(dbPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(dbPath), err)
+	}
+	if err := linkFile(ctx, config, dbPath, filepath.Join(archDir, name)); err != nil {
+		return fmt.Errorf("failed to link %s: %w", name, err)
+	}
+
+	// Use binary signatures for Pacman (not ASCII-armored)
+	if g.signer != nil {
+		signature, err := g.signer.SignDetachedBinary(data)
+		if err != nil {
+			return fmt.Errorf("failed to sign %s: %w", filepath.Base(dbPath), err)
+		}
+		// Catch key or algorithm misconfigurations before pacman does
+		if err := signer.CheckDetached(g.signer, signature, data); err != nil {
+			return fmt.Errorf("%s.sig self-check failed: %w", filepath.Base(dbPath), err)
+		}
+
+		sigPath := dbPath + ".sig"
+		if err := utils.WriteFile(sigPath, signature, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", filepath.Base(sigPath), err)
+		}
+		events.Emit(events.Signed, events.Fields{"path": sigPath, "kind": "detached"})
+
+		if err := linkFile(ctx, config, sigPath, filepath.Join(archDir, name+".sig")); err != nil {
+			return fmt.Errorf("failed to link %s.sig: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// generateDatabase creates the Pacman database (.db.tar.zst, or as
+// compressed by --db-compression), or with files the .files one, which also lists the files of each package
+func (g *Generator) generateDatabase(config *models.RepositoryConfig, packages []models.Package, files bool) ([]byte, error) {
 	// Create in-memory tar archive
 	var tarBuf bytes.Buffer
 	tw := tar.NewWriter(&tarBuf)
+	now := utils.Timestamp(config)
+
+	// Entries in a stable order, whatever the order packages were found in
+	packages = append([]models.Package(nil), packages...)
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].Name != packages[j].Name {
+			return packages[i].Name < packages[j].Name
+		}
+		return packages[i].Version < packages[j].Version
+	})
 
 	for _, pkg := range packages {
 		// Generate desc content
@@ -188,6 +273,7 @@ func (g *Generator) generateDatabase(config *models.RepositoryConfig, packages [
 		err = tw.WriteHeader(&tar.Header{
 			Name:     dirName,
 			Mode:     0755,
+			ModTime:  now,
 			Typeflag: tar.TypeDir,
 		})
 		if err != nil {
@@ -197,9 +283,10 @@ func (g *Generator) generateDatabase(config *models.RepositoryConfig, packages [
 		// Add desc file
 		descPath := dirName + "desc"
 		err = tw.WriteHeader(&tar.Header{
-			Name: descPath,
-			Mode: 0644,
-			Size: int64(len(descContent)),
+			Name:    descPath,
+			Mode:    0644,
+			Size:    int64(len(descContent)),
+			ModTime: now,
 		})
 		if err != nil {
 			return nil, err
@@ -209,29 +296,42 @@ func (g *Generator) generateDatabase(config *models.RepositoryConfig, packages [
 		if err != nil {
 			return nil, err
 		}
-	}
 
-	if err := tw.Close(); err != nil {
-		return nil, err
+		if files {
+			pkgFiles, _ := pkg.Metadata["Files"].([]string)
+			filesContent := []byte("%FILES%\n")
+			for _, file := range pkgFiles {
+				filesContent = append(filesContent, file+"\n"...)
+			}
+			err = tw.WriteHeader(&tar.Header{
+				Name:    dirName + "files",
+				Mode:    0644,
+				Size:    int64(len(filesContent)),
+				ModTime: now,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if _, err := tw.Write(filesContent); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	// Compress with zstd
-	var compressedBuf bytes.Buffer
-	zw, err := zstd.NewWriter(&compressedBuf)
-	if err != nil {
+	if err := tw.Close(); err != nil {
 		return nil, err
 	}
 
-	_, err = zw.Write(tarBuf.Bytes())
-	if err != nil {
-		return nil, err
-	}
+	return utils.Compress(dbCompression(config), config.CompressionLevel, tarBuf.Bytes())
+}
 
-	if err := zw.Close(); err != nil {
-		return nil, err
+// dbCompression returns the compression of the databases, zstd like
+// repo-add by default
+func dbCompression(config *models.RepositoryConfig) string {
+	if config.DBCompression == "" {
+		return utils.CompressionZstd
 	}
-
-	return compressedBuf.Bytes(), nil
+	return config.DBCompression
 }
 
 // generateDescFile creates the desc file content for a package
@@ -272,36 +372,56 @@ func generateDescFile(pkg models.Package) ([]byte, error) {
 	writeField("URL", pkg.Homepage)
 	writeField("LICENSE", pkg.License)
 
-	// Dependencies
-	if len(pkg.Dependencies) > 0 {
-		buf.WriteString("%DEPENDS%\n")
-		for _, dep := range pkg.Dependencies {
-			buf.WriteString(fmt.Sprintf("%s\n", dep))
+	// Write a list field to the buffer, one value a line
+	writeList := func(name string, values []string) {
+		if len(values) > 0 {
+			buf.WriteString(fmt.Sprintf("%%%s%%\n%s\n\n", name, strings.Join(values, "\n")))
 		}
-		buf.WriteString("\n")
 	}
 
-	// Conflicts
-	if len(pkg.Conflicts) > 0 {
-		buf.WriteString("%CONFLICTS%\n")
-		for _, conflict := range pkg.Conflicts {
-			buf.WriteString(fmt.Sprintf("%s\n", conflict))
-		}
-		buf.WriteString("\n")
-	}
+	// Relationships, in the order of repo-add
+	writeList("REPLACES", pkg.Replaces)
+	writeList("CONFLICTS", pkg.Conflicts)
+	writeList("PROVIDES", pkg.Provides)
+	writeList("DEPENDS", pkg.Dependencies)
+	optDepends, _ := pkg.Metadata["OptDepends"].([]string)
+	writeList("OPTDEPENDS", optDepends)
+	makeDepends, _ := pkg.Metadata["MakeDepends"].([]string)
+	writeList("MAKEDEPENDS", makeDepends)
+	checkDepends, _ := pkg.Metadata["CheckDepends"].([]string)
+	writeList("CHECKDEPENDS", checkDepends)
 
 	// Groups
-	if len(pkg.Groups) > 0 {
-		buf.WriteString("%GROUPS%\n")
-		for _, group := range pkg.Groups {
-			buf.WriteString(fmt.Sprintf("%s\n", group))
+	writeList("GROUPS", pkg.Groups)
+
+	// Sections preserved from an existing database
+	if extra, ok := pkg.Metadata["desc_extra"].([]descSection); ok {
+		for _, section := range extra {
+			if len(section.Values) == 0 {
+				continue
+			}
+			buf.WriteString(fmt.Sprintf("%%%s%%\n", section.Name))
+			for _, value := range section.Values {
+				buf.WriteString(fmt.Sprintf("%s\n", value))
+			}
+			buf.WriteString("\n")
 		}
-		buf.WriteString("\n")
 	}
 
 	return buf.Bytes(), nil
 }
 
+// DatabaseName returns the name of the databases, and of the repository
+// section of pacman.conf: the repo-name, the origin, or a default
+func DatabaseName(config *models.RepositoryConfig) string {
+	if config.RepoName != "" {
+		return sanitizeRepoName(config.RepoName)
+	} else if config.Origin != "" {
+		return sanitizeRepoName(config.Origin)
+	}
+	return "custom"
+}
+
 // sanitizeRepoName sanitizes a repository name for use in filenames
 func sanitizeRepoName(name string) string {
 	name = strings.ToLower(name)
@@ -3,13 +3,19 @@ package pacman
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
+	"github.com/ralt/repogen/internal/generator"
 	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/packager"
+	"github.com/ralt/repogen/internal/utils"
 )
 
 func TestGenerateDescFile(t *testing.T) {
@@ -106,7 +112,7 @@ func TestGenerateDatabase(t *testing.T) {
 	}
 
 	gen := &Generator{}
-	dbData, err := gen.generateDatabase(config, packages)
+	dbData, err := gen.generateDatabase(config, packages, false)
 	if err != nil {
 		t.Fatalf("Failed to generate database: %v", err)
 	}
@@ -438,3 +444,311 @@ func TestIncrementalModeCopiesNewPackages(t *testing.T) {
 
 	t.Logf("Incremental mode test passed for Pacman!")
 }
+
+func TestPoolLayoutLinksPackagesIntoEveryArchitecture(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+
+	var packages []models.Package
+	for _, p := range []struct{ name, arch string }{{"tool", "x86_64"}, {"tool", "aarch64"}, {"tool-doc", "any"}} {
+		filename := filepath.Join(inputDir, fmt.Sprintf("%s-1.0-1-%s.pkg.tar.zst", p.name, p.arch))
+		if err := os.WriteFile(filename, []byte(p.name+p.arch), 0644); err != nil {
+			t.Fatalf("Failed to write package: %v", err)
+		}
+		packages = append(packages, models.Package{Name: p.name, Version: "1.0-1", Architecture: p.arch, Filename: filename})
+	}
+
+	config := &models.RepositoryConfig{
+		OutputDir:    filepath.Join(tmpDir, "output"),
+		RepoName:     "test-repo",
+		PacmanLayout: LayoutPool,
+		BaseURL:      "https://example.com/arch",
+	}
+	gen := NewGenerator(nil)
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	// The "any" package is stored once, and listed for both architectures
+	for _, arch := range []string{"x86_64", "aarch64"} {
+		for _, name := range []string{"tool-1.0-1-" + arch + ".pkg.tar.zst", "tool-doc-1.0-1-any.pkg.tar.zst"} {
+			link := filepath.Join(config.OutputDir, "os", arch, name)
+			target, err := os.Readlink(link)
+			if err != nil {
+				t.Fatalf("%s is not a symlink: %v", link, err)
+			}
+			if target != filepath.Join("..", "..", "pool", name) {
+				t.Errorf("%s links to %s", link, target)
+			}
+			if _, err := os.Stat(link); err != nil {
+				t.Errorf("%s is dangling: %v", link, err)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(config.OutputDir, "os", "any")); err == nil {
+		t.Error("any packages got a database of their own")
+	}
+	conf, _ := os.ReadFile(filepath.Join(config.OutputDir, ConfFileName))
+	if string(conf) != "[test-repo]\nSigLevel = Optional TrustAll\nServer = https://example.com/arch/os/$arch\n" {
+		t.Errorf("Unexpected pacman.conf snippet:\n%s", conf)
+	}
+
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("Failed to parse existing metadata: %v", err)
+	}
+	if len(existing) != 3 {
+		t.Errorf("Existing metadata lists %d packages, want 3", len(existing))
+	}
+
+	files := gen.(generator.PackageLocator).PackageFiles(config, models.Package{Architecture: "any", Filename: "tool-doc-1.0-1-any.pkg.tar.zst"})
+	if files[0] != filepath.Join(config.OutputDir, "pool", "tool-doc-1.0-1-any.pkg.tar.zst") || len(files) != 6 {
+		t.Errorf("Unexpected package files %v", files)
+	}
+}
+
+func TestAnyPackagesAreListedForEveryArchitecture(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		arches []string
+		dbs    map[string][]string
+	}{
+		{"with other packages", []string{"x86_64", "aarch64", "any"}, map[string][]string{
+			"x86_64":  {"tool-x86_64", "tool-doc-any"},
+			"aarch64": {"tool-aarch64", "tool-doc-any"},
+		}},
+		{"alone", []string{"any"}, map[string][]string{
+			"x86_64": {"tool-doc-any"},
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			var packages []models.Package
+			for _, arch := range tt.arches {
+				name := "tool"
+				if arch == "any" {
+					name = "tool-doc"
+				}
+				filename := filepath.Join(tmpDir, fmt.Sprintf("%s-1.0-1-%s.pkg.tar.zst", name, arch))
+				if err := os.WriteFile(filename, []byte(arch), 0644); err != nil {
+					t.Fatalf("Failed to write package: %v", err)
+				}
+				packages = append(packages, models.Package{Name: name, Version: "1.0-1", Architecture: arch, Filename: filename})
+			}
+
+			config := &models.RepositoryConfig{
+				OutputDir: filepath.Join(tmpDir, "output"),
+				RepoName:  "test-repo",
+				Arches:    []string{"amd64"},
+			}
+			if err := NewGenerator(nil).Generate(context.Background(), config, packages); err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+
+			if _, err := os.Stat(filepath.Join(config.OutputDir, "any")); err == nil {
+				t.Error("any packages got a directory of their own")
+			}
+			for arch, expected := range tt.dbs {
+				listed, err := parsePacmanDB(filepath.Join(config.OutputDir, arch, "test-repo.db.tar.zst"))
+				if err != nil {
+					t.Fatalf("Failed to read %s database: %v", arch, err)
+				}
+				var names []string
+				for _, pkg := range listed {
+					names = append(names, pkg.Name+"-"+pkg.Architecture)
+					if _, err := os.Stat(filepath.Join(config.OutputDir, arch, pkg.Filename)); err != nil {
+						t.Errorf("%s lists %s, missing from its directory", arch, pkg.Filename)
+					}
+				}
+				sort.Strings(names)
+				sort.Strings(expected)
+				if strings.Join(names, ",") != strings.Join(expected, ",") {
+					t.Errorf("%s database lists %v, want %v", arch, names, expected)
+				}
+			}
+
+			config.Arches = nil
+			for arch := range tt.dbs {
+				config.Arches = append(config.Arches, arch)
+			}
+			existing, err := NewGenerator(nil).ParseExistingMetadata(config)
+			if err != nil || len(existing) != len(packages) {
+				t.Errorf("Existing metadata lists %d packages, want %d: %v", len(existing), len(packages), err)
+			}
+		})
+	}
+}
+
+func TestFilesDatabaseAndLinks(t *testing.T) {
+	for _, mode := range []string{LinkSymlink, LinkCopy} {
+		t.Run(mode, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			path, err := packager.Build("pacman", packager.Package{Name: "hello", Version: "1.0", Summary: "Hello", Files: []packager.File{{Path: "usr/bin/hello", Mode: 0755, Data: []byte("hello")}}}, tmpDir)
+			if err != nil {
+				t.Fatalf("Failed to build package: %v", err)
+			}
+			pkg, err := ParsePackage(path)
+			if err != nil {
+				t.Fatalf("Failed to parse package: %v", err)
+			}
+
+			config := &models.RepositoryConfig{
+				OutputDir:   filepath.Join(tmpDir, "output"),
+				RepoName:    "test-repo",
+				Arches:      []string{"x86_64"},
+				PacmanLinks: mode,
+			}
+			archDir := filepath.Join(config.OutputDir, "x86_64")
+			if err := os.MkdirAll(archDir, 0755); err != nil {
+				t.Fatalf("Failed to create arch dir: %v", err)
+			}
+			stale := filepath.Join(archDir, "test-repo.db.tar.zst.old")
+			if err := os.WriteFile(stale, []byte("old"), 0644); err != nil {
+				t.Fatalf("Failed to write stale database: %v", err)
+			}
+
+			if err := NewGenerator(nil).Generate(context.Background(), config, []models.Package{*pkg}); err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+
+			if _, err := os.Stat(stale); !os.IsNotExist(err) {
+				t.Error("Stale .old database left behind")
+			}
+			for _, name := range []string{"test-repo.db", "test-repo.files"} {
+				link := filepath.Join(archDir, name)
+				target, err := os.Readlink(link)
+				if mode == LinkSymlink && (err != nil || target != name+".tar.zst") {
+					t.Errorf("%s links to %q (%v)", name, target, err)
+				}
+				if mode == LinkCopy && err == nil {
+					t.Errorf("%s is a symlink", name)
+				}
+				data, err := os.ReadFile(link)
+				published, _ := os.ReadFile(link + ".tar.zst")
+				if err != nil || !bytes.Equal(data, published) {
+					t.Errorf("%s doesn't match %s.tar.zst: %v", name, name, err)
+				}
+			}
+
+			files, err := parsePacmanDB(filepath.Join(archDir, "test-repo.files.tar.zst"))
+			if err != nil || len(files) != 1 {
+				t.Fatalf("Failed to read .files database (%d packages): %v", len(files), err)
+			}
+			if listed, _ := files[0].Metadata["Files"].([]string); !contains(listed, "usr/bin/hello") {
+				t.Errorf(".files database lists %v", listed)
+			}
+
+			// Regenerating keeps the files of packages read back
+			existing, err := NewGenerator(nil).ParseExistingMetadata(config)
+			if err != nil || len(existing) != 1 {
+				t.Fatalf("Failed to read existing metadata: %v", err)
+			}
+			if listed, _ := existing[0].Metadata["Files"].([]string); !contains(listed, "usr/bin/hello") {
+				t.Errorf("Existing package lists files %v", listed)
+			}
+		})
+	}
+}
+
+func TestDatabaseCompression(t *testing.T) {
+	tmpDir := t.TempDir()
+	path, err := packager.Build("pacman", packager.Package{Name: "hello", Version: "1.0", Summary: "Hello", Files: []packager.File{{Path: "usr/bin/hello", Mode: 0755, Data: []byte("hello")}}}, tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to build package: %v", err)
+	}
+	pkg, err := ParsePackage(path)
+	if err != nil {
+		t.Fatalf("Failed to parse package: %v", err)
+	}
+
+	config := &models.RepositoryConfig{
+		OutputDir: filepath.Join(tmpDir, "output"),
+		RepoName:  "test-repo",
+		Arches:    []string{"x86_64"},
+	}
+	archDir := filepath.Join(config.OutputDir, "x86_64")
+
+	// Each compression replaces the databases of the previous one
+	for _, tc := range []struct {
+		algorithm, suffix string
+		level             int
+	}{
+		{"", ".tar.zst", 0},
+		{utils.CompressionXz, ".tar.xz", 9},
+		{utils.CompressionGzip, ".tar.gz", 1},
+		{utils.CompressionNone, ".tar", 0},
+		{utils.CompressionZstd, ".tar.zst", 19},
+	} {
+		config.DBCompression = tc.algorithm
+		config.CompressionLevel = tc.level
+		if err := NewGenerator(nil).Generate(context.Background(), config, []models.Package{*pkg}); err != nil {
+			t.Fatalf("Generate with %q failed: %v", tc.algorithm, err)
+		}
+
+		dbs, _ := filepath.Glob(filepath.Join(archDir, "test-repo.db.tar*"))
+		if len(dbs) != 1 || dbs[0] != filepath.Join(archDir, "test-repo.db"+tc.suffix) {
+			t.Errorf("With %q, databases are %v", tc.algorithm, dbs)
+		}
+		if target, err := os.Readlink(filepath.Join(archDir, "test-repo.db")); err != nil || target != "test-repo.db"+tc.suffix {
+			t.Errorf("With %q, test-repo.db links to %q (%v)", tc.algorithm, target, err)
+		}
+
+		existing, err := NewGenerator(nil).ParseExistingMetadata(config)
+		if err != nil || len(existing) != 1 {
+			t.Fatalf("With %q, failed to read existing metadata: %v", tc.algorithm, err)
+		}
+		if listed, _ := existing[0].Metadata["Files"].([]string); !contains(listed, "usr/bin/hello") {
+			t.Errorf("With %q, existing package lists files %v", tc.algorithm, listed)
+		}
+	}
+}
+
+func TestReproducibleDatabase(t *testing.T) {
+	tmpDir := t.TempDir()
+	var packages []models.Package
+	for _, name := range []string{"hello", "world"} {
+		path, err := packager.Build("pacman", packager.Package{Name: name, Version: "1.0", Summary: "Test", Files: []packager.File{{Path: "usr/bin/" + name, Mode: 0755, Data: []byte(name)}}}, tmpDir)
+		if err != nil {
+			t.Fatalf("Failed to build package: %v", err)
+		}
+		pkg, err := ParsePackage(path)
+		if err != nil {
+			t.Fatalf("Failed to parse package: %v", err)
+		}
+		packages = append(packages, *pkg)
+	}
+
+	// The same packages and timestamp give the same bytes, in any order
+	var databases [][]byte
+	for i, order := range [][]models.Package{packages, {packages[1], packages[0]}} {
+		config := &models.RepositoryConfig{
+			OutputDir: filepath.Join(tmpDir, fmt.Sprintf("output%d", i)),
+			RepoName:  "test-repo",
+			Arches:    []string{"x86_64"},
+			Timestamp: time.Unix(1700000000, 0),
+		}
+		if err := NewGenerator(nil).Generate(context.Background(), config, order); err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		data, err := os.ReadFile(filepath.Join(config.OutputDir, "x86_64", "test-repo.db.tar.zst"))
+		if err != nil {
+			t.Fatalf("Failed to read database: %v", err)
+		}
+		databases = append(databases, data)
+	}
+	if !bytes.Equal(databases[0], databases[1]) {
+		t.Error("Databases of the same packages differ")
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package pacman
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Repository layouts
+const (
+	LayoutArch = "arch" // <arch>/ holding the database and the packages of each architecture
+	LayoutPool = "pool" // Packages under pool/, symlinked into os/<arch>/ next to the databases
+)
+
+// Link modes: how the conventional names of repo-add (myrepo.db, ...) and
+// the packages of the pool layout point at the files
+const (
+	LinkSymlink = "symlink" // Relative symlinks, like repo-add
+	LinkCopy    = "copy"    // Copies, for storage without symlinks
+)
+
+// anyArch is the architecture of packages installing everywhere
+const anyArch = "any"
+
+// archNames maps --arch values to pacman architectures
+var archNames = map[string]string{
+	"amd64": "x86_64",
+	"arm64": "aarch64",
+	"armhf": "armv7h",
+	"i386":  "i686",
+	"386":   "i686",
+}
+
+// ValidateLayout checks that layout is a known repository layout
+func ValidateLayout(layout string) error {
+	switch layout {
+	case "", LayoutArch, LayoutPool:
+		return nil
+	}
+	return fmt.Errorf("unknown Pacman layout %q (expected %s or %s)", layout, LayoutArch, LayoutPool)
+}
+
+// ValidateLinkMode checks that mode is a known link mode
+func ValidateLinkMode(mode string) error {
+	switch mode {
+	case "", LinkSymlink, LinkCopy:
+		return nil
+	}
+	return fmt.Errorf("unknown Pacman link mode %q (expected %s or %s)", mode, LinkSymlink, LinkCopy)
+}
+
+// archDir returns the directory holding the database of arch
+func archDir(config *models.RepositoryConfig, arch string) string {
+	if config.PacmanLayout == LayoutPool {
+		return filepath.Join(config.OutputDir, "os", arch)
+	}
+	return filepath.Join(config.OutputDir, arch)
+}
+
+// packageDir returns the directory the files of packages of arch are stored in
+func packageDir(config *models.RepositoryConfig, arch string) string {
+	if config.PacmanLayout == LayoutPool {
+		return poolDir(config)
+	}
+	return archDir(config, arch)
+}
+
+// poolDir returns the directory holding the packages of the pool layout
+func poolDir(config *models.RepositoryConfig) string {
+	return filepath.Join(config.OutputDir, "pool")
+}
+
+// spreadAnyPackages lists the "any" packages in the database of every
+// architecture, those of the other packages or else --arch, like repo-add:
+// pacman only reads the one of $arch
+func spreadAnyPackages(config *models.RepositoryConfig, archPackages map[string][]models.Package) map[string][]models.Package {
+	anyPackages, ok := archPackages[anyArch]
+	if !ok {
+		return archPackages
+	}
+	delete(archPackages, anyArch)
+
+	if len(archPackages) == 0 {
+		for _, arch := range config.Arches {
+			if name, ok := archNames[arch]; ok {
+				arch = name
+			}
+			archPackages[arch] = nil
+		}
+	}
+	if len(archPackages) == 0 {
+		archPackages["x86_64"] = nil // default architecture
+	}
+
+	for arch := range archPackages {
+		archPackages[arch] = append(archPackages[arch], anyPackages...)
+	}
+	return archPackages
+}
+
+// linkPackage links the pooled file name into dir
+func linkPackage(ctx context.Context, config *models.RepositoryConfig, dir, name string) error {
+	return linkFile(ctx, config, filepath.Join(poolDir(config), name), filepath.Join(dir, name))
+}
+
+// linkFile makes link point at target, a symlink relative to the link or a
+// copy, replacing what was there. Copies of targets only on remote storage
+// are left as published
+func linkFile(ctx context.Context, config *models.RepositoryConfig, target, link string) error {
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if config.PacmanLinks == LinkCopy {
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			return nil
+		}
+		return utils.CopyFile(ctx, target, link)
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(link), target)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(rel, link)
+}
+
+// publishedPoolArches returns the architectures of an existing pool
+// layout, the directories below os/
+func publishedPoolArches(config *models.RepositoryConfig) []string {
+	entries, err := os.ReadDir(filepath.Join(config.OutputDir, "os"))
+	if err != nil {
+		return nil
+	}
+	var arches []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			arches = append(arches, entry.Name())
+		}
+	}
+	return arches
+}
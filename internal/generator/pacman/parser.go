@@ -8,10 +8,12 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"github.com/klauspost/compress/zstd"
+	"github.com/ralt/repogen/internal/depcheck"
 	"github.com/ralt/repogen/internal/models"
 	"github.com/ralt/repogen/internal/utils"
 	"github.com/ulikunitz/xz"
@@ -25,8 +27,8 @@ func ParsePackage(path string) (*models.Package, error) {
 		return nil, fmt.Errorf("failed to calculate checksums: %w", err)
 	}
 
-	// Extract .PKGINFO file
-	pkginfo, err := extractPKGINFO(path)
+	// Extract .PKGINFO file, and list the files the package installs
+	pkginfo, files, err := readPackage(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to extract .PKGINFO: %w", err)
 	}
@@ -37,6 +39,9 @@ func ParsePackage(path string) (*models.Package, error) {
 		return nil, fmt.Errorf("failed to parse .PKGINFO: %w", err)
 	}
 
+	// Listed in the .files database, for pacman -F
+	pkg.Metadata["Files"] = files
+
 	// Set file information
 	pkg.Filename = path
 	pkg.Size = checksums.Size
@@ -48,11 +53,12 @@ func ParsePackage(path string) (*models.Package, error) {
 	return pkg, nil
 }
 
-// extractPKGINFO extracts the .PKGINFO file from a Pacman package
-func extractPKGINFO(path string) ([]byte, error) {
+// readPackage extracts the .PKGINFO file from a Pacman package, and lists
+// the files it installs, leaving out its dot files (.PKGINFO, .MTREE, ...)
+func readPackage(path string) ([]byte, []string, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer f.Close()
 
@@ -62,45 +68,60 @@ func extractPKGINFO(path string) ([]byte, error) {
 	if strings.HasSuffix(path, ".pkg.tar.zst") {
 		zr, err := zstd.NewReader(f)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		defer zr.Close()
 		tarReader = tar.NewReader(zr)
 	} else if strings.HasSuffix(path, ".pkg.tar.xz") {
 		xr, err := xz.NewReader(f)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		tarReader = tar.NewReader(xr)
 	} else if strings.HasSuffix(path, ".pkg.tar.gz") {
 		gr, err := gzip.NewReader(f)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		defer gr.Close()
 		tarReader = tar.NewReader(gr)
 	} else if strings.HasSuffix(path, ".pkg.tar") {
 		tarReader = tar.NewReader(f)
 	} else {
-		return nil, fmt.Errorf("unsupported package format: %s", filepath.Base(path))
+		return nil, nil, fmt.Errorf("unsupported package format: %s", filepath.Base(path))
 	}
 
-	// Find .PKGINFO
+	var pkginfo []byte
+	var files []string
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		if header.Name == ".PKGINFO" {
-			return io.ReadAll(tarReader)
+		name := strings.TrimPrefix(header.Name, "./")
+		if name == ".PKGINFO" {
+			if pkginfo, err = io.ReadAll(tarReader); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if name == "" || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if header.Typeflag == tar.TypeDir && !strings.HasSuffix(name, "/") {
+			name += "/"
 		}
+		files = append(files, name)
 	}
 
-	return nil, fmt.Errorf(".PKGINFO not found in package")
+	if pkginfo == nil {
+		return nil, nil, fmt.Errorf(".PKGINFO not found in package")
+	}
+	return pkginfo, files, nil
 }
 
 // parsePKGINFO parses the .PKGINFO file content
@@ -147,6 +168,16 @@ func parsePKGINFO(data []byte) (*models.Package, error) {
 			pkg.Dependencies = append(pkg.Dependencies, value)
 		case "conflict":
 			pkg.Conflicts = append(pkg.Conflicts, value)
+		case "provides":
+			pkg.Provides = append(pkg.Provides, value)
+		case "replaces":
+			pkg.Replaces = append(pkg.Replaces, value)
+		case "optdepend":
+			appendMetadata(pkg, "OptDepends", value)
+		case "makedepend":
+			appendMetadata(pkg, "MakeDepends", value)
+		case "checkdepend":
+			appendMetadata(pkg, "CheckDepends", value)
 		case "group":
 			pkg.Groups = append(pkg.Groups, value)
 		case "builddate":
@@ -166,43 +197,62 @@ func parsePKGINFO(data []byte) (*models.Package, error) {
 	return pkg, nil
 }
 
-// ParseExistingMetadata reads .db.tar.zst database files
+// appendMetadata appends value to the list of metadata key, for the
+// relationships models.Package has no field for
+func appendMetadata(pkg *models.Package, key, value string) {
+	values, _ := pkg.Metadata[key].([]string)
+	pkg.Metadata[key] = append(values, value)
+}
+
+// ParseExistingMetadata reads .db.tar.zst (or .xz, .gz, uncompressed) database files
 func (g *Generator) ParseExistingMetadata(config *models.RepositoryConfig) ([]models.Package, error) {
 	var allPackages []models.Package
 
-	// Pacman repos are organized by arch
-	for _, arch := range config.Arches {
-		archDir := filepath.Join(config.OutputDir, arch)
-
-		// Find database files (pattern: *.db.tar.zst or *.db)
-		pattern := filepath.Join(archDir, "*.db.tar.zst")
-		dbFiles, err := filepath.Glob(pattern)
-		if err != nil || len(dbFiles) == 0 {
-			// Try .db.tar.xz extension
-			pattern = filepath.Join(archDir, "*.db.tar.xz")
-			dbFiles, err = filepath.Glob(pattern)
-			if err != nil || len(dbFiles) == 0 {
-				// Try .db.tar.gz extension
-				pattern = filepath.Join(archDir, "*.db.tar.gz")
-				dbFiles, err = filepath.Glob(pattern)
-				if err != nil || len(dbFiles) == 0 {
-					// Try .db extension (might be symlink or uncompressed)
-					pattern = filepath.Join(archDir, "*.db")
-					dbFiles, err = filepath.Glob(pattern)
-					if err != nil || len(dbFiles) == 0 {
-						continue
-					}
-				}
+	// Pacman repos are organized by arch, under os/ in the pool layout
+	arches := config.Arches
+	if config.PacmanLayout == LayoutPool {
+		arches = publishedPoolArches(config)
+	}
+	seen := make(map[string]bool)
+	for _, arch := range arches {
+		archDir := archDir(config, arch)
+
+		// Find database files (pattern: *.db.tar.zst, .xz, .gz, .tar or *.db)
+		var dbFiles []string
+		for _, suffix := range []string{".db.tar.zst", ".db.tar.xz", ".db.tar.gz", ".db.tar", ".db"} {
+			dbFiles, _ = filepath.Glob(filepath.Join(archDir, "*"+suffix))
+			if len(dbFiles) > 0 {
+				break
 			}
 		}
+		if len(dbFiles) == 0 {
+			continue
+		}
 
-		// Parse first database file found
-		packages, err := parsePacmanDB(dbFiles[0])
+		// Parse first database file found, or its .files database, which
+		// also lists the files of the packages
+		dbPath := dbFiles[0]
+		if i := strings.LastIndex(dbPath, ".db"); i >= 0 {
+			filesPath := dbPath[:i] + ".files" + dbPath[i+len(".db"):]
+			if _, err := os.Stat(filesPath); err == nil {
+				dbPath = filesPath
+			}
+		}
+		packages, err := parsePacmanDB(dbPath)
 		if err != nil {
 			continue
 		}
 
-		allPackages = append(allPackages, packages...)
+		// "any" packages are listed in every database
+		for _, pkg := range packages {
+			if pkg.Architecture == anyArch {
+				if seen[pkg.Filename] {
+					continue
+				}
+				seen[pkg.Filename] = true
+			}
+			allPackages = append(allPackages, pkg)
+		}
 	}
 
 	if len(allPackages) == 0 {
@@ -213,51 +263,24 @@ func (g *Generator) ParseExistingMetadata(config *models.RepositoryConfig) ([]mo
 }
 
 func parsePacmanDB(dbPath string) ([]models.Package, error) {
-	f, err := os.Open(dbPath)
+	data, err := os.ReadFile(dbPath)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-
-	// Detect compression from extension
-	var tarReader *tar.Reader
 
-	if strings.HasSuffix(dbPath, ".db.tar.zst") || strings.HasSuffix(dbPath, ".db.tar") {
-		// Try zstd first
-		zr, err := zstd.NewReader(f)
-		if err != nil {
-			// If zstd fails and it's .db.tar, try uncompressed
-			if strings.HasSuffix(dbPath, ".db.tar") {
-				f.Seek(0, 0)
-				tarReader = tar.NewReader(f)
-			} else {
-				return nil, err
-			}
-		} else {
-			defer zr.Close()
-			tarReader = tar.NewReader(zr)
-		}
-	} else if strings.HasSuffix(dbPath, ".db.tar.xz") {
-		xr, err := xz.NewReader(f)
-		if err != nil {
-			return nil, err
-		}
-		tarReader = tar.NewReader(xr)
-	} else if strings.HasSuffix(dbPath, ".db.tar.gz") {
-		gr, err := gzip.NewReader(f)
-		if err != nil {
-			return nil, err
-		}
-		defer gr.Close()
-		tarReader = tar.NewReader(gr)
-	} else {
-		// Assume it's a symlink or uncompressed tar
-		tarReader = tar.NewReader(f)
+	// Detect the compression from the content, the .db link naming none
+	data, err = utils.Decompress(data)
+	if err != nil {
+		return nil, err
 	}
+	tarReader := tar.NewReader(bytes.NewReader(data))
 
 	var packages []models.Package
+	var dirs []string
+	fileLists := make(map[string][]string)
 
-	// Read tar archive - each package has a directory with desc file
+	// Read tar archive - each package has a directory with desc file, and
+	// in the .files database a files file
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
@@ -281,12 +304,45 @@ func parsePacmanDB(dbPath string) ([]models.Package, error) {
 			}
 
 			packages = append(packages, *pkg)
+			dirs = append(dirs, path.Dir(header.Name))
+		}
+
+		if header.Typeflag == tar.TypeReg && strings.HasSuffix(header.Name, "/files") {
+			filesData, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, err
+			}
+			fileLists[path.Dir(header.Name)] = parseFilesFile(filesData)
+		}
+	}
+
+	for i := range packages {
+		if files, ok := fileLists[dirs[i]]; ok {
+			packages[i].Metadata["Files"] = files
 		}
 	}
 
 	return packages, nil
 }
 
+// parseFilesFile returns the files listed by the files file of a package
+// in the .files database
+func parseFilesFile(data []byte) []string {
+	var files []string
+	inFiles := false
+	for _, line := range strings.Split(string(data), "\n") {
+		switch {
+		case line == "%FILES%":
+			inFiles = true
+		case strings.HasPrefix(line, "%"):
+			inFiles = false
+		case inFiles && line != "":
+			files = append(files, line)
+		}
+	}
+	return files
+}
+
 func parseDescFile(data []byte) (*models.Package, error) {
 	pkg := &models.Package{
 		Metadata:     make(map[string]interface{}),
@@ -297,6 +353,7 @@ func parseDescFile(data []byte) (*models.Package, error) {
 
 	scanner := bufio.NewScanner(bytes.NewReader(data))
 	var currentField string
+	var extra []descSection
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -304,6 +361,9 @@ func parseDescFile(data []byte) (*models.Package, error) {
 		// Field marker: %FIELDNAME%
 		if strings.HasPrefix(line, "%") && strings.HasSuffix(line, "%") {
 			currentField = strings.Trim(line, "%")
+			if !knownDescFields[currentField] {
+				extra = append(extra, descSection{Name: currentField})
+			}
 			continue
 		}
 
@@ -347,10 +407,109 @@ func parseDescFile(data []byte) (*models.Package, error) {
 			pkg.Dependencies = append(pkg.Dependencies, line)
 		case "CONFLICTS":
 			pkg.Conflicts = append(pkg.Conflicts, line)
+		case "PROVIDES":
+			pkg.Provides = append(pkg.Provides, line)
+		case "REPLACES":
+			pkg.Replaces = append(pkg.Replaces, line)
+		case "OPTDEPENDS":
+			appendMetadata(pkg, "OptDepends", line)
+		case "MAKEDEPENDS":
+			appendMetadata(pkg, "MakeDepends", line)
+		case "CHECKDEPENDS":
+			appendMetadata(pkg, "CheckDepends", line)
 		case "GROUPS":
 			pkg.Groups = append(pkg.Groups, line)
+		default:
+			// Keep sections repogen doesn't model so they survive regeneration
+			if len(extra) > 0 && extra[len(extra)-1].Name == currentField {
+				extra[len(extra)-1].Values = append(extra[len(extra)-1].Values, line)
+			}
 		}
 	}
 
+	if len(extra) > 0 {
+		pkg.Metadata["desc_extra"] = extra
+	}
+
 	return pkg, nil
 }
+
+// descSection is a %NAME% section of a desc file with its values
+type descSection struct {
+	Name   string
+	Values []string
+}
+
+// knownDescFields lists the desc sections mapped onto models.Package
+var knownDescFields = map[string]bool{
+	"FILENAME":  true,
+	"NAME":      true,
+	"VERSION":   true,
+	"DESC":      true,
+	"CSIZE":     true,
+	"ISIZE":     true,
+	"MD5SUM":    true,
+	"SHA256SUM": true,
+	"ARCH":      true,
+	"BUILDDATE": true,
+	"PACKAGER":  true,
+	"URL":       true,
+	"LICENSE":   true,
+	"DEPENDS":   true,
+	"CONFLICTS": true,
+	"GROUPS":    true,
+
+	"PROVIDES":     true,
+	"REPLACES":     true,
+	"OPTDEPENDS":   true,
+	"MAKEDEPENDS":  true,
+	"CHECKDEPENDS": true,
+}
+
+// PackageFiles returns the path of a package from existing metadata and of
+// its detached signature, for every architecture listing "any" packages, and
+// followed in the pool layout by their symlinks
+func (g *Generator) PackageFiles(config *models.RepositoryConfig, pkg models.Package) []string {
+	name := filepath.Base(pkg.Filename)
+	if config.PacmanLayout == LayoutPool {
+		// The pooled package, then its symlinks
+		pkgPath := filepath.Join(poolDir(config), name)
+		files := []string{pkgPath, pkgPath + ".sig"}
+		for _, arch := range publishedPoolArches(config) {
+			link := filepath.Join(archDir(config, arch), name)
+			files = append(files, link, link+".sig")
+		}
+		return files
+	}
+	if pkg.Architecture == anyArch {
+		// Copied into the directory of every architecture
+		var files []string
+		matches, _ := filepath.Glob(filepath.Join(config.OutputDir, "*", name))
+		for _, pkgPath := range matches {
+			files = append(files, pkgPath, pkgPath+".sig")
+		}
+		if len(files) > 0 {
+			return files
+		}
+	}
+	pkgPath := filepath.Join(config.OutputDir, pkg.Architecture, name)
+	return []string{pkgPath, pkgPath + ".sig"}
+}
+
+// Requires returns the dependencies of pkg
+func (g *Generator) Requires(pkg models.Package) [][]string {
+	var requires [][]string
+	for _, dep := range pkg.Dependencies {
+		requires = append(requires, []string{depcheck.TrimConstraint(dep)})
+	}
+	return requires
+}
+
+// Provides returns the name of pkg and the names it provides
+func (g *Generator) Provides(pkg models.Package) []string {
+	provides := []string{pkg.Name}
+	for _, name := range pkg.Provides {
+		provides = append(provides, depcheck.TrimConstraint(name))
+	}
+	return provides
+}
@@ -1,6 +1,7 @@
 package pacman
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -121,3 +122,97 @@ func TestSanitizeRepoName(t *testing.T) {
 		}
 	}
 }
+
+func TestParseDescFilePreservesUnknownSections(t *testing.T) {
+	descContent := []byte(`%FILENAME%
+nano-8.3-1-x86_64.pkg.tar.zst
+
+%NAME%
+nano
+
+%BASE%
+nano
+
+%VERSION%
+8.3-1
+
+%PROVIDES%
+pico
+editor
+
+%ARCH%
+x86_64
+
+`)
+
+	pkg, err := parseDescFile(descContent)
+	if err != nil {
+		t.Fatalf("Failed to parse desc file: %v", err)
+	}
+
+	desc, err := generateDescFile(*pkg)
+	if err != nil {
+		t.Fatalf("Failed to generate desc file: %v", err)
+	}
+
+	descStr := string(desc)
+	for _, expected := range []string{"%BASE%\nnano\n", "%PROVIDES%\npico\neditor\n"} {
+		if !strings.Contains(descStr, expected) {
+			t.Errorf("Regenerated desc file missing preserved section %q:\n%s", expected, descStr)
+		}
+	}
+}
+
+func TestRelationshipsSurviveTheDatabase(t *testing.T) {
+	pkginfoContent := []byte(`pkgname = vim
+pkgver = 9.1-1
+arch = x86_64
+provides = xxd
+provides = vi=9.1
+replaces = gvim
+conflict = gvim
+depend = glibc
+optdepend = python: Python language support
+optdepend = ruby: Ruby language support
+makedepend = gpm
+checkdepend = python
+`)
+
+	pkg, err := parsePKGINFO(pkginfoContent)
+	if err != nil {
+		t.Fatalf("Failed to parse PKGINFO: %v", err)
+	}
+
+	desc, err := generateDescFile(*pkg)
+	if err != nil {
+		t.Fatalf("Failed to generate desc file: %v", err)
+	}
+	for _, expected := range []string{
+		"%REPLACES%\ngvim\n\n",
+		"%CONFLICTS%\ngvim\n\n",
+		"%PROVIDES%\nxxd\nvi=9.1\n\n",
+		"%DEPENDS%\nglibc\n\n",
+		"%OPTDEPENDS%\npython: Python language support\nruby: Ruby language support\n\n",
+		"%MAKEDEPENDS%\ngpm\n\n",
+		"%CHECKDEPENDS%\npython\n\n",
+	} {
+		if !strings.Contains(string(desc), expected) {
+			t.Errorf("desc file missing %q:\n%s", expected, desc)
+		}
+	}
+
+	parsed, err := parseDescFile(desc)
+	if err != nil {
+		t.Fatalf("Failed to parse desc file: %v", err)
+	}
+	if _, ok := parsed.Metadata["desc_extra"]; ok {
+		t.Errorf("Relationships parsed as unknown sections: %v", parsed.Metadata["desc_extra"])
+	}
+	regenerated, err := generateDescFile(*parsed)
+	if err != nil {
+		t.Fatalf("Failed to regenerate desc file: %v", err)
+	}
+	if string(regenerated) != string(desc) {
+		t.Errorf("desc file changed on regeneration:\n%s\nwant:\n%s", regenerated, desc)
+	}
+}
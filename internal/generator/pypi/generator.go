@@ -0,0 +1,359 @@
+package pypi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Distributions are served from packagesDir, the indexes from simpleDir
+const (
+	simpleDir   = "simple"
+	packagesDir = "packages"
+)
+
+// apiVersion is the version of the simple repository API the pages follow
+const apiVersion = "1.1"
+
+// Generator implements the generator.Generator interface for PyPI simple
+// repositories (PEP 503 HTML pages and their PEP 691 JSON variant)
+type Generator struct{}
+
+// NewGenerator creates a new PyPI generator
+func NewGenerator() generator.Generator {
+	return &Generator{}
+}
+
+// projectPage is a project page of the PEP 691 JSON API
+type projectPage struct {
+	Meta     apiMeta       `json:"meta"`
+	Name     string        `json:"name"`
+	Versions []string      `json:"versions"`
+	Files    []projectFile `json:"files"`
+}
+
+type apiMeta struct {
+	APIVersion string `json:"api-version"`
+}
+
+type projectFile struct {
+	Filename       string            `json:"filename"`
+	URL            string            `json:"url"`
+	Hashes         map[string]string `json:"hashes"`
+	RequiresPython string            `json:"requires-python,omitempty"`
+	Size           int64             `json:"size"`
+	Yanked         interface{}       `json:"yanked,omitempty"` // false, true or the reason
+}
+
+// yankedReason reports whether the file is yanked (PEP 592), and why
+func (f projectFile) yankedReason() (string, bool) {
+	switch y := f.Yanked.(type) {
+	case string:
+		return y, true
+	case bool:
+		return "", y
+	default:
+		return "", false
+	}
+}
+
+// rootIndex is the project list of the PEP 691 JSON API
+type rootIndex struct {
+	Meta     apiMeta       `json:"meta"`
+	Projects []rootProject `json:"projects"`
+}
+
+type rootProject struct {
+	Name string `json:"name"`
+}
+
+// Generate copies the distributions and writes the simple index of every project
+func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfig, packages []models.Package) error {
+	logrus.Info("Generating PyPI simple repository...")
+
+	// Group distributions by project
+	filesByProject := make(map[string][]models.Package)
+	for _, pkg := range packages {
+		project := NormalizeName(pkg.Name)
+		filesByProject[project] = append(filesByProject[project], pkg)
+	}
+
+	projects := make([]string, 0, len(filesByProject))
+	for project := range filesByProject {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+
+	for _, project := range projects {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := g.generateProject(ctx, config, project, filesByProject[project]); err != nil {
+			return fmt.Errorf("failed to generate for %s: %w", project, err)
+		}
+	}
+
+	// Projects left without distributions drop out of the index
+	if err := removeStaleProjects(config.OutputDir, filesByProject); err != nil {
+		return err
+	}
+
+	if err := writeRootIndex(config.OutputDir, projects, filesByProject); err != nil {
+		return err
+	}
+
+	logrus.Infof("PyPI repository generated successfully (%d projects, %d files)", len(projects), len(packages))
+	return nil
+}
+
+// generateProject copies the distributions of project and writes its page
+func (g *Generator) generateProject(ctx context.Context, config *models.RepositoryConfig, project string, files []models.Package) error {
+	projectDir := filepath.Join(config.OutputDir, packagesDir, project)
+	if err := utils.EnsureDir(projectDir); err != nil {
+		return err
+	}
+
+	for i := range files {
+		pkg := &files[i]
+		dstPath := filepath.Join(projectDir, filepath.Base(pkg.Filename))
+
+		// Distributions read back from an existing page are published
+		// already, possibly only on remote storage
+		srcPath, finalDstPath, needsCopy, err := utils.ShouldCopyPackage(pkg, dstPath, config.OutputDir)
+		if err != nil {
+			return fmt.Errorf("package copy check failed for %s: %w", pkg.Name, err)
+		}
+
+		if needsCopy {
+			logrus.Debugf("Copying package: %s -> %s", srcPath, finalDstPath)
+
+			checksums, err := utils.CopyFileWithChecksums(ctx, srcPath, finalDstPath)
+			if err != nil {
+				return fmt.Errorf("failed to copy %s: %w", srcPath, err)
+			}
+
+			if config.VerifyWrites {
+				if err := utils.VerifyCopy(finalDstPath, checksums); err != nil {
+					return fmt.Errorf("failed to verify %s: %w", srcPath, err)
+				}
+			}
+
+			pkg.Size = checksums.Size
+			pkg.MD5Sum = checksums.MD5
+			pkg.SHA1Sum = checksums.SHA1
+			pkg.SHA256Sum = checksums.SHA256
+		}
+
+		pkg.Filename = filepath.Base(pkg.Filename)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Filename < files[j].Filename
+	})
+
+	pageDir := filepath.Join(config.OutputDir, simpleDir, project)
+	if err := utils.WriteFile(filepath.Join(pageDir, "index.html"), projectHTML(project, files), 0644); err != nil {
+		return fmt.Errorf("failed to write project page: %w", err)
+	}
+
+	data, err := json.MarshalIndent(newProjectPage(project, files), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFile(filepath.Join(pageDir, "index.json"), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write project page: %w", err)
+	}
+
+	logrus.Infof("Generated project %s (%d files)", project, len(files))
+	return nil
+}
+
+// fileURL returns the URL of a distribution, relative to its project page
+func fileURL(project, filename string) string {
+	return path.Join("..", "..", packagesDir, project, filename)
+}
+
+// projectHTML renders the PEP 503 page of project, with sha256 fragments,
+// Requires-Python (PEP 503) and yanked (PEP 592) attributes
+func projectHTML(project string, files []models.Package) []byte {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n  <head>\n")
+	fmt.Fprintf(&b, "    <meta name=\"pypi:repository-version\" content=\"%s\">\n", apiVersion)
+	fmt.Fprintf(&b, "    <title>Links for %s</title>\n", html.EscapeString(project))
+	b.WriteString("  </head>\n  <body>\n")
+	fmt.Fprintf(&b, "    <h1>Links for %s</h1>\n", html.EscapeString(project))
+
+	for _, file := range files {
+		href := fileURL(project, file.Filename)
+		if file.SHA256Sum != "" {
+			href += "#sha256=" + file.SHA256Sum
+		}
+		attrs := fmt.Sprintf(" href=\"%s\"", html.EscapeString(href))
+		if requiresPython, ok := file.Metadata["RequiresPython"].(string); ok {
+			attrs += fmt.Sprintf(" data-requires-python=\"%s\"", html.EscapeString(requiresPython))
+		}
+		if file.Deprecation != nil {
+			attrs += fmt.Sprintf(" data-yanked=\"%s\"", html.EscapeString(file.Deprecation.Note()))
+		}
+		fmt.Fprintf(&b, "    <a%s>%s</a><br/>\n", attrs, html.EscapeString(file.Filename))
+	}
+
+	b.WriteString("  </body>\n</html>\n")
+	return []byte(b.String())
+}
+
+// newProjectPage describes project in the PEP 691 JSON API
+func newProjectPage(project string, files []models.Package) *projectPage {
+	page := &projectPage{
+		Meta:     apiMeta{APIVersion: apiVersion},
+		Name:     project,
+		Versions: []string{},
+		Files:    make([]projectFile, 0, len(files)),
+	}
+
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if !seen[file.Version] {
+			seen[file.Version] = true
+			page.Versions = append(page.Versions, file.Version)
+		}
+
+		f := projectFile{
+			Filename: file.Filename,
+			URL:      fileURL(project, file.Filename),
+			Hashes:   map[string]string{},
+			Size:     file.Size,
+		}
+		if file.SHA256Sum != "" {
+			f.Hashes["sha256"] = file.SHA256Sum
+		}
+		if requiresPython, ok := file.Metadata["RequiresPython"].(string); ok {
+			f.RequiresPython = requiresPython
+		}
+		if file.Deprecation != nil {
+			f.Yanked = file.Deprecation.Note()
+		}
+		page.Files = append(page.Files, f)
+	}
+
+	sort.Slice(page.Versions, func(i, j int) bool {
+		return utils.CompareSemanticVersions(page.Versions[i], page.Versions[j]) < 0
+	})
+	return page
+}
+
+// writeRootIndex writes the project list, in HTML and JSON
+func writeRootIndex(outputDir string, projects []string, filesByProject map[string][]models.Package) error {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n  <head>\n")
+	fmt.Fprintf(&b, "    <meta name=\"pypi:repository-version\" content=\"%s\">\n", apiVersion)
+	b.WriteString("    <title>Simple index</title>\n  </head>\n  <body>\n")
+
+	index := rootIndex{Meta: apiMeta{APIVersion: apiVersion}, Projects: []rootProject{}}
+	for _, project := range projects {
+		name := project
+		for _, file := range filesByProject[project] {
+			if displayName, ok := file.Metadata["DisplayName"].(string); ok {
+				name = displayName
+				break
+			}
+		}
+		fmt.Fprintf(&b, "    <a href=\"%s/\">%s</a><br/>\n", html.EscapeString(project), html.EscapeString(name))
+		index.Projects = append(index.Projects, rootProject{Name: project})
+	}
+	b.WriteString("  </body>\n</html>\n")
+
+	dir := filepath.Join(outputDir, simpleDir)
+	if err := utils.WriteFile(filepath.Join(dir, "index.html"), []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write simple index: %w", err)
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFile(filepath.Join(dir, "index.json"), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write simple index: %w", err)
+	}
+	return nil
+}
+
+// removeStaleProjects deletes the pages of projects without distributions,
+// so the index never links to removed files
+func removeStaleProjects(outputDir string, filesByProject map[string][]models.Package) error {
+	pages, err := filepath.Glob(filepath.Join(outputDir, simpleDir, "*", "index.json"))
+	if err != nil {
+		return err
+	}
+	for _, page := range pages {
+		dir := filepath.Dir(page)
+		if _, ok := filesByProject[filepath.Base(dir)]; ok {
+			continue
+		}
+		for _, name := range []string{"index.html", "index.json"} {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove stale project page: %w", err)
+			}
+		}
+		os.Remove(dir)
+		logrus.Infof("Removed project %s, which has no distributions left", filepath.Base(dir))
+	}
+	return nil
+}
+
+// ValidatePackages checks if packages are wheels or source distributions
+func (g *Generator) ValidatePackages(packages []models.Package) error {
+	for _, pkg := range packages {
+		if !strings.HasSuffix(pkg.Filename, ".whl") && !strings.HasSuffix(pkg.Filename, ".tar.gz") {
+			return fmt.Errorf("package %s is not a wheel or source distribution", pkg.Filename)
+		}
+	}
+	return nil
+}
+
+// GetSupportedType returns the package type this generator supports
+func (g *Generator) GetSupportedType() scanner.PackageType {
+	return scanner.TypePypi
+}
+
+// ParseExistingMetadata reads the PEP 691 pages under simple/
+func (g *Generator) ParseExistingMetadata(config *models.RepositoryConfig) ([]models.Package, error) {
+	pages, err := filepath.Glob(filepath.Join(config.OutputDir, simpleDir, "*", "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(pages)
+
+	var packages []models.Package
+	for _, page := range pages {
+		files, err := parseProjectPage(page)
+		if err != nil {
+			logrus.Warnf("Skipping %s: %v", page, err)
+			continue
+		}
+		packages = append(packages, files...)
+	}
+
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no existing PyPI project pages found in %s", config.OutputDir)
+	}
+
+	return packages, nil
+}
+
+// PackageFiles returns the path of a distribution from an existing project page
+func (g *Generator) PackageFiles(config *models.RepositoryConfig, pkg models.Package) []string {
+	return []string{filepath.Join(config.OutputDir, packagesDir, NormalizeName(pkg.Name), filepath.Base(pkg.Filename))}
+}
@@ -0,0 +1,181 @@
+package pypi
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+// writeWheel creates a wheel holding METADATA
+func writeWheel(t *testing.T, path, distInfo, metadata string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	w, _ := zw.Create("foo_bar/__init__.py")
+	w.Write([]byte(""))
+	w, _ = zw.Create(distInfo + "/METADATA")
+	w.Write([]byte(metadata))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeSdist creates a source distribution holding PKG-INFO
+func writeSdist(t *testing.T, path, top, pkgInfo string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, name := range []string{top + "/src/foo_bar.egg-info/PKG-INFO", top + "/PKG-INFO"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(pkgInfo))})
+		tw.Write([]byte(pkgInfo))
+	}
+	tw.Close()
+	gw.Close()
+}
+
+func TestNormalizeName(t *testing.T) {
+	for name, want := range map[string]string{
+		"Foo_Bar":    "foo-bar",
+		"foo.bar--x": "foo-bar-x",
+		"requests":   "requests",
+	} {
+		if got := NormalizeName(name); got != want {
+			t.Errorf("NormalizeName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestParsePackage(t *testing.T) {
+	dir := t.TempDir()
+
+	wheel := filepath.Join(dir, "foo_bar-1.0-py3-none-any.whl")
+	writeWheel(t, wheel, "foo_bar-1.0.dist-info",
+		"Metadata-Version: 2.1\nName: Foo_Bar\nVersion: 1.0\nSummary: A test project\nRequires-Python: >=3.8\nRequires-Dist: requests\n\nLong description\n")
+	pkg, err := ParsePackage(wheel)
+	if err != nil {
+		t.Fatalf("ParsePackage(wheel) failed: %v", err)
+	}
+	if pkg.Name != "foo-bar" || pkg.Version != "1.0" || pkg.Architecture != "py3-none-any" {
+		t.Errorf("unexpected wheel %s %s %s", pkg.Name, pkg.Version, pkg.Architecture)
+	}
+	if pkg.Metadata["RequiresPython"] != ">=3.8" || pkg.Description != "A test project" {
+		t.Errorf("unexpected wheel metadata %+v", pkg)
+	}
+
+	sdist := filepath.Join(dir, "foo-bar-0.9.tar.gz")
+	writeSdist(t, sdist, "foo-bar-0.9", "Metadata-Version: 1.2\nName: foo-bar\nVersion: 0.9\n")
+	pkg, err = ParsePackage(sdist)
+	if err != nil {
+		t.Fatalf("ParsePackage(sdist) failed: %v", err)
+	}
+	if pkg.Name != "foo-bar" || pkg.Version != "0.9" || pkg.Architecture != sourceArch {
+		t.Errorf("unexpected sdist %s %s %s", pkg.Name, pkg.Version, pkg.Architecture)
+	}
+}
+
+func TestSplitFilename(t *testing.T) {
+	tests := []struct {
+		project, filename, version, arch string
+	}{
+		{"foo-bar", "foo_bar-1.0-py3-none-any.whl", "1.0", "py3-none-any"},
+		{"foo-bar", "foo_bar-1.0-1-cp312-cp312-manylinux_2_17_x86_64.whl", "1.0", "cp312-cp312-manylinux_2_17_x86_64"},
+		{"foo-bar", "foo-bar-0.9.tar.gz", "0.9", sourceArch},
+	}
+	for _, tt := range tests {
+		version, arch, ok := splitFilename(tt.project, tt.filename)
+		if !ok || version != tt.version || arch != tt.arch {
+			t.Errorf("splitFilename(%s) = %s %s %v, want %s %s", tt.filename, version, arch, ok, tt.version, tt.arch)
+		}
+	}
+}
+
+func TestGenerateSimpleIndex(t *testing.T) {
+	tmpDir := t.TempDir()
+	wheel := filepath.Join(tmpDir, "foo_bar-1.0-py3-none-any.whl")
+	writeWheel(t, wheel, "foo_bar-1.0.dist-info", "Metadata-Version: 2.1\nName: Foo_Bar\nVersion: 1.0\nRequires-Python: >=3.8\n")
+	sdist := filepath.Join(tmpDir, "foo-bar-0.9.tar.gz")
+	writeSdist(t, sdist, "foo-bar-0.9", "Metadata-Version: 1.2\nName: foo-bar\nVersion: 0.9\n")
+
+	var packages []models.Package
+	for _, path := range []string{wheel, sdist} {
+		pkg, err := ParsePackage(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packages = append(packages, *pkg)
+	}
+
+	config := &models.RepositoryConfig{OutputDir: filepath.Join(tmpDir, "output")}
+	gen := NewGenerator().(*Generator)
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	page, err := os.ReadFile(filepath.Join(config.OutputDir, "simple", "foo-bar", "index.html"))
+	if err != nil {
+		t.Fatalf("project page not written: %v", err)
+	}
+	want := `<a href="../../packages/foo-bar/foo_bar-1.0-py3-none-any.whl#sha256=` + packages[0].SHA256Sum + `" data-requires-python="&gt;=3.8">foo_bar-1.0-py3-none-any.whl</a>`
+	if !strings.Contains(string(page), want) {
+		t.Errorf("project page lacks %s:\n%s", want, page)
+	}
+	if _, err := os.Stat(filepath.Join(config.OutputDir, "packages", "foo-bar", "foo-bar-0.9.tar.gz")); err != nil {
+		t.Errorf("sdist not copied: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(config.OutputDir, "simple", "foo-bar", "index.json"))
+	if err != nil {
+		t.Fatalf("JSON project page not written: %v", err)
+	}
+	var doc projectPage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("invalid JSON project page: %v", err)
+	}
+	if doc.Meta.APIVersion != apiVersion || strings.Join(doc.Versions, ",") != "0.9,1.0" || len(doc.Files) != 2 {
+		t.Errorf("unexpected JSON project page:\n%s", data)
+	}
+
+	root, _ := os.ReadFile(filepath.Join(config.OutputDir, "simple", "index.html"))
+	if !strings.Contains(string(root), `<a href="foo-bar/">Foo_Bar</a>`) {
+		t.Errorf("root index does not list the project:\n%s", root)
+	}
+
+	// The pages read back as the published files
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 2 || existing[0].Version != "0.9" || existing[1].Metadata["RequiresPython"] != ">=3.8" {
+		t.Fatalf("unexpected existing packages: %+v", existing)
+	}
+	for _, pkg := range existing {
+		if _, err := os.Stat(gen.PackageFiles(config, pkg)[0]); err != nil {
+			t.Errorf("PackageFiles returned a missing path: %v", err)
+		}
+	}
+
+	// Regenerating without the project drops its pages
+	if err := gen.Generate(context.Background(), config, nil); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(config.OutputDir, "simple", "foo-bar", "index.html")); !os.IsNotExist(err) {
+		t.Errorf("stale project page kept: %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+package pypi
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// sourceArch is the architecture of source distributions; wheels use their
+// compatibility tag (e.g. "py3-none-any")
+const sourceArch = "source"
+
+// normalizeRe matches the runs of separators PEP 503 collapses
+var normalizeRe = regexp.MustCompile(`[-_.]+`)
+
+// NormalizeName returns the PEP 503 normalized form of a project name
+func NormalizeName(name string) string {
+	return strings.ToLower(normalizeRe.ReplaceAllString(name, "-"))
+}
+
+// ParsePackage parses a wheel or source distribution and extracts metadata
+func ParsePackage(path string) (*models.Package, error) {
+	// Calculate checksums
+	checksums, err := utils.CalculateChecksums(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate checksums: %w", err)
+	}
+
+	// Wheels carry METADATA in their .dist-info, sdists PKG-INFO at the top
+	var metadata []byte
+	arch := sourceArch
+	switch {
+	case strings.HasSuffix(path, ".whl"):
+		metadata, err = extractWheelMetadata(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract METADATA: %w", err)
+		}
+		arch, err = wheelTag(filepath.Base(path))
+		if err != nil {
+			return nil, err
+		}
+	case strings.HasSuffix(path, ".tar.gz"):
+		metadata, err = extractSdistMetadata(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract PKG-INFO: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("%s is neither a wheel nor a .tar.gz source distribution", filepath.Base(path))
+	}
+
+	// Parse METADATA / PKG-INFO
+	pkg, err := parseMetadata(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metadata: %w", err)
+	}
+
+	// Set file information
+	pkg.Architecture = arch
+	pkg.Filename = path
+	pkg.Size = checksums.Size
+	pkg.MD5Sum = checksums.MD5
+	pkg.SHA1Sum = checksums.SHA1
+	pkg.SHA256Sum = checksums.SHA256
+	pkg.SHA512Sum = checksums.SHA512
+
+	return pkg, nil
+}
+
+// extractWheelMetadata reads the METADATA file of a wheel
+func extractWheelMetadata(path string) ([]byte, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		dir, name, ok := strings.Cut(f.Name, "/")
+		if !ok || name != "METADATA" || !strings.HasSuffix(dir, ".dist-info") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+
+	return nil, fmt.Errorf("METADATA not found in wheel")
+}
+
+// extractSdistMetadata reads the PKG-INFO file at the top of a source distribution
+func extractSdistMetadata(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// <name>-<version>/PKG-INFO, not the copies under *.egg-info
+		_, name, ok := strings.Cut(strings.TrimPrefix(header.Name, "./"), "/")
+		if ok && name == "PKG-INFO" {
+			return io.ReadAll(tr)
+		}
+	}
+
+	return nil, fmt.Errorf("PKG-INFO not found in source distribution")
+}
+
+// wheelTag returns the compatibility tag of a wheel file name,
+// {name}-{version}(-{build})?-{python}-{abi}-{platform}.whl
+func wheelTag(filename string) (string, error) {
+	parts := strings.Split(strings.TrimSuffix(filename, ".whl"), "-")
+	if len(parts) < 5 {
+		return "", fmt.Errorf("invalid wheel file name %s", filename)
+	}
+	return strings.Join(parts[len(parts)-3:], "-"), nil
+}
+
+// parseMetadata parses core metadata, an RFC 822 style header block
+func parseMetadata(data []byte) (*models.Package, error) {
+	// The body after the headers, if any, is the long description
+	if !bytes.Contains(data, []byte("\n\n")) {
+		data = append(data, '\n')
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	header := msg.Header
+
+	pkg := &models.Package{
+		Name:        NormalizeName(header.Get("Name")),
+		Version:     header.Get("Version"),
+		Description: header.Get("Summary"),
+		Homepage:    header.Get("Home-page"),
+		License:     header.Get("License"),
+		Metadata:    make(map[string]interface{}),
+	}
+	if pkg.Name == "" || pkg.Version == "" {
+		return nil, fmt.Errorf("metadata lacks Name or Version")
+	}
+	pkg.Dependencies = header["Requires-Dist"]
+
+	if name := header.Get("Name"); name != pkg.Name {
+		pkg.Metadata["DisplayName"] = name
+	}
+	if requiresPython := header.Get("Requires-Python"); requiresPython != "" {
+		pkg.Metadata["RequiresPython"] = requiresPython
+	}
+
+	return pkg, nil
+}
+
+// parseProjectPage reads back the files of a PEP 691 project page
+func parseProjectPage(path string) ([]models.Package, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var page projectPage
+	if err := json.Unmarshal(data, &page); err != nil {
+		return nil, fmt.Errorf("invalid project page %s: %w", path, err)
+	}
+
+	var packages []models.Package
+	for _, file := range page.Files {
+		version, arch, ok := splitFilename(page.Name, file.Filename)
+		if !ok {
+			continue
+		}
+		pkg := models.Package{
+			Name:         page.Name,
+			Version:      version,
+			Architecture: arch,
+			Filename:     file.Filename,
+			Size:         file.Size,
+			SHA256Sum:    file.Hashes["sha256"],
+			Metadata:     make(map[string]interface{}),
+		}
+		if file.RequiresPython != "" {
+			pkg.Metadata["RequiresPython"] = file.RequiresPython
+		}
+		if reason, ok := file.yankedReason(); ok {
+			pkg.Deprecation = &models.Deprecation{Message: reason}
+		}
+		packages = append(packages, pkg)
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].Filename < packages[j].Filename
+	})
+	return packages, nil
+}
+
+// splitFilename returns the version and architecture of a distribution of
+// project, from its file name
+func splitFilename(project, filename string) (version, arch string, ok bool) {
+	var rest string
+	switch {
+	case strings.HasSuffix(filename, ".whl"):
+		tag, err := wheelTag(filename)
+		if err != nil {
+			return "", "", false
+		}
+		rest, arch = strings.TrimSuffix(filename, "-"+tag+".whl"), tag
+	case strings.HasSuffix(filename, ".tar.gz"):
+		rest, arch = strings.TrimSuffix(filename, ".tar.gz"), sourceArch
+	default:
+		return "", "", false
+	}
+
+	// The name part may hold hyphens itself: find where it ends
+	for i := strings.Index(rest, "-"); i >= 0; i = nextHyphen(rest, i) {
+		if NormalizeName(rest[:i]) == project {
+			version = rest[i+1:]
+			// Drop the build tag of wheels
+			if arch != sourceArch {
+				version, _, _ = strings.Cut(version, "-")
+			}
+			return version, arch, version != ""
+		}
+	}
+	return "", "", false
+}
+
+// nextHyphen returns the index of the hyphen after the one at i, or -1
+func nextHyphen(s string, i int) int {
+	j := strings.Index(s[i+1:], "-")
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
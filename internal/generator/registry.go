@@ -0,0 +1,45 @@
+package generator
+
+import (
+	"sync"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/signer"
+)
+
+// Signers holds the keys repositories are signed with, nil when unsigned
+type Signers struct {
+	GPG        signer.Signer
+	RSA        signer.RSASigner
+	RSAKeyName string
+}
+
+// Factory creates the generator of a package type
+type Factory func(config *models.RepositoryConfig, signers Signers) Generator
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[scanner.PackageType]Factory)
+)
+
+// Register makes factory create the generator of pkgType, replacing the one
+// registered before, so other package formats can be added without changing
+// the commands
+func Register(pkgType scanner.PackageType, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[pkgType] = factory
+}
+
+// New returns a generator per registered package type
+func New(config *models.RepositoryConfig, signers Signers) map[scanner.PackageType]Generator {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	generators := make(map[scanner.PackageType]Generator, len(registry))
+	for pkgType, factory := range registry {
+		generators[pkgType] = factory(config, signers)
+	}
+	return generators
+}
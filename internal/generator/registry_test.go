@@ -0,0 +1,52 @@
+package generator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+)
+
+// customGenerator records the base URL it was created for
+type customGenerator struct {
+	baseURL string
+}
+
+func (g *customGenerator) Generate(ctx context.Context, config *models.RepositoryConfig, packages []models.Package) error {
+	return nil
+}
+
+func (g *customGenerator) ValidatePackages(packages []models.Package) error { return nil }
+
+func (g *customGenerator) GetSupportedType() scanner.PackageType { return scanner.TypeUnknown }
+
+func (g *customGenerator) ParseExistingMetadata(config *models.RepositoryConfig) ([]models.Package, error) {
+	return nil, nil
+}
+
+func TestRegisteredGeneratorsAreCreatedPerConfig(t *testing.T) {
+	custom := scanner.PackageType(1000)
+	Register(custom, func(config *models.RepositoryConfig, signers Signers) Generator {
+		return &customGenerator{baseURL: config.BaseURL}
+	})
+	defer func() {
+		registryMu.Lock()
+		delete(registry, custom)
+		registryMu.Unlock()
+	}()
+
+	generators := New(&models.RepositoryConfig{BaseURL: "https://example.com"}, Signers{})
+	gen, ok := generators[custom].(*customGenerator)
+	if !ok || gen.baseURL != "https://example.com" {
+		t.Fatalf("Registered generator not created for the config: %#v", generators[custom])
+	}
+
+	// Registering again replaces the generator
+	Register(custom, func(config *models.RepositoryConfig, signers Signers) Generator {
+		return &customGenerator{baseURL: "replaced"}
+	})
+	if gen := New(&models.RepositoryConfig{}, Signers{})[custom].(*customGenerator); gen.baseURL != "replaced" {
+		t.Errorf("Generator not replaced, base URL %q", gen.baseURL)
+	}
+}
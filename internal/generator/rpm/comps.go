@@ -0,0 +1,206 @@
+package rpm
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// compsGroups describes the package groups and environments of a repository,
+// as read from the --rpm-groups file
+type compsGroups struct {
+	Groups       []compsGroup       `yaml:"groups"`
+	Environments []compsEnvironment `yaml:"environments"`
+}
+
+type compsGroup struct {
+	ID           string             `yaml:"id"`
+	Name         string             `yaml:"name"`
+	Description  string             `yaml:"description"`
+	Default      *bool              `yaml:"default"`     // Installed by default in an environment (defaults to false)
+	UserVisible  *bool              `yaml:"uservisible"` // Shown by `dnf group list` (defaults to true)
+	DisplayOrder int                `yaml:"display_order"`
+	Packages     compsGroupPackages `yaml:"packages"`
+}
+
+type compsGroupPackages struct {
+	Mandatory []string `yaml:"mandatory"`
+	Default   []string `yaml:"default"`
+	Optional  []string `yaml:"optional"`
+}
+
+type compsEnvironment struct {
+	ID           string   `yaml:"id"`
+	Name         string   `yaml:"name"`
+	Description  string   `yaml:"description"`
+	DisplayOrder int      `yaml:"display_order"`
+	Groups       []string `yaml:"groups"`  // Always installed with the environment
+	Options      []string `yaml:"options"` // Offered as optional add-ons
+}
+
+// loadGroups reads and validates a package groups file (YAML or JSON)
+func loadGroups(path string) (*compsGroups, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read groups file: %w", err)
+	}
+
+	var groups compsGroups
+	if err := yaml.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("failed to parse groups file %s: %w", path, err)
+	}
+
+	known := make(map[string]bool)
+	for _, group := range groups.Groups {
+		if group.ID == "" {
+			return nil, fmt.Errorf("group %q in %s has no id", group.Name, path)
+		}
+		if known[group.ID] {
+			return nil, fmt.Errorf("duplicate group id %q in %s", group.ID, path)
+		}
+		known[group.ID] = true
+	}
+
+	for _, env := range groups.Environments {
+		if env.ID == "" {
+			return nil, fmt.Errorf("environment %q in %s has no id", env.Name, path)
+		}
+		for _, id := range append(append([]string{}, env.Groups...), env.Options...) {
+			if !known[id] {
+				return nil, fmt.Errorf("environment %q references unknown group %q", env.ID, id)
+			}
+		}
+	}
+
+	return &groups, nil
+}
+
+type xmlComps struct {
+	XMLName      xml.Name         `xml:"comps"`
+	Groups       []xmlGroup       `xml:"group"`
+	Environments []xmlEnvironment `xml:"environment"`
+}
+
+type xmlGroup struct {
+	ID           string          `xml:"id"`
+	Name         string          `xml:"name"`
+	Description  string          `xml:"description"`
+	Default      bool            `xml:"default"`
+	UserVisible  bool            `xml:"uservisible"`
+	DisplayOrder int             `xml:"display_order,omitempty"`
+	PackageList  []xmlPackageReq `xml:"packagelist>packagereq"`
+}
+
+type xmlPackageReq struct {
+	Type string `xml:"type,attr"`
+	Name string `xml:",chardata"`
+}
+
+type xmlEnvironment struct {
+	ID           string   `xml:"id"`
+	Name         string   `xml:"name"`
+	Description  string   `xml:"description"`
+	DisplayOrder int      `xml:"display_order,omitempty"`
+	GroupList    []string `xml:"grouplist>groupid"`
+	OptionList   []string `xml:"optionlist>groupid"`
+}
+
+// generateCompsXML renders the groups in the comps.xml format used by dnf
+func generateCompsXML(groups *compsGroups) ([]byte, error) {
+	comps := xmlComps{}
+
+	for _, group := range groups.Groups {
+		xg := xmlGroup{
+			ID:           group.ID,
+			Name:         group.Name,
+			Description:  group.Description,
+			Default:      group.Default != nil && *group.Default,
+			UserVisible:  group.UserVisible == nil || *group.UserVisible,
+			DisplayOrder: group.DisplayOrder,
+		}
+		if xg.Name == "" {
+			xg.Name = group.ID
+		}
+
+		for _, name := range group.Packages.Mandatory {
+			xg.PackageList = append(xg.PackageList, xmlPackageReq{Type: "mandatory", Name: name})
+		}
+		for _, name := range group.Packages.Default {
+			xg.PackageList = append(xg.PackageList, xmlPackageReq{Type: "default", Name: name})
+		}
+		for _, name := range group.Packages.Optional {
+			xg.PackageList = append(xg.PackageList, xmlPackageReq{Type: "optional", Name: name})
+		}
+
+		comps.Groups = append(comps.Groups, xg)
+	}
+
+	for _, env := range groups.Environments {
+		xe := xmlEnvironment{
+			ID:           env.ID,
+			Name:         env.Name,
+			Description:  env.Description,
+			DisplayOrder: env.DisplayOrder,
+			GroupList:    env.Groups,
+			OptionList:   env.Options,
+		}
+		if xe.Name == "" {
+			xe.Name = env.ID
+		}
+		comps.Environments = append(comps.Environments, xe)
+	}
+
+	xmlBytes, err := xml.MarshalIndent(comps, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	header := xml.Header + `<!DOCTYPE comps PUBLIC "-//Red Hat, Inc.//DTD Comps info//EN" "comps.dtd">` + "\n"
+	return append([]byte(header), xmlBytes...), nil
+}
+
+// writeCompsXML writes comps.xml, plain and gzipped like createrepo_c does,
+// and returns the matching repomd.xml entries
+func writeCompsXML(config *models.RepositoryConfig, repodataDir string, groups *compsGroups) ([]repomdData, error) {
+	compsXML, err := generateCompsXML(groups)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate comps.xml: %w", err)
+	}
+
+	compsGz, err := utils.GzipCompress(compsXML)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress comps.xml: %w", err)
+	}
+
+	compsChecksum, _ := utils.CalculateChecksum(compsXML, "sha256")
+	compsGzChecksum, _ := utils.CalculateChecksum(compsGz, "sha256")
+
+	compsName := fmt.Sprintf("%s-comps.xml", compsChecksum)
+	compsGzName := fmt.Sprintf("%s-comps.xml.gz", compsGzChecksum)
+
+	if err := utils.WriteFile(filepath.Join(repodataDir, compsName), compsXML, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write comps.xml: %w", err)
+	}
+	if err := utils.WriteFile(filepath.Join(repodataDir, compsGzName), compsGz, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write comps.xml.gz: %w", err)
+	}
+
+	entries := []repomdData{
+		newRepomdData("group", "repodata/"+compsName, compsXML, nil),
+		newRepomdData("group_gz", "repodata/"+compsGzName, compsGz, compsXML),
+	}
+
+	if config.RPMZchunk {
+		zckEntry, err := writeZchunk(repodataDir, "comps.xml", "group_zck", compsXML)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, zckEntry)
+	}
+	return entries, nil
+}
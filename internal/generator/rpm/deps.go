@@ -0,0 +1,189 @@
+package rpm
+
+import (
+	"encoding/gob"
+	"encoding/xml"
+	"strings"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/sassoftware/go-rpmutils"
+)
+
+// Weak dependency tags, which go-rpmutils doesn't define
+const (
+	tagRecommendName     = 5046
+	tagRecommendVersion  = 5047
+	tagRecommendFlags    = 5048
+	tagSuggestName       = 5049
+	tagSuggestVersion    = 5050
+	tagSuggestFlags      = 5051
+	tagSupplementName    = 5052
+	tagSupplementVersion = 5053
+	tagSupplementFlags   = 5054
+	tagEnhanceName       = 5055
+	tagEnhanceVersion    = 5056
+	tagEnhanceFlags      = 5057
+)
+
+// Dependency flags (RPMSENSE_*)
+const (
+	senseLess       = 1 << 1
+	senseGreater    = 1 << 2
+	senseEqual      = 1 << 3
+	sensePrereq     = 1 << 6
+	senseScriptPre  = 1 << 9
+	senseScriptPost = 1 << 10
+	senseRpmlib     = 1 << 24
+)
+
+// dependencyKinds are the dependency lists of primary.xml, in the order
+// createrepo writes them, with the header tags they are read from
+var dependencyKinds = []struct {
+	element              string
+	name, version, flags int
+}{
+	{"provides", rpmutils.PROVIDENAME, rpmutils.PROVIDEVERSION, rpmutils.PROVIDEFLAGS},
+	{"requires", rpmutils.REQUIRENAME, rpmutils.REQUIREVERSION, rpmutils.REQUIREFLAGS},
+	{"conflicts", rpmutils.CONFLICTNAME, rpmutils.CONFLICTVERSION, rpmutils.CONFLICTFLAGS},
+	{"obsoletes", rpmutils.OBSOLETENAME, rpmutils.OBSOLETEVERSION, rpmutils.OBSOLETEFLAGS},
+	{"suggests", tagSuggestName, tagSuggestVersion, tagSuggestFlags},
+	{"enhances", tagEnhanceName, tagEnhanceVersion, tagEnhanceFlags},
+	{"recommends", tagRecommendName, tagRecommendVersion, tagRecommendFlags},
+	{"supplements", tagSupplementName, tagSupplementVersion, tagSupplementFlags},
+}
+
+// The parse cache stores the dependency lists of parsed packages
+func init() {
+	gob.Register([]xmlDependencies(nil))
+}
+
+// xmlDependencies is a dependency list of primary.xml, e.g. <rpm:requires>
+type xmlDependencies struct {
+	XMLName xml.Name
+	Entries []xmlEntry `xml:"rpm:entry"`
+}
+
+type xmlEntry struct {
+	Name  string `xml:"name,attr"`
+	Flags string `xml:"flags,attr,omitempty"`
+	Epoch string `xml:"epoch,attr,omitempty"`
+	Ver   string `xml:"ver,attr,omitempty"`
+	Rel   string `xml:"rel,attr,omitempty"`
+	Pre   string `xml:"pre,attr,omitempty"`
+}
+
+// readDependencies reads the dependency lists of an RPM header, rich
+// dependencies such as "(foo if bar)" being kept as their name
+func readDependencies(rpm *rpmutils.Rpm) []xmlDependencies {
+	var lists []xmlDependencies
+	for _, kind := range dependencyKinds {
+		names, err := rpm.Header.GetStrings(kind.name)
+		if err != nil || len(names) == 0 {
+			continue
+		}
+		versions, _ := rpm.Header.GetStrings(kind.version)
+		flags, _ := rpm.Header.GetInts(kind.flags)
+
+		seen := make(map[xmlEntry]bool)
+		var entries []xmlEntry
+		for i, name := range names {
+			var version string
+			var flag int
+			if i < len(versions) {
+				version = versions[i]
+			}
+			if i < len(flags) {
+				flag = flags[i]
+			}
+
+			// rpmlib() requirements are checked by rpm itself, not resolved
+			if flag&senseRpmlib != 0 || strings.HasPrefix(name, "rpmlib(") {
+				continue
+			}
+
+			entry := xmlEntry{Name: name, Flags: comparison(flag)}
+			if version != "" {
+				entry.Epoch, entry.Ver, entry.Rel = splitEVR(version)
+			}
+			if kind.element == "requires" && flag&(sensePrereq|senseScriptPre|senseScriptPost) != 0 {
+				entry.Pre = "1"
+			}
+			if seen[entry] {
+				continue
+			}
+			seen[entry] = true
+			entries = append(entries, entry)
+		}
+
+		if len(entries) > 0 {
+			lists = append(lists, xmlDependencies{
+				XMLName: xml.Name{Local: "rpm:" + kind.element},
+				Entries: entries,
+			})
+		}
+	}
+	return lists
+}
+
+// comparison returns the primary.xml flags of a versioned dependency
+func comparison(flags int) string {
+	switch flags & (senseLess | senseGreater | senseEqual) {
+	case senseLess:
+		return "LT"
+	case senseGreater:
+		return "GT"
+	case senseEqual:
+		return "EQ"
+	case senseLess | senseEqual:
+		return "LE"
+	case senseGreater | senseEqual:
+		return "GE"
+	}
+	return ""
+}
+
+// splitEVR splits [epoch:]version[-release], the epoch defaulting to 0
+func splitEVR(evr string) (epoch, version, release string) {
+	epoch = "0"
+	if e, rest, ok := strings.Cut(evr, ":"); ok {
+		epoch, evr = e, rest
+	}
+	version = evr
+	if i := strings.LastIndex(evr, "-"); i >= 0 {
+		version, release = evr[:i], evr[i+1:]
+	}
+	return epoch, version, release
+}
+
+// Requires returns the requirements of pkg on packages or capabilities,
+// leaving out those on files, on rpmlib features and rich dependencies
+func (g *Generator) Requires(pkg models.Package) [][]string {
+	var requires [][]string
+	for _, name := range dependencyNames(pkg, "requires") {
+		if strings.HasPrefix(name, "/") || strings.HasPrefix(name, "rpmlib(") || strings.HasPrefix(name, "(") {
+			continue
+		}
+		requires = append(requires, []string{name})
+	}
+	return requires
+}
+
+// Provides returns the name of pkg and the capabilities it provides
+func (g *Generator) Provides(pkg models.Package) []string {
+	return append([]string{pkg.Name}, dependencyNames(pkg, "provides")...)
+}
+
+// dependencyNames returns the names of the dependency list element of pkg
+func dependencyNames(pkg models.Package, element string) []string {
+	lists, _ := pkg.Metadata["Dependencies"].([]xmlDependencies)
+	var names []string
+	for _, list := range lists {
+		if list.XMLName.Local != element && list.XMLName.Local != "rpm:"+element {
+			continue
+		}
+		for _, entry := range list.Entries {
+			names = append(names, entry.Name)
+		}
+	}
+	return names
+}
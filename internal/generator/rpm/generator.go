@@ -1,18 +1,29 @@
 package rpm
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/xml"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/ralt/repogen/internal/events"
 	"github.com/ralt/repogen/internal/generator"
 	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/overrides"
 	"github.com/ralt/repogen/internal/scanner"
 	"github.com/ralt/repogen/internal/signer"
 	"github.com/ralt/repogen/internal/utils"
+	"github.com/sassoftware/go-rpmutils"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/openpgp"
 )
 
 // Generator implements the generator.Generator interface for RPM repositories
@@ -20,12 +31,6 @@ type Generator struct {
 	signer signer.Signer
 }
 
-// versionArch represents a version/architecture combination
-type versionArch struct {
-	version string
-	arch    string
-}
-
 // NewGenerator creates a new RPM generator
 func NewGenerator(s signer.Signer) generator.Generator {
 	return &Generator{
@@ -37,40 +42,74 @@ func NewGenerator(s signer.Signer) generator.Generator {
 func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfig, packages []models.Package) error {
 	logrus.Info("Generating RPM repository...")
 
-	// Group packages by version and architecture
-	versionArchPackages := make(map[versionArch][]models.Package)
+	// Group packages by repository directory, as rendered by the layout
+	repoDirPackages := make(map[string][]models.Package)
 
+	arches := versionArches(config, packages)
 	for _, pkg := range packages {
-		version := getPackageVersion(config, pkg)
-		arch := pkg.Architecture
-		if arch == "" {
-			arch = "x86_64" // default architecture
+		dirs, err := repoDirs(config, pkg, arches)
+		if err != nil {
+			return &models.RepoGenError{Type: models.ErrInvalidConfig, Err: err}
+		}
+		for _, dir := range dirs {
+			repoDirPackages[dir] = append(repoDirPackages[dir], pkg)
 		}
-		key := versionArch{version: version, arch: arch}
-		versionArchPackages[key] = append(versionArchPackages[key], pkg)
 	}
 
-	// Generate repository for each version/arch combination
-	for versionArchKey, pkgs := range versionArchPackages {
-		if err := g.generateForVersionArch(ctx, config, versionArchKey.version, versionArchKey.arch, pkgs); err != nil {
-			return fmt.Errorf("failed to generate for %s/%s: %w", versionArchKey.version, versionArchKey.arch, err)
+	// Load package groups, shared by every repository
+	var groups *compsGroups
+	if config.RPMGroupsPath != "" {
+		var err error
+		groups, err = loadGroups(config.RPMGroupsPath)
+		if err != nil {
+			return err
 		}
 	}
 
-	// Sign repositories if signer available (log after all versions/archs are done)
+	// Load advisories, matched against the packages of each repository
+	var adv *advisories
+	if config.RPMAdvisoriesPath != "" {
+		var err error
+		adv, err = loadAdvisories(config.RPMAdvisoriesPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Generate each repository, several at once
+	dirs := make([]string, 0, len(repoDirPackages))
+	for dir := range repoDirPackages {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	err := generator.ForEach(ctx, generator.Concurrency(config), len(dirs), func(ctx context.Context, i int) error {
+		if err := g.generateForRepoDir(ctx, config, dirs[i], repoDirPackages[dirs[i]], groups, adv); err != nil {
+			return fmt.Errorf("failed to generate for %s: %w", dirs[i], err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Sign repositories if signer available (log after all repositories are done)
 	if g.signer != nil {
+		// The .repo file's gpgkey= points at the armored key by default
+		if err := signer.PublishPublicKey(g.signer, config.OutputDir, utils.RepoSlug(config), utils.Timestamp(config)); err != nil {
+			return err
+		}
 		logrus.Info("Repository signed successfully")
 	}
 
 	// Generate .repo file if BaseURL is provided
 	if config.BaseURL != "" {
-		repoFile, err := generateRepoFile(config, g.signer != nil)
+		repoFile, err := generateRepoFile(config, g.signer != nil, packages)
 		if err != nil {
 			return fmt.Errorf("failed to generate .repo file: %w", err)
 		}
 
 		// Use distro name for filename, fall back to sanitized origin
-		repoFileName := fmt.Sprintf("%s.repo", getRepoFileName(config))
+		repoFileName := fmt.Sprintf("%s.repo", RepoFileName(config))
 		repoFilePath := filepath.Join(config.OutputDir, repoFileName)
 
 		if err := utils.WriteFile(repoFilePath, repoFile, 0644); err != nil {
@@ -84,14 +123,14 @@ func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfi
 	return nil
 }
 
-// generateForVersionArch generates repository for a specific version/arch combination
-func (g *Generator) generateForVersionArch(ctx context.Context, config *models.RepositoryConfig, version, arch string, packages []models.Package) error {
-	logrus.Infof("Generating for version %s, architecture: %s", version, arch)
+// generateForRepoDir generates the repository at dir, relative to the output directory
+func (g *Generator) generateForRepoDir(ctx context.Context, config *models.RepositoryConfig, dir string, packages []models.Package, groups *compsGroups, adv *advisories) error {
+	logrus.Infof("Generating repository %s", dir)
 
-	// Create directory structure: OutputDir/version/arch/
-	versionArchDir := filepath.Join(config.OutputDir, version, arch)
-	repodataDir := filepath.Join(versionArchDir, "repodata")
-	packagesDir := filepath.Join(versionArchDir, "Packages")
+	// Create directory structure: OutputDir/<layout>/
+	repoPath := filepath.Join(config.OutputDir, filepath.FromSlash(dir))
+	repodataDir := filepath.Join(repoPath, "repodata")
+	packagesDir := filepath.Join(repoPath, "Packages")
 
 	if err := utils.EnsureDir(repodataDir); err != nil {
 		return err
@@ -102,6 +141,9 @@ func (g *Generator) generateForVersionArch(ctx context.Context, config *models.R
 
 	// Copy RPM files to Packages directory and recalculate checksums
 	for i := range packages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		pkg := &packages[i]
 		dstPath := filepath.Join(packagesDir, filepath.Base(pkg.Filename))
 
@@ -111,17 +153,47 @@ func (g *Generator) generateForVersionArch(ctx context.Context, config *models.R
 			return fmt.Errorf("package copy check failed for %s: %w", pkg.Name, err)
 		}
 
+		// The signed copy of an earlier run differs from its input: keep it
+		// rather than signing it again
+		if needsCopy && config.SignRPMs && g.signedCopy(srcPath, finalDstPath) {
+			logrus.Debugf("Package already signed: %s", filepath.Base(finalDstPath))
+			checksums, err := utils.CalculateChecksums(finalDstPath)
+			if err != nil {
+				return fmt.Errorf("failed to calculate checksums for %s: %w", filepath.Base(pkg.Filename), err)
+			}
+			pkg.Size = checksums.Size
+			pkg.MD5Sum = checksums.MD5
+			pkg.SHA1Sum = checksums.SHA1
+			pkg.SHA256Sum = checksums.SHA256
+			needsCopy = false
+		}
+
 		if needsCopy {
 			logrus.Debugf("Copying package: %s -> %s", srcPath, finalDstPath)
 
-			if err := utils.CopyFile(srcPath, finalDstPath); err != nil {
+			// Copy package file, checksumming it on the way
+			checksums, err := utils.CopyFileWithChecksums(ctx, srcPath, finalDstPath)
+			if err != nil {
 				return fmt.Errorf("failed to copy %s: %w", srcPath, err)
 			}
 
-			// Recalculate checksums on the copied file to ensure accuracy
-			checksums, err := utils.CalculateChecksums(finalDstPath)
-			if err != nil {
-				return fmt.Errorf("failed to calculate checksums for %s: %w", filepath.Base(pkg.Filename), err)
+			if config.VerifyWrites {
+				if err := utils.VerifyCopy(finalDstPath, checksums); err != nil {
+					return fmt.Errorf("failed to verify %s: %w", srcPath, err)
+				}
+			}
+
+			// Sign the copy, never the input file, so gpgcheck=1 clients accept it
+			if config.SignRPMs {
+				if err := g.signPackage(finalDstPath, utils.Timestamp(config)); err != nil {
+					return err
+				}
+
+				// Signing rewrites the header, so the checksums changed
+				checksums, err = utils.CalculateChecksums(finalDstPath)
+				if err != nil {
+					return fmt.Errorf("failed to calculate checksums for %s: %w", filepath.Base(pkg.Filename), err)
+				}
 			}
 			pkg.Size = checksums.Size
 			pkg.MD5Sum = checksums.MD5
@@ -135,7 +207,8 @@ func (g *Generator) generateForVersionArch(ctx context.Context, config *models.R
 	}
 
 	// Generate primary.xml
-	primaryXML, err := generatePrimaryXML(packages)
+	xmlPackages := primaryPackages(packages, utils.Timestamp(config))
+	primaryXML, err := generatePrimaryXML(xmlPackages)
 	if err != nil {
 		return fmt.Errorf("failed to generate primary.xml: %w", err)
 	}
@@ -155,8 +228,48 @@ func (g *Generator) generateForVersionArch(ctx context.Context, config *models.R
 		return fmt.Errorf("failed to write primary.xml.gz: %w", err)
 	}
 
+	repomdEntries := []repomdData{
+		newRepomdData("primary", fmt.Sprintf("repodata/%s-primary.xml.gz", primaryChecksum), primaryGz, primaryXML),
+	}
+
+	// Generate primary.xml.zck for delta downloads
+	if config.RPMZchunk {
+		primaryZckEntry, err := writeZchunk(repodataDir, "primary.xml", "primary_zck", primaryXML)
+		if err != nil {
+			return err
+		}
+		repomdEntries = append(repomdEntries, primaryZckEntry)
+	}
+
+	// Generate the sqlite databases yum reads instead of the XML metadata
+	if config.RPMSQLite {
+		dbEntries, err := writeDatabases(repoPath, repodataDir, xmlPackages, primaryChecksum)
+		if err != nil {
+			return err
+		}
+		repomdEntries = append(repomdEntries, dbEntries...)
+	}
+
+	// Generate comps.xml so `dnf group install` works
+	if groups != nil {
+		groupEntries, err := writeCompsXML(config, repodataDir, groups)
+		if err != nil {
+			return err
+		}
+		repomdEntries = append(repomdEntries, groupEntries...)
+	}
+
+	// Generate updateinfo.xml so `dnf updateinfo` lists the advisories
+	if adv != nil {
+		updateInfoEntries, err := writeUpdateInfoXML(config, repodataDir, adv, packages)
+		if err != nil {
+			return err
+		}
+		repomdEntries = append(repomdEntries, updateInfoEntries...)
+	}
+
 	// Generate repomd.xml
-	repomdXML, err := generateRepomdXML(primaryChecksum, int64(len(primaryGz)), int64(len(primaryXML)))
+	repomdXML, err := generateRepomdXML(repomdEntries, utils.Timestamp(config))
 	if err != nil {
 		return fmt.Errorf("failed to generate repomd.xml: %w", err)
 	}
@@ -172,17 +285,101 @@ func (g *Generator) generateForVersionArch(ctx context.Context, config *models.R
 		if err != nil {
 			return fmt.Errorf("failed to sign repomd.xml: %w", err)
 		}
+		// Catch key or algorithm misconfigurations before dnf does
+		if err := signer.CheckDetached(g.signer, signature, repomdXML); err != nil {
+			return fmt.Errorf("repomd.xml.asc self-check failed: %w", err)
+		}
 
 		sigPath := filepath.Join(repodataDir, "repomd.xml.asc")
 		if err := utils.WriteFile(sigPath, signature, 0644); err != nil {
 			return fmt.Errorf("failed to write repomd.xml.asc: %w", err)
 		}
+		events.Emit(events.Signed, events.Fields{"path": sigPath, "kind": "detached"})
+
+		// zypper offers to import the key it finds next to the signature
+		if distroVariant(config) == "suse" {
+			publicKey, err := g.signer.GetPublicKey()
+			if err != nil {
+				return fmt.Errorf("failed to get public key: %w", err)
+			}
+			if err := utils.WriteFile(filepath.Join(repodataDir, "repomd.xml.key"), publicKey, 0644); err != nil {
+				return fmt.Errorf("failed to write repomd.xml.key: %w", err)
+			}
+		}
+	}
+
+	logrus.Infof("Generated repository %s (%d packages)", dir, len(packages))
+	return nil
+}
+
+// signPackage embeds a GPG signature made at created into an RPM package
+func (g *Generator) signPackage(path string, created time.Time) error {
+	rpmSigner, ok := g.signer.(signer.RPMSigner)
+	if !ok {
+		return fmt.Errorf("RPM package signing requires a GPG signer")
+	}
+
+	logrus.Debugf("Signing package: %s", filepath.Base(path))
+	if err := rpmSigner.SignRPM(path, created); err != nil {
+		return fmt.Errorf("failed to sign %s: %w", filepath.Base(path), err)
 	}
+	events.Emit(events.Signed, events.Fields{"path": path, "kind": "package"})
 
-	logrus.Infof("Generated repository for %s/%s (%d packages)", version, arch, len(packages))
 	return nil
 }
 
+// signedCopy reports whether dst carries valid signatures of the signing
+// key over the same header and payload as src
+func (g *Generator) signedCopy(src, dst string) bool {
+	publicKey, err := g.signer.GetPublicKey()
+	if err != nil {
+		return false
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(publicKey))
+	if err != nil {
+		return false
+	}
+
+	f, err := os.Open(dst)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if _, sigs, err := rpmutils.Verify(f, keyring); err != nil || len(sigs) == 0 {
+		return false
+	}
+
+	srcDigest, err := signedDigest(src)
+	if err != nil {
+		return false
+	}
+	dstDigest, err := signedDigest(dst)
+	return err == nil && srcDigest == dstDigest
+}
+
+// signedDigest returns the SHA-256 of the header and payload of the RPM at
+// path, the part of the file its signatures cover
+func signedDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header, err := rpmutils.ReadHeader(f)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(int64(header.GetRange().Start), io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // ValidatePackages checks if packages are valid RPM packages
 func (g *Generator) ValidatePackages(packages []models.Package) error {
 	for _, pkg := range packages {
@@ -221,6 +418,9 @@ type xmlPkg struct {
 	Size     xmlSize     `xml:"size"`
 	Location xmlLocation `xml:"location"`
 	Format   xmlFormat   `xml:"format"`
+
+	// Extra holds elements repogen doesn't model, preserved from existing metadata
+	Extra []xmlExtra `xml:",any"`
 }
 
 type xmlVersion struct {
@@ -253,9 +453,108 @@ type xmlLocation struct {
 type xmlFormat struct {
 	License string `xml:"rpm:license,omitempty"`
 	Group   string `xml:"rpm:group,omitempty"`
+
+	// Dependencies read from the headers of new packages, or from existing
+	// metadata
+	Dependencies []xmlDependencies
+
+	// Extra holds format entries repogen doesn't model (provides, files, ...)
+	Extra []xmlExtra `xml:",any"`
+}
+
+// UnmarshalXML decodes a <format> element. encoding/xml matches prefixed
+// tags such as "rpm:license" literally, so namespaced children are matched
+// by hand, dependency lists parsed and anything unknown kept verbatim in Extra
+func (f *xmlFormat) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := qualifiedName(t.Name)
+			switch {
+			case name == "rpm:license":
+				if err := d.DecodeElement(&f.License, &t); err != nil {
+					return err
+				}
+			case name == "rpm:group":
+				if err := d.DecodeElement(&f.Group, &t); err != nil {
+					return err
+				}
+			case isDependencyList(name):
+				var list struct {
+					Entries []xmlEntry `xml:"entry"`
+				}
+				if err := d.DecodeElement(&list, &t); err != nil {
+					return err
+				}
+				f.Dependencies = append(f.Dependencies, xmlDependencies{
+					XMLName: xml.Name{Local: name},
+					Entries: list.Entries,
+				})
+			default:
+				var extra xmlExtra
+				if err := d.DecodeElement(&extra, &t); err != nil {
+					return err
+				}
+				f.Extra = append(f.Extra, extra)
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// xmlExtra is an arbitrary element carried through regeneration unchanged
+type xmlExtra struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   string     `xml:",innerxml"`
+}
+
+// UnmarshalXML decodes an element and rewrites its name with the prefix
+// used in primary.xml, so it is marshalled back as e.g. <rpm:provides>
+func (e *xmlExtra) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type rawExtra xmlExtra
+	var raw rawExtra
+	if err := d.DecodeElement(&raw, &start); err != nil {
+		return err
+	}
+
+	raw.XMLName = xml.Name{Local: qualifiedName(start.Name)}
+
+	// Namespace declarations are already present on the document root
+	var attrs []xml.Attr
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+			continue
+		}
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: qualifiedName(attr.Name)}, Value: attr.Value})
+	}
+	raw.Attrs = attrs
+
+	*e = xmlExtra(raw)
+	return nil
+}
+
+// qualifiedName maps a namespaced XML name back to the prefixed form used in repodata
+func qualifiedName(name xml.Name) string {
+	if name.Space == rpmNamespace || name.Space == "rpm" {
+		return "rpm:" + name.Local
+	}
+	return name.Local
 }
 
-func generatePrimaryXML(packages []models.Package) ([]byte, error) {
+const (
+	commonNamespace = "http://linux.duke.edu/metadata/common"
+	rpmNamespace    = "http://linux.duke.edu/metadata/rpm"
+)
+
+// primaryPackages describes packages as primary.xml lists them
+func primaryPackages(packages []models.Package, now time.Time) []xmlPkg {
 	var xmlPackages []xmlPkg
 
 	for _, pkg := range packages {
@@ -263,8 +562,12 @@ func generatePrimaryXML(packages []models.Package) ([]byte, error) {
 		if r, ok := pkg.Metadata["Release"].(string); ok {
 			release = r
 		}
+		epoch := "0"
+		if e, ok := pkg.Metadata["Epoch"].(string); ok && e != "" {
+			epoch = e
+		}
 
-		buildTime := time.Now().Unix()
+		buildTime := now.Unix()
 		if bt, ok := pkg.Metadata["BuildTime"].(int64); ok {
 			buildTime = bt
 		}
@@ -274,7 +577,7 @@ func generatePrimaryXML(packages []models.Package) ([]byte, error) {
 			Name: pkg.Name,
 			Arch: pkg.Architecture,
 			Version: xmlVersion{
-				Epoch: "0",
+				Epoch: epoch,
 				Ver:   pkg.Version,
 				Rel:   release,
 			},
@@ -287,7 +590,7 @@ func generatePrimaryXML(packages []models.Package) ([]byte, error) {
 			Packager: pkg.Maintainer,
 			URL:      pkg.Homepage,
 			Time: xmlTime{
-				File:  time.Now().Unix(),
+				File:  now.Unix(),
 				Build: buildTime,
 			},
 			Size: xmlSize{
@@ -304,13 +607,29 @@ func generatePrimaryXML(packages []models.Package) ([]byte, error) {
 			},
 		}
 
+		if deps, ok := pkg.Metadata["Dependencies"].([]xmlDependencies); ok {
+			xmlPkg.Format.Dependencies = deps
+		}
+
+		// Carry through elements preserved from existing metadata
+		if extra, ok := pkg.Metadata["primary_extra"].([]xmlExtra); ok {
+			xmlPkg.Extra = extra
+		}
+		if extra, ok := pkg.Metadata["format_extra"].([]xmlExtra); ok {
+			xmlPkg.Format.Extra = extra
+		}
+
 		xmlPackages = append(xmlPackages, xmlPkg)
 	}
 
+	return xmlPackages
+}
+
+func generatePrimaryXML(xmlPackages []xmlPkg) ([]byte, error) {
 	meta := metadata{
-		Xmlns:         "http://linux.duke.edu/metadata/common",
-		XmlnsRpm:      "http://linux.duke.edu/metadata/rpm",
-		PackagesCount: len(packages),
+		Xmlns:         commonNamespace,
+		XmlnsRpm:      rpmNamespace,
+		PackagesCount: len(xmlPackages),
 		Packages:      xmlPackages,
 	}
 
@@ -331,13 +650,20 @@ type repomd struct {
 }
 
 type repomdData struct {
-	Type         string         `xml:"type,attr"`
-	Checksum     repomdChecksum `xml:"checksum"`
-	OpenChecksum repomdChecksum `xml:"open-checksum"`
-	Location     repomdLocation `xml:"location"`
-	Timestamp    int64          `xml:"timestamp"`
-	Size         int64          `xml:"size"`
-	OpenSize     int64          `xml:"open-size"`
+	Type         string          `xml:"type,attr"`
+	Checksum     repomdChecksum  `xml:"checksum"`
+	OpenChecksum *repomdChecksum `xml:"open-checksum,omitempty"`
+	Location     repomdLocation  `xml:"location"`
+	Timestamp    int64           `xml:"timestamp"`
+	Size         int64           `xml:"size"`
+	OpenSize     int64           `xml:"open-size,omitempty"`
+
+	// DatabaseVersion is the schema version of sqlite databases
+	DatabaseVersion int `xml:"database_version,omitempty"`
+
+	// Header of zchunk files, which clients fetch first
+	HeaderChecksum *repomdChecksum `xml:"header-checksum,omitempty"`
+	HeaderSize     int64           `xml:"header-size,omitempty"`
 }
 
 type repomdChecksum struct {
@@ -349,32 +675,44 @@ type repomdLocation struct {
 	Href string `xml:"href,attr"`
 }
 
-func generateRepomdXML(primaryChecksum string, compressedSize, uncompressedSize int64) ([]byte, error) {
-	openChecksum, _ := utils.CalculateChecksum([]byte(primaryChecksum), "sha256")
+// newRepomdData describes a metadata file stored at href. openData is the
+// uncompressed content for compressed files, nil otherwise
+func newRepomdData(dataType, href string, data, openData []byte) repomdData {
+	checksum, _ := utils.CalculateChecksum(data, "sha256")
+
+	entry := repomdData{
+		Type: dataType,
+		Checksum: repomdChecksum{
+			Type:  "sha256",
+			Value: checksum,
+		},
+		Location: repomdLocation{
+			Href: href,
+		},
+		Size: int64(len(data)),
+	}
+
+	if openData != nil {
+		openChecksum, _ := utils.CalculateChecksum(openData, "sha256")
+		entry.OpenChecksum = &repomdChecksum{
+			Type:  "sha256",
+			Value: openChecksum,
+		}
+		entry.OpenSize = int64(len(openData))
+	}
+
+	return entry
+}
 
+func generateRepomdXML(data []repomdData, now time.Time) ([]byte, error) {
+	for i := range data {
+		data[i].Timestamp = now.Unix()
+	}
 	repomd := repomd{
 		Xmlns:    "http://linux.duke.edu/metadata/repo",
 		XmlnsRpm: "http://linux.duke.edu/metadata/rpm",
-		Revision: time.Now().Unix(),
-		Data: []repomdData{
-			{
-				Type: "primary",
-				Checksum: repomdChecksum{
-					Type:  "sha256",
-					Value: primaryChecksum,
-				},
-				OpenChecksum: repomdChecksum{
-					Type:  "sha256",
-					Value: openChecksum,
-				},
-				Location: repomdLocation{
-					Href: fmt.Sprintf("repodata/%s-primary.xml.gz", primaryChecksum),
-				},
-				Timestamp: time.Now().Unix(),
-				Size:      compressedSize,
-				OpenSize:  uncompressedSize,
-			},
-		},
+		Revision: now.Unix(),
+		Data:     data,
 	}
 
 	xmlBytes, err := xml.MarshalIndent(repomd, "", "  ")
@@ -385,26 +723,23 @@ func generateRepomdXML(primaryChecksum string, compressedSize, uncompressedSize
 	return append([]byte(xml.Header), xmlBytes...), nil
 }
 
-// generateRepoFile creates a .repo configuration file for dnf/yum
-func generateRepoFile(config *models.RepositoryConfig, isSigned bool) ([]byte, error) {
-	repoID := sanitizeRepoID(config.Origin)
+// generateRepoFile creates a .repo configuration file for dnf/yum, or zypper,
+// listing the noarch and source repositories packages need
+func generateRepoFile(config *models.RepositoryConfig, isSigned bool, packages []models.Package) ([]byte, error) {
+	repoID := utils.Slug(config.Origin)
 	repoName := config.Label
 	if repoName == "" {
 		repoName = config.Origin
 	}
 
-	baseURL := config.BaseURL
-	if baseURL[len(baseURL)-1] != '/' {
-		baseURL += "/"
+	// The layout with $releasever/$basearch variables for yum/dnf substitution
+	baseURL, err := repoBaseURL(config)
+	if err != nil {
+		return nil, err
 	}
-	// Add $releasever/$basearch variables for yum/dnf substitution
-	baseURL = fmt.Sprintf("%s$releasever/$basearch", baseURL)
 
 	// Get distribution-specific defaults
-	distro := config.DistroVariant
-	if distro == "" {
-		distro = "fedora"
-	}
+	distro := distroVariant(config)
 
 	gpgCheck := "0"
 	repoGpgCheck := "0"
@@ -412,12 +747,25 @@ func generateRepoFile(config *models.RepositoryConfig, isSigned bool) ([]byte, e
 	additionalOptions := ""
 
 	if isSigned {
-		gpgCheck = "1"
-		// Use explicit GPG key URL instead of auto-generating
-		gpgKey = fmt.Sprintf("gpgkey=%s\n", config.GPGKeyURL)
+		// Prefer an explicit GPG key URL, else the armored key published with the repository
+		keyURL := config.GPGKeyURL
+		if keyURL == "" {
+			keyURL = config.BaseURL
+			if !strings.HasSuffix(keyURL, "/") {
+				keyURL += "/"
+			}
+			keyURL += signer.PublicKeyPath(utils.RepoSlug(config), signer.KeyArmored)
+		}
+		gpgKey = fmt.Sprintf("gpgkey=%s\n", keyURL)
+
+		// gpgcheck verifies the packages themselves, which only works once they are signed
+		if config.SignRPMs {
+			gpgCheck = "1"
+		}
 
-		// Fedora typically enables repo_gpgcheck
-		if distro == "fedora" {
+		// Fedora and zypper typically enable repo_gpgcheck; without package
+		// signatures the signed metadata is the only thing left to verify
+		if distro == "fedora" || distro == "suse" || !config.SignRPMs {
 			repoGpgCheck = "1"
 		}
 	}
@@ -434,38 +782,74 @@ func generateRepoFile(config *models.RepositoryConfig, isSigned bool) ([]byte, e
 	repoContent := fmt.Sprintf(`[%s]
 name=%s
 baseurl=%s
-enabled=1
-gpgcheck=%s`, repoID, repoName, baseURL, gpgCheck)
+enabled=1`, repoID, repoName, baseURL)
+
+	// Settings shared with the source repository. zypper needs the
+	// metadata type, and only refreshes it when asked to
+	var settings string
+	if distro == "suse" {
+		settings += "\nautorefresh=1\ntype=rpm-md"
+	}
+
+	settings += fmt.Sprintf("\ngpgcheck=%s", gpgCheck)
 
 	if repoGpgCheck == "1" {
-		repoContent += fmt.Sprintf("\nrepo_gpgcheck=%s", repoGpgCheck)
+		settings += fmt.Sprintf("\nrepo_gpgcheck=%s", repoGpgCheck)
 	}
 
 	if gpgKey != "" {
-		repoContent += fmt.Sprintf("\n%s", gpgKey)
+		settings += fmt.Sprintf("\n%s", gpgKey)
 	}
+	repoContent += settings
 
 	if additionalOptions != "" {
 		repoContent += fmt.Sprintf("\n%s", additionalOptions)
 	}
 
-	return []byte(repoContent), nil
-}
+	// Suggest hiding deprecated packages rather than forcing it on users
+	if deprecated := overrides.DeprecatedNames(packages); len(deprecated) > 0 {
+		if distro == "suse" {
+			repoContent += "\n# The following packages are deprecated; lock them to hide them from zypper:"
+			repoContent += fmt.Sprintf("\n# zypper addlock %s", strings.Join(deprecated, " "))
+		} else {
+			repoContent += "\n# The following packages are deprecated; uncomment to hide them from dnf:"
+			repoContent += fmt.Sprintf("\n#excludepkgs=%s", strings.Join(deprecated, ","))
+		}
+	}
+
+	// noarch packages of their own repository are enabled next to $basearch
+	noarchURL, err := noarchBaseURL(config, packages)
+	if err != nil {
+		return nil, err
+	}
+	if noarchURL != "" {
+		repoContent = strings.TrimRight(repoContent, "\n")
+		repoContent += fmt.Sprintf(`
+
+[%s-noarch]
+name=%s - noarch
+baseurl=%s
+enabled=1`, repoID, repoName, noarchURL)
+		repoContent += settings
+	}
 
-// sanitizeRepoID creates a valid repository ID from a string
-func sanitizeRepoID(s string) string {
-	// Convert to lowercase and replace spaces/special chars with hyphens
-	result := ""
-	for _, ch := range s {
-		if (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') {
-			result += string(ch)
-		} else if ch >= 'A' && ch <= 'Z' {
-			result += string(ch - 'A' + 'a')
-		} else if ch == ' ' || ch == '_' || ch == '.' {
-			result += "-"
+	// dnf download --source enables the repository named <id>-source
+	if hasSourcePackages(packages) {
+		sourceURL, err := sourceBaseURL(config)
+		if err != nil {
+			return nil, err
 		}
+		repoContent = strings.TrimRight(repoContent, "\n")
+		repoContent += fmt.Sprintf(`
+
+[%s-source]
+name=%s - Source
+baseurl=%s
+enabled=0`, repoID, repoName, sourceURL)
+		repoContent += settings
 	}
-	return result
+
+	return []byte(repoContent), nil
 }
 
 // getPackageVersion determines the version for a package
@@ -489,24 +873,26 @@ func getPackageVersion(config *models.RepositoryConfig, pkg models.Package) stri
 		return "9" // CentOS Stream 9
 	case "rhel":
 		return "9" // RHEL 9
+	case "suse":
+		return "15.6" // openSUSE Leap 15.6
 	default:
 		return "40"
 	}
 }
 
-// getRepoFileName determines the .repo filename
+// RepoFileName determines the .repo filename
 // Priority: RepoName -> DistroVariant -> Sanitized Origin (fallback)
-func getRepoFileName(config *models.RepositoryConfig) string {
+func RepoFileName(config *models.RepositoryConfig) string {
 	// Priority 1: RepoName (explicit)
 	if config.RepoName != "" {
-		return sanitizeRepoID(config.RepoName)
+		return utils.Slug(config.RepoName)
 	}
 
-	// Priority 2: DistroVariant (fedora, centos, rhel)
+	// Priority 2: DistroVariant (fedora, centos, rhel, suse)
 	if config.DistroVariant != "" {
 		return config.DistroVariant
 	}
 
 	// Priority 3: Sanitized Origin
-	return sanitizeRepoID(config.Origin)
+	return utils.Slug(config.Origin)
 }
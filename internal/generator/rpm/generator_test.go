@@ -1,12 +1,30 @@
 package rpm
 
 import (
+	"bytes"
+	"compress/bzip2"
 	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
 	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/packager"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/signer"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sassoftware/go-rpmutils"
+	xopenpgp "golang.org/x/crypto/openpgp"
 )
 
 func TestIncrementalModeCopiesNewPackages(t *testing.T) {
@@ -149,3 +167,1137 @@ func TestIncrementalModeCopiesNewPackages(t *testing.T) {
 
 	t.Logf("Incremental mode test passed for RPM!")
 }
+
+func TestPrimaryXMLRoundTripPreservesExtraElements(t *testing.T) {
+	archDir := t.TempDir()
+	repodataDir := filepath.Join(archDir, "repodata")
+	os.MkdirAll(repodataDir, 0755)
+
+	primary := `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="1">
+  <package type="rpm">
+    <name>pkga</name>
+    <arch>x86_64</arch>
+    <version epoch="0" ver="1.0" rel="1"/>
+    <checksum type="sha256" pkgid="YES">abc</checksum>
+    <summary>Package A</summary>
+    <description>Longer description of package A</description>
+    <time file="1" build="2"/>
+    <size package="18" installed="18" archive="18"/>
+    <location href="Packages/pkga-1.0-1.x86_64.rpm"/>
+    <format>
+      <rpm:license>MIT</rpm:license>
+      <rpm:group>Unspecified</rpm:group>
+      <rpm:provides>
+        <rpm:entry name="pkga" flags="EQ" epoch="0" ver="1.0" rel="1"/>
+        <rpm:entry name="libpkga.so.1()(64bit)"/>
+      </rpm:provides>
+      <rpm:requires>
+        <rpm:entry name="/bin/sh" pre="1"/>
+        <rpm:entry name="pkgb" flags="GE" epoch="0" ver="2.0"/>
+      </rpm:requires>
+      <file>/usr/bin/pkga</file>
+    </format>
+  </package>
+</metadata>`
+
+	primaryGz, _ := utils.GzipCompress([]byte(primary))
+	os.WriteFile(filepath.Join(repodataDir, "abc-primary.xml.gz"), primaryGz, 0644)
+	os.WriteFile(filepath.Join(repodataDir, "repomd.xml"), []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <data type="primary"><location href="repodata/abc-primary.xml.gz"/></data>
+</repomd>`), 0644)
+
+	packages, err := parsePrimaryXML(archDir)
+	if err != nil {
+		t.Fatalf("Failed to parse primary.xml: %v", err)
+	}
+	if len(packages) != 1 {
+		t.Fatalf("Expected 1 package, got %d", len(packages))
+	}
+	if packages[0].License != "MIT" {
+		t.Errorf("Expected license MIT, got %q", packages[0].License)
+	}
+
+	// Dependencies are parsed as those of new packages are
+	if want := []string{"pkga", "libpkga.so.1()(64bit)"}; !reflect.DeepEqual(packages[0].Provides, want) {
+		t.Errorf("Provides = %q, want %q", packages[0].Provides, want)
+	}
+	if want := []string{"/bin/sh", "pkgb"}; !reflect.DeepEqual(packages[0].Dependencies, want) {
+		t.Errorf("Dependencies = %q, want %q", packages[0].Dependencies, want)
+	}
+	gen := &Generator{}
+	if requires := gen.Requires(packages[0]); !reflect.DeepEqual(requires, [][]string{{"pkgb"}}) {
+		t.Errorf("Requires = %q, want pkgb", requires)
+	}
+	if provides := gen.Provides(packages[0]); !reflect.DeepEqual(provides, []string{"pkga", "pkga", "libpkga.so.1()(64bit)"}) {
+		t.Errorf("Provides = %q", provides)
+	}
+
+	output, err := generatePrimaryXML(primaryPackages(packages, time.Now()))
+	if err != nil {
+		t.Fatalf("Failed to generate primary.xml: %v", err)
+	}
+
+	for _, expected := range []string{
+		"<description>Longer description of package A</description>",
+		`<rpm:entry name="pkga" flags="EQ" epoch="0" ver="1.0" rel="1">`,
+		`<rpm:entry name="/bin/sh" pre="1">`,
+		"<rpm:provides>",
+		"<rpm:requires>",
+		"<file>/usr/bin/pkga</file>",
+	} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("Regenerated primary.xml missing %q:\n%s", expected, output)
+		}
+	}
+}
+
+func TestSignRPMsEmbedsVerifiableSignature(t *testing.T) {
+	fixture := filepath.Join("..", "..", "..", "test", "fixtures", "rpms", "repogen-test-1.0.0-1.x86_64.rpm")
+	if _, err := os.Stat(fixture); err != nil {
+		t.Skip("RPM fixture not found")
+	}
+
+	tmpDir := t.TempDir()
+
+	// Create a throwaway signing key
+	entity, err := openpgp.NewEntity("Repogen Test", "", "test@repogen.local", nil)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	var keyBuf bytes.Buffer
+	w, _ := armor.Encode(&keyBuf, openpgp.PrivateKeyType, nil)
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatalf("Failed to serialize key: %v", err)
+	}
+	w.Close()
+	keyPath := filepath.Join(tmpDir, "key.asc")
+	os.WriteFile(keyPath, keyBuf.Bytes(), 0600)
+
+	gpgSigner, err := signer.NewGPGSigner(keyPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	pkg, err := ParsePackage(fixture)
+	if err != nil {
+		t.Fatalf("Failed to parse fixture: %v", err)
+	}
+	originalSHA256 := pkg.SHA256Sum
+
+	signedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	config := &models.RepositoryConfig{
+		OutputDir:     filepath.Join(tmpDir, "output"),
+		Version:       "40",
+		DistroVariant: "fedora",
+		SignRPMs:      true,
+		Timestamp:     signedAt,
+	}
+	gen := NewGenerator(gpgSigner)
+	if err := gen.Generate(context.Background(), config, []models.Package{*pkg}); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	// The input file must be left untouched
+	inputChecksums, _ := utils.CalculateChecksums(fixture)
+	if inputChecksums.SHA256 != originalSHA256 {
+		t.Errorf("Input RPM was modified by signing")
+	}
+
+	signedPath := filepath.Join(config.OutputDir, "40", "x86_64", "Packages", filepath.Base(fixture))
+	f, err := os.Open(signedPath)
+	if err != nil {
+		t.Fatalf("Signed RPM not found: %v", err)
+	}
+	defer f.Close()
+
+	keyring, err := xopenpgp.ReadArmoredKeyRing(bytes.NewReader(keyBuf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to read keyring: %v", err)
+	}
+	_, sigs, err := rpmutils.Verify(f, keyring)
+	if err != nil {
+		t.Fatalf("Signed RPM does not verify: %v", err)
+	}
+
+	var headerOnly, headerAndPayload bool
+	for _, sig := range sigs {
+		if !sig.CreationTime.Equal(signedAt) {
+			t.Errorf("Signature made at %v, expected the repository timestamp %v", sig.CreationTime, signedAt)
+		}
+		if sig.HeaderOnly {
+			headerOnly = true
+		} else {
+			headerAndPayload = true
+		}
+	}
+	if !headerOnly || !headerAndPayload {
+		t.Errorf("Expected both header and header+payload signatures, got %d signatures", len(sigs))
+	}
+
+	// Later runs keep the signed copy rather than signing it again
+	signed, _ := os.ReadFile(signedPath)
+	config.Timestamp = signedAt.Add(time.Hour)
+	if err := gen.Generate(context.Background(), config, []models.Package{*pkg}); err != nil {
+		t.Fatalf("Second Generate failed: %v", err)
+	}
+	if resigned, _ := os.ReadFile(signedPath); !bytes.Equal(resigned, signed) {
+		t.Error("Signed RPM was signed again")
+	}
+}
+
+func TestAgentSignerEmbedsVerifiableSignature(t *testing.T) {
+	fixture := filepath.Join("..", "..", "..", "test", "fixtures", "rpms", "repogen-test-1.0.0-1.x86_64.rpm")
+	if _, err := os.Stat(fixture); err != nil {
+		t.Skip("RPM fixture not found")
+	}
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not available")
+	}
+
+	tmpDir := t.TempDir()
+
+	// Import a throwaway key into a private keyring
+	entity, err := openpgp.NewEntity("Repogen Test", "", "test@repogen.local", nil)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	var keyBuf bytes.Buffer
+	w, _ := armor.Encode(&keyBuf, openpgp.PrivateKeyType, nil)
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatalf("Failed to serialize key: %v", err)
+	}
+	w.Close()
+
+	gnupgHome, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatalf("Failed to create keyring dir: %v", err)
+	}
+	defer os.RemoveAll(gnupgHome)
+	defer exec.Command("gpgconf", "--homedir", gnupgHome, "--kill", "gpg-agent").Run()
+	t.Setenv("GNUPGHOME", gnupgHome)
+
+	importCmd := exec.Command("gpg", "--batch", "--import")
+	importCmd.Stdin = bytes.NewReader(keyBuf.Bytes())
+	if output, err := importCmd.CombinedOutput(); err != nil {
+		t.Fatalf("Failed to import key: %v\n%s", err, output)
+	}
+
+	agentSigner, err := signer.NewAgentSigner(fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint), "")
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	pkg, err := ParsePackage(fixture)
+	if err != nil {
+		t.Fatalf("Failed to parse fixture: %v", err)
+	}
+
+	config := &models.RepositoryConfig{
+		OutputDir:     filepath.Join(tmpDir, "output"),
+		Version:       "40",
+		DistroVariant: "fedora",
+		SignRPMs:      true,
+	}
+	gen := NewGenerator(agentSigner)
+	if err := gen.Generate(context.Background(), config, []models.Package{*pkg}); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	keyring, err := xopenpgp.ReadArmoredKeyRing(bytes.NewReader(keyBuf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to read keyring: %v", err)
+	}
+
+	// The package signatures
+	f, err := os.Open(filepath.Join(config.OutputDir, "40", "x86_64", "Packages", filepath.Base(fixture)))
+	if err != nil {
+		t.Fatalf("Signed RPM not found: %v", err)
+	}
+	defer f.Close()
+	_, sigs, err := rpmutils.Verify(f, keyring)
+	if err != nil {
+		t.Fatalf("Signed RPM does not verify: %v", err)
+	}
+	if len(sigs) != 2 {
+		t.Errorf("Expected header and header+payload signatures, got %d signatures", len(sigs))
+	}
+
+	// The repomd.xml signature
+	repodataDir := filepath.Join(config.OutputDir, "40", "x86_64", "repodata")
+	repomd, _ := os.ReadFile(filepath.Join(repodataDir, "repomd.xml"))
+	sig, _ := os.ReadFile(filepath.Join(repodataDir, "repomd.xml.asc"))
+	if _, err := xopenpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(repomd), bytes.NewReader(sig)); err != nil {
+		t.Errorf("repomd.xml signature does not verify: %v", err)
+	}
+}
+
+func TestRPMGroupsGenerateComps(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	groupsPath := filepath.Join(tmpDir, "groups.yaml")
+	os.WriteFile(groupsPath, []byte(`groups:
+  - id: repogen-tools
+    name: Repogen Tools
+    description: Tools for building repositories
+    default: true
+    packages:
+      mandatory: [pkga]
+      optional: [pkgb]
+environments:
+  - id: repogen-workstation
+    name: Repogen Workstation
+    groups: [repogen-tools]
+`), 0644)
+
+	pkgPath := filepath.Join(tmpDir, "pkga-1.0-1.x86_64.rpm")
+	os.WriteFile(pkgPath, []byte("fake rpm package A"), 0644)
+
+	config := &models.RepositoryConfig{
+		OutputDir:     filepath.Join(tmpDir, "output"),
+		Version:       "40",
+		DistroVariant: "fedora",
+		RPMGroupsPath: groupsPath,
+	}
+	packages := []models.Package{
+		{Name: "pkga", Version: "1.0", Architecture: "x86_64", Filename: pkgPath},
+	}
+
+	gen := NewGenerator(nil)
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	repodataDir := filepath.Join(config.OutputDir, "40", "x86_64", "repodata")
+	repomdData, err := os.ReadFile(filepath.Join(repodataDir, "repomd.xml"))
+	if err != nil {
+		t.Fatalf("Failed to read repomd.xml: %v", err)
+	}
+
+	var md repomd
+	if err := xml.Unmarshal(repomdData, &md); err != nil {
+		t.Fatalf("Failed to parse repomd.xml: %v", err)
+	}
+
+	hrefs := make(map[string]string)
+	for _, data := range md.Data {
+		hrefs[data.Type] = data.Location.Href
+
+		content, err := os.ReadFile(filepath.Join(config.OutputDir, "40", "x86_64", data.Location.Href))
+		if err != nil {
+			t.Fatalf("repomd.xml references missing file %s: %v", data.Location.Href, err)
+		}
+		checksum, _ := utils.CalculateChecksum(content, "sha256")
+		if checksum != data.Checksum.Value {
+			t.Errorf("Checksum mismatch for %s", data.Type)
+		}
+	}
+	if hrefs["group"] == "" || hrefs["group_gz"] == "" {
+		t.Fatalf("repomd.xml does not reference comps.xml: %v", hrefs)
+	}
+
+	comps, _ := os.ReadFile(filepath.Join(config.OutputDir, "40", "x86_64", hrefs["group"]))
+	for _, expected := range []string{
+		"<id>repogen-tools</id>",
+		`<packagereq type="mandatory">pkga</packagereq>`,
+		`<packagereq type="optional">pkgb</packagereq>`,
+		"<groupid>repogen-tools</groupid>",
+	} {
+		if !strings.Contains(string(comps), expected) {
+			t.Errorf("comps.xml missing %q:\n%s", expected, comps)
+		}
+	}
+}
+
+func TestRPMGroupsRejectUnknownGroupReference(t *testing.T) {
+	groupsPath := filepath.Join(t.TempDir(), "groups.yaml")
+	os.WriteFile(groupsPath, []byte(`environments:
+  - id: broken
+    groups: [missing]
+`), 0644)
+
+	if _, err := loadGroups(groupsPath); err == nil {
+		t.Errorf("Expected an error for an environment referencing an unknown group")
+	}
+}
+
+func TestRPMAdvisoriesGenerateUpdateInfo(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	advisoriesPath := filepath.Join(tmpDir, "advisories.yaml")
+	os.WriteFile(advisoriesPath, []byte(`advisories:
+  - id: REPOGEN-2024-0001
+    type: security
+    severity: Important
+    title: pkga security update
+    issued: 2024-03-01
+    cves: [CVE-2024-0001]
+    packages:
+      - name: pkga
+        version: "1.0"
+  - id: REPOGEN-2024-0002
+    packages:
+      - name: not-in-repo
+`), 0644)
+
+	pkgPath := filepath.Join(tmpDir, "pkga-1.0-1.x86_64.rpm")
+	os.WriteFile(pkgPath, []byte("fake rpm package A"), 0644)
+
+	config := &models.RepositoryConfig{
+		OutputDir:         filepath.Join(tmpDir, "output"),
+		Version:           "40",
+		DistroVariant:     "fedora",
+		Origin:            "Repogen Test",
+		RPMAdvisoriesPath: advisoriesPath,
+	}
+	packages := []models.Package{
+		{Name: "pkga", Version: "1.0", Architecture: "x86_64", Filename: pkgPath, Metadata: map[string]interface{}{"Release": "2.fc40"}},
+	}
+
+	gen := NewGenerator(nil)
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	repoDir := filepath.Join(config.OutputDir, "40", "x86_64")
+	repomdData, _ := os.ReadFile(filepath.Join(repoDir, "repodata", "repomd.xml"))
+	var md repomd
+	if err := xml.Unmarshal(repomdData, &md); err != nil {
+		t.Fatalf("Failed to parse repomd.xml: %v", err)
+	}
+
+	var href string
+	for _, data := range md.Data {
+		if data.Type == "updateinfo" {
+			href = data.Location.Href
+		}
+	}
+	if href == "" {
+		t.Fatalf("repomd.xml does not reference updateinfo.xml:\n%s", repomdData)
+	}
+
+	updateInfoGz, err := os.ReadFile(filepath.Join(repoDir, href))
+	if err != nil {
+		t.Fatalf("Failed to read updateinfo: %v", err)
+	}
+	updateInfo, err := utils.GzipDecompress(updateInfoGz)
+	if err != nil {
+		t.Fatalf("Failed to decompress updateinfo: %v", err)
+	}
+
+	content := string(updateInfo)
+	for _, expected := range []string{
+		`<update from="Repogen Test" status="stable" type="security" version="2.0">`,
+		"<id>REPOGEN-2024-0001</id>",
+		`<issued date="2024-03-01 00:00:00"></issued>`,
+		"<severity>Important</severity>",
+		`id="CVE-2024-0001"`,
+		`<package name="pkga" version="1.0" release="2.fc40" epoch="0" arch="x86_64">`,
+	} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("updateinfo.xml missing %q:\n%s", expected, content)
+		}
+	}
+	if strings.Contains(content, "REPOGEN-2024-0002") {
+		t.Errorf("Advisory without matching packages should be skipped:\n%s", content)
+	}
+}
+
+func TestPackageFilesLocatesExistingPackages(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	pkgPath := filepath.Join(tmpDir, "pkga-1.0-1.x86_64.rpm")
+	os.WriteFile(pkgPath, []byte("fake rpm package A"), 0644)
+
+	config := &models.RepositoryConfig{
+		OutputDir:     filepath.Join(tmpDir, "output"),
+		DistroVariant: "fedora",
+	}
+	packages := []models.Package{
+		{Name: "pkga", Version: "1.0", Architecture: "x86_64", Filename: pkgPath, Metadata: map[string]interface{}{"DistroVersion": "39"}},
+	}
+
+	gen := NewGenerator(nil).(*Generator)
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 1 || existing[0].Metadata["DistroVersion"] != "39" {
+		t.Fatalf("Existing package should remember its version directory: %+v", existing)
+	}
+
+	files := gen.PackageFiles(config, existing[0])
+	if _, err := os.Stat(files[0]); err != nil {
+		t.Errorf("PackageFiles returned a missing path %s: %v", files[0], err)
+	}
+}
+
+func TestSignedRepoFileReferencesPublishedKey(t *testing.T) {
+	fixture := filepath.Join("..", "..", "..", "test", "fixtures", "rpms", "repogen-test-1.0.0-1.x86_64.rpm")
+	if _, err := os.Stat(fixture); err != nil {
+		t.Skip("RPM fixture not found")
+	}
+
+	tmpDir := t.TempDir()
+
+	entity, err := openpgp.NewEntity("Repogen Test", "", "test@repogen.local", nil)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	var keyBuf bytes.Buffer
+	w, _ := armor.Encode(&keyBuf, openpgp.PrivateKeyType, nil)
+	entity.SerializePrivate(w, nil)
+	w.Close()
+	keyPath := filepath.Join(tmpDir, "key.asc")
+	os.WriteFile(keyPath, keyBuf.Bytes(), 0600)
+
+	gpgSigner, err := signer.NewGPGSigner(keyPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	pkg, err := ParsePackage(fixture)
+	if err != nil {
+		t.Fatalf("Failed to parse fixture: %v", err)
+	}
+
+	config := &models.RepositoryConfig{
+		OutputDir:     filepath.Join(tmpDir, "output"),
+		Origin:        "Test Repo",
+		Version:       "40",
+		DistroVariant: "fedora",
+		BaseURL:       "https://example.com/repo",
+	}
+	if err := NewGenerator(gpgSigner).Generate(context.Background(), config, []models.Package{*pkg}); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	repoFile, err := os.ReadFile(filepath.Join(config.OutputDir, "fedora.repo"))
+	if err != nil {
+		t.Fatalf("Failed to read .repo file: %v", err)
+	}
+	if !strings.Contains(string(repoFile), "gpgkey=https://example.com/repo/keys/test-repo.asc\n") {
+		t.Errorf(".repo file does not reference the published key:\n%s", repoFile)
+	}
+
+	published, err := os.ReadFile(filepath.Join(config.OutputDir, "keys", "test-repo.asc"))
+	if err != nil {
+		t.Fatalf("Armored key not published: %v", err)
+	}
+	if _, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(published)); err != nil {
+		t.Errorf("Published key does not parse: %v", err)
+	}
+	for _, encoding := range []string{signer.KeyBinary, signer.KeyKeybox} {
+		if _, err := os.Stat(filepath.Join(config.OutputDir, "keys", "test-repo"+encoding)); err != nil {
+			t.Errorf("Missing %s key: %v", encoding, err)
+		}
+	}
+}
+
+func TestLayoutTemplate(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	pkgPath := filepath.Join(tmpDir, "pkga-1.0-1.x86_64.rpm")
+	os.WriteFile(pkgPath, []byte("fake rpm package A"), 0644)
+
+	config := &models.RepositoryConfig{
+		OutputDir:     filepath.Join(tmpDir, "output"),
+		Origin:        "Test Repo",
+		Version:       "9",
+		DistroVariant: "centos",
+		BaseURL:       "https://example.com/repo",
+		RPMLayout:     "{{.Distro}}/el{{.Version}}/{{.Arch}}/stable",
+	}
+	packages := []models.Package{
+		{Name: "pkga", Version: "1.0", Architecture: "x86_64", Filename: pkgPath},
+	}
+
+	gen := NewGenerator(nil).(*Generator)
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	repoPath := filepath.Join(config.OutputDir, "centos", "el9", "x86_64", "stable")
+	for _, rel := range []string{"repodata/repomd.xml", "Packages/pkga-1.0-1.x86_64.rpm"} {
+		if _, err := os.Stat(filepath.Join(repoPath, rel)); err != nil {
+			t.Errorf("%s not laid out: %v", rel, err)
+		}
+	}
+
+	repoFile, err := os.ReadFile(filepath.Join(config.OutputDir, "centos.repo"))
+	if err != nil {
+		t.Fatalf("Failed to read .repo file: %v", err)
+	}
+	if !strings.Contains(string(repoFile), "baseurl=https://example.com/repo/centos/el$releasever/$basearch/stable\n") {
+		t.Errorf(".repo baseurl does not follow the layout:\n%s", repoFile)
+	}
+
+	// Without --version, existing packages are found again with their release version
+	config.Version = ""
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 1 || existing[0].Metadata["DistroVersion"] != "9" || existing[0].Metadata["RepoDir"] != "centos/el9/x86_64/stable" {
+		t.Fatalf("Existing package lost its place in the layout: %+v", existing)
+	}
+	files := gen.PackageFiles(config, existing[0])
+	if _, err := os.Stat(files[0]); err != nil {
+		t.Errorf("PackageFiles returned a missing path %s: %v", files[0], err)
+	}
+}
+
+func TestFlatLayout(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	pkgPath := filepath.Join(tmpDir, "pkga-1.0-1.x86_64.rpm")
+	os.WriteFile(pkgPath, []byte("fake rpm package A"), 0644)
+
+	config := &models.RepositoryConfig{
+		OutputDir:     filepath.Join(tmpDir, "output"),
+		Origin:        "Test Repo",
+		DistroVariant: "fedora",
+		BaseURL:       "https://example.com/repo",
+		RPMLayout:     ".",
+	}
+	packages := []models.Package{
+		{Name: "pkga", Version: "1.0", Architecture: "x86_64", Filename: pkgPath},
+	}
+
+	gen := NewGenerator(nil).(*Generator)
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(config.OutputDir, "repodata", "repomd.xml")); err != nil {
+		t.Errorf("Flat repository metadata not at the root: %v", err)
+	}
+
+	repoFile, _ := os.ReadFile(filepath.Join(config.OutputDir, "fedora.repo"))
+	if !strings.Contains(string(repoFile), "baseurl=https://example.com/repo/\n") {
+		t.Errorf(".repo baseurl does not point at the root:\n%s", repoFile)
+	}
+
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 1 || existing[0].Metadata["RepoDir"] != "." {
+		t.Fatalf("Flat repository not found again: %+v", existing)
+	}
+}
+
+func TestValidateLayout(t *testing.T) {
+	for _, layout := range []string{"", ".", DefaultLayout, "{{.Distro}}/{{.Version}}/{{.Arch}}"} {
+		if err := ValidateLayout(layout); err != nil {
+			t.Errorf("ValidateLayout(%q): %v", layout, err)
+		}
+	}
+	for _, layout := range []string{"{{.Release}}/{{.Arch}}", "{{.Version", "../{{.Arch}}"} {
+		if err := ValidateLayout(layout); err == nil {
+			t.Errorf("ValidateLayout(%q) accepted an invalid layout", layout)
+		}
+	}
+}
+
+func TestPrimaryXMLListsDependencies(t *testing.T) {
+	fixture := filepath.Join("..", "..", "..", "test", "fixtures", "rpms", "repogen-test-1.0.0-1.x86_64.rpm")
+	if _, err := os.Stat(fixture); err != nil {
+		t.Skip("RPM fixture not found")
+	}
+
+	pkg, err := ParsePackage(fixture)
+	if err != nil {
+		t.Fatalf("Failed to parse fixture: %v", err)
+	}
+	output, err := generatePrimaryXML(primaryPackages([]models.Package{*pkg}, time.Now()))
+	if err != nil {
+		t.Fatalf("Failed to generate primary.xml: %v", err)
+	}
+
+	for _, expected := range []string{
+		`<rpm:provides>`,
+		`<rpm:entry name="repogen-test" flags="EQ" epoch="0" ver="1.0.0" rel="1"></rpm:entry>`,
+		`<rpm:entry name="repogen-test(x86-64)" flags="EQ" epoch="0" ver="1.0.0" rel="1"></rpm:entry>`,
+		`<rpm:requires>`,
+		`<rpm:entry name="/usr/bin/sh"></rpm:entry>`,
+	} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("primary.xml missing %q:\n%s", expected, output)
+		}
+	}
+	// rpmlib() requirements are for rpm itself
+	if strings.Contains(string(output), "rpmlib(") {
+		t.Errorf("primary.xml lists rpmlib() requirements:\n%s", output)
+	}
+}
+
+func TestDependencyFlags(t *testing.T) {
+	for flags, expected := range map[int]string{0: "", senseLess: "LT", senseGreater | senseEqual: "GE", senseEqual | sensePrereq: "EQ"} {
+		if got := comparison(flags); got != expected {
+			t.Errorf("comparison(%d) = %q, want %q", flags, got, expected)
+		}
+	}
+	for evr, expected := range map[string][3]string{
+		"1.0":        {"0", "1.0", ""},
+		"2:1.0-3":    {"2", "1.0", "3"},
+		"1.0-1.fc40": {"0", "1.0", "1.fc40"},
+	} {
+		epoch, version, release := splitEVR(evr)
+		if [3]string{epoch, version, release} != expected {
+			t.Errorf("splitEVR(%q) = %s %s %s", evr, epoch, version, release)
+		}
+	}
+}
+
+func TestRPMSQLiteGeneratesDatabases(t *testing.T) {
+	fixture := filepath.Join("..", "..", "..", "test", "fixtures", "rpms", "repogen-test-1.0.0-1.x86_64.rpm")
+	if _, err := os.Stat(fixture); err != nil {
+		t.Skip("RPM fixture not found")
+	}
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not available")
+	}
+
+	pkg, err := ParsePackage(fixture)
+	if err != nil {
+		t.Fatalf("Failed to parse fixture: %v", err)
+	}
+	config := &models.RepositoryConfig{
+		OutputDir:     filepath.Join(t.TempDir(), "output"),
+		Version:       "40",
+		DistroVariant: "fedora",
+		RPMSQLite:     true,
+	}
+	if err := NewGenerator(nil).Generate(context.Background(), config, []models.Package{*pkg}); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	repoPath := filepath.Join(config.OutputDir, "40", "x86_64")
+	repomdXML, err := os.ReadFile(filepath.Join(repoPath, "repodata", "repomd.xml"))
+	if err != nil {
+		t.Fatalf("Failed to read repomd.xml: %v", err)
+	}
+	var md repomd
+	if err := xml.Unmarshal(repomdXML, &md); err != nil {
+		t.Fatalf("Failed to parse repomd.xml: %v", err)
+	}
+
+	entries := make(map[string]repomdData)
+	for _, data := range md.Data {
+		entries[data.Type] = data
+	}
+	primaryChecksum := entries["primary"].Checksum.Value
+	for dbType, queries := range map[string]map[string]string{
+		"primary_db": {
+			"SELECT dbversion, checksum FROM db_info":                   "10|" + primaryChecksum,
+			"SELECT pkgKey, name, arch, version, release FROM packages": "1|repogen-test|x86_64|1.0.0|1",
+			"SELECT name FROM requires WHERE pkgKey = 1":                "/usr/bin/sh",
+			"SELECT count(*) FROM provides WHERE flags = 'EQ'":          "2",
+		},
+		"filelists_db": {
+			"SELECT dbversion, checksum FROM db_info":                    "10|" + primaryChecksum,
+			"SELECT pkgKey, pkgId FROM packages":                         "1|" + pkg.SHA256Sum,
+			"SELECT pkgKey, dirname, filenames, filetypes FROM filelist": "1|/usr/bin|repogen-test|f",
+		},
+		"other_db": {
+			"SELECT dbversion, checksum FROM db_info":               "10|" + primaryChecksum,
+			"SELECT pkgKey, pkgId FROM packages":                    "1|" + pkg.SHA256Sum,
+			"SELECT pkgKey, author, date, changelog FROM changelog": "1|Repogen <test@example.com> - 1.0.0-1|1765368000|- Initial release",
+		},
+	} {
+		entry, ok := entries[dbType]
+		if !ok || entry.DatabaseVersion != 10 || entry.OpenChecksum == nil {
+			t.Fatalf("repomd.xml does not describe %s: %+v", dbType, md.Data)
+		}
+
+		compressed, err := os.ReadFile(filepath.Join(repoPath, entry.Location.Href))
+		if err != nil {
+			t.Fatalf("repomd.xml references missing file %s: %v", entry.Location.Href, err)
+		}
+		db, err := io.ReadAll(bzip2.NewReader(bytes.NewReader(compressed)))
+		if err != nil {
+			t.Fatalf("Failed to decompress %s: %v", entry.Location.Href, err)
+		}
+		if checksum, _ := utils.CalculateChecksum(db, "sha256"); checksum != entry.OpenChecksum.Value {
+			t.Errorf("open-checksum mismatch for %s", dbType)
+		}
+
+		dbPath := filepath.Join(t.TempDir(), dbType+".sqlite")
+		if err := os.WriteFile(dbPath, db, 0644); err != nil {
+			t.Fatal(err)
+		}
+		queries["PRAGMA integrity_check"] = "ok"
+		for query, expected := range queries {
+			out, err := exec.Command("sqlite3", dbPath, query).CombinedOutput()
+			if err != nil {
+				t.Fatalf("sqlite3 %q failed: %v\n%s", query, err, out)
+			}
+			if got := strings.TrimSpace(string(out)); got != expected {
+				t.Errorf("%s: %s = %q, want %q", dbType, query, got, expected)
+			}
+		}
+	}
+}
+
+func TestRPMZchunkMetadata(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	groupsPath := filepath.Join(tmpDir, "groups.yaml")
+	os.WriteFile(groupsPath, []byte("groups:\n  - id: tools\n    packages:\n      mandatory: [pkga]\n"), 0644)
+
+	var packages []models.Package
+	for _, name := range []string{"pkga", "pkgb"} {
+		pkgPath := filepath.Join(tmpDir, name+"-1.0-1.x86_64.rpm")
+		os.WriteFile(pkgPath, []byte("fake rpm package "+name), 0644)
+		packages = append(packages, models.Package{Name: name, Version: "1.0", Architecture: "x86_64", Filename: pkgPath})
+	}
+
+	config := &models.RepositoryConfig{
+		OutputDir:     filepath.Join(tmpDir, "output"),
+		Version:       "40",
+		DistroVariant: "fedora",
+		RPMGroupsPath: groupsPath,
+		RPMZchunk:     true,
+	}
+	if err := NewGenerator(nil).Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	repoPath := filepath.Join(config.OutputDir, "40", "x86_64")
+	repomdXML, err := os.ReadFile(filepath.Join(repoPath, "repodata", "repomd.xml"))
+	if err != nil {
+		t.Fatalf("Failed to read repomd.xml: %v", err)
+	}
+	var md repomd
+	if err := xml.Unmarshal(repomdXML, &md); err != nil {
+		t.Fatalf("Failed to parse repomd.xml: %v", err)
+	}
+
+	found := make(map[string]bool)
+	for _, data := range md.Data {
+		if !strings.HasSuffix(data.Type, "_zck") {
+			continue
+		}
+		found[data.Type] = true
+
+		zck, err := os.ReadFile(filepath.Join(repoPath, data.Location.Href))
+		if err != nil {
+			t.Fatalf("repomd.xml references missing file %s: %v", data.Location.Href, err)
+		}
+		if !bytes.HasPrefix(zck, []byte("\x00ZCK1")) || data.HeaderChecksum == nil || data.HeaderSize == 0 || data.OpenChecksum == nil {
+			t.Fatalf("%s is not described as a zchunk file: %+v", data.Type, data)
+		}
+		if checksum, _ := utils.CalculateChecksum(zck[:data.HeaderSize], "sha256"); checksum != data.HeaderChecksum.Value {
+			t.Errorf("header-checksum mismatch for %s", data.Type)
+		}
+	}
+	if !found["primary_zck"] || !found["group_zck"] {
+		t.Errorf("repomd.xml lacks zchunk entries: %v", found)
+	}
+}
+
+func TestXMLChunks(t *testing.T) {
+	output, err := generatePrimaryXML(primaryPackages([]models.Package{
+		{Name: "pkga", Version: "1.0", Architecture: "x86_64"},
+		{Name: "pkgb", Version: "1.0", Architecture: "x86_64"},
+	}, time.Now()))
+	if err != nil {
+		t.Fatalf("Failed to generate primary.xml: %v", err)
+	}
+
+	chunks := xmlChunks(output)
+	if !bytes.Equal(bytes.Join(chunks, nil), output) {
+		t.Fatal("Chunks don't add up to the document")
+	}
+	// The document start, each package, and the end of the root element
+	if len(chunks) != 3 || !bytes.Contains(chunks[1], []byte("<name>pkga</name>")) || !bytes.HasSuffix(chunks[2], []byte("</metadata>")) {
+		t.Errorf("Unexpected chunks %q", chunks)
+	}
+}
+
+func TestSUSERepository(t *testing.T) {
+	fixture := filepath.Join("..", "..", "..", "test", "fixtures", "rpms", "repogen-test-1.0.0-1.x86_64.rpm")
+	if _, err := os.Stat(fixture); err != nil {
+		t.Skip("RPM fixture not found")
+	}
+
+	tmpDir := t.TempDir()
+
+	entity, err := openpgp.NewEntity("Repogen Test", "", "test@repogen.local", nil)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	var keyBuf bytes.Buffer
+	w, _ := armor.Encode(&keyBuf, openpgp.PrivateKeyType, nil)
+	entity.SerializePrivate(w, nil)
+	w.Close()
+	keyPath := filepath.Join(tmpDir, "key.asc")
+	os.WriteFile(keyPath, keyBuf.Bytes(), 0600)
+
+	gpgSigner, err := signer.NewGPGSigner(keyPath, "")
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	pkg, err := ParsePackage(fixture)
+	if err != nil {
+		t.Fatalf("Failed to parse fixture: %v", err)
+	}
+	pkg.Metadata["DistroVersion"] = ""
+
+	config := &models.RepositoryConfig{
+		OutputDir:     filepath.Join(tmpDir, "output"),
+		Origin:        "Test Repo",
+		DistroVariant: "suse",
+		BaseURL:       "https://example.com/repo",
+	}
+	if err := NewGenerator(gpgSigner).Generate(context.Background(), config, []models.Package{*pkg}); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	// Without a version, packages land in the openSUSE Leap repository
+	repodataDir := filepath.Join(config.OutputDir, "15.6", "x86_64", "repodata")
+	key, err := os.ReadFile(filepath.Join(repodataDir, "repomd.xml.key"))
+	if err != nil {
+		t.Fatalf("repomd.xml.key not published: %v", err)
+	}
+	if _, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key)); err != nil {
+		t.Errorf("repomd.xml.key does not parse: %v", err)
+	}
+
+	repoFile, err := os.ReadFile(filepath.Join(config.OutputDir, "suse.repo"))
+	if err != nil {
+		t.Fatalf("Failed to read .repo file: %v", err)
+	}
+	for _, expected := range []string{
+		"baseurl=https://example.com/repo/$releasever/$basearch\n",
+		"autorefresh=1\n",
+		"type=rpm-md\n",
+		"repo_gpgcheck=1\n",
+	} {
+		if !strings.Contains(string(repoFile), expected) {
+			t.Errorf(".repo file missing %q:\n%s", expected, repoFile)
+		}
+	}
+}
+
+func TestParseVersionFromSUSEDistro(t *testing.T) {
+	for distro, expected := range map[string]string{
+		"openSUSE Leap 15.6": "15.6",
+		"obs://build.opensuse.org/openSUSE:Leap:15.5/standard/abc-pkg": "15.5",
+		"openSUSE Tumbleweed": "tumbleweed",
+		"fc40":                "40",
+	} {
+		if got := parseVersionFromDistro(distro); got != expected {
+			t.Errorf("parseVersionFromDistro(%q) = %q, want %q", distro, got, expected)
+		}
+	}
+}
+
+func TestSourcePackagesGetTheirOwnRepository(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	var packages []models.Package
+	for _, arch := range []string{"x86_64", "src"} {
+		path, err := packager.Build("rpm", packager.Package{Name: "hello", Version: "1.0", Arch: arch, Summary: "Hello", Files: []packager.File{{Path: "usr/bin/hello", Mode: 0755, Data: []byte("hello")}}}, tmpDir)
+		if err != nil {
+			t.Fatalf("Failed to build %s package: %v", arch, err)
+		}
+		pkg, err := ParsePackage(path)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", path, err)
+		}
+		packages = append(packages, *pkg)
+	}
+	if packages[1].Architecture != "src" {
+		t.Fatalf("Source package has architecture %q", packages[1].Architecture)
+	}
+
+	for layout, dirs := range map[string][2]string{
+		"":  {"40/x86_64", "40/SRPMS"},
+		".": {".", "SRPMS"},
+	} {
+		config := &models.RepositoryConfig{
+			OutputDir:     filepath.Join(tmpDir, "output"+layout),
+			Origin:        "Test Repo",
+			Version:       "40",
+			DistroVariant: "fedora",
+			BaseURL:       "https://example.com/repo",
+			RPMLayout:     layout,
+		}
+		if err := NewGenerator(nil).Generate(context.Background(), config, append([]models.Package(nil), packages...)); err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+
+		for i, dir := range dirs {
+			arches := primaryArches(t, filepath.Join(config.OutputDir, dir))
+			if len(arches) != 1 || arches[0] != packages[i].Architecture {
+				t.Errorf("Layout %q: %s lists %v, want only the %s package", layout, dir, arches, packages[i].Architecture)
+			}
+		}
+
+		existing, err := NewGenerator(nil).ParseExistingMetadata(config)
+		if err != nil || len(existing) != 2 {
+			t.Errorf("Layout %q: existing metadata has %d packages: %v", layout, len(existing), err)
+		}
+	}
+
+	repoFile, err := os.ReadFile(filepath.Join(tmpDir, "output", "fedora.repo"))
+	if err != nil {
+		t.Fatalf("Failed to read .repo file: %v", err)
+	}
+	if !strings.Contains(string(repoFile), "\n\n[test-repo-source]\nname=Test Repo - Source\nbaseurl=https://example.com/repo/$releasever/SRPMS\nenabled=0\n") {
+		t.Errorf(".repo file lacks the source repository:\n%s", repoFile)
+	}
+}
+
+// primaryArches returns the architectures of the packages listed in the
+// primary.xml of the repository at repoPath
+func primaryArches(t *testing.T, repoPath string) []string {
+	t.Helper()
+	packages, err := parsePrimaryXML(repoPath)
+	if err != nil {
+		t.Fatalf("Failed to read metadata of %s: %v", repoPath, err)
+	}
+	var arches []string
+	for _, pkg := range packages {
+		arches = append(arches, pkg.Architecture)
+	}
+	return arches
+}
+
+func TestEpochIsPublishedAndIdentifiesPackages(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &models.RepositoryConfig{
+		OutputDir: filepath.Join(tmpDir, "output"),
+		Origin:    "Test Repo",
+		Version:   "40",
+	}
+
+	pkgPath := filepath.Join(tmpDir, "hello-1.0-1.x86_64.rpm")
+	if err := os.WriteFile(pkgPath, []byte("rpm"), 0644); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	pkg := models.Package{
+		Name:         "hello",
+		Version:      "1.0",
+		Architecture: "x86_64",
+		Filename:     pkgPath,
+		Metadata:     map[string]interface{}{"Release": "1", "Epoch": "2"},
+	}
+	if err := NewGenerator(nil).Generate(context.Background(), config, []models.Package{pkg}); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	existing, err := NewGenerator(nil).ParseExistingMetadata(config)
+	if err != nil || len(existing) != 1 {
+		t.Fatalf("Failed to read existing metadata (%d packages): %v", len(existing), err)
+	}
+	if epoch := existing[0].Metadata["Epoch"]; epoch != "2" {
+		t.Errorf("Existing package has epoch %v, want 2", epoch)
+	}
+
+	// The same version and release under another epoch is another package
+	other := pkg
+	other.Metadata = map[string]interface{}{"Release": "1", "Epoch": "1"}
+	if conflicts := utils.DetectConflicts(existing, []models.Package{other}, scanner.TypeRpm); len(conflicts) != 0 {
+		t.Errorf("Package with another epoch conflicts: %v", conflicts)
+	}
+	if conflicts := utils.DetectConflicts(existing, []models.Package{pkg}, scanner.TypeRpm); len(conflicts) != 1 {
+		t.Errorf("Republished package doesn't conflict")
+	}
+
+	fixture := filepath.Join("..", "..", "..", "test", "fixtures", "rpms", "repogen-test-1.0.0-1.x86_64.rpm")
+	if _, err := os.Stat(fixture); err == nil {
+		parsed, err := ParsePackage(fixture)
+		if err != nil {
+			t.Fatalf("Failed to parse fixture: %v", err)
+		}
+		if epoch := parsed.Metadata["Epoch"]; epoch != "0" {
+			t.Errorf("Package without an epoch has epoch %v", epoch)
+		}
+	}
+}
+
+func TestNoarchPackagesReachEveryArchitecture(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	build := func(name, arch string) models.Package {
+		path, err := packager.Build("rpm", packager.Package{Name: name, Version: "1.0", Arch: arch, Summary: name, Files: []packager.File{{Path: "usr/share/" + name, Mode: 0644, Data: []byte(name)}}}, tmpDir)
+		if err != nil {
+			t.Fatalf("Failed to build %s: %v", name, err)
+		}
+		pkg, err := ParsePackage(path)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", path, err)
+		}
+		return *pkg
+	}
+	amd, arm, doc := build("tool", "x86_64"), build("tool-arm", "aarch64"), build("tool-doc", "noarch")
+
+	for _, tt := range []struct {
+		name     string
+		mode     string
+		packages []models.Package
+		repos    map[string][]string
+		repoFile string
+	}{
+		{"merged", NoarchMerge, []models.Package{amd, arm, doc}, map[string][]string{
+			"40/x86_64":  {"tool", "tool-doc"},
+			"40/aarch64": {"tool-arm", "tool-doc"},
+		}, ""},
+		{"merged into --arch", "", []models.Package{doc}, map[string][]string{
+			"40/x86_64": {"tool-doc"},
+		}, ""},
+		{"own repository", NoarchRepo, []models.Package{amd, doc}, map[string][]string{
+			"40/x86_64": {"tool"},
+			"40/noarch": {"tool-doc"},
+		}, "\n\n[test-repo-noarch]\nname=Test Repo - noarch\nbaseurl=https://example.com/repo/$releasever/noarch\nenabled=1\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &models.RepositoryConfig{
+				OutputDir:     filepath.Join(tmpDir, tt.name),
+				Origin:        "Test Repo",
+				Version:       "40",
+				DistroVariant: "fedora",
+				Arches:        []string{"amd64"},
+				BaseURL:       "https://example.com/repo",
+				RPMNoarch:     tt.mode,
+			}
+			if err := NewGenerator(nil).Generate(context.Background(), config, append([]models.Package(nil), tt.packages...)); err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+
+			for dir, expected := range tt.repos {
+				packages, err := parsePrimaryXML(filepath.Join(config.OutputDir, dir))
+				if err != nil {
+					t.Fatalf("Failed to read metadata of %s: %v", dir, err)
+				}
+				var names []string
+				for _, pkg := range packages {
+					names = append(names, pkg.Name)
+				}
+				sort.Strings(names)
+				if strings.Join(names, ",") != strings.Join(expected, ",") {
+					t.Errorf("%s lists %v, want %v", dir, names, expected)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(config.OutputDir, "40", "noarch")); tt.mode != NoarchRepo && err == nil {
+				t.Error("noarch repository published when merging")
+			}
+
+			repoFile, err := os.ReadFile(filepath.Join(config.OutputDir, "fedora.repo"))
+			if err != nil {
+				t.Fatalf("Failed to read .repo file: %v", err)
+			}
+			if hasNoarch := strings.Contains(string(repoFile), "-noarch]"); hasNoarch != (tt.repoFile != "") || !strings.Contains(string(repoFile), tt.repoFile) {
+				t.Errorf(".repo file doesn't list the noarch repository as expected:\n%s", repoFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package rpm
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+// DefaultLayout places each repository under <version>/<arch>
+const DefaultLayout = "{{.Version}}/{{.Arch}}"
+
+// Source packages have the src architecture, and are published in their
+// own repository, rendered with SRPMS as the architecture
+const (
+	sourceArch = "src"
+	sourceDir  = "SRPMS"
+)
+
+// layoutVars are the fields a layout template can reference
+type layoutVars struct {
+	Distro  string // Distribution variant (fedora, centos, rhel, suse)
+	Version string // Release version
+	Arch    string // Package architecture
+}
+
+// Sentinels rendered in place of the version and architecture when a
+// layout is matched against existing directories
+const (
+	versionSentinel = "@@VERSION@@"
+	archSentinel    = "@@ARCH@@"
+)
+
+// ValidateLayout checks that layout is a template rendering to a relative
+// path inside the output directory
+func ValidateLayout(layout string) error {
+	config := &models.RepositoryConfig{RPMLayout: layout, DistroVariant: "fedora"}
+	_, err := renderLayout(config, layoutVars{Distro: "fedora", Version: "40", Arch: "x86_64"})
+	return err
+}
+
+// renderLayout renders the layout of config as a clean slash-separated
+// path, "." being the output directory itself
+func renderLayout(config *models.RepositoryConfig, vars layoutVars) (string, error) {
+	layout := config.RPMLayout
+	if layout == "" {
+		layout = DefaultLayout
+	}
+
+	tmpl, err := template.New("layout").Parse(layout)
+	if err != nil {
+		return "", fmt.Errorf("invalid layout %q: %w", layout, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("invalid layout %q: %w", layout, err)
+	}
+
+	dir := path.Clean(strings.Trim(b.String(), "/"))
+	if dir == ".." || strings.HasPrefix(dir, "../") {
+		return "", fmt.Errorf("layout %q escapes the output directory", layout)
+	}
+	return dir, nil
+}
+
+// repoDir returns the directory, relative to the output directory, of the
+// repository holding pkg. Existing packages stay where they were found
+func repoDir(config *models.RepositoryConfig, pkg models.Package) (string, error) {
+	if dir, ok := pkg.Metadata["RepoDir"].(string); ok && dir != "" {
+		return dir, nil
+	}
+
+	arch := pkg.Architecture
+	if arch == "" {
+		arch = "x86_64" // default architecture
+	}
+	if arch == sourceArch {
+		return sourceRepoDir(config, getPackageVersion(config, pkg))
+	}
+	return renderLayout(config, layoutVars{
+		Distro:  distroVariant(config),
+		Version: getPackageVersion(config, pkg),
+		Arch:    arch,
+	})
+}
+
+// sourceRepoDir returns the directory of the source packages of version.
+// When the layout has no architecture, they go to an SRPMS directory below
+// it rather than next to the binary packages
+func sourceRepoDir(config *models.RepositoryConfig, version string) (string, error) {
+	dir, err := renderLayout(config, layoutVars{
+		Distro:  distroVariant(config),
+		Version: version,
+		Arch:    sourceDir,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	pattern, err := renderLayout(config, layoutVars{
+		Distro:  distroVariant(config),
+		Version: version,
+		Arch:    archSentinel,
+	})
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(pattern, archSentinel) {
+		dir = path.Join(dir, sourceDir)
+	}
+	return dir, nil
+}
+
+// hasSourcePackages reports whether packages include source packages
+func hasSourcePackages(packages []models.Package) bool {
+	for _, pkg := range packages {
+		if pkg.Architecture == sourceArch {
+			return true
+		}
+	}
+	return false
+}
+
+// repoBaseURL returns the baseurl of the .repo file, letting dnf substitute
+// $releasever and $basearch into the layout
+func repoBaseURL(config *models.RepositoryConfig) (string, error) {
+	dir, err := renderLayout(config, layoutVars{
+		Distro:  distroVariant(config),
+		Version: "$releasever",
+		Arch:    "$basearch",
+	})
+	if err != nil {
+		return "", err
+	}
+	return joinBaseURL(config, dir), nil
+}
+
+// sourceBaseURL returns the baseurl of the source repository of the .repo file
+func sourceBaseURL(config *models.RepositoryConfig) (string, error) {
+	dir, err := sourceRepoDir(config, "$releasever")
+	if err != nil {
+		return "", err
+	}
+	return joinBaseURL(config, dir), nil
+}
+
+// joinBaseURL returns the URL of dir, relative to the output directory
+func joinBaseURL(config *models.RepositoryConfig, dir string) string {
+	baseURL := config.BaseURL
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	if dir == "." {
+		return baseURL
+	}
+	return baseURL + dir
+}
+
+// layoutVersion returns the release version a repository directory was
+// rendered with, if dir matches the layout and the layout has a version
+func layoutVersion(config *models.RepositoryConfig, dir string) (string, bool) {
+	pattern, err := renderLayout(config, layoutVars{
+		Distro:  distroVariant(config),
+		Version: versionSentinel,
+		Arch:    archSentinel,
+	})
+	if err != nil || !strings.Contains(pattern, versionSentinel) {
+		return "", false
+	}
+
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, versionSentinel, `(?P<version>[^/]+)`, 1)
+	expr = strings.ReplaceAll(expr, versionSentinel, `[^/]+`)
+	expr = strings.ReplaceAll(expr, archSentinel, `[^/]+`)
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return "", false
+	}
+
+	m := re.FindStringSubmatch(dir)
+	if m == nil {
+		return "", false
+	}
+	return m[re.SubexpIndex("version")], true
+}
+
+// distroVariant returns the distribution variant of config, fedora by default
+func distroVariant(config *models.RepositoryConfig) string {
+	if config.DistroVariant == "" {
+		return "fedora"
+	}
+	return config.DistroVariant
+}
@@ -0,0 +1,159 @@
+package rpm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+// noarchArch is the architecture of packages installing everywhere
+const noarchArch = "noarch"
+
+// noarch modes: where architecture independent packages are published
+const (
+	NoarchMerge = "merge" // Listed in the repository of every architecture
+	NoarchRepo  = "repo"  // In their own noarch repository, listed in the .repo file
+)
+
+// archNames maps --arch values to RPM architectures
+var archNames = map[string]string{
+	"amd64":   "x86_64",
+	"arm64":   "aarch64",
+	"i386":    "i686",
+	"386":     "i686",
+	"armhf":   "armv7hl",
+	"ppc64el": "ppc64le",
+}
+
+// ValidateNoarchMode checks that mode is a known noarch mode
+func ValidateNoarchMode(mode string) error {
+	switch mode {
+	case "", NoarchMerge, NoarchRepo:
+		return nil
+	}
+	return fmt.Errorf("unknown noarch mode %q (expected %s or %s)", mode, NoarchMerge, NoarchRepo)
+}
+
+// mergesNoarch reports whether noarch packages are listed in the repository
+// of every architecture, the default
+func mergesNoarch(config *models.RepositoryConfig) bool {
+	return config.RPMNoarch != NoarchRepo
+}
+
+// versionArches returns the architectures published for each release
+// version: those of its binary packages, else the --arch ones
+func versionArches(config *models.RepositoryConfig, packages []models.Package) map[string][]string {
+	seen := make(map[string]map[string]bool)
+	for _, pkg := range packages {
+		arch := pkg.Architecture
+		if arch == "" {
+			arch = "x86_64" // default architecture
+		}
+		if arch == noarchArch || arch == sourceArch {
+			continue
+		}
+		version := getPackageVersion(config, pkg)
+		if seen[version] == nil {
+			seen[version] = make(map[string]bool)
+		}
+		seen[version][arch] = true
+	}
+
+	arches := make(map[string][]string)
+	for version, set := range seen {
+		for arch := range set {
+			arches[version] = append(arches[version], arch)
+		}
+		sort.Strings(arches[version])
+	}
+	return arches
+}
+
+// defaultArches returns the RPM names of the --arch architectures
+func defaultArches(config *models.RepositoryConfig) []string {
+	var arches []string
+	for _, arch := range config.Arches {
+		if name, ok := archNames[arch]; ok {
+			arch = name
+		}
+		arches = append(arches, arch)
+	}
+	if len(arches) == 0 {
+		arches = []string{"x86_64"}
+	}
+	return arches
+}
+
+// repoDirs returns the directories of the repositories listing pkg. A new
+// noarch package is listed in the repository of each architecture of its
+// release version, as dnf only reads the one of $basearch
+func repoDirs(config *models.RepositoryConfig, pkg models.Package, arches map[string][]string) ([]string, error) {
+	if existing, _ := pkg.Metadata["RepoDir"].(string); existing != "" || pkg.Architecture != noarchArch || !mergesNoarch(config) {
+		dir, err := repoDir(config, pkg)
+		if err != nil {
+			return nil, err
+		}
+		return []string{dir}, nil
+	}
+
+	version := getPackageVersion(config, pkg)
+	versionArches, ok := arches[version]
+	if !ok {
+		versionArches = defaultArches(config)
+	}
+
+	// Layouts without the architecture render the same directory for all
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, arch := range versionArches {
+		dir, err := renderLayout(config, layoutVars{
+			Distro:  distroVariant(config),
+			Version: version,
+			Arch:    arch,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, nil
+}
+
+// noarchBaseURL returns the baseurl of the noarch repository of the .repo
+// file, empty when noarch packages share the repository of the others
+func noarchBaseURL(config *models.RepositoryConfig, packages []models.Package) (string, error) {
+	if mergesNoarch(config) {
+		return "", nil
+	}
+	found := false
+	for _, pkg := range packages {
+		if pkg.Architecture == noarchArch {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return "", nil
+	}
+
+	dir, err := renderLayout(config, layoutVars{
+		Distro:  distroVariant(config),
+		Version: "$releasever",
+		Arch:    noarchArch,
+	})
+	if err != nil {
+		return "", err
+	}
+	baseURL, err := repoBaseURL(config)
+	if err != nil {
+		return "", err
+	}
+	if url := joinBaseURL(config, dir); url != baseURL {
+		return url, nil
+	}
+	return "", nil
+}
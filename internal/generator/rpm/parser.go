@@ -5,12 +5,15 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/snapshot"
 	"github.com/ralt/repogen/internal/utils"
 	"github.com/sassoftware/go-rpmutils"
 )
@@ -46,9 +49,16 @@ func ParsePackage(path string) (*models.Package, error) {
 		Homepage:     getStringTag(rpm, rpmutils.URL),
 		License:      getStringTag(rpm, rpmutils.LICENSE),
 		Dependencies: getStringSliceTag(rpm, rpmutils.REQUIRENAME),
+		Provides:     getStringSliceTag(rpm, rpmutils.PROVIDENAME),
 		Metadata:     make(map[string]interface{}),
 	}
 
+	// Source packages have no source package of their own, and whatever
+	// architecture they were built on: createrepo lists them as src
+	if !rpm.Header.HasTag(rpmutils.SOURCERPM) {
+		pkg.Architecture = sourceArch
+	}
+
 	// Set file information (keep full path for copying)
 	pkg.Filename = path
 	pkg.Size = checksums.Size
@@ -59,9 +69,11 @@ func ParsePackage(path string) (*models.Package, error) {
 
 	// Add additional metadata
 	pkg.Metadata["Release"] = getStringTag(rpm, rpmutils.RELEASE)
+	pkg.Metadata["Epoch"] = getEpoch(rpm)
 	pkg.Metadata["Group"] = getStringTag(rpm, rpmutils.GROUP)
 	pkg.Metadata["BuildTime"] = getIntTag(rpm, rpmutils.BUILDTIME)
 	pkg.Metadata["DistroVersion"] = getDistroVersion(rpm)
+	pkg.Metadata["Dependencies"] = readDependencies(rpm)
 
 	return pkg, nil
 }
@@ -106,6 +118,15 @@ func getIntTag(rpm *rpmutils.Rpm, tag int) int64 {
 	return 0
 }
 
+// getEpoch returns the epoch of a package, "0" when it has none
+func getEpoch(rpm *rpmutils.Rpm) string {
+	epochs, err := rpm.Header.GetUint32s(rpmutils.EPOCH)
+	if err != nil || len(epochs) == 0 {
+		return "0"
+	}
+	return strconv.FormatUint(uint64(epochs[0]), 10)
+}
+
 // getStringSliceTag safely gets a string slice tag from RPM
 func getStringSliceTag(rpm *rpmutils.Rpm, tag int) []string {
 	val, err := rpm.Header.Get(tag)
@@ -159,6 +180,15 @@ func getDistroVersion(rpm *rpmutils.Rpm) string {
 // parseVersionFromDistro parses version from distribution strings
 // Handles patterns: fc40 -> 40, el8 -> 8, .el9 -> 9, etc.
 func parseVersionFromDistro(distro string) string {
+	// openSUSE: "openSUSE Leap 15.6" or obs://.../openSUSE:Leap:15.6/...
+	// -> 15.6, and Tumbleweed, which is versioned by snapshot
+	if matches := regexp.MustCompile(`Leap[ :](\d+\.\d+)`).FindStringSubmatch(distro); len(matches) > 1 {
+		return matches[1]
+	}
+	if strings.Contains(strings.ToLower(distro), "tumbleweed") {
+		return "tumbleweed"
+	}
+
 	// Common patterns for Fedora, RHEL, CentOS
 	patterns := []string{
 		`fc(\d+)`,     // Fedora: fc40, fc39
@@ -189,38 +219,52 @@ func parseVersionFromDistro(distro string) string {
 func (g *Generator) ParseExistingMetadata(config *models.RepositoryConfig) ([]models.Package, error) {
 	var allPackages []models.Package
 
-	// RPM repos are organized by version/arch
-	// We need to scan all version/arch combinations
-	entries, err := os.ReadDir(config.OutputDir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read output directory: %w", err)
-	}
-
-	for _, versionEntry := range entries {
-		if !versionEntry.IsDir() {
-			continue
+	// RPM repos are laid out by the layout template, so look for every
+	// repository below the output directory
+	err := filepath.WalkDir(config.OutputDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == config.OutputDir {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		switch d.Name() {
+		case "repodata", "Packages", "keys", ".repogen":
+			return filepath.SkipDir
+		}
+		// Snapshots are repositories of their own
+		if path == filepath.Join(config.OutputDir, snapshot.Dir) {
+			return filepath.SkipDir
 		}
 
-		versionDir := filepath.Join(config.OutputDir, versionEntry.Name())
-		archEntries, err := os.ReadDir(versionDir)
+		packages, err := parsePrimaryXML(path)
 		if err != nil {
-			continue
+			// No metadata in this directory, skip
+			return nil
 		}
 
-		for _, archEntry := range archEntries {
-			if !archEntry.IsDir() {
-				continue
-			}
+		rel, err := filepath.Rel(config.OutputDir, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
 
-			archDir := filepath.Join(versionDir, archEntry.Name())
-			packages, err := parsePrimaryXML(archDir)
-			if err != nil {
-				// No metadata in this arch dir, skip
-				continue
+		// Remember where the packages live so regeneration keeps them there
+		for i := range packages {
+			packages[i].Metadata["RepoDir"] = rel
+			if version, ok := layoutVersion(config, rel); ok {
+				packages[i].Metadata["DistroVersion"] = version
 			}
-
-			allPackages = append(allPackages, packages...)
 		}
+
+		allPackages = append(allPackages, packages...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read output directory: %w", err)
 	}
 
 	if len(allPackages) == 0 {
@@ -275,7 +319,11 @@ func parsePrimaryXML(archDir string) ([]models.Package, error) {
 		return nil, err
 	}
 
-	// Parse XML
+	return parsePrimary(data)
+}
+
+// parsePrimary reads the packages of an uncompressed primary.xml
+func parsePrimary(data []byte) ([]models.Package, error) {
 	var meta metadata
 	if err := xml.Unmarshal(data, &meta); err != nil {
 		return nil, err
@@ -297,12 +345,33 @@ func parsePrimaryXML(archDir string) ([]models.Package, error) {
 			SHA256Sum:    xmlPkg.Checksum.Value,
 			Metadata: map[string]interface{}{
 				"Release":   xmlPkg.Version.Rel,
+				"Epoch":     xmlPkg.Version.Epoch,
 				"BuildTime": xmlPkg.Time.Build,
 				"Group":     xmlPkg.Format.Group,
+				// Parsed as for new packages, for depcheck and the databases
+				"Dependencies": xmlPkg.Format.Dependencies,
 			},
 		}
+		pkg.Dependencies = dependencyNames(pkg, "requires")
+		pkg.Provides = dependencyNames(pkg, "provides")
+
+		// Keep elements repogen doesn't model so they survive regeneration
+		if len(xmlPkg.Extra) > 0 {
+			pkg.Metadata["primary_extra"] = xmlPkg.Extra
+		}
+		if len(xmlPkg.Format.Extra) > 0 {
+			pkg.Metadata["format_extra"] = xmlPkg.Format.Extra
+		}
 		packages = append(packages, pkg)
 	}
 
 	return packages, nil
 }
+
+// PackageFiles returns the path of a package from existing metadata, which
+// is relative to its repository directory
+func (g *Generator) PackageFiles(config *models.RepositoryConfig, pkg models.Package) []string {
+	// Invalid layouts are rejected before any package is located
+	dir, _ := repoDir(config, pkg)
+	return []string{filepath.Join(config.OutputDir, filepath.FromSlash(dir), pkg.Filename)}
+}
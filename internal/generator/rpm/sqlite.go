@@ -0,0 +1,323 @@
+package rpm
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ralt/repogen/internal/bzip2"
+	"github.com/ralt/repogen/internal/sqlite"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sassoftware/go-rpmutils"
+	"github.com/sirupsen/logrus"
+)
+
+// dbVersion is the version of the yum database schemas below
+const dbVersion = 10
+
+// primaryDBTables are the tables of primary.sqlite, as created by createrepo
+var primaryDBTables = []struct{ name, sql string }{
+	{"db_info", "CREATE TABLE db_info (dbversion INTEGER, checksum TEXT)"},
+	{"packages", "CREATE TABLE packages (  pkgKey INTEGER PRIMARY KEY,  pkgId TEXT,  name TEXT,  arch TEXT,  version TEXT,  epoch TEXT,  release TEXT,  summary TEXT,  description TEXT,  url TEXT,  time_file INTEGER,  time_build INTEGER,  rpm_license TEXT,  rpm_vendor TEXT,  rpm_group TEXT,  rpm_buildhost TEXT,  rpm_sourcerpm TEXT,  rpm_header_start INTEGER,  rpm_header_end INTEGER,  rpm_packager TEXT,  size_package INTEGER,  size_installed INTEGER,  size_archive INTEGER,  location_href TEXT,  location_base TEXT,  checksum_type TEXT)"},
+	{"files", "CREATE TABLE files (  name TEXT,  type TEXT,  pkgKey INTEGER)"},
+	{"requires", "CREATE TABLE requires (  name TEXT,  flags TEXT,  epoch TEXT,  version TEXT,  release TEXT,  pkgKey INTEGER , pre BOOLEAN DEFAULT FALSE)"},
+	{"provides", "CREATE TABLE provides (  name TEXT,  flags TEXT,  epoch TEXT,  version TEXT,  release TEXT,  pkgKey INTEGER )"},
+	{"conflicts", "CREATE TABLE conflicts (  name TEXT,  flags TEXT,  epoch TEXT,  version TEXT,  release TEXT,  pkgKey INTEGER )"},
+	{"obsoletes", "CREATE TABLE obsoletes (  name TEXT,  flags TEXT,  epoch TEXT,  version TEXT,  release TEXT,  pkgKey INTEGER )"},
+	{"suggests", "CREATE TABLE suggests (  name TEXT,  flags TEXT,  epoch TEXT,  version TEXT,  release TEXT,  pkgKey INTEGER )"},
+	{"enhances", "CREATE TABLE enhances (  name TEXT,  flags TEXT,  epoch TEXT,  version TEXT,  release TEXT,  pkgKey INTEGER )"},
+	{"recommends", "CREATE TABLE recommends (  name TEXT,  flags TEXT,  epoch TEXT,  version TEXT,  release TEXT,  pkgKey INTEGER )"},
+	{"supplements", "CREATE TABLE supplements (  name TEXT,  flags TEXT,  epoch TEXT,  version TEXT,  release TEXT,  pkgKey INTEGER )"},
+}
+
+// filelistsDBTables are the tables of filelists.sqlite, as created by createrepo
+var filelistsDBTables = []struct{ name, sql string }{
+	{"db_info", "CREATE TABLE db_info (dbversion INTEGER, checksum TEXT)"},
+	{"packages", "CREATE TABLE packages (  pkgKey INTEGER PRIMARY KEY,  pkgId TEXT)"},
+	{"filelist", "CREATE TABLE filelist (  pkgKey INTEGER,  dirname TEXT,  filenames TEXT,  filetypes TEXT)"},
+}
+
+// otherDBTables are the tables of other.sqlite, as created by createrepo
+var otherDBTables = []struct{ name, sql string }{
+	{"db_info", "CREATE TABLE db_info (dbversion INTEGER, checksum TEXT)"},
+	{"packages", "CREATE TABLE packages (  pkgKey INTEGER PRIMARY KEY,  pkgId TEXT)"},
+	{"changelog", "CREATE TABLE changelog (  pkgKey INTEGER,  author TEXT,  date INTEGER,  changelog TEXT)"},
+}
+
+// generatePrimaryDB builds primary.sqlite, the database yum reads instead of
+// parsing primary.xml. checksum is that of the compressed primary.xml it
+// mirrors, which yum compares to repomd.xml
+func generatePrimaryDB(packages []xmlPkg, checksum string) ([]byte, error) {
+	db := sqlite.New()
+	tables := make(map[string]*sqlite.Table)
+	for _, t := range primaryDBTables {
+		tables[t.name] = db.CreateTable(t.name, t.sql)
+	}
+
+	if err := tables["db_info"].Insert(dbVersion, checksum); err != nil {
+		return nil, err
+	}
+
+	for i, p := range packages {
+		pkgKey := int64(i + 1) // The row id of the package
+		err := tables["packages"].Insert(nil, p.Checksum.Value, p.Name, p.Arch,
+			p.Version.Ver, p.Version.Epoch, p.Version.Rel, p.Summary, p.Summary, p.URL,
+			p.Time.File, p.Time.Build, p.Format.License, "", p.Format.Group, "", "",
+			0, 0, p.Packager, p.Size.Package, p.Size.Installed, p.Size.Archive,
+			p.Location.Href, nil, p.Checksum.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, list := range p.Format.Dependencies {
+			table := tables[strings.TrimPrefix(list.XMLName.Local, "rpm:")]
+			if table == nil {
+				continue
+			}
+			for _, e := range list.Entries {
+				values := []interface{}{e.Name, e.Flags, e.Epoch, e.Ver, e.Rel, pkgKey}
+				if list.XMLName.Local == "rpm:requires" {
+					values = append(values, e.Pre == "1")
+				}
+				if err := table.Insert(values...); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		files, err := packageFiles(p.Format)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read files of %s: %w", p.Name, err)
+		}
+		for _, f := range files {
+			if err := tables["files"].Insert(f.name, f.kind, pkgKey); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return db.Bytes()
+}
+
+// packageLists holds what filelists.sqlite and other.sqlite list of a
+// package, which primary.xml only partly carries
+type packageLists struct {
+	files     []packageFile
+	changelog []changelogEntry
+}
+
+type changelogEntry struct {
+	author string
+	date   int64
+	text   string
+}
+
+// readPackageLists reads the files and changelog of the package at path.
+// Packages that can't be read, e.g. missing from an existing repository,
+// keep the files of format and no changelog
+func readPackageLists(path string, format xmlFormat) (packageLists, error) {
+	f, err := os.Open(path)
+	if err == nil {
+		defer f.Close()
+		var header *rpmutils.RpmHeader
+		if header, err = rpmutils.ReadHeader(f); err == nil {
+			return headerLists(header)
+		}
+	}
+	logrus.Debugf("Listing the files of %s from primary.xml: %v", filepath.Base(path), err)
+
+	files, err := packageFiles(format)
+	return packageLists{files: files}, err
+}
+
+// headerLists reads the files and changelog of an RPM header
+func headerLists(header *rpmutils.RpmHeader) (packageLists, error) {
+	var lists packageLists
+	files, err := header.GetFiles()
+	if err != nil {
+		return lists, err
+	}
+	for _, file := range files {
+		kind := "file"
+		switch {
+		case file.Flags()&rpmutils.RPMFILE_GHOST != 0:
+			kind = "ghost"
+		case file.Mode()&0170000 == 0040000:
+			kind = "dir"
+		}
+		lists.files = append(lists.files, packageFile{name: file.Name(), kind: kind})
+	}
+
+	times, _ := header.GetInts(rpmutils.CHANGELOGTIME)
+	authors, _ := header.GetStrings(rpmutils.CHANGELOGNAME)
+	texts, _ := header.GetStrings(rpmutils.CHANGELOGTEXT)
+	for i := range times {
+		if i >= len(authors) || i >= len(texts) {
+			break
+		}
+		lists.changelog = append(lists.changelog, changelogEntry{author: authors[i], date: int64(times[i]), text: texts[i]})
+	}
+	return lists, nil
+}
+
+// generateFilelistsDB builds filelists.sqlite, listing the files of each
+// package by directory, their types abbreviated to f, d or g
+func generateFilelistsDB(packages []xmlPkg, lists []packageLists, checksum string) ([]byte, error) {
+	db := sqlite.New()
+	tables := make(map[string]*sqlite.Table)
+	for _, t := range filelistsDBTables {
+		tables[t.name] = db.CreateTable(t.name, t.sql)
+	}
+
+	if err := tables["db_info"].Insert(dbVersion, checksum); err != nil {
+		return nil, err
+	}
+	for i, p := range packages {
+		pkgKey := int64(i + 1)
+		if err := tables["packages"].Insert(nil, p.Checksum.Value); err != nil {
+			return nil, err
+		}
+
+		var dirs []string
+		names := make(map[string][]string)
+		types := make(map[string]string)
+		for _, f := range lists[i].files {
+			dir, name := path.Split(f.name)
+			dir = strings.TrimSuffix(dir, "/")
+			if _, ok := names[dir]; !ok {
+				dirs = append(dirs, dir)
+			}
+			names[dir] = append(names[dir], name)
+			types[dir] += f.kind[:1]
+		}
+		for _, dir := range dirs {
+			if err := tables["filelist"].Insert(pkgKey, dir, strings.Join(names[dir], "/"), types[dir]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return db.Bytes()
+}
+
+// generateOtherDB builds other.sqlite, holding the changelog of each package
+func generateOtherDB(packages []xmlPkg, lists []packageLists, checksum string) ([]byte, error) {
+	db := sqlite.New()
+	tables := make(map[string]*sqlite.Table)
+	for _, t := range otherDBTables {
+		tables[t.name] = db.CreateTable(t.name, t.sql)
+	}
+
+	if err := tables["db_info"].Insert(dbVersion, checksum); err != nil {
+		return nil, err
+	}
+	for i, p := range packages {
+		pkgKey := int64(i + 1)
+		if err := tables["packages"].Insert(nil, p.Checksum.Value); err != nil {
+			return nil, err
+		}
+		for _, e := range lists[i].changelog {
+			if err := tables["changelog"].Insert(pkgKey, e.author, e.date, e.text); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return db.Bytes()
+}
+
+func isDependencyList(element string) bool {
+	for _, kind := range dependencyKinds {
+		if element == "rpm:"+kind.element {
+			return true
+		}
+	}
+	return false
+}
+
+type packageFile struct {
+	name, kind string
+}
+
+// packageFiles returns the files listed in primary.xml, which only
+// existing metadata carries
+func packageFiles(format xmlFormat) ([]packageFile, error) {
+	var files []packageFile
+	for _, extra := range format.Extra {
+		if extra.XMLName.Local != "file" {
+			continue
+		}
+
+		var name string
+		if err := xml.Unmarshal([]byte("<file>"+extra.Inner+"</file>"), &name); err != nil {
+			return nil, err
+		}
+		kind := "file"
+		for _, attr := range extra.Attrs {
+			if attr.Name.Local == "type" {
+				kind = attr.Value
+			}
+		}
+		files = append(files, packageFile{name: name, kind: kind})
+	}
+	return files, nil
+}
+
+// writeDatabases writes primary.sqlite.bz2, filelists.sqlite.bz2 and
+// other.sqlite.bz2 next to primary.xml.gz, reading the file lists and
+// changelogs of the packages of repoPath. There is no filelists.xml or
+// other.xml for their db_info to mirror: all three record the checksum of
+// primary.xml.gz, which they are generated along with
+func writeDatabases(repoPath, repodataDir string, packages []xmlPkg, primaryChecksum string) ([]repomdData, error) {
+	primaryDB, err := generatePrimaryDB(packages, primaryChecksum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate primary.sqlite: %w", err)
+	}
+
+	lists := make([]packageLists, len(packages))
+	for i, p := range packages {
+		if lists[i], err = readPackageLists(filepath.Join(repoPath, filepath.FromSlash(p.Location.Href)), p.Format); err != nil {
+			return nil, fmt.Errorf("failed to read files of %s: %w", p.Name, err)
+		}
+	}
+	filelistsDB, err := generateFilelistsDB(packages, lists, primaryChecksum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate filelists.sqlite: %w", err)
+	}
+	otherDB, err := generateOtherDB(packages, lists, primaryChecksum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate other.sqlite: %w", err)
+	}
+
+	var entries []repomdData
+	for _, db := range []struct {
+		name string
+		data []byte
+	}{{"primary", primaryDB}, {"filelists", filelistsDB}, {"other", otherDB}} {
+		entry, err := writeDatabase(repodataDir, db.name, db.data)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// writeDatabase writes <name>.sqlite.bz2, described by the <name>_db entry
+// of repomd.xml
+func writeDatabase(repodataDir, name string, db []byte) (repomdData, error) {
+	compressed := bzip2.Compress(db)
+	checksum, _ := utils.CalculateChecksum(compressed, "sha256")
+
+	file := fmt.Sprintf("%s-%s.sqlite.bz2", checksum, name)
+	if err := utils.WriteFile(filepath.Join(repodataDir, file), compressed, 0644); err != nil {
+		return repomdData{}, fmt.Errorf("failed to write %s.sqlite.bz2: %w", name, err)
+	}
+
+	entry := newRepomdData(name+"_db", "repodata/"+file, compressed, db)
+	entry.DatabaseVersion = dbVersion
+	return entry, nil
+}
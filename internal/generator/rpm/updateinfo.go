@@ -0,0 +1,317 @@
+package rpm
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// advisories describes the errata of a repository, as read from the
+// --rpm-advisories file
+type advisories struct {
+	Advisories []advisory `yaml:"advisories"`
+}
+
+type advisory struct {
+	ID              string              `yaml:"id"`
+	Type            string              `yaml:"type"`     // security, bugfix, enhancement or newpackage
+	Severity        string              `yaml:"severity"` // Critical, Important, Moderate, Low or None
+	Title           string              `yaml:"title"`
+	Description     string              `yaml:"description"`
+	Issued          string              `yaml:"issued"`  // YYYY-MM-DD or YYYY-MM-DD HH:MM:SS
+	Updated         string              `yaml:"updated"` // Same formats as issued
+	CVEs            []string            `yaml:"cves"`
+	References      []advisoryReference `yaml:"references"`
+	RebootSuggested bool                `yaml:"reboot_suggested"`
+	Packages        []advisoryPackage   `yaml:"packages"`
+}
+
+type advisoryReference struct {
+	Href  string `yaml:"href"`
+	ID    string `yaml:"id"`
+	Title string `yaml:"title"`
+	Type  string `yaml:"type"` // Defaults to "other"
+}
+
+// advisoryPackage selects the fixed packages. Version and release are
+// optional; when empty every version of the package matches
+type advisoryPackage struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	Release string `yaml:"release"`
+}
+
+var (
+	advisoryTypes      = map[string]bool{"security": true, "bugfix": true, "enhancement": true, "newpackage": true}
+	advisorySeverities = map[string]bool{"Critical": true, "Important": true, "Moderate": true, "Low": true, "None": true}
+)
+
+// loadAdvisories reads and validates an advisories file (YAML or JSON)
+func loadAdvisories(path string) (*advisories, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read advisories file: %w", err)
+	}
+
+	var a advisories
+	if err := yaml.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("failed to parse advisories file %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for i := range a.Advisories {
+		adv := &a.Advisories[i]
+		if adv.ID == "" {
+			return nil, fmt.Errorf("advisory %q in %s has no id", adv.Title, path)
+		}
+		if seen[adv.ID] {
+			return nil, fmt.Errorf("duplicate advisory id %q in %s", adv.ID, path)
+		}
+		seen[adv.ID] = true
+
+		if adv.Type == "" {
+			adv.Type = "bugfix"
+		}
+		if !advisoryTypes[adv.Type] {
+			return nil, fmt.Errorf("advisory %s has invalid type %q (expected security, bugfix, enhancement or newpackage)", adv.ID, adv.Type)
+		}
+		if adv.Severity != "" && !advisorySeverities[adv.Severity] {
+			return nil, fmt.Errorf("advisory %s has invalid severity %q (expected Critical, Important, Moderate, Low or None)", adv.ID, adv.Severity)
+		}
+		if len(adv.Packages) == 0 {
+			return nil, fmt.Errorf("advisory %s lists no packages", adv.ID)
+		}
+
+		if adv.Issued, err = formatAdvisoryDate(adv.Issued); err != nil {
+			return nil, fmt.Errorf("advisory %s: invalid issued date: %w", adv.ID, err)
+		}
+		if adv.Updated, err = formatAdvisoryDate(adv.Updated); err != nil {
+			return nil, fmt.Errorf("advisory %s: invalid updated date: %w", adv.ID, err)
+		}
+	}
+
+	return &a, nil
+}
+
+// formatAdvisoryDate normalizes a date to the format used by updateinfo.xml
+func formatAdvisoryDate(date string) (string, error) {
+	if date == "" {
+		return "", nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t.UTC().Format("2006-01-02 15:04:05"), nil
+		}
+	}
+	return "", fmt.Errorf("unrecognized date %q", date)
+}
+
+type xmlUpdates struct {
+	XMLName xml.Name    `xml:"updates"`
+	Updates []xmlUpdate `xml:"update"`
+}
+
+type xmlUpdate struct {
+	From        string              `xml:"from,attr"`
+	Status      string              `xml:"status,attr"`
+	Type        string              `xml:"type,attr"`
+	Version     string              `xml:"version,attr"`
+	ID          string              `xml:"id"`
+	Title       string              `xml:"title"`
+	Issued      *xmlUpdateDate      `xml:"issued,omitempty"`
+	Updated     *xmlUpdateDate      `xml:"updated,omitempty"`
+	Severity    string              `xml:"severity,omitempty"`
+	Description string              `xml:"description"`
+	References  []xmlReference      `xml:"references>reference"`
+	Collection  xmlUpdateCollection `xml:"pkglist>collection"`
+}
+
+type xmlUpdateDate struct {
+	Date string `xml:"date,attr"`
+}
+
+type xmlReference struct {
+	Href  string `xml:"href,attr"`
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type xmlUpdateCollection struct {
+	Short    string             `xml:"short,attr"`
+	Name     string             `xml:"name"`
+	Packages []xmlUpdatePackage `xml:"package"`
+}
+
+type xmlUpdatePackage struct {
+	Name            string `xml:"name,attr"`
+	Version         string `xml:"version,attr"`
+	Release         string `xml:"release,attr"`
+	Epoch           string `xml:"epoch,attr"`
+	Arch            string `xml:"arch,attr"`
+	Filename        string `xml:"filename"`
+	RebootSuggested string `xml:"reboot_suggested,omitempty"`
+}
+
+// generateUpdateInfoXML renders the advisories affecting packages in the
+// updateinfo.xml format used by `dnf updateinfo`. Advisories none of the
+// packages match are left out; it returns nil when nothing matches
+func generateUpdateInfoXML(config *models.RepositoryConfig, adv *advisories, packages []models.Package) ([]byte, error) {
+	from := config.Origin
+	if from == "" {
+		from = "repogen"
+	}
+	collection := utils.Slug(from)
+
+	updates := xmlUpdates{}
+	for _, a := range adv.Advisories {
+		update := xmlUpdate{
+			From:        from,
+			Status:      "stable",
+			Type:        a.Type,
+			Version:     "2.0",
+			ID:          a.ID,
+			Title:       a.Title,
+			Severity:    a.Severity,
+			Description: a.Description,
+			Collection: xmlUpdateCollection{
+				Short: collection,
+				Name:  collection,
+			},
+		}
+		if update.Title == "" {
+			update.Title = a.ID
+		}
+		if a.Issued != "" {
+			update.Issued = &xmlUpdateDate{Date: a.Issued}
+		}
+		if a.Updated != "" {
+			update.Updated = &xmlUpdateDate{Date: a.Updated}
+		}
+
+		for _, cve := range a.CVEs {
+			update.References = append(update.References, xmlReference{
+				Href:  "https://www.cve.org/CVERecord?id=" + cve,
+				ID:    cve,
+				Title: cve,
+				Type:  "cve",
+			})
+		}
+		for _, ref := range a.References {
+			refType := ref.Type
+			if refType == "" {
+				refType = "other"
+			}
+			update.References = append(update.References, xmlReference{
+				Href:  ref.Href,
+				ID:    ref.ID,
+				Title: ref.Title,
+				Type:  refType,
+			})
+		}
+
+		for _, pkg := range packages {
+			if !a.affects(pkg) {
+				continue
+			}
+			release := "1"
+			if r, ok := pkg.Metadata["Release"].(string); ok {
+				release = r
+			}
+			epoch := "0"
+			if e, ok := pkg.Metadata["Epoch"].(string); ok && e != "" {
+				epoch = e
+			}
+			xmlPackage := xmlUpdatePackage{
+				Name:     pkg.Name,
+				Version:  pkg.Version,
+				Release:  release,
+				Epoch:    epoch,
+				Arch:     pkg.Architecture,
+				Filename: filepath.Base(pkg.Filename),
+			}
+			if a.RebootSuggested {
+				xmlPackage.RebootSuggested = "True"
+			}
+			update.Collection.Packages = append(update.Collection.Packages, xmlPackage)
+		}
+
+		if len(update.Collection.Packages) == 0 {
+			logrus.Debugf("Advisory %s matches no package in this repository, skipping", a.ID)
+			continue
+		}
+		updates.Updates = append(updates.Updates, update)
+	}
+
+	if len(updates.Updates) == 0 {
+		return nil, nil
+	}
+
+	xmlBytes, err := xml.MarshalIndent(updates, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), xmlBytes...), nil
+}
+
+// affects reports whether pkg is one of the packages fixed by the advisory
+func (a *advisory) affects(pkg models.Package) bool {
+	release, _ := pkg.Metadata["Release"].(string)
+	for _, p := range a.Packages {
+		if p.Name != pkg.Name {
+			continue
+		}
+		if p.Version != "" && p.Version != pkg.Version {
+			continue
+		}
+		if p.Release != "" && p.Release != release {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// writeUpdateInfoXML writes updateinfo.xml.gz and returns the matching
+// repomd.xml entries, none when no advisory applies to packages
+func writeUpdateInfoXML(config *models.RepositoryConfig, repodataDir string, adv *advisories, packages []models.Package) ([]repomdData, error) {
+	updateInfoXML, err := generateUpdateInfoXML(config, adv, packages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate updateinfo.xml: %w", err)
+	}
+	if updateInfoXML == nil {
+		return nil, nil
+	}
+
+	updateInfoGz, err := utils.GzipCompress(updateInfoXML)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress updateinfo.xml: %w", err)
+	}
+
+	checksum, _ := utils.CalculateChecksum(updateInfoGz, "sha256")
+	name := fmt.Sprintf("%s-updateinfo.xml.gz", checksum)
+	if err := utils.WriteFile(filepath.Join(repodataDir, name), updateInfoGz, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write updateinfo.xml.gz: %w", err)
+	}
+
+	entries := []repomdData{
+		newRepomdData("updateinfo", "repodata/"+name, updateInfoGz, updateInfoXML),
+	}
+
+	if config.RPMZchunk {
+		zckEntry, err := writeZchunk(repodataDir, "updateinfo.xml", "updateinfo_zck", updateInfoXML)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, zckEntry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,72 @@
+package rpm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// ReadUpstream reads the repodata/repomd.xml of the repository and the
+// primary metadata it lists, checked against its checksum
+func (g *Generator) ReadUpstream(ctx context.Context, config *models.RepositoryConfig, upstream generator.Upstream) ([]models.Package, error) {
+	const repomdPath = "repodata/repomd.xml"
+	repomdData, err := upstream.Get(ctx, repomdPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", repomdPath, err)
+	}
+	if upstream.Verifies() {
+		sig, err := upstream.Get(ctx, repomdPath+".asc")
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s.asc: %w", repomdPath, err)
+		}
+		if err := upstream.Verify(repomdData, sig); err != nil {
+			return nil, fmt.Errorf("%s: %w", repomdPath, err)
+		}
+	}
+
+	var repomdDoc repomd
+	if err := xml.Unmarshal(repomdData, &repomdDoc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", repomdPath, err)
+	}
+
+	for _, data := range repomdDoc.Data {
+		if data.Type != "primary" {
+			continue
+		}
+
+		primary, err := upstream.Get(ctx, data.Location.Href)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", data.Location.Href, err)
+		}
+		if data.Checksum.Type == "sha256" {
+			sum := sha256.Sum256(primary)
+			if got := hex.EncodeToString(sum[:]); got != data.Checksum.Value {
+				return nil, fmt.Errorf("checksum mismatch for %s: repomd.xml lists sha256 %s, got %s", data.Location.Href, data.Checksum.Value, got)
+			}
+		}
+
+		primary, err = utils.Decompress(primary)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %w", data.Location.Href, err)
+		}
+		packages, err := parsePrimary(primary)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", data.Location.Href, err)
+		}
+
+		// Older repositories checksum packages with SHA-1, which isn't kept
+		for i := range packages {
+			if len(packages[i].SHA256Sum) != sha256.Size*2 {
+				packages[i].SHA256Sum = ""
+			}
+		}
+		return packages, nil
+	}
+	return nil, fmt.Errorf("primary.xml not found in %s", repomdPath)
+}
@@ -0,0 +1,52 @@
+package rpm
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/ralt/repogen/internal/zchunk"
+)
+
+// writeZchunk writes a zchunk copy of the metadata file name (e.g.
+// "primary.xml"), listed as dataType in repomd.xml. dnf then downloads
+// only the chunks that changed since its cached copy
+func writeZchunk(repodataDir, name, dataType string, data []byte) (repomdData, error) {
+	zck, headerSize, err := zchunk.Compress(xmlChunks(data))
+	if err != nil {
+		return repomdData{}, fmt.Errorf("failed to compress %s: %w", name, err)
+	}
+
+	checksum, _ := utils.CalculateChecksum(zck, "sha256")
+	fileName := fmt.Sprintf("%s-%s.zck", checksum, name)
+	if err := utils.WriteFile(filepath.Join(repodataDir, fileName), zck, 0644); err != nil {
+		return repomdData{}, fmt.Errorf("failed to write %s.zck: %w", name, err)
+	}
+
+	// Clients fetch the header first, to find the chunks they miss
+	headerChecksum, _ := utils.CalculateChecksum(zck[:headerSize], "sha256")
+	entry := newRepomdData(dataType, "repodata/"+fileName, zck, data)
+	entry.HeaderChecksum = &repomdChecksum{Type: "sha256", Value: headerChecksum}
+	entry.HeaderSize = int64(headerSize)
+	return entry, nil
+}
+
+// xmlChunks splits an indented XML document before each child of the root
+// element, so a package or group changing only changes its own chunk
+func xmlChunks(data []byte) [][]byte {
+	var chunks [][]byte
+	start := 0
+	for i := 0; ; {
+		next := bytes.Index(data[i:], []byte("\n  <"))
+		if next < 0 {
+			break
+		}
+		i += next + 1
+		if i+3 < len(data) && data[i+3] != '/' && i > start {
+			chunks = append(chunks, data[start:i])
+			start = i
+		}
+	}
+	return append(chunks, data[start:])
+}
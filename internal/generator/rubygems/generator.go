@@ -0,0 +1,303 @@
+package rubygems
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Gems are served from gemsDir, the compact index info files from infoDir
+// and the Marshal specifications gem install fetches from quickDir
+const (
+	gemsDir  = "gems"
+	infoDir  = "info"
+	quickDir = "quick/Marshal.4.8"
+)
+
+// prereleaseRe matches prerelease versions, as Gem::Version#prerelease? does
+var prereleaseRe = regexp.MustCompile(`[a-zA-Z]`)
+
+// Generator implements the generator.Generator interface for RubyGems
+// sources: the compact index bundler and gem query, and the legacy
+// specs.4.8.gz indexes
+type Generator struct{}
+
+// NewGenerator creates a new RubyGems generator
+func NewGenerator() generator.Generator {
+	return &Generator{}
+}
+
+// Generate copies the gems and writes the compact index and specs indexes
+func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfig, packages []models.Package) error {
+	logrus.Info("Generating RubyGems repository...")
+
+	if err := utils.EnsureDir(filepath.Join(config.OutputDir, gemsDir)); err != nil {
+		return err
+	}
+
+	for i := range packages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := g.publishGem(ctx, config, &packages[i]); err != nil {
+			return fmt.Errorf("failed to publish %s: %w", packages[i].Name, err)
+		}
+	}
+
+	// Group versions by gem
+	versionsByGem := make(map[string][]models.Package)
+	for _, pkg := range packages {
+		versionsByGem[pkg.Name] = append(versionsByGem[pkg.Name], pkg)
+	}
+	names := make([]string, 0, len(versionsByGem))
+	for name, versions := range versionsByGem {
+		names = append(names, name)
+		sort.SliceStable(versions, func(i, j int) bool {
+			if c := utils.CompareSemanticVersions(versions[i].Version, versions[j].Version); c != 0 {
+				return c < 0
+			}
+			return versions[i].Architecture < versions[j].Architecture
+		})
+	}
+	sort.Strings(names)
+
+	if err := writeCompactIndex(config.OutputDir, names, versionsByGem, utils.Timestamp(config)); err != nil {
+		return err
+	}
+
+	if err := writeSpecs(config.OutputDir, names, versionsByGem); err != nil {
+		return err
+	}
+
+	logrus.Infof("RubyGems repository generated successfully (%d gems, %d versions)", len(names), len(packages))
+	return nil
+}
+
+// publishGem copies a gem to gems/ and writes its Marshal specification
+func (g *Generator) publishGem(ctx context.Context, config *models.RepositoryConfig, pkg *models.Package) error {
+	dstPath := filepath.Join(config.OutputDir, gemsDir, fullName(*pkg)+".gem")
+
+	// Gems read back from the index are published already, possibly only
+	// on remote storage
+	srcPath, finalDstPath, needsCopy, err := utils.ShouldCopyPackage(pkg, dstPath, config.OutputDir)
+	if err != nil {
+		return fmt.Errorf("package copy check failed: %w", err)
+	}
+
+	if needsCopy {
+		logrus.Debugf("Copying package: %s -> %s", srcPath, finalDstPath)
+
+		checksums, err := utils.CopyFileWithChecksums(ctx, srcPath, finalDstPath)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", srcPath, err)
+		}
+
+		if config.VerifyWrites {
+			if err := utils.VerifyCopy(finalDstPath, checksums); err != nil {
+				return fmt.Errorf("failed to verify %s: %w", srcPath, err)
+			}
+		}
+
+		pkg.Size = checksums.Size
+		pkg.MD5Sum = checksums.MD5
+		pkg.SHA1Sum = checksums.SHA1
+		pkg.SHA256Sum = checksums.SHA256
+	}
+
+	pkg.Filename = filepath.Join(gemsDir, filepath.Base(dstPath))
+
+	// Gems read back from the index keep the specification written then
+	spec, ok := pkg.Metadata["Gemspec"].(*gemspec)
+	if !ok {
+		return nil
+	}
+	var rz bytes.Buffer
+	zw := zlib.NewWriter(&rz)
+	zw.Write(marshalSpec(spec))
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	specPath := filepath.Join(config.OutputDir, quickDir, fullName(*pkg)+".gemspec.rz")
+	if err := utils.WriteFile(specPath, rz.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write gemspec: %w", err)
+	}
+
+	return nil
+}
+
+// writeCompactIndex writes the names and versions files, created at
+// created, and the info file of every gem
+func writeCompactIndex(outputDir string, names []string, versionsByGem map[string][]models.Package, created time.Time) error {
+	var namesFile, versionsFile strings.Builder
+	namesFile.WriteString("---\n")
+	fmt.Fprintf(&versionsFile, "created_at: %s\n---\n", created.Format(time.RFC3339))
+
+	for _, name := range names {
+		info := infoFile(versionsByGem[name])
+		if err := utils.WriteFile(filepath.Join(outputDir, infoDir, name), info, 0644); err != nil {
+			return fmt.Errorf("failed to write info file: %w", err)
+		}
+
+		var versions []string
+		for _, pkg := range versionsByGem[name] {
+			versions = append(versions, platformVersion(pkg))
+		}
+		sum := md5.Sum(info)
+		namesFile.WriteString(name + "\n")
+		fmt.Fprintf(&versionsFile, "%s %s %s\n", name, strings.Join(versions, ","), hex.EncodeToString(sum[:]))
+	}
+
+	if err := utils.WriteFile(filepath.Join(outputDir, "names"), []byte(namesFile.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write names: %w", err)
+	}
+	if err := utils.WriteFile(filepath.Join(outputDir, "versions"), []byte(versionsFile.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write versions: %w", err)
+	}
+
+	return removeStaleInfo(outputDir, versionsByGem)
+}
+
+// infoFile renders the compact index info file of a gem's versions:
+// VERSION[-PLATFORM] DEP:REQ,...|checksum:SHA256,ruby:REQ,rubygems:REQ
+func infoFile(versions []models.Package) []byte {
+	var b strings.Builder
+	b.WriteString("---\n")
+	for _, pkg := range versions {
+		reqs := []string{"checksum:" + pkg.SHA256Sum}
+		if req, ok := pkg.Metadata["RequiredRuby"].(string); ok {
+			reqs = append(reqs, "ruby:"+req)
+		}
+		if req, ok := pkg.Metadata["RequiredRubygems"].(string); ok {
+			reqs = append(reqs, "rubygems:"+req)
+		}
+		fmt.Fprintf(&b, "%s %s|%s\n", platformVersion(pkg), strings.Join(pkg.Dependencies, ","), strings.Join(reqs, ","))
+	}
+	return []byte(b.String())
+}
+
+// removeStaleInfo deletes the info files of gems without versions
+func removeStaleInfo(outputDir string, versionsByGem map[string][]models.Package) error {
+	entries, err := os.ReadDir(filepath.Join(outputDir, infoDir))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, ok := versionsByGem[entry.Name()]; ok || entry.IsDir() {
+			continue
+		}
+		if err := os.Remove(filepath.Join(outputDir, infoDir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove stale info file: %w", err)
+		}
+		logrus.Infof("Removed gem %s, which has no versions left", entry.Name())
+	}
+	return nil
+}
+
+// writeSpecs writes specs.4.8.gz (released versions), latest_specs.4.8.gz
+// (the latest released version per platform) and prerelease_specs.4.8.gz,
+// each a gzipped Marshal array of [name, Gem::Version, platform]
+func writeSpecs(outputDir string, names []string, versionsByGem map[string][]models.Package) error {
+	specs, latest, prerelease := []interface{}{}, []interface{}{}, []interface{}{}
+	for _, name := range names {
+		latestByPlatform := make(map[string]models.Package)
+		var platforms []string
+		for _, pkg := range versionsByGem[name] {
+			tuple := []interface{}{pkg.Name, rubyVersion(pkg.Version), pkg.Architecture}
+			if prereleaseRe.MatchString(pkg.Version) {
+				prerelease = append(prerelease, tuple)
+				continue
+			}
+			specs = append(specs, tuple)
+			if _, ok := latestByPlatform[pkg.Architecture]; !ok {
+				platforms = append(platforms, pkg.Architecture)
+			}
+			// Versions are sorted: the last one wins
+			latestByPlatform[pkg.Architecture] = pkg
+		}
+		for _, platform := range platforms {
+			pkg := latestByPlatform[platform]
+			latest = append(latest, []interface{}{pkg.Name, rubyVersion(pkg.Version), pkg.Architecture})
+		}
+	}
+
+	for file, tuples := range map[string][]interface{}{
+		"specs.4.8.gz":            specs,
+		"latest_specs.4.8.gz":     latest,
+		"prerelease_specs.4.8.gz": prerelease,
+	} {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		gw.Write(rubyMarshal(tuples))
+		if err := gw.Close(); err != nil {
+			return err
+		}
+		if err := utils.WriteFile(filepath.Join(outputDir, file), buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// ValidatePackages checks if packages are gems
+func (g *Generator) ValidatePackages(packages []models.Package) error {
+	for _, pkg := range packages {
+		if !strings.HasSuffix(pkg.Filename, ".gem") {
+			return fmt.Errorf("package %s is not a gem", pkg.Filename)
+		}
+	}
+	return nil
+}
+
+// GetSupportedType returns the package type this generator supports
+func (g *Generator) GetSupportedType() scanner.PackageType {
+	return scanner.TypeRubygem
+}
+
+// ParseExistingMetadata reads the compact index info files under info/
+func (g *Generator) ParseExistingMetadata(config *models.RepositoryConfig) ([]models.Package, error) {
+	files, err := filepath.Glob(filepath.Join(config.OutputDir, infoDir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var packages []models.Package
+	for _, file := range files {
+		versions, err := parseInfoFile(file)
+		if err != nil {
+			logrus.Warnf("Skipping %s: %v", file, err)
+			continue
+		}
+		packages = append(packages, versions...)
+	}
+
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no existing RubyGems index found in %s", config.OutputDir)
+	}
+
+	return packages, nil
+}
+
+// PackageFiles returns the gem backing a version and its Marshal specification
+func (g *Generator) PackageFiles(config *models.RepositoryConfig, pkg models.Package) []string {
+	return []string{
+		filepath.Join(config.OutputDir, gemsDir, fullName(pkg)+".gem"),
+		filepath.Join(config.OutputDir, quickDir, fullName(pkg)+".gemspec.rz"),
+	}
+}
@@ -0,0 +1,245 @@
+package rubygems
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+// writeGem writes a .gem holding metadata.gz with the given gemspec
+func writeGem(t *testing.T, dir, name, spec string) string {
+	t.Helper()
+
+	var metadata bytes.Buffer
+	gw := gzip.NewWriter(&metadata)
+	gw.Write([]byte(spec))
+	gw.Close()
+
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{
+		{"metadata.gz", metadata.Bytes()},
+		{"data.tar.gz", []byte("data")},
+	} {
+		tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0444, Size: int64(len(file.data))})
+		tw.Write(file.data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const helloSpec = `--- !ruby/object:Gem::Specification
+name: hello
+version: !ruby/object:Gem::Version
+  version: 1.2.0
+platform: ruby
+authors:
+- Jane Doe
+email: jane@example.com
+summary: Says hello
+homepage: https://example.com/hello
+licenses:
+- MIT
+metadata:
+  source_code_uri: https://example.com/hello.git
+dependencies:
+- !ruby/object:Gem::Dependency
+  name: rack
+  requirement: !ruby/object:Gem::Requirement
+    requirements:
+    - - ">="
+      - !ruby/object:Gem::Version
+        version: '2.0'
+    - - "<"
+      - !ruby/object:Gem::Version
+        version: '4'
+  type: :runtime
+  prerelease: false
+- !ruby/object:Gem::Dependency
+  name: rspec
+  requirement: !ruby/object:Gem::Requirement
+    requirements:
+    - - "~>"
+      - !ruby/object:Gem::Version
+        version: '3.0'
+  type: :development
+  prerelease: false
+required_ruby_version: !ruby/object:Gem::Requirement
+  requirements:
+  - - ">="
+    - !ruby/object:Gem::Version
+      version: 2.7.0
+required_rubygems_version: !ruby/object:Gem::Requirement
+  requirements:
+  - - ">="
+    - !ruby/object:Gem::Version
+      version: '0'
+rubygems_version: 3.4.10
+specification_version: 4
+`
+
+func TestParsePackage(t *testing.T) {
+	path := writeGem(t, t.TempDir(), "hello-1.2.0.gem", helloSpec)
+
+	pkg, err := ParsePackage(path)
+	if err != nil {
+		t.Fatalf("ParsePackage failed: %v", err)
+	}
+	if pkg.Name != "hello" || pkg.Version != "1.2.0" || pkg.Architecture != "ruby" {
+		t.Errorf("unexpected package %s %s %s", pkg.Name, pkg.Version, pkg.Architecture)
+	}
+	if pkg.Description != "Says hello" || pkg.License != "MIT" {
+		t.Errorf("unexpected summary %q or license %q", pkg.Description, pkg.License)
+	}
+	// Development dependencies stay out of the index
+	if len(pkg.Dependencies) != 1 || pkg.Dependencies[0] != "rack:>= 2.0&< 4" {
+		t.Errorf("unexpected dependencies %v", pkg.Dependencies)
+	}
+	if pkg.Metadata["RequiredRuby"] != ">= 2.7.0" {
+		t.Errorf("unexpected required ruby %v", pkg.Metadata["RequiredRuby"])
+	}
+	if _, ok := pkg.Metadata["RequiredRubygems"]; ok {
+		t.Error("trivial rubygems requirement recorded")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputDir := filepath.Join(tmpDir, "output")
+
+	var packages []models.Package
+	for file, spec := range map[string]string{
+		"hello-1.2.0.gem":     helloSpec,
+		"hello-1.3.0.pre.gem": strings.Replace(helloSpec, "version: 1.2.0", "version: 1.3.0.pre", 1),
+		"native-0.1.0-x86_64-linux.gem": strings.NewReplacer(
+			"name: hello", "name: native", "version: 1.2.0", "version: 0.1.0", "platform: ruby", "platform: x86_64-linux",
+		).Replace(helloSpec),
+	} {
+		pkg, err := ParsePackage(writeGem(t, tmpDir, file, spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		packages = append(packages, *pkg)
+	}
+
+	gen := NewGenerator()
+	config := &models.RepositoryConfig{OutputDir: outputDir}
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	for _, file := range []string{
+		"gems/hello-1.2.0.gem",
+		"gems/native-0.1.0-x86_64-linux.gem",
+		"quick/Marshal.4.8/hello-1.2.0.gemspec.rz",
+		"quick/Marshal.4.8/native-0.1.0-x86_64-linux.gemspec.rz",
+		"specs.4.8.gz",
+		"latest_specs.4.8.gz",
+		"prerelease_specs.4.8.gz",
+	} {
+		if _, err := os.Stat(filepath.Join(outputDir, file)); err != nil {
+			t.Errorf("missing %s: %v", file, err)
+		}
+	}
+
+	names, _ := os.ReadFile(filepath.Join(outputDir, "names"))
+	if string(names) != "---\nhello\nnative\n" {
+		t.Errorf("unexpected names:\n%s", names)
+	}
+
+	info, _ := os.ReadFile(filepath.Join(outputDir, "info", "hello"))
+	for _, pkg := range packages {
+		if pkg.Name != "hello" || pkg.Version != "1.2.0" {
+			continue
+		}
+		want := "---\n1.2.0 rack:>= 2.0&< 4|checksum:" + pkg.SHA256Sum + ",ruby:>= 2.7.0\n"
+		if !strings.HasPrefix(string(info), want) {
+			t.Errorf("info file lacks %q:\n%s", want, info)
+		}
+	}
+
+	versions, _ := os.ReadFile(filepath.Join(outputDir, "versions"))
+	if !strings.Contains(string(versions), "\n---\nhello 1.2.0,1.3.0.pre ") || !strings.Contains(string(versions), "\nnative 0.1.0-x86_64-linux ") {
+		t.Errorf("unexpected versions file:\n%s", versions)
+	}
+
+	// The quick specification is a deflated Gem::Specification
+	rz, _ := os.ReadFile(filepath.Join(outputDir, "quick/Marshal.4.8/hello-1.2.0.gemspec.rz"))
+	zr, err := zlib.NewReader(bytes.NewReader(rz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, _ := io.ReadAll(zr)
+	if !bytes.HasPrefix(spec, []byte("\x04\x08u:\x17Gem::Specification")) || !bytes.Contains(spec, []byte("Says hello")) {
+		t.Errorf("unexpected quick specification %q", spec)
+	}
+
+	// The index reads back, and regenerating from it keeps the gems
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 3 {
+		t.Fatalf("expected 3 versions, got %d", len(existing))
+	}
+	for _, pkg := range existing {
+		if pkg.Name == "native" && (pkg.Version != "0.1.0" || pkg.Architecture != "x86_64-linux") {
+			t.Errorf("unexpected native version %+v", pkg)
+		}
+	}
+
+	var remaining []models.Package
+	for _, pkg := range existing {
+		if pkg.Name != "native" {
+			remaining = append(remaining, pkg)
+		}
+	}
+	if err := gen.Generate(context.Background(), config, remaining); err != nil {
+		t.Fatalf("Generate from existing failed: %v", err)
+	}
+	again, _ := os.ReadFile(filepath.Join(outputDir, "info", "hello"))
+	if string(again) != string(info) {
+		t.Errorf("regenerated info file differs:\n%s\nwant:\n%s", again, info)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "info", "native")); !os.IsNotExist(err) {
+		t.Error("info file of a gem without versions left behind")
+	}
+}
+
+func TestRubyMarshal(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{[]interface{}{"a", nil, true, 5}, "\x04\x08[\x09I\"\x06a\x06:\x06ET0Ti\x0a"},
+		{rubyVersion("1.0"), "\x04\x08U:\x11Gem::Version[\x06I\"\x081.0\x06:\x06ET"},
+		{[]interface{}{rubySymbol("a"), rubySymbol("a")}, "\x04\x08[\x07:\x06a;\x00"},
+		{300, "\x04\x08i\x02\x2c\x01"},
+		{-300, "\x04\x08i\xfe\xd4\xfe"},
+	}
+	for _, tt := range tests {
+		if got := string(rubyMarshal(tt.value)); got != tt.want {
+			t.Errorf("rubyMarshal(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package rubygems
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Ruby values the Marshal encoder knows beyond nil, bools, ints, strings
+// and arrays
+type (
+	// rubySymbol is a Symbol
+	rubySymbol string
+
+	// rubyHash is a Hash, in insertion order
+	rubyHash []rubyPair
+
+	// rubyUserMarshal is an object dumped by its marshal_dump method
+	rubyUserMarshal struct {
+		class string
+		data  interface{}
+	}
+
+	// rubyUserDef is an object dumped by its _dump method, as raw bytes
+	rubyUserDef struct {
+		class string
+		data  []byte
+	}
+
+	// rubyObject is a plain object and its instance variables
+	rubyObject struct {
+		class string
+		ivars rubyHash // Keyed by rubySymbol("@name")
+	}
+)
+
+type rubyPair struct {
+	key, value interface{}
+}
+
+// marshalWriter encodes values in Ruby's Marshal format 4.8, restricted
+// to what Gem::SafeMarshal loads back
+type marshalWriter struct {
+	buf     bytes.Buffer
+	symbols map[string]int
+}
+
+// rubyMarshal returns the Marshal.dump of v
+func rubyMarshal(v interface{}) []byte {
+	w := &marshalWriter{symbols: make(map[string]int)}
+	w.buf.Write([]byte{4, 8})
+	w.write(v)
+	return w.buf.Bytes()
+}
+
+func (w *marshalWriter) write(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		w.buf.WriteByte('0')
+	case bool:
+		if v {
+			w.buf.WriteByte('T')
+		} else {
+			w.buf.WriteByte('F')
+		}
+	case int:
+		w.buf.WriteByte('i')
+		w.writeLong(v)
+	case string:
+		// UTF-8 strings carry their encoding as the E instance variable
+		w.buf.WriteString(`I"`)
+		w.writeBytes([]byte(v))
+		w.writeLong(1)
+		w.writeSymbol("E")
+		w.buf.WriteByte('T')
+	case rubySymbol:
+		w.writeSymbol(string(v))
+	case []string:
+		w.buf.WriteByte('[')
+		w.writeLong(len(v))
+		for _, item := range v {
+			w.write(item)
+		}
+	case []interface{}:
+		w.buf.WriteByte('[')
+		w.writeLong(len(v))
+		for _, item := range v {
+			w.write(item)
+		}
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		hash := make(rubyHash, len(keys))
+		for i, k := range keys {
+			hash[i] = rubyPair{k, v[k]}
+		}
+		w.write(hash)
+	case rubyHash:
+		w.buf.WriteByte('{')
+		w.writeLong(len(v))
+		for _, pair := range v {
+			w.write(pair.key)
+			w.write(pair.value)
+		}
+	case rubyUserMarshal:
+		w.buf.WriteByte('U')
+		w.writeSymbol(v.class)
+		w.write(v.data)
+	case rubyUserDef:
+		w.buf.WriteByte('u')
+		w.writeSymbol(v.class)
+		w.writeBytes(v.data)
+	case rubyObject:
+		w.buf.WriteByte('o')
+		w.writeSymbol(v.class)
+		w.writeLong(len(v.ivars))
+		for _, ivar := range v.ivars {
+			w.write(ivar.key)
+			w.write(ivar.value)
+		}
+	default:
+		panic("rubyMarshal: unsupported value")
+	}
+}
+
+// writeSymbol writes a symbol, or a link to it once written
+func (w *marshalWriter) writeSymbol(name string) {
+	if index, ok := w.symbols[name]; ok {
+		w.buf.WriteByte(';')
+		w.writeLong(index)
+		return
+	}
+	w.symbols[name] = len(w.symbols)
+	w.buf.WriteByte(':')
+	w.writeBytes([]byte(name))
+}
+
+func (w *marshalWriter) writeBytes(b []byte) {
+	w.writeLong(len(b))
+	w.buf.Write(b)
+}
+
+// writeLong writes an integer in Marshal's variable length encoding
+func (w *marshalWriter) writeLong(n int) {
+	switch {
+	case n == 0:
+		w.buf.WriteByte(0)
+		return
+	case n > 0 && n < 123:
+		w.buf.WriteByte(byte(n + 5))
+		return
+	case n < 0 && n > -124:
+		w.buf.WriteByte(byte(n - 5))
+		return
+	}
+
+	var b [4]byte
+	length := 0
+	for length < len(b) {
+		b[length] = byte(n)
+		length++
+		n >>= 8
+		if n == 0 {
+			w.buf.WriteByte(byte(length))
+			break
+		}
+		if n == -1 {
+			w.buf.WriteByte(byte(-length))
+			break
+		}
+	}
+	w.buf.Write(b[:length])
+}
+
+// rubyVersion is a Gem::Version
+func rubyVersion(version string) rubyUserMarshal {
+	return rubyUserMarshal{class: "Gem::Version", data: []interface{}{version}}
+}
+
+// rubyRequirement is a Gem::Requirement
+func rubyRequirement(req gemRequirement) rubyUserMarshal {
+	pairs := []interface{}{}
+	for _, r := range req.Requirements {
+		pairs = append(pairs, []interface{}{r.Op, rubyVersion(r.Version)})
+	}
+	if len(pairs) == 0 {
+		pairs = append(pairs, []interface{}{">=", rubyVersion("0")})
+	}
+	return rubyUserMarshal{class: "Gem::Requirement", data: []interface{}{pairs}}
+}
+
+// marshalSpec returns the Gem::Specification of spec as gem fetches it from
+// quick/Marshal.4.8/, in the field order of Gem::Specification#_dump
+func marshalSpec(spec *gemspec) []byte {
+	deps := []interface{}{}
+	for _, dep := range spec.Dependencies {
+		depType := rubySymbol("runtime")
+		if dep.Type == ":development" {
+			depType = "development"
+		}
+		req := rubyRequirement(dep.Requirement)
+		deps = append(deps, rubyObject{class: "Gem::Dependency", ivars: rubyHash{
+			{rubySymbol("@name"), dep.Name},
+			{rubySymbol("@requirement"), req},
+			{rubySymbol("@type"), depType},
+			{rubySymbol("@prerelease"), false},
+			{rubySymbol("@version_requirements"), req},
+		}})
+	}
+
+	var email interface{}
+	switch e := spec.Email.(type) {
+	case string:
+		email = e
+	case []interface{}:
+		emails := make([]string, 0, len(e))
+		for _, item := range e {
+			if s, ok := item.(string); ok {
+				emails = append(emails, s)
+			}
+		}
+		email = emails
+	}
+
+	rubygemsVersion := spec.RubygemsVersion
+	if rubygemsVersion == "" {
+		rubygemsVersion = "3.0.0"
+	}
+	specVersion := spec.SpecificationVersion
+	if specVersion == 0 {
+		specVersion = 4
+	}
+	authors := spec.Authors
+	if authors == nil {
+		authors = []string{}
+	}
+	licenses := spec.Licenses
+	if licenses == nil {
+		licenses = []string{}
+	}
+	metadata := spec.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+
+	fields := []interface{}{
+		rubygemsVersion,
+		specVersion,
+		spec.Name,
+		rubyVersion(spec.Version.Version),
+		nil, // date, defaulting to the load time
+		spec.Summary,
+		rubyRequirement(spec.RequiredRubyVersion),
+		rubyRequirement(spec.RequiredRubygemsVersion),
+		spec.Platform,
+		deps,
+		"", // rubyforge_project
+		email,
+		authors,
+		spec.Description,
+		spec.Homepage,
+		true, // has_rdoc
+		spec.Platform,
+		licenses,
+		metadata,
+	}
+
+	return rubyMarshal(rubyUserDef{class: "Gem::Specification", data: rubyMarshal(fields)})
+}
@@ -0,0 +1,259 @@
+package rubygems
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// rubyPlatform is the platform of pure Ruby gems
+const rubyPlatform = "ruby"
+
+// gemspec is the Gem::Specification YAML document of a gem's metadata.gz
+type gemspec struct {
+	Name                    string            `yaml:"name"`
+	Version                 gemVersion        `yaml:"version"`
+	Platform                string            `yaml:"platform"`
+	Authors                 []string          `yaml:"authors"`
+	Email                   interface{}       `yaml:"email"` // A string or a list
+	Summary                 string            `yaml:"summary"`
+	Description             string            `yaml:"description"`
+	Homepage                string            `yaml:"homepage"`
+	Licenses                []string          `yaml:"licenses"`
+	Metadata                map[string]string `yaml:"metadata"`
+	Dependencies            []gemDependency   `yaml:"dependencies"`
+	RequiredRubyVersion     gemRequirement    `yaml:"required_ruby_version"`
+	RequiredRubygemsVersion gemRequirement    `yaml:"required_rubygems_version"`
+	RubygemsVersion         string            `yaml:"rubygems_version"`
+	SpecificationVersion    int               `yaml:"specification_version"`
+}
+
+// gemVersion is a Gem::Version
+type gemVersion struct {
+	Version string `yaml:"version"`
+}
+
+// gemRequirement is a Gem::Requirement, a list of (operator, version) pairs
+type gemRequirement struct {
+	Requirements []requirementPair `yaml:"requirements"`
+}
+
+type requirementPair struct {
+	Op      string
+	Version string
+}
+
+// UnmarshalYAML reads a [operator, Gem::Version] pair
+func (p *requirementPair) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode || len(node.Content) != 2 {
+		return fmt.Errorf("line %d: invalid requirement", node.Line)
+	}
+	var version gemVersion
+	if err := node.Content[1].Decode(&version); err != nil {
+		return err
+	}
+	p.Op, p.Version = node.Content[0].Value, version.Version
+	return nil
+}
+
+// gemDependency is a Gem::Dependency
+type gemDependency struct {
+	Name        string         `yaml:"name"`
+	Requirement gemRequirement `yaml:"requirement"`
+	Type        string         `yaml:"type"` // ":runtime" or ":development"
+}
+
+// String renders the requirement the way the compact index does, e.g.
+// ">= 1.0&< 2"
+func (r gemRequirement) String() string {
+	if len(r.Requirements) == 0 {
+		return ">= 0"
+	}
+	parts := make([]string, len(r.Requirements))
+	for i, req := range r.Requirements {
+		parts[i] = req.Op + " " + req.Version
+	}
+	return strings.Join(parts, "&")
+}
+
+// ParsePackage parses a .gem file and extracts metadata
+func ParsePackage(path string) (*models.Package, error) {
+	// Calculate checksums
+	checksums, err := utils.CalculateChecksums(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate checksums: %w", err)
+	}
+
+	spec, err := readGemspec(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gemspec: %w", err)
+	}
+
+	pkg := specPackage(spec)
+	pkg.Filename = path
+	pkg.Size = checksums.Size
+	pkg.MD5Sum = checksums.MD5
+	pkg.SHA1Sum = checksums.SHA1
+	pkg.SHA256Sum = checksums.SHA256
+	pkg.SHA512Sum = checksums.SHA512
+
+	return pkg, nil
+}
+
+// readGemspec reads metadata.gz from a .gem, a plain tar archive
+func readGemspec(path string) (*gemspec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Name != "metadata.gz" {
+			continue
+		}
+
+		gr, err := gzip.NewReader(tr)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+
+		var spec gemspec
+		if err := yaml.NewDecoder(gr).Decode(&spec); err != nil {
+			return nil, err
+		}
+		if spec.Name == "" || spec.Version.Version == "" {
+			return nil, fmt.Errorf("gemspec lacks name or version")
+		}
+		if spec.Platform == "" {
+			spec.Platform = rubyPlatform
+		}
+		return &spec, nil
+	}
+
+	return nil, fmt.Errorf("metadata.gz not found in gem")
+}
+
+// specPackage describes a gem from its specification. Runtime
+// dependencies are kept in compact index form, "name:requirement"
+func specPackage(spec *gemspec) *models.Package {
+	pkg := &models.Package{
+		Name:         spec.Name,
+		Version:      spec.Version.Version,
+		Architecture: spec.Platform,
+		Description:  spec.Summary,
+		Maintainer:   strings.Join(spec.Authors, ", "),
+		Homepage:     spec.Homepage,
+		License:      strings.Join(spec.Licenses, ", "),
+		Metadata:     make(map[string]interface{}),
+	}
+
+	for _, dep := range spec.Dependencies {
+		if dep.Type == ":runtime" || dep.Type == "" {
+			pkg.Dependencies = append(pkg.Dependencies, dep.Name+":"+dep.Requirement.String())
+		}
+	}
+	sort.Strings(pkg.Dependencies)
+
+	if req := spec.RequiredRubyVersion.String(); req != ">= 0" {
+		pkg.Metadata["RequiredRuby"] = req
+	}
+	if req := spec.RequiredRubygemsVersion.String(); req != ">= 0" {
+		pkg.Metadata["RequiredRubygems"] = req
+	}
+	pkg.Metadata["Gemspec"] = spec
+
+	return pkg
+}
+
+// fullName returns the name gem gives a version, platform included
+func fullName(pkg models.Package) string {
+	return pkg.Name + "-" + platformVersion(pkg)
+}
+
+// platformVersion returns the version as listed by the compact index,
+// suffixed by its platform unless it is a pure Ruby gem
+func platformVersion(pkg models.Package) string {
+	if pkg.Architecture == "" || pkg.Architecture == rubyPlatform {
+		return pkg.Version
+	}
+	return pkg.Version + "-" + pkg.Architecture
+}
+
+// parseInfoFile reads back the versions listed by a compact index info file
+func parseInfoFile(path string) ([]models.Package, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	name := filepath.Base(path)
+	var packages []models.Package
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || line == "---" {
+			continue
+		}
+
+		// VERSION[-PLATFORM] DEP:REQ,DEP:REQ|checksum:SHA256,ruby:REQ,rubygems:REQ
+		head, reqs, ok := strings.Cut(line, "|")
+		if !ok {
+			return nil, fmt.Errorf("invalid info line %q", line)
+		}
+		version, deps, _ := strings.Cut(head, " ")
+
+		pkg := models.Package{
+			Name:         name,
+			Version:      version,
+			Architecture: rubyPlatform,
+			Metadata:     make(map[string]interface{}),
+		}
+		// Gem versions can't hold hyphens, platforms can
+		if v, platform, ok := strings.Cut(version, "-"); ok {
+			pkg.Version, pkg.Architecture = v, platform
+		}
+		if deps != "" {
+			pkg.Dependencies = strings.Split(deps, ",")
+		}
+		for _, req := range strings.Split(reqs, ",") {
+			key, value, _ := strings.Cut(req, ":")
+			switch key {
+			case "checksum":
+				pkg.SHA256Sum = value
+			case "ruby":
+				pkg.Metadata["RequiredRuby"] = value
+			case "rubygems":
+				pkg.Metadata["RequiredRubygems"] = value
+			}
+		}
+		pkg.Filename = filepath.Join(gemsDir, fullName(pkg)+".gem")
+		packages = append(packages, pkg)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return packages, nil
+}
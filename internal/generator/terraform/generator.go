@@ -0,0 +1,360 @@
+package terraform
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ralt/repogen/internal/events"
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/signer"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// providersPath is where the provider registry protocol is served from, as
+// announced by the service discovery document
+const providersPath = "v1/providers"
+
+// discoveryFile is the service discovery document terraform reads first
+const discoveryFile = ".well-known/terraform.json"
+
+// versionsDoc lists the versions of a provider
+type versionsDoc struct {
+	Versions []versionDoc `json:"versions"`
+}
+
+type versionDoc struct {
+	Version   string        `json:"version"`
+	Protocols []string      `json:"protocols"`
+	Platforms []platformDoc `json:"platforms"`
+}
+
+type platformDoc struct {
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+}
+
+// downloadDoc tells terraform where to get a provider build and how to
+// verify it
+type downloadDoc struct {
+	Protocols           []string       `json:"protocols"`
+	OS                  string         `json:"os"`
+	Arch                string         `json:"arch"`
+	Filename            string         `json:"filename"`
+	DownloadURL         string         `json:"download_url"`
+	ShasumsURL          string         `json:"shasums_url"`
+	ShasumsSignatureURL string         `json:"shasums_signature_url,omitempty"`
+	Shasum              string         `json:"shasum"`
+	SigningKeys         signingKeysDoc `json:"signing_keys"`
+}
+
+type signingKeysDoc struct {
+	GPGPublicKeys []gpgPublicKey `json:"gpg_public_keys"`
+}
+
+type gpgPublicKey struct {
+	KeyID      string `json:"key_id"`
+	ASCIIArmor string `json:"ascii_armor"`
+}
+
+// Generator implements the generator.Generator interface for Terraform
+// provider registries
+type Generator struct {
+	signer signer.Signer
+}
+
+// NewGenerator creates a new Terraform provider registry generator
+func NewGenerator(s signer.Signer) generator.Generator {
+	return &Generator{
+		signer: s,
+	}
+}
+
+// Generate copies the provider archives and writes the service discovery
+// document, then the versions of every provider and the download document
+// of every build, with the signed SHA256SUMS of every release
+func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfig, packages []models.Package) error {
+	logrus.Info("Generating Terraform provider registry...")
+
+	// Providers are addressed as <host>/<namespace>/<type>
+	namespace := utils.RepoSlug(config)
+	nsDir := filepath.Join(config.OutputDir, filepath.FromSlash(providersPath), namespace)
+
+	discovery, err := json.MarshalIndent(map[string]string{"providers.v1": "/" + providersPath + "/"}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFile(filepath.Join(config.OutputDir, filepath.FromSlash(discoveryFile)), append(discovery, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", discoveryFile, err)
+	}
+
+	keys := signingKeysDoc{GPGPublicKeys: []gpgPublicKey{}}
+	if g.signer != nil {
+		armored, err := g.signer.GetPublicKey()
+		if err != nil {
+			return fmt.Errorf("failed to get public key: %w", err)
+		}
+		fingerprint, err := signer.Fingerprint(g.signer)
+		if err != nil {
+			return err
+		}
+		keys.GPGPublicKeys = append(keys.GPGPublicKeys, gpgPublicKey{
+			KeyID:      fingerprint[len(fingerprint)-16:],
+			ASCIIArmor: string(armored),
+		})
+	} else {
+		logrus.Warn("terraform init only accepts signed providers, use --gpg-key to sign SHA256SUMS")
+	}
+
+	// Group builds by provider, then release
+	releases := make(map[string]map[string][]models.Package)
+	for i := range packages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pkg := &packages[i]
+		if err := publishPackage(ctx, config, namespace, pkg); err != nil {
+			return fmt.Errorf("failed to publish %s: %w", pkg.Name, err)
+		}
+		if releases[pkg.Name] == nil {
+			releases[pkg.Name] = make(map[string][]models.Package)
+		}
+		releases[pkg.Name][pkg.Version] = append(releases[pkg.Name][pkg.Version], *pkg)
+	}
+
+	// Providers whose last release was removed list no versions
+	existing, err := filepath.Glob(filepath.Join(nsDir, "*", "versions"))
+	if err != nil {
+		return err
+	}
+	for _, p := range existing {
+		if name := filepath.Base(filepath.Dir(p)); releases[name] == nil {
+			releases[name] = make(map[string][]models.Package)
+		}
+	}
+
+	for name, versions := range releases {
+		doc := versionsDoc{Versions: []versionDoc{}}
+		for version, builds := range versions {
+			sort.Slice(builds, func(i, j int) bool { return builds[i].Architecture < builds[j].Architecture })
+			if err := g.writeRelease(nsDir, name, version, builds, keys); err != nil {
+				return fmt.Errorf("failed to write %s %s: %w", name, version, err)
+			}
+
+			v := versionDoc{Version: version, Protocols: protocols(builds[0])}
+			for _, pkg := range builds {
+				goos, goarch := platform(pkg)
+				v.Platforms = append(v.Platforms, platformDoc{OS: goos, Arch: goarch})
+			}
+			doc.Versions = append(doc.Versions, v)
+		}
+		sort.Slice(doc.Versions, func(i, j int) bool {
+			return utils.CompareSemanticVersions(doc.Versions[i].Version, doc.Versions[j].Version) < 0
+		})
+
+		if err := writeJSON(filepath.Join(nsDir, name, "versions"), doc); err != nil {
+			return fmt.Errorf("failed to write versions of %s: %w", name, err)
+		}
+		logrus.Infof("Generated %s/%s (%d versions)", namespace, name, len(doc.Versions))
+	}
+
+	logrus.Infof("Terraform provider registry generated successfully (%d providers, %d builds)", len(releases), len(packages))
+	return nil
+}
+
+// writeRelease writes the SHA256SUMS of a release, its signature, and the
+// download document of each of its builds
+func (g *Generator) writeRelease(nsDir, name, version string, builds []models.Package, keys signingKeysDoc) error {
+	releaseDir := filepath.Join(nsDir, name, version)
+
+	var sums strings.Builder
+	for _, pkg := range builds {
+		fmt.Fprintf(&sums, "%s  %s\n", pkg.SHA256Sum, path.Base(pkg.Filename))
+	}
+	sumsName := fmt.Sprintf("terraform-provider-%s_%s_SHA256SUMS", name, version)
+	sumsPath := filepath.Join(releaseDir, sumsName)
+	if err := utils.WriteFile(sumsPath, []byte(sums.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", sumsName, err)
+	}
+
+	sigName := ""
+	if g.signer != nil {
+		// terraform verifies binary detached signatures, like goreleaser makes
+		signature, err := g.signer.SignDetachedBinary([]byte(sums.String()))
+		if err != nil {
+			return fmt.Errorf("failed to sign %s: %w", sumsName, err)
+		}
+		sigName = sumsName + ".sig"
+		if err := utils.WriteFile(sumsPath+".sig", signature, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", sigName, err)
+		}
+		events.Emit(events.Signed, events.Fields{"path": sumsPath + ".sig", "kind": "detached"})
+	}
+
+	// URLs are relative to the download document, <version>/download/<os>/<arch>
+	for _, pkg := range builds {
+		goos, goarch := platform(pkg)
+		doc := downloadDoc{
+			Protocols:   protocols(pkg),
+			OS:          goos,
+			Arch:        goarch,
+			Filename:    path.Base(pkg.Filename),
+			DownloadURL: "../../" + path.Base(pkg.Filename),
+			ShasumsURL:  "../../" + sumsName,
+			Shasum:      pkg.SHA256Sum,
+			SigningKeys: keys,
+		}
+		if sigName != "" {
+			doc.ShasumsSignatureURL = "../../" + sigName
+		}
+		if err := writeJSON(filepath.Join(releaseDir, "download", goos, goarch), doc); err != nil {
+			return fmt.Errorf("failed to write download document of %s: %w", pkg.Architecture, err)
+		}
+	}
+
+	return nil
+}
+
+// publishPackage copies a provider archive next to the documents of its
+// release, v1/providers/<namespace>/<type>/<version>/
+func publishPackage(ctx context.Context, config *models.RepositoryConfig, namespace string, pkg *models.Package) error {
+	rel := path.Join(providersPath, namespace, pkg.Name, pkg.Version, fileName(*pkg))
+	dstPath := filepath.Join(config.OutputDir, filepath.FromSlash(rel))
+
+	// Archives read back from the registry are published already, possibly
+	// only on remote storage
+	srcPath, finalDstPath, needsCopy, err := utils.ShouldCopyPackage(pkg, dstPath, config.OutputDir)
+	if err != nil {
+		return fmt.Errorf("package copy check failed: %w", err)
+	}
+
+	if needsCopy {
+		logrus.Debugf("Copying package: %s -> %s", srcPath, finalDstPath)
+
+		checksums, err := utils.CopyFileWithChecksums(ctx, srcPath, finalDstPath)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", srcPath, err)
+		}
+
+		if config.VerifyWrites {
+			if err := utils.VerifyCopy(finalDstPath, checksums); err != nil {
+				return fmt.Errorf("failed to verify %s: %w", srcPath, err)
+			}
+		}
+
+		pkg.Size = checksums.Size
+		pkg.MD5Sum = checksums.MD5
+		pkg.SHA1Sum = checksums.SHA1
+		pkg.SHA256Sum = checksums.SHA256
+	}
+
+	pkg.Filename = rel
+	return nil
+}
+
+// platform returns the os and arch of a provider build
+func platform(pkg models.Package) (string, string) {
+	goos, _ := pkg.Metadata["OS"].(string)
+	goarch, _ := pkg.Metadata["Arch"].(string)
+	return goos, goarch
+}
+
+// protocols returns the plugin protocols of a provider build
+func protocols(pkg models.Package) []string {
+	if p, ok := pkg.Metadata["Protocols"].([]string); ok && len(p) > 0 {
+		return p
+	}
+	return defaultProtocols
+}
+
+// writeJSON writes an indented JSON document
+func writeJSON(file string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFile(file, append(data, '\n'), 0644)
+}
+
+// ValidatePackages checks if packages are Terraform provider archives
+func (g *Generator) ValidatePackages(packages []models.Package) error {
+	for _, pkg := range packages {
+		if goos, goarch := platform(pkg); goos == "" || goarch == "" {
+			return fmt.Errorf("package %s is not a Terraform provider archive", pkg.Filename)
+		}
+	}
+	return nil
+}
+
+// GetSupportedType returns the package type this generator supports
+func (g *Generator) GetSupportedType() scanner.PackageType {
+	return scanner.TypeTerraform
+}
+
+// ParseExistingMetadata reads the builds listed in the download documents
+// of the providers of the namespace
+func (g *Generator) ParseExistingMetadata(config *models.RepositoryConfig) ([]models.Package, error) {
+	namespace := utils.RepoSlug(config)
+	nsDir := filepath.Join(config.OutputDir, filepath.FromSlash(providersPath), namespace)
+
+	files, err := filepath.Glob(filepath.Join(nsDir, "*", "versions"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var packages []models.Package
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var doc versionsDoc
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+
+		name := filepath.Base(filepath.Dir(file))
+		for _, v := range doc.Versions {
+			for _, p := range v.Platforms {
+				downloadPath := filepath.Join(nsDir, name, v.Version, "download", p.OS, p.Arch)
+				data, err := os.ReadFile(downloadPath)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read download document of %s %s %s_%s: %w", name, v.Version, p.OS, p.Arch, err)
+				}
+				var download downloadDoc
+				if err := json.Unmarshal(data, &download); err != nil {
+					return nil, fmt.Errorf("failed to read %s: %w", downloadPath, err)
+				}
+
+				pkg := providerPackage(name, v.Version, p.OS, p.Arch, download.Protocols)
+				pkg.Filename = path.Join(providersPath, namespace, name, v.Version, download.Filename)
+				pkg.SHA256Sum = download.Shasum
+				if info, err := os.Stat(filepath.Join(config.OutputDir, filepath.FromSlash(pkg.Filename))); err == nil {
+					pkg.Size = info.Size()
+				}
+				packages = append(packages, *pkg)
+			}
+		}
+	}
+
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no existing Terraform provider registry found in %s", config.OutputDir)
+	}
+
+	return packages, nil
+}
+
+// PackageFiles returns the path of a provider archive
+func (g *Generator) PackageFiles(config *models.RepositoryConfig, pkg models.Package) []string {
+	rel := path.Join(providersPath, utils.RepoSlug(config), pkg.Name, pkg.Version, fileName(pkg))
+	return []string{filepath.Join(config.OutputDir, filepath.FromSlash(rel))}
+}
@@ -0,0 +1,203 @@
+package terraform
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/signer"
+)
+
+// writeProvider writes a provider archive holding its executable
+func writeProvider(t *testing.T, dir, providerType, version, platform string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "terraform-provider-"+providerType+"_"+version+"_"+platform+".zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	fw, _ := w.Create("terraform-provider-" + providerType + "_v" + version)
+	fw.Write([]byte("\x7fELF " + platform))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newSigner(t *testing.T) (signer.Signer, openpgp.EntityList) {
+	t.Helper()
+
+	entity, err := openpgp.NewEntity("Repogen Test", "", "test@repogen.local", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keyBuf bytes.Buffer
+	w, _ := armor.Encode(&keyBuf, openpgp.PrivateKeyType, nil)
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	keyPath := filepath.Join(t.TempDir(), "key.asc")
+	os.WriteFile(keyPath, keyBuf.Bytes(), 0600)
+
+	gpgSigner, err := signer.NewGPGSigner(keyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gpgSigner, openpgp.EntityList{entity}
+}
+
+func readJSON(t *testing.T, path string, v interface{}) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("invalid %s: %v", path, err)
+	}
+}
+
+func TestParsePackage(t *testing.T) {
+	dir := t.TempDir()
+	manifest := `{"version": 1, "metadata": {"protocol_versions": ["6.0"]}}`
+	os.WriteFile(filepath.Join(dir, "terraform-provider-hello_1.2.0_manifest.json"), []byte(manifest), 0644)
+
+	pkg, err := ParsePackage(writeProvider(t, dir, "hello", "1.2.0", "linux_amd64"))
+	if err != nil {
+		t.Fatalf("ParsePackage failed: %v", err)
+	}
+	if pkg.Name != "hello" || pkg.Version != "1.2.0" || pkg.Architecture != "linux_amd64" {
+		t.Errorf("unexpected package %s %s %s", pkg.Name, pkg.Version, pkg.Architecture)
+	}
+	if p := protocols(*pkg); len(p) != 1 || p[0] != "6.0" {
+		t.Errorf("unexpected protocols %v", p)
+	}
+
+	// Without a manifest, providers speak protocol 5
+	pkg, err = ParsePackage(writeProvider(t, dir, "other", "0.1.0-beta.1", "darwin_arm64"))
+	if err != nil {
+		t.Fatalf("ParsePackage failed: %v", err)
+	}
+	if goos, goarch := platform(*pkg); goos != "darwin" || goarch != "arm64" || pkg.Version != "0.1.0-beta.1" {
+		t.Errorf("unexpected build %s %s %s", pkg.Version, goos, goarch)
+	}
+	if p := protocols(*pkg); len(p) != 1 || p[0] != "5.0" {
+		t.Errorf("unexpected protocols %v", p)
+	}
+
+	// The archive must hold the provider
+	empty := filepath.Join(dir, "terraform-provider-empty_1.0.0_linux_amd64.zip")
+	f, _ := os.Create(empty)
+	zip.NewWriter(f).Close()
+	f.Close()
+	if _, err := ParsePackage(empty); err == nil {
+		t.Error("expected an error for an archive without the provider")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputDir := filepath.Join(tmpDir, "output")
+
+	var packages []models.Package
+	for _, build := range []struct{ version, platform string }{
+		{"1.10.0", "linux_amd64"},
+		{"1.10.0", "darwin_arm64"},
+		{"1.2.0", "linux_amd64"},
+	} {
+		pkg, err := ParsePackage(writeProvider(t, tmpDir, "hello", build.version, build.platform))
+		if err != nil {
+			t.Fatalf("ParsePackage failed: %v", err)
+		}
+		packages = append(packages, *pkg)
+	}
+
+	gpgSigner, keyring := newSigner(t)
+	gen := NewGenerator(gpgSigner)
+	config := &models.RepositoryConfig{OutputDir: outputDir, Origin: "Example Corp"}
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	var discovery map[string]string
+	readJSON(t, filepath.Join(outputDir, ".well-known", "terraform.json"), &discovery)
+	if discovery["providers.v1"] != "/v1/providers/" {
+		t.Errorf("unexpected discovery document %v", discovery)
+	}
+
+	providerDir := filepath.Join(outputDir, "v1", "providers", "example-corp", "hello")
+	var versions versionsDoc
+	readJSON(t, filepath.Join(providerDir, "versions"), &versions)
+	if len(versions.Versions) != 2 || versions.Versions[1].Version != "1.10.0" || len(versions.Versions[1].Platforms) != 2 {
+		t.Fatalf("unexpected versions %+v", versions)
+	}
+
+	var download downloadDoc
+	readJSON(t, filepath.Join(providerDir, "1.10.0", "download", "linux", "amd64"), &download)
+	if download.Filename != "terraform-provider-hello_1.10.0_linux_amd64.zip" || download.Shasum != packages[0].SHA256Sum {
+		t.Errorf("unexpected download document %+v", download)
+	}
+	if len(download.SigningKeys.GPGPublicKeys) != 1 || download.SigningKeys.GPGPublicKeys[0].KeyID != keyring[0].PrimaryKey.KeyIdString() {
+		t.Errorf("unexpected signing keys %+v", download.SigningKeys)
+	}
+
+	// The URLs resolve from the download document to the release files
+	downloadDir := filepath.Join(providerDir, "1.10.0", "download", "linux")
+	for _, url := range []string{download.DownloadURL, download.ShasumsURL, download.ShasumsSignatureURL} {
+		if _, err := os.Stat(filepath.Join(downloadDir, filepath.FromSlash(url))); err != nil {
+			t.Errorf("%s does not resolve: %v", url, err)
+		}
+	}
+
+	// SHA256SUMS lists every build of the release, and is signed
+	sums, err := os.ReadFile(filepath.Join(downloadDir, filepath.FromSlash(download.ShasumsURL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(sums), packages[1].SHA256Sum+"  terraform-provider-hello_1.10.0_darwin_arm64.zip\n") || strings.Count(string(sums), "\n") != 2 {
+		t.Errorf("unexpected SHA256SUMS:\n%s", sums)
+	}
+	sig, err := os.ReadFile(filepath.Join(downloadDir, filepath.FromSlash(download.ShasumsSignatureURL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(sums), bytes.NewReader(sig), nil); err != nil {
+		t.Errorf("invalid SHA256SUMS signature: %v", err)
+	}
+
+	// Read back, then drop the 1.10.0 release
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 3 {
+		t.Fatalf("expected 3 builds, got %d", len(existing))
+	}
+	var kept []models.Package
+	for _, pkg := range existing {
+		if pkg.Version != "1.10.0" {
+			kept = append(kept, pkg)
+		}
+	}
+	if err := gen.Generate(context.Background(), config, kept); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	readJSON(t, filepath.Join(providerDir, "versions"), &versions)
+	if len(versions.Versions) != 1 || versions.Versions[0].Version != "1.2.0" {
+		t.Errorf("unexpected versions %+v", versions)
+	}
+}
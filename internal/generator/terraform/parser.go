@@ -0,0 +1,119 @@
+package terraform
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// archiveRe matches provider archives as terraform and goreleaser name them,
+// terraform-provider-<type>_<version>_<os>_<arch>.zip
+var archiveRe = regexp.MustCompile(`^terraform-provider-([a-z0-9-]+)_([^_]+)_([a-z0-9]+)_([a-z0-9]+)\.zip$`)
+
+// defaultProtocols are the plugin protocols of providers published without
+// a manifest, the one the plugin SDK and framework speak
+var defaultProtocols = []string{"5.0"}
+
+// providerManifest is the terraform-registry-manifest.json of a release,
+// published next to the archives as <name>_<version>_manifest.json
+type providerManifest struct {
+	Version  int `json:"version"`
+	Metadata struct {
+		ProtocolVersions []string `json:"protocol_versions"`
+	} `json:"metadata"`
+}
+
+// ParsePackage parses a provider archive and extracts metadata
+func ParsePackage(path string) (*models.Package, error) {
+	m := archiveRe.FindStringSubmatch(filepath.Base(path))
+	if m == nil {
+		return nil, fmt.Errorf("%s is not named terraform-provider-<type>_<version>_<os>_<arch>.zip", filepath.Base(path))
+	}
+	providerType, version, goos, goarch := m[1], m[2], m[3], m[4]
+
+	// Calculate checksums
+	checksums, err := utils.CalculateChecksums(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate checksums: %w", err)
+	}
+
+	// terraform runs the terraform-provider-<type>* executable of the archive
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open provider archive: %w", err)
+	}
+	defer zr.Close()
+	found := false
+	for _, f := range zr.File {
+		if !strings.Contains(f.Name, "/") && strings.HasPrefix(f.Name, "terraform-provider-"+providerType) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no terraform-provider-%s executable found in archive", providerType)
+	}
+
+	protocols, err := readProtocols(filepath.Dir(path), providerType, version)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := providerPackage(providerType, version, goos, goarch, protocols)
+	pkg.Filename = path
+	pkg.Size = checksums.Size
+	pkg.MD5Sum = checksums.MD5
+	pkg.SHA1Sum = checksums.SHA1
+	pkg.SHA256Sum = checksums.SHA256
+
+	return pkg, nil
+}
+
+// readProtocols returns the plugin protocols of a provider release from the
+// manifest next to its archives, or defaultProtocols without one
+func readProtocols(dir, providerType, version string) ([]string, error) {
+	name := fmt.Sprintf("terraform-provider-%s_%s_manifest.json", providerType, version)
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return defaultProtocols, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest providerManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if len(manifest.Metadata.ProtocolVersions) == 0 {
+		return defaultProtocols, nil
+	}
+	return manifest.Metadata.ProtocolVersions, nil
+}
+
+// providerPackage returns the package of a provider build. Its architecture
+// is the terraform platform, <os>_<arch>
+func providerPackage(providerType, version, goos, goarch string, protocols []string) *models.Package {
+	return &models.Package{
+		Name:         providerType,
+		Version:      version,
+		Architecture: goos + "_" + goarch,
+		Metadata: map[string]interface{}{
+			"OS":        goos,
+			"Arch":      goarch,
+			"Protocols": protocols,
+		},
+	}
+}
+
+// fileName returns the canonical file name of a provider build
+func fileName(pkg models.Package) string {
+	return fmt.Sprintf("terraform-provider-%s_%s_%s.zip", pkg.Name, pkg.Version, pkg.Architecture)
+}
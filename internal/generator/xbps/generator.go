@@ -0,0 +1,387 @@
+package xbps
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ralt/repogen/internal/events"
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/signer"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// repodataSuffix ends the index of each architecture, <arch>-repodata
+const repodataSuffix = "-repodata"
+
+// archNames maps --arch values to xbps architectures
+var archNames = map[string]string{
+	"amd64": "x86_64",
+	"arm64": "aarch64",
+	"i386":  "i686",
+	"386":   "i686",
+	"armhf": "armv7l",
+}
+
+// Generator implements the generator.Generator interface for xbps repositories
+type Generator struct {
+	rsaSigner signer.RSASigner
+}
+
+// NewGenerator creates a new xbps generator, signing packages with the RSA
+// key also used for Alpine repositories
+func NewGenerator(rsaSigner signer.RSASigner) generator.Generator {
+	return &Generator{
+		rsaSigner: rsaSigner,
+	}
+}
+
+// Generate copies the packages to the repository, signs them and writes the
+// <arch>-repodata of every architecture
+func (g *Generator) Generate(ctx context.Context, config *models.RepositoryConfig, packages []models.Package) error {
+	logrus.Info("Generating xbps repository...")
+
+	arches := make(map[string]bool)
+	for i := range packages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pkg := &packages[i]
+		if err := publishPackage(ctx, config, pkg); err != nil {
+			return fmt.Errorf("failed to publish %s: %w", pkg.Name, err)
+		}
+		if pkg.Architecture != noarch {
+			arches[pkg.Architecture] = true
+		}
+
+		// xbps-install checks the .sig2 of every package it downloads.
+		// Packages only on remote storage were signed when first published
+		pkgPath := filepath.Join(config.OutputDir, pkg.Filename)
+		if _, err := os.Stat(pkgPath); g.rsaSigner != nil && err == nil {
+			sig, err := g.rsaSigner.SignRSASHA256FromFile(pkgPath)
+			if err != nil {
+				return fmt.Errorf("failed to sign package %s: %w", pkg.Filename, err)
+			}
+			sigPath := pkgPath + ".sig2"
+			if err := utils.WriteFile(sigPath, sig, 0644); err != nil {
+				return fmt.Errorf("failed to write package signature: %w", err)
+			}
+			events.Emit(events.Signed, events.Fields{"path": sigPath, "kind": "detached"})
+		}
+	}
+
+	// Architectures whose last package was removed get an empty index
+	existing, err := filepath.Glob(filepath.Join(config.OutputDir, "*"+repodataSuffix))
+	if err != nil {
+		return err
+	}
+	for _, path := range existing {
+		arches[strings.TrimSuffix(filepath.Base(path), repodataSuffix)] = true
+	}
+	// A repository of noarch packages only is published for --arch
+	if len(arches) == 0 {
+		for _, arch := range config.Arches {
+			if name, ok := archNames[arch]; ok {
+				arch = name
+			}
+			arches[arch] = true
+		}
+	}
+
+	meta := dict{}
+	if g.rsaSigner != nil {
+		if meta, err = indexMeta(g.rsaSigner, config.Origin); err != nil {
+			return fmt.Errorf("failed to describe the signing key: %w", err)
+		}
+	} else {
+		logrus.Warn("xbps-install only accepts signed remote repositories, use --rsa-key to sign packages")
+	}
+
+	for arch := range arches {
+		index := dict{}
+		newest := make(map[string]*models.Package)
+		for i := range packages {
+			pkg := &packages[i]
+			if pkg.Architecture != arch && pkg.Architecture != noarch {
+				continue
+			}
+			// An index holds a single version of each package
+			if n, ok := newest[pkg.Name]; !ok || utils.CompareVersions(pkg.Version, n.Version) > 0 {
+				newest[pkg.Name] = pkg
+			}
+		}
+		for name, pkg := range newest {
+			index[name] = indexEntry(*pkg)
+		}
+
+		data, err := repodata(index, meta, utils.Timestamp(config))
+		if err != nil {
+			return fmt.Errorf("failed to generate %s%s: %w", arch, repodataSuffix, err)
+		}
+		path := filepath.Join(config.OutputDir, arch+repodataSuffix)
+		if err := utils.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s%s: %w", arch, repodataSuffix, err)
+		}
+		logrus.Infof("Generated index for %s (%d packages)", arch, len(index))
+	}
+
+	logrus.Infof("xbps repository generated successfully (%d packages)", len(packages))
+	return nil
+}
+
+// publishPackage copies a package to <pkgver>.<arch>.xbps
+func publishPackage(ctx context.Context, config *models.RepositoryConfig, pkg *models.Package) error {
+	rel := fileName(*pkg)
+	dstPath := filepath.Join(config.OutputDir, rel)
+
+	// Packages read back from the repodata are published already, possibly
+	// only on remote storage
+	srcPath, finalDstPath, needsCopy, err := utils.ShouldCopyPackage(pkg, dstPath, config.OutputDir)
+	if err != nil {
+		return fmt.Errorf("package copy check failed: %w", err)
+	}
+
+	if needsCopy {
+		logrus.Debugf("Copying package: %s -> %s", srcPath, finalDstPath)
+
+		checksums, err := utils.CopyFileWithChecksums(ctx, srcPath, finalDstPath)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", srcPath, err)
+		}
+
+		if config.VerifyWrites {
+			if err := utils.VerifyCopy(finalDstPath, checksums); err != nil {
+				return fmt.Errorf("failed to verify %s: %w", srcPath, err)
+			}
+		}
+
+		pkg.Size = checksums.Size
+		pkg.MD5Sum = checksums.MD5
+		pkg.SHA1Sum = checksums.SHA1
+		pkg.SHA256Sum = checksums.SHA256
+	}
+
+	pkg.Filename = rel
+	return nil
+}
+
+// indexEntry returns the index.plist entry of a package: its props.plist,
+// less what xbps-rindex drops, with the checksum and size of the file
+func indexEntry(pkg models.Package) dict {
+	entry := dict{}
+	if props, ok := pkg.Metadata["Props"].(dict); ok {
+		for k, v := range props {
+			entry[k] = v
+		}
+	}
+	delete(entry, "pkgname")
+	delete(entry, "version")
+	delete(entry, "packaged-with")
+
+	entry["pkgver"] = pkg.Name + "-" + pkg.Version
+	entry["architecture"] = pkg.Architecture
+	entry["filename-sha256"] = pkg.SHA256Sum
+	entry["filename-size"] = pkg.Size
+	return entry
+}
+
+// indexMeta returns the index-meta.plist announcing the key packages are
+// signed with, which xbps-install asks to import on first use
+func indexMeta(rsaSigner signer.RSASigner, signedBy string) (dict, error) {
+	pemKey, err := rsaSigner.GetPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not an RSA key")
+	}
+
+	if signedBy == "" {
+		signedBy = "repogen"
+	}
+	return dict{
+		"public-key":      pemKey,
+		"public-key-size": int64(rsaKey.N.BitLen()),
+		"signature-by":    signedBy,
+		"signature-type":  "rsa",
+	}, nil
+}
+
+// repodata returns the zstd compressed tar of index.plist and
+// index-meta.plist, modified at now
+func repodata(index, meta dict, now time.Time) ([]byte, error) {
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	for _, f := range []struct {
+		name string
+		d    dict
+	}{
+		{"index.plist", index},
+		{"index-meta.plist", meta},
+	} {
+		data, err := marshalPlist(f.d)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    f.name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	var compressedBuf bytes.Buffer
+	zw, err := zstd.NewWriter(&compressedBuf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(tarBuf.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return compressedBuf.Bytes(), nil
+}
+
+// readIndex reads the index.plist of an <arch>-repodata
+func readIndex(path string) (dict, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := decompress(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	files, err := readTarFiles(r, "index.plist")
+	if err != nil {
+		return nil, err
+	}
+	data, ok := files["index.plist"]
+	if !ok {
+		return nil, fmt.Errorf("no index.plist found")
+	}
+	return unmarshalPlist(data)
+}
+
+// ValidatePackages checks if packages are xbps packages
+func (g *Generator) ValidatePackages(packages []models.Package) error {
+	for _, pkg := range packages {
+		if _, ok := pkg.Metadata["Props"].(dict); !ok || !strings.HasSuffix(pkg.Filename, ".xbps") {
+			return fmt.Errorf("package %s is not an xbps package", pkg.Filename)
+		}
+	}
+	return nil
+}
+
+// GetSupportedType returns the package type this generator supports
+func (g *Generator) GetSupportedType() scanner.PackageType {
+	return scanner.TypeXbps
+}
+
+// ParseExistingMetadata reads the packages listed in the <arch>-repodata
+// files
+func (g *Generator) ParseExistingMetadata(config *models.RepositoryConfig) ([]models.Package, error) {
+	paths, err := filepath.Glob(filepath.Join(config.OutputDir, "*"+repodataSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	// noarch packages are listed in every index
+	seen := make(map[string]bool)
+	var packages []models.Package
+	for _, path := range paths {
+		index, err := readIndex(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+		}
+
+		names := make([]string, 0, len(index))
+		for name := range index {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			entry, ok := index[name].(dict)
+			if !ok {
+				continue
+			}
+			pkg, err := propsPackage(name, entry)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+			}
+			pkg.Filename = fileName(*pkg)
+			if seen[pkg.Filename] {
+				continue
+			}
+			seen[pkg.Filename] = true
+			pkg.SHA256Sum = entry.str("filename-sha256")
+			pkg.Size = entry.int("filename-size")
+			packages = append(packages, *pkg)
+		}
+	}
+
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no existing xbps repository found in %s", config.OutputDir)
+	}
+
+	return packages, nil
+}
+
+// PackageFiles returns the path of a package and of its signature
+func (g *Generator) PackageFiles(config *models.RepositoryConfig, pkg models.Package) []string {
+	path := filepath.Join(config.OutputDir, fileName(pkg))
+	return []string{path, path + ".sig2"}
+}
+
+// Requires returns the dependencies of pkg, read as package names
+func (g *Generator) Requires(pkg models.Package) [][]string {
+	var requires [][]string
+	for _, dep := range pkg.Dependencies {
+		requires = append(requires, []string{dep})
+	}
+	return requires
+}
+
+// Provides returns the name of pkg
+func (g *Generator) Provides(pkg models.Package) []string {
+	return []string{pkg.Name}
+}
@@ -0,0 +1,261 @@
+package xbps
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/signer"
+)
+
+// writeXbps writes a package holding the given props.plist, zstd compressed
+// like xbps-create does by default
+func writeXbps(t *testing.T, dir string, props dict) string {
+	t.Helper()
+
+	plist, err := marshalPlist(props)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{"./props.plist", plist},
+		{"./files.plist", []byte(plistHeader + "<dict/>\n</plist>\n")},
+		{"./usr/bin/" + props.str("pkgname"), []byte("#!/bin/sh\n")},
+	} {
+		tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data))})
+		tw.Write(f.data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var compressed bytes.Buffer
+	zw, _ := zstd.NewWriter(&compressed)
+	zw.Write(b.Bytes())
+	zw.Close()
+
+	path := filepath.Join(dir, props.str("pkgver")+"."+props.str("architecture")+".xbps")
+	if err := os.WriteFile(path, compressed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// helloProps returns the props.plist of a hello package
+func helloProps(version, arch string) dict {
+	return dict{
+		"pkgname":        "hello",
+		"version":        version,
+		"pkgver":         "hello-" + version,
+		"architecture":   arch,
+		"short_desc":     "Says hello",
+		"maintainer":     "Jane Doe <jane@example.com>",
+		"homepage":       "https://example.com/hello",
+		"license":        "MIT",
+		"build-date":     "2024-05-06 10:08 UTC",
+		"installed_size": int64(4096),
+		"run_depends":    []interface{}{"glibc>=2.32_1", "libfoo-1.0_1"},
+		"packaged-with":  "xbps-create-0.59.2",
+	}
+}
+
+func newSigner(t *testing.T) (signer.RSASigner, *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "repo.rsa")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := signer.NewAlpineRSASigner(keyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, key
+}
+
+// readRepodata returns the index.plist and index-meta.plist of an
+// <arch>-repodata
+func readRepodata(t *testing.T, path string) (dict, dict) {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := decompress(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	files, err := readTarFiles(r, "index.plist", "index-meta.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := unmarshalPlist(files["index.plist"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := unmarshalPlist(files["index-meta.plist"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return index, meta
+}
+
+func TestParsePackage(t *testing.T) {
+	pkg, err := ParsePackage(writeXbps(t, t.TempDir(), helloProps("1.2.0_1", "x86_64")))
+	if err != nil {
+		t.Fatalf("ParsePackage failed: %v", err)
+	}
+
+	if pkg.Name != "hello" || pkg.Version != "1.2.0_1" || pkg.Architecture != "x86_64" {
+		t.Errorf("unexpected package %s %s %s", pkg.Name, pkg.Version, pkg.Architecture)
+	}
+	if pkg.Description != "Says hello" || pkg.License != "MIT" || pkg.Homepage != "https://example.com/hello" {
+		t.Errorf("unexpected metadata %+v", pkg)
+	}
+	if len(pkg.Dependencies) != 2 || pkg.Dependencies[0] != "glibc" || pkg.Dependencies[1] != "libfoo" {
+		t.Errorf("unexpected dependencies %v", pkg.Dependencies)
+	}
+	if pkg.Metadata["BuildTime"] != int64(1714990080) {
+		t.Errorf("unexpected build time %v", pkg.Metadata["BuildTime"])
+	}
+	if fileName(*pkg) != "hello-1.2.0_1.x86_64.xbps" {
+		t.Errorf("unexpected file name %s", fileName(*pkg))
+	}
+}
+
+func TestPlistRoundTrip(t *testing.T) {
+	d := dict{
+		"name":    "a <b> & c",
+		"size":    int64(-3),
+		"enabled": true,
+		"key":     []byte{0, 1, 2, 0xff},
+		"list":    []interface{}{"x", int64(1), dict{}},
+		"nested":  dict{"empty": []interface{}{}},
+	}
+	data, err := marshalPlist(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := unmarshalPlist(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := marshalPlist(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Errorf("property list changed on a round trip:\n%s\n%s", data, again)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputDir := filepath.Join(tmpDir, "output")
+
+	var packages []models.Package
+	for _, props := range []dict{
+		helloProps("1.2.0_1", "x86_64"),
+		helloProps("1.10.0_1", "x86_64"),
+		helloProps("1.2.0_1", "aarch64"),
+		{"pkgname": "hello-doc", "pkgver": "hello-doc-1.0_2", "architecture": "noarch", "short_desc": "Hello documentation"},
+	} {
+		pkg, err := ParsePackage(writeXbps(t, tmpDir, props))
+		if err != nil {
+			t.Fatalf("ParsePackage failed: %v", err)
+		}
+		packages = append(packages, *pkg)
+	}
+
+	rsaSigner, key := newSigner(t)
+	gen := NewGenerator(rsaSigner)
+	config := &models.RepositoryConfig{OutputDir: outputDir, Origin: "Example"}
+	if err := gen.Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	index, meta := readRepodata(t, filepath.Join(outputDir, "x86_64-repodata"))
+	if len(index) != 2 {
+		t.Fatalf("expected 2 packages in the x86_64 index, got %d", len(index))
+	}
+	hello, _ := index["hello"].(dict)
+	if hello.str("pkgver") != "hello-1.10.0_1" || hello.str("filename-sha256") != packages[1].SHA256Sum || hello.int("filename-size") != packages[1].Size {
+		t.Errorf("unexpected entry %v", hello)
+	}
+	if _, ok := hello["pkgname"]; ok {
+		t.Error("index entries should not repeat the pkgname")
+	}
+	if hello.int("installed_size") != 4096 {
+		t.Errorf("props.plist not kept: %v", hello)
+	}
+	if meta.str("signature-type") != "rsa" || meta.str("signature-by") != "Example" || meta.int("public-key-size") != 2048 {
+		t.Errorf("unexpected index-meta.plist %v", meta)
+	}
+
+	// The noarch package is listed for every architecture
+	index, _ = readRepodata(t, filepath.Join(outputDir, "aarch64-repodata"))
+	if _, ok := index["hello-doc"]; !ok || len(index) != 2 {
+		t.Errorf("unexpected aarch64 index %v", index)
+	}
+
+	// Every package has a .sig2, the signature of its SHA-256
+	pkgPath := filepath.Join(outputDir, "hello-1.2.0_1.aarch64.xbps")
+	data, err := os.ReadFile(pkgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := os.ReadFile(pkgPath + ".sig2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		t.Errorf("invalid package signature: %v", err)
+	}
+
+	// Read back, then drop the aarch64 package
+	existing, err := gen.ParseExistingMetadata(config)
+	if err != nil {
+		t.Fatalf("ParseExistingMetadata failed: %v", err)
+	}
+	if len(existing) != 3 {
+		t.Fatalf("expected 3 packages, got %d", len(existing))
+	}
+	var kept []models.Package
+	for _, pkg := range existing {
+		if pkg.Architecture != "aarch64" {
+			kept = append(kept, pkg)
+		}
+	}
+	if err := gen.Generate(context.Background(), config, kept); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	index, _ = readRepodata(t, filepath.Join(outputDir, "aarch64-repodata"))
+	if _, ok := index["hello"]; ok || len(index) != 1 {
+		t.Errorf("expected only hello-doc in the aarch64 index, got %v", index)
+	}
+}
@@ -0,0 +1,194 @@
+package xbps
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/ulikunitz/xz"
+)
+
+// noarch is the architecture of packages installing everywhere
+const noarch = "noarch"
+
+// buildDateLayout is how xbps-create writes build-date
+const buildDateLayout = "2006-01-02 15:04 MST"
+
+// pkgverRe splits a pkgver (foo-1.0_1) into name and version_revision
+var pkgverRe = regexp.MustCompile(`^(.+)-([^-]+_[0-9]+)$`)
+
+// ParsePackage parses an xbps package and extracts metadata
+func ParsePackage(path string) (*models.Package, error) {
+	// Calculate checksums
+	checksums, err := utils.CalculateChecksums(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate checksums: %w", err)
+	}
+
+	data, err := extractProps(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract props.plist: %w", err)
+	}
+	props, err := unmarshalPlist(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid props.plist: %w", err)
+	}
+	if props.str("pkgname") == "" || props.str("pkgver") == "" || props.str("architecture") == "" {
+		return nil, fmt.Errorf("props.plist lacks a pkgname, pkgver or architecture")
+	}
+
+	pkg, err := propsPackage(props.str("pkgname"), props)
+	if err != nil {
+		return nil, err
+	}
+	pkg.Filename = path
+	pkg.Size = checksums.Size
+	pkg.MD5Sum = checksums.MD5
+	pkg.SHA1Sum = checksums.SHA1
+	pkg.SHA256Sum = checksums.SHA256
+
+	return pkg, nil
+}
+
+// extractProps returns the props.plist of a package
+func extractProps(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := decompress(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// props.plist is the first entry, but don't rely on it
+	files, err := readTarFiles(r, "props.plist")
+	if err != nil {
+		return nil, err
+	}
+	data, ok := files["props.plist"]
+	if !ok {
+		return nil, fmt.Errorf("no props.plist found in package")
+	}
+	return data, nil
+}
+
+// decompress returns the decompressed stream of packages and repodata: zstd
+// (the default), xz, gzip or bzip2
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(6)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x28, 0xB5, 0x2F, 0xFD}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}):
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	case bytes.HasPrefix(magic, []byte{0x1F, 0x8B}):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return io.NopCloser(bzip2.NewReader(br)), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression")
+	}
+}
+
+// readTarFiles returns the files of a tar stream with the given names
+func readTarFiles(r io.Reader, names ...string) (map[string][]byte, error) {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for len(files) < len(wanted) {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(header.Name, "./")
+		if !wanted[name] {
+			continue
+		}
+		if files[name], err = io.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// propsPackage returns the package described by the properties of a
+// package, from its props.plist or its repository index entry
+func propsPackage(name string, props dict) (*models.Package, error) {
+	m := pkgverRe.FindStringSubmatch(props.str("pkgver"))
+	if m == nil || m[1] != name {
+		return nil, fmt.Errorf("invalid pkgver %q", props.str("pkgver"))
+	}
+
+	pkg := &models.Package{
+		Name:         name,
+		Version:      m[2],
+		Architecture: props.str("architecture"),
+		Description:  props.str("short_desc"),
+		Maintainer:   props.str("maintainer"),
+		Homepage:     props.str("homepage"),
+		License:      props.str("license"),
+		Metadata: map[string]interface{}{
+			"Props": props,
+		},
+	}
+	for _, dep := range props.strs("run_depends") {
+		pkg.Dependencies = append(pkg.Dependencies, patternName(dep))
+	}
+	for _, c := range props.strs("conflicts") {
+		pkg.Conflicts = append(pkg.Conflicts, patternName(c))
+	}
+	if t, err := time.Parse(buildDateLayout, props.str("build-date")); err == nil {
+		pkg.Metadata["BuildTime"] = t.Unix()
+	}
+
+	return pkg, nil
+}
+
+// patternName returns the package name of a dependency pattern: glibc>=2.32_1,
+// foo-1.0_1, or a plain name
+func patternName(pattern string) string {
+	if i := strings.IndexAny(pattern, "<>="); i > 0 {
+		return pattern[:i]
+	}
+	if m := pkgverRe.FindStringSubmatch(pattern); m != nil {
+		return m[1]
+	}
+	return pattern
+}
+
+// fileName returns the canonical file name of a package,
+// <pkgver>.<arch>.xbps as xbps-create names them
+func fileName(pkg models.Package) string {
+	return fmt.Sprintf("%s-%s.%s.xbps", pkg.Name, pkg.Version, pkg.Architecture)
+}
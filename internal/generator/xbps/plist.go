@@ -0,0 +1,226 @@
+package xbps
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dict is a property list dictionary. Values are dicts, []interface{},
+// strings, int64, bools and []byte (data)
+type dict map[string]interface{}
+
+// str returns a string value of a dictionary
+func (d dict) str(key string) string {
+	s, _ := d[key].(string)
+	return s
+}
+
+// strs returns an array of strings of a dictionary
+func (d dict) strs(key string) []string {
+	values, _ := d[key].([]interface{})
+	var out []string
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// int returns an integer value of a dictionary
+func (d dict) int(key string) int64 {
+	n, _ := d[key].(int64)
+	return n
+}
+
+const plistHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple Computer//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+`
+
+// marshalPlist encodes a dictionary as an XML property list, with sorted
+// keys and tab indentation like xbps' proplib
+func marshalPlist(d dict) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(plistHeader)
+	if err := writeValue(&b, d, 0); err != nil {
+		return nil, err
+	}
+	b.WriteString("</plist>\n")
+	return b.Bytes(), nil
+}
+
+func writeValue(b *bytes.Buffer, v interface{}, depth int) error {
+	indent := strings.Repeat("\t", depth)
+	switch v := v.(type) {
+	case dict:
+		if len(v) == 0 {
+			b.WriteString(indent + "<dict/>\n")
+			return nil
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteString(indent + "<dict>\n")
+		for _, k := range keys {
+			b.WriteString(indent + "\t<key>")
+			xml.EscapeText(b, []byte(k))
+			b.WriteString("</key>\n")
+			if err := writeValue(b, v[k], depth+1); err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+		}
+		b.WriteString(indent + "</dict>\n")
+	case map[string]interface{}:
+		return writeValue(b, dict(v), depth)
+	case []interface{}:
+		if len(v) == 0 {
+			b.WriteString(indent + "<array/>\n")
+			return nil
+		}
+		b.WriteString(indent + "<array>\n")
+		for _, item := range v {
+			if err := writeValue(b, item, depth+1); err != nil {
+				return err
+			}
+		}
+		b.WriteString(indent + "</array>\n")
+	case string:
+		b.WriteString(indent + "<string>")
+		xml.EscapeText(b, []byte(v))
+		b.WriteString("</string>\n")
+	case int64:
+		fmt.Fprintf(b, "%s<integer>%d</integer>\n", indent, v)
+	case bool:
+		if v {
+			b.WriteString(indent + "<true/>\n")
+		} else {
+			b.WriteString(indent + "<false/>\n")
+		}
+	case []byte:
+		fmt.Fprintf(b, "%s<data>%s</data>\n", indent, base64.StdEncoding.EncodeToString(v))
+	default:
+		return fmt.Errorf("unsupported property list value %T", v)
+	}
+	return nil
+}
+
+// unmarshalPlist decodes an XML property list holding a dictionary
+func unmarshalPlist(data []byte) (dict, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("property list has no dictionary")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local != "plist" {
+			v, err := readValue(dec, start)
+			if err != nil {
+				return nil, err
+			}
+			d, ok := v.(dict)
+			if !ok {
+				return nil, fmt.Errorf("property list holds a %s, not a dictionary", start.Name.Local)
+			}
+			return d, nil
+		}
+	}
+}
+
+// readValue decodes the value starting with start
+func readValue(dec *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	switch start.Name.Local {
+	case "dict":
+		d := make(dict)
+		var key string
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.EndElement:
+				return d, nil
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					if key, err = readText(dec); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				v, err := readValue(dec, t)
+				if err != nil {
+					return nil, err
+				}
+				d[key] = v
+			}
+		}
+	case "array":
+		values := []interface{}{}
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.EndElement:
+				return values, nil
+			case xml.StartElement:
+				v, err := readValue(dec, t)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, v)
+			}
+		}
+	case "string":
+		return readText(dec)
+	case "integer":
+		text, err := readText(dec)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseInt(strings.TrimSpace(text), 0, 64)
+	case "true", "false":
+		if err := dec.Skip(); err != nil {
+			return nil, err
+		}
+		return start.Name.Local == "true", nil
+	case "data":
+		text, err := readText(dec)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	default:
+		return nil, fmt.Errorf("unsupported property list element <%s>", start.Name.Local)
+	}
+}
+
+// readText returns the text of the current element, consuming its end
+func readText(dec *xml.Decoder) (string, error) {
+	var b strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			b.Write(t)
+		case xml.EndElement:
+			return b.String(), nil
+		}
+	}
+}
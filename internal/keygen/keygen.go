@@ -0,0 +1,302 @@
+package keygen
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/ralt/repogen/internal/signer"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Key types
+const (
+	TypeGPG     = "gpg"     // OpenPGP, for Debian, RPM and Pacman repositories
+	TypeRSA     = "rsa"     // PEM RSA, for Alpine, F-Droid and xbps repositories
+	TypeEd25519 = "ed25519" // usign/signify Ed25519, for opkg feeds and minisign signatures
+)
+
+// DefaultBits is the size of new RSA keys
+const DefaultBits = 4096
+
+// minBits is the smallest RSA key apt and dnf still accept
+const minBits = 2048
+
+// Options describe the key to generate
+type Options struct {
+	Type       string
+	Name       string    // User ID name of GPG keys, and base name of the key files
+	Email      string    // User ID email of GPG keys
+	Bits       int       // Size of RSA and GPG keys
+	Passphrase string    // Encrypts GPG and RSA private keys when set
+	Created    time.Time // Creation time of GPG keys, now when zero
+}
+
+// ValidateType checks that keyType is a known key type
+func ValidateType(keyType string) error {
+	switch keyType {
+	case TypeGPG, TypeRSA, TypeEd25519:
+		return nil
+	default:
+		return fmt.Errorf("invalid key type %q (expected %s, %s or %s)", keyType, TypeGPG, TypeRSA, TypeEd25519)
+	}
+}
+
+// Validate checks the options
+func (o *Options) Validate() error {
+	if err := ValidateType(o.Type); err != nil {
+		return err
+	}
+	if utils.Slug(o.Name) == "" {
+		return fmt.Errorf("a key name is required")
+	}
+	if o.Type != TypeEd25519 && o.Bits < minBits {
+		return fmt.Errorf("keys of %d bits are too small: at least %d are required", o.Bits, minBits)
+	}
+	if o.Type == TypeEd25519 && o.Passphrase != "" {
+		return fmt.Errorf("usign keys can't be encrypted with a passphrase")
+	}
+	return nil
+}
+
+// GPG generates an OpenPGP RSA key signing with SHA-256, and returns the
+// armored private and public keys
+func GPG(o Options) (private, public []byte, err error) {
+	if o.Created.IsZero() {
+		o.Created = time.Now()
+	}
+	config := &packet.Config{
+		Algorithm:   packet.PubKeyAlgoRSA,
+		RSABits:     o.Bits,
+		DefaultHash: crypto.SHA256,
+		Time:        func() time.Time { return o.Created },
+	}
+	entity, err := openpgp.NewEntity(o.Name, "", o.Email, config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate GPG key: %w", err)
+	}
+
+	var pub bytes.Buffer
+	w, err := armor.Encode(&pub, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := entity.Serialize(w); err != nil {
+		return nil, nil, err
+	}
+	w.Close()
+
+	if o.Passphrase != "" {
+		if err := entity.EncryptPrivateKeys([]byte(o.Passphrase), config); err != nil {
+			return nil, nil, fmt.Errorf("failed to encrypt GPG key: %w", err)
+		}
+	}
+	var priv bytes.Buffer
+	w, err = armor.Encode(&priv, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := entity.SerializePrivateWithoutSigning(w, config); err != nil {
+		return nil, nil, err
+	}
+	w.Close()
+
+	return priv.Bytes(), pub.Bytes(), nil
+}
+
+// RSA generates an RSA key, and returns the PKCS#1 private key and the PKIX
+// public key in PEM, as abuild-keygen writes them
+func RSA(o Options) (private, public []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, o.Bits)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate RSA key: %w", err)
+	}
+
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if o.Passphrase != "" {
+		// Legacy encrypted PEM, as the RSA signer and OpenSSL read it
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(o.Passphrase), x509.PEMCipherAES256)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encrypt RSA key: %w", err)
+		}
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(block), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// Ed25519 generates a usign key, and returns the secret and public key
+// files with the fingerprint opkg names the installed public key after
+func Ed25519() (secret, public []byte, fingerprint string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to generate Ed25519 key: %w", err)
+	}
+
+	// usign picks a random fingerprint, and checksums the secret key
+	fp := make([]byte, 8)
+	salt := make([]byte, 16)
+	if _, err := rand.Read(fp); err != nil {
+		return nil, nil, "", err
+	}
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, "", err
+	}
+	checksum := sha512.Sum512(priv)
+	fingerprint = hex.EncodeToString(fp)
+
+	// pkalg, kdfalg, kdfrounds (0: unencrypted), salt, checksum, fingerprint, key
+	var sec bytes.Buffer
+	sec.WriteString("EdBK")
+	sec.Write([]byte{0, 0, 0, 0})
+	sec.Write(salt)
+	sec.Write(checksum[:8])
+	sec.Write(fp)
+	sec.Write(priv)
+
+	// pkalg, fingerprint, key
+	var pk bytes.Buffer
+	pk.WriteString("Ed")
+	pk.Write(fp)
+	pk.Write(pub)
+
+	secret = []byte("untrusted comment: private key " + fingerprint + "\n" + base64.StdEncoding.EncodeToString(sec.Bytes()) + "\n")
+	public = []byte("untrusted comment: public key " + fingerprint + "\n" + base64.StdEncoding.EncodeToString(pk.Bytes()) + "\n")
+	return secret, public, fingerprint, nil
+}
+
+// File is a generated key file
+type File struct {
+	Path    string
+	Private bool
+}
+
+// Files returns the files Write creates for a key of keyType named name in
+// dir, the private key first
+//
+//   - gpg: <name>.private.asc, and the public key as <name>.asc, .gpg and
+//     .kbx, as published in the keys/ directory of repositories
+//   - rsa: <name>.rsa and <name>.rsa.pub, the name apk expects in /etc/apk/keys
+//   - ed25519: <name>.sec and <name>.pub
+func Files(keyType, dir, name string) []File {
+	base := filepath.Join(dir, utils.Slug(name))
+	switch keyType {
+	case TypeGPG:
+		return []File{
+			{Path: base + ".private" + signer.KeyArmored, Private: true},
+			{Path: base + signer.KeyArmored},
+			{Path: base + signer.KeyBinary},
+			{Path: base + signer.KeyKeybox},
+		}
+	case TypeRSA:
+		return []File{{Path: base + ".rsa", Private: true}, {Path: base + ".rsa.pub"}}
+	case TypeEd25519:
+		return []File{{Path: base + ".sec", Private: true}, {Path: base + ".pub"}}
+	}
+	return nil
+}
+
+// Write generates the key described by o into the Files of dir, and returns
+// them with the fingerprint of the key. Private keys are only readable by
+// their owner. Existing files are only replaced when force is set
+func Write(o Options, dir string, force bool) ([]File, string, error) {
+	if err := o.Validate(); err != nil {
+		return nil, "", err
+	}
+	if o.Created.IsZero() {
+		o.Created = time.Now()
+	}
+
+	files := Files(o.Type, dir, o.Name)
+	if !force {
+		var existing []string
+		for _, f := range files {
+			if _, err := os.Lstat(f.Path); err == nil {
+				existing = append(existing, f.Path)
+			}
+		}
+		if len(existing) > 0 {
+			return nil, "", fmt.Errorf("key files already exist: %s", strings.Join(existing, ", "))
+		}
+	}
+
+	var contents [][]byte
+	var fingerprint string
+	switch o.Type {
+	case TypeGPG:
+		private, public, err := GPG(o)
+		if err != nil {
+			return nil, "", err
+		}
+		binaryKey, err := signer.Dearmor(public)
+		if err != nil {
+			return nil, "", err
+		}
+		keybox, err := signer.Keybox(public, o.Created)
+		if err != nil {
+			return nil, "", err
+		}
+		fingerprint, err = gpgFingerprint(public)
+		if err != nil {
+			return nil, "", err
+		}
+		contents = [][]byte{private, public, binaryKey, keybox}
+	case TypeRSA:
+		private, public, err := RSA(o)
+		if err != nil {
+			return nil, "", err
+		}
+		contents = [][]byte{private, public}
+	case TypeEd25519:
+		secret, public, fp, err := Ed25519()
+		if err != nil {
+			return nil, "", err
+		}
+		fingerprint = fp
+		contents = [][]byte{secret, public}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, "", err
+	}
+	for i, f := range files {
+		perm := os.FileMode(0644)
+		if f.Private {
+			perm = 0600
+		}
+		// Replaced rather than rewritten, so the mode of an existing file
+		// can't leak into a new private key
+		os.Remove(f.Path)
+		if err := os.WriteFile(f.Path, contents[i], perm); err != nil {
+			return nil, "", fmt.Errorf("failed to write %s: %w", f.Path, err)
+		}
+	}
+	return files, fingerprint, nil
+}
+
+// gpgFingerprint returns the fingerprint of an armored public key
+func gpgFingerprint(public []byte) (string, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(public))
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(entities[0].PrimaryKey.Fingerprint)), nil
+}
@@ -0,0 +1,184 @@
+package keygen
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ralt/repogen/internal/signer"
+)
+
+// readKeyFile returns the base64 payload of a usign key file
+func readKeyFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "untrusted comment: ") {
+		t.Fatalf("%s is not a usign key file:\n%s", path, data)
+	}
+	payload, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil {
+		t.Fatalf("Invalid base64 in %s: %v", path, err)
+	}
+	return payload
+}
+
+func TestWriteGPGKey(t *testing.T) {
+	for _, passphrase := range []string{"", "secret"} {
+		dir := t.TempDir()
+		files, fingerprint, err := Write(Options{Type: TypeGPG, Name: "Test Repo", Email: "repo@example.com", Bits: 2048, Passphrase: passphrase}, dir, false)
+		if err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if len(files) != 4 || filepath.Base(files[0].Path) != "test-repo.private.asc" || len(fingerprint) != 40 {
+			t.Fatalf("Wrote %+v with fingerprint %s", files, fingerprint)
+		}
+		if info, err := os.Stat(files[0].Path); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("Private key mode is %v: %v", info.Mode(), err)
+		}
+
+		// The private key signs for the published public key
+		s, err := signer.NewGPGSigner(files[0].Path, passphrase)
+		if err != nil {
+			t.Fatalf("NewGPGSigner failed: %v", err)
+		}
+		if got, err := signer.Fingerprint(s); err != nil || got != fingerprint {
+			t.Errorf("Signer fingerprint is %s, expected %s: %v", got, fingerprint, err)
+		}
+		sig, err := s.SignDetached([]byte("Release"))
+		if err != nil {
+			t.Fatalf("SignDetached failed: %v", err)
+		}
+		public, err := os.ReadFile(filepath.Join(dir, "test-repo.gpg"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyring, err := openpgp.ReadKeyRing(bytes.NewReader(public))
+		if err != nil {
+			t.Fatalf("Binary public key is invalid: %v", err)
+		}
+		if _, err := openpgp.CheckArmoredDetachedSignature(keyring, strings.NewReader("Release"), bytes.NewReader(sig), nil); err != nil {
+			t.Errorf("Signature doesn't verify: %v", err)
+		}
+		for id := range keyring[0].Identities {
+			if id != "Test Repo <repo@example.com>" {
+				t.Errorf("User ID is %q", id)
+			}
+		}
+	}
+}
+
+func TestWriteRSAKey(t *testing.T) {
+	for _, passphrase := range []string{"", "secret"} {
+		dir := t.TempDir()
+		files, _, err := Write(Options{Type: TypeRSA, Name: "alpine", Bits: 2048, Passphrase: passphrase}, dir, false)
+		if err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if filepath.Base(files[0].Path) != "alpine.rsa" || filepath.Base(files[1].Path) != "alpine.rsa.pub" {
+			t.Fatalf("Wrote %+v", files)
+		}
+
+		s, err := signer.NewAlpineRSASigner(files[0].Path, passphrase)
+		if err != nil {
+			t.Fatalf("NewAlpineRSASigner failed: %v", err)
+		}
+		got, err := s.GetPublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		public, err := os.ReadFile(files[1].Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, public) {
+			t.Errorf("Public key doesn't match the private key")
+		}
+	}
+}
+
+func TestWriteEd25519Key(t *testing.T) {
+	dir := t.TempDir()
+	files, fingerprint, err := Write(Options{Type: TypeEd25519, Name: "feed"}, dir, false)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	sec := readKeyFile(t, files[0].Path)
+	pub := readKeyFile(t, files[1].Path)
+	if len(sec) != 104 || string(sec[:4]) != "EdBK" || len(pub) != 42 || string(pub[:2]) != "Ed" {
+		t.Fatalf("Key sizes are %d and %d", len(sec), len(pub))
+	}
+
+	// Both files carry the fingerprint; the secret key is checksummed
+	if hex.EncodeToString(sec[32:40]) != fingerprint || hex.EncodeToString(pub[2:10]) != fingerprint {
+		t.Errorf("Fingerprints differ from %s", fingerprint)
+	}
+	if line := readFirstLine(t, files[1].Path); line != "untrusted comment: public key "+fingerprint {
+		t.Errorf("Public key comment is %q", line)
+	}
+	priv := ed25519.PrivateKey(sec[40:])
+	sum := sha512.Sum512(priv)
+	if !bytes.Equal(sec[24:32], sum[:8]) {
+		t.Errorf("Secret key checksum is wrong")
+	}
+	sig := ed25519.Sign(priv, []byte("Packages"))
+	if !ed25519.Verify(ed25519.PublicKey(pub[10:]), []byte("Packages"), sig) {
+		t.Errorf("Public key doesn't match the secret key")
+	}
+}
+
+func readFirstLine(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	return line
+}
+
+func TestWriteKeepsExistingKeys(t *testing.T) {
+	dir := t.TempDir()
+	o := Options{Type: TypeEd25519, Name: "feed"}
+	if _, _, err := Write(o, dir, false); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	before, _ := os.ReadFile(filepath.Join(dir, "feed.sec"))
+
+	if _, _, err := Write(o, dir, false); err == nil || !strings.Contains(err.Error(), "already exist") {
+		t.Errorf("Overwriting returned %v", err)
+	}
+	if after, _ := os.ReadFile(filepath.Join(dir, "feed.sec")); !bytes.Equal(before, after) {
+		t.Error("Existing key was replaced")
+	}
+	if _, _, err := Write(o, dir, true); err != nil {
+		t.Errorf("Forced overwrite failed: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, o := range []Options{
+		{Type: "dsa", Name: "repo", Bits: 4096},
+		{Type: TypeGPG, Name: "", Bits: 4096},
+		{Type: TypeRSA, Name: "repo", Bits: 1024},
+		{Type: TypeEd25519, Name: "repo", Passphrase: "secret"},
+	} {
+		if err := o.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded", o)
+		}
+	}
+	if err := (&Options{Type: TypeEd25519, Name: "repo"}).Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+// Package largefiles writes download aids next to large package files:
+// zsync control files, so updates only download the blocks that changed,
+// Metalink descriptions listing the mirrors of the repository, and
+// BitTorrent metainfo seeded from them
+package largefiles
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Download aids
+const (
+	AidZsync    = "zsync"
+	AidMetalink = "metalink"
+	AidTorrent  = "torrent"
+)
+
+// Suffixes of the download aids of a file
+const (
+	ZsyncExt    = ".zsync"
+	MetalinkExt = ".meta4"
+	TorrentExt  = ".torrent"
+)
+
+// extensions are the suffixes of each download aid
+var extensions = map[string]string{
+	AidZsync:    ZsyncExt,
+	AidMetalink: MetalinkExt,
+	AidTorrent:  TorrentExt,
+}
+
+// ValidateAids checks the download aids requested
+func ValidateAids(aids []string) error {
+	for _, aid := range aids {
+		if _, ok := extensions[aid]; !ok {
+			return fmt.Errorf("invalid download aid %q (expected %s, %s or %s)", aid, AidZsync, AidMetalink, AidTorrent)
+		}
+	}
+	return nil
+}
+
+// Options configures the download aids written
+type Options struct {
+	Aids     []string
+	BaseURLs []string  // Where the repository is served, preferred first: its own URL, then its mirrors
+	Trackers []string  // Trackers torrents are announced to
+	Time     time.Time // Recorded as the creation time of the aids
+}
+
+// Write writes the download aids of the file at rel, a slash-separated path
+// relative to repoDir, next to it, and returns the files written. Aids
+// written since the file last changed are left alone
+func Write(repoDir, rel string, opts Options) ([]string, error) {
+	path := filepath.Join(repoDir, filepath.FromSlash(rel))
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var urls, torrents []string
+	for _, base := range opts.BaseURLs {
+		url := strings.TrimRight(base, "/") + "/" + rel
+		urls = append(urls, url)
+		if has(opts.Aids, AidTorrent) {
+			torrents = append(torrents, url+TorrentExt)
+		}
+	}
+
+	var written []string
+	for _, aid := range []string{AidZsync, AidTorrent, AidMetalink} {
+		if !has(opts.Aids, aid) {
+			continue
+		}
+		target := path + extensions[aid]
+		if upToDate(target, info) {
+			continue
+		}
+
+		var data []byte
+		switch aid {
+		case AidZsync:
+			data, err = Zsync(path, filepath.Base(path), info.ModTime())
+		case AidTorrent:
+			data, err = Torrent(path, urls, opts.Trackers, opts.Time)
+		case AidMetalink:
+			var checksum *utils.Checksum
+			if checksum, err = utils.CalculateChecksums(path); err == nil {
+				data, err = NewMetalink(info.Name(), info.Size(), checksum.SHA256, urls, torrents, opts.Time)
+			}
+		}
+		if err != nil {
+			return written, fmt.Errorf("failed to generate %s of %s: %w", aid, rel, err)
+		}
+		if err := utils.WriteFile(target, data, 0644); err != nil {
+			return written, err
+		}
+		written = append(written, target)
+	}
+	return written, nil
+}
+
+// upToDate reports whether the aid at path was written since the file
+// described by info last changed
+func upToDate(path string, info os.FileInfo) bool {
+	aid, err := os.Stat(path)
+	return err == nil && !aid.ModTime().Before(info.ModTime())
+}
+
+func has(aids []string, aid string) bool {
+	for _, a := range aids {
+		if a == aid {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveStale removes the download aids of files no longer in repoDir,
+// skipping the directories of skip relative to it, and returns how many
+// it removed
+func RemoveStale(repoDir string, skip ...string) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(repoDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(repoDir, p)
+		if d.IsDir() {
+			for _, dir := range skip {
+				if rel == dir {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if utils.IsInternalPath(filepath.ToSlash(rel)) {
+			return nil
+		}
+		for _, ext := range extensions {
+			if !strings.HasSuffix(d.Name(), ext) {
+				continue
+			}
+			if _, err := os.Stat(strings.TrimSuffix(p, ext)); !os.IsNotExist(err) {
+				return nil
+			}
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,183 @@
+package largefiles
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writePackage writes a package file of size bytes at rel in dir
+func writePackage(t *testing.T, dir, rel string, size int) string {
+	t.Helper()
+	path := filepath.Join(dir, filepath.FromSlash(rel))
+	os.MkdirAll(filepath.Dir(path), 0755)
+	data := bytes.Repeat([]byte("repogen!"), size/8+1)[:size]
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestZsync(t *testing.T) {
+	path := writePackage(t, t.TempDir(), "big.deb", 5000)
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	control, err := Zsync(path, "big.deb", mtime)
+	if err != nil {
+		t.Fatalf("Zsync failed: %v", err)
+	}
+	header, blocks, ok := bytes.Cut(control, []byte("\n\n"))
+	if !ok {
+		t.Fatalf("No header end in %q", control)
+	}
+	data, _ := os.ReadFile(path)
+	for _, line := range []string{
+		"zsync: 0.6.2",
+		"Filename: big.deb",
+		"MTime: Tue, 02 Jan 2024 03:04:05 +0000",
+		"Blocksize: 2048",
+		"Length: 5000",
+		"Hash-Lengths: 2,2,3",
+		"URL: big.deb",
+		"SHA-1: " + hexSHA1(data),
+	} {
+		if !strings.Contains(string(header)+"\n", line+"\n") {
+			t.Errorf("Header lacks %q:\n%s", line, header)
+		}
+	}
+
+	// 3 blocks, each with 2 bytes of rolling checksum and 3 of MD4
+	if len(blocks) != 3*(2+3) {
+		t.Fatalf("Block checksums take %d bytes", len(blocks))
+	}
+	a, b := rollingChecksum(data[:2048])
+	if !bytes.Equal(blocks[:2], []byte{byte(b >> 8), byte(b)}) {
+		t.Errorf("First rolling checksum = %x, want %04x%04x truncated", blocks[:2], a, b)
+	}
+}
+
+func hexSHA1(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestZsyncHashLengths(t *testing.T) {
+	tests := []struct {
+		length          int64
+		blockSize       int
+		seq, rsum, csum int
+	}{
+		{1000, 2048, 1, 2, 4},
+		{100 << 20, 4096, 2, 2, 5},
+		{1 << 30, 4096, 2, 3, 5},
+	}
+	for _, tt := range tests {
+		seq, rsum, csum := zsyncHashLengths(tt.length, tt.blockSize)
+		if seq != tt.seq || rsum != tt.rsum || csum != tt.csum {
+			t.Errorf("zsyncHashLengths(%d, %d) = %d,%d,%d, want %d,%d,%d", tt.length, tt.blockSize, seq, rsum, csum, tt.seq, tt.rsum, tt.csum)
+		}
+	}
+}
+
+func TestTorrent(t *testing.T) {
+	path := writePackage(t, t.TempDir(), "big.rpm", minPieceLength+10)
+	created := time.Unix(1700000000, 0)
+
+	torrent, err := Torrent(path, []string{"https://example.com/big.rpm"}, []string{"udp://tracker.example.com:1337"}, created)
+	if err != nil {
+		t.Fatalf("Torrent failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	first, second := sha1.Sum(data[:minPieceLength]), sha1.Sum(data[minPieceLength:])
+	want := "d8:announce30:udp://tracker.example.com:133713:announce-listll30:udp://tracker.example.com:1337ee" +
+		"10:created by7:repogen13:creation datei1700000000e" +
+		"4:infod6:lengthi262154e4:name7:big.rpm12:piece lengthi262144e6:pieces40:" + string(first[:]) + string(second[:]) + "e" +
+		"8:url-listl27:https://example.com/big.rpmee"
+	if string(torrent) != want {
+		t.Errorf("Torrent = %q\nwant %q", torrent, want)
+	}
+}
+
+func TestMetalink(t *testing.T) {
+	data, err := NewMetalink("big.deb", 42, "abc", []string{"https://a/big.deb", "https://b/big.deb"}, []string{"https://a/big.deb.torrent"}, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("NewMetalink failed: %v", err)
+	}
+	var m Metalink
+	if err := xml.Unmarshal(data, &m); err != nil {
+		t.Fatalf("Invalid metalink: %v\n%s", err, data)
+	}
+	if m.XMLName.Space != metalinkNamespace || len(m.Files) != 1 {
+		t.Fatalf("Metalink = %+v", m)
+	}
+	f := m.Files[0]
+	if f.Name != "big.deb" || f.Size != 42 || f.Hash.Type != "sha-256" || f.Hash.Value != "abc" {
+		t.Errorf("File = %+v", f)
+	}
+	if len(f.URLs) != 2 || f.URLs[1].Priority != 2 || f.URLs[1].URL != "https://b/big.deb" {
+		t.Errorf("URLs = %+v", f.URLs)
+	}
+	if len(f.MetaURLs) != 1 || f.MetaURLs[0].MediaType != "torrent" {
+		t.Errorf("MetaURLs = %+v", f.MetaURLs)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	writePackage(t, dir, "pool/big.deb", 3000)
+	opts := Options{
+		Aids:     []string{AidZsync, AidMetalink, AidTorrent},
+		BaseURLs: []string{"https://example.com/repo/", "https://mirror.example.org/repo"},
+		Time:     time.Unix(0, 0),
+	}
+
+	written, err := Write(dir, "pool/big.deb", opts)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if len(written) != 3 {
+		t.Fatalf("Write wrote %v", written)
+	}
+	meta, _ := os.ReadFile(filepath.Join(dir, "pool/big.deb"+MetalinkExt))
+	for _, url := range []string{"https://example.com/repo/pool/big.deb", "https://mirror.example.org/repo/pool/big.deb", "https://example.com/repo/pool/big.deb.torrent"} {
+		if !strings.Contains(string(meta), ">"+url+"<") {
+			t.Errorf("Metalink lacks %s:\n%s", url, meta)
+		}
+	}
+
+	// Up to date aids are left alone
+	if written, err := Write(dir, "pool/big.deb", opts); err != nil || len(written) != 0 {
+		t.Errorf("Second Write wrote %v, %v", written, err)
+	}
+}
+
+func TestRemoveStale(t *testing.T) {
+	dir := t.TempDir()
+	writePackage(t, dir, "big.deb", 10)
+	for _, name := range []string{"big.deb.zsync", "old.deb.zsync", "old.deb.meta4", "old.deb.torrent"} {
+		writePackage(t, dir, name, 10)
+	}
+
+	removed, err := RemoveStale(dir)
+	if err != nil || removed != 3 {
+		t.Fatalf("RemoveStale = %d, %v", removed, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "big.deb.zsync")); err != nil {
+		t.Error("Aid of a published file was removed")
+	}
+}
+
+func TestValidateAids(t *testing.T) {
+	if err := ValidateAids([]string{AidZsync, AidTorrent}); err != nil {
+		t.Errorf("ValidateAids failed: %v", err)
+	}
+	if err := ValidateAids([]string{"jigdo"}); err == nil {
+		t.Error("ValidateAids accepted jigdo")
+	}
+}
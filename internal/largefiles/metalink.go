@@ -0,0 +1,67 @@
+package largefiles
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// metalinkNamespace is the namespace of Metalink 4 (RFC 5854)
+const metalinkNamespace = "urn:ietf:params:xml:ns:metalink"
+
+// Metalink is a Metalink 4 document describing files and where to
+// download them
+type Metalink struct {
+	XMLName   xml.Name       `xml:"urn:ietf:params:xml:ns:metalink metalink"`
+	Published string         `xml:"published"`
+	Generator string         `xml:"generator"`
+	Files     []MetalinkFile `xml:"file"`
+}
+
+// MetalinkFile is a file of a metalink, with its mirrors by priority
+type MetalinkFile struct {
+	Name     string        `xml:"name,attr"`
+	Size     int64         `xml:"size"`
+	Hash     MetalinkHash  `xml:"hash"`
+	URLs     []MetalinkURL `xml:"url"`
+	MetaURLs []MetalinkURL `xml:"metaurl,omitempty"`
+}
+
+// MetalinkHash is the checksum clients verify the download with
+type MetalinkHash struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// MetalinkURL is a place to download a file from, or its torrent
+type MetalinkURL struct {
+	Priority  int    `xml:"priority,attr"`
+	MediaType string `xml:"mediatype,attr,omitempty"`
+	URL       string `xml:",chardata"`
+}
+
+// NewMetalink returns the metalink of a file named name, of size bytes and
+// SHA-256 checksum sha256, downloaded from urls, preferred first, and
+// torrents, the URLs of its torrent
+func NewMetalink(name string, size int64, sha256 string, urls, torrents []string, published time.Time) ([]byte, error) {
+	file := MetalinkFile{
+		Name: name,
+		Size: size,
+		Hash: MetalinkHash{Type: "sha-256", Value: sha256},
+	}
+	for i, url := range urls {
+		file.URLs = append(file.URLs, MetalinkURL{Priority: i + 1, URL: url})
+	}
+	for i, url := range torrents {
+		file.MetaURLs = append(file.MetaURLs, MetalinkURL{Priority: i + 1, MediaType: "torrent", URL: url})
+	}
+
+	data, err := xml.MarshalIndent(Metalink{
+		Published: published.UTC().Format(time.RFC3339),
+		Generator: "repogen",
+		Files:     []MetalinkFile{file},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
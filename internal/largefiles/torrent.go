@@ -0,0 +1,126 @@
+package largefiles
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Torrent piece sizes: the smallest keeping torrents of large files to
+// about 2000 pieces, up to 16 MiB
+const (
+	minPieceLength = 256 * 1024
+	maxPieceLength = 16 * 1024 * 1024
+	targetPieces   = 2000
+)
+
+// Torrent returns the BitTorrent metainfo of the file at path, a
+// single-file torrent announced to trackers and web seeded (BEP 19) from
+// urls, the URLs of the file itself
+func Torrent(path string, urls, trackers []string, created time.Time) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	pieceLength := int64(minPieceLength)
+	for info.Size()/pieceLength > targetPieces && pieceLength < maxPieceLength {
+		pieceLength *= 2
+	}
+
+	var pieces bytes.Buffer
+	piece := make([]byte, pieceLength)
+	for {
+		n, err := io.ReadFull(f, piece)
+		if n > 0 {
+			sum := sha1.Sum(piece[:n])
+			pieces.Write(sum[:])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	metainfo := map[string]interface{}{
+		"created by":    "repogen",
+		"creation date": created.Unix(),
+		"info": map[string]interface{}{
+			"length":       info.Size(),
+			"name":         info.Name(),
+			"piece length": pieceLength,
+			"pieces":       pieces.Bytes(),
+		},
+	}
+	if len(trackers) > 0 {
+		metainfo["announce"] = trackers[0]
+		tiers := make([]interface{}, 0, len(trackers))
+		for _, tracker := range trackers {
+			tiers = append(tiers, []interface{}{tracker})
+		}
+		metainfo["announce-list"] = tiers
+	}
+	if len(urls) > 0 {
+		seeds := make([]interface{}, 0, len(urls))
+		for _, url := range urls {
+			seeds = append(seeds, url)
+		}
+		metainfo["url-list"] = seeds
+	}
+
+	var out bytes.Buffer
+	if err := bencode(&out, metainfo); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// bencode writes v, made of strings, byte slices, integers, lists and
+// dictionaries, in the BitTorrent encoding. Dictionary keys are sorted
+func bencode(w *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		fmt.Fprintf(w, "%d:%s", len(v), v)
+	case []byte:
+		fmt.Fprintf(w, "%d:", len(v))
+		w.Write(v)
+	case int64:
+		fmt.Fprintf(w, "i%de", v)
+	case []interface{}:
+		w.WriteByte('l')
+		for _, item := range v {
+			if err := bencode(w, item); err != nil {
+				return err
+			}
+		}
+		w.WriteByte('e')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		w.WriteByte('d')
+		for _, key := range keys {
+			bencode(w, key)
+			if err := bencode(w, v[key]); err != nil {
+				return err
+			}
+		}
+		w.WriteByte('e')
+	default:
+		return fmt.Errorf("can't bencode %T", v)
+	}
+	return nil
+}
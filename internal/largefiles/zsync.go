@@ -0,0 +1,114 @@
+package largefiles
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/md4"
+)
+
+// zsyncVersion is the zsync control file version written, the one of
+// zsyncmake 0.6.2
+const zsyncVersion = "0.6.2"
+
+// Zsync returns the zsync control file of the file at path, which zsync
+// downloads from url, relative to the control file. Like zsyncmake, it
+// checksums blocks of 2 KiB, or 4 KiB for files of 100 MB and more, with
+// a rolling checksum and MD4, both truncated to what the file size needs
+func Zsync(path, url string, mtime time.Time) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	length := info.Size()
+	blockSize := 2048
+	if length >= 100000000 {
+		blockSize = 4096
+	}
+	seqMatches, rsumLen, checksumLen := zsyncHashLengths(length, blockSize)
+
+	var blocks bytes.Buffer
+	whole := sha1.New()
+	reader := bufio.NewReaderSize(io.TeeReader(f, whole), 64*1024)
+	block := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(reader, block)
+		if n == 0 {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		// The last block is padded with zeros
+		clear(block[n:])
+
+		a, b := rollingChecksum(block)
+		rsum := []byte{byte(a >> 8), byte(a), byte(b >> 8), byte(b)}
+		blocks.Write(rsum[4-rsumLen:])
+		strong := md4.New()
+		strong.Write(block)
+		blocks.Write(strong.Sum(nil)[:checksumLen])
+
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var control bytes.Buffer
+	fmt.Fprintf(&control, "zsync: %s\n", zsyncVersion)
+	fmt.Fprintf(&control, "Filename: %s\n", info.Name())
+	fmt.Fprintf(&control, "MTime: %s\n", mtime.UTC().Format(time.RFC1123Z))
+	fmt.Fprintf(&control, "Blocksize: %d\n", blockSize)
+	fmt.Fprintf(&control, "Length: %d\n", length)
+	fmt.Fprintf(&control, "Hash-Lengths: %d,%d,%d\n", seqMatches, rsumLen, checksumLen)
+	fmt.Fprintf(&control, "URL: %s\n", url)
+	fmt.Fprintf(&control, "SHA-1: %s\n\n", hex.EncodeToString(whole.Sum(nil)))
+	control.Write(blocks.Bytes())
+	return control.Bytes(), nil
+}
+
+// rollingChecksum returns the two halves of the zsync rolling checksum of
+// block, an rsync-like sum
+func rollingChecksum(block []byte) (a, b uint16) {
+	for i, c := range block {
+		a += uint16(c)
+		b += uint16(len(block)-i) * uint16(c)
+	}
+	return a, b
+}
+
+// zsyncHashLengths returns how many consecutive blocks must match, and how
+// many bytes of the rolling and MD4 checksums to keep, as zsyncmake
+// computes them from the odds of false matches
+func zsyncHashLengths(length int64, blockSize int) (seqMatches, rsumLen, checksumLen int) {
+	l, bs := float64(length), float64(blockSize)
+	seqMatches = 1
+	if length > int64(blockSize) {
+		seqMatches = 2
+	}
+
+	rsumLen = int(math.Ceil(((math.Log(l)+math.Log(bs))/math.Log(2) - 8.6) / float64(seqMatches) / 8))
+	rsumLen = min(max(rsumLen, 2), 4)
+
+	blocks := float64(length / int64(blockSize))
+	checksumLen = int(math.Ceil((20 + (math.Log(l)+math.Log(1+blocks))/math.Log(2)) / float64(seqMatches) / 8))
+	checksumLen = max(checksumLen, int((7.9+(20+math.Log(1+blocks)/math.Log(2)))/8))
+	checksumLen = min(checksumLen, 16)
+	return seqMatches, rsumLen, checksumLen
+}
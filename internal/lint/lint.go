@@ -0,0 +1,298 @@
+// Package lint checks the metadata of packages before they are published:
+// fields users rely on, versions the package manager can order, file names
+// agreeing with the metadata, sizes, and the policies of a rules file
+package lint
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Severity is how much a finding matters
+type Severity string
+
+const (
+	SeverityOff   Severity = "off"   // The rule isn't checked
+	SeverityWarn  Severity = "warn"  // Reported
+	SeverityError Severity = "error" // Reported, and fails the lint
+)
+
+// Rule names, used to set their severity in a rules file
+const (
+	RuleMissingMaintainer  = "missing-maintainer"
+	RuleMissingLicense     = "missing-license"
+	RuleMissingDescription = "missing-description"
+	RuleInvalidVersion     = "invalid-version"
+	RuleArchMismatch       = "arch-mismatch"
+	RuleOversized          = "oversized"
+	RulePolicyName         = "policy-name"
+	RulePolicyMaintainer   = "policy-maintainer"
+	RulePolicyLicense      = "policy-license"
+	RulePolicyArch         = "policy-arch"
+)
+
+// defaultSeverities are the severities of the rules a rules file doesn't set
+var defaultSeverities = map[string]Severity{
+	RuleMissingMaintainer:  SeverityWarn,
+	RuleMissingLicense:     SeverityWarn,
+	RuleMissingDescription: SeverityWarn,
+	RuleInvalidVersion:     SeverityError,
+	RuleArchMismatch:       SeverityError,
+	RuleOversized:          SeverityWarn,
+	RulePolicyName:         SeverityError,
+	RulePolicyMaintainer:   SeverityError,
+	RulePolicyLicense:      SeverityError,
+	RulePolicyArch:         SeverityError,
+}
+
+// DefaultMaxSize is the size above which packages are oversized when the
+// rules don't say
+const DefaultMaxSize = 1 << 30
+
+// Rules configures the checks. The policy checks only apply when their
+// pattern or list is set
+type Rules struct {
+	Severity             map[string]Severity `yaml:"severity"` // Rule name to severity
+	MaxSize              string              `yaml:"max_size"` // e.g. 500MiB, 2GB or a number of bytes
+	NamePattern          string              `yaml:"name_pattern"`
+	MaintainerPattern    string              `yaml:"maintainer_pattern"`
+	AllowedLicenses      []string            `yaml:"allowed_licenses"`
+	AllowedArchitectures []string            `yaml:"allowed_architectures"`
+
+	maxSize    int64
+	name       *regexp.Regexp
+	maintainer *regexp.Regexp
+}
+
+// Load reads a rules file (YAML or JSON)
+func Load(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	var r Rules
+	if err := yaml.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse rules file %s: %w", path, err)
+	}
+	if err := r.compile(); err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// DefaultRules returns the rules applied without a rules file
+func DefaultRules() *Rules {
+	r := &Rules{}
+	r.compile()
+	return r
+}
+
+// compile checks the rules and prepares their patterns
+func (r *Rules) compile() error {
+	for rule, severity := range r.Severity {
+		if _, ok := defaultSeverities[rule]; !ok {
+			return fmt.Errorf("unknown rule %q", rule)
+		}
+		switch severity {
+		case SeverityOff, SeverityWarn, SeverityError:
+		default:
+			return fmt.Errorf("invalid severity %q of %s (expected off, warn or error)", severity, rule)
+		}
+	}
+
+	r.maxSize = DefaultMaxSize
+	if r.MaxSize != "" {
+		size, err := parseSize(r.MaxSize)
+		if err != nil {
+			return err
+		}
+		r.maxSize = size
+	}
+
+	var err error
+	if r.NamePattern != "" {
+		if r.name, err = regexp.Compile(r.NamePattern); err != nil {
+			return fmt.Errorf("invalid name_pattern: %w", err)
+		}
+	}
+	if r.MaintainerPattern != "" {
+		if r.maintainer, err = regexp.Compile(r.MaintainerPattern); err != nil {
+			return fmt.Errorf("invalid maintainer_pattern: %w", err)
+		}
+	}
+	return nil
+}
+
+// severity returns the severity of rule
+func (r *Rules) severity(rule string) Severity {
+	if s, ok := r.Severity[rule]; ok {
+		return s
+	}
+	return defaultSeverities[rule]
+}
+
+// Finding is a rule a package breaks
+type Finding struct {
+	Rule         string   `json:"rule"`
+	Severity     Severity `json:"severity"`
+	Type         string   `json:"type"`
+	Name         string   `json:"name"`
+	Version      string   `json:"version"`
+	Architecture string   `json:"arch,omitempty"`
+	File         string   `json:"file"`
+	Message      string   `json:"message"`
+}
+
+// Check returns what the packages of pkgType break, as parsed from their
+// files (Filename being the path of the file)
+func (r *Rules) Check(pkgType scanner.PackageType, packages []models.Package) []Finding {
+	var findings []Finding
+	for _, pkg := range packages {
+		report := func(rule, format string, args ...interface{}) {
+			severity := r.severity(rule)
+			if severity == SeverityOff {
+				return
+			}
+			findings = append(findings, Finding{
+				Rule:         rule,
+				Severity:     severity,
+				Type:         pkgType.String(),
+				Name:         pkg.Name,
+				Version:      pkg.Version,
+				Architecture: pkg.Architecture,
+				File:         pkg.Filename,
+				Message:      fmt.Sprintf(format, args...),
+			})
+		}
+
+		if strings.TrimSpace(pkg.Maintainer) == "" {
+			report(RuleMissingMaintainer, "no maintainer")
+		}
+		if strings.TrimSpace(pkg.License) == "" {
+			report(RuleMissingLicense, "no license")
+		}
+		if strings.TrimSpace(pkg.Description) == "" {
+			report(RuleMissingDescription, "no description")
+		}
+		if re, ok := versionPatterns[pkgType]; ok && !re.MatchString(pkg.Version) {
+			report(RuleInvalidVersion, "version %q is not a valid %s version", pkg.Version, pkgType)
+		} else if !ok && (pkg.Version == "" || strings.ContainsAny(pkg.Version, " \t\n")) {
+			report(RuleInvalidVersion, "version %q is empty or holds spaces", pkg.Version)
+		}
+		if arch, ok := filenameArch(pkgType, filepath.Base(pkg.Filename)); ok && arch != pkg.Architecture {
+			report(RuleArchMismatch, "file name says architecture %s, metadata says %s", arch, pkg.Architecture)
+		}
+		if pkg.Size > r.maxSize {
+			report(RuleOversized, "%s, above the %s limit", utils.FormatBytes(pkg.Size), utils.FormatBytes(r.maxSize))
+		}
+
+		if r.name != nil && !r.name.MatchString(pkg.Name) {
+			report(RulePolicyName, "name doesn't match %s", r.NamePattern)
+		}
+		if r.maintainer != nil && !r.maintainer.MatchString(pkg.Maintainer) {
+			report(RulePolicyMaintainer, "maintainer %q doesn't match %s", pkg.Maintainer, r.MaintainerPattern)
+		}
+		if len(r.AllowedLicenses) > 0 && pkg.License != "" && !slices.Contains(r.AllowedLicenses, pkg.License) {
+			report(RulePolicyLicense, "license %q is not allowed", pkg.License)
+		}
+		if len(r.AllowedArchitectures) > 0 && !slices.Contains(r.AllowedArchitectures, pkg.Architecture) {
+			report(RulePolicyArch, "architecture %q is not allowed", pkg.Architecture)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].File < findings[j].File
+	})
+	return findings
+}
+
+// versionPatterns match the versions each package format accepts
+var versionPatterns = map[scanner.PackageType]*regexp.Regexp{
+	// [epoch:]upstream[-revision], upstream starting with a digit
+	scanner.TypeDeb: regexp.MustCompile(`^([0-9]+:)?[0-9]([A-Za-z0-9.+~-]*[A-Za-z0-9.+~])?$`),
+	// The release is kept apart, so no hyphen
+	scanner.TypeRpm: regexp.MustCompile(`^[A-Za-z0-9._+~^]+$`),
+	// Numbers, a letter, suffixes, then -r<release>
+	scanner.TypeApk:  regexp.MustCompile(`^[0-9]+(\.[0-9]+)*[a-z]?(_(alpha|beta|pre|rc|cvs|svn|git|hg|p)[0-9]*)*(-r[0-9]+)?$`),
+	scanner.TypeXbps: regexp.MustCompile(`^[^\s_-]+_[0-9]+$`),
+	// [epoch:]pkgver-pkgrel
+	scanner.TypePacman: regexp.MustCompile(`^([0-9]+:)?[^-:/\s]+-[0-9]+(\.[0-9]+)?$`),
+	// PEP 440
+	scanner.TypePypi:    regexp.MustCompile(`(?i)^v?([0-9]+!)?[0-9]+(\.[0-9]+)*([-_.]?(a|b|c|rc|alpha|beta|pre|preview)[-_.]?[0-9]*)?((-[0-9]+)|([-_.]?(post|rev|r)[-_.]?[0-9]*))?([-_.]?dev[-_.]?[0-9]*)?(\+[a-z0-9]+([-_.][a-z0-9]+)*)?$`),
+	scanner.TypeRubygem: regexp.MustCompile(`^[0-9]+(\.[0-9a-zA-Z]+)*(-[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?$`),
+	// Semantic versions
+	scanner.TypeCargo:     regexp.MustCompile(`^(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`),
+	scanner.TypeTerraform: regexp.MustCompile(`^(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`),
+	scanner.TypeNuget:     regexp.MustCompile(`^[0-9]+(\.[0-9]+){1,3}(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`),
+}
+
+// filenameArch returns the architecture the conventional file name of a
+// package names, if its format puts it there
+func filenameArch(pkgType scanner.PackageType, name string) (string, bool) {
+	switch pkgType {
+	case scanner.TypeDeb:
+		// name_version_arch.deb
+		parts := strings.Split(strings.TrimSuffix(name, ".deb"), "_")
+		if len(parts) == 3 && strings.HasSuffix(name, ".deb") {
+			return parts[2], true
+		}
+	case scanner.TypeRpm:
+		// name-version-release.arch.rpm
+		base := strings.TrimSuffix(name, ".rpm")
+		if i := strings.LastIndex(base, "."); i > 0 && strings.HasSuffix(name, ".rpm") {
+			return base[i+1:], true
+		}
+	case scanner.TypePacman:
+		// name-pkgver-pkgrel-arch.pkg.tar.*
+		if i := strings.Index(name, ".pkg.tar"); i > 0 {
+			parts := strings.Split(name[:i], "-")
+			if len(parts) >= 4 {
+				return parts[len(parts)-1], true
+			}
+		}
+	case scanner.TypeXbps:
+		// name-version_revision.arch.xbps
+		base := strings.TrimSuffix(name, ".xbps")
+		if i := strings.LastIndex(base, "."); i > 0 && strings.HasSuffix(name, ".xbps") {
+			return base[i+1:], true
+		}
+	}
+	return "", false
+}
+
+// parseSize reads a size such as 500MiB, 2GB or 1048576 (bytes)
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+		{"B", 1},
+	}
+	s = strings.TrimSpace(s)
+	multiplier := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, multiplier = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid max_size %q (expected e.g. 500MiB)", s)
+	}
+	return n * multiplier, nil
+}
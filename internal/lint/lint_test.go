@@ -0,0 +1,131 @@
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+)
+
+// rules returns the rules a finding was reported for, in order
+func rules(findings []Finding) string {
+	var names []string
+	for _, f := range findings {
+		names = append(names, string(f.Severity)+":"+f.Rule)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestCheckDefaults(t *testing.T) {
+	good := models.Package{
+		Name: "hello", Version: "1:2.10-1ubuntu1", Architecture: "amd64",
+		Maintainer: "Test <test@example.com>", License: "MIT", Description: "Says hello",
+		Filename: "/in/hello_2.10-1ubuntu1_amd64.deb", Size: 1000,
+	}
+	if findings := DefaultRules().Check(scanner.TypeDeb, []models.Package{good}); len(findings) != 0 {
+		t.Errorf("Findings for a good package: %+v", findings)
+	}
+
+	bad := models.Package{
+		Name: "hello", Version: "v1.0-", Architecture: "amd64",
+		Filename: "/in/hello_1.0_arm64.deb", Size: 2 << 30,
+	}
+	got := rules(DefaultRules().Check(scanner.TypeDeb, []models.Package{bad}))
+	want := "warn:missing-maintainer warn:missing-license warn:missing-description error:invalid-version error:arch-mismatch warn:oversized"
+	if got != want {
+		t.Errorf("Findings = %s, want %s", got, want)
+	}
+}
+
+func TestVersionPatterns(t *testing.T) {
+	for _, tt := range []struct {
+		pkgType scanner.PackageType
+		version string
+		valid   bool
+	}{
+		{scanner.TypeDeb, "1.0", true},
+		{scanner.TypeDeb, "2:1.0~rc1-3", true},
+		{scanner.TypeDeb, "abc", false},
+		{scanner.TypeRpm, "1.0", true},
+		{scanner.TypeRpm, "1.0-1", false},
+		{scanner.TypeApk, "1.2.3-r0", true},
+		{scanner.TypeApk, "1.2.3_rc1-r2", true},
+		{scanner.TypeApk, "latest", false},
+		{scanner.TypePacman, "1:8.3-1", true},
+		{scanner.TypePacman, "8.3", false},
+		{scanner.TypePypi, "1.0.post1.dev2", true},
+		{scanner.TypePypi, "1.0-final-x", false},
+		{scanner.TypeCargo, "1.2.3-alpha.1", true},
+		{scanner.TypeCargo, "1.2", false},
+		{scanner.TypeConda, "1.0 beta", false},
+	} {
+		findings := (&Rules{Severity: map[string]Severity{
+			RuleMissingMaintainer: SeverityOff, RuleMissingLicense: SeverityOff, RuleMissingDescription: SeverityOff,
+		}, maxSize: DefaultMaxSize}).Check(tt.pkgType, []models.Package{{Name: "x", Version: tt.version}})
+		if valid := len(findings) == 0; valid != tt.valid {
+			t.Errorf("%s version %q valid = %v, want %v", tt.pkgType, tt.version, valid, tt.valid)
+		}
+	}
+}
+
+func TestFilenameArch(t *testing.T) {
+	for _, tt := range []struct {
+		pkgType scanner.PackageType
+		name    string
+		arch    string
+	}{
+		{scanner.TypeDeb, "hello_1.0-1_arm64.deb", "arm64"},
+		{scanner.TypeRpm, "hello-1.0-1.el9.noarch.rpm", "noarch"},
+		{scanner.TypePacman, "hello-1.0-1-x86_64.pkg.tar.zst", "x86_64"},
+		{scanner.TypeXbps, "hello-1.0_1.aarch64.xbps", "aarch64"},
+		{scanner.TypeDeb, "hello.deb", ""},
+		{scanner.TypeApk, "hello-1.0-r0.apk", ""},
+	} {
+		if arch, _ := filenameArch(tt.pkgType, tt.name); arch != tt.arch {
+			t.Errorf("filenameArch(%s) = %q, want %q", tt.name, arch, tt.arch)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.yaml")
+	os.WriteFile(path, []byte(`max_size: 10MiB
+name_pattern: '^lib'
+maintainer_pattern: '@example\.com>$'
+allowed_licenses: [MIT]
+allowed_architectures: [amd64]
+severity:
+  missing-description: off
+  missing-license: error
+`), 0644)
+
+	r, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	pkg := models.Package{
+		Name: "hello", Version: "1.0", Architecture: "arm64", License: "GPL-2.0",
+		Maintainer: "Someone <someone@elsewhere.org>", Filename: "hello_1.0_arm64.deb", Size: 11 << 20,
+	}
+	got := rules(r.Check(scanner.TypeDeb, []models.Package{pkg}))
+	want := "warn:oversized error:policy-name error:policy-maintainer error:policy-license error:policy-arch"
+	if got != want {
+		t.Errorf("Findings = %s, want %s", got, want)
+	}
+
+	for _, invalid := range []string{
+		"severity:\n  no-such-rule: warn\n",
+		"severity:\n  oversized: fatal\n",
+		"max_size: lots\n",
+		"name_pattern: '('\n",
+	} {
+		os.WriteFile(path, []byte(invalid), 0644)
+		if _, err := Load(path); err == nil {
+			t.Errorf("Load accepted %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Path is the lock file, relative to the output directory
+const Path = ".repogen.lock"
+
+// ErrLocked is returned when another run holds the lock
+var ErrLocked = errors.New("output directory is locked by another run")
+
+// errBusy and errUnsupported are returned by tryLock when another process
+// holds the lock, and when the file can't be locked
+var (
+	errBusy        = errors.New("lock held by another process")
+	errUnsupported = errors.New("locks not supported")
+)
+
+// pollInterval is how often a waiting run retries the lock
+const pollInterval = 200 * time.Millisecond
+
+// Lock is an advisory lock on an output directory, held until Release
+type Lock struct {
+	f      *os.File
+	locked bool // False on filesystems without locks
+}
+
+// Holder describes the run holding a lock, as recorded in the lock file
+type Holder struct {
+	PID      int
+	Hostname string
+	Since    time.Time
+}
+
+// String describes the holder for error messages
+func (h Holder) String() string {
+	return fmt.Sprintf("pid %d on %s since %s", h.PID, h.Hostname, h.Since.Format(time.RFC3339))
+}
+
+// Acquire locks outputDir against other runs, waiting up to wait for the
+// run holding it to finish, or failing at once when wait is zero
+func Acquire(ctx context.Context, outputDir string, wait time.Duration) (*Lock, error) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(outputDir, Path)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(wait)
+	logged := false
+	for {
+		err := tryLock(f)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, errUnsupported) {
+			logrus.Warnf("%s doesn't support locks, other runs can't be kept out: %v", outputDir, err)
+			return &Lock{f: f}, nil
+		}
+		if !errors.Is(err, errBusy) {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+
+		holder, _ := readHolder(f)
+		if !time.Now().Before(deadline) {
+			f.Close()
+			if holder != nil {
+				return nil, fmt.Errorf("%w (%s)", ErrLocked, holder)
+			}
+			return nil, ErrLocked
+		}
+		if !logged {
+			logrus.Infof("Waiting for another run to release %s...", outputDir)
+			logged = true
+		}
+
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+
+	// The kernel releases the lock of runs that die, but not the holder they
+	// recorded, which is then stale
+	if holder, _ := readHolder(f); holder != nil {
+		logrus.Warnf("Taking over the stale lock of %s, which did not finish", holder)
+	}
+	if err := writeHolder(f); err != nil {
+		unlock(f)
+		f.Close()
+		return nil, fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return &Lock{f: f, locked: true}, nil
+}
+
+// Release clears the holder and unlocks. The lock file is kept, as removing
+// it would let two runs lock different files
+func (l *Lock) Release() error {
+	if !l.locked {
+		return l.f.Close()
+	}
+	if err := l.f.Truncate(0); err != nil {
+		l.f.Close()
+		return err
+	}
+	if err := unlock(l.f); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
+
+// readHolder reads the holder recorded in f, nil when there is none
+func readHolder(f *os.File) (*Holder, error) {
+	data := make([]byte, 512)
+	n, err := f.ReadAt(data, 0)
+	if n == 0 {
+		return nil, err
+	}
+
+	fields := strings.Fields(string(data[:n]))
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("invalid lock file")
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid lock file pid: %w", err)
+	}
+	since, err := time.Parse(time.RFC3339, fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid lock file time: %w", err)
+	}
+	return &Holder{PID: pid, Hostname: fields[1], Since: since}, nil
+}
+
+// writeHolder records the current process as the holder of f
+func writeHolder(f *os.File) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.WriteAt([]byte(fmt.Sprintf("%d %s %s\n", os.Getpid(), hostname, time.Now().UTC().Format(time.RFC3339))), 0)
+	return err
+}
@@ -0,0 +1,19 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package lock
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// tryLock fails as unsupported: without flock, runs aren't kept out of each
+// other's way
+func tryLock(*os.File) error {
+	return fmt.Errorf("%w on %s", errUnsupported, runtime.GOOS)
+}
+
+func unlock(*os.File) error {
+	return nil
+}
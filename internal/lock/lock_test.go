@@ -0,0 +1,84 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquireExcludesOtherRuns(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := Acquire(context.Background(), dir, 0)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	_, err = Acquire(context.Background(), dir, 0)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "pid ") {
+		t.Errorf("error doesn't name the holder: %v", err)
+	}
+
+	// A waiting run gets the lock once it is released
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		first.Release()
+	}()
+	second, err := Acquire(context.Background(), dir, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Acquire with wait failed: %v", err)
+	}
+	if err := second.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	// The lock file is kept, without a holder
+	data, err := os.ReadFile(filepath.Join(dir, Path))
+	if err != nil || len(data) != 0 {
+		t.Errorf("unexpected lock file %q: %v", data, err)
+	}
+}
+
+func TestAcquireTakesOverStaleLocks(t *testing.T) {
+	dir := t.TempDir()
+
+	// A run that died leaves its holder behind, but not the lock itself
+	if err := os.WriteFile(filepath.Join(dir, Path), []byte("999999 elsewhere 2024-01-02T03:04:05Z\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Acquire(context.Background(), dir, 0)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	defer l.Release()
+
+	data, _ := os.ReadFile(filepath.Join(dir, Path))
+	if strings.Contains(string(data), "elsewhere") || !strings.HasPrefix(string(data), fmt.Sprintf("%d ", os.Getpid())) {
+		t.Errorf("stale holder not replaced: %q", data)
+	}
+}
+
+func TestAcquireGivesUpWithContext(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Acquire(context.Background(), dir, 0)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	defer l.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := Acquire(ctx, dir, time.Hour); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to stop waiting, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package manifest
+
+import (
+	"sort"
+
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Update is a package whose published versions differ between two
+// repositories
+type Update struct {
+	Type         string   `json:"type"`
+	Name         string   `json:"name"`
+	Architecture string   `json:"arch,omitempty"`
+	From         []string `json:"from"` // Versions only in the old repository
+	To           []string `json:"to"`   // Versions only in the new repository
+}
+
+// Change is a package version published in both repositories with
+// different contents, as when it was rebuilt without a version bump
+type Change struct {
+	Old Entry `json:"old"`
+	New Entry `json:"new"`
+}
+
+// Difference lists how a repository differs from an older one
+type Difference struct {
+	Added   []Entry  `json:"added"`   // Packages the old repository doesn't have in any version
+	Removed []Entry  `json:"removed"` // Packages the new repository doesn't have in any version
+	Updated []Update `json:"updated"`
+	Changed []Change `json:"changed"`
+}
+
+// Empty reports whether both repositories publish the same packages
+func (d *Difference) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Updated) == 0 && len(d.Changed) == 0
+}
+
+// packageKey identifies a package whatever its version
+type packageKey struct {
+	pkgType, name, arch string
+}
+
+// Diff compares the packages of the new repository with the old one's.
+// Packages are told apart by type, name and architecture, and versions of
+// a package by their version string
+func Diff(oldEntries, newEntries []Entry) *Difference {
+	oldVersions, newVersions := groupVersions(oldEntries), groupVersions(newEntries)
+	d := &Difference{Added: []Entry{}, Removed: []Entry{}, Updated: []Update{}, Changed: []Change{}}
+
+	for _, key := range sortedKeys(oldVersions, newVersions) {
+		before, after := oldVersions[key], newVersions[key]
+		switch {
+		case before == nil:
+			d.Added = append(d.Added, sortedEntries(after)...)
+		case after == nil:
+			d.Removed = append(d.Removed, sortedEntries(before)...)
+		default:
+			update := Update{Type: key.pkgType, Name: key.name, Architecture: key.arch, From: []string{}, To: []string{}}
+			for _, e := range sortedEntries(before) {
+				n, ok := after[e.Version]
+				if !ok {
+					update.From = append(update.From, e.Version)
+				} else if n.SHA256 != e.SHA256 || n.Size != e.Size {
+					d.Changed = append(d.Changed, Change{Old: e, New: n})
+				}
+			}
+			for _, e := range sortedEntries(after) {
+				if _, ok := before[e.Version]; !ok {
+					update.To = append(update.To, e.Version)
+				}
+			}
+			if len(update.From) > 0 || len(update.To) > 0 {
+				d.Updated = append(d.Updated, update)
+			}
+		}
+	}
+	return d
+}
+
+// groupVersions indexes entries by package, then version
+func groupVersions(entries []Entry) map[packageKey]map[string]Entry {
+	groups := make(map[packageKey]map[string]Entry)
+	for _, e := range entries {
+		key := packageKey{e.Type, e.Name, e.Architecture}
+		if groups[key] == nil {
+			groups[key] = make(map[string]Entry)
+		}
+		groups[key][e.Version] = e
+	}
+	return groups
+}
+
+// sortedKeys returns the packages of both groups, by type, name and
+// architecture
+func sortedKeys(a, b map[packageKey]map[string]Entry) []packageKey {
+	var keys []packageKey
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if a[key] == nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].pkgType != keys[j].pkgType {
+			return keys[i].pkgType < keys[j].pkgType
+		}
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].arch < keys[j].arch
+	})
+	return keys
+}
+
+// sortedEntries returns the versions of a package, oldest first
+func sortedEntries(versions map[string]Entry) []Entry {
+	entries := make([]Entry, 0, len(versions))
+	for _, e := range versions {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return utils.VersionComparator(entries[i].Type)(entries[i].Version, entries[j].Version) < 0
+	})
+	return entries
+}
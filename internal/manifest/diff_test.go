@@ -0,0 +1,42 @@
+package manifest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := []Entry{
+		{Type: "deb", Name: "hello", Version: "1.0", Architecture: "amd64", SHA256: "a"},
+		{Type: "deb", Name: "hello", Version: "1.1", Architecture: "amd64", SHA256: "b"},
+		{Type: "deb", Name: "gone", Version: "2.0", Architecture: "amd64", SHA256: "c"},
+		{Type: "rpm", Name: "tool", Version: "3.0", Architecture: "x86_64", SHA256: "d", Size: 10},
+	}
+	updated := []Entry{
+		{Type: "deb", Name: "hello", Version: "1.1", Architecture: "amd64", SHA256: "b"},
+		{Type: "deb", Name: "hello", Version: "1.10", Architecture: "amd64", SHA256: "e"},
+		{Type: "deb", Name: "hello", Version: "1.1", Architecture: "arm64", SHA256: "f"},
+		{Type: "rpm", Name: "tool", Version: "3.0", Architecture: "x86_64", SHA256: "g", Size: 12},
+	}
+
+	d := Diff(old, updated)
+	if len(d.Added) != 1 || d.Added[0].Architecture != "arm64" {
+		t.Errorf("Added = %+v", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0].Name != "gone" {
+		t.Errorf("Removed = %+v", d.Removed)
+	}
+	if len(d.Updated) != 1 || strings.Join(d.Updated[0].From, ",") != "1.0" || strings.Join(d.Updated[0].To, ",") != "1.10" {
+		t.Errorf("Updated = %+v", d.Updated)
+	}
+	if len(d.Changed) != 1 || d.Changed[0].Old.SHA256 != "d" || d.Changed[0].New.SHA256 != "g" {
+		t.Errorf("Changed = %+v", d.Changed)
+	}
+	if d.Empty() {
+		t.Error("Empty() = true")
+	}
+
+	if d := Diff(old, old); !d.Empty() {
+		t.Errorf("Diff of a repository with itself = %+v", d)
+	}
+}
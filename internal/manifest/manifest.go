@@ -0,0 +1,140 @@
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Path is the package manifest, relative to the repository root
+const Path = ".repogen/packages.json"
+
+// Entry is a package published in a repository
+type Entry struct {
+	Type         string `json:"type"`
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Architecture string `json:"arch,omitempty"`
+	Path         string `json:"path,omitempty"` // Package file, relative to the repository root
+	Size         int64  `json:"size,omitempty"`
+	SHA256       string `json:"sha256,omitempty"`
+	Deprecated   string `json:"deprecated,omitempty"` // Deprecation note, empty for supported packages
+}
+
+// Manifest lists the packages of every package type published in a repository
+type Manifest struct {
+	Updated  time.Time `json:"updated"`
+	Packages []Entry   `json:"packages"`
+}
+
+// Read loads the manifest of the repository in outputDir
+func Read(outputDir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(outputDir, filepath.FromSlash(Path)))
+	if err != nil {
+		return nil, err
+	}
+	return decode(data)
+}
+
+// Fetch downloads the manifest of the repository served at baseURL
+func Fetch(ctx context.Context, client *http.Client, baseURL string) (*Manifest, error) {
+	url := strings.TrimRight(baseURL, "/") + "/" + Path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decode(data)
+}
+
+func decode(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid package manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// recordMu keeps the package types generated at once from losing each
+// other's packages
+var recordMu sync.Mutex
+
+// Record replaces the packages of pkgType in the manifest of the repository
+// in outputDir, keeping the other types, as updated at updated
+func Record(outputDir, pkgType string, entries []Entry, updated time.Time) error {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+
+	m, err := Read(outputDir)
+	if err != nil {
+		m = &Manifest{}
+	}
+
+	packages := make([]Entry, 0, len(m.Packages)+len(entries))
+	for _, e := range m.Packages {
+		if e.Type != pkgType {
+			packages = append(packages, e)
+		}
+	}
+	for _, e := range entries {
+		e.Type = pkgType
+		packages = append(packages, e)
+	}
+	sort.SliceStable(packages, func(i, j int) bool {
+		a, b := packages[i], packages[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if c := utils.VersionComparator(a.Type)(a.Version, b.Version); c != 0 {
+			return c < 0
+		}
+		if a.Architecture != b.Architecture {
+			return a.Architecture < b.Architecture
+		}
+		return a.Path < b.Path
+	})
+	m.Packages = packages
+	m.Updated = updated.UTC()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Searches read the file at any time: replace it in one step
+	file := filepath.Join(outputDir, filepath.FromSlash(Path))
+	tmp := file + ".tmp"
+	if err := utils.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write package manifest: %w", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write package manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package manifest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRecordAndFetch(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := Record(dir, "deb", []Entry{
+		{Name: "hello", Version: "1.10", Architecture: "amd64", Path: "pool/main/h/hello/hello_1.10_amd64.deb"},
+		{Name: "hello", Version: "1.9", Architecture: "amd64", Path: "pool/main/h/hello/hello_1.9_amd64.deb"},
+	}, time.Now()); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	if err := Record(dir, "rpm", []Entry{{Name: "hello", Version: "1.0", Architecture: "x86_64"}}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// A new generation replaces the packages of its type only
+	if err := Record(dir, "deb", []Entry{
+		{Name: "hello", Version: "1.10", Architecture: "amd64"},
+		{Name: "hello", Version: "1.9", Architecture: "amd64"},
+	}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Read(dir)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(m.Packages) != 3 {
+		t.Fatalf("expected 3 packages, got %+v", m.Packages)
+	}
+	if m.Packages[0].Type != "deb" || m.Packages[0].Version != "1.9" || m.Packages[2].Type != "rpm" {
+		t.Errorf("unexpected order %+v", m.Packages)
+	}
+
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	fetched, err := Fetch(context.Background(), server.Client(), server.URL+"/")
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(fetched.Packages) != 3 {
+		t.Errorf("unexpected fetched manifest %+v", fetched)
+	}
+	if _, err := Fetch(context.Background(), server.Client(), server.URL+"/missing"); err == nil {
+		t.Error("Fetch succeeded without a manifest")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	entry := Entry{Type: "deb", Name: "libfoo-dev", Version: "2.1.0", Architecture: "amd64"}
+
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{nil, true},
+		{[]string{"foo"}, true},
+		{[]string{"name~^libfoo"}, true},
+		{[]string{"name=libfoo"}, false},
+		{[]string{"name=libfoo-dev", "arch=amd64"}, true},
+		{[]string{"name~foo", "version~^1\\."}, false},
+		{[]string{"type=rpm"}, false},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.args)
+		if err != nil {
+			t.Fatalf("ParseQuery(%v) failed: %v", tt.args, err)
+		}
+		if got := q.Match(entry); got != tt.want {
+			t.Errorf("ParseQuery(%v).Match = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"size=1", "name~("} {
+		if _, err := ParseQuery([]string{bad}); err == nil {
+			t.Errorf("ParseQuery accepted %q", bad)
+		}
+	}
+}
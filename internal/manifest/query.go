@@ -0,0 +1,66 @@
+package manifest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Query matches the entries every one of its terms matches
+type Query struct {
+	terms []term
+}
+
+// term compares one field of an entry, exactly or against a regular expression
+type term struct {
+	field string
+	value string
+	re    *regexp.Regexp
+}
+
+// fields are the entry fields a query can compare
+var fields = map[string]func(Entry) string{
+	"name":    func(e Entry) string { return e.Name },
+	"version": func(e Entry) string { return e.Version },
+	"arch":    func(e Entry) string { return e.Architecture },
+	"type":    func(e Entry) string { return e.Type },
+}
+
+// ParseQuery reads terms of the form field=value (exact) or field~regex,
+// field being name, version, arch or type. A bare term is a regular
+// expression on the name
+func ParseQuery(args []string) (*Query, error) {
+	q := &Query{}
+	for _, arg := range args {
+		i := strings.IndexAny(arg, "=~")
+		field, op, value := "name", byte('~'), arg
+		if i >= 0 {
+			field, op, value = arg[:i], arg[i], arg[i+1:]
+			if _, ok := fields[field]; !ok {
+				return nil, fmt.Errorf("unknown field %q in %q, expected name, version, arch or type", field, arg)
+			}
+		}
+
+		t := term{field: field, value: value}
+		if op == '~' {
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression in %q: %w", arg, err)
+			}
+			t.re = re
+		}
+		q.terms = append(q.terms, t)
+	}
+	return q, nil
+}
+
+// Match reports whether e matches every term of q
+func (q *Query) Match(e Entry) bool {
+	for _, t := range q.terms {
+		got := fields[t.field](e)
+		if t.re != nil && !t.re.MatchString(got) || t.re == nil && got != t.value {
+			return false
+		}
+	}
+	return true
+}
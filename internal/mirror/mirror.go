@@ -0,0 +1,151 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ralt/repogen/internal/fetch"
+	"github.com/ralt/repogen/internal/filter"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+)
+
+// maxMetadataSize bounds the metadata files read from upstream, which are
+// held in memory
+const maxMetadataSize = 512 << 20
+
+// Upstream is a repository served over HTTP(S)
+type Upstream struct {
+	Client *http.Client // nil for http.DefaultClient
+
+	base    *url.URL
+	keyring openpgp.EntityList
+}
+
+// New returns the repository at baseURL. Its signed metadata is checked
+// against keyring when one is given
+func New(baseURL string, keyring openpgp.EntityList) (*Upstream, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid upstream URL %q: expected http:// or https://", baseURL)
+	}
+	// Paths are resolved below the repository, not next to it
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	return &Upstream{base: base, keyring: keyring}, nil
+}
+
+// URL returns the URL of the file at p below the repository
+func (u *Upstream) URL(p string) string {
+	return u.base.ResolveReference(&url.URL{Path: p}).String()
+}
+
+// Get fetches the file at p below the repository
+func (u *Upstream) Get(ctx context.Context, p string) ([]byte, error) {
+	fileURL := u.URL(p)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", fileURL, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMetadataSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxMetadataSize {
+		return nil, fmt.Errorf("GET %s: response larger than %d bytes", fileURL, maxMetadataSize)
+	}
+	return data, nil
+}
+
+// Verify checks the armored or binary detached signature of data against
+// the keyring, accepting anything without one
+func (u *Upstream) Verify(data, signature []byte) error {
+	if !u.Verifies() {
+		return nil
+	}
+
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN PGP SIGNATURE-----")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(u.keyring, bytes.NewReader(data), bytes.NewReader(signature), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(u.keyring, bytes.NewReader(data), bytes.NewReader(signature), nil)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	return nil
+}
+
+// Verifies reports whether signatures are checked
+func (u *Upstream) Verifies() bool {
+	return len(u.keyring) > 0
+}
+
+// Select returns the packages of pkgType read from upstream that pass the
+// filters of config, --include and --exclude matching their path in the
+// repository
+func Select(config *models.RepositoryConfig, pkgType scanner.PackageType, packages []models.Package) []models.Package {
+	var selected []models.Package
+	for _, pkg := range filter.Packages(config, pkgType, packages) {
+		if scanner.Matches(config.Include, config.Exclude, pkg.Filename) {
+			selected = append(selected, pkg)
+		}
+	}
+	return selected
+}
+
+// Entries returns the downloads of packages read from upstream, grouped
+// by the subdirectory they are saved in: their Debian component, so they
+// are published in the same one, or else none
+func (u *Upstream) Entries(packages []models.Package) map[string][]fetch.Entry {
+	entries := make(map[string][]fetch.Entry)
+	for _, pkg := range packages {
+		component, _ := pkg.Metadata["Component"].(string)
+		entries[component] = append(entries[component], fetch.Entry{
+			URL:    u.URL(pkg.Filename),
+			SHA256: pkg.SHA256Sum,
+			Name:   path.Base(pkg.Filename),
+		})
+	}
+	return entries
+}
+
+// ReadKeyring reads armored or binary public keys
+func ReadKeyring(file string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring %s: %w", file, err)
+	}
+	return keyring, nil
+}
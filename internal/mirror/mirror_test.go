@@ -0,0 +1,112 @@
+package mirror
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/ralt/repogen/internal/generator"
+	"github.com/ralt/repogen/internal/generator/deb"
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/packager"
+	"github.com/ralt/repogen/internal/scanner"
+)
+
+func TestURL(t *testing.T) {
+	for _, base := range []string{"https://example.com/debian", "https://example.com/debian/"} {
+		upstream, err := New(base, nil)
+		if err != nil {
+			t.Fatalf("New(%q) failed: %v", base, err)
+		}
+		if got := upstream.URL("dists/bookworm/Release"); got != "https://example.com/debian/dists/bookworm/Release" {
+			t.Errorf("URL below %q is %s", base, got)
+		}
+	}
+
+	for _, base := range []string{"ftp://example.com/debian", "example.com/debian", ""} {
+		if _, err := New(base, nil); err == nil {
+			t.Errorf("New(%q) succeeded", base)
+		}
+	}
+}
+
+func TestMirrorDebianRepository(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	var packages []models.Package
+	for _, name := range []string{"hello", "hello-dbgsym", "world"} {
+		path, err := packager.Build("deb", packager.Package{Name: name, Version: "1.0", Summary: "Test", Files: []packager.File{{Path: "usr/bin/" + name, Mode: 0755, Data: []byte(name)}}}, inputDir)
+		if err != nil {
+			t.Fatalf("Failed to build package: %v", err)
+		}
+		pkg, err := deb.ParsePackage(path)
+		if err != nil {
+			t.Fatalf("Failed to parse package: %v", err)
+		}
+		packages = append(packages, *pkg)
+	}
+
+	config := &models.RepositoryConfig{
+		InputDir:   inputDir,
+		OutputDir:  filepath.Join(tmpDir, "upstream"),
+		Codename:   "bookworm",
+		Suite:      "stable",
+		Components: []string{"main"},
+		Arches:     []string{"amd64"},
+	}
+	if err := deb.NewGenerator(nil).Generate(context.Background(), config, packages); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	server := httptest.NewServer(http.FileServer(http.Dir(config.OutputDir)))
+	defer server.Close()
+
+	upstream, err := New(server.URL, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	reader := deb.NewGenerator(nil).(generator.UpstreamReader)
+	listed, err := reader.ReadUpstream(context.Background(), config, upstream)
+	if err != nil {
+		t.Fatalf("ReadUpstream failed: %v", err)
+	}
+	if len(listed) != 3 {
+		t.Fatalf("Read %d upstream packages", len(listed))
+	}
+
+	// Filters select by metadata, --exclude by the path in the repository
+	config.Exclude = []string{"*-dbgsym_*"}
+	config.OnlyPackages = []string{"h*"}
+	selected := Select(config, scanner.TypeDeb, listed)
+	if len(selected) != 1 || selected[0].Name != "hello" {
+		t.Fatalf("Selected %+v", selected)
+	}
+
+	entries := upstream.Entries(selected)["main"]
+	if len(entries) != 1 {
+		t.Fatalf("Entries are %+v", entries)
+	}
+	want := server.URL + "/" + selected[0].Filename
+	if entries[0].URL != want || entries[0].SHA256 != packages[0].SHA256Sum || entries[0].Name != filepath.Base(selected[0].Filename) {
+		t.Errorf("Entry is %+v, expected %s with sha256 %s", entries[0], want, packages[0].SHA256Sum)
+	}
+
+	// Components and architectures the suite doesn't publish have no packages
+	config.Components = []string{"main", "contrib"}
+	config.Arches = []string{"amd64", "arm64"}
+	listed, err = reader.ReadUpstream(context.Background(), config, upstream)
+	if err != nil {
+		t.Fatalf("ReadUpstream of missing indexes failed: %v", err)
+	}
+	var names []string
+	for _, pkg := range listed {
+		names = append(names, pkg.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 3 || names[0] != "hello" {
+		t.Errorf("Read %v", names)
+	}
+}
@@ -11,6 +11,10 @@ const (
 	ErrSigning
 	ErrFileOp
 	ErrInvalidConfig
+	ErrTimeout
+	ErrVulnerable
+	ErrLint
+	ErrDependency
 )
 
 // String returns the string representation of ErrorType
@@ -26,6 +30,14 @@ func (e ErrorType) String() string {
 		return "FileOp"
 	case ErrInvalidConfig:
 		return "InvalidConfig"
+	case ErrTimeout:
+		return "Timeout"
+	case ErrVulnerable:
+		return "Vulnerable"
+	case ErrLint:
+		return "Lint"
+	case ErrDependency:
+		return "Dependency"
 	default:
 		return "Unknown"
 	}
@@ -1,5 +1,7 @@
 package models
 
+import "fmt"
+
 // Package represents a software package with its metadata
 type Package struct {
 	// Core metadata
@@ -10,18 +12,57 @@ type Package struct {
 	Maintainer   string
 	Homepage     string
 	License      string
+	Section      string // Debian section, e.g. "utils"
+	Priority     string // Debian priority, e.g. "optional"
+	MultiArch    string // Debian Multi-Arch: same, foreign, allowed or no
+	Groups       []string
+
+	// Relationships with other packages
 	Dependencies []string
+	Recommends   []string
+	Suggests     []string
+	Breaks       []string
 	Conflicts    []string
-	Groups       []string
+	Provides     []string
+	Replaces     []string
 
 	// File information
-	Filename  string
-	Size      int64
-	MD5Sum    string
-	SHA1Sum   string
-	SHA256Sum string
-	SHA512Sum string
+	Filename      string
+	Size          int64
+	InstalledSize int64 // Bytes used once installed, zero when unknown
+	MD5Sum        string
+	SHA1Sum       string
+	SHA256Sum     string
+	SHA512Sum     string
+
+	// Lifecycle information (nil unless the package is deprecated)
+	Deprecation *Deprecation
+
+	// Localized descriptions keyed by language code (e.g. "de", "pt_BR")
+	Translations map[string]string
 
 	// Type-specific metadata
 	Metadata map[string]interface{}
 }
+
+// Deprecation marks a package as deprecated or end-of-life
+type Deprecation struct {
+	Message    string // Free-form note shown to users
+	ReplacedBy string // Name of the package users should move to
+	EOL        string // End-of-life date (YYYY-MM-DD)
+}
+
+// Note returns a human-readable description of the deprecation
+func (d *Deprecation) Note() string {
+	note := "deprecated"
+	if d.EOL != "" {
+		note = fmt.Sprintf("end-of-life since %s", d.EOL)
+	}
+	if d.ReplacedBy != "" {
+		note += fmt.Sprintf(", replaced by %s", d.ReplacedBy)
+	}
+	if d.Message != "" {
+		note += fmt.Sprintf(": %s", d.Message)
+	}
+	return note
+}
@@ -1,16 +1,23 @@
 package models
 
+import "time"
+
 // RepositoryConfig contains configuration for repository generation
 type RepositoryConfig struct {
 	// Input/Output
 	InputDir  string
 	OutputDir string
+	Output    string // Registry or server the repository is pushed to after generation (oci://registry/repository[:tag], sftp://user@host/path)
+
+	// SFTP output
+	SSHKeyPath    string // Private key authenticating to sftp:// outputs, besides ssh-agent
+	SSHKnownHosts string // Known hosts file checking the keys of sftp:// servers
 
 	// Repository metadata
 	Origin     string
 	Label      string
 	RepoName   string   // Repository name for Pacman .db files and optional RPM .repo naming
-	Codename   string   // For Debian
+	Codename   string   // For Debian, comma-separated for several suites sharing the pool
 	Suite      string   // For Debian
 	Components []string // For Debian (main, contrib, etc.)
 	Arches     []string // Architectures to support
@@ -18,16 +25,120 @@ type RepositoryConfig struct {
 
 	// Signing
 	GPGKeyPath    string
+	GPGKeyID      string // Sign with this key of the user's GPG keyring (via gpg-agent) instead of GPGKeyPath
+	PKCS11URI     string // Sign with this RSA key of a PKCS#11 token (RFC 7512 URI) instead of GPGKeyPath
+	KMSKeyARN     string // Sign with this AWS KMS RSA or ECDSA key (ARN, ID or alias) instead of GPGKeyPath
 	GPGPassphrase string
 	RSAKeyPath    string
 	RSAPassphrase string
-	RSAKeyName    string // For Alpine
+	RSAKeyName    string // For Alpine, and the F-Droid repository certificate
+	SignRPMs      bool   // For RPM: embed GPG signatures into the packages themselves
+	Cosign        bool   // Also sign the metadata with Sigstore through cosign
+	CosignKey     string // cosign key of Cosign signatures, keyless when empty
+	RekorURL      string // Rekor instance recording Cosign signatures, the public one when empty
+	CosignNoTlog  bool   // Don't record Cosign signatures in Rekor
+	MinisignKey   string // Also sign the metadata with this minisign or signify secret key
+	MinisignPass  string // Passphrase of an encrypted MinisignKey
+	KeyExpiryDays int    // Warn about signing keys expiring within this many days
+	StrictKeys    bool   // Fail on signing key problems instead of warning
 
 	// Type-specific options
-	BaseURL       string // For Homebrew bottles and RPM .repo files
-	GPGKeyURL     string // For RPM: explicit GPG key URL (supports $releasever/$basearch variables)
-	DistroVariant string // For RPM: fedora, centos, rhel (affects .repo defaults)
+	BaseURL           string            // For Homebrew bottles, client setup files, Cargo registries, NuGet feeds and F-Droid repositories
+	BottleRootURL     string            // For Homebrew: root_url of bottle blocks, defaults to BaseURL/bottles
+	BottleCollisions  string            // For Homebrew: "error" or "digest", when a bottle would replace a published one
+	GPGKeyURL         string            // For RPM: explicit GPG key URL (supports $releasever/$basearch variables)
+	DistroVariant     string            // For RPM: fedora, centos, rhel, suse (affects .repo defaults)
+	RPMGroupsPath     string            // For RPM: YAML/JSON package groups file rendered as comps.xml
+	RPMAdvisoriesPath string            // For RPM: YAML/JSON advisories file rendered as updateinfo.xml
+	RPMSQLite         bool              // For RPM: also publish the primary, filelists and other sqlite databases, for yum
+	RPMZchunk         bool              // For RPM: also publish zchunk (.zck) metadata, for dnf delta downloads
+	RPMLayout         string            // For RPM: template of the repository directories (e.g. "{{.Version}}/{{.Arch}}")
+	RPMNoarch         string            // For RPM: "merge" (noarch packages in every architecture's repository) or "repo" (their own)
+	AptClients        []string          // For Debian: apt client releases (e.g. "debian-12") the signature must satisfy
+	DebLayout         string            // For Debian: "pool" (dists/ and pool/) or "flat" (everything at the root)
+	PacmanLayout      string            // For Pacman: "arch" (<arch>/) or "pool" (pool/, symlinked into os/<arch>/)
+	PacmanLinks       string            // For Pacman: "symlink" or "copy", for myrepo.db and pooled packages
+	DBCompression     string            // For Pacman databases and Debian Packages: zstd, xz, gz or none, the format's default when empty
+	CompressionLevel  int               // Level of DBCompression, 0 for the algorithm's default
+	ComponentMap      map[string]string // For Debian: component of the packages of each input subdirectory
+
+	// Debian Release fields
+	ReleaseValidFor      string // Valid-Until, from the Release date (e.g. "14d", "36h")
+	NotAutomatic         bool   // apt doesn't install from the repository unless asked to
+	ButAutomaticUpgrades bool   // With NotAutomatic, apt still upgrades packages installed from the repository
+	AcquireByHash        bool   // Indexes are also published under by-hash/, for atomic updates
+
+	// Build matrix, packaged before scanning
+	BuildMatrixPath string // YAML/JSON description of binaries built for several targets
+	BuildVersion    string // Version of the packages built, instead of the matrix's
+
+	// Remote packages, downloaded before scanning
+	InputManifestPath string // URL list, or YAML/JSON list of URLs with their SHA-256
+	FetchConcurrency  int    // Downloads run at once
+	FetchCacheDir     string // Keeps downloads across runs, none when empty
+
+	// Per-package overrides (deprecation flags, ...)
+	OverridesPath string
+
+	// Localized package descriptions
+	TranslationsPath string
+
+	// Package filters, applied to the scanned packages
+	Include      []string // Only scan files matching one of these globs
+	Exclude      []string // Skip files matching one of these globs
+	OnlyArches   []string // Only publish these architectures (arch-independent packages always pass)
+	OnlyPackages []string // Only publish packages whose name matches one of these globs
+	MinVersion   string   // Only publish packages at or above this version
+	MaxVersion   string   // Only publish packages at or below this version
+
+	// Vulnerability gate, checking packages before they are published
+	VulnPolicy string // fail, warn or ignore
+	VulnReport string // grype or trivy JSON report, instead of querying OSV
+	OSVURL     string // OSV API queried without VulnReport
+
+	// Dependency check, finding dependencies no published package satisfies
+	DepsPolicy string   // fail, warn or ignore
+	DepsAllow  []string // Globs of the names provided outside the repository, e.g. by the distribution
+
+	// Integrity
+	VerifyWrites bool // Re-hash copied packages and compare them to the source before referencing them
+
+	// Deadlines, zero meaning no limit
+	Timeout        time.Duration // Whole generate run
+	ScanTimeout    time.Duration // Finding package files in InputDir
+	ParseTimeout   time.Duration // Reading package metadata
+	PublishTimeout time.Duration // Copying packages, writing and signing metadata
+
+	// Monitoring
+	MetricsFile  string // JSON file receiving the metrics and spans of the run
+	MetricsPush  string // Prometheus Pushgateway receiving the metrics of the run
+	OTLPEndpoint string // OpenTelemetry collector receiving the spans of the run, over OTLP/HTTP
+	NoProgress   bool   // Don't report the progress of the run, on a bar or periodic log lines
+
+	// Reproducible output
+	Timestamp time.Time // Time recorded in metadata instead of now (--timestamp or SOURCE_DATE_EPOCH)
+
+	// Concurrent runs
+	LockWait time.Duration // How long to wait for another run to release the output directory, zero failing at once
+
+	// Static hosting
+	IndexPages    bool // Write index.html pages listing every directory, for hosts without directory listings
+	Checksums     bool // Write a signed SHA256SUMS of every package file at the repository root
+	ChecksumFiles bool // Also write a .sha256 next to every package file
+
+	// Large files
+	LargeFileSize   int      // Write download aids next to package files of at least this many MiB, zero for none
+	LargeFileAids   []string // zsync, metalink and torrent
+	MirrorURLs      []string // Mirrors of the repository, listed in metalinks and seeding torrents
+	TorrentTrackers []string // Trackers torrents are announced to
+
+	// Parallelism
+	Concurrency int // Architectures and package types generated at once
 
 	// Incremental mode
 	Incremental bool // Add new packages to existing repository without removing existing ones
+	ParseCache  bool // Reuse the metadata of packages unchanged since the previous run
+
+	// Packages with the same identity, scanned twice or already published
+	OnConflict string // fail, skip, replace or keep-both
 }
@@ -0,0 +1,308 @@
+package objstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// azureVersion is the version of the Blob service REST API requested
+const azureVersion = "2021-08-06"
+
+// azureBucket is a directory of an Azure Blob Storage container. Large
+// files are uploaded as blocks, then committed as a block list
+type azureBucket struct {
+	client
+	account   string
+	container string
+	prefix    string
+	endpoint  string
+	sas       url.Values // Shared access signature added to every request
+	key       []byte     // Account key signing requests
+}
+
+// newAzureBucket returns the directory prefix of the container of account,
+// authenticating with $AZURE_STORAGE_SAS_TOKEN, $AZURE_STORAGE_KEY, or an
+// access token of $AZURE_STORAGE_ACCESS_TOKEN or the az CLI
+func newAzureBucket(account, container, prefix string) (*azureBucket, error) {
+	b := &azureBucket{
+		account:   account,
+		container: container,
+		prefix:    prefix,
+		endpoint:  "https://" + account + ".blob.core.windows.net",
+	}
+	switch {
+	case os.Getenv("AZURE_STORAGE_SAS_TOKEN") != "":
+		sas, err := url.ParseQuery(strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_SAS_TOKEN: %w", err)
+		}
+		b.sas = sas
+		b.authorize = b.version
+	case os.Getenv("AZURE_STORAGE_KEY") != "":
+		key, err := base64.StdEncoding.DecodeString(os.Getenv("AZURE_STORAGE_KEY"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_KEY: %w", err)
+		}
+		b.key = key
+		b.authorize = b.signSharedKey
+	default:
+		tokens := &cliToken{
+			env:     "AZURE_STORAGE_ACCESS_TOKEN",
+			command: []string{"az", "account", "get-access-token", "--resource", "https://storage.azure.com/", "--query", "accessToken", "--output", "tsv"},
+		}
+		bearer := tokens.bearer()
+		b.authorize = func(req *http.Request) error {
+			b.version(req)
+			return bearer(req)
+		}
+	}
+	return b, nil
+}
+
+func (b *azureBucket) String() string {
+	return strings.TrimSuffix(AzureScheme+b.account+"/"+b.container+"/"+b.prefix, "/")
+}
+
+// blobURL returns the URL of the blob named key, with the query q
+func (b *azureBucket) blobURL(key string, q url.Values) string {
+	return b.url("/"+url.PathEscape(b.container)+"/"+escapePath(objectName(b.prefix, key)), q)
+}
+
+// url returns the URL of p, with the query q and the shared access
+// signature if any
+func (b *azureBucket) url(p string, q url.Values) string {
+	all := url.Values{}
+	for name, values := range b.sas {
+		all[name] = values
+	}
+	for name, values := range q {
+		all[name] = values
+	}
+	if len(all) == 0 {
+		return b.endpoint + p
+	}
+	return b.endpoint + p + "?" + all.Encode()
+}
+
+// version sets the date and API version of req
+func (b *azureBucket) version(req *http.Request) error {
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureVersion)
+	return nil
+}
+
+// signSharedKey authorizes req with the account key
+func (b *azureBucket) signSharedKey(req *http.Request) error {
+	b.version(req)
+	mac := hmac.New(sha256.New, b.key)
+	mac.Write([]byte(sharedKeyString(b.account, req)))
+	req.Header.Set("Authorization", "SharedKey "+b.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// sharedKeyString returns the string signed to authorize req with the key
+// of account
+func sharedKeyString(account string, req *http.Request) string {
+	length := ""
+	if req.ContentLength > 0 {
+		length = strconv.FormatInt(req.ContentLength, 10)
+	}
+	lines := []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		length,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date being set
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}
+
+	var headers []string
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-ms-") {
+			headers = append(headers, name+":"+strings.TrimSpace(strings.Join(values, ",")))
+		}
+	}
+	sort.Strings(headers)
+	lines = append(lines, headers...)
+
+	resource := "/" + account + req.URL.EscapedPath()
+	q := req.URL.Query()
+	names := make([]string, 0, len(q))
+	for name := range q {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := append([]string(nil), q[name]...)
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+	return strings.Join(append(lines, resource), "\n")
+}
+
+// azureBlobs is a page of a container listing
+type azureBlobs struct {
+	Blobs []struct {
+		Name          string `xml:"Name"`
+		ContentLength int64  `xml:"Properties>Content-Length"`
+		Metadata      struct {
+			SHA256 string `xml:"sha256"`
+		} `xml:"Metadata"`
+	} `xml:"Blobs>Blob"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+func (b *azureBucket) List(ctx context.Context) (map[string]Object, error) {
+	objects := make(map[string]Object)
+	prefix := objectName(b.prefix, "")
+	q := url.Values{"restype": {"container"}, "comp": {"list"}, "include": {"metadata"}, "prefix": {prefix}}
+	for {
+		resp, err := b.do(ctx, http.MethodGet, b.url("/"+url.PathEscape(b.container), q), nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkStatus(resp, "list "+b.String(), http.StatusOK); err != nil {
+			return nil, err
+		}
+		var page azureBlobs
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid listing of %s: %w", b, err)
+		}
+
+		for _, blob := range page.Blobs {
+			if key := strings.TrimPrefix(blob.Name, prefix); key != "" {
+				objects[key] = Object{Size: blob.ContentLength, SHA256: blob.Metadata.SHA256}
+			}
+		}
+		if page.NextMarker == "" {
+			return objects, nil
+		}
+		q.Set("marker", page.NextMarker)
+	}
+}
+
+func (b *azureBucket) Stat(ctx context.Context, key string) (*Object, error) {
+	resp, err := b.do(ctx, http.MethodHead, b.blobURL(key, nil), nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(resp, "stat "+key, http.StatusOK); err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &Object{Size: resp.ContentLength, SHA256: resp.Header.Get("x-ms-meta-" + sha256Metadata), MD5: resp.Header.Get("Content-MD5")}, nil
+}
+
+// header returns the headers setting the content type, cache control and
+// checksum of the blob of src
+func (b *azureBucket) header(src *Source) http.Header {
+	header := http.Header{}
+	header.Set("x-ms-blob-content-type", src.ContentType)
+	if src.CacheControl != "" {
+		header.Set("x-ms-blob-cache-control", src.CacheControl)
+	}
+	header.Set("x-ms-meta-"+sha256Metadata, src.SHA256)
+	return header
+}
+
+func (b *azureBucket) Put(ctx context.Context, key string, src *Source) error {
+	if src.Size >= MultipartSize {
+		return b.putBlocks(ctx, key, src)
+	}
+	header := b.header(src)
+	header.Set("x-ms-blob-type", "BlockBlob")
+	resp, err := b.do(ctx, http.MethodPut, b.blobURL(key, nil), header, sectionOpener(src.Path, 0, src.Size), src.Size)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp, "upload", http.StatusCreated); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// putBlocks uploads src as blocks, several at once, then commits them.
+// Blocks left uncommitted by failures are discarded by the service
+func (b *azureBucket) putBlocks(ctx context.Context, key string, src *Source) error {
+	blockID := func(n int) string {
+		// IDs of the blocks of a blob must all have the same length
+		return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", n)))
+	}
+	count, err := putParts(ctx, src, func(n int, open func() (io.ReadCloser, error), size int64) error {
+		q := url.Values{"comp": {"block"}, "blockid": {blockID(n)}}
+		resp, err := b.do(ctx, http.MethodPut, b.blobURL(key, q), nil, open, size)
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(resp, fmt.Sprintf("upload block %d", n), http.StatusCreated); err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	list := struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{}
+	for n := 1; n <= count; n++ {
+		list.Latest = append(list.Latest, blockID(n))
+	}
+	body, err := xml.Marshal(list)
+	if err != nil {
+		return err
+	}
+	body = append([]byte(xml.Header), body...)
+	header := b.header(src)
+	header.Set("Content-Type", "application/xml")
+	resp, err := b.do(ctx, http.MethodPut, b.blobURL(key, url.Values{"comp": {"blocklist"}}), header, bytesOpener(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp, "commit blocks", http.StatusCreated); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *azureBucket) Get(ctx context.Context, key string, w io.Writer) error {
+	return b.get(ctx, b.blobURL(key, nil), w)
+}
+
+func (b *azureBucket) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, b.blobURL(key, nil), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp, "delete", http.StatusAccepted, http.StatusNotFound); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,235 @@
+package objstore
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// gcsEndpoint serves the JSON and XML APIs of Google Cloud Storage
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// gcsBucket is a directory of a Google Cloud Storage bucket. Objects are
+// listed with the JSON API, which returns their metadata, and written with
+// the XML API, which uploads large files in parts
+type gcsBucket struct {
+	client
+	bucket   string
+	prefix   string
+	endpoint string
+}
+
+// newGCSBucket returns the directory prefix of bucket, authenticating with
+// $GOOGLE_OAUTH_ACCESS_TOKEN or gcloud. Like the Google Cloud SDKs,
+// $STORAGE_EMULATOR_HOST replaces the service by an unauthenticated emulator
+func newGCSBucket(bucket, prefix string) *gcsBucket {
+	b := &gcsBucket{bucket: bucket, prefix: prefix, endpoint: gcsEndpoint}
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		b.endpoint = strings.TrimSuffix(host, "/")
+		if !strings.Contains(host, "://") {
+			b.endpoint = "http://" + b.endpoint
+		}
+		return b
+	}
+	tokens := &cliToken{env: "GOOGLE_OAUTH_ACCESS_TOKEN", command: []string{"gcloud", "auth", "print-access-token"}}
+	b.authorize = tokens.bearer()
+	return b
+}
+
+func (b *gcsBucket) String() string {
+	return strings.TrimSuffix(GCSScheme+b.bucket+"/"+b.prefix, "/")
+}
+
+// objectURL returns the XML API URL of the object named key
+func (b *gcsBucket) objectURL(key string) string {
+	return b.endpoint + "/" + url.PathEscape(b.bucket) + "/" + escapePath(objectName(b.prefix, key))
+}
+
+// gcsObjects is a page of a JSON API listing
+type gcsObjects struct {
+	Items []struct {
+		Name     string            `json:"name"`
+		Size     int64             `json:"size,string"`
+		Metadata map[string]string `json:"metadata"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func (b *gcsBucket) List(ctx context.Context) (map[string]Object, error) {
+	objects := make(map[string]Object)
+	prefix := objectName(b.prefix, "")
+	q := url.Values{"prefix": {prefix}, "fields": {"items(name,size,metadata),nextPageToken"}}
+	for {
+		resp, err := b.do(ctx, http.MethodGet, b.endpoint+"/storage/v1/b/"+url.PathEscape(b.bucket)+"/o?"+q.Encode(), nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkStatus(resp, "list "+b.String(), http.StatusOK); err != nil {
+			return nil, err
+		}
+		var page gcsObjects
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid listing of %s: %w", b, err)
+		}
+
+		for _, item := range page.Items {
+			key := strings.TrimPrefix(item.Name, prefix)
+			// Folders of the console are empty objects named after them
+			if key == "" || strings.HasSuffix(key, "/") {
+				continue
+			}
+			objects[key] = Object{Size: item.Size, SHA256: item.Metadata[sha256Metadata]}
+		}
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		q.Set("pageToken", page.NextPageToken)
+	}
+}
+
+func (b *gcsBucket) Stat(ctx context.Context, key string) (*Object, error) {
+	q := url.Values{"fields": {"size,metadata,md5Hash"}}
+	objectURL := b.endpoint + "/storage/v1/b/" + url.PathEscape(b.bucket) + "/o/" + url.PathEscape(objectName(b.prefix, key)) + "?" + q.Encode()
+	resp, err := b.do(ctx, http.MethodGet, objectURL, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(resp, "stat "+key, http.StatusOK); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var item struct {
+		Size     int64             `json:"size,string"`
+		Metadata map[string]string `json:"metadata"`
+		MD5Hash  string            `json:"md5Hash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		return nil, fmt.Errorf("invalid metadata of %s: %w", key, err)
+	}
+	return &Object{Size: item.Size, SHA256: item.Metadata[sha256Metadata], MD5: item.MD5Hash}, nil
+}
+
+// header returns the headers setting the content type, cache control and
+// checksum of the object of src
+func (b *gcsBucket) header(src *Source) http.Header {
+	header := http.Header{}
+	header.Set("Content-Type", src.ContentType)
+	if src.CacheControl != "" {
+		header.Set("Cache-Control", src.CacheControl)
+	}
+	header.Set("x-goog-meta-"+sha256Metadata, src.SHA256)
+	return header
+}
+
+func (b *gcsBucket) Put(ctx context.Context, key string, src *Source) error {
+	if src.Size >= MultipartSize {
+		return b.putMultipart(ctx, key, src)
+	}
+	resp, err := b.do(ctx, http.MethodPut, b.objectURL(key), b.header(src), sectionOpener(src.Path, 0, src.Size), src.Size)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp, "upload", http.StatusOK); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// gcsPart is a part of a multipart upload, as completing it lists them
+type gcsPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart uploads src in parts, several at once, then has them
+// joined. The upload is aborted on failure, not to be billed for its parts
+func (b *gcsBucket) putMultipart(ctx context.Context, key string, src *Source) error {
+	objectURL := b.objectURL(key)
+	resp, err := b.do(ctx, http.MethodPost, objectURL+"?uploads", b.header(src), nil, 0)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp, "start multipart upload", http.StatusOK); err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("no multipart upload ID returned: %v", err)
+	}
+	uploadURL := objectURL + "?uploadId=" + url.QueryEscape(initiated.UploadID)
+
+	parts := make([]gcsPart, (src.Size+PartSize-1)/PartSize)
+	_, err = putParts(ctx, src, func(n int, open func() (io.ReadCloser, error), size int64) error {
+		resp, err := b.do(ctx, http.MethodPut, fmt.Sprintf("%s&partNumber=%d", uploadURL, n), nil, open, size)
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(resp, fmt.Sprintf("upload part %d", n), http.StatusOK); err != nil {
+			return err
+		}
+		resp.Body.Close()
+		parts[n-1] = gcsPart{PartNumber: n, ETag: resp.Header.Get("ETag")}
+		return nil
+	})
+	if err == nil {
+		err = b.completeMultipart(ctx, uploadURL, parts)
+	}
+	if err != nil {
+		// A background context, as the upload may have been canceled
+		if resp, abortErr := b.do(context.Background(), http.MethodDelete, uploadURL, nil, nil, 0); abortErr == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+// completeMultipart joins the uploaded parts into the object
+func (b *gcsBucket) completeMultipart(ctx context.Context, uploadURL string, parts []gcsPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name  `xml:"CompleteMultipartUpload"`
+		Parts   []gcsPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/xml"}}
+	resp, err := b.do(ctx, http.MethodPost, uploadURL, header, bytesOpener(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp, "complete multipart upload", http.StatusOK); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *gcsBucket) Get(ctx context.Context, key string, w io.Writer) error {
+	return b.get(ctx, b.objectURL(key), w)
+}
+
+func (b *gcsBucket) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, b.objectURL(key), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp, "delete", http.StatusNoContent, http.StatusOK, http.StatusNotFound); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,162 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// attempts is the number of times requests are sent before giving up on
+// throttling and server errors
+const attempts = 4
+
+// client sends the requests of a bucket, retrying those the service
+// couldn't handle at the time
+type client struct {
+	HTTP      *http.Client                  // nil for http.DefaultClient
+	authorize func(req *http.Request) error // Authenticates requests, nil for anonymous access
+}
+
+// do sends a request with header, retrying throttled and failed ones. open
+// returns the body, of size bytes, so it can be sent again
+func (c *client) do(ctx context.Context, method, rawURL string, header http.Header, open func() (io.ReadCloser, error), size int64) (*http.Response, error) {
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(1<<attempt) * 250 * time.Millisecond):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		var body io.ReadCloser
+		if open != nil {
+			var err error
+			if body, err = open(); err != nil {
+				return nil, err
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+		if err != nil {
+			if body != nil {
+				body.Close()
+			}
+			return nil, err
+		}
+		req.ContentLength = size
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if c.authorize != nil {
+			if err := c.authorize(req); err != nil {
+				if body != nil {
+					body.Close()
+				}
+				return nil, err
+			}
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			lastErr = checkStatus(resp, strings.ToLower(method)+" "+req.URL.Path)
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// get sends a GET request and writes the body of its 200 response to w
+func (c *client) get(ctx context.Context, rawURL string, w io.Writer) error {
+	resp, err := c.do(ctx, http.MethodGet, rawURL, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp, "download", http.StatusOK); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// bytesOpener returns a function opening data, so that requests can be
+// sent again
+func bytesOpener(data []byte) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+// tokenLifetime is how long access tokens are reused, shorter than the
+// hour they last
+const tokenLifetime = 30 * time.Minute
+
+// cliToken gets OAuth access tokens from the environment variable env, or
+// from the CLI of the cloud provider, so that every credential source it
+// supports works
+type cliToken struct {
+	env     string
+	command []string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// get returns a valid access token
+func (t *cliToken) get() (string, error) {
+	if token := os.Getenv(t.env); token != "" {
+		return token, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Before(t.expires) {
+		return t.token, nil
+	}
+
+	cmd := exec.Command(t.command[0], t.command[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to get an access token (set %s, or log in with %s): %w\nOutput: %s", t.env, t.command[0], err, stderr.String())
+	}
+	t.token = strings.TrimSpace(stdout.String())
+	t.expires = time.Now().Add(tokenLifetime)
+	return t.token, nil
+}
+
+// bearer returns a function authorizing requests with the tokens of t
+func (t *cliToken) bearer() func(req *http.Request) error {
+	return func(req *http.Request) error {
+		token, err := t.get()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+}
@@ -0,0 +1,214 @@
+// Package objstore publishes repositories to object storage buckets:
+// Google Cloud Storage (gs://bucket/prefix) and Azure Blob Storage
+// (az://account/container/prefix). Objects carry the content type clients
+// expect, and the SHA-256 of their content in their metadata, so that only
+// changed files are uploaded again
+package objstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// Schemes of the bucket URLs
+const (
+	GCSScheme   = "gs://"
+	AzureScheme = "az://"
+)
+
+// sha256Metadata is the metadata key holding the SHA-256 of objects
+const sha256Metadata = "sha256"
+
+// Multipart uploads of large files
+const (
+	// MultipartSize is the size from which files are uploaded in parts
+	MultipartSize = 64 << 20
+	// PartSize is the size of the parts of multipart uploads
+	PartSize = 16 << 20
+	// partUploads is the number of parts of a file uploaded at once
+	partUploads = 4
+)
+
+// Object is a file stored in a bucket
+type Object struct {
+	Size   int64
+	SHA256 string // Hex, empty for objects repogen didn't upload
+	MD5    string // Base64, computed by the service; empty when listing, and for objects uploaded in parts
+}
+
+// Source is a local file uploaded as an object
+type Source struct {
+	Path         string
+	Size         int64
+	SHA256       string
+	ContentType  string
+	CacheControl string
+}
+
+// Bucket is a directory of a bucket, objects being named by their path
+// relative to it
+type Bucket interface {
+	// List returns the objects under the directory, by relative path
+	List(ctx context.Context) (map[string]Object, error)
+	// Stat returns the object named key, as stored by the service
+	Stat(ctx context.Context, key string) (*Object, error)
+	// Put uploads src as the object named key, in parts when it is large
+	Put(ctx context.Context, key string, src *Source) error
+	// Get writes the content of the object named key to w
+	Get(ctx context.Context, key string, w io.Writer) error
+	// Delete removes the object named key, if it exists
+	Delete(ctx context.Context, key string) error
+	// String returns the URL of the directory
+	String() string
+}
+
+// IsURL reports whether s is the URL of a bucket
+func IsURL(s string) bool {
+	return strings.HasPrefix(s, GCSScheme) || strings.HasPrefix(s, AzureScheme)
+}
+
+// Open returns the bucket directory of rawURL, authenticating with the
+// credentials of the environment
+func Open(rawURL string) (Bucket, error) {
+	switch {
+	case strings.HasPrefix(rawURL, GCSScheme):
+		bucket, prefix, err := splitURL(rawURL, GCSScheme, 1)
+		if err != nil {
+			return nil, err
+		}
+		return newGCSBucket(bucket[0], prefix), nil
+	case strings.HasPrefix(rawURL, AzureScheme):
+		names, prefix, err := splitURL(rawURL, AzureScheme, 2)
+		if err != nil {
+			return nil, err
+		}
+		return newAzureBucket(names[0], names[1], prefix)
+	}
+	return nil, fmt.Errorf("%q is not a %s or %s URL", rawURL, GCSScheme, AzureScheme)
+}
+
+// splitURL splits scheme://name.../prefix into its n leading names and
+// the cleaned prefix, empty for the root of the bucket
+func splitURL(rawURL, scheme string, n int) ([]string, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(rawURL, scheme), "/", n+1)
+	if len(parts) < n {
+		return nil, "", fmt.Errorf("%q lacks a bucket or container", rawURL)
+	}
+	for _, name := range parts[:n] {
+		if name == "" {
+			return nil, "", fmt.Errorf("%q lacks a bucket or container", rawURL)
+		}
+	}
+	prefix := ""
+	if len(parts) > n {
+		prefix = strings.Trim(path.Clean("/"+parts[n]), "/")
+	}
+	return parts[:n], prefix, nil
+}
+
+// objectName joins the prefix of a bucket directory and a relative path
+func objectName(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "/" + key
+}
+
+// escapePath escapes the segments of an object name for a URL path
+func escapePath(name string) string {
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// contentTypes maps file extensions to the content types of their objects
+var contentTypes = map[string]string{
+	".asc":     "text/plain; charset=utf-8",
+	".bz2":     "application/x-bzip2",
+	".db":      "application/octet-stream",
+	".deb":     "application/vnd.debian.binary-package",
+	".gpg":     "application/pgp-signature",
+	".gz":      "application/gzip",
+	".html":    "text/html; charset=utf-8",
+	".json":    "application/json",
+	".meta4":   "application/metalink4+xml",
+	".minisig": "text/plain; charset=utf-8",
+	".pem":     "application/x-pem-file",
+	".pub":     "text/plain; charset=utf-8",
+	".repo":    "text/plain; charset=utf-8",
+	".rpm":     "application/x-rpm",
+	".sha256":  "text/plain; charset=utf-8",
+	".sqlite":  "application/vnd.sqlite3",
+	".torrent": "application/x-bittorrent",
+	".xml":     "application/xml",
+	".xz":      "application/x-xz",
+	".zck":     "application/zchunk",
+	".zsync":   "application/x-zsync",
+	".zst":     "application/zstd",
+}
+
+// textFiles are the files without extension holding text metadata
+var textFiles = map[string]bool{
+	"Release": true, "InRelease": true, "Packages": true, "Sources": true,
+	"Contents": true, "Translation": true, "SHA256SUMS": true, "Index": true,
+}
+
+// ContentType returns the content type of the object of the file name:
+// text for Debian indexes and checksums, so that browsers show them
+func ContentType(name string) string {
+	base := path.Base(name)
+	if t, ok := contentTypes[path.Ext(base)]; ok {
+		return t
+	}
+	if textFiles[base] || strings.HasPrefix(base, "Contents-") || strings.HasPrefix(base, "Translation-") {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// checkStatus returns an error describing resp unless its status is one of
+// ok, closing its body then
+func checkStatus(resp *http.Response, action string, ok ...int) error {
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("failed to %s: %s: %s", action, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// sectionOpener returns a function opening the size bytes of file at
+// offset, so that requests can be sent again
+func sectionOpener(file string, offset, size int64) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.NewSectionReader(f, offset, size), f}, nil
+	}
+}
+
+// putParts uploads the parts of src with put, partUploads at once, and
+// returns their number
+func putParts(ctx context.Context, src *Source, put func(n int, open func() (io.ReadCloser, error), size int64) error) (int, error) {
+	count := int((src.Size + PartSize - 1) / PartSize)
+	return count, parallelN(ctx, partUploads, count, func(i int) error {
+		offset := int64(i) * PartSize
+		size := min(PartSize, src.Size-offset)
+		return put(i+1, sectionOpener(src.Path, offset, size), size)
+	})
+}
@@ -0,0 +1,493 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ralt/repogen/internal/lock"
+	"github.com/ralt/repogen/internal/staging"
+)
+
+// fakeObject is an object of a fake bucket, with the headers it was
+// uploaded with
+type fakeObject struct {
+	data         []byte
+	contentType  string
+	cacheControl string
+	sha256       string
+	md5          string // Base64, only for objects uploaded whole
+}
+
+// fakeStore holds the objects of the fake services
+type fakeStore struct {
+	mu       sync.Mutex
+	objects  map[string]*fakeObject
+	parts    map[string][]byte // Uploaded parts or blocks, by upload and number or block ID
+	uploads  map[string]*fakeObject
+	puts     []string // Names of the objects written, in order
+	failures int      // Requests answered with 503 before the next ones succeed
+	corrupt  bool     // Whether objects uploaded whole are stored with their first byte changed
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{objects: make(map[string]*fakeObject), parts: make(map[string][]byte), uploads: make(map[string]*fakeObject)}
+}
+
+// throttle answers the request with 503 if failures are left
+func (s *fakeStore) throttle(w http.ResponseWriter) bool {
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return true
+	}
+	return false
+}
+
+func (s *fakeStore) put(name string, obj *fakeObject) {
+	s.objects[name] = obj
+	s.puts = append(s.puts, name)
+}
+
+// putWhole stores an object uploaded in a single request, with the MD5
+// of the content stored
+func (s *fakeStore) putWhole(name string, obj *fakeObject, data []byte) {
+	if s.corrupt && len(data) > 0 {
+		data[0]++
+	}
+	sum := md5.Sum(data)
+	obj.data = data
+	obj.md5 = base64.StdEncoding.EncodeToString(sum[:])
+	s.put(name, obj)
+}
+
+// names returns the object names with prefix, sorted
+func (s *fakeStore) names(prefix string) []string {
+	var names []string
+	for name := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// gcsHandler serves the JSON and XML APIs of bucket "bkt", requiring a
+// bearer token
+func gcsHandler(s *fakeStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if s.throttle(w) {
+			return
+		}
+		q := r.URL.Query()
+
+		if r.URL.Path == "/storage/v1/b/bkt/o" {
+			// Pages of 2 objects, the token being the index of the next one
+			names := s.names(q.Get("prefix"))
+			start, _ := strconv.Atoi(q.Get("pageToken"))
+			var page gcsObjects
+			for i := start; i < len(names) && i < start+2; i++ {
+				obj := s.objects[names[i]]
+				page.Items = append(page.Items, struct {
+					Name     string            `json:"name"`
+					Size     int64             `json:"size,string"`
+					Metadata map[string]string `json:"metadata"`
+				}{names[i], int64(len(obj.data)), map[string]string{"sha256": obj.sha256}})
+			}
+			if start+2 < len(names) {
+				page.NextPageToken = strconv.Itoa(start + 2)
+			}
+			json.NewEncoder(w).Encode(page)
+			return
+		}
+		if name, ok := strings.CutPrefix(r.URL.Path, "/storage/v1/b/bkt/o/"); ok {
+			obj, ok := s.objects[name]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"size": strconv.Itoa(len(obj.data)), "metadata": map[string]string{"sha256": obj.sha256}, "md5Hash": obj.md5})
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/bkt/")
+		headers := func() *fakeObject {
+			return &fakeObject{contentType: r.Header.Get("Content-Type"), cacheControl: r.Header.Get("Cache-Control"), sha256: r.Header.Get("x-goog-meta-sha256")}
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodPost && q.Has("uploads"):
+			id := fmt.Sprintf("upload-%d", len(s.uploads))
+			s.uploads[id] = headers()
+			fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>bkt</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", name, id)
+		case r.Method == http.MethodPut && q.Has("uploadId"):
+			s.parts[q.Get("uploadId")+"/"+q.Get("partNumber")] = body
+			w.Header().Set("ETag", `"etag-`+q.Get("partNumber")+`"`)
+		case r.Method == http.MethodPost && q.Has("uploadId"):
+			var complete struct {
+				Parts []gcsPart `xml:"Part"`
+			}
+			xml.Unmarshal(body, &complete)
+			obj := s.uploads[q.Get("uploadId")]
+			for i, part := range complete.Parts {
+				if part.PartNumber != i+1 || part.ETag != fmt.Sprintf(`"etag-%d"`, i+1) {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				obj.data = append(obj.data, s.parts[q.Get("uploadId")+"/"+strconv.Itoa(part.PartNumber)]...)
+			}
+			s.put(name, obj)
+		case r.Method == http.MethodPut:
+			s.putWhole(name, headers(), body)
+		case r.Method == http.MethodGet || r.Method == http.MethodDelete:
+			obj, ok := s.objects[name]
+			switch {
+			case !ok:
+				w.WriteHeader(http.StatusNotFound)
+			case r.Method == http.MethodGet:
+				w.Write(obj.data)
+			default:
+				delete(s.objects, name)
+				w.WriteHeader(http.StatusNoContent)
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}
+}
+
+// azureHandler serves container "cont" of account "acct", checking the
+// shared key signature of requests
+func azureHandler(s *fakeStore, key []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(sharedKeyString("acct", r)))
+		if r.Header.Get("Authorization") != "SharedKey acct:"+base64.StdEncoding.EncodeToString(mac.Sum(nil)) || r.Header.Get("x-ms-version") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if s.throttle(w) {
+			return
+		}
+		q := r.URL.Query()
+
+		if r.URL.Path == "/cont" && q.Get("comp") == "list" {
+			var out bytes.Buffer
+			out.WriteString("<EnumerationResults><Blobs>")
+			for _, name := range s.names(q.Get("prefix")) {
+				obj := s.objects[name]
+				fmt.Fprintf(&out, "<Blob><Name>%s</Name><Properties><Content-Length>%d</Content-Length></Properties><Metadata><sha256>%s</sha256></Metadata></Blob>", name, len(obj.data), obj.sha256)
+			}
+			out.WriteString("</Blobs><NextMarker/></EnumerationResults>")
+			w.Write(out.Bytes())
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/cont/")
+		headers := func() *fakeObject {
+			return &fakeObject{contentType: r.Header.Get("x-ms-blob-content-type"), cacheControl: r.Header.Get("x-ms-blob-cache-control"), sha256: r.Header.Get("x-ms-meta-sha256")}
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodPut && q.Get("comp") == "block":
+			s.parts[name+"/"+q.Get("blockid")] = body
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+			var list struct {
+				Latest []string `xml:"Latest"`
+			}
+			xml.Unmarshal(body, &list)
+			obj := headers()
+			for _, id := range list.Latest {
+				obj.data = append(obj.data, s.parts[name+"/"+id]...)
+			}
+			s.put(name, obj)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut && r.Header.Get("x-ms-blob-type") == "BlockBlob":
+			s.putWhole(name, headers(), body)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodHead || r.Method == http.MethodGet || r.Method == http.MethodDelete:
+			obj, ok := s.objects[name]
+			switch {
+			case !ok:
+				w.WriteHeader(http.StatusNotFound)
+			case r.Method == http.MethodHead:
+				w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+				w.Header().Set("x-ms-meta-sha256", obj.sha256)
+				if obj.md5 != "" {
+					w.Header().Set("Content-MD5", obj.md5)
+				}
+			case r.Method == http.MethodGet:
+				w.Write(obj.data)
+			default:
+				delete(s.objects, name)
+				w.WriteHeader(http.StatusAccepted)
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}
+}
+
+func newTestGCSBucket(t *testing.T, s *fakeStore) *gcsBucket {
+	t.Setenv("STORAGE_EMULATOR_HOST", "")
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "test-token")
+	srv := httptest.NewServer(gcsHandler(s))
+	t.Cleanup(srv.Close)
+	b := newGCSBucket("bkt", "repo")
+	b.endpoint = srv.URL
+	return b
+}
+
+func newTestAzureBucket(t *testing.T, s *fakeStore) *azureBucket {
+	key := []byte("account key")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_STORAGE_KEY", base64.StdEncoding.EncodeToString(key))
+	srv := httptest.NewServer(azureHandler(s, key))
+	t.Cleanup(srv.Close)
+	b, err := newAzureBucket("acct", "cont", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.endpoint = srv.URL
+	return b
+}
+
+func writeFile(t *testing.T, dir, rel string, data []byte) {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(rel))
+	os.MkdirAll(filepath.Dir(p), 0755)
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testSync uploads a repository to b, stored in s, then downloads it back
+func testSync(t *testing.T, b Bucket, s *fakeStore) {
+	local := t.TempDir()
+	writeFile(t, local, "pool/hello.deb", []byte("package"))
+	writeFile(t, local, "dists/stable/Release", []byte("Release"))
+	writeFile(t, local, "dists/stable/InRelease", []byte("InRelease"))
+	writeFile(t, local, "dists/stable/main/binary-amd64/Packages.gz", []byte("Packages"))
+	writeFile(t, local, lock.Path, nil)
+	s.objects["repo/pool/old.deb"] = &fakeObject{data: []byte("old")}
+	s.objects["elsewhere/kept.deb"] = &fakeObject{data: []byte("kept")}
+	// The first request is throttled, and sent again
+	s.failures = 1
+
+	files, _ := staging.Files(local)
+	stages, err := staging.Stages(local, files, []string{"pool/hello.deb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	result, err := Upload(ctx, b, local, stages, true)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if result.Transferred != 4 || result.Removed != 1 || result.Bytes != int64(len("packageReleaseInReleasePackages")) {
+		t.Errorf("Upload = %+v", result)
+	}
+	if got := strings.Join(s.names(""), " "); got != "elsewhere/kept.deb repo/dists/stable/InRelease repo/dists/stable/Release repo/dists/stable/main/binary-amd64/Packages.gz repo/pool/hello.deb" {
+		t.Errorf("Objects = %s", got)
+	}
+	if s.puts[0] != "repo/pool/hello.deb" || s.puts[3] != "repo/dists/stable/InRelease" {
+		t.Errorf("Upload order = %v", s.puts)
+	}
+
+	sum := sha256.Sum256([]byte("package"))
+	if pkg := s.objects["repo/pool/hello.deb"]; pkg.contentType != "application/vnd.debian.binary-package" || pkg.cacheControl != "" || pkg.sha256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Package object = %+v", pkg)
+	}
+	if release := s.objects["repo/dists/stable/Release"]; release.contentType != "text/plain; charset=utf-8" || release.cacheControl != "no-cache" {
+		t.Errorf("Release object = %+v", release)
+	}
+	if index := s.objects["repo/dists/stable/main/binary-amd64/Packages.gz"]; index.contentType != "application/gzip" {
+		t.Errorf("Index object = %+v", index)
+	}
+
+	// Nothing changed: nothing is uploaded
+	s.puts = nil
+	if result, err := Upload(ctx, b, local, stages, true); err != nil || result.Transferred != 0 || result.Unchanged != 4 || len(s.puts) != 0 {
+		t.Errorf("Second upload = %+v, %v", result, err)
+	}
+
+	copyDir := t.TempDir()
+	writeFile(t, copyDir, "pool/removed.deb", []byte("removed"))
+	writeFile(t, copyDir, "dists/stable/Release", []byte("Release"))
+	result, err = Download(ctx, b, copyDir)
+	if err != nil || result.Transferred != 3 || result.Unchanged != 1 || result.Removed != 1 {
+		t.Fatalf("Download = %+v, %v", result, err)
+	}
+	for _, f := range files {
+		want, _ := os.ReadFile(filepath.Join(local, f))
+		if got, _ := os.ReadFile(filepath.Join(copyDir, f)); !bytes.Equal(got, want) {
+			t.Errorf("Downloaded %s differs", f)
+		}
+	}
+}
+
+// testVerify uploads a package to b, stored in s, that the service stores
+// corrupted
+func testVerify(t *testing.T, b Bucket, s *fakeStore) {
+	local := t.TempDir()
+	writeFile(t, local, "pool/hello.deb", []byte("package"))
+	s.corrupt = true
+
+	stages := [][]string{{"pool/hello.deb"}}
+	if _, err := Upload(context.Background(), b, local, stages, false); err != nil {
+		t.Fatalf("Upload without verification failed: %v", err)
+	}
+	delete(s.objects, "repo/pool/hello.deb")
+	_, err := Upload(context.Background(), b, local, stages, true)
+	if err == nil || !strings.Contains(err.Error(), "failed to verify pool/hello.deb") {
+		t.Fatalf("Upload = %v, want a verification failure", err)
+	}
+	if _, ok := s.objects["repo/pool/hello.deb"]; ok {
+		t.Error("Corrupted object was kept")
+	}
+}
+
+// testMultipart uploads a file of two parts to b, stored in s
+func testMultipart(t *testing.T, b Bucket, s *fakeStore) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789abcdef"), (PartSize+4096)/16)
+	writeFile(t, dir, "big.rpm", data)
+	sum := sha256.Sum256(data)
+	src := &Source{Path: filepath.Join(dir, "big.rpm"), Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:]), ContentType: ContentType("big.rpm")}
+
+	var err error
+	switch b := b.(type) {
+	case *gcsBucket:
+		err = b.putMultipart(context.Background(), "big.rpm", src)
+	case *azureBucket:
+		err = b.putBlocks(context.Background(), "big.rpm", src)
+	}
+	if err != nil {
+		t.Fatalf("Multipart upload failed: %v", err)
+	}
+	if len(s.parts) != 2 {
+		t.Errorf("Uploaded %d parts", len(s.parts))
+	}
+	obj := s.objects["repo/big.rpm"]
+	if obj == nil || !bytes.Equal(obj.data, data) || obj.contentType != "application/x-rpm" || obj.sha256 != src.SHA256 {
+		t.Fatalf("Multipart object differs")
+	}
+}
+
+func TestGCS(t *testing.T) {
+	s := newFakeStore()
+	testSync(t, newTestGCSBucket(t, s), s)
+}
+
+func TestGCSVerify(t *testing.T) {
+	s := newFakeStore()
+	testVerify(t, newTestGCSBucket(t, s), s)
+}
+
+func TestGCSMultipart(t *testing.T) {
+	s := newFakeStore()
+	testMultipart(t, newTestGCSBucket(t, s), s)
+}
+
+func TestAzure(t *testing.T) {
+	s := newFakeStore()
+	testSync(t, newTestAzureBucket(t, s), s)
+}
+
+func TestAzureVerify(t *testing.T) {
+	s := newFakeStore()
+	testVerify(t, newTestAzureBucket(t, s), s)
+}
+
+func TestAzureMultipart(t *testing.T) {
+	s := newFakeStore()
+	testMultipart(t, newTestAzureBucket(t, s), s)
+}
+
+func TestSharedKeyString(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPut, "https://acct.blob.core.windows.net/cont/pool/my%20app.deb?comp=block&blockid=MDAwMDAwMDE%3D", strings.NewReader("data"))
+	req.Header.Set("x-ms-date", "Mon, 02 Jan 2006 15:04:05 GMT")
+	req.Header.Set("x-ms-version", azureVersion)
+	req.Header.Set("X-Ms-Meta-Sha256", "abc")
+
+	want := "PUT\n\n\n4\n\n\n\n\n\n\n\n\n" +
+		"x-ms-date:Mon, 02 Jan 2006 15:04:05 GMT\nx-ms-meta-sha256:abc\nx-ms-version:" + azureVersion + "\n" +
+		"/acct/cont/pool/my%20app.deb\nblockid:MDAwMDAwMDE=\ncomp:block"
+	if got := sharedKeyString("acct", req); got != want {
+		t.Errorf("sharedKeyString = %q\nwant %q", got, want)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	t.Setenv("AZURE_STORAGE_KEY", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "?sv=2021&sig=abc")
+	tests := []struct {
+		url, want string
+	}{
+		{"gs://bkt", "gs://bkt"},
+		{"gs://bkt/repo/apt/", "gs://bkt/repo/apt"},
+		{"gs://bkt/../x", "gs://bkt/x"},
+		{"az://acct/cont/repo", "az://acct/cont/repo"},
+	}
+	for _, tt := range tests {
+		b, err := Open(tt.url)
+		if err != nil || b.String() != tt.want {
+			t.Errorf("Open(%s) = %v, %v, want %s", tt.url, b, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"gs://", "gs:///repo", "az://acct", "az://acct//repo", "s3://bkt"} {
+		if _, err := Open(bad); err == nil {
+			t.Errorf("Open(%s) succeeded", bad)
+		}
+	}
+
+	b, _ := Open("az://acct/cont/repo")
+	if got := b.(*azureBucket).blobURL("a b.deb", nil); got != "https://acct.blob.core.windows.net/cont/repo/a%20b.deb?sig=abc&sv=2021" {
+		t.Errorf("blobURL = %s", got)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	tests := map[string]string{
+		"dists/stable/InRelease":                     "text/plain; charset=utf-8",
+		"dists/stable/main/Contents-amd64":           "text/plain; charset=utf-8",
+		"dists/stable/main/binary-amd64/Packages.xz": "application/x-xz",
+		"repodata/repomd.xml":                        "application/xml",
+		"repodata/abc-primary.sqlite.bz2":            "application/x-bzip2",
+		"x86_64/hello.pkg.tar.zst":                   "application/zstd",
+		"x86_64/hello-1.0-r0.apk":                    "application/octet-stream",
+		"index.html":                                 "text/html; charset=utf-8",
+	}
+	for name, want := range tests {
+		if got := ContentType(name); got != want {
+			t.Errorf("ContentType(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package objstore
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ralt/repogen/internal/staging"
+)
+
+// Uploads is the number of files transferred at once
+const Uploads = 8
+
+// Upload uploads the files of localDir whose content changed to b, stage
+// by stage, then removes the objects localDir no longer has. Packages, in
+// the first stage, never change once published; other files are metadata
+// updated in place, that caches must revalidate. With verify, every object
+// uploaded is checked against its file
+func Upload(ctx context.Context, b Bucket, localDir string, stages [][]string, verify bool) (*staging.Result, error) {
+	remote, err := b.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &staging.Result{}
+	local := make(map[string]bool)
+
+	var transferred, unchanged atomic.Int32
+	var bytes atomic.Int64
+	for i, stage := range stages {
+		cacheControl := "no-cache"
+		if i == 0 {
+			cacheControl = ""
+		}
+		err := parallel(ctx, stage, func(rel string) error {
+			localPath := filepath.Join(localDir, filepath.FromSlash(rel))
+			info, err := os.Stat(localPath)
+			if err != nil {
+				return err
+			}
+			sum, err := fileSHA256(localPath)
+			if err != nil {
+				return err
+			}
+			if obj, ok := remote[rel]; ok && obj.Size == info.Size() && obj.SHA256 == sum {
+				unchanged.Add(1)
+				return nil
+			}
+
+			src := &Source{Path: localPath, Size: info.Size(), SHA256: sum, ContentType: ContentType(rel), CacheControl: cacheControl}
+			if err := b.Put(ctx, rel, src); err != nil {
+				return fmt.Errorf("failed to upload %s: %w", rel, err)
+			}
+			if verify {
+				if err := verifyObject(ctx, b, rel, src); err != nil {
+					// Its metadata would pass it as unchanged on the next run
+					b.Delete(ctx, rel)
+					return fmt.Errorf("failed to verify %s: %w", rel, err)
+				}
+			}
+			transferred.Add(1)
+			bytes.Add(info.Size())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range stage {
+			local[rel] = true
+		}
+	}
+
+	var stale []string
+	for rel := range remote {
+		if !local[rel] {
+			stale = append(stale, rel)
+		}
+	}
+	sort.Strings(stale)
+	err = parallel(ctx, stale, func(rel string) error {
+		if err := b.Delete(ctx, rel); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", rel, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.Transferred = int(transferred.Load())
+	result.Unchanged = int(unchanged.Load())
+	result.Removed = len(stale)
+	result.Bytes = bytes.Load()
+	return result, nil
+}
+
+// Download makes localDir a copy of the directory of b: it downloads the
+// objects that differ from the local files, and removes the local files
+// the bucket doesn't have. The lock and parse cache are kept
+func Download(ctx context.Context, b Bucket, localDir string) (*staging.Result, error) {
+	remote, err := b.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &staging.Result{}
+
+	var keys []string
+	for rel := range remote {
+		clean := path.Clean(rel)
+		if clean != rel || path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+			return nil, fmt.Errorf("%s has an invalid object name %q", b, rel)
+		}
+		keys = append(keys, rel)
+	}
+	sort.Strings(keys)
+
+	var transferred, unchanged atomic.Int32
+	var bytes atomic.Int64
+	err = parallel(ctx, keys, func(rel string) error {
+		localPath := filepath.Join(localDir, filepath.FromSlash(rel))
+		obj := remote[rel]
+		if info, err := os.Stat(localPath); err == nil && info.Size() == obj.Size && obj.SHA256 != "" {
+			if sum, err := fileSHA256(localPath); err == nil && sum == obj.SHA256 {
+				unchanged.Add(1)
+				return nil
+			}
+		}
+		if err := download(ctx, b, rel, localPath); err != nil {
+			return fmt.Errorf("failed to download %s: %w", rel, err)
+		}
+		transferred.Add(1)
+		bytes.Add(obj.Size)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := staging.Files(localDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, rel := range files {
+		if _, ok := remote[rel]; !ok {
+			if err := os.Remove(filepath.Join(localDir, filepath.FromSlash(rel))); err != nil {
+				return nil, err
+			}
+			result.Removed++
+		}
+	}
+
+	result.Transferred = int(transferred.Load())
+	result.Unchanged = int(unchanged.Load())
+	result.Bytes = bytes.Load()
+	return result, nil
+}
+
+// download writes the object named rel to localPath, through a temporary
+// file so that failures leave the previous version
+func download(ctx context.Context, b Bucket, rel, localPath string) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = b.Get(ctx, rel, tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), localPath)
+}
+
+// verifyObject checks that the object named key has the size and SHA-256 of
+// src, and the MD5 of its content when the service computed it
+func verifyObject(ctx context.Context, b Bucket, key string, src *Source) error {
+	obj, err := b.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	if obj.Size != src.Size {
+		return fmt.Errorf("%d bytes stored, expected %d", obj.Size, src.Size)
+	}
+	if obj.SHA256 != src.SHA256 {
+		return fmt.Errorf("stored with SHA-256 %q, expected %s", obj.SHA256, src.SHA256)
+	}
+	if obj.MD5 == "" {
+		return nil
+	}
+	sum, err := fileMD5(src.Path)
+	if err != nil {
+		return err
+	}
+	if obj.MD5 != sum {
+		return fmt.Errorf("stored content has MD5 %s, expected %s", obj.MD5, sum)
+	}
+	return nil
+}
+
+// fileMD5 returns the base64 MD5 of the content of file, as services
+// report it
+func fileMD5(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileSHA256 returns the hex SHA-256 of the content of file
+func fileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// parallel runs fn on every item, Uploads at once, and returns the first
+// error
+func parallel(ctx context.Context, items []string, fn func(item string) error) error {
+	return parallelN(ctx, Uploads, len(items), func(i int) error { return fn(items[i]) })
+}
+
+// parallelN runs fn on 0 to n-1, limit at once, and returns the first
+// error. No call starts once one failed
+func parallelN(ctx context.Context, limit, n int, fn func(i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	slots := make(chan struct{}, limit)
+	for i := 0; i < n; i++ {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := fn(i); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
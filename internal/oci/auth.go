@@ -0,0 +1,176 @@
+package oci
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Credentials returns the username and password for a registry, ok being
+// false when there are none
+type Credentials func(registry string) (username, password string, ok bool)
+
+// dockerConfig is the part of ~/.docker/config.json holding credentials
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth string `json:"auth"`
+	} `json:"auths"`
+}
+
+// DockerCredentials returns the credentials docker login, podman login
+// --compat-auth-file and oras login store in $DOCKER_CONFIG/config.json
+// (~/.docker/config.json by default). Credential helpers aren't supported
+func DockerCredentials() Credentials {
+	return func(registry string) (string, string, bool) {
+		dir := os.Getenv("DOCKER_CONFIG")
+		if dir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", "", false
+			}
+			dir = filepath.Join(home, ".docker")
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+		if err != nil {
+			return "", "", false
+		}
+		var config dockerConfig
+		if err := json.Unmarshal(data, &config); err != nil {
+			return "", "", false
+		}
+
+		// Keys are host[:port], possibly written as a URL
+		for key, entry := range config.Auths {
+			host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+			host, _, _ = strings.Cut(host, "/")
+			if host != registry || entry.Auth == "" {
+				continue
+			}
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return "", "", false
+			}
+			username, password, ok := strings.Cut(string(decoded), ":")
+			return username, password, ok
+		}
+		return "", "", false
+	}
+}
+
+// authorization returns the Authorization header obtained for a registry
+// and scope, if any
+func (c *Client) authorization(ref *Reference, scope string) string {
+	return c.auth[ref.Registry+" "+scope]
+}
+
+// authenticate answers the WWW-Authenticate challenge of a registry: basic
+// authentication with the credentials, or a bearer token from the token
+// service it names
+func (c *Client) authenticate(ctx context.Context, ref *Reference, challenge, scope string) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	username, password, hasCredentials := "", "", false
+	if c.Credentials != nil {
+		username, password, hasCredentials = c.Credentials(ref.Registry)
+	}
+
+	var header string
+	switch scheme {
+	case "basic":
+		if !hasCredentials {
+			return "", fmt.Errorf("%s requires credentials, use docker login or oras login", ref.Registry)
+		}
+		header = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	case "bearer":
+		token, err := c.fetchToken(ctx, params, scope, username, password, hasCredentials)
+		if err != nil {
+			return "", fmt.Errorf("failed to authenticate to %s: %w", ref.Registry, err)
+		}
+		header = "Bearer " + token
+	default:
+		return "", fmt.Errorf("%s asks for unsupported authentication %q", ref.Registry, challenge)
+	}
+
+	if c.auth == nil {
+		c.auth = make(map[string]string)
+	}
+	c.auth[ref.Registry+" "+scope] = header
+	return header, nil
+}
+
+// fetchToken gets a bearer token from the token service of a challenge, as
+// described by the distribution token authentication specification
+func (c *Client) fetchToken(ctx context.Context, params map[string]string, scope, username, password string, hasCredentials bool) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("token service has no valid realm")
+	}
+	q := realm.Query()
+	if service := params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasCredentials {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token service returned %s", resp.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("token service returned no token")
+}
+
+// parseChallenge splits a WWW-Authenticate header, e.g. Bearer
+// realm="https://auth.example.com/token",service="registry.example.com",
+// into its lowercase scheme and parameters
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+			params[key] = value
+		}
+	}
+	return strings.ToLower(scheme), params
+}
@@ -0,0 +1,433 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Scheme prefixes the locations repositories are pushed to
+const Scheme = "oci://"
+
+// Media types of the artifact, as ORAS pushes files
+const (
+	manifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	emptyMediaType    = "application/vnd.oci.empty.v1+json"
+	layerMediaType    = "application/vnd.oci.image.layer.v1.tar"
+
+	// ArtifactType identifies repogen repositories among registry artifacts
+	ArtifactType = "application/vnd.repogen.repository.v1"
+
+	// titleAnnotation names the file of a layer, which oras pull restores
+	titleAnnotation   = "org.opencontainers.image.title"
+	createdAnnotation = "org.opencontainers.image.created"
+)
+
+// emptyConfig is the config blob of artifacts without one
+var emptyConfig = []byte("{}")
+
+// ErrNotFound is returned by Pull when the tag does not exist
+var ErrNotFound = errors.New("artifact not found")
+
+// Reference locates an artifact: registry.example.com/repo/path:tag
+type Reference struct {
+	Registry   string // host[:port]
+	Repository string
+	Tag        string
+}
+
+// ParseReference parses an oci://registry/repository[:tag] location, the tag
+// defaulting to latest
+func ParseReference(s string) (*Reference, error) {
+	if !strings.HasPrefix(s, Scheme) {
+		return nil, fmt.Errorf("%q is not an %s location", s, Scheme)
+	}
+	rest := strings.TrimPrefix(s, Scheme)
+	slash := strings.Index(rest, "/")
+	if slash <= 0 || slash == len(rest)-1 {
+		return nil, fmt.Errorf("%q lacks a registry or repository", s)
+	}
+
+	ref := &Reference{Registry: rest[:slash], Repository: rest[slash+1:], Tag: "latest"}
+	// A colon after the last slash starts the tag, others are registry ports
+	if i := strings.LastIndex(ref.Repository, ":"); i > strings.LastIndex(ref.Repository, "/") {
+		ref.Tag = ref.Repository[i+1:]
+		ref.Repository = ref.Repository[:i]
+	}
+	if ref.Repository == "" || ref.Tag == "" || ref.Repository != strings.ToLower(ref.Repository) {
+		return nil, fmt.Errorf("%q is not a valid repository and tag", s)
+	}
+	return ref, nil
+}
+
+// String returns the reference as an oci:// location
+func (r *Reference) String() string {
+	return Scheme + r.Registry + "/" + r.Repository + ":" + r.Tag
+}
+
+// baseURL returns the URL of the repository API. Registries on the local
+// host are reached over plain HTTP, like docker does
+func (r *Reference) baseURL() string {
+	scheme := "https"
+	switch (&url.URL{Host: r.Registry}).Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+		scheme = "http"
+	}
+	return scheme + "://" + r.Registry + "/v2/" + r.Repository
+}
+
+// descriptor points at a blob
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Data        []byte            `json:"data,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// manifest is an OCI image manifest describing an artifact
+type manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        descriptor        `json:"config"`
+	Layers        []descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Client pushes and pulls repositories to and from OCI registries
+type Client struct {
+	HTTP        *http.Client // nil for http.DefaultClient
+	Credentials Credentials  // nil for anonymous access
+	Verify      bool         // Check the registry serves what was pushed
+
+	auth map[string]string // Authorization header per registry and scope
+}
+
+// NewClient returns a Client authenticating with the docker credentials of
+// the user
+func NewClient() *Client {
+	return &Client{Credentials: DockerCredentials()}
+}
+
+// Push uploads every file of dir as a layer of an artifact tagged ref,
+// titled with its path relative to dir. It returns the manifest digest
+func (c *Client) Push(ctx context.Context, ref *Reference, dir string) (string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(dir, p); err == nil && utils.IsInternalPath(filepath.ToSlash(rel)) {
+			return nil
+		}
+		// Symlinked files (e.g. Pacman's myrepo.db) are pushed as copies
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(p); err == nil {
+				info = target
+			}
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to scan %s: %w", dir, err)
+	}
+	sort.Strings(files)
+
+	m := manifest{
+		SchemaVersion: 2,
+		MediaType:     manifestMediaType,
+		ArtifactType:  ArtifactType,
+		Config:        blobDescriptor(emptyMediaType, emptyConfig),
+		Layers:        []descriptor{},
+		Annotations:   map[string]string{createdAnnotation: time.Now().UTC().Format(time.RFC3339)},
+	}
+	// OCI 1.1 embeds the empty config, registries predating it need the blob
+	m.Config.Data = emptyConfig
+	if err := c.pushBlob(ctx, ref, m.Config.Digest, int64(len(emptyConfig)), func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(emptyConfig)), nil
+	}); err != nil {
+		return "", err
+	}
+
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return "", err
+		}
+		digest, size, err := fileDigest(file)
+		if err != nil {
+			return "", err
+		}
+		err = c.pushBlob(ctx, ref, digest, size, func() (io.ReadCloser, error) {
+			return os.Open(file)
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to push %s: %w", rel, err)
+		}
+		m.Layers = append(m.Layers, descriptor{
+			MediaType:   layerMediaType,
+			Digest:      digest,
+			Size:        size,
+			Annotations: map[string]string{titleAnnotation: filepath.ToSlash(rel)},
+		})
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(ctx, ref, http.MethodPut, ref.baseURL()+"/manifests/"+ref.Tag, manifestMediaType, "", func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}, int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to push manifest: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to push manifest: %s", resp.Status)
+	}
+
+	digest := blobDescriptor(manifestMediaType, data).Digest
+	if c.Verify {
+		if err := c.verify(ctx, ref, digest, m.Layers); err != nil {
+			return "", err
+		}
+	}
+	return digest, nil
+}
+
+// verify checks that the registry serves the manifest digest under the tag
+// of ref, and every layer with its size
+func (c *Client) verify(ctx context.Context, ref *Reference, digest string, layers []descriptor) error {
+	resp, err := c.do(ctx, ref, http.MethodGet, ref.baseURL()+"/manifests/"+ref.Tag, "", manifestMediaType, nil, 0)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to verify manifest: %s", resp.Status)
+	}
+	if served := blobDescriptor(manifestMediaType, data).Digest; served != digest {
+		return fmt.Errorf("%s serves manifest %s, expected %s", ref, served, digest)
+	}
+
+	for _, layer := range layers {
+		resp, err := c.do(ctx, ref, http.MethodHead, ref.baseURL()+"/blobs/"+layer.Digest, "", "", nil, 0)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.ContentLength != layer.Size {
+			return fmt.Errorf("failed to verify %s: %s, %d bytes, expected %d", layer.Annotations[titleAnnotation], resp.Status, resp.ContentLength, layer.Size)
+		}
+	}
+	return nil
+}
+
+// pushBlob uploads a blob unless the registry has it already
+func (c *Client) pushBlob(ctx context.Context, ref *Reference, digest string, size int64, open func() (io.ReadCloser, error)) error {
+	resp, err := c.do(ctx, ref, http.MethodHead, ref.baseURL()+"/blobs/"+digest, "", "", nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = c.do(ctx, ref, http.MethodPost, ref.baseURL()+"/blobs/uploads/", "", "", nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to start upload: %s", resp.Status)
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return fmt.Errorf("registry returned no upload location")
+	}
+	q := location.Query()
+	q.Set("digest", digest)
+	location.RawQuery = q.Encode()
+
+	resp, err = c.do(ctx, ref, http.MethodPut, location.String(), "application/octet-stream", "", open, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("failed to upload blob: %s", resp.Status)
+	}
+	return nil
+}
+
+// Pull downloads the files of the artifact tagged ref into dir, checking
+// their digests. It returns ErrNotFound when the tag does not exist
+func (c *Client) Pull(ctx context.Context, ref *Reference, dir string) (int, error) {
+	resp, err := c.do(ctx, ref, http.MethodGet, ref.baseURL()+"/manifests/"+ref.Tag, "", manifestMediaType, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to get manifest: %s", resp.Status)
+	}
+	var m manifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return 0, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.ArtifactType != "" && m.ArtifactType != ArtifactType {
+		return 0, fmt.Errorf("%s is a %s artifact, not a repository", ref, m.ArtifactType)
+	}
+
+	for _, layer := range m.Layers {
+		title := layer.Annotations[titleAnnotation]
+		clean := path.Clean(title)
+		if title == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return 0, fmt.Errorf("layer %s has an invalid title %q", layer.Digest, title)
+		}
+		if err := c.pullBlob(ctx, ref, layer, filepath.Join(dir, filepath.FromSlash(clean))); err != nil {
+			return 0, fmt.Errorf("failed to pull %s: %w", clean, err)
+		}
+	}
+	return len(m.Layers), nil
+}
+
+// pullBlob downloads a blob to file, removing it if its digest is wrong
+func (c *Client) pullBlob(ctx context.Context, ref *Reference, layer descriptor, file string) error {
+	resp, err := c.do(ctx, ref, http.MethodGet, ref.baseURL()+"/blobs/"+layer.Digest, "", "", nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get blob: %s", resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && "sha256:"+hex.EncodeToString(h.Sum(nil)) != layer.Digest {
+		err = fmt.Errorf("digest mismatch, expected %s", layer.Digest)
+	}
+	if err != nil {
+		os.Remove(file)
+		return err
+	}
+	return nil
+}
+
+// do sends a request, authenticating and resending it when the registry
+// asks to. open returns the body, so it can be sent again
+func (c *Client) do(ctx context.Context, ref *Reference, method, rawURL, contentType, accept string, open func() (io.ReadCloser, error), size int64) (*http.Response, error) {
+	send := func(auth string) (*http.Response, error) {
+		var body io.ReadCloser
+		if open != nil {
+			var err error
+			if body, err = open(); err != nil {
+				return nil, err
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+		if err != nil {
+			if body != nil {
+				body.Close()
+			}
+			return nil, err
+		}
+		if open != nil {
+			req.ContentLength = size
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		return c.httpClient().Do(req)
+	}
+
+	scope := "repository:" + ref.Repository + ":pull,push"
+	resp, err := send(c.authorization(ref, scope))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	auth, err := c.authenticate(ctx, ref, challenge, scope)
+	if err != nil {
+		return nil, err
+	}
+	return send(auth)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	return http.DefaultClient
+}
+
+// blobDescriptor returns the descriptor of data
+func blobDescriptor(mediaType string, data []byte) descriptor {
+	sum := sha256.Sum256(data)
+	return descriptor{MediaType: mediaType, Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: int64(len(data))}
+}
+
+// fileDigest returns the digest and size of a file
+func fileDigest(file string) (string, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), size, nil
+}
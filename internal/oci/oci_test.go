@@ -0,0 +1,252 @@
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// registry is an in-memory registry requiring a bearer token
+type registry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+	token     string
+	truncate  bool // Store blobs without their last byte
+}
+
+func (r *registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.URL.Path == "/token" {
+		user, pass, _ := req.BasicAuth()
+		if user != "ci" || pass != "secret" || req.URL.Query().Get("scope") != "repository:team/repo:pull,push" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": r.token})
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer "+r.token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="test"`, req.Host))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/team/repo")
+	switch {
+	case strings.HasPrefix(path, "/blobs/uploads/") && req.Method == http.MethodPost:
+		w.Header().Set("Location", "/v2/team/repo/blobs/uploads/1")
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasPrefix(path, "/blobs/uploads/") && req.Method == http.MethodPut:
+		data, _ := io.ReadAll(req.Body)
+		sum := sha256.Sum256(data)
+		digest := "sha256:" + hex.EncodeToString(sum[:])
+		if digest != req.URL.Query().Get("digest") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.truncate {
+			data = data[:len(data)-1]
+		}
+		r.blobs[digest] = data
+		r.uploads++
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(path, "/blobs/"):
+		data, ok := r.blobs[strings.TrimPrefix(path, "/blobs/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if req.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case strings.HasPrefix(path, "/manifests/") && req.Method == http.MethodPut:
+		if req.Header.Get("Content-Type") != manifestMediaType {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.manifests[strings.TrimPrefix(path, "/manifests/")], _ = io.ReadAll(req.Body)
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(path, "/manifests/"):
+		data, ok := r.manifests[strings.TrimPrefix(path, "/manifests/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", manifestMediaType)
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	for _, tc := range []struct {
+		in                  string
+		registry, repo, tag string
+	}{
+		{"oci://registry.example.com/repo", "registry.example.com", "repo", "latest"},
+		{"oci://localhost:5000/team/repo:stable", "localhost:5000", "team/repo", "stable"},
+	} {
+		ref, err := ParseReference(tc.in)
+		if err != nil {
+			t.Fatalf("ParseReference(%q) failed: %v", tc.in, err)
+		}
+		if ref.Registry != tc.registry || ref.Repository != tc.repo || ref.Tag != tc.tag {
+			t.Errorf("ParseReference(%q) = %+v", tc.in, ref)
+		}
+	}
+	for _, in := range []string{"registry.example.com/repo", "oci://registry.example.com", "oci://registry.example.com/Repo"} {
+		if _, err := ParseReference(in); err == nil {
+			t.Errorf("ParseReference(%q) should fail", in)
+		}
+	}
+
+	ref, _ := ParseReference("oci://localhost:5000/repo")
+	if ref.baseURL() != "http://localhost:5000/v2/repo" {
+		t.Errorf("unexpected base URL %s", ref.baseURL())
+	}
+}
+
+func TestPushPull(t *testing.T) {
+	reg := &registry{blobs: make(map[string][]byte), manifests: make(map[string][]byte), token: "t0k3n"}
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	ref, err := ParseReference("oci://" + strings.TrimPrefix(server.URL, "http://") + "/team/repo:stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{Credentials: func(registry string) (string, string, bool) {
+		return "ci", "secret", registry == ref.Registry
+	}}
+
+	// Nothing is published yet
+	if _, err := client.Pull(context.Background(), ref, t.TempDir()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	repoDir := t.TempDir()
+	files := map[string]string{
+		"dists/stable/Release":           "Origin: test\n",
+		"pool/main/h/hello/hello_1.deb":  "package",
+		"pool/main/h/hello/hello_1b.deb": "package",
+	}
+	for name, content := range files {
+		p := filepath.Join(repoDir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte(content), 0644)
+	}
+
+	digest, err := client.Push(context.Background(), ref, repoDir)
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if !strings.HasPrefix(digest, "sha256:") {
+		t.Errorf("unexpected digest %s", digest)
+	}
+	// Identical files share a blob, next to the empty config
+	if reg.uploads != 3 {
+		t.Errorf("expected 3 blob uploads, got %d", reg.uploads)
+	}
+
+	var m manifest
+	json.Unmarshal(reg.manifests["stable"], &m)
+	if m.ArtifactType != ArtifactType || len(m.Layers) != 3 || m.Layers[0].Annotations[titleAnnotation] != "dists/stable/Release" {
+		t.Errorf("unexpected manifest %s", reg.manifests["stable"])
+	}
+
+	// Pushing again only sends the manifest
+	if _, err := client.Push(context.Background(), ref, repoDir); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if reg.uploads != 3 {
+		t.Errorf("expected no new blob uploads, got %d", reg.uploads-3)
+	}
+
+	pullDir := t.TempDir()
+	n, err := client.Pull(context.Background(), ref, pullDir)
+	if err != nil {
+		t.Fatalf("Pull failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 files, got %d", n)
+	}
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(pullDir, filepath.FromSlash(name)))
+		if err != nil || string(data) != content {
+			t.Errorf("%s not restored: %q, %v", name, data, err)
+		}
+	}
+
+	// Without credentials, the token service refuses
+	anonymous := &Client{}
+	if _, err := anonymous.Push(context.Background(), ref, repoDir); err == nil {
+		t.Error("expected anonymous push to fail")
+	}
+}
+
+func TestPushVerify(t *testing.T) {
+	reg := &registry{blobs: make(map[string][]byte), manifests: make(map[string][]byte), token: "t"}
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	ref, _ := ParseReference("oci://" + strings.TrimPrefix(server.URL, "http://") + "/team/repo:stable")
+	client := &Client{Verify: true, Credentials: func(string) (string, string, bool) { return "ci", "secret", true }}
+
+	repoDir := t.TempDir()
+	os.WriteFile(filepath.Join(repoDir, "Release"), []byte("Origin: test\n"), 0644)
+
+	if _, err := client.Push(context.Background(), ref, repoDir); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	// A registry storing blobs other than it was sent is caught
+	reg.blobs = make(map[string][]byte)
+	reg.truncate = true
+	if _, err := client.Push(context.Background(), ref, repoDir); err == nil || !strings.Contains(err.Error(), "failed to verify Release") {
+		t.Errorf("expected a verification failure, got %v", err)
+	}
+}
+
+func TestPullRejectsTraversal(t *testing.T) {
+	reg := &registry{blobs: make(map[string][]byte), manifests: make(map[string][]byte), token: "t"}
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	m := manifest{SchemaVersion: 2, MediaType: manifestMediaType, Layers: []descriptor{{
+		MediaType:   layerMediaType,
+		Digest:      "sha256:00",
+		Annotations: map[string]string{titleAnnotation: "../../etc/passwd"},
+	}}}
+	reg.manifests["latest"], _ = json.Marshal(m)
+
+	ref, _ := ParseReference("oci://" + strings.TrimPrefix(server.URL, "http://") + "/team/repo")
+	client := &Client{Credentials: func(string) (string, string, bool) { return "ci", "secret", true }}
+	if _, err := client.Pull(context.Background(), ref, t.TempDir()); err == nil || !strings.Contains(err.Error(), "invalid title") {
+		t.Errorf("expected an invalid title error, got %v", err)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull"`)
+	if scheme != "bearer" || params["realm"] != "https://auth.example.com/token" || params["service"] != "registry.example.com" || params["scope"] != "repository:a/b:pull" {
+		t.Errorf("unexpected challenge %s %v", scheme, params)
+	}
+}
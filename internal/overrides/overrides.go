@@ -0,0 +1,74 @@
+package overrides
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/ralt/repogen/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Overrides contains per-package settings supplied by the repository maintainer
+type Overrides struct {
+	Packages map[string]PackageOverride `yaml:"packages"`
+}
+
+// PackageOverride contains the settings applied to every version of a package
+type PackageOverride struct {
+	Deprecated bool   `yaml:"deprecated"`
+	Message    string `yaml:"message"`
+	ReplacedBy string `yaml:"replaced_by"`
+	EOL        string `yaml:"eol"` // End-of-life date (YYYY-MM-DD), implies deprecated
+}
+
+// Load reads an overrides file (YAML or JSON)
+func Load(path string) (*Overrides, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overrides file: %w", err)
+	}
+
+	var o Overrides
+	if err := yaml.Unmarshal(data, &o); err != nil {
+		return nil, fmt.Errorf("failed to parse overrides file %s: %w", path, err)
+	}
+
+	return &o, nil
+}
+
+// Apply sets override-derived fields on packages in place
+func (o *Overrides) Apply(packages []models.Package) {
+	if o == nil {
+		return
+	}
+
+	for i := range packages {
+		override, ok := o.Packages[packages[i].Name]
+		if !ok {
+			continue
+		}
+
+		if override.Deprecated || override.EOL != "" {
+			packages[i].Deprecation = &models.Deprecation{
+				Message:    override.Message,
+				ReplacedBy: override.ReplacedBy,
+				EOL:        override.EOL,
+			}
+		}
+	}
+}
+
+// DeprecatedNames returns the sorted, de-duplicated names of deprecated packages
+func DeprecatedNames(packages []models.Package) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, pkg := range packages {
+		if pkg.Deprecation != nil && !seen[pkg.Name] {
+			seen[pkg.Name] = true
+			names = append(names, pkg.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
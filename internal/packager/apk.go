@@ -0,0 +1,70 @@
+package packager
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// buildApk writes <name>-<version>-r<release>.apk: an unsigned package of
+// a control and a data gzip stream, as abuild builds before signing
+func buildApk(pkg Package, dir string) (string, error) {
+	arch := pkg.Arch
+	if arch == "" {
+		arch = "x86_64"
+	}
+	release := pkg.Release
+	if release == "" {
+		release = "0"
+	}
+	version := fmt.Sprintf("%s-r%s", pkg.Version, release)
+
+	// apk verifies the content of every file against its PAX checksum
+	entries := fileEntries(pkg.Files, "")
+	for i, e := range entries {
+		if !e.Dir {
+			sum := sha1.Sum(e.Data)
+			entries[i].PAX = map[string]string{"APK-TOOLS.checksum.SHA1": hex.EncodeToString(sum[:])}
+		}
+	}
+	dataTar, err := writeTar(entries, pkg.BuildTime, true)
+	if err != nil {
+		return "", err
+	}
+	data, err := compress("gzip", dataTar)
+	if err != nil {
+		return "", err
+	}
+	datahash := sha256.Sum256(data)
+
+	var pkginfo strings.Builder
+	fmt.Fprintf(&pkginfo, "# Generated by repogen\n")
+	fmt.Fprintf(&pkginfo, "pkgname = %s\n", pkg.Name)
+	fmt.Fprintf(&pkginfo, "pkgver = %s\n", version)
+	fmt.Fprintf(&pkginfo, "pkgdesc = %s\n", pkg.Summary)
+	writeOptional(&pkginfo, "url", pkg.Homepage)
+	fmt.Fprintf(&pkginfo, "builddate = %d\n", pkg.BuildTime.Unix())
+	writeOptional(&pkginfo, "packager", pkg.Maintainer)
+	fmt.Fprintf(&pkginfo, "size = %d\n", installedSize(pkg.Files))
+	fmt.Fprintf(&pkginfo, "arch = %s\n", arch)
+	fmt.Fprintf(&pkginfo, "origin = %s\n", pkg.Name)
+	writeOptional(&pkginfo, "license", pkg.License)
+	for _, dep := range pkg.Depends {
+		fmt.Fprintf(&pkginfo, "depend = %s\n", dep)
+	}
+	fmt.Fprintf(&pkginfo, "datahash = %s\n", hex.EncodeToString(datahash[:]))
+
+	// The control segment is a cut tar archive, continued by the data one
+	controlTar, err := writeTar([]tarEntry{{Name: ".PKGINFO", Mode: 0644, Data: []byte(pkginfo.String())}}, pkg.BuildTime, false)
+	if err != nil {
+		return "", err
+	}
+	control, err := compress("gzip", controlTar)
+	if err != nil {
+		return "", err
+	}
+
+	return writeFile(dir, fmt.Sprintf("%s-%s.apk", pkg.Name, version), append(control, data...))
+}
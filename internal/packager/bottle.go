@@ -0,0 +1,36 @@
+package packager
+
+import (
+	"fmt"
+	"path"
+)
+
+// buildBottle writes <name>--<version>.<tag>.bottle.tar.gz, a keg holding
+// the files. The release, when set, is the bottle rebuild number
+func buildBottle(pkg Package, dir string) (string, error) {
+	tag := pkg.Arch
+	if tag == "" {
+		tag = "x86_64_linux"
+	}
+
+	keg := path.Join(pkg.Name, pkg.Version)
+	files := make([]File, len(pkg.Files))
+	for i, f := range pkg.Files {
+		f.Path = path.Join(keg, f.Path)
+		files[i] = f
+	}
+	archive, err := writeTar(fileEntries(files, ""), pkg.BuildTime, true)
+	if err != nil {
+		return "", err
+	}
+	data, err := compress("gzip", archive)
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s--%s.%s.bottle.tar.gz", pkg.Name, pkg.Version, tag)
+	if pkg.Release != "" && pkg.Release != "0" {
+		name = fmt.Sprintf("%s--%s.%s.bottle.%s.tar.gz", pkg.Name, pkg.Version, tag, pkg.Release)
+	}
+	return writeFile(dir, name, data)
+}
@@ -0,0 +1,100 @@
+package packager
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// buildDeb writes <name>_<version>[-<release>]_<arch>.deb, with xz
+// compressed members by default like dpkg-deb
+func buildDeb(pkg Package, dir string) (string, error) {
+	arch := pkg.Arch
+	if arch == "" {
+		arch = "amd64"
+	}
+	algorithm := pkg.Compression
+	if algorithm == "" {
+		algorithm = "xz"
+	}
+	version := pkg.Version
+	if pkg.Release != "" {
+		version += "-" + pkg.Release
+	}
+
+	var control strings.Builder
+	fmt.Fprintf(&control, "Package: %s\n", pkg.Name)
+	fmt.Fprintf(&control, "Version: %s\n", version)
+	fmt.Fprintf(&control, "Architecture: %s\n", arch)
+	fmt.Fprintf(&control, "Maintainer: %s\n", pkg.Maintainer)
+	fmt.Fprintf(&control, "Installed-Size: %d\n", (installedSize(pkg.Files)+1023)/1024)
+	if len(pkg.Depends) > 0 {
+		fmt.Fprintf(&control, "Depends: %s\n", strings.Join(pkg.Depends, ", "))
+	}
+	fmt.Fprintf(&control, "Section: misc\n")
+	fmt.Fprintf(&control, "Priority: optional\n")
+	if pkg.Homepage != "" {
+		fmt.Fprintf(&control, "Homepage: %s\n", pkg.Homepage)
+	}
+	fmt.Fprintf(&control, "Description: %s\n", pkg.Summary)
+	if pkg.Description != "" {
+		for _, line := range strings.Split(strings.TrimSpace(pkg.Description), "\n") {
+			// Blank lines of the long description are written " ."
+			if line = strings.TrimRight(line, " \t"); line == "" {
+				line = "."
+			}
+			fmt.Fprintf(&control, " %s\n", line)
+		}
+	}
+
+	var md5sums strings.Builder
+	for _, f := range pkg.Files {
+		sum := md5.Sum(f.Data)
+		fmt.Fprintf(&md5sums, "%s  %s\n", hex.EncodeToString(sum[:]), f.Path)
+	}
+
+	controlTar, err := writeTar([]tarEntry{
+		{Name: "./", Mode: 0755, Dir: true},
+		{Name: "./control", Mode: 0644, Data: []byte(control.String())},
+		{Name: "./md5sums", Mode: 0644, Data: []byte(md5sums.String())},
+	}, pkg.BuildTime, true)
+	if err != nil {
+		return "", err
+	}
+	dataTar, err := writeTar(append([]tarEntry{{Name: "./", Mode: 0755, Dir: true}}, fileEntries(pkg.Files, "./")...), pkg.BuildTime, true)
+	if err != nil {
+		return "", err
+	}
+
+	controlMember, err := compress(algorithm, controlTar)
+	if err != nil {
+		return "", err
+	}
+	dataMember, err := compress(algorithm, dataTar)
+	if err != nil {
+		return "", err
+	}
+
+	// A .deb is an ar archive of debian-binary, control.tar and data.tar
+	var b bytes.Buffer
+	b.WriteString("!<arch>\n")
+	suffix := compressionSuffix(algorithm)
+	for _, member := range []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar" + suffix, controlMember},
+		{"data.tar" + suffix, dataMember},
+	} {
+		fmt.Fprintf(&b, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", member.name, pkg.BuildTime.Unix(), 0, 0, "100644", len(member.data))
+		b.Write(member.data)
+		if len(member.data)%2 != 0 {
+			b.WriteByte('\n')
+		}
+	}
+
+	return writeFile(dir, fmt.Sprintf("%s_%s_%s.deb", pkg.Name, version, arch), b.Bytes())
+}
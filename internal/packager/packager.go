@@ -0,0 +1,256 @@
+package packager
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Package describes a package to build
+type Package struct {
+	Name        string
+	Version     string
+	Release     string // Package release, the format's default when empty
+	Arch        string // The format's usual 64-bit x86 name when empty
+	Maintainer  string // Name <email>
+	Summary     string // One line description
+	Description string // Longer description, optional
+	Homepage    string
+	License     string
+	Depends     []string // Names of the packages this one requires
+	Files       []File
+	Compression string // gzip, xz or zst for deb and pacman, the format's default when empty
+	BuildTime   time.Time
+}
+
+// File is a file installed by a package
+type File struct {
+	Path string // Slash-separated, relative to the installation root (the keg for bottles)
+	Mode int64
+	Data []byte
+}
+
+// builders build a package of each format into dir
+var builders = map[string]func(pkg Package, dir string) (string, error){
+	"deb":    buildDeb,
+	"rpm":    buildRpm,
+	"apk":    buildApk,
+	"pacman": buildPacman,
+	"bottle": buildBottle,
+}
+
+// Formats returns the package formats that can be built
+func Formats() []string {
+	formats := make([]string, 0, len(builders))
+	for format := range builders {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// Build writes a package of the given format into dir and returns its path
+func Build(format string, pkg Package, dir string) (string, error) {
+	build, ok := builders[format]
+	if !ok {
+		return "", fmt.Errorf("unsupported package format %q, expected one of %s", format, strings.Join(Formats(), ", "))
+	}
+	if pkg.Name == "" || pkg.Version == "" {
+		return "", fmt.Errorf("packages need a name and a version")
+	}
+	if strings.ContainsAny(pkg.Name, "/ ") {
+		return "", fmt.Errorf("invalid package name %q", pkg.Name)
+	}
+	if pkg.Compression != "" && format != "deb" && format != "pacman" {
+		return "", fmt.Errorf("the compression of %s packages cannot be chosen", format)
+	}
+	if len(pkg.Files) == 0 {
+		return "", fmt.Errorf("package %s installs no files", pkg.Name)
+	}
+
+	files := make([]File, len(pkg.Files))
+	seen := make(map[string]bool)
+	for i, f := range pkg.Files {
+		f.Path = path.Clean(strings.TrimPrefix(f.Path, "/"))
+		if f.Path == "." || strings.HasPrefix(f.Path, "../") {
+			return "", fmt.Errorf("invalid file path %q", pkg.Files[i].Path)
+		}
+		if seen[f.Path] {
+			return "", fmt.Errorf("file %s is installed twice", f.Path)
+		}
+		seen[f.Path] = true
+		files[i] = f
+	}
+	// Packages list their files in order, which rpm relies on
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	pkg.Files = files
+
+	if pkg.BuildTime.IsZero() {
+		pkg.BuildTime = time.Now()
+	}
+	pkg.BuildTime = pkg.BuildTime.UTC().Truncate(time.Second)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return build(pkg, dir)
+}
+
+// parentDirs returns the directories above files, parents first
+func parentDirs(files []File) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, f := range files {
+		var parents []string
+		for dir := path.Dir(f.Path); dir != "." && !seen[dir]; dir = path.Dir(dir) {
+			seen[dir] = true
+			parents = append([]string{dir}, parents...)
+		}
+		dirs = append(dirs, parents...)
+	}
+	return dirs
+}
+
+// installedSize returns the size of the files
+func installedSize(files []File) int64 {
+	var size int64
+	for _, f := range files {
+		size += int64(len(f.Data))
+	}
+	return size
+}
+
+// writeOptional writes a "key = value" line of a .PKGINFO when value is set
+func writeOptional(b *strings.Builder, key, value string) {
+	if value != "" {
+		fmt.Fprintf(b, "%s = %s\n", key, value)
+	}
+}
+
+// compress compresses data with the named algorithm
+func compress(algorithm string, data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	switch algorithm {
+	case "gzip":
+		w := gzip.NewWriter(&b)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case "xz":
+		w, err := xz.NewWriter(&b)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case "zst":
+		w, err := zstd.NewWriter(&b)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression %q, expected gzip, xz or zst", algorithm)
+	}
+	return b.Bytes(), nil
+}
+
+// compressionSuffix returns the file extension of a compression algorithm
+func compressionSuffix(algorithm string) string {
+	if algorithm == "gzip" {
+		return ".gz"
+	}
+	return "." + algorithm
+}
+
+// writeFile writes a built package into dir
+func writeFile(dir, name string, data []byte) (string, error) {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// tarEntry is a file or directory of a tar archive
+type tarEntry struct {
+	Name string
+	Mode int64
+	Data []byte
+	Dir  bool
+	PAX  map[string]string
+}
+
+// fileEntries returns the tar entries of files and of their parent
+// directories, named with prefix
+func fileEntries(files []File, prefix string) []tarEntry {
+	var entries []tarEntry
+	for _, dir := range parentDirs(files) {
+		entries = append(entries, tarEntry{Name: prefix + dir + "/", Mode: 0755, Dir: true})
+	}
+	for _, f := range files {
+		entries = append(entries, tarEntry{Name: prefix + f.Path, Mode: f.Mode, Data: f.Data})
+	}
+	return entries
+}
+
+// writeTar returns a tar archive of entries owned by root. Without
+// terminate, the end-of-archive blocks are left out
+func writeTar(entries []tarEntry, mtime time.Time, terminate bool) ([]byte, error) {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, e := range entries {
+		header := &tar.Header{
+			Name:       e.Name,
+			Mode:       e.Mode,
+			Size:       int64(len(e.Data)),
+			ModTime:    mtime,
+			Typeflag:   tar.TypeReg,
+			Uname:      "root",
+			Gname:      "root",
+			PAXRecords: e.PAX,
+		}
+		if e.Dir {
+			header.Typeflag = tar.TypeDir
+		}
+		if e.PAX == nil {
+			header.Format = tar.FormatGNU
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(e.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if terminate {
+		err = tw.Close()
+	} else {
+		err = tw.Flush()
+	}
+	return b.Bytes(), err
+}
@@ -0,0 +1,81 @@
+package packager
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// buildPacman writes <name>-<version>-<release>-<arch>.pkg.tar.zst, with
+// the .PKGINFO and .MTREE makepkg writes
+func buildPacman(pkg Package, dir string) (string, error) {
+	arch := pkg.Arch
+	if arch == "" {
+		arch = "x86_64"
+	}
+	release := pkg.Release
+	if release == "" {
+		release = "1"
+	}
+	algorithm := pkg.Compression
+	if algorithm == "" {
+		algorithm = "zst"
+	}
+
+	files := pkg.Files
+
+	var pkginfo strings.Builder
+	fmt.Fprintf(&pkginfo, "# Generated by repogen\n")
+	fmt.Fprintf(&pkginfo, "pkgname = %s\n", pkg.Name)
+	fmt.Fprintf(&pkginfo, "pkgbase = %s\n", pkg.Name)
+	fmt.Fprintf(&pkginfo, "pkgver = %s-%s\n", pkg.Version, release)
+	fmt.Fprintf(&pkginfo, "pkgdesc = %s\n", pkg.Summary)
+	writeOptional(&pkginfo, "url", pkg.Homepage)
+	fmt.Fprintf(&pkginfo, "builddate = %d\n", pkg.BuildTime.Unix())
+	writeOptional(&pkginfo, "packager", pkg.Maintainer)
+	fmt.Fprintf(&pkginfo, "size = %d\n", installedSize(files))
+	fmt.Fprintf(&pkginfo, "arch = %s\n", arch)
+	writeOptional(&pkginfo, "license", pkg.License)
+	for _, dep := range pkg.Depends {
+		fmt.Fprintf(&pkginfo, "depend = %s\n", dep)
+	}
+	pkginfoFile := File{Path: ".PKGINFO", Mode: 0644, Data: []byte(pkginfo.String())}
+
+	// .MTREE describes every other entry of the package, for pacman -Qk
+	mtime := fmt.Sprintf("time=%d.0", pkg.BuildTime.Unix())
+	var mtree strings.Builder
+	mtree.WriteString("#mtree\n/set type=file uid=0 gid=0 mode=644\n")
+	for _, f := range append([]File{pkginfoFile}, files...) {
+		md5sum := md5.Sum(f.Data)
+		sha256sum := sha256.Sum256(f.Data)
+		fmt.Fprintf(&mtree, "./%s %s", f.Path, mtime)
+		if f.Mode != 0644 {
+			fmt.Fprintf(&mtree, " mode=%o", f.Mode)
+		}
+		fmt.Fprintf(&mtree, " size=%d md5digest=%s sha256digest=%s\n", len(f.Data), hex.EncodeToString(md5sum[:]), hex.EncodeToString(sha256sum[:]))
+	}
+	for _, dir := range parentDirs(files) {
+		fmt.Fprintf(&mtree, "./%s %s mode=755 type=dir\n", dir, mtime)
+	}
+	mtreeData, err := compress("gzip", []byte(mtree.String()))
+	if err != nil {
+		return "", err
+	}
+
+	entries := []tarEntry{
+		{Name: ".PKGINFO", Mode: 0644, Data: pkginfoFile.Data},
+		{Name: ".MTREE", Mode: 0644, Data: mtreeData},
+	}
+	archive, err := writeTar(append(entries, fileEntries(files, "")...), pkg.BuildTime, true)
+	if err != nil {
+		return "", err
+	}
+	data, err := compress(algorithm, archive)
+	if err != nil {
+		return "", err
+	}
+
+	return writeFile(dir, fmt.Sprintf("%s-%s-%s-%s.pkg.tar%s", pkg.Name, pkg.Version, release, arch, compressionSuffix(algorithm)), data)
+}
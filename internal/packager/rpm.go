@@ -0,0 +1,284 @@
+package packager
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+)
+
+// RPM header data types
+const (
+	rpmInt16       = 3
+	rpmInt32       = 4
+	rpmString      = 6
+	rpmBin         = 7
+	rpmStringArray = 8
+	rpmI18NString  = 9
+)
+
+// Region tags: the immutable region of the main and signature headers
+const (
+	rpmTagHeaderSignatures = 62
+	rpmTagHeaderImmutable  = 63
+)
+
+// rpmlib dependencies are RPMSENSE_RPMLIB | RPMSENSE_LESS | RPMSENSE_EQUAL
+const rpmlibFlags = 0x1000000 | 0x02 | 0x08
+
+// rpmEntry is a tag of an RPM header
+type rpmEntry struct {
+	tag   int
+	typ   int
+	value interface{} // string, []string, []int16, []int32 or []byte
+}
+
+// rpmHeader builds an RPM header structure
+type rpmHeader []rpmEntry
+
+func (h *rpmHeader) add(tag, typ int, value interface{}) {
+	*h = append(*h, rpmEntry{tag, typ, value})
+}
+
+// bytes returns the header with every tag in the region of regionTag
+func (h rpmHeader) bytes(regionTag int) []byte {
+	entries := append(rpmHeader(nil), h...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	var index, store bytes.Buffer
+	count := len(entries) + 1
+	writeIndex := func(tag, typ, offset, n int) {
+		binary.Write(&index, binary.BigEndian, [4]int32{int32(tag), int32(typ), int32(offset), int32(n)})
+	}
+
+	// The region entry comes first; its trailer, after every other value
+	var data bytes.Buffer
+	for _, e := range entries {
+		align := map[int]int{rpmInt16: 2, rpmInt32: 4}[e.typ]
+		for align > 0 && data.Len()%align != 0 {
+			data.WriteByte(0)
+		}
+		offset := data.Len()
+
+		n := 1
+		switch v := e.value.(type) {
+		case string:
+			data.WriteString(v)
+			data.WriteByte(0)
+		case []string:
+			for _, s := range v {
+				data.WriteString(s)
+				data.WriteByte(0)
+			}
+			n = len(v)
+		case []int16:
+			binary.Write(&data, binary.BigEndian, v)
+			n = len(v)
+		case []int32:
+			binary.Write(&data, binary.BigEndian, v)
+			n = len(v)
+		case []byte:
+			data.Write(v)
+			n = len(v)
+		}
+		writeIndex(e.tag, e.typ, offset, n)
+	}
+	trailerOffset := data.Len()
+	binary.Write(&data, binary.BigEndian, [4]int32{int32(regionTag), rpmBin, int32(-count * 16), 16})
+
+	store.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	binary.Write(&store, binary.BigEndian, [2]int32{int32(count), int32(data.Len())})
+	binary.Write(&store, binary.BigEndian, [4]int32{int32(regionTag), rpmBin, int32(trailerOffset), 16})
+	store.Write(index.Bytes())
+	store.Write(data.Bytes())
+	return store.Bytes()
+}
+
+// buildRpm writes <name>-<version>-<release>.<arch>.rpm, a binary package
+// with a gzip compressed cpio payload and the digests rpm verifies. The src
+// architecture gives a source package instead
+func buildRpm(pkg Package, dir string) (string, error) {
+	arch := pkg.Arch
+	if arch == "" {
+		arch = "x86_64"
+	}
+	source := arch == "src"
+	release := pkg.Release
+	if release == "" {
+		release = "1"
+	}
+	nvr := fmt.Sprintf("%s-%s-%s", pkg.Name, pkg.Version, release)
+	buildTime := int32(pkg.BuildTime.Unix())
+
+	files := pkg.Files
+	cpio := cpioArchive(files, buildTime)
+	payload, err := compress("gzip", cpio)
+	if err != nil {
+		return "", err
+	}
+	payloadDigest := sha256.Sum256(payload)
+
+	var dirNames []string
+	dirIndex := make(map[string]int)
+	var baseNames, digests, linkTos, users, langs []string
+	var dirIndexes, sizes, mtimes, flags, devices, inodes, verifyFlags []int32
+	var modes, rdevs []int16
+	for i, f := range files {
+		dir := "/" + path.Dir(f.Path) + "/"
+		if _, ok := dirIndex[dir]; !ok {
+			dirIndex[dir] = len(dirNames)
+			dirNames = append(dirNames, dir)
+		}
+		sum := sha256.Sum256(f.Data)
+		dirIndexes = append(dirIndexes, int32(dirIndex[dir]))
+		baseNames = append(baseNames, path.Base(f.Path))
+		digests = append(digests, hex.EncodeToString(sum[:]))
+		linkTos = append(linkTos, "")
+		users = append(users, "root")
+		langs = append(langs, "")
+		sizes = append(sizes, int32(len(f.Data)))
+		mtimes = append(mtimes, buildTime)
+		flags = append(flags, 0)
+		devices = append(devices, 1)
+		inodes = append(inodes, int32(i+1))
+		verifyFlags = append(verifyFlags, -1)
+		modes = append(modes, int16(0100000|f.Mode))
+		rdevs = append(rdevs, 0)
+	}
+
+	description := pkg.Description
+	if description == "" {
+		description = pkg.Summary
+	}
+
+	// Dependencies come with the rpmlib features the package relies on
+	requireNames := append(append([]string(nil), pkg.Depends...), "rpmlib(CompressedFileNames)", "rpmlib(FileDigests)", "rpmlib(PayloadFilesHavePrefix)")
+	requireVersions := append(make([]string, len(pkg.Depends)), "3.0.4-1", "4.6.0-1", "4.0-1")
+	requireFlags := append(make([]int32, len(pkg.Depends)), rpmlibFlags, rpmlibFlags, rpmlibFlags)
+
+	var h rpmHeader
+	h.add(100, rpmStringArray, []string{"C"}) // HEADERI18NTABLE
+	h.add(1000, rpmString, pkg.Name)
+	h.add(1001, rpmString, pkg.Version)
+	h.add(1002, rpmString, release)
+	h.add(1004, rpmI18NString, pkg.Summary) // SUMMARY
+	h.add(1005, rpmI18NString, description)
+	h.add(1006, rpmInt32, []int32{buildTime})
+	h.add(1007, rpmString, "localhost") // BUILDHOST
+	h.add(1009, rpmInt32, []int32{int32(installedSize(files))})
+	if pkg.License != "" {
+		h.add(1014, rpmString, pkg.License)
+	}
+	if pkg.Maintainer != "" {
+		h.add(1015, rpmString, pkg.Maintainer) // PACKAGER
+	}
+	h.add(1016, rpmI18NString, "Unspecified") // GROUP
+	if pkg.Homepage != "" {
+		h.add(1020, rpmString, pkg.Homepage)
+	}
+	h.add(1021, rpmString, "linux")
+	if source {
+		// Source packages record the architecture they were built on
+		h.add(1022, rpmString, "x86_64")
+		h.add(1106, rpmInt32, []int32{1}) // SOURCEPACKAGE
+	} else {
+		h.add(1022, rpmString, arch)
+		h.add(1044, rpmString, nvr+".src.rpm") // SOURCERPM
+	}
+	h.add(1028, rpmInt32, sizes)
+	h.add(1030, rpmInt16, modes)
+	h.add(1033, rpmInt16, rdevs)
+	h.add(1034, rpmInt32, mtimes)
+	h.add(1035, rpmStringArray, digests)
+	h.add(1036, rpmStringArray, linkTos)
+	h.add(1037, rpmInt32, flags)
+	h.add(1039, rpmStringArray, users) // FILEUSERNAME
+	h.add(1040, rpmStringArray, users) // FILEGROUPNAME
+	h.add(1045, rpmInt32, verifyFlags)
+	h.add(1047, rpmStringArray, []string{pkg.Name}) // PROVIDENAME
+	h.add(1048, rpmInt32, requireFlags)
+	h.add(1049, rpmStringArray, requireNames)
+	h.add(1050, rpmStringArray, requireVersions)
+	h.add(1064, rpmString, "4.16.0") // RPMVERSION
+	h.add(1095, rpmInt32, devices)
+	h.add(1096, rpmInt32, inodes)
+	h.add(1097, rpmStringArray, langs)
+	h.add(1112, rpmInt32, []int32{0x08}) // PROVIDEFLAGS: RPMSENSE_EQUAL
+	h.add(1113, rpmStringArray, []string{pkg.Version + "-" + release})
+	h.add(1116, rpmInt32, dirIndexes)
+	h.add(1117, rpmStringArray, baseNames)
+	h.add(1118, rpmStringArray, dirNames)
+	h.add(1124, rpmString, "cpio")
+	h.add(1125, rpmString, "gzip")
+	h.add(1126, rpmString, "9")
+	h.add(5011, rpmInt32, []int32{8}) // FILEDIGESTALGO: SHA-256
+	h.add(5092, rpmStringArray, []string{hex.EncodeToString(payloadDigest[:])})
+	h.add(5093, rpmInt32, []int32{8}) // PAYLOADDIGESTALGO: SHA-256
+	header := h.bytes(rpmTagHeaderImmutable)
+
+	headerSHA1 := sha1.Sum(header)
+	headerSHA256 := sha256.Sum256(header)
+	md5sum := md5.New()
+	md5sum.Write(header)
+	md5sum.Write(payload)
+
+	var sig rpmHeader
+	sig.add(269, rpmString, hex.EncodeToString(headerSHA1[:]))
+	sig.add(273, rpmString, hex.EncodeToString(headerSHA256[:]))
+	sig.add(1000, rpmInt32, []int32{int32(len(header) + len(payload))})
+	sig.add(1004, rpmBin, md5sum.Sum(nil))
+	sig.add(1007, rpmInt32, []int32{int32(len(cpio))})
+	signature := sig.bytes(rpmTagHeaderSignatures)
+
+	var b bytes.Buffer
+	b.Write(rpmLead(nvr, source))
+	b.Write(signature)
+	// The main header starts on an 8 byte boundary
+	b.Write(make([]byte, (8-len(signature)%8)%8))
+	b.Write(header)
+	b.Write(payload)
+
+	return writeFile(dir, fmt.Sprintf("%s.%s.rpm", nvr, arch), b.Bytes())
+}
+
+// rpmLead returns the legacy 96 byte lead of a binary or source package
+func rpmLead(nvr string, source bool) []byte {
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0})
+	if source {
+		binary.BigEndian.PutUint16(lead[6:], 1) // Source package
+	}
+	binary.BigEndian.PutUint16(lead[8:], 1)
+	copy(lead[10:75], nvr)
+	binary.BigEndian.PutUint16(lead[76:], 1) // Linux
+	binary.BigEndian.PutUint16(lead[78:], 5) // Header-style signature
+	return lead
+}
+
+// cpioArchive returns the newc cpio archive of files, named ./<path>
+func cpioArchive(files []File, mtime int32) []byte {
+	var b bytes.Buffer
+	entry := func(ino int, mode int64, name string, data []byte) {
+		fmt.Fprintf(&b, "070701%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+			ino, mode, 0, 0, 1, mtime, len(data), 0, 0, 0, 0, len(name)+1, 0)
+		b.WriteString(name)
+		b.WriteByte(0)
+		for b.Len()%4 != 0 {
+			b.WriteByte(0)
+		}
+		b.Write(data)
+		for b.Len()%4 != 0 {
+			b.WriteByte(0)
+		}
+	}
+	for i, f := range files {
+		entry(i+1, 0100000|f.Mode, "./"+f.Path, f.Data)
+	}
+	entry(0, 0, "TRAILER!!!", nil)
+	return b.Bytes()
+}
@@ -0,0 +1,190 @@
+// Package parsecache keeps the metadata parsed from package files across
+// runs, so regenerating a repository from a large input directory only
+// parses the packages that changed since the last run
+package parsecache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Path is the cache, relative to the repository root. It records local
+// paths, so it is neither pushed, exported nor snapshotted with the repository
+const Path = ".repogen-cache"
+
+// version is bumped whenever cached packages would no longer decode into
+// models.Package as parsers produce it, discarding older caches
+const version = 2
+
+// entry is a cached package, valid while its file keeps its size and
+// modification time
+type entry struct {
+	Size    int64
+	ModTime int64  // Nanoseconds since the epoch
+	Package []byte // gob-encoded models.Package
+}
+
+type file struct {
+	Version int
+	Entries map[string]entry // By absolute path
+}
+
+// Cache holds the packages parsed by previous runs. A nil Cache caches nothing
+type Cache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]entry
+	used    map[string]bool // Entries looked up or added by this run
+	changed bool
+}
+
+// Open loads the cache of the repository in outputDir. A missing cache is
+// empty; so is an unreadable one, the error saying why
+func Open(outputDir string) (*Cache, error) {
+	c := &Cache{
+		path:    filepath.Join(outputDir, filepath.FromSlash(Path)),
+		entries: make(map[string]entry),
+		used:    make(map[string]bool),
+	}
+	data, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+
+	var f file
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&f); err != nil {
+		return c, fmt.Errorf("invalid parse cache %s: %w", c.path, err)
+	}
+	if f.Version == version && f.Entries != nil {
+		c.entries = f.Entries
+	}
+	return c, nil
+}
+
+// key returns the cache key of path and the stat of the file
+func key(path string) (string, os.FileInfo, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := os.Stat(abs)
+	return abs, info, err
+}
+
+// Get returns the package parsed from path by a previous run, if the file
+// hasn't changed since. The package is the caller's to modify
+func (c *Cache) Get(path string) (*models.Package, bool) {
+	if c == nil {
+		return nil, false
+	}
+	k, info, err := key(path)
+	if err != nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	e, ok := c.entries[k]
+	c.mu.Unlock()
+	if !ok || e.Size != info.Size() || e.ModTime != info.ModTime().UnixNano() {
+		return nil, false
+	}
+
+	var pkg models.Package
+	if err := gob.NewDecoder(bytes.NewReader(e.Package)).Decode(&pkg); err != nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	c.used[k] = true
+	c.mu.Unlock()
+	return &pkg, true
+}
+
+// Put records the package parsed from path. Packages whose metadata
+// doesn't survive encoding unchanged (types gob doesn't know, unexported
+// fields) are left out, and parsed again by every run
+func (c *Cache) Put(path string, pkg *models.Package) bool {
+	if c == nil {
+		return false
+	}
+	k, info, err := key(path)
+	if err != nil {
+		return false
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(pkg); err != nil {
+		return false
+	}
+	var decoded models.Package
+	if err := gob.NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&decoded); err != nil || !reflect.DeepEqual(normalize(*pkg), normalize(decoded)) {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[k] = entry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Package: buf.Bytes()}
+	c.used[k] = true
+	c.changed = true
+	return true
+}
+
+// normalize returns pkg with its empty slices and maps nil, as gob decodes them
+func normalize(pkg models.Package) models.Package {
+	for _, list := range []*[]string{&pkg.Groups, &pkg.Dependencies, &pkg.Recommends, &pkg.Suggests, &pkg.Breaks, &pkg.Conflicts, &pkg.Provides, &pkg.Replaces} {
+		if len(*list) == 0 {
+			*list = nil
+		}
+	}
+	if len(pkg.Translations) == 0 {
+		pkg.Translations = nil
+	}
+	if len(pkg.Metadata) == 0 {
+		pkg.Metadata = nil
+	}
+	return pkg
+}
+
+// Save writes the cache back, keeping only the packages of this run so
+// files removed from the input directory don't linger. A run that looked
+// nothing up leaves the cache as it was
+func (c *Cache) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.used) == 0 {
+		return nil
+	}
+
+	entries := make(map[string]entry, len(c.used))
+	for k := range c.used {
+		entries[k] = c.entries[k]
+	}
+	if !c.changed && len(entries) == len(c.entries) {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(file{Version: version, Entries: entries}); err != nil {
+		return err
+	}
+	if err := utils.WriteFile(c.path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write parse cache: %w", err)
+	}
+	c.entries = entries
+	c.changed = false
+	return nil
+}
@@ -0,0 +1,145 @@
+package parsecache
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+// writePackage writes a package file into dir
+func writePackage(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCacheRoundTrip(t *testing.T) {
+	inputDir, outputDir := t.TempDir(), t.TempDir()
+	path := writePackage(t, inputDir, "myapp_1.0_amd64.deb", "package")
+	pkg := &models.Package{
+		Name:         "myapp",
+		Version:      "1.0",
+		Dependencies: []string{"libc6"},
+		Translations: map[string]string{"de": "Meine App"},
+		Metadata:     map[string]interface{}{"BuildTime": int64(1700000000), "Files": []string{"/usr/bin/myapp"}},
+	}
+
+	c, err := Open(outputDir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, ok := c.Get(path); ok {
+		t.Fatal("Empty cache returned a package")
+	}
+	if !c.Put(path, pkg) {
+		t.Fatal("Put refused a plain package")
+	}
+	if err := c.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	c, err = Open(outputDir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	cached, ok := c.Get(path)
+	if !ok {
+		t.Fatal("Saved package not found")
+	}
+	if !reflect.DeepEqual(cached, pkg) {
+		t.Errorf("Get() = %+v, want %+v", cached, pkg)
+	}
+
+	// A rewritten file is parsed again
+	writePackage(t, inputDir, "myapp_1.0_amd64.deb", "package 2")
+	if _, ok := c.Get(path); ok {
+		t.Error("Cache returned the package of a changed file")
+	}
+	writePackage(t, inputDir, "myapp_1.0_amd64.deb", "package")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get(path); ok {
+		t.Error("Cache returned the package of a touched file")
+	}
+}
+
+func TestPutSkipsUnencodableMetadata(t *testing.T) {
+	type unregistered struct{ Checksum string }
+	path := writePackage(t, t.TempDir(), "mycrate-1.0.crate", "crate")
+
+	c, _ := Open(t.TempDir())
+	if c.Put(path, &models.Package{Name: "mycrate", Metadata: map[string]interface{}{"IndexEntry": unregistered{"abc"}}}) {
+		t.Error("Put accepted metadata gob can't decode")
+	}
+	if _, ok := c.Get(path); ok {
+		t.Error("Unencodable package cached")
+	}
+}
+
+func TestSavePrunesUnusedEntries(t *testing.T) {
+	inputDir, outputDir := t.TempDir(), t.TempDir()
+	kept := writePackage(t, inputDir, "kept.deb", "kept")
+	removed := writePackage(t, inputDir, "removed.deb", "removed")
+
+	c, _ := Open(outputDir)
+	c.Put(kept, &models.Package{Name: "kept"})
+	c.Put(removed, &models.Package{Name: "removed"})
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ = Open(outputDir)
+	if _, ok := c.Get(kept); !ok {
+		t.Fatal("Package not cached")
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ = Open(outputDir)
+	if _, ok := c.Get(removed); ok {
+		t.Error("Package left out of the last run still cached")
+	}
+	if _, ok := c.Get(kept); !ok {
+		t.Error("Package of the last run not cached")
+	}
+}
+
+func TestOpenInvalidCache(t *testing.T) {
+	outputDir := t.TempDir()
+	path := filepath.Join(outputDir, filepath.FromSlash(Path))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("not gob"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Open(outputDir)
+	if err == nil {
+		t.Error("Open accepted an invalid cache")
+	}
+	pkgPath := writePackage(t, t.TempDir(), "myapp.deb", "package")
+	if !c.Put(pkgPath, &models.Package{Name: "myapp"}) || c.Save() != nil {
+		t.Fatal("Invalid cache not replaced")
+	}
+	if _, err := Open(outputDir); err != nil {
+		t.Errorf("Open failed after Save: %v", err)
+	}
+}
+
+func TestNilCache(t *testing.T) {
+	var c *Cache
+	if _, ok := c.Get("myapp.deb"); ok || c.Put("myapp.deb", &models.Package{}) || c.Save() != nil {
+		t.Error("Nil cache cached")
+	}
+}
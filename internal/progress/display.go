@@ -0,0 +1,96 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// barWidth is the number of characters of the progress bar
+const barWidth = 24
+
+// Bar redraws the status of the run on the last line of a terminal. Logs
+// written through it are printed above that line
+type Bar struct {
+	mu   sync.Mutex
+	w    io.Writer
+	line string // Currently drawn, empty when none
+}
+
+// NewBar returns a bar drawn on w
+func NewBar(w io.Writer) *Bar {
+	return &Bar{w: w}
+}
+
+// Update redraws the bar with s
+func (b *Bar) Update(s Status) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	b.line = render(s)
+	b.draw()
+}
+
+// Close removes the bar, leaving the logs of the run
+func (b *Bar) Close(Status) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	b.line = ""
+}
+
+// Write prints p above the bar
+func (b *Bar) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	n, err := b.w.Write(p)
+	b.draw()
+	return n, err
+}
+
+func (b *Bar) clear() {
+	if b.line != "" {
+		fmt.Fprint(b.w, "\r\033[K")
+	}
+}
+
+func (b *Bar) draw() {
+	if b.line != "" {
+		fmt.Fprint(b.w, b.line)
+	}
+}
+
+// render draws s as a bar, when its total is known, followed by its status
+func render(s Status) string {
+	if s.Total <= 0 {
+		return s.String()
+	}
+	filled := min(s.Done*barWidth/s.Total, barWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < barWidth {
+		bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+	return "[" + bar + "] " + s.String()
+}
+
+// Log logs the status of the run, for output that isn't a terminal
+type Log struct{}
+
+// Update logs s
+func (Log) Update(s Status) {
+	logrus.Infof("Progress: %s", s)
+}
+
+// Close does nothing: the run logs how it ended
+func (Log) Close(Status) {}
+
+// IsTerminal reports whether f is a terminal, where a bar can be redrawn
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
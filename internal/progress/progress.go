@@ -0,0 +1,162 @@
+// Package progress reports how far a run has got: the current phase, the
+// packages parsed or repositories published, the bytes copied and the time
+// left, on a status line redrawn on terminals or as periodic log lines
+package progress
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ralt/repogen/internal/events"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Status is a snapshot of the progress of a run
+type Status struct {
+	Phase   string
+	Unit    string // What Done and Total count, e.g. "packages"
+	Done    int
+	Total   int   // Zero when unknown
+	Bytes   int64 // Copied into the repository so far
+	Elapsed time.Duration
+}
+
+// ETA returns how long the phase should still take at its pace so far
+func (s Status) ETA() (time.Duration, bool) {
+	if s.Done <= 0 || s.Total <= 0 || s.Done >= s.Total || s.Elapsed <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(s.Elapsed) / float64(s.Done) * float64(s.Total-s.Done)), true
+}
+
+// String describes s on one line, e.g.
+// "parse: 1200/5000 packages (24%), 1.2 GiB copied, 3m12s left"
+func (s Status) String() string {
+	var parts []string
+	switch {
+	case s.Total > 0:
+		parts = append(parts, fmt.Sprintf("%d/%d %s (%d%%)", s.Done, s.Total, s.Unit, s.Done*100/s.Total))
+	case s.Done > 0:
+		parts = append(parts, fmt.Sprintf("%d %s", s.Done, s.Unit))
+	default:
+		parts = append(parts, fmt.Sprintf("%s elapsed", s.Elapsed.Round(time.Second)))
+	}
+	if s.Bytes > 0 {
+		parts = append(parts, utils.FormatBytes(s.Bytes)+" copied")
+	}
+	if eta, ok := s.ETA(); ok {
+		parts = append(parts, eta.Round(time.Second).String()+" left")
+	}
+	return s.Phase + ": " + strings.Join(parts, ", ")
+}
+
+// Display shows the status of a run as it goes
+type Display interface {
+	// Update shows s, the status of the run at a regular interval
+	Update(s Status)
+
+	// Close shows s, the status of the run when it ended
+	Close(s Status)
+}
+
+// Tracker follows a run, updating its display at a regular interval
+type Tracker struct {
+	mu         sync.Mutex
+	status     Status
+	phaseStart time.Time
+	display    Display
+	stopEvents func()
+	stopTicker chan struct{}
+	done       chan struct{}
+}
+
+// Start tracks the run, updating display every interval until Stop
+func Start(display Display, interval time.Duration) *Tracker {
+	t := &Tracker{
+		status:     Status{Phase: "start"},
+		phaseStart: time.Now(),
+		display:    display,
+		stopTicker: make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	t.stopEvents = events.Observe(t.Observe)
+
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.display.Update(t.Status())
+			case <-t.stopTicker:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// Phase starts phase, counting total of unit (zero when unknown)
+func (t *Tracker) Phase(phase, unit string, total int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.Phase, t.status.Unit = phase, unit
+	t.status.Done, t.status.Total = 0, total
+	t.phaseStart = time.Now()
+}
+
+// Observe counts the packages parsed, repositories published and bytes
+// copied reported by events
+func (t *Tracker) Observe(typ events.Type, fields events.Fields) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch typ {
+	case events.PackageParsed:
+		if t.status.Phase == "parse" {
+			t.status.Done++
+		}
+	case events.Published:
+		if t.status.Phase == "publish" {
+			t.status.Done++
+		}
+	case events.FileCopied:
+		if size, ok := fields["size"].(int64); ok {
+			t.status.Bytes += size
+		}
+	}
+}
+
+// Status returns the progress of the run so far
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.status
+	s.Elapsed = time.Since(t.phaseStart)
+	return s
+}
+
+// Stop stops tracking the run, showing its last status
+func (t *Tracker) Stop() {
+	t.stopEvents()
+	close(t.stopTicker)
+	<-t.done
+	t.display.Close(t.Status())
+}
+
+type trackerKey struct{}
+
+// WithTracker returns a context reporting phases to t
+func WithTracker(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, trackerKey{}, t)
+}
+
+// Phase starts phase on the tracker of ctx, if any
+func Phase(ctx context.Context, phase, unit string, total int) {
+	if t, _ := ctx.Value(trackerKey{}).(*Tracker); t != nil {
+		t.Phase(phase, unit, total)
+	}
+}
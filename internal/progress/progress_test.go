@@ -0,0 +1,114 @@
+package progress
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ralt/repogen/internal/events"
+)
+
+func TestStatusString(t *testing.T) {
+	for _, tt := range []struct {
+		status Status
+		want   string
+	}{
+		{
+			Status{Phase: "parse", Unit: "packages", Done: 1200, Total: 4800, Bytes: 3 << 20, Elapsed: time.Minute},
+			"parse: 1200/4800 packages (25%), 3.0 MiB copied, 3m0s left",
+		},
+		{
+			Status{Phase: "publish", Unit: "repositories", Done: 2, Total: 2, Elapsed: time.Minute},
+			"publish: 2/2 repositories (100%)",
+		},
+		{
+			Status{Phase: "scan", Elapsed: 90 * time.Second},
+			"scan: 1m30s elapsed",
+		},
+	} {
+		if got := tt.status.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+// recorder is a display keeping what it was shown
+type recorder struct {
+	mu      sync.Mutex
+	updates []Status
+	closed  *Status
+}
+
+func (r *recorder) Update(s Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updates = append(r.updates, s)
+}
+
+func (r *recorder) Close(s Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = &s
+}
+
+func TestTracker(t *testing.T) {
+	display := &recorder{}
+	tracker := Start(display, time.Millisecond)
+
+	tracker.Phase("parse", "packages", 3)
+	events.Emit(events.PackageParsed, events.Fields{"type": "deb"})
+	events.Emit(events.PackageParsed, events.Fields{"type": "deb"})
+	tracker.Phase("publish", "repositories", 1)
+	events.Emit(events.FileCopied, events.Fields{"size": int64(1000)})
+	events.Emit(events.PackageParsed, events.Fields{"type": "deb"})
+	events.Emit(events.Published, events.Fields{"type": "deb", "packages": 2})
+	time.Sleep(10 * time.Millisecond)
+	tracker.Stop()
+
+	if len(display.updates) == 0 {
+		t.Error("Display never updated")
+	}
+	s := display.closed
+	if s == nil {
+		t.Fatal("Display not closed")
+	}
+	if s.Phase != "publish" || s.Done != 1 || s.Total != 1 || s.Bytes != 1000 {
+		t.Errorf("Final status = %+v", *s)
+	}
+
+	// Events after Stop are no longer counted
+	events.Emit(events.FileCopied, events.Fields{"size": int64(1000)})
+	if got := tracker.Status().Bytes; got != 1000 {
+		t.Errorf("Counted %d bytes after Stop", got)
+	}
+}
+
+func TestBar(t *testing.T) {
+	var out bytes.Buffer
+	bar := NewBar(&out)
+
+	bar.Write([]byte("first log\n"))
+	bar.Update(Status{Phase: "parse", Unit: "packages", Done: 1, Total: 4})
+	bar.Write([]byte("second log\n"))
+	bar.Close(Status{})
+
+	want := "first log\n" +
+		"[======>                 ] parse: 1/4 packages (25%)" +
+		"\r\033[Ksecond log\n[======>                 ] parse: 1/4 packages (25%)" +
+		"\r\033[K"
+	if got := out.String(); got != want {
+		t.Errorf("Bar wrote %q, want %q", got, want)
+	}
+}
+
+func TestRender(t *testing.T) {
+	full := render(Status{Phase: "publish", Unit: "repositories", Done: 3, Total: 3})
+	if !strings.HasPrefix(full, "["+strings.Repeat("=", barWidth)+"] ") {
+		t.Errorf("Full bar = %q", full)
+	}
+	if got := render(Status{Phase: "scan"}); got != "scan: 0s elapsed" {
+		t.Errorf("Bar without total = %q", got)
+	}
+}
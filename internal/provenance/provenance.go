@@ -0,0 +1,105 @@
+package provenance
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Dir is the directory of the provenance records, relative to the repository root
+const Dir = ".repogen/provenance"
+
+// Record describes where a published package file came from
+type Record struct {
+	Type           string     `json:"type"`
+	Name           string     `json:"name"`
+	Version        string     `json:"version"`
+	Architecture   string     `json:"arch"`
+	Path           string     `json:"path"`                 // Published file, relative to the repository root
+	Source         string     `json:"source"`               // File the package was published from
+	BuildTime      *time.Time `json:"build_time,omitempty"` // When the metadata says
+	PublishedAt    time.Time  `json:"published_at"`
+	Size           int64      `json:"size,omitempty"`
+	MD5            string     `json:"md5,omitempty"`
+	SHA1           string     `json:"sha1,omitempty"`
+	SHA256         string     `json:"sha256,omitempty"`
+	SHA512         string     `json:"sha512,omitempty"`
+	SigningKey     string     `json:"signing_key,omitempty"` // OpenPGP fingerprint, or Alpine key name
+	RepogenVersion string     `json:"repogen_version"`
+}
+
+// NewRecord describes pkg, published at rel (slash-separated, relative to
+// the repository root) from source
+func NewRecord(pkgType string, pkg models.Package, rel, source string) *Record {
+	rec := &Record{
+		Type:         pkgType,
+		Name:         pkg.Name,
+		Version:      pkg.Version,
+		Architecture: pkg.Architecture,
+		Path:         rel,
+		Source:       source,
+		PublishedAt:  time.Now().UTC(),
+		Size:         pkg.Size,
+		MD5:          pkg.MD5Sum,
+		SHA1:         pkg.SHA1Sum,
+		SHA256:       pkg.SHA256Sum,
+		SHA512:       pkg.SHA512Sum,
+	}
+	if built, ok := utils.BuildTime(pkg); ok {
+		built = built.UTC()
+		rec.BuildTime = &built
+	}
+	if abs, err := filepath.Abs(source); err == nil {
+		rec.Source = abs
+	}
+	return rec
+}
+
+// RecordPath returns the sidecar of the package file at rel, as a
+// slash-separated path relative to the repository root
+func RecordPath(rel string) string {
+	return path.Join(Dir, rel+".json")
+}
+
+// Write stores rec in its sidecar under outputDir, replacing any previous record
+func Write(outputDir string, rec *Record) error {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	sidecar := filepath.Join(outputDir, filepath.FromSlash(RecordPath(rec.Path)))
+	if err := utils.WriteFile(sidecar, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write provenance of %s: %w", rec.Path, err)
+	}
+	return nil
+}
+
+// Read loads the record of the package file at rel
+func Read(outputDir, rel string) (*Record, error) {
+	data, err := os.ReadFile(filepath.Join(outputDir, filepath.FromSlash(RecordPath(rel))))
+	if err != nil {
+		return nil, err
+	}
+
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("invalid provenance of %s: %w", rel, err)
+	}
+	return &rec, nil
+}
+
+// Remove deletes the record of the package file at rel, if any
+func Remove(outputDir, rel string) error {
+	err := os.Remove(filepath.Join(outputDir, filepath.FromSlash(RecordPath(rel))))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
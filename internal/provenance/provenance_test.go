@@ -0,0 +1,58 @@
+package provenance
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	pkg := models.Package{
+		Name:         "hello",
+		Version:      "1.0-1",
+		Architecture: "x86_64",
+		Size:         42,
+		SHA256Sum:    "abc",
+		Metadata:     map[string]interface{}{"BuildTime": int64(1700000000)},
+	}
+
+	rec := NewRecord("rpm", pkg, "40/x86_64/hello-1.0-1.x86_64.rpm", "dist/hello-1.0-1.x86_64.rpm")
+	rec.SigningKey = "0123ABCD"
+	rec.RepogenVersion = "1.2.3"
+	if err := Write(dir, rec); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	sidecar := filepath.Join(dir, ".repogen", "provenance", "40", "x86_64", "hello-1.0-1.x86_64.rpm.json")
+	if _, err := os.Stat(sidecar); err != nil {
+		t.Fatalf("sidecar not written: %v", err)
+	}
+
+	got, err := Read(dir, rec.Path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if got.Name != "hello" || got.SHA256 != "abc" || got.SigningKey != "0123ABCD" || got.RepogenVersion != "1.2.3" {
+		t.Errorf("unexpected record %+v", got)
+	}
+	if got.BuildTime == nil || !got.BuildTime.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("BuildTime = %v, want %v", got.BuildTime, time.Unix(1700000000, 0))
+	}
+	if !filepath.IsAbs(got.Source) {
+		t.Errorf("Source %q is not absolute", got.Source)
+	}
+
+	if err := Remove(dir, rec.Path); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := os.Stat(sidecar); !os.IsNotExist(err) {
+		t.Errorf("sidecar still exists after Remove")
+	}
+	if err := Remove(dir, rec.Path); err != nil {
+		t.Errorf("Remove of a missing record failed: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Path is the generation report, relative to the repository root
+const Path = ".repogen/report.json"
+
+// File is a file written to the repository
+type File struct {
+	Path   string `json:"path"` // Relative to the repository root
+	Size   int64  `json:"size"`
+	MD5    string `json:"md5,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	SHA512 string `json:"sha512,omitempty"`
+	Link   string `json:"link,omitempty"` // Target of symlinks, which have no checksums
+}
+
+// Report describes a generation for CI pipelines: the packages published
+// per package type and the files written
+type Report struct {
+	GeneratedAt    time.Time      `json:"generated_at"`
+	RepogenVersion string         `json:"repogen_version"`
+	OutputDir      string         `json:"output_dir"`
+	Packages       map[string]int `json:"packages"` // By package type
+	Files          []File         `json:"files"`
+}
+
+// Written lists the files of dir written since the given time, sorted by
+// path. Files kept as they were, like packages already published, are left out
+func Written(dir string, since time.Time) ([]File, error) {
+	// Some filesystems only store modification times to the second
+	since = since.Truncate(time.Second)
+
+	var files []File
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if utils.IsInternalPath(filepath.ToSlash(rel)) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(since) {
+			return nil
+		}
+
+		file := File{Path: filepath.ToSlash(rel), Size: info.Size()}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			if file.Link, err = os.Readlink(path); err != nil {
+				return err
+			}
+			files = append(files, file)
+			return nil
+		}
+
+		checksums, err := utils.CalculateChecksums(path)
+		if err != nil {
+			return err
+		}
+		file.MD5 = checksums.MD5
+		file.SHA256 = checksums.SHA256
+		file.SHA512 = checksums.SHA512
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list written files: %w", err)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// Write stores r as the report of the repository in outputDir
+func Write(outputDir string, r *Report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFile(filepath.Join(outputDir, filepath.FromSlash(Path)), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
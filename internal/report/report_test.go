@@ -0,0 +1,54 @@
+package report
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWrittenListsFilesOfTheRun(t *testing.T) {
+	dir := t.TempDir()
+
+	old := filepath.Join(dir, "pool", "old.deb")
+	if err := os.MkdirAll(filepath.Dir(old), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(old, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(old, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := os.WriteFile(filepath.Join(dir, "Packages"), []byte("Package: hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("Packages", filepath.Join(dir, "Index")); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := Written(dir, start)
+	if err != nil {
+		t.Fatalf("Written failed: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 written files, got %+v", files)
+	}
+	if files[0].Path != "Index" || files[0].Link != "Packages" || files[0].SHA256 != "" {
+		t.Errorf("unexpected symlink %+v", files[0])
+	}
+	packages := files[1]
+	if packages.Path != "Packages" || packages.Size != 15 || packages.SHA256 != "b0504db6bdc2c07dd019a214eb84e0956281316b21caa923c2b87bc8130a71e5" || len(packages.SHA512) != 128 {
+		t.Errorf("unexpected file %+v", packages)
+	}
+
+	if err := Write(dir, &Report{Packages: map[string]int{"deb": 1}, Files: files}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(Path))); err != nil {
+		t.Errorf("report missing: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package retention
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Policy decides which package versions are retired from a repository.
+// A version is retired only when it falls outside every configured limit;
+// the newest version of each package is always kept
+type Policy struct {
+	Keep     int       // Number of newest versions to keep per package and architecture (0 = no limit)
+	KeepDays int       // Keep versions built within this many days (0 = no limit)
+	Now      time.Time // Reference time for KeepDays
+}
+
+// Enabled reports whether the policy retires anything at all
+func (p Policy) Enabled() bool {
+	return p.Keep > 0 || p.KeepDays > 0
+}
+
+// Select splits the packages of pkgType into the versions to keep and the
+// ones to retire, ordering versions by the rules of pkgType. builtAt returns the build (or publication) time of a package, and false
+// when it is unknown; packages of unknown age are never retired by KeepDays
+func (p Policy) Select(pkgType scanner.PackageType, packages []models.Package, builtAt func(models.Package) (time.Time, bool)) (keep, retire []models.Package) {
+	if !p.Enabled() {
+		return packages, nil
+	}
+
+	// Group versions of the same package, per architecture (and distro version for RPM)
+	groups := make(map[string][]models.Package)
+	var order []string
+	for _, pkg := range packages {
+		key := pkg.Name + "\x00" + pkg.Architecture
+		if distroVersion, ok := pkg.Metadata["DistroVersion"].(string); ok {
+			key += "\x00" + distroVersion
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], pkg)
+	}
+
+	cutoff := p.Now.AddDate(0, 0, -p.KeepDays)
+	compare := utils.VersionComparator(pkgType.String())
+
+	for _, key := range order {
+		versions := groups[key]
+
+		// Newest first
+		sort.SliceStable(versions, func(i, j int) bool {
+			return compare(fullVersion(versions[i]), fullVersion(versions[j])) > 0
+		})
+
+		for i, pkg := range versions {
+			if i == 0 || p.Keep > 0 && i < p.Keep || p.KeepDays > 0 && p.isRecent(pkg, cutoff, builtAt) {
+				keep = append(keep, pkg)
+			} else {
+				retire = append(retire, pkg)
+			}
+		}
+	}
+
+	return keep, retire
+}
+
+func (p Policy) isRecent(pkg models.Package, cutoff time.Time, builtAt func(models.Package) (time.Time, bool)) bool {
+	t, ok := builtAt(pkg)
+	return !ok || t.After(cutoff)
+}
+
+// fullVersion returns the version used for ordering, including the RPM
+// epoch and release
+func fullVersion(pkg models.Package) string {
+	version := pkg.Version
+	if release, ok := pkg.Metadata["Release"].(string); ok && release != "" {
+		version += "-" + release
+	}
+	if epoch, ok := pkg.Metadata["Epoch"].(string); ok && epoch != "" && epoch != "0" {
+		version = epoch + ":" + version
+	}
+	return version
+}
@@ -0,0 +1,129 @@
+package retention
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/scanner"
+)
+
+func TestPolicySelect(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	unknownAge := func(models.Package) (time.Time, bool) { return time.Time{}, false }
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		pkgType  scanner.PackageType
+		versions []string
+		builtAt  func(models.Package) (time.Time, bool)
+		keep     []string
+	}{
+		{
+			name:     "disabled",
+			pkgType:  scanner.TypeDeb,
+			versions: []string{"1.0", "2.0"},
+			keep:     []string{"1.0", "2.0"},
+		},
+		{
+			name:     "debian revisions",
+			policy:   Policy{Keep: 2},
+			pkgType:  scanner.TypeDeb,
+			versions: []string{"1.0.1", "1.0+1", "1.0-1", "1.0~rc1", "1.0"},
+			keep:     []string{"1.0.1", "1.0+1"},
+		},
+		{
+			name:     "debian revision below the next upstream version",
+			policy:   Policy{Keep: 1},
+			pkgType:  scanner.TypeDeb,
+			versions: []string{"1.0.1", "1.0-1"},
+			keep:     []string{"1.0.1"},
+		},
+		{
+			name:     "rpm",
+			policy:   Policy{Keep: 2},
+			pkgType:  scanner.TypeRpm,
+			versions: []string{"1.0~rc1", "1.10", "1.9", "1.0"},
+			keep:     []string{"1.10", "1.9"},
+		},
+		{
+			name:     "semver pre-releases",
+			policy:   Policy{Keep: 1},
+			pkgType:  scanner.TypeCargo,
+			versions: []string{"1.0.0-rc.1", "1.0.0", "0.9.0"},
+			keep:     []string{"1.0.0"},
+		},
+		{
+			name:     "pep 440 pre-releases",
+			policy:   Policy{Keep: 2},
+			pkgType:  scanner.TypePypi,
+			versions: []string{"1.0rc1", "1.0", "1.0.post1", "1.0a1"},
+			keep:     []string{"1.0.post1", "1.0"},
+		},
+		{
+			name:     "newest always kept",
+			policy:   Policy{KeepDays: 7, Now: now},
+			pkgType:  scanner.TypeDeb,
+			versions: []string{"1.0", "2.0"},
+			builtAt:  func(models.Package) (time.Time, bool) { return now.AddDate(0, 0, -30), true },
+			keep:     []string{"2.0"},
+		},
+		{
+			name:     "unknown age kept",
+			policy:   Policy{KeepDays: 7, Now: now},
+			pkgType:  scanner.TypeDeb,
+			versions: []string{"1.0", "2.0"},
+			builtAt:  unknownAge,
+			keep:     []string{"2.0", "1.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var packages []models.Package
+			for _, version := range tt.versions {
+				packages = append(packages, models.Package{Name: "hello", Version: version, Architecture: "amd64"})
+			}
+			builtAt := tt.builtAt
+			if builtAt == nil {
+				builtAt = unknownAge
+			}
+
+			keep, retire := tt.policy.Select(tt.pkgType, packages, builtAt)
+			var kept []string
+			for _, pkg := range keep {
+				kept = append(kept, pkg.Version)
+			}
+			if !reflect.DeepEqual(kept, tt.keep) {
+				t.Errorf("Kept %v, want %v", kept, tt.keep)
+			}
+			if len(keep)+len(retire) != len(packages) {
+				t.Errorf("Kept %d and retired %d of %d packages", len(keep), len(retire), len(packages))
+			}
+		})
+	}
+}
+
+func TestPolicySelectGroups(t *testing.T) {
+	packages := []models.Package{
+		{Name: "hello", Version: "1.0", Architecture: "amd64"},
+		{Name: "hello", Version: "2.0", Architecture: "amd64"},
+		{Name: "hello", Version: "1.0", Architecture: "arm64"},
+		{Name: "world", Version: "1.0", Architecture: "amd64"},
+		{Name: "hello", Version: "1.0", Architecture: "x86_64", Metadata: map[string]interface{}{"Release": "1", "DistroVersion": "el8"}},
+		{Name: "hello", Version: "1.0", Architecture: "x86_64", Metadata: map[string]interface{}{"Release": "2", "DistroVersion": "el8"}},
+		{Name: "hello", Version: "1.0", Architecture: "x86_64", Metadata: map[string]interface{}{"Release": "1", "DistroVersion": "el9"}},
+	}
+	keep, retire := Policy{Keep: 1}.Select(scanner.TypeRpm, packages, func(models.Package) (time.Time, bool) { return time.Time{}, false })
+	if len(keep) != 5 || len(retire) != 2 {
+		t.Fatalf("Kept %d and retired %d, want 5 and 2", len(keep), len(retire))
+	}
+	if retire[0].Version != "1.0" || retire[0].Architecture != "amd64" {
+		t.Errorf("Retired %+v, want hello 1.0 (amd64)", retire[0])
+	}
+	if release := retire[1].Metadata["Release"]; release != "1" || retire[1].Metadata["DistroVersion"] != "el8" {
+		t.Errorf("Retired %+v, want hello 1.0-1 (el8)", retire[1])
+	}
+}
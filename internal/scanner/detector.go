@@ -1,9 +1,11 @@
 package scanner
 
 import (
+	"archive/zip"
 	"bytes"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -23,8 +25,18 @@ var (
 
 	// XZ magic bytes (Pacman packages .pkg.tar.xz)
 	xzMagic = []byte{0xFD, 0x37, 0x7A, 0x58, 0x5A, 0x00}
+
+	// Bzip2 magic bytes (legacy conda packages .tar.bz2)
+	bzip2Magic = []byte("BZh")
+
+	// Zip magic bytes (Android APKs)
+	zipMagic = []byte{0x50, 0x4B, 0x03, 0x04}
 )
 
+// bottleSuffixRe matches the end of bottle file names, with their optional
+// rebuild number (foo--1.0.arm64_sonoma.bottle.1.tar.gz)
+var bottleSuffixRe = regexp.MustCompile(`\.bottle\.(\d+\.)?tar(\.gz)?$`)
+
 // DetectPackageType determines the package type based on magic bytes and file extension
 func DetectPackageType(path string) (PackageType, error) {
 	// Open file
@@ -60,6 +72,11 @@ func DetectPackageType(path string) (PackageType, error) {
 		return TypeApk, nil
 	}
 
+	// Check for Android APK (zip archive with an AndroidManifest.xml)
+	if bytes.HasPrefix(header, zipMagic) && ext == ".apk" && hasZipEntry(path, "AndroidManifest.xml") {
+		return TypeFdroid, nil
+	}
+
 	// Check for Pacman package (.pkg.tar.zst, .pkg.tar.xz, .pkg.tar.gz, .pkg.tar)
 	if strings.Contains(basename, ".pkg.tar.") {
 		// Check compression format
@@ -78,9 +95,66 @@ func DetectPackageType(path string) (PackageType, error) {
 	}
 
 	// Check for Homebrew bottle (filename pattern)
-	if strings.Contains(basename, ".bottle.tar.gz") || strings.Contains(basename, ".bottle.tar") {
+	if bottleSuffixRe.MatchString(basename) {
 		return TypeHomebrewBottle, nil
 	}
 
+	// Check for RubyGems (plain tar archives)
+	if ext == ".gem" {
+		return TypeRubygem, nil
+	}
+
+	// Check for Rust crates (gzipped tars)
+	if bytes.HasPrefix(header, gzipMagic) && ext == ".crate" {
+		return TypeCargo, nil
+	}
+
+	// Check for conda packages: zip archives, or legacy bzip2 tarballs
+	if ext == ".conda" || bytes.HasPrefix(header, bzip2Magic) && strings.HasSuffix(basename, ".tar.bz2") {
+		return TypeConda, nil
+	}
+
+	// Check for NuGet packages (zip archives)
+	if strings.ToLower(ext) == ".nupkg" {
+		return TypeNuget, nil
+	}
+
+	// Check for Void Linux packages (zstd tars, or xz ones from older xbps)
+	if ext == ".xbps" {
+		return TypeXbps, nil
+	}
+
+	// Check for Python wheels and source distributions
+	if ext == ".whl" || bytes.HasPrefix(header, gzipMagic) && strings.HasSuffix(basename, ".tar.gz") {
+		return TypePypi, nil
+	}
+
+	// Check for Terraform provider archives, before other zip archives
+	if strings.HasPrefix(basename, "terraform-provider-") && ext == ".zip" {
+		return TypeTerraform, nil
+	}
+
+	// Check for macOS app artifacts, published as Homebrew casks
+	switch strings.ToLower(ext) {
+	case ".dmg", ".pkg", ".zip":
+		return TypeHomebrewCask, nil
+	}
+
 	return TypeUnknown, nil
 }
+
+// hasZipEntry reports whether the zip archive at path holds a file called name
+func hasZipEntry(path, name string) bool {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return false
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -4,17 +4,24 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
 // FileSystemScanner implements Scanner interface for filesystem scanning
-type FileSystemScanner struct{}
+type FileSystemScanner struct {
+	include []string // Globs files must match one of, all files when empty
+	exclude []string // Globs of files to skip, winning over include
+}
 
-// NewFileSystemScanner creates a new filesystem scanner
-func NewFileSystemScanner() *FileSystemScanner {
-	return &FileSystemScanner{}
+// NewFileSystemScanner creates a new filesystem scanner. Patterns are
+// matched against file names, or against paths relative to the scanned
+// directory when they contain a slash
+func NewFileSystemScanner(include, exclude []string) *FileSystemScanner {
+	return &FileSystemScanner{include: include, exclude: exclude}
 }
 
 // Scan recursively scans a directory for packages
@@ -38,6 +45,11 @@ func (s *FileSystemScanner) Scan(ctx context.Context, dir string) ([]ScannedPack
 			return nil
 		}
 
+		if !s.matches(dir, path) {
+			logrus.Debugf("Skipping %s: filtered out by --include/--exclude", path)
+			return nil
+		}
+
 		// Try to detect package type
 		pkgType, err := s.DetectType(path)
 		if err != nil {
@@ -73,3 +85,35 @@ func (s *FileSystemScanner) Scan(ctx context.Context, dir string) ([]ScannedPack
 func (s *FileSystemScanner) DetectType(path string) (PackageType, error) {
 	return DetectPackageType(path)
 }
+
+// matches reports whether file, found below dir, passes the include and
+// exclude patterns
+func (s *FileSystemScanner) matches(dir, file string) bool {
+	rel, err := filepath.Rel(dir, file)
+	if err != nil {
+		rel = file
+	}
+	return Matches(s.include, s.exclude, filepath.ToSlash(rel))
+}
+
+// Matches reports whether the file at rel, a slash-separated path below the
+// scanned directory, passes the include and exclude patterns
+func Matches(include, exclude []string, rel string) bool {
+	if len(include) > 0 && !matchAny(include, rel) {
+		return false
+	}
+	return !matchAny(exclude, rel)
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		subject := path.Base(rel)
+		if strings.Contains(pattern, "/") {
+			subject = rel
+		}
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
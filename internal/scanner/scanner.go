@@ -12,6 +12,15 @@ const (
 	TypeApk
 	TypeHomebrewBottle
 	TypePacman
+	TypeHomebrewCask
+	TypePypi
+	TypeRubygem
+	TypeCargo
+	TypeNuget
+	TypeConda
+	TypeFdroid
+	TypeXbps
+	TypeTerraform
 )
 
 // String returns the string representation of PackageType
@@ -27,6 +36,24 @@ func (pt PackageType) String() string {
 		return "brew"
 	case TypePacman:
 		return "pacman"
+	case TypeHomebrewCask:
+		return "cask"
+	case TypePypi:
+		return "pypi"
+	case TypeRubygem:
+		return "gem"
+	case TypeCargo:
+		return "cargo"
+	case TypeNuget:
+		return "nuget"
+	case TypeConda:
+		return "conda"
+	case TypeFdroid:
+		return "fdroid"
+	case TypeXbps:
+		return "xbps"
+	case TypeTerraform:
+		return "terraform"
 	default:
 		return "unknown"
 	}
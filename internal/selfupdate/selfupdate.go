@@ -0,0 +1,274 @@
+package selfupdate
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// Release assets, as named by .goreleaser.yml
+const (
+	checksumsAsset = "checksums.txt"
+	signatureAsset = "checksums.txt.sig"
+	binaryName     = "repogen"
+)
+
+// maxAssetSize bounds downloads, so a bogus asset can't exhaust memory
+const maxAssetSize = 256 << 20
+
+// Updater fetches repogen releases from GitHub
+type Updater struct {
+	APIURL string       // GitHub API root, e.g. https://api.github.com
+	Repo   string       // owner/name
+	Client *http.Client // nil for http.DefaultClient
+}
+
+// New returns an Updater for the official repogen releases
+func New() *Updater {
+	return &Updater{APIURL: "https://api.github.com", Repo: "ralt/repogen"}
+}
+
+// Release is a published release and the download URLs of its assets
+type Release struct {
+	Tag     string            // e.g. "v1.2.3"
+	Version string            // Tag without its "v" prefix
+	Assets  map[string]string // Asset name to download URL
+}
+
+// ArchiveName returns the archive holding the binary for this platform
+func (r *Release) ArchiveName() string {
+	return fmt.Sprintf("repogen_%s_%s_%s.tar.gz", r.Version, runtime.GOOS, runtime.GOARCH)
+}
+
+// Latest returns the latest release
+func (u *Updater) Latest(ctx context.Context) (*Release, error) {
+	url := fmt.Sprintf("%s/repos/%s/releases/latest", strings.TrimRight(u.APIURL, "/"), u.Repo)
+	data, err := u.get(ctx, url, "application/vnd.github+json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latest release: %w", err)
+	}
+
+	var doc struct {
+		TagName string `json:"tag_name"`
+		Assets  []struct {
+			Name string `json:"name"`
+			URL  string `json:"browser_download_url"`
+		} `json:"assets"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid release document: %w", err)
+	}
+	if doc.TagName == "" {
+		return nil, fmt.Errorf("release document has no tag")
+	}
+
+	rel := &Release{
+		Tag:     doc.TagName,
+		Version: strings.TrimPrefix(doc.TagName, "v"),
+		Assets:  make(map[string]string),
+	}
+	for _, asset := range doc.Assets {
+		rel.Assets[asset.Name] = asset.URL
+	}
+	return rel, nil
+}
+
+// Download fetches the binary of rel for this platform, checking the
+// archive against checksums.txt. When keyring is not empty, checksums.txt
+// must carry a detached signature by one of its keys
+func (u *Updater) Download(ctx context.Context, rel *Release, keyring openpgp.EntityList) ([]byte, error) {
+	archiveName := rel.ArchiveName()
+	archiveURL, ok := rel.Assets[archiveName]
+	if !ok {
+		return nil, fmt.Errorf("release %s has no %s", rel.Tag, archiveName)
+	}
+	checksumsURL, ok := rel.Assets[checksumsAsset]
+	if !ok {
+		return nil, fmt.Errorf("release %s has no %s", rel.Tag, checksumsAsset)
+	}
+
+	checksums, err := u.get(ctx, checksumsURL, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", checksumsAsset, err)
+	}
+
+	if len(keyring) > 0 {
+		sigURL, ok := rel.Assets[signatureAsset]
+		if !ok {
+			return nil, fmt.Errorf("release %s is not signed (no %s)", rel.Tag, signatureAsset)
+		}
+		sig, err := u.get(ctx, sigURL, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", signatureAsset, err)
+		}
+		if err := verifySignature(keyring, checksums, sig); err != nil {
+			return nil, err
+		}
+	}
+
+	want, err := lookupChecksum(checksums, archiveName)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := u.get(ctx, archiveURL, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", archiveName, err)
+	}
+	sum := sha256.Sum256(archive)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return nil, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", archiveName, want, got)
+	}
+
+	return extractBinary(archive)
+}
+
+// get downloads url, accepting only a 200 response
+func (u *Updater) get(ctx context.Context, url, accept string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAssetSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxAssetSize {
+		return nil, fmt.Errorf("GET %s: response larger than %d bytes", url, maxAssetSize)
+	}
+	return data, nil
+}
+
+// ReadKeyring reads the armored or binary public keys releases are signed with
+func ReadKeyring(path string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read release key %s: %w", path, err)
+	}
+	return keyring, nil
+}
+
+// verifySignature checks the detached signature (binary or armored) of checksums
+func verifySignature(keyring openpgp.EntityList, checksums, sig []byte) error {
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN PGP SIGNATURE-----")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(checksums), bytes.NewReader(sig), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(checksums), bytes.NewReader(sig), nil)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid signature of %s: %w", checksumsAsset, err)
+	}
+	return nil
+}
+
+// lookupChecksum finds the SHA-256 of name in a sha256sum style file
+func lookupChecksum(checksums []byte, name string) (string, error) {
+	sc := bufio.NewScanner(bytes.NewReader(checksums))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no checksum for %s", checksumsAsset, name)
+}
+
+// extractBinary returns the repogen binary of a release archive
+func extractBinary(archive []byte) ([]byte, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg && filepath.Base(header.Name) == binaryName {
+			return io.ReadAll(io.LimitReader(tr, maxAssetSize))
+		}
+	}
+	return nil, fmt.Errorf("release archive has no %s binary", binaryName)
+}
+
+// Replace atomically replaces the executable at exe with binary: it is
+// written next to exe and renamed over it, so an interrupted update leaves
+// the old binary in place
+func Replace(exe string, binary []byte) error {
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".repogen-update-*")
+	if err != nil {
+		return fmt.Errorf("cannot write next to %s: %w", exe, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()|0111); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+	return nil
+}
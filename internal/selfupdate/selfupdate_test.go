@@ -0,0 +1,149 @@
+package selfupdate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// releaseServer serves a fake GitHub release holding binary, signed by
+// signer when not nil
+func releaseServer(t *testing.T, binary []byte, signer *openpgp.Entity) (*httptest.Server, *Release) {
+	t.Helper()
+
+	var archive bytes.Buffer
+	gw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "README.md", Mode: 0644, Size: 2, Typeflag: tar.TypeReg})
+	tw.Write([]byte("hi"))
+	tw.WriteHeader(&tar.Header{Name: binaryName, Mode: 0755, Size: int64(len(binary)), Typeflag: tar.TypeReg})
+	tw.Write(binary)
+	tw.Close()
+	gw.Close()
+
+	rel := &Release{Tag: "v9.9.9", Version: "9.9.9"}
+	sum := sha256.Sum256(archive.Bytes())
+	checksums := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), rel.ArchiveName())
+
+	files := map[string][]byte{
+		rel.ArchiveName(): archive.Bytes(),
+		checksumsAsset:    []byte(checksums),
+	}
+	if signer != nil {
+		var sig bytes.Buffer
+		if err := openpgp.DetachSign(&sig, signer, strings.NewReader(checksums), nil); err != nil {
+			t.Fatal(err)
+		}
+		files[signatureAsset] = sig.Bytes()
+	}
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	var assets []string
+	for name := range files {
+		assets = append(assets, fmt.Sprintf(`{"name": %q, "browser_download_url": %q}`, name, srv.URL+"/download/"+name))
+	}
+	mux.HandleFunc("/repos/ralt/repogen/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"tag_name": %q, "assets": [%s]}`, rel.Tag, strings.Join(assets, ","))
+	})
+	mux.HandleFunc("/download/", func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[strings.TrimPrefix(r.URL.Path, "/download/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	})
+
+	return srv, rel
+}
+
+func TestDownloadVerifiesRelease(t *testing.T) {
+	key, err := openpgp.NewEntity("Release", "", "release@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := releaseServer(t, []byte("new binary"), key)
+
+	u := &Updater{APIURL: srv.URL, Repo: "ralt/repogen"}
+	rel, err := u.Latest(context.Background())
+	if err != nil {
+		t.Fatalf("Latest failed: %v", err)
+	}
+	if rel.Version != "9.9.9" {
+		t.Errorf("unexpected version %s", rel.Version)
+	}
+
+	binary, err := u.Download(context.Background(), rel, openpgp.EntityList{key})
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if string(binary) != "new binary" {
+		t.Errorf("unexpected binary %q", binary)
+	}
+
+	// A signature by another key is refused
+	other, _ := openpgp.NewEntity("Other", "", "other@example.com", nil)
+	if _, err := u.Download(context.Background(), rel, openpgp.EntityList{other}); err == nil {
+		t.Error("Download accepted a release signed by another key")
+	}
+
+	// A tampered archive is refused
+	rel.Assets[rel.ArchiveName()] = srv.URL + "/download/" + checksumsAsset
+	if _, err := u.Download(context.Background(), rel, nil); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Download accepted a tampered archive: %v", err)
+	}
+}
+
+func TestDownloadRequiresSignatureWithKey(t *testing.T) {
+	srv, _ := releaseServer(t, []byte("new binary"), nil)
+	key, _ := openpgp.NewEntity("Release", "", "release@example.com", nil)
+
+	u := &Updater{APIURL: srv.URL, Repo: "ralt/repogen"}
+	rel, err := u.Latest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Download(context.Background(), rel, openpgp.EntityList{key}); err == nil {
+		t.Error("Download accepted an unsigned release although a key was given")
+	}
+	if _, err := u.Download(context.Background(), rel, nil); err != nil {
+		t.Errorf("Download of a checksummed release failed: %v", err)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	exe := filepath.Join(t.TempDir(), "repogen")
+	os.WriteFile(exe, []byte("old binary"), 0750)
+
+	if err := Replace(exe, []byte("new binary")); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(exe)
+	if string(data) != "new binary" {
+		t.Errorf("binary not replaced: %q", data)
+	}
+	info, _ := os.Stat(exe)
+	if info.Mode().Perm() != 0750|0111 {
+		t.Errorf("unexpected mode %v", info.Mode())
+	}
+	entries, _ := os.ReadDir(filepath.Dir(exe))
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
@@ -0,0 +1,252 @@
+// Package sftp publishes repositories to SFTP servers: it uploads the
+// files of a local staging directory over SSH, packages first, then the
+// metadata, then its signatures, so the server never serves metadata
+// referencing missing files or signatures of other metadata
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Scheme prefixes the URLs of SFTP targets
+const Scheme = "sftp://"
+
+// Target is a directory of an SFTP server
+type Target struct {
+	User     string
+	Password string // From the URL, for servers without key authentication
+	Host     string // host:port
+	Path     string // Relative to the home directory unless absolute
+}
+
+// IsURL reports whether s is an SFTP URL
+func IsURL(s string) bool {
+	return strings.HasPrefix(s, Scheme)
+}
+
+// ParseURL parses sftp://[user[:password]@]host[:port]/path. Paths
+// starting with /~/ are relative to the home directory of the user
+func ParseURL(raw string) (*Target, error) {
+	if !IsURL(raw) {
+		return nil, fmt.Errorf("%q is not an sftp:// URL", raw)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%q has no host", raw)
+	}
+
+	t := &Target{Host: u.Host, Path: u.Path}
+	if u.Port() == "" {
+		t.Host = net.JoinHostPort(u.Hostname(), "22")
+	}
+	if u.User != nil {
+		t.User = u.User.Username()
+		t.Password, _ = u.User.Password()
+	}
+	if t.User == "" {
+		t.User = os.Getenv("USER")
+	}
+	switch {
+	case t.Path == "" || t.Path == "/~" || t.Path == "/~/":
+		t.Path = "."
+	case strings.HasPrefix(t.Path, "/~/"):
+		t.Path = strings.TrimPrefix(t.Path, "/~/")
+	}
+	t.Path = path.Clean(t.Path)
+	return t, nil
+}
+
+// String returns the URL of the target, without its password
+func (t *Target) String() string {
+	p := t.Path
+	if !path.IsAbs(p) {
+		p = "/~/" + p
+	}
+	return Scheme + t.User + "@" + strings.TrimSuffix(t.Host, ":22") + path.Clean(p)
+}
+
+// Options configures the SSH connection
+type Options struct {
+	KeyPath        string        // Private key to authenticate with, besides ssh-agent and ~/.ssh/id_*
+	KnownHostsPath string        // Known host keys, ~/.ssh/known_hosts when empty
+	Sessions       int           // SFTP sessions transferring files at once, 4 when zero
+	Timeout        time.Duration // Connection timeout, 30s when zero
+	Verify         bool          // Read uploaded files back before renaming them into place
+}
+
+// Client is an SSH connection to an SFTP server, with a pool of SFTP
+// sessions transferring files at once
+type Client struct {
+	target   *Target
+	conn     *ssh.Client
+	sessions chan *session
+	all      []*session
+	verify   bool
+}
+
+// Dial connects to the server of target, checking its host key against
+// the known hosts
+func Dial(target *Target, opts Options) (*Client, error) {
+	knownHosts := opts.KnownHostsPath
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts (add the server with %s >> %s): %w", keyscan(target.Host), knownHosts, err)
+	}
+
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	auth, err := authMethods(target, opts.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ssh.Dial("tcp", target.Host, &ssh.ClientConfig{
+		User:            target.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         timeout,
+	})
+	if err != nil {
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return nil, fmt.Errorf("%s is not a known host (add it with %s >> %s): %w", target.Host, keyscan(target.Host), knownHosts, err)
+		}
+		return nil, fmt.Errorf("failed to connect to %s: %w", target.Host, err)
+	}
+
+	n := opts.Sessions
+	if n <= 0 {
+		n = 4
+	}
+	c := &Client{target: target, conn: conn, sessions: make(chan *session, n), verify: opts.Verify}
+	for i := 0; i < n; i++ {
+		s, err := c.newSession()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.all = append(c.all, s)
+		c.sessions <- s
+	}
+	return c, nil
+}
+
+// keyscan returns the ssh-keyscan command printing the keys of host
+func keyscan(host string) string {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil || port == "22" {
+		return "ssh-keyscan " + strings.TrimSuffix(host, ":22")
+	}
+	return "ssh-keyscan -p " + port + " " + hostname
+}
+
+// newSession starts the sftp subsystem on a new channel of the connection
+func (c *Client) newSession() (*session, error) {
+	sshSession, err := c.conn.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open an SSH session: %w", err)
+	}
+	stdin, err := sshSession.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := sshSession.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := sshSession.RequestSubsystem("sftp"); err != nil {
+		sshSession.Close()
+		return nil, fmt.Errorf("the server doesn't serve SFTP: %w", err)
+	}
+	s, err := newSession(&channel{Reader: stdout, WriteCloser: stdin, session: sshSession})
+	if err != nil {
+		sshSession.Close()
+		return nil, fmt.Errorf("failed to start SFTP: %w", err)
+	}
+	return s, nil
+}
+
+// channel joins the stdin and stdout of an SSH session
+type channel struct {
+	io.Reader
+	io.WriteCloser
+	session *ssh.Session
+}
+
+func (c *channel) Close() error {
+	c.WriteCloser.Close()
+	return c.session.Close()
+}
+
+// Close closes the sessions and the connection
+func (c *Client) Close() error {
+	for _, s := range c.all {
+		s.Close()
+	}
+	return c.conn.Close()
+}
+
+// authMethods returns the ways of authenticating: the password of the URL,
+// the keys of ssh-agent, then keyPath or the unencrypted default keys
+func authMethods(target *Target, keyPath string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if target.Password != "" {
+		methods = append(methods, ssh.Password(target.Password))
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	var signers []ssh.Signer
+	if keyPath != "" {
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH key %s (encrypted keys must be loaded in ssh-agent): %w", keyPath, err)
+		}
+		signers = append(signers, signer)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			data, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+			if err != nil {
+				continue
+			}
+			if signer, err := ssh.ParsePrivateKey(data); err == nil {
+				signers = append(signers, signer)
+			}
+		}
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	return methods, nil
+}
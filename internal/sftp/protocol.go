@@ -0,0 +1,519 @@
+package sftp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// SFTP version 3 packet types (draft-ietf-secsh-filexfer-02), the version
+// OpenSSH implements
+const (
+	fxpInit          = 1
+	fxpVersion       = 2
+	fxpOpen          = 3
+	fxpClose         = 4
+	fxpRead          = 5
+	fxpWrite         = 6
+	fxpLstat         = 7
+	fxpSetstat       = 9
+	fxpOpendir       = 11
+	fxpReaddir       = 12
+	fxpRemove        = 13
+	fxpMkdir         = 14
+	fxpRmdir         = 15
+	fxpStat          = 17
+	fxpRename        = 18
+	fxpStatus        = 101
+	fxpHandle        = 102
+	fxpData          = 103
+	fxpName          = 104
+	fxpAttrs         = 105
+	fxpExtended      = 200
+	fxpExtendedReply = 201
+)
+
+// Open flags
+const (
+	fxfRead  = 0x01
+	fxfWrite = 0x02
+	fxfCreat = 0x08
+	fxfTrunc = 0x10
+)
+
+// Attribute flags
+const (
+	attrSize        = 0x01
+	attrUIDGID      = 0x02
+	attrPermissions = 0x04
+	attrACModTime   = 0x08
+	attrExtended    = 0x80000000
+)
+
+// Status codes
+const (
+	fxOK         = 0
+	fxEOF        = 1
+	fxNoSuchFile = 2
+)
+
+// posixRename is the OpenSSH extension replacing files atomically, which
+// the version 3 rename refuses to do
+const posixRename = "posix-rename@openssh.com"
+
+// maxChunk is the most data read or written per request, which every
+// server accepts
+const maxChunk = 32 * 1024
+
+// maxInFlight is how many writes are sent before waiting for their replies
+const maxInFlight = 16
+
+// maxPacket bounds the replies read, against corrupt streams
+const maxPacket = 256 * 1024
+
+// statusError is an error status a server replied with
+type statusError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *statusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("sftp: %s (status %d)", e.Message, e.Code)
+	}
+	return fmt.Sprintf("sftp: status %d", e.Code)
+}
+
+// Is makes missing files match os.ErrNotExist
+func (e *statusError) Is(target error) bool {
+	return target == os.ErrNotExist && e.Code == fxNoSuchFile
+}
+
+// fileInfo is what a server tells of a file
+type fileInfo struct {
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+}
+
+// session is an SFTP session, used by one goroutine at a time
+type session struct {
+	r          *bufio.Reader
+	w          io.Writer
+	closer     io.Closer
+	nextID     uint32
+	extensions map[string]string
+}
+
+// newSession starts an SFTP session over rw, the stdin and stdout of the
+// sftp subsystem
+func newSession(rw io.ReadWriteCloser) (*session, error) {
+	s := &session{r: bufio.NewReaderSize(rw, 64*1024), w: rw, closer: rw, extensions: make(map[string]string)}
+	if err := s.writePacket(fxpInit, uint32(3)); err != nil {
+		return nil, err
+	}
+	typ, data, err := s.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	if typ != fxpVersion || len(data) < 4 {
+		return nil, fmt.Errorf("sftp: unexpected reply %d to init", typ)
+	}
+	data = data[4:]
+	for len(data) > 0 {
+		var name, value string
+		if name, data, err = readString(data); err != nil {
+			break
+		}
+		if value, data, err = readString(data); err != nil {
+			break
+		}
+		s.extensions[name] = value
+	}
+	return s, nil
+}
+
+func (s *session) Close() error {
+	return s.closer.Close()
+}
+
+// writePacket sends a packet of the fields, uint32, uint64, string or
+// []byte values
+func (s *session) writePacket(typ byte, fields ...interface{}) error {
+	payload := []byte{typ}
+	for _, field := range fields {
+		switch v := field.(type) {
+		case uint32:
+			payload = binary.BigEndian.AppendUint32(payload, v)
+		case uint64:
+			payload = binary.BigEndian.AppendUint64(payload, v)
+		case string:
+			payload = binary.BigEndian.AppendUint32(payload, uint32(len(v)))
+			payload = append(payload, v...)
+		case []byte:
+			payload = binary.BigEndian.AppendUint32(payload, uint32(len(v)))
+			payload = append(payload, v...)
+		default:
+			return fmt.Errorf("sftp: can't encode %T", field)
+		}
+	}
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	_, err := s.w.Write(append(packet, payload...))
+	return err
+}
+
+// readPacket reads a packet, returning its type and the rest of it
+func (s *session) readPacket() (byte, []byte, error) {
+	var length uint32
+	if err := binary.Read(s.r, binary.BigEndian, &length); err != nil {
+		return 0, nil, err
+	}
+	if length == 0 || length > maxPacket {
+		return 0, nil, fmt.Errorf("sftp: invalid packet length %d", length)
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(s.r, packet); err != nil {
+		return 0, nil, err
+	}
+	return packet[0], packet[1:], nil
+}
+
+// send sends a request and returns its ID
+func (s *session) send(typ byte, fields ...interface{}) (uint32, error) {
+	s.nextID++
+	return s.nextID, s.writePacket(typ, append([]interface{}{s.nextID}, fields...)...)
+}
+
+// receive reads a reply, returning the ID of its request
+func (s *session) receive() (uint32, byte, []byte, error) {
+	typ, data, err := s.readPacket()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if len(data) < 4 {
+		return 0, 0, nil, fmt.Errorf("sftp: short reply")
+	}
+	return binary.BigEndian.Uint32(data), typ, data[4:], nil
+}
+
+// request sends a request and reads its reply
+func (s *session) request(typ byte, fields ...interface{}) (byte, []byte, error) {
+	id, err := s.send(typ, fields...)
+	if err != nil {
+		return 0, nil, err
+	}
+	replyID, replyType, data, err := s.receive()
+	if err != nil {
+		return 0, nil, err
+	}
+	if replyID != id {
+		return 0, nil, fmt.Errorf("sftp: reply to request %d, expected %d", replyID, id)
+	}
+	return replyType, data, nil
+}
+
+// status turns a status reply into an error, nil for OK
+func status(typ byte, data []byte) error {
+	if typ != fxpStatus {
+		return fmt.Errorf("sftp: unexpected reply %d", typ)
+	}
+	if len(data) < 4 {
+		return fmt.Errorf("sftp: short status")
+	}
+	code := binary.BigEndian.Uint32(data)
+	if code == fxOK {
+		return nil
+	}
+	message, _, _ := readString(data[4:])
+	return &statusError{Code: code, Message: message}
+}
+
+// call sends a request answered by a status
+func (s *session) call(typ byte, fields ...interface{}) error {
+	replyType, data, err := s.request(typ, fields...)
+	if err != nil {
+		return err
+	}
+	return status(replyType, data)
+}
+
+// stat returns the attributes of path, following symlinks
+func (s *session) stat(path string) (*fileInfo, error) {
+	typ, data, err := s.request(fxpStat, path)
+	if err != nil {
+		return nil, err
+	}
+	if typ != fxpAttrs {
+		return nil, status(typ, data)
+	}
+	info, _, err := readAttrs(data)
+	return info, err
+}
+
+// open opens path with the open flags, returning its handle
+func (s *session) open(path string, flags uint32) (string, error) {
+	typ, data, err := s.request(fxpOpen, path, flags, uint32(0))
+	if err != nil {
+		return "", err
+	}
+	return handle(typ, data)
+}
+
+func handle(typ byte, data []byte) (string, error) {
+	if typ != fxpHandle {
+		return "", status(typ, data)
+	}
+	h, _, err := readString(data)
+	return h, err
+}
+
+// closeHandle closes a file or directory handle
+func (s *session) closeHandle(h string) error {
+	return s.call(fxpClose, h)
+}
+
+// write writes the rest of r to the open file h from offset, with several
+// writes in flight
+func (s *session) write(h string, offset int64, r io.Reader) error {
+	buf := make([]byte, maxChunk)
+	inFlight := 0
+	var writeErr error
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, sendErr := s.send(fxpWrite, h, uint64(offset), buf[:n]); sendErr != nil {
+				return sendErr
+			}
+			offset += int64(n)
+			inFlight++
+		}
+		done := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !done {
+			writeErr = err
+		}
+
+		// Wait for replies when enough are in flight, or all at the end
+		for inFlight > 0 && (inFlight >= maxInFlight || done || writeErr != nil) {
+			_, typ, data, err := s.receive()
+			if err != nil {
+				return err
+			}
+			inFlight--
+			if err := status(typ, data); err != nil && writeErr == nil {
+				writeErr = err
+			}
+		}
+		if writeErr != nil {
+			return writeErr
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// read copies the file h to w
+func (s *session) read(h string, w io.Writer) error {
+	var offset uint64
+	for {
+		typ, data, err := s.request(fxpRead, h, offset, uint32(maxChunk))
+		if err != nil {
+			return err
+		}
+		if typ != fxpData {
+			if err := status(typ, data); err != nil {
+				var statusErr *statusError
+				if errors.As(err, &statusErr) && statusErr.Code == fxEOF {
+					return nil
+				}
+				return err
+			}
+			return fmt.Errorf("sftp: unexpected OK status to read")
+		}
+		chunk, _, err := readBytes(data)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		offset += uint64(len(chunk))
+	}
+}
+
+// setModTime sets the access and modification times of path
+func (s *session) setModTime(path string, t time.Time) error {
+	return s.call(fxpSetstat, path, uint32(attrACModTime), uint32(t.Unix()), uint32(t.Unix()))
+}
+
+// rename renames oldPath to newPath, replacing it atomically when the
+// server supports it
+func (s *session) rename(oldPath, newPath string) error {
+	if _, ok := s.extensions[posixRename]; ok {
+		return s.call(fxpExtended, posixRename, oldPath, newPath)
+	}
+	if err := s.call(fxpRemove, newPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return s.call(fxpRename, oldPath, newPath)
+}
+
+// remove removes the file at path
+func (s *session) remove(path string) error {
+	return s.call(fxpRemove, path)
+}
+
+// mkdir creates the directory at path
+func (s *session) mkdir(path string) error {
+	return s.call(fxpMkdir, path, uint32(attrPermissions), uint32(0755))
+}
+
+// rmdir removes the empty directory at path
+func (s *session) rmdir(path string) error {
+	return s.call(fxpRmdir, path)
+}
+
+// readDir lists the directory at path, without . and ..
+func (s *session) readDir(path string) ([]*fileInfo, error) {
+	typ, data, err := s.request(fxpOpendir, path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := handle(typ, data)
+	if err != nil {
+		return nil, err
+	}
+	defer s.closeHandle(h)
+
+	var entries []*fileInfo
+	for {
+		typ, data, err := s.request(fxpReaddir, h)
+		if err != nil {
+			return nil, err
+		}
+		if typ != fxpName {
+			var statusErr *statusError
+			if err := status(typ, data); errors.As(err, &statusErr) && statusErr.Code == fxEOF {
+				return entries, nil
+			} else if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("sftp: unexpected OK status to readdir")
+		}
+		if len(data) < 4 {
+			return nil, fmt.Errorf("sftp: short name reply")
+		}
+		count := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		for i := uint32(0); i < count; i++ {
+			var name string
+			if name, data, err = readString(data); err != nil {
+				return nil, err
+			}
+			if _, data, err = readString(data); err != nil { // ls -l style long name
+				return nil, err
+			}
+			var info *fileInfo
+			if info, data, err = readAttrs(data); err != nil {
+				return nil, err
+			}
+			if name == "." || name == ".." {
+				continue
+			}
+			info.Name = name
+			entries = append(entries, info)
+		}
+	}
+}
+
+// readAttrs decodes file attributes
+func readAttrs(data []byte) (*fileInfo, []byte, error) {
+	var flags uint32
+	var err error
+	if flags, data, err = readUint32(data); err != nil {
+		return nil, nil, err
+	}
+	info := &fileInfo{}
+	if flags&attrSize != 0 {
+		if len(data) < 8 {
+			return nil, nil, fmt.Errorf("sftp: short attributes")
+		}
+		info.Size = int64(binary.BigEndian.Uint64(data))
+		data = data[8:]
+	}
+	if flags&attrUIDGID != 0 {
+		if len(data) < 8 {
+			return nil, nil, fmt.Errorf("sftp: short attributes")
+		}
+		data = data[8:]
+	}
+	if flags&attrPermissions != 0 {
+		var perm uint32
+		if perm, data, err = readUint32(data); err != nil {
+			return nil, nil, err
+		}
+		info.Mode = fileMode(perm)
+	}
+	if flags&attrACModTime != 0 {
+		if len(data) < 8 {
+			return nil, nil, fmt.Errorf("sftp: short attributes")
+		}
+		info.ModTime = time.Unix(int64(binary.BigEndian.Uint32(data[4:])), 0)
+		data = data[8:]
+	}
+	if flags&attrExtended != 0 {
+		var count uint32
+		if count, data, err = readUint32(data); err != nil {
+			return nil, nil, err
+		}
+		for i := uint32(0); i < 2*count; i++ {
+			if _, data, err = readString(data); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return info, data, nil
+}
+
+// fileMode converts POSIX permissions, with the file type bits
+func fileMode(perm uint32) os.FileMode {
+	mode := os.FileMode(perm & 0777)
+	switch perm & 0170000 {
+	case 0040000:
+		mode |= os.ModeDir
+	case 0120000:
+		mode |= os.ModeSymlink
+	case 0100000:
+	default:
+		mode |= os.ModeIrregular
+	}
+	return mode
+}
+
+func readUint32(data []byte) (uint32, []byte, error) {
+	if len(data) < 4 {
+		return 0, nil, fmt.Errorf("sftp: short packet")
+	}
+	return binary.BigEndian.Uint32(data), data[4:], nil
+}
+
+func readBytes(data []byte) ([]byte, []byte, error) {
+	n, data, err := readUint32(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if uint32(len(data)) < n {
+		return nil, nil, fmt.Errorf("sftp: short packet")
+	}
+	return data[:n], data[n:], nil
+}
+
+func readString(data []byte) (string, []byte, error) {
+	b, rest, err := readBytes(data)
+	return string(b), rest, err
+}
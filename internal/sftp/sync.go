@@ -0,0 +1,404 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ralt/repogen/internal/staging"
+)
+
+// partSuffix marks files being uploaded, renamed into place once complete
+const partSuffix = ".repogen-part"
+
+// remoteTree lists the files and directories under the target directory
+type remoteTree struct {
+	files map[string]*fileInfo // By slash-separated path relative to the target
+	dirs  map[string]bool
+}
+
+// list walks the target directory, empty when it doesn't exist yet
+func (c *Client) list(ctx context.Context) (*remoteTree, error) {
+	tree := &remoteTree{files: make(map[string]*fileInfo), dirs: make(map[string]bool)}
+	s := <-c.sessions
+	defer func() { c.sessions <- s }()
+
+	pending := []string{"."}
+	for len(pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		dir := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		entries, err := s.readDir(c.remotePath(dir))
+		if errors.Is(err, os.ErrNotExist) && dir == "." {
+			return tree, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", c.remotePath(dir), err)
+		}
+		tree.dirs[dir] = true
+		for _, e := range entries {
+			rel := path.Join(dir, e.Name)
+			switch {
+			case e.Mode.IsDir():
+				pending = append(pending, rel)
+			case e.Mode&os.ModeSymlink != 0:
+				// Follow symlinks, like local files
+				target, err := s.stat(c.remotePath(rel))
+				if err == nil && target.Mode.IsRegular() {
+					tree.files[rel] = target
+				}
+			case e.Mode.IsRegular():
+				tree.files[rel] = e
+			}
+		}
+	}
+	return tree, nil
+}
+
+func (c *Client) remotePath(rel string) string {
+	return path.Join(c.target.Path, rel)
+}
+
+// unchanged reports whether the remote file has the size and modification
+// time of the local one, which transfers set
+func unchanged(local os.FileInfo, remote *fileInfo) bool {
+	return remote != nil && remote.Size == local.Size() && remote.ModTime.Unix() == local.ModTime().Unix()
+}
+
+// Upload uploads the files of localDir that changed to the target
+// directory, stage by stage, then removes the remote files localDir no
+// longer has. Files are written next to their destination and renamed into
+// place once complete, and uploads cut short resume where they stopped
+func (c *Client) Upload(ctx context.Context, localDir string, stages [][]string) (*staging.Result, error) {
+	tree, err := c.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &staging.Result{}
+	var dirs sync.Map
+	for dir := range tree.dirs {
+		dirs.Store(dir, true)
+	}
+
+	local := make(map[string]bool)
+	for _, stage := range stages {
+		var changed []string
+		for _, rel := range stage {
+			local[rel] = true
+			info, err := os.Stat(filepath.Join(localDir, filepath.FromSlash(rel)))
+			if err != nil {
+				return result, err
+			}
+			if unchanged(info, tree.files[rel]) {
+				result.Unchanged++
+				continue
+			}
+			changed = append(changed, rel)
+		}
+
+		var bytes atomic.Int64
+		err := c.parallel(ctx, changed, func(s *session, rel string) error {
+			n, err := c.upload(s, &dirs, filepath.Join(localDir, filepath.FromSlash(rel)), rel)
+			bytes.Add(n)
+			return err
+		})
+		result.Transferred += len(changed)
+		result.Bytes += bytes.Load()
+		if err != nil {
+			return result, err
+		}
+	}
+
+	// Files of removed packages go last, once no metadata references them
+	var stale []string
+	for rel := range tree.files {
+		if !local[rel] {
+			stale = append(stale, rel)
+		}
+	}
+	sort.Strings(stale)
+	err = c.parallel(ctx, stale, func(s *session, rel string) error {
+		if err := s.remove(c.remotePath(rel)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", c.remotePath(rel), err)
+		}
+		return nil
+	})
+	result.Removed = len(stale)
+	if err != nil {
+		return result, err
+	}
+	c.removeEmptyDirs(tree, local)
+	return result, nil
+}
+
+// upload uploads the file at localPath to rel, resuming an interrupted
+// upload of the same version of the file, and returns the bytes sent
+func (c *Client) upload(s *session, dirs *sync.Map, localPath, rel string) (int64, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	remote := c.remotePath(rel)
+	if err := c.mkdirAll(s, dirs, path.Dir(rel)); err != nil {
+		return 0, err
+	}
+
+	// Parts are named after the version of the file they hold, so a
+	// resumed upload never mixes two versions
+	part := path.Join(path.Dir(remote), fmt.Sprintf(".%s.%d-%d%s", path.Base(remote), info.Size(), info.ModTime().Unix(), partSuffix))
+	var offset int64
+	flags := uint32(fxfWrite | fxfCreat | fxfTrunc)
+	if partial, err := s.stat(part); err == nil && partial.Size <= info.Size() {
+		offset = partial.Size
+		flags = fxfWrite
+	}
+	if _, err := f.Seek(offset, 0); err != nil {
+		return 0, err
+	}
+
+	h, err := s.open(part, flags)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", part, err)
+	}
+	if err := s.write(h, offset, f); err != nil {
+		s.closeHandle(h)
+		return 0, fmt.Errorf("failed to upload %s: %w", remote, err)
+	}
+	if err := s.closeHandle(h); err != nil {
+		return 0, fmt.Errorf("failed to upload %s: %w", remote, err)
+	}
+	if c.verify {
+		if err := verifyPart(s, part, f, info.Size()); err != nil {
+			// The next run starts the upload over
+			s.remove(part)
+			return 0, fmt.Errorf("failed to verify %s: %w", remote, err)
+		}
+	}
+	if err := s.setModTime(part, info.ModTime()); err != nil {
+		return 0, fmt.Errorf("failed to set the time of %s: %w", remote, err)
+	}
+	if err := s.rename(part, remote); err != nil {
+		return 0, fmt.Errorf("failed to rename %s into place: %w", remote, err)
+	}
+	return info.Size() - offset, nil
+}
+
+// verifyPart checks that the uploaded part holds the size bytes of f, reading
+// it back
+func verifyPart(s *session, part string, f *os.File, size int64) error {
+	written, err := s.stat(part)
+	if err != nil {
+		return err
+	}
+	if written.Size != size {
+		return fmt.Errorf("%d bytes written, expected %d", written.Size, size)
+	}
+
+	h, err := s.open(part, fxfRead)
+	if err != nil {
+		return err
+	}
+	defer s.closeHandle(h)
+	remoteSum := sha256.New()
+	if err := s.read(h, remoteSum); err != nil {
+		return err
+	}
+	localSum := sha256.New()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(localSum, f); err != nil {
+		return err
+	}
+	if !bytes.Equal(remoteSum.Sum(nil), localSum.Sum(nil)) {
+		return fmt.Errorf("written content differs from %s", f.Name())
+	}
+	return nil
+}
+
+// mkdirAll creates the directory rel and its parents, those already
+// created being recorded in dirs
+func (c *Client) mkdirAll(s *session, dirs *sync.Map, rel string) error {
+	if _, ok := dirs.Load(rel); ok || rel == "." {
+		return nil
+	}
+	if err := c.mkdirAll(s, dirs, path.Dir(rel)); err != nil {
+		return err
+	}
+	if err := s.mkdir(c.remotePath(rel)); err != nil {
+		// Another session may have created it
+		if info, statErr := s.stat(c.remotePath(rel)); statErr != nil || !info.Mode.IsDir() {
+			return fmt.Errorf("failed to create %s: %w", c.remotePath(rel), err)
+		}
+	}
+	dirs.Store(rel, true)
+	return nil
+}
+
+// removeEmptyDirs removes the remote directories left without files,
+// deepest first. Failures are ignored, as they only leave empty directories
+func (c *Client) removeEmptyDirs(tree *remoteTree, local map[string]bool) {
+	used := make(map[string]bool)
+	for rel := range local {
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			used[dir] = true
+		}
+	}
+	var empty []string
+	for dir := range tree.dirs {
+		if dir != "." && !used[dir] {
+			empty = append(empty, dir)
+		}
+	}
+	sort.Slice(empty, func(i, j int) bool { return len(empty[i]) > len(empty[j]) })
+
+	s := <-c.sessions
+	defer func() { c.sessions <- s }()
+	for _, dir := range empty {
+		s.rmdir(c.remotePath(dir))
+	}
+}
+
+// Download makes localDir a copy of the target directory: it downloads
+// the remote files that differ from the local ones, and removes the local
+// files the target doesn't have. The lock and parse cache are kept
+func (c *Client) Download(ctx context.Context, localDir string) (*staging.Result, error) {
+	tree, err := c.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &staging.Result{}
+
+	var changed []string
+	for rel, remote := range tree.files {
+		if strings.HasSuffix(rel, partSuffix) {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(localDir, filepath.FromSlash(rel))); err == nil && unchanged(info, remote) {
+			result.Unchanged++
+			continue
+		}
+		changed = append(changed, rel)
+	}
+	sort.Strings(changed)
+
+	var bytes atomic.Int64
+	err = c.parallel(ctx, changed, func(s *session, rel string) error {
+		n, err := c.download(s, rel, filepath.Join(localDir, filepath.FromSlash(rel)), tree.files[rel])
+		bytes.Add(n)
+		return err
+	})
+	result.Transferred, result.Bytes = len(changed), bytes.Load()
+	if err != nil {
+		return result, err
+	}
+
+	local, err := staging.Files(localDir)
+	if err != nil {
+		return result, err
+	}
+	for _, rel := range local {
+		if _, ok := tree.files[rel]; !ok {
+			if err := os.Remove(filepath.Join(localDir, filepath.FromSlash(rel))); err != nil {
+				return result, err
+			}
+			result.Removed++
+		}
+	}
+	return result, nil
+}
+
+// download downloads rel to localPath, with the modification time of the
+// remote file, and returns its size
+func (c *Client) download(s *session, rel, localPath string, remote *fileInfo) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return 0, err
+	}
+	h, err := s.open(c.remotePath(rel), fxfRead)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", c.remotePath(rel), err)
+	}
+	defer s.closeHandle(h)
+
+	tmp := localPath + partSuffix
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	err = s.read(h, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(tmp, remote.ModTime, remote.ModTime)
+	}
+	if err == nil {
+		err = os.Rename(tmp, localPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("failed to download %s: %w", c.remotePath(rel), err)
+	}
+	return remote.Size, nil
+}
+
+// parallel runs fn on every item, each with a session of the pool, and
+// returns the first error
+func (c *Client) parallel(ctx context.Context, items []string, fn func(s *session, item string) error) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	for _, item := range items {
+		var s *session
+		select {
+		case s = <-c.sessions:
+		case <-ctx.Done():
+		}
+		mu.Lock()
+		stop := firstErr != nil || s == nil
+		mu.Unlock()
+		if stop {
+			if s != nil {
+				c.sessions <- s
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func(s *session, item string) {
+			defer wg.Done()
+			err := fn(s, item)
+			c.sessions <- s
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(s, item)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
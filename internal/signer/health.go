@@ -0,0 +1,147 @@
+package signer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// minRSABits is the shortest RSA key apt (2.7.13 and later) accepts
+const minRSABits = 2048
+
+// CheckKey inspects the key of s the way clients will, returning what makes
+// them refuse its signatures, now or within warnWithin: an expired or
+// revoked key, no key able to sign, short RSA or DSA keys, and SHA-1
+// digests or self-signatures
+func CheckKey(s Signer, now time.Time, warnWithin time.Duration) ([]string, error) {
+	keyring, err := Keyring(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, entity := range keyring {
+		name := fmt.Sprintf("key %s", entity.PrimaryKey.KeyIdString())
+		ident := entity.PrimaryIdentity()
+		if ident == nil || ident.SelfSignature == nil {
+			problems = append(problems, name+" has no self-signed user ID")
+			continue
+		}
+		found := len(problems)
+		if entity.Revoked(now) {
+			problems = append(problems, name+" is revoked")
+		}
+		problems = append(problems, expiryProblems(name, entity.PrimaryKey, ident.SelfSignature, now, warnWithin)...)
+
+		key, ok := entity.SigningKey(now)
+		if !ok {
+			// Expired and revoked keys can't sign either
+			if len(problems) == found {
+				problems = append(problems, name+" has no signing-capable key or subkey")
+			}
+			continue
+		}
+		if key.PublicKey != entity.PrimaryKey {
+			name = fmt.Sprintf("signing subkey %s", key.PublicKey.KeyIdString())
+			problems = append(problems, expiryProblems(name, key.PublicKey, key.SelfSignature, now, warnWithin)...)
+		}
+
+		bits, _ := key.PublicKey.BitLength()
+		switch key.PublicKey.PubKeyAlgo {
+		case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSASignOnly:
+			if bits < minRSABits {
+				problems = append(problems, fmt.Sprintf("%s is a %d-bit RSA key, which apt refuses since Debian 13 and Ubuntu 24.04", name, bits))
+			}
+		case packet.PubKeyAlgoDSA:
+			problems = append(problems, fmt.Sprintf("%s is a DSA key, which apt refuses since Debian 13 and Ubuntu 24.04", name))
+		}
+		if weakHash(key.SelfSignature.Hash) {
+			problems = append(problems, fmt.Sprintf("%s is bound by a %s self-signature, which Debian 13 refuses", name, key.SelfSignature.Hash))
+		}
+	}
+
+	// The digest is chosen when signing, e.g. from gpg.conf with gpg-agent
+	hash, err := probeHash(s)
+	if err != nil {
+		return nil, err
+	}
+	if weakHash(hash) {
+		problems = append(problems, fmt.Sprintf("signatures use %s digests, which apt refuses", hash))
+	}
+	return problems, nil
+}
+
+// CheckRSAKey inspects the key of s, returning why clients would refuse
+// its signatures
+func CheckRSAKey(s RSASigner) ([]string, error) {
+	publicKeyPEM, err := s.GetPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key: %w", err)
+	}
+	block, _ := pem.Decode(publicKeyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("public key is not PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not an RSA key")
+	}
+
+	if bits := publicKey.N.BitLen(); bits < minRSABits {
+		return []string{fmt.Sprintf("RSA key is %d bits long, use at least %d", bits, minRSABits)}, nil
+	}
+	return nil, nil
+}
+
+// expiryProblems reports key, bound by sig, expired or expiring within warnWithin
+func expiryProblems(name string, key *packet.PublicKey, sig *packet.Signature, now time.Time, warnWithin time.Duration) []string {
+	if sig.KeyLifetimeSecs == nil || *sig.KeyLifetimeSecs == 0 {
+		return nil
+	}
+	expiry := key.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+	switch {
+	case !now.Before(expiry):
+		return []string{fmt.Sprintf("%s expired on %s", name, expiry.UTC().Format("2006-01-02"))}
+	case expiry.Sub(now) < warnWithin:
+		days := int(expiry.Sub(now).Hours() / 24)
+		return []string{fmt.Sprintf("%s expires on %s, in %d days", name, expiry.UTC().Format("2006-01-02"), days)}
+	}
+	return nil
+}
+
+// probeHash signs a probe with s and returns the digest algorithm it used
+func probeHash(s Signer) (crypto.Hash, error) {
+	signature, err := s.SignDetached([]byte("repogen key check\n"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to sign key check probe: %w", err)
+	}
+	block, err := armor.Decode(bytes.NewReader(signature))
+	if err != nil || block.Type != openpgp.SignatureType {
+		return 0, fmt.Errorf("key check probe is not an armored signature")
+	}
+	p, err := packet.Read(block.Body)
+	if err != nil {
+		return 0, fmt.Errorf("invalid key check probe signature: %w", err)
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok {
+		return 0, fmt.Errorf("invalid key check probe signature")
+	}
+	return sig.Hash, nil
+}
+
+func weakHash(h crypto.Hash) bool {
+	return h == crypto.MD5 || h == crypto.SHA1 || h == crypto.RIPEMD160
+}
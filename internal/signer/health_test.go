@@ -0,0 +1,74 @@
+package signer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// newTestGPGSigner signs with a key generated as described by cfg
+func newTestGPGSigner(t *testing.T, cfg *packet.Config) *GPGSigner {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Repogen Test", "", "test@repogen.local", cfg)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	var key bytes.Buffer
+	w, _ := armor.Encode(&key, openpgp.PrivateKeyType, nil)
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatalf("Failed to serialize key: %v", err)
+	}
+	w.Close()
+
+	keyPath := filepath.Join(t.TempDir(), "key.asc")
+	os.WriteFile(keyPath, key.Bytes(), 0600)
+	s, err := NewGPGSigner(keyPath, "")
+	if err != nil {
+		t.Fatalf("NewGPGSigner failed: %v", err)
+	}
+	return s
+}
+
+func TestCheckKey(t *testing.T) {
+	healthy := newTestGPGSigner(t, &packet.Config{RSABits: 2048})
+	expiring := newTestGPGSigner(t, &packet.Config{RSABits: 2048, KeyLifetimeSecs: 10 * 24 * 3600})
+	weak := newTestGPGSigner(t, &packet.Config{RSABits: 1024})
+	now := time.Now()
+
+	if problems, err := CheckKey(healthy, now, 30*24*time.Hour); err != nil || len(problems) != 0 {
+		t.Errorf("CheckKey(healthy) = %v, %v", problems, err)
+	}
+
+	problems, err := CheckKey(expiring, now, 30*24*time.Hour)
+	if err != nil || len(problems) == 0 || !strings.Contains(problems[0], "expires on") {
+		t.Errorf("CheckKey(expiring) = %v, %v", problems, err)
+	}
+	if problems, _ := CheckKey(expiring, now, 24*time.Hour); len(problems) != 0 {
+		t.Errorf("CheckKey(expiring) outside the warning window = %v", problems)
+	}
+	problems, _ = CheckKey(expiring, now.Add(20*24*time.Hour), 0)
+	if len(problems) != 1 || !strings.Contains(problems[0], "expired on") {
+		t.Errorf("CheckKey(expired) = %v", problems)
+	}
+
+	problems, _ = CheckKey(weak, now, 0)
+	if len(problems) != 1 || !strings.Contains(problems[0], "1024-bit RSA") {
+		t.Errorf("CheckKey(weak) = %v", problems)
+	}
+}
+
+func TestCheckRSAKey(t *testing.T) {
+	if problems, err := CheckRSAKey(newTestRSASigner(t, 2048)); err != nil || len(problems) != 0 {
+		t.Errorf("CheckRSAKey(2048 bits) = %v, %v", problems, err)
+	}
+	if problems, err := CheckRSAKey(newTestRSASigner(t, 1024)); err != nil || len(problems) != 1 {
+		t.Errorf("CheckRSAKey(1024 bits) = %v, %v", problems, err)
+	}
+}
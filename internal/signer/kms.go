@@ -0,0 +1,152 @@
+package signer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// KMS algorithms signing digests of each hash, with RSA and ECDSA keys
+var (
+	kmsRSAAlgorithms = map[crypto.Hash]string{
+		crypto.SHA256: "RSASSA_PKCS1_V1_5_SHA_256",
+		crypto.SHA384: "RSASSA_PKCS1_V1_5_SHA_384",
+		crypto.SHA512: "RSASSA_PKCS1_V1_5_SHA_512",
+	}
+	kmsECDSAAlgorithms = map[crypto.Hash]string{
+		crypto.SHA256: "ECDSA_SHA_256",
+		crypto.SHA384: "ECDSA_SHA_384",
+		crypto.SHA512: "ECDSA_SHA_512",
+	}
+)
+
+// KMSSigner implements Signer with an asymmetric RSA or ECDSA key held by AWS KMS.
+// Digests are signed through the aws CLI, so every credential source it
+// supports (environment, profiles, SSO, instance and task roles) works
+type KMSSigner struct {
+	*remoteSigner
+}
+
+// NewKMSSigner creates a signer for the KMS key keyID (key ARN, ID, alias
+// name or alias ARN). userID names the key in the exported public key
+func NewKMSSigner(keyID, userID string) (*KMSSigner, error) {
+	if keyID == "" {
+		return nil, fmt.Errorf("key ID is empty")
+	}
+
+	key := &kmsKey{keyID: keyID, region: kmsRegion(keyID)}
+	if err := key.readPublicKey(); err != nil {
+		return nil, err
+	}
+
+	remote, err := newRemoteSigner(key, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to certify KMS key: %w", err)
+	}
+
+	return &KMSSigner{remote}, nil
+}
+
+// kmsRegion returns the region named by a key or alias ARN. It must win
+// over the configured default region, which may not hold the key
+func kmsRegion(keyID string) string {
+	parts := strings.SplitN(keyID, ":", 6)
+	if len(parts) == 6 && parts[0] == "arn" && parts[2] == "kms" {
+		return parts[3]
+	}
+	return ""
+}
+
+// kmsKey is a crypto.Signer performing RSA PKCS#1 v1.5 or ECDSA signatures
+// in KMS, ECDSA signatures being ASN.1 encoded as crypto/ecdsa does
+type kmsKey struct {
+	keyID      string
+	region     string
+	public     crypto.PublicKey // *rsa.PublicKey or *ecdsa.PublicKey
+	algorithms map[crypto.Hash]string
+}
+
+// Public returns the KMS key's public key
+func (k *kmsKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+// Sign has KMS sign digest without sending it the signed data
+func (k *kmsKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	algorithm, ok := k.algorithms[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash %v", opts.HashFunc())
+	}
+
+	tmpDir, err := os.MkdirTemp("", "repogen-kms-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	digestFile := filepath.Join(tmpDir, "digest.bin")
+	if err := os.WriteFile(digestFile, digest, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write digest file: %w", err)
+	}
+
+	return k.aws("sign", "--message", "fileb://"+digestFile, "--message-type", "DIGEST",
+		"--signing-algorithm", algorithm, "--query", "Signature")
+}
+
+// readPublicKey downloads the public half of the key, which selects the
+// signing algorithms
+func (k *kmsKey) readPublicKey() error {
+	der, err := k.aws("get-public-key", "--query", "PublicKey")
+	if err != nil {
+		return fmt.Errorf("failed to get public key of %s: %w", k.keyID, err)
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return fmt.Errorf("failed to parse public key of %s: %w", k.keyID, err)
+	}
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		k.algorithms = kmsRSAAlgorithms
+	case *ecdsa.PublicKey:
+		k.algorithms = kmsECDSAAlgorithms
+	default:
+		return fmt.Errorf("only RSA and ECDSA keys are supported, %s is %T", k.keyID, pub)
+	}
+
+	k.public = pub
+	return nil
+}
+
+// aws runs an aws kms subcommand on the key and decodes the base64 blob
+// selected by its --query
+func (k *kmsKey) aws(subcommand string, args ...string) ([]byte, error) {
+	base := []string{"kms", subcommand, "--key-id", k.keyID, "--output", "text"}
+	if k.region != "" {
+		base = append(base, "--region", k.region)
+	}
+
+	cmd := exec.Command("aws", append(base, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("aws kms %s failed: %w\nOutput: %s", subcommand, err, stderr.String())
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		return nil, fmt.Errorf("unexpected aws kms %s output: %w", subcommand, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,18 @@
+package signer
+
+import "testing"
+
+func TestKMSRegion(t *testing.T) {
+	tests := map[string]string{
+		"arn:aws:kms:eu-west-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab": "eu-west-1",
+		"arn:aws-cn:kms:cn-north-1:111122223333:alias/release":                        "cn-north-1",
+		"1234abcd-12ab-34cd-56ef-1234567890ab":                                        "",
+		"alias/release":                                                               "",
+	}
+
+	for keyID, want := range tests {
+		if got := kmsRegion(keyID); got != want {
+			t.Errorf("kmsRegion(%q) = %q, want %q", keyID, got, want)
+		}
+	}
+}
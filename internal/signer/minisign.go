@@ -0,0 +1,266 @@
+package signer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Suffixes of minisign and signify signature and public key files
+const (
+	MinisignExt       = ".minisig"
+	MinisignPublicExt = ".pub"
+)
+
+// Minisign and signify (usign) key and signature algorithms
+var (
+	edAlgorithm       = []byte("Ed") // Ed25519 over the data itself
+	prehashAlgorithm  = []byte("ED") // minisign: Ed25519 over the BLAKE2b-512 hash of the data
+	signifyKDF        = []byte("BK") // signify: bcrypt_pbkdf
+	minisignKDF       = []byte("Sc") // minisign: scrypt
+	minisignChecksum  = []byte("B2") // minisign: BLAKE2b-256
+	untrustedPrefix   = "untrusted comment: "
+	trustedPrefix     = "trusted comment: "
+	minisignKeyLength = 158
+	signifyKeyLength  = 104
+)
+
+// MinisignSigner signs files with an Ed25519 minisign or signify (usign)
+// secret key, into signatures the tool of the key verifies
+type MinisignSigner struct {
+	privateKey ed25519.PrivateKey
+	keyID      []byte // The key number signatures name their key with
+	signify    bool   // signify keys make signify signatures, minisign keys minisign ones
+}
+
+// NewMinisignSigner creates a signer from a minisign or signify secret key
+// file. Encrypted minisign keys are decrypted with passphrase; signify
+// keys must be unencrypted, as usign makes them
+func NewMinisignSigner(keyPath, passphrase string) (*MinisignSigner, error) {
+	if keyPath == "" {
+		return nil, fmt.Errorf("key path is empty")
+	}
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	key, err := decodeMinisignFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(key) == minisignKeyLength && bytes.Equal(key[:2], edAlgorithm):
+		return parseMinisignKey(key, passphrase)
+	case len(key) == signifyKeyLength && bytes.Equal(key[:2], edAlgorithm) && bytes.Equal(key[2:4], signifyKDF):
+		return parseSignifyKey(key)
+	}
+	return nil, fmt.Errorf("not a minisign or signify Ed25519 secret key")
+}
+
+// decodeMinisignFile returns the base64 payload following the untrusted
+// comment of a key or signature file
+func decodeMinisignFile(data []byte) ([]byte, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, untrustedPrefix) {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key: %w", err)
+		}
+		return decoded, nil
+	}
+	return nil, fmt.Errorf("no key found")
+}
+
+// parseMinisignKey reads a minisign secret key: algorithms, scrypt salt
+// and limits, then the key number, key and checksum, encrypted by XOR
+// with the scrypt output unless the key has no password
+func parseMinisignKey(key []byte, passphrase string) (*MinisignSigner, error) {
+	kdf, salt := key[2:4], key[6:38]
+	opsLimit := binary.LittleEndian.Uint64(key[38:46])
+	memLimit := binary.LittleEndian.Uint64(key[46:54])
+	secret := append([]byte(nil), key[54:]...)
+
+	if !bytes.Equal(key[4:6], minisignChecksum) {
+		return nil, fmt.Errorf("unsupported minisign checksum algorithm %q", key[4:6])
+	}
+	switch {
+	case bytes.Equal(kdf, minisignKDF):
+		if passphrase == "" {
+			return nil, fmt.Errorf("key is encrypted but no passphrase provided")
+		}
+		logN, r, p := scryptParams(opsLimit, memLimit)
+		stream, err := scrypt.Key([]byte(passphrase), salt, 1<<logN, r, p, len(secret))
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key: %w", err)
+		}
+		subtle.XORBytes(secret, secret, stream)
+	case bytes.Equal(kdf, []byte{0, 0}):
+	default:
+		return nil, fmt.Errorf("unsupported minisign key derivation %q", kdf)
+	}
+
+	keyID, priv, checksum := secret[:8], secret[8:72], secret[72:104]
+	h, _ := blake2b.New256(nil)
+	h.Write(key[:2])
+	h.Write(keyID)
+	h.Write(priv)
+	if subtle.ConstantTimeCompare(h.Sum(nil), checksum) != 1 {
+		return nil, fmt.Errorf("wrong passphrase or corrupted key")
+	}
+	return &MinisignSigner{privateKey: ed25519.PrivateKey(priv), keyID: keyID}, nil
+}
+
+// parseSignifyKey reads a signify secret key: algorithms, bcrypt_pbkdf
+// rounds and salt, then the key checksum, key number and key
+func parseSignifyKey(key []byte) (*MinisignSigner, error) {
+	if rounds := binary.BigEndian.Uint32(key[4:8]); rounds != 0 {
+		return nil, fmt.Errorf("encrypted signify keys aren't supported, remove the passphrase with signify or use a minisign key")
+	}
+	checksum, keyID, priv := key[24:32], key[32:40], key[40:104]
+	sum := sha512.Sum512(priv)
+	if subtle.ConstantTimeCompare(sum[:8], checksum) != 1 {
+		return nil, fmt.Errorf("corrupted signify key")
+	}
+	return &MinisignSigner{privateKey: ed25519.PrivateKey(priv), keyID: keyID, signify: true}, nil
+}
+
+// scryptParams picks the scrypt parameters of the minisign limits, as
+// libsodium's crypto_pwhash_scryptsalsa208sha256 does
+func scryptParams(opsLimit, memLimit uint64) (logN uint, r, p int) {
+	if opsLimit < 32768 {
+		opsLimit = 32768
+	}
+	r = 8
+	var maxN uint64
+	if opsLimit < memLimit/32 {
+		maxN = opsLimit / uint64(r*4)
+	} else {
+		maxN = memLimit / uint64(r*128)
+	}
+	for logN = 1; logN < 63; logN++ {
+		if uint64(1)<<logN > maxN/2 {
+			break
+		}
+	}
+	p = 1
+	if opsLimit >= memLimit/32 {
+		maxRP := (opsLimit / 4) / (uint64(1) << logN)
+		if maxRP > 0x3fffffff {
+			maxRP = 0x3fffffff
+		}
+		p = int(maxRP) / r
+	}
+	return logN, r, p
+}
+
+// KeyID returns the key number signatures name the key with, as the tool
+// of the key prints it: usign fingerprints in lowercase hex, minisign key
+// IDs as uppercase little-endian numbers
+func (s *MinisignSigner) KeyID() string {
+	if s.signify {
+		return hex.EncodeToString(s.keyID)
+	}
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(s.keyID))
+}
+
+// Signify reports whether the key is a signify key, making signatures
+// usign and signify verify
+func (s *MinisignSigner) Signify() bool {
+	return s.signify
+}
+
+// PublicKey returns the public key file clients verify signatures with
+func (s *MinisignSigner) PublicKey() []byte {
+	payload := append(append(append([]byte(nil), edAlgorithm...), s.keyID...), s.privateKey.Public().(ed25519.PublicKey)...)
+	comment := "public key " + s.KeyID()
+	if !s.signify {
+		comment = "minisign " + comment
+	}
+	return []byte(fmt.Sprintf("%s%s\n%s\n", untrustedPrefix, comment, base64.StdEncoding.EncodeToString(payload)))
+}
+
+// Sign returns the signature file of data. Minisign signatures sign the
+// BLAKE2b-512 hash of data and carry trustedComment, itself signed;
+// signify signatures sign data and have no trusted comment
+func (s *MinisignSigner) Sign(data []byte, trustedComment string) []byte {
+	if s.signify {
+		sig := ed25519.Sign(s.privateKey, data)
+		payload := append(append(append([]byte(nil), edAlgorithm...), s.keyID...), sig...)
+		return []byte(fmt.Sprintf("%ssigned by key %s\n%s\n", untrustedPrefix, s.KeyID(), base64.StdEncoding.EncodeToString(payload)))
+	}
+
+	hash := blake2b.Sum512(data)
+	sig := ed25519.Sign(s.privateKey, hash[:])
+	global := ed25519.Sign(s.privateKey, append(append([]byte(nil), sig...), trustedComment...))
+	payload := append(append(append([]byte(nil), prehashAlgorithm...), s.keyID...), sig...)
+	return []byte(fmt.Sprintf("%ssignature from minisign secret key %s\n%s\n%s%s\n%s\n",
+		untrustedPrefix, s.KeyID(), base64.StdEncoding.EncodeToString(payload),
+		trustedPrefix, trustedComment, base64.StdEncoding.EncodeToString(global)))
+}
+
+// VerifyMinisign checks a minisign or signify signature file of data
+// against a public key file
+func VerifyMinisign(publicKey, signature, data []byte) error {
+	pk, err := decodeMinisignFile(publicKey)
+	if err != nil {
+		return err
+	}
+	if len(pk) != 42 || !bytes.Equal(pk[:2], edAlgorithm) {
+		return fmt.Errorf("not an Ed25519 public key")
+	}
+	keyID, pub := pk[2:10], ed25519.PublicKey(pk[10:])
+
+	lines := strings.Split(strings.TrimRight(string(signature), "\n"), "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], untrustedPrefix) {
+		return fmt.Errorf("malformed signature")
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 74 {
+		return fmt.Errorf("malformed signature")
+	}
+	if !bytes.Equal(sig[2:10], keyID) {
+		return fmt.Errorf("signature made with key %X, not %X", sig[2:10], keyID)
+	}
+
+	message := data
+	switch {
+	case bytes.Equal(sig[:2], prehashAlgorithm):
+		hash := blake2b.Sum512(data)
+		message = hash[:]
+	case !bytes.Equal(sig[:2], edAlgorithm):
+		return fmt.Errorf("unsupported signature algorithm %q", sig[:2])
+	}
+	if !ed25519.Verify(pub, message, sig[10:]) {
+		return fmt.Errorf("signature doesn't match the data")
+	}
+
+	// minisign signatures also sign their trusted comment
+	if len(lines) < 4 {
+		return nil
+	}
+	comment, ok := strings.CutPrefix(lines[2], trustedPrefix)
+	if !ok {
+		return fmt.Errorf("malformed trusted comment")
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || !ed25519.Verify(pub, append(append([]byte(nil), sig[10:]...), comment...), global) {
+		return fmt.Errorf("trusted comment signature doesn't match")
+	}
+	return nil
+}
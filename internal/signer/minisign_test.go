@@ -0,0 +1,142 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// writeSignifyKey writes an unencrypted signify secret key, as usign makes
+func writeSignifyKey(t *testing.T, priv ed25519.PrivateKey, keyID []byte) string {
+	t.Helper()
+	checksum := sha512.Sum512(priv)
+	var key bytes.Buffer
+	key.WriteString("EdBK")
+	key.Write(make([]byte, 4+16))
+	key.Write(checksum[:8])
+	key.Write(keyID)
+	key.Write(priv)
+	path := filepath.Join(t.TempDir(), "test.sec")
+	os.WriteFile(path, []byte("untrusted comment: private key\n"+base64.StdEncoding.EncodeToString(key.Bytes())+"\n"), 0600)
+	return path
+}
+
+// writeMinisignKey writes a minisign secret key, encrypted with cheap
+// scrypt limits unless passphrase is empty
+func writeMinisignKey(t *testing.T, priv ed25519.PrivateKey, keyID []byte, passphrase string) string {
+	t.Helper()
+	h, _ := blake2b.New256(nil)
+	h.Write([]byte("Ed"))
+	h.Write(keyID)
+	h.Write(priv)
+	secret := append(append(append([]byte(nil), keyID...), priv...), h.Sum(nil)...)
+
+	salt := make([]byte, 32)
+	rand.Read(salt)
+	opsLimit, memLimit := uint64(32768), uint64(1<<30)
+	kdf := []byte{0, 0}
+	if passphrase != "" {
+		kdf = []byte("Sc")
+		logN, r, p := scryptParams(opsLimit, memLimit)
+		stream, err := scrypt.Key([]byte(passphrase), salt, 1<<logN, r, p, len(secret))
+		if err != nil {
+			t.Fatalf("scrypt failed: %v", err)
+		}
+		subtle.XORBytes(secret, secret, stream)
+	}
+
+	var key bytes.Buffer
+	key.WriteString("Ed")
+	key.Write(kdf)
+	key.WriteString("B2")
+	key.Write(salt)
+	binary.Write(&key, binary.LittleEndian, opsLimit)
+	binary.Write(&key, binary.LittleEndian, memLimit)
+	key.Write(secret)
+	path := filepath.Join(t.TempDir(), "test.key")
+	os.WriteFile(path, []byte("untrusted comment: minisign encrypted secret key\n"+base64.StdEncoding.EncodeToString(key.Bytes())+"\n"), 0600)
+	return path
+}
+
+func TestMinisignSigner(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	data := []byte("Origin: Test\nLabel: Test\n")
+
+	tests := []struct {
+		name    string
+		path    string
+		keyID   string
+		signify bool
+	}{
+		{"signify", writeSignifyKey(t, priv, keyID), "0102030405060708", true},
+		{"minisign", writeMinisignKey(t, priv, keyID, ""), "0807060504030201", false},
+		{"encrypted minisign", writeMinisignKey(t, priv, keyID, "secret"), "0807060504030201", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewMinisignSigner(tt.path, "secret")
+			if err != nil {
+				t.Fatalf("NewMinisignSigner failed: %v", err)
+			}
+			if s.KeyID() != tt.keyID || s.Signify() != tt.signify {
+				t.Errorf("KeyID() = %s, Signify() = %v", s.KeyID(), s.Signify())
+			}
+
+			sig := s.Sign(data, "timestamp:0\tfile:Release\thashed")
+			lines := strings.Split(strings.TrimSpace(string(sig)), "\n")
+			if want := map[bool]int{true: 2, false: 4}[tt.signify]; len(lines) != want {
+				t.Errorf("Signature has %d lines, want %d:\n%s", len(lines), want, sig)
+			}
+			if err := VerifyMinisign(s.PublicKey(), sig, data); err != nil {
+				t.Errorf("VerifyMinisign failed: %v", err)
+			}
+			if err := VerifyMinisign(s.PublicKey(), sig, []byte("tampered")); err == nil {
+				t.Error("VerifyMinisign accepted tampered data")
+			}
+		})
+	}
+}
+
+func TestMinisignSignerErrors(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	keyID := make([]byte, 8)
+	encrypted := writeMinisignKey(t, priv, keyID, "secret")
+
+	if _, err := NewMinisignSigner(encrypted, ""); err == nil || !strings.Contains(err.Error(), "no passphrase") {
+		t.Errorf("Missing passphrase error = %v", err)
+	}
+	if _, err := NewMinisignSigner(encrypted, "wrong"); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Errorf("Wrong passphrase error = %v", err)
+	}
+
+	other := filepath.Join(t.TempDir(), "other.key")
+	os.WriteFile(other, []byte("untrusted comment: nothing\n"+base64.StdEncoding.EncodeToString([]byte("RWQ"))+"\n"), 0600)
+	if _, err := NewMinisignSigner(other, ""); err == nil {
+		t.Error("NewMinisignSigner accepted a malformed key")
+	}
+}
+
+func TestMinisignTrustedComment(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	s, err := NewMinisignSigner(writeMinisignKey(t, priv, make([]byte, 8), ""), "")
+	if err != nil {
+		t.Fatalf("NewMinisignSigner failed: %v", err)
+	}
+	data := []byte("data")
+	sig := strings.Replace(string(s.Sign(data, "file:Release")), "file:Release", "file:Other", 1)
+	if err := VerifyMinisign(s.PublicKey(), []byte(sig), data); err == nil {
+		t.Error("VerifyMinisign accepted a forged trusted comment")
+	}
+}
@@ -0,0 +1,226 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// pkcs11PINEnv passes the PIN to pkcs11-tool without exposing it on the command line
+const pkcs11PINEnv = "REPOGEN_PKCS11_PIN"
+
+// PKCS11Signer implements Signer with an RSA key that never leaves a PKCS#11
+// token (YubiKey, Nitrokey, SoftHSM, ...). Only the raw RSA operation runs on
+// the token, through OpenSC's pkcs11-tool
+type PKCS11Signer struct {
+	*remoteSigner
+}
+
+// pkcs11URI holds the RFC 7512 attributes used to find the key
+type pkcs11URI struct {
+	module string // module-path: the PKCS#11 library to load
+	token  string // token label
+	slot   string // slot-id
+	object string // key label
+	id     []byte // key ID
+	pin    string // pin-value, or read from pin-source
+}
+
+// NewPKCS11Signer creates a signer for the key selected by uri, e.g.
+// "pkcs11:token=YubiKey;object=release?module-path=/usr/lib/libykcs11.so".
+// pin is used when the URI has neither pin-value nor pin-source, and userID
+// names the key in the exported public key
+func NewPKCS11Signer(uri, pin, userID string) (*PKCS11Signer, error) {
+	parsed, err := parsePKCS11URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.pin == "" {
+		parsed.pin = pin
+	}
+
+	key := &pkcs11Key{uri: parsed}
+	if key.public, err = key.readPublicKey(); err != nil {
+		return nil, err
+	}
+
+	remote, err := newRemoteSigner(key, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to certify token key: %w", err)
+	}
+
+	return &PKCS11Signer{remote}, nil
+}
+
+// parsePKCS11URI parses the subset of RFC 7512 needed to find a signing key
+func parsePKCS11URI(uri string) (*pkcs11URI, error) {
+	rest, ok := strings.CutPrefix(uri, "pkcs11:")
+	if !ok {
+		return nil, fmt.Errorf("invalid PKCS#11 URI %q: must start with pkcs11:", uri)
+	}
+	path, query, _ := strings.Cut(rest, "?")
+
+	parsed := &pkcs11URI{}
+	attrs := make(map[string]string)
+	for _, part := range append(strings.Split(path, ";"), strings.Split(query, "&")...) {
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid PKCS#11 URI attribute %q", part)
+		}
+		decoded, err := url.PathUnescape(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PKCS#11 URI attribute %q: %w", part, err)
+		}
+		attrs[name] = decoded
+	}
+
+	parsed.module = attrs["module-path"]
+	parsed.token = attrs["token"]
+	parsed.slot = attrs["slot-id"]
+	parsed.object = attrs["object"]
+	parsed.id = []byte(attrs["id"])
+	parsed.pin = attrs["pin-value"]
+
+	if parsed.module == "" {
+		return nil, fmt.Errorf("PKCS#11 URI %q has no module-path", uri)
+	}
+	if parsed.object == "" && len(parsed.id) == 0 {
+		return nil, fmt.Errorf("PKCS#11 URI %q selects no key: set object or id", uri)
+	}
+
+	if source := attrs["pin-source"]; source != "" && parsed.pin == "" {
+		data, err := os.ReadFile(strings.TrimPrefix(source, "file:"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PIN: %w", err)
+		}
+		parsed.pin = strings.TrimRight(string(data), "\r\n")
+	}
+
+	return parsed, nil
+}
+
+// pkcs11Key is a crypto.Signer performing RSA PKCS#1 v1.5 signatures on the token
+type pkcs11Key struct {
+	uri    *pkcs11URI
+	public *rsa.PublicKey
+	mu     sync.Mutex // Tokens sign one digest at a time
+}
+
+// Public returns the token's public key
+func (k *pkcs11Key) Public() crypto.PublicKey {
+	return k.public
+}
+
+// Sign signs digest on the token. The raw RSA-PKCS mechanism pads whatever it
+// is given, so the DigestInfo naming the hash is prepended here
+func (k *pkcs11Key) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash %v", opts.HashFunc())
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	tmpDir, err := os.MkdirTemp("", "repogen-pkcs11-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	inputFile := filepath.Join(tmpDir, "input.dat")
+	if err := os.WriteFile(inputFile, append(append([]byte{}, prefix...), digest...), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write input file: %w", err)
+	}
+	outputFile := filepath.Join(tmpDir, "output.sig")
+
+	args := []string{"--sign", "--mechanism", "RSA-PKCS", "--login", "--input-file", inputFile, "--output-file", outputFile}
+	if err := k.tool(args...); err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(outputFile)
+}
+
+// readPublicKey exports the key's public half from the token
+func (k *pkcs11Key) readPublicKey() (*rsa.PublicKey, error) {
+	tmpDir, err := os.MkdirTemp("", "repogen-pkcs11-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	outputFile := filepath.Join(tmpDir, "public.der")
+	if err := k.tool("--read-object", "--type", "pubkey", "--output-file", outputFile); err != nil {
+		return nil, fmt.Errorf("failed to read public key from token: %w", err)
+	}
+
+	der, err := os.ReadFile(outputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// OpenSC exports SubjectPublicKeyInfo, older versions a bare PKCS#1 key
+	if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("only RSA keys are supported, token key is %T", pub)
+		}
+		return rsaPub, nil
+	}
+	pub, err := x509.ParsePKCS1PublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token public key: %w", err)
+	}
+	return pub, nil
+}
+
+// tool runs pkcs11-tool against the selected token and key
+func (k *pkcs11Key) tool(args ...string) error {
+	base := []string{"--module", k.uri.module}
+	if k.uri.token != "" {
+		base = append(base, "--token-label", k.uri.token)
+	}
+	if k.uri.slot != "" {
+		base = append(base, "--slot", k.uri.slot)
+	}
+	if k.uri.object != "" {
+		base = append(base, "--label", k.uri.object)
+	}
+	if len(k.uri.id) > 0 {
+		base = append(base, "--id", hex.EncodeToString(k.uri.id))
+	}
+
+	cmd := exec.Command("pkcs11-tool", append(base, args...)...)
+	if k.uri.pin != "" {
+		cmd.Args = append(cmd.Args, "--pin", "env:"+pkcs11PINEnv)
+		cmd.Env = append(os.Environ(), pkcs11PINEnv+"="+k.uri.pin)
+	}
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("pkcs11-tool failed: %w\nOutput: %s", err, output)
+	}
+
+	return nil
+}
+
+// digestInfoPrefixes are the DER DigestInfo headers of PKCS#1 v1.5 signatures
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA224: {0x30, 0x2d, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x04, 0x05, 0x00, 0x04, 0x1c},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
@@ -0,0 +1,64 @@
+package signer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDigestInfoPrefixes(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	// Padding prefix+digest raw must give what crypto/rsa produces for the hash
+	for hash, prefix := range digestInfoPrefixes {
+		h := hash.New()
+		h.Write([]byte("data"))
+		digest := h.Sum(nil)
+
+		want, err := rsa.SignPKCS1v15(nil, key, hash, digest)
+		if err != nil {
+			t.Fatalf("%v: %v", hash, err)
+		}
+		got, err := rsa.SignPKCS1v15(nil, key, crypto.Hash(0), append(append([]byte{}, prefix...), digest...))
+		if err != nil {
+			t.Fatalf("%v: %v", hash, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("DigestInfo prefix for %v is wrong", hash)
+		}
+	}
+}
+
+func TestParsePKCS11URI(t *testing.T) {
+	pinFile := filepath.Join(t.TempDir(), "pin")
+	os.WriteFile(pinFile, []byte("654321\n"), 0600)
+
+	uri, err := parsePKCS11URI("pkcs11:token=My%20Token;id=%01%02;object=release?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=file:" + pinFile)
+	if err != nil {
+		t.Fatalf("parsePKCS11URI failed: %v", err)
+	}
+	if uri.module != "/usr/lib/softhsm/libsofthsm2.so" || uri.token != "My Token" || uri.object != "release" {
+		t.Errorf("Unexpected attributes: %+v", uri)
+	}
+	if !bytes.Equal(uri.id, []byte{1, 2}) {
+		t.Errorf("id = %x, want 0102", uri.id)
+	}
+	if uri.pin != "654321" {
+		t.Errorf("pin = %q, want 654321", uri.pin)
+	}
+
+	for _, invalid := range []string{
+		"token=x;object=y?module-path=/lib.so",
+		"pkcs11:token=x;object=y",
+		"pkcs11:token=x?module-path=/lib.so",
+		"pkcs11:object=%zz?module-path=/lib.so",
+	} {
+		if _, err := parsePKCS11URI(invalid); err == nil {
+			t.Errorf("parsePKCS11URI(%q) succeeded", invalid)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Public key encodings published next to signed repositories. Each client
+// wants its own: dnf/rpm and pacman-key import armored keys, apt's Signed-By
+// wants a binary keyring, and gpgv/GnuPG 2 keyrings are keybox files
+const (
+	KeyArmored = ".asc"
+	KeyBinary  = ".gpg"
+	KeyKeybox  = ".kbx"
+)
+
+// publicKeyDir is the directory of the published keys, relative to the repository root
+const publicKeyDir = "keys"
+
+// RootKeyPath is a copy of the armored key at a path that doesn't depend on
+// the repository name, so clients can be set up from the repository URL alone
+const RootKeyPath = "key.asc"
+
+// PublicKeyPath returns where the key named name is published in the given
+// encoding, as a slash-separated path relative to the repository root
+func PublicKeyPath(name, encoding string) string {
+	return path.Join(publicKeyDir, name+encoding)
+}
+
+// PublishPublicKey writes the public key of s into outputDir in every
+// encoding, so each client configuration can reference the one it needs,
+// and as RootKeyPath.
+// The keybox records created as the time the key was imported
+func PublishPublicKey(s Signer, outputDir, name string, created time.Time) error {
+	armored, err := s.GetPublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to get public key: %w", err)
+	}
+
+	binaryKey, err := Dearmor(armored)
+	if err != nil {
+		return err
+	}
+
+	keybox, err := Keybox(armored, created)
+	if err != nil {
+		return err
+	}
+
+	for keyPath, data := range map[string][]byte{
+		PublicKeyPath(name, KeyArmored): armored,
+		PublicKeyPath(name, KeyBinary):  binaryKey,
+		PublicKeyPath(name, KeyKeybox):  keybox,
+		RootKeyPath:                     armored,
+	} {
+		if err := utils.WriteFile(filepath.Join(outputDir, filepath.FromSlash(keyPath)), data, 0644); err != nil {
+			return fmt.Errorf("failed to write public key: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// PublishRSAPublicKey writes the PEM public key of s into outputDir as
+// keys/<fileName>, the name clients install it under
+func PublishRSAPublicKey(s RSASigner, outputDir, fileName string) error {
+	publicKey, err := s.GetPublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to get public key: %w", err)
+	}
+
+	keyPath := filepath.Join(outputDir, publicKeyDir, fileName)
+	if err := utils.WriteFile(keyPath, publicKey, 0644); err != nil {
+		return fmt.Errorf("failed to write public key: %w", err)
+	}
+	return nil
+}
+
+// Fingerprint returns the fingerprint of the primary key of s, in uppercase hex
+func Fingerprint(s Signer) (string, error) {
+	armored, err := s.GetPublicKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to get public key: %w", err)
+	}
+
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+	if err != nil {
+		return "", fmt.Errorf("failed to read public key: %w", err)
+	}
+	if len(entities) == 0 {
+		return "", fmt.Errorf("public key is empty")
+	}
+
+	return strings.ToUpper(hex.EncodeToString(entities[0].PrimaryKey.Fingerprint)), nil
+}
+
+// Dearmor converts an armored public key into a binary OpenPGP keyring, the
+// same as gpg --dearmor
+func Dearmor(armored []byte) ([]byte, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+
+	var buf bytes.Buffer
+	for _, entity := range entities {
+		if err := entity.Serialize(&buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Keybox converts an armored public key into a GnuPG keybox file (the
+// pubring.kbx format), usable with gpg/gpgv --keyring
+func Keybox(armored []byte, created time.Time) ([]byte, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+
+	var kbx bytes.Buffer
+
+	// Header blob
+	header := make([]byte, 32)
+	binary.BigEndian.PutUint32(header[0:], 32)
+	header[4] = 1                                  // Blob type: header
+	header[5] = 1                                  // Version
+	binary.BigEndian.PutUint16(header[6:], 0x0002) // Holds OpenPGP blobs
+	copy(header[8:], "KBXf")
+	binary.BigEndian.PutUint32(header[16:], uint32(created.Unix()))
+	kbx.Write(header)
+
+	for _, entity := range entities {
+		blob, err := keyboxBlob(entity, created)
+		if err != nil {
+			return nil, err
+		}
+		kbx.Write(blob)
+	}
+
+	return kbx.Bytes(), nil
+}
+
+// keyboxBlob builds the OpenPGP blob of one key: an index of its keys, user
+// IDs and signatures, followed by the keyblock itself and a SHA-1 checksum
+func keyboxBlob(entity *openpgp.Entity, created time.Time) ([]byte, error) {
+	// Serialize the keyblock the way Entity.Serialize does, remembering
+	// where each user ID lands
+	var keyblock bytes.Buffer
+	type uidRef struct{ off, len int }
+	var uids []uidRef
+	var sigs []*packet.Signature
+
+	if err := entity.PrimaryKey.Serialize(&keyblock); err != nil {
+		return nil, err
+	}
+	sigs = append(sigs, entity.Revocations...)
+	if err := serializeSignatures(&keyblock, entity.Revocations); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entity.Identities))
+	for name := range entity.Identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ident := entity.Identities[name]
+		if err := ident.UserId.Serialize(&keyblock); err != nil {
+			return nil, err
+		}
+		// The user ID is the body of the packet just written
+		uids = append(uids, uidRef{off: keyblock.Len() - len(ident.UserId.Id), len: len(ident.UserId.Id)})
+		sigs = append(sigs, ident.Signatures...)
+		if err := serializeSignatures(&keyblock, ident.Signatures); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := []*packet.PublicKey{entity.PrimaryKey}
+	for _, subkey := range entity.Subkeys {
+		if err := subkey.PublicKey.Serialize(&keyblock); err != nil {
+			return nil, err
+		}
+		subkeySigs := append(append([]*packet.Signature{}, subkey.Revocations...), subkey.Sig)
+		sigs = append(sigs, subkeySigs...)
+		if err := serializeSignatures(&keyblock, subkeySigs); err != nil {
+			return nil, err
+		}
+		keys = append(keys, subkey.PublicKey)
+	}
+	nsigs := len(sigs)
+
+	const keyInfoSize, uidInfoSize, sigInfoSize = 28, 12, 4
+	fixedSize := 4 + 1 + 1 + 2 + 4 + 4 + // Length, type, version, flags, keyblock offset and length
+		2 + 2 + len(keys)*keyInfoSize + // Keys
+		2 + // Serial number size
+		2 + 2 + len(uids)*uidInfoSize + // User IDs
+		2 + 2 + nsigs*sigInfoSize + // Signatures
+		1 + 1 + 2 + 4 + 4 + 4 + // Ownertrust, validity, RFU, recheck, latest timestamp, created
+		4 // Reserved space size
+	keyblockOffset := fixedSize
+	length := keyblockOffset + keyblock.Len() + sha1.Size
+
+	blob := make([]byte, 0, length)
+	be := binary.BigEndian
+	blob = be.AppendUint32(blob, uint32(length))
+	blob = append(blob, 2, 1) // Blob type: OpenPGP, version 1
+	blob = be.AppendUint16(blob, 0)
+	blob = be.AppendUint32(blob, uint32(keyblockOffset))
+	blob = be.AppendUint32(blob, uint32(keyblock.Len()))
+
+	blob = be.AppendUint16(blob, uint16(len(keys)))
+	blob = be.AppendUint16(blob, keyInfoSize)
+	for _, key := range keys {
+		if len(key.Fingerprint) != 20 {
+			return nil, fmt.Errorf("only v4 keys can be stored in a keybox")
+		}
+		// A v4 key ID is the end of its fingerprint, so point there
+		kidOffset := len(blob) + 12
+		blob = append(blob, key.Fingerprint...)
+		blob = be.AppendUint32(blob, uint32(kidOffset))
+		blob = be.AppendUint16(blob, 0) // Key flags
+		blob = be.AppendUint16(blob, 0) // RFU
+	}
+
+	blob = be.AppendUint16(blob, 0) // No serial number
+
+	blob = be.AppendUint16(blob, uint16(len(uids)))
+	blob = be.AppendUint16(blob, uidInfoSize)
+	for _, uid := range uids {
+		blob = be.AppendUint32(blob, uint32(keyblockOffset+uid.off))
+		blob = be.AppendUint32(blob, uint32(uid.len))
+		blob = be.AppendUint16(blob, 0) // Flags
+		blob = append(blob, 0, 0)       // Validity, RFU
+	}
+
+	blob = be.AppendUint16(blob, uint16(nsigs))
+	blob = be.AppendUint16(blob, sigInfoSize)
+	for i := 0; i < nsigs; i++ {
+		blob = be.AppendUint32(blob, 0) // Not checked
+	}
+
+	blob = append(blob, 0, 0) // Ownertrust, validity
+	blob = be.AppendUint16(blob, 0)
+	blob = be.AppendUint32(blob, 0) // Recheck after
+	blob = be.AppendUint32(blob, uint32(entity.PrimaryKey.CreationTime.Unix()))
+	blob = be.AppendUint32(blob, uint32(created.Unix()))
+	blob = be.AppendUint32(blob, 0) // No reserved space
+
+	blob = append(blob, keyblock.Bytes()...)
+	sum := sha1.Sum(blob)
+	return append(blob, sum[:]...), nil
+}
+
+// serializeSignatures writes sigs one after the other
+func serializeSignatures(w io.Writer, sigs []*packet.Signature) error {
+	for _, sig := range sigs {
+		if err := sig.Serialize(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PublishMinisignPublicKey writes the public key of s into outputDir as
+// keys/<name>.pub, the file minisign -p and usign -p verify with
+func PublishMinisignPublicKey(s *MinisignSigner, outputDir, name string) error {
+	keyPath := filepath.Join(outputDir, filepath.FromSlash(PublicKeyPath(name, MinisignPublicExt)))
+	if err := utils.WriteFile(keyPath, s.PublicKey(), 0644); err != nil {
+		return fmt.Errorf("failed to write public key: %w", err)
+	}
+	return nil
+}
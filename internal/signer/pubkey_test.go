@@ -0,0 +1,90 @@
+package signer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+func TestPublicKeyEncodings(t *testing.T) {
+	s, keyring := newTestRemoteSigner(t)
+	armored, _ := s.GetPublicKey()
+
+	binaryKey, err := Dearmor(armored)
+	if err != nil {
+		t.Fatalf("Dearmor failed: %v", err)
+	}
+	entities, err := openpgp.ReadKeyRing(bytes.NewReader(binaryKey))
+	if err != nil || len(entities) != 1 {
+		t.Fatalf("Dearmored key does not parse: %v", err)
+	}
+	if entities[0].PrimaryKey.KeyId != keyring[0].PrimaryKey.KeyId {
+		t.Errorf("Dearmored key ID = %X, want %X", entities[0].PrimaryKey.KeyId, keyring[0].PrimaryKey.KeyId)
+	}
+
+	kbx, err := Keybox(armored, time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatalf("Keybox failed: %v", err)
+	}
+	if string(kbx[8:12]) != "KBXf" {
+		t.Fatalf("Missing keybox magic")
+	}
+
+	// Let GnuPG itself read the keybox when it is installed
+	if _, err := exec.LookPath("gpgv"); err != nil {
+		t.Skip("gpgv not installed")
+	}
+	dir := t.TempDir()
+	kbxPath := filepath.Join(dir, "key.kbx")
+	dataPath := filepath.Join(dir, "data")
+	sigPath := filepath.Join(dir, "data.sig")
+	data := []byte("Origin: Test\n")
+	sig, _ := s.SignDetachedBinary(data)
+	os.WriteFile(kbxPath, kbx, 0644)
+	os.WriteFile(dataPath, data, 0644)
+	os.WriteFile(sigPath, sig, 0644)
+
+	cmd := exec.Command("gpgv", "--homedir", dir, "--keyring", kbxPath, sigPath, dataPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("gpgv rejected the keybox: %v\n%s", err, output)
+	}
+}
+
+func TestPublishPublicKey(t *testing.T) {
+	s, _ := newTestRemoteSigner(t)
+	dir := t.TempDir()
+
+	if err := PublishPublicKey(s, dir, "myrepo", time.Now()); err != nil {
+		t.Fatalf("PublishPublicKey failed: %v", err)
+	}
+	for _, encoding := range []string{KeyArmored, KeyBinary, KeyKeybox} {
+		if _, err := os.Stat(filepath.Join(dir, "keys", "myrepo"+encoding)); err != nil {
+			t.Errorf("Missing %s key: %v", encoding, err)
+		}
+	}
+
+	// The root copy doesn't depend on the repository name
+	armored, _ := os.ReadFile(filepath.Join(dir, "keys", "myrepo"+KeyArmored))
+	root, err := os.ReadFile(filepath.Join(dir, RootKeyPath))
+	if err != nil || !bytes.Equal(root, armored) {
+		t.Errorf("%s differs from the armored key: %v", RootKeyPath, err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	s, keyring := newTestRemoteSigner(t)
+
+	fingerprint, err := Fingerprint(s)
+	if err != nil {
+		t.Fatalf("Fingerprint failed: %v", err)
+	}
+	if want := fmt.Sprintf("%X", keyring[0].PrimaryKey.Fingerprint); fingerprint != want {
+		t.Errorf("Fingerprint = %s, want %s", fingerprint, want)
+	}
+}
@@ -0,0 +1,311 @@
+package signer
+
+import (
+	"bytes"
+	"crypto"
+	stdecdsa "crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/ecdsa"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/sassoftware/go-rpmutils"
+)
+
+// remoteKeyCreationTime is the creation time of the OpenPGP key wrapped around
+// a remote public key. It is part of the fingerprint, so it must never
+// change or clients would have to import a new key
+var remoteKeyCreationTime = time.Unix(0, 0)
+
+// remoteSigner implements Signer and RPMSigner for RSA and ECDSA keys whose
+// private half is out of reach (hardware tokens, cloud KMS). Only the raw
+// signing operation is delegated to the key; OpenPGP packets are built here
+type remoteSigner struct {
+	entity *openpgp.Entity
+	hash   crypto.Hash // Digest ECDSA keys sign, matching their curve; zero for RSA keys
+}
+
+// newRemoteSigner wraps an RSA or ECDSA crypto.Signer into an OpenPGP entity
+// with a self-signed user ID, the minimum apt, dnf and pacman accept as a key
+func newRemoteSigner(key crypto.Signer, userID string) (*remoteSigner, error) {
+	s := &remoteSigner{}
+
+	var pub *packet.PublicKey
+	var priv *packet.PrivateKey
+	switch public := key.Public().(type) {
+	case *rsa.PublicKey:
+		pub = packet.NewRSAPublicKey(remoteKeyCreationTime, public)
+		priv = &packet.PrivateKey{PublicKey: *pub, PrivateKey: key}
+	case *stdecdsa.PublicKey:
+		hash, ok := curveHashes[public.Curve]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %s", public.Curve.Params().Name)
+		}
+		s.hash = hash
+
+		// The curve stands in for the private key, which only signs
+		ecdsaPub := ecdsa.NewPublicKey(&remoteCurve{Curve: public.Curve, key: key})
+		ecdsaPub.X, ecdsaPub.Y = public.X, public.Y
+		pub = packet.NewECDSAPublicKey(remoteKeyCreationTime, ecdsaPub)
+		priv = &packet.PrivateKey{PublicKey: *pub, PrivateKey: ecdsa.NewPrivateKey(*ecdsaPub)}
+	default:
+		return nil, fmt.Errorf("only RSA and ECDSA keys are supported, not %T", public)
+	}
+
+	// Accept the usual "Name <email>" form
+	name, email := userID, ""
+	if n, e, ok := strings.Cut(userID, " <"); ok && strings.HasSuffix(e, ">") {
+		name, email = n, strings.TrimSuffix(e, ">")
+	}
+	uid := packet.NewUserId(name, "", email)
+	if uid == nil {
+		return nil, fmt.Errorf("invalid user ID %q", userID)
+	}
+
+	isPrimary := true
+	selfSig := &packet.Signature{
+		Version:      4,
+		SigType:      packet.SigTypePositiveCert,
+		PubKeyAlgo:   pub.PubKeyAlgo,
+		Hash:         s.digest(crypto.SHA512),
+		CreationTime: remoteKeyCreationTime,
+		IssuerKeyId:  &pub.KeyId,
+		IsPrimaryId:  &isPrimary,
+		FlagsValid:   true,
+		FlagSign:     true,
+		FlagCertify:  true,
+	}
+	if err := selfSig.SignUserId(uid.Id, pub, priv, nil); err != nil {
+		return nil, err
+	}
+
+	entity := &openpgp.Entity{
+		PrimaryKey: pub,
+		PrivateKey: priv,
+		Identities: map[string]*openpgp.Identity{
+			uid.Id: {
+				Name:          uid.Id,
+				UserId:        uid,
+				SelfSignature: selfSig,
+				Signatures:    []*packet.Signature{selfSig},
+			},
+		},
+	}
+
+	s.entity = entity
+	return s, nil
+}
+
+// digest returns the hash signatures use: preferred, unless the key is an
+// ECDSA key, which only signs digests of the size of its curve
+func (s *remoteSigner) digest(preferred crypto.Hash) crypto.Hash {
+	if s.hash != 0 {
+		return s.hash
+	}
+	return preferred
+}
+
+// SignCleartext creates a cleartext signature (for Debian InRelease)
+func (s *remoteSigner) SignCleartext(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := clearsign.Encode(&buf, s.entity.PrivateKey, &packet.Config{DefaultHash: s.digest(crypto.SHA512)})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to create cleartext signature: %w", err)
+	}
+	// The armor ends without a newline, unlike gpg's output
+	buf.WriteString("\n")
+
+	return buf.Bytes(), nil
+}
+
+// SignDetached creates a detached ASCII-armored signature (for Debian Release.gpg, RPM repomd.xml.asc)
+func (s *remoteSigner) SignDetached(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	err := openpgp.ArmoredDetachSign(&buf, s.entity, bytes.NewReader(data), &packet.Config{
+		DefaultHash: s.digest(crypto.SHA512),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create detached signature: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// SignDetachedBinary creates a detached binary signature (for Pacman .sig files)
+func (s *remoteSigner) SignDetachedBinary(data []byte) ([]byte, error) {
+	return s.detachSign(bytes.NewReader(data), crypto.SHA512, time.Now())
+}
+
+// SignDetachedBinaryFromFile creates a detached binary signature directly from a file
+func (s *remoteSigner) SignDetachedBinaryFromFile(filePath string) ([]byte, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer f.Close()
+
+	return s.detachSign(f, crypto.SHA512, time.Now())
+}
+
+// GetPublicKey returns the public key in armored format
+func (s *remoteSigner) GetPublicKey() ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.entity.Serialize(w); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// SignRPM embeds V4 OpenPGP signatures into the RPM at path, rewriting it in place.
+// The key signs the same ranges rpmsign does: the header alone, and header plus payload
+func (s *remoteSigner) SignRPM(path string, created time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open RPM: %w", err)
+	}
+	defer f.Close()
+
+	header, err := rpmutils.ReadHeader(f)
+	if err != nil {
+		return fmt.Errorf("failed to read RPM header: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	r := header.GetRange()
+
+	sigHeader, err := s.detachSign(io.NewSectionReader(f, int64(r.Start), int64(r.End-r.Start)), crypto.SHA256, created)
+	if err != nil {
+		return fmt.Errorf("failed to sign RPM header: %w", err)
+	}
+	sigPayload, err := s.detachSign(io.NewSectionReader(f, int64(r.Start), info.Size()-int64(r.Start)), crypto.SHA256, created)
+	if err != nil {
+		return fmt.Errorf("failed to sign RPM payload: %w", err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := rpmutils.RewriteWithSignatures(f, path, sigPayload, sigHeader); err != nil {
+		return fmt.Errorf("failed to sign RPM %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// detachSign creates a binary detached signature of message using hash,
+// made at created
+func (s *remoteSigner) detachSign(message io.Reader, hash crypto.Hash, created time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+
+	config := &packet.Config{DefaultHash: s.digest(hash), Time: func() time.Time { return created }}
+	if err := openpgp.DetachSign(&buf, s.entity, message, config); err != nil {
+		return nil, fmt.Errorf("failed to create detached signature: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// curveHashes maps the curves of ECDSA keys to the digests they sign, as
+// cloud KMS requires
+var curveHashes = map[elliptic.Curve]crypto.Hash{
+	elliptic.P256(): crypto.SHA256,
+	elliptic.P384(): crypto.SHA384,
+	elliptic.P521(): crypto.SHA512,
+}
+
+// digestHashes maps digest sizes to their hash
+var digestHashes = map[int]crypto.Hash{
+	crypto.SHA256.Size(): crypto.SHA256,
+	crypto.SHA384.Size(): crypto.SHA384,
+	crypto.SHA512.Size(): crypto.SHA512,
+}
+
+// remoteCurve is the curve of an ECDSA key whose private half is out of
+// reach. go-crypto signs through the curve of the key, so it delegates
+// signing to the key and decodes the ASN.1 signature into r and s
+type remoteCurve struct {
+	elliptic.Curve
+	key crypto.Signer
+}
+
+func (c *remoteCurve) GetCurveName() string {
+	return c.Params().Name
+}
+
+func (c *remoteCurve) MarshalIntegerPoint(x, y *big.Int) []byte {
+	return elliptic.Marshal(c.Curve, x, y)
+}
+
+func (c *remoteCurve) UnmarshalIntegerPoint(point []byte) (x, y *big.Int) {
+	return elliptic.Unmarshal(c.Curve, point)
+}
+
+func (c *remoteCurve) MarshalIntegerSecret(*big.Int) []byte {
+	return nil
+}
+
+func (c *remoteCurve) UnmarshalIntegerSecret([]byte) *big.Int {
+	return nil
+}
+
+func (c *remoteCurve) GenerateECDSA(io.Reader) (x, y, secret *big.Int, err error) {
+	return nil, nil, nil, fmt.Errorf("remote keys can't be generated")
+}
+
+func (c *remoteCurve) Sign(rand io.Reader, _, _, _ *big.Int, hash []byte) (r, s *big.Int, err error) {
+	h, ok := digestHashes[len(hash)]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported %d-byte digest", len(hash))
+	}
+	der, err := c.key.Sign(rand, hash, h)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sig struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(der, &sig); err != nil || len(rest) > 0 {
+		return nil, nil, fmt.Errorf("invalid ECDSA signature")
+	}
+	return sig.R, sig.S, nil
+}
+
+func (c *remoteCurve) Verify(x, y *big.Int, hash []byte, r, s *big.Int) bool {
+	return stdecdsa.Verify(&stdecdsa.PublicKey{Curve: c.Curve, X: x, Y: y}, hash, r, s)
+}
+
+func (c *remoteCurve) ValidateECDSA(*big.Int, *big.Int, []byte) error {
+	return fmt.Errorf("remote keys can't be validated")
+}
@@ -0,0 +1,134 @@
+package signer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// newTestRemoteSigner stands in for a token or KMS with an in-memory RSA key
+func newTestRemoteSigner(t *testing.T) (*remoteSigner, openpgp.EntityList) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return newTestRemoteSignerFor(t, key)
+}
+
+// newTestRemoteSignerFor wraps key as a token or KMS would
+func newTestRemoteSignerFor(t *testing.T, key crypto.Signer) (*remoteSigner, openpgp.EntityList) {
+	t.Helper()
+
+	s, err := newRemoteSigner(key, "Test Repository <test@example.com>")
+	if err != nil {
+		t.Fatalf("newRemoteSigner failed: %v", err)
+	}
+
+	armored, err := s.GetPublicKey()
+	if err != nil {
+		t.Fatalf("GetPublicKey failed: %v", err)
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+	if err != nil {
+		t.Fatalf("Exported public key does not parse: %v", err)
+	}
+
+	return s, keyring
+}
+
+func TestRemoteSignaturesVerify(t *testing.T) {
+	s, keyring := newTestRemoteSigner(t)
+	message := []byte("Origin: Test\n-Label: Test\n")
+
+	signed, err := s.SignCleartext(message)
+	if err != nil {
+		t.Fatalf("SignCleartext failed: %v", err)
+	}
+	text, err := VerifyCleartext(signed, keyring)
+	if err != nil {
+		t.Fatalf("Cleartext signature does not verify: %v\n%s", err, signed)
+	}
+	if string(text) != string(message) {
+		t.Errorf("Signed text = %q, want %q", text, message)
+	}
+
+	detached, err := s.SignDetached(message)
+	if err != nil {
+		t.Fatalf("SignDetached failed: %v", err)
+	}
+	if _, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(message), bytes.NewReader(detached), nil); err != nil {
+		t.Errorf("Detached signature does not verify: %v", err)
+	}
+
+	binary, err := s.SignDetachedBinary(message)
+	if err != nil {
+		t.Fatalf("SignDetachedBinary failed: %v", err)
+	}
+	if _, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(message), bytes.NewReader(binary), nil); err != nil {
+		t.Errorf("Binary signature does not verify: %v", err)
+	}
+}
+
+func TestRemotePublicKeyIsStable(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	first, _ := newRemoteSigner(key, "Test")
+	second, _ := newRemoteSigner(key, "Test")
+	if first.entity.PrimaryKey.KeyIdString() != second.entity.PrimaryKey.KeyIdString() {
+		t.Errorf("Key ID changed between runs: %s != %s", first.entity.PrimaryKey.KeyIdString(), second.entity.PrimaryKey.KeyIdString())
+	}
+}
+
+func TestRemoteECDSASignaturesVerify(t *testing.T) {
+	for _, test := range []struct {
+		curve elliptic.Curve
+		hash  crypto.Hash
+	}{
+		{elliptic.P256(), crypto.SHA256},
+		{elliptic.P384(), crypto.SHA384},
+	} {
+		t.Run(test.curve.Params().Name, func(t *testing.T) {
+			key, err := ecdsa.GenerateKey(test.curve, rand.Reader)
+			if err != nil {
+				t.Fatalf("Failed to generate key: %v", err)
+			}
+			s, keyring := newTestRemoteSignerFor(t, key)
+			if algo := keyring[0].PrimaryKey.PubKeyAlgo; algo != packet.PubKeyAlgoECDSA {
+				t.Errorf("Exported key algorithm = %d, want ECDSA", algo)
+			}
+			message := []byte("Origin: Test\n")
+
+			signed, err := s.SignCleartext(message)
+			if err != nil {
+				t.Fatalf("SignCleartext failed: %v", err)
+			}
+			if _, err := VerifyCleartext(signed, keyring); err != nil {
+				t.Errorf("Cleartext signature does not verify: %v\n%s", err, signed)
+			}
+
+			binary, err := s.SignDetachedBinary(message)
+			if err != nil {
+				t.Fatalf("SignDetachedBinary failed: %v", err)
+			}
+			sig, err := packet.Read(bytes.NewReader(binary))
+			if err != nil {
+				t.Fatalf("Signature does not parse: %v", err)
+			}
+			if hash := sig.(*packet.Signature).Hash; hash != test.hash {
+				t.Errorf("Signature digest = %v, want %v", hash, test.hash)
+			}
+			if _, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(message), bytes.NewReader(binary), nil); err != nil {
+				t.Errorf("Binary signature does not verify: %v", err)
+			}
+		})
+	}
+}
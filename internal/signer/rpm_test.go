@@ -0,0 +1,78 @@
+package signer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/sassoftware/go-rpmutils"
+	xopenpgp "golang.org/x/crypto/openpgp"
+)
+
+func TestSignRPMWithSigningSubkey(t *testing.T) {
+	fixture := filepath.Join("..", "..", "test", "fixtures", "rpms", "repogen-test-1.0.0-1.x86_64.rpm")
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Skip("RPM fixture not found")
+	}
+
+	primaryOnly := newTestGPGSigner(t, &packet.Config{RSABits: 2048})
+	withSubkey := newTestGPGSigner(t, &packet.Config{RSABits: 2048})
+	if err := withSubkey.entity.AddSigningSubkey(&packet.Config{RSABits: 2048}); err != nil {
+		t.Fatalf("Failed to add a signing subkey: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		signer *GPGSigner
+		keyID  uint64
+	}{
+		{"primary key", primaryOnly, primaryOnly.entity.PrimaryKey.KeyId},
+		{"signing subkey", withSubkey, withSubkey.entity.Subkeys[len(withSubkey.entity.Subkeys)-1].PublicKey.KeyId},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), filepath.Base(fixture))
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			// Older than the key, as reproducible timestamps often are
+			signedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			if err := tt.signer.SignRPM(path, signedAt); err != nil {
+				t.Fatalf("SignRPM failed: %v", err)
+			}
+
+			var public bytes.Buffer
+			if err := tt.signer.entity.Serialize(&public); err != nil {
+				t.Fatal(err)
+			}
+			keyring, err := xopenpgp.ReadKeyRing(&public)
+			if err != nil {
+				t.Fatalf("Failed to read keyring: %v", err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			_, sigs, err := rpmutils.Verify(f, keyring)
+			if err != nil {
+				t.Fatalf("Signed RPM does not verify: %v", err)
+			}
+			if len(sigs) != 2 {
+				t.Errorf("Got %d signatures, want 2", len(sigs))
+			}
+			for _, sig := range sigs {
+				if sig.KeyId != tt.keyID {
+					t.Errorf("Signed by %X, want %X", sig.KeyId, tt.keyID)
+				}
+				if !sig.CreationTime.Equal(signedAt) {
+					t.Errorf("Signature made at %v, want %v", sig.CreationTime, signedAt)
+				}
+			}
+		})
+	}
+}
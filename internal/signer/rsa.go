@@ -5,9 +5,11 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"os"
 )
 
@@ -105,6 +107,28 @@ func (s *AlpineRSASigner) SignRSA(data []byte) ([]byte, error) {
 	return signature, nil
 }
 
+// SignRSASHA256FromFile creates an RSA PKCS1v15 signature of the SHA-256 of
+// a file (xbps .sig2 standard)
+func (s *AlpineRSASigner) SignRSASHA256FromFile(filePath string) ([]byte, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA256, h.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	return signature, nil
+}
+
 // GetPublicKey returns the public key in PEM format
 func (s *AlpineRSASigner) GetPublicKey() ([]byte, error) {
 	pubKeyBytes, err := x509.MarshalPKIXPublicKey(s.publicKey)
@@ -19,11 +19,15 @@ type Signer interface {
 	GetPublicKey() ([]byte, error)
 }
 
-// RSASigner interface for RSA signing (Alpine APK)
+// RSASigner interface for RSA signing (Alpine APK, F-Droid, xbps)
 type RSASigner interface {
 	// SignRSA creates an RSA PKCS1v15 signature
 	SignRSA(data []byte) ([]byte, error)
 
+	// SignRSASHA256FromFile creates an RSA PKCS1v15 signature of the SHA-256
+	// of a file (for xbps .sig2 files), without loading it into memory
+	SignRSASHA256FromFile(filePath string) ([]byte, error)
+
 	// GetPublicKey returns the public key
 	GetPublicKey() ([]byte, error)
 }
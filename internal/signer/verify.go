@@ -0,0 +1,288 @@
+package signer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+const (
+	cleartextHeader       = "-----BEGIN PGP SIGNED MESSAGE-----"
+	cleartextSignature    = "-----BEGIN PGP SIGNATURE-----"
+	cleartextSignatureEnd = "-----END PGP SIGNATURE-----"
+)
+
+// cleartextHashes maps the names allowed in "Hash:" armor headers
+var cleartextHashes = map[string]crypto.Hash{
+	"SHA1":   crypto.SHA1,
+	"SHA224": crypto.SHA224,
+	"SHA256": crypto.SHA256,
+	"SHA384": crypto.SHA384,
+	"SHA512": crypto.SHA512,
+}
+
+// VerifyCleartext checks a cleartext signed message (e.g. a Debian InRelease
+// file) against keyring the way apt does with gpgv, and returns the signed
+// text with dash-escaping undone.
+//
+// It is deliberately strict: apt refuses InRelease files with data before
+// or after the signed block, unknown armor headers or hash algorithms that
+// don't match the "Hash:" header, so they are rejected here too, as are
+// SHA1 signatures and signatures by keys that are expired, revoked or not
+// allowed to sign. As in RFC 4880 section 7.1, trailing spaces and tabs are
+// not part of the signed text and line endings are signed as CRLF.
+func VerifyCleartext(signed []byte, keyring openpgp.KeyRing) ([]byte, error) {
+	text := strings.ReplaceAll(string(signed), "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+
+	// The signed message must come first
+	if len(lines) == 0 || strings.TrimRight(lines[0], " \t") != cleartextHeader {
+		return nil, fmt.Errorf("data before the signed message")
+	}
+	i := 1
+
+	// Armor headers up to the first empty line; only Hash is defined
+	declared := make(map[crypto.Hash]bool)
+	for ; i < len(lines) && strings.TrimRight(lines[i], " \t") != ""; i++ {
+		name, value, ok := strings.Cut(lines[i], ": ")
+		if !ok || name != "Hash" {
+			return nil, fmt.Errorf("invalid armor header %q", lines[i])
+		}
+		for _, algo := range strings.Split(value, ",") {
+			h, ok := cleartextHashes[strings.TrimSpace(algo)]
+			if !ok {
+				return nil, fmt.Errorf("unsupported hash algorithm %q", algo)
+			}
+			declared[h] = true
+		}
+	}
+	if i == len(lines) {
+		return nil, fmt.Errorf("missing signed text")
+	}
+	i++
+
+	// Signed text up to the signature, undoing dash-escaping
+	var message []string
+	for ; i < len(lines) && strings.TrimRight(lines[i], " \t") != cleartextSignature; i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "-") {
+			if !strings.HasPrefix(line, "- ") {
+				return nil, fmt.Errorf("line %d is not dash-escaped", i+1)
+			}
+			line = line[2:]
+		}
+		message = append(message, line)
+	}
+	if i == len(lines) {
+		return nil, fmt.Errorf("missing signature")
+	}
+
+	// The signature block must end the file; only whitespace may follow
+	start := i
+	for ; i < len(lines) && strings.TrimRight(lines[i], " \t") != cleartextSignatureEnd; i++ {
+	}
+	if i == len(lines) {
+		return nil, fmt.Errorf("unterminated signature")
+	}
+	for _, line := range lines[i+1:] {
+		if strings.TrimSpace(line) != "" {
+			return nil, fmt.Errorf("data after the signature")
+		}
+	}
+
+	block, err := armor.Decode(strings.NewReader(strings.Join(lines[start:i+1], "\n")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature armor: %w", err)
+	}
+
+	// The line ending before the signature belongs to the armor, not the text
+	var canonical bytes.Buffer
+	for n, line := range message {
+		if n > 0 {
+			canonical.WriteString("\r\n")
+		}
+		canonical.WriteString(strings.TrimRight(line, " \t"))
+	}
+
+	// A Hash header, when present, must name the algorithm actually used
+	sig, err := checkTextSignature(keyring, canonical.Bytes(), block)
+	if err != nil {
+		return nil, err
+	}
+	if len(declared) > 0 && !declared[sig.Hash] {
+		return nil, fmt.Errorf("signature uses %s, which the Hash header doesn't declare", sig.Hash)
+	}
+	if sig.SigType != packet.SigTypeText {
+		return nil, fmt.Errorf("cleartext signature is not a text signature")
+	}
+
+	return []byte(strings.Join(message, "\n") + "\n"), nil
+}
+
+// checkTextSignature verifies the signature in block over data, which is
+// already in canonical text form
+func checkTextSignature(keyring openpgp.KeyRing, data []byte, block *armor.Block) (*packet.Signature, error) {
+	if block.Type != openpgp.SignatureType {
+		return nil, fmt.Errorf("expected a signature, got %s", block.Type)
+	}
+
+	// Read the signature packet first: go-crypto canonicalizes line
+	// endings of text signatures itself, which would turn the CRLFs
+	// above into CRCRLF, so the check below hashes the data as binary
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(block.Body); err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	p, err := packet.Read(bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok || sig.IssuerKeyId == nil {
+		return nil, fmt.Errorf("invalid signature packet")
+	}
+	if !sig.Hash.Available() {
+		return nil, fmt.Errorf("unsupported signature hash %d", sig.Hash)
+	}
+	// apt has refused SHA1 signatures since 1.4
+	if sig.Hash == crypto.SHA1 {
+		return nil, fmt.Errorf("signature uses SHA1, which is no longer accepted")
+	}
+
+	now := time.Now()
+	if sig.SigExpired(now) {
+		return nil, fmt.Errorf("signature expired")
+	}
+	for _, key := range keyring.KeysById(*sig.IssuerKeyId) {
+		h := sig.Hash.New()
+		h.Write(data)
+		if err := key.PublicKey.VerifySignature(h, sig); err != nil {
+			continue
+		}
+		if err := checkSigningKey(key, now); err != nil {
+			return nil, err
+		}
+		return sig, nil
+	}
+
+	return nil, fmt.Errorf("signature made by unknown key %X or invalid", *sig.IssuerKeyId)
+}
+
+// checkSigningKey reports why key, which made a valid signature, can't be
+// trusted at now, as gpgv does for expired, revoked and non-signing keys
+func checkSigningKey(key openpgp.Key, now time.Time) error {
+	name := fmt.Sprintf("key %s", key.PublicKey.KeyIdString())
+	subkey := key.Entity != nil && key.PublicKey != key.Entity.PrimaryKey
+	if subkey {
+		name = fmt.Sprintf("subkey %s", key.PublicKey.KeyIdString())
+	}
+
+	if key.Entity != nil {
+		if key.Entity.Revoked(now) {
+			return fmt.Errorf("signing key %s is revoked", key.Entity.PrimaryKey.KeyIdString())
+		}
+		ident := key.Entity.PrimaryIdentity()
+		if ident == nil || ident.SelfSignature == nil {
+			return fmt.Errorf("signing key %s has no self-signed user ID", key.Entity.PrimaryKey.KeyIdString())
+		}
+		if key.Entity.PrimaryKey.KeyExpired(ident.SelfSignature, now) {
+			return fmt.Errorf("signing key %s is expired", key.Entity.PrimaryKey.KeyIdString())
+		}
+	}
+	if subkey && key.Revoked(now) {
+		return fmt.Errorf("signing %s is revoked", name)
+	}
+	if key.SelfSignature == nil || !key.SelfSignature.FlagsValid || !key.SelfSignature.FlagSign {
+		return fmt.Errorf("signing %s is not allowed to sign", name)
+	}
+	if subkey && key.PublicKey.KeyExpired(key.SelfSignature, now) {
+		return fmt.Errorf("signing %s is expired", name)
+	}
+	return nil
+}
+
+// Keyring reads the public key of s, to verify the signatures it makes
+func Keyring(s Signer) (openpgp.EntityList, error) {
+	armored, err := s.GetPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key: %w", err)
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	return keyring, nil
+}
+
+// CheckCleartext verifies that signed, a cleartext signature s just made,
+// signs message and verifies against the public key of s, the way clients
+// will check it
+func CheckCleartext(s Signer, signed, message []byte) error {
+	keyring, err := Keyring(s)
+	if err != nil {
+		return err
+	}
+	text, err := VerifyCleartext(signed, keyring)
+	if err != nil {
+		return fmt.Errorf("signature doesn't verify against the signing key: %w", err)
+	}
+	if !bytes.Equal(text, append(bytes.TrimSuffix(message, []byte("\n")), '\n')) {
+		return fmt.Errorf("signed text differs from the message")
+	}
+	return nil
+}
+
+// CheckDetached verifies that signature, armored or binary, which s just
+// made over message, verifies against the public key of s
+func CheckDetached(s Signer, signature, message []byte) error {
+	keyring, err := Keyring(s)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN ")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(message), bytes.NewReader(signature), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(message), bytes.NewReader(signature), nil)
+	}
+	if err != nil {
+		return fmt.Errorf("signature doesn't verify against the signing key: %w", err)
+	}
+	return nil
+}
+
+// CheckRSA verifies that signature, which s just made over data with
+// SignRSA, verifies against the public key of s
+func CheckRSA(s RSASigner, signature, data []byte) error {
+	publicKeyPEM, err := s.GetPublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to get public key: %w", err)
+	}
+	block, _ := pem.Decode(publicKeyPEM)
+	if block == nil {
+		return fmt.Errorf("public key is not PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to read public key: %w", err)
+	}
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("public key is not an RSA key")
+	}
+
+	hashed := sha1.Sum(data)
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA1, hashed[:], signature); err != nil {
+		return fmt.Errorf("signature doesn't verify against the signing key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package signer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// newTestRSASigner signs with a new RSA key of bits bits
+func newTestRSASigner(t *testing.T, bits int) *AlpineRSASigner {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "test.rsa")
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600)
+	s, err := NewAlpineRSASigner(keyPath, "")
+	if err != nil {
+		t.Fatalf("NewAlpineRSASigner failed: %v", err)
+	}
+	return s
+}
+
+func TestSelfChecks(t *testing.T) {
+	s, _ := newTestRemoteSigner(t)
+	other, _ := newTestRemoteSigner(t)
+	message := []byte("Origin: Test\nLabel: Test\n")
+
+	cleartext, err := s.SignCleartext(message)
+	if err != nil {
+		t.Fatalf("SignCleartext failed: %v", err)
+	}
+	if err := CheckCleartext(s, cleartext, message); err != nil {
+		t.Errorf("CheckCleartext failed: %v", err)
+	}
+	if err := CheckCleartext(other, cleartext, message); err == nil {
+		t.Error("CheckCleartext accepted a signature made by another key")
+	}
+	if err := CheckCleartext(s, cleartext, []byte("Origin: Evil\n")); err == nil {
+		t.Error("CheckCleartext accepted a signature over another message")
+	}
+
+	armored, _ := s.SignDetached(message)
+	binary, _ := s.SignDetachedBinary(message)
+	for name, signature := range map[string][]byte{"armored": armored, "binary": binary} {
+		if err := CheckDetached(s, signature, message); err != nil {
+			t.Errorf("CheckDetached(%s) failed: %v", name, err)
+		}
+		if err := CheckDetached(other, signature, message); err == nil {
+			t.Errorf("CheckDetached(%s) accepted a signature made by another key", name)
+		}
+	}
+}
+
+func TestRSASelfCheck(t *testing.T) {
+	s, other := newTestRSASigner(t, 2048), newTestRSASigner(t, 2048)
+
+	data := []byte("APKINDEX")
+	signature, err := s.SignRSA(data)
+	if err != nil {
+		t.Fatalf("SignRSA failed: %v", err)
+	}
+	if err := CheckRSA(s, signature, data); err != nil {
+		t.Errorf("CheckRSA failed: %v", err)
+	}
+	if err := CheckRSA(other, signature, data); err == nil {
+		t.Error("CheckRSA accepted a signature made by another key")
+	}
+}
+
+// clearsignAt makes a cleartext signature of a one-line message with key,
+// dated at; by hand, as go-crypto no longer makes SHA1 ones
+func clearsignAt(t *testing.T, key *packet.PrivateKey, hash crypto.Hash, at time.Time, line string) []byte {
+	t.Helper()
+	sig := &packet.Signature{
+		Version:      4,
+		SigType:      packet.SigTypeText,
+		PubKeyAlgo:   key.PubKeyAlgo,
+		Hash:         hash,
+		CreationTime: at,
+		IssuerKeyId:  &key.KeyId,
+	}
+	h := hash.New()
+	h.Write([]byte(line))
+	if err := sig.Sign(h, key, nil); err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	var buf bytes.Buffer
+	for name, algo := range cleartextHashes {
+		if algo == hash {
+			buf.WriteString(cleartextHeader + "\nHash: " + name + "\n\n" + line + "\n")
+		}
+	}
+	w, _ := armor.Encode(&buf, openpgp.SignatureType, nil)
+	if err := sig.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestVerifyCleartextRejectsUnusableKeys(t *testing.T) {
+	now := time.Now()
+	newEntity := func(cfg *packet.Config) *openpgp.Entity {
+		cfg.RSABits = 2048
+		entity, err := openpgp.NewEntity("Repogen Test", "", "test@repogen.local", cfg)
+		if err != nil {
+			t.Fatalf("Failed to create key: %v", err)
+		}
+		return entity
+	}
+
+	valid := newEntity(&packet.Config{})
+	expired := newEntity(&packet.Config{Time: func() time.Time { return now.Add(-48 * time.Hour) }, KeyLifetimeSecs: 24 * 3600})
+	revoked := newEntity(&packet.Config{})
+	if err := revoked.RevokeKey(packet.KeyCompromised, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	revokedSubkey := newEntity(&packet.Config{})
+	if err := revokedSubkey.AddSigningSubkey(&packet.Config{RSABits: 2048}); err != nil {
+		t.Fatal(err)
+	}
+	subkey := &revokedSubkey.Subkeys[len(revokedSubkey.Subkeys)-1]
+	if err := revokedSubkey.RevokeSubkey(subkey, packet.KeyCompromised, "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		entity  *openpgp.Entity
+		key     *packet.PrivateKey
+		hash    crypto.Hash
+		at      time.Time
+		wantErr string
+	}{
+		{"valid", valid, valid.PrivateKey, crypto.SHA256, now, ""},
+		{"expired", expired, expired.PrivateKey, crypto.SHA256, now.Add(-47 * time.Hour), "is expired"},
+		{"revoked", revoked, revoked.PrivateKey, crypto.SHA256, now.Add(-time.Minute), "is revoked"},
+		{"revoked subkey", revokedSubkey, subkey.PrivateKey, crypto.SHA256, now.Add(-time.Minute), "is revoked"},
+		// The encryption subkey NewEntity adds
+		{"no sign flag", valid, valid.Subkeys[0].PrivateKey, crypto.SHA256, now, "is not allowed to sign"},
+		{"sha1", valid, valid.PrivateKey, crypto.SHA1, now, "SHA1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed := clearsignAt(t, tt.key, tt.hash, tt.at, "Origin: Test")
+			_, err := VerifyCleartext(signed, openpgp.EntityList{tt.entity})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("VerifyCleartext failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("VerifyCleartext = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+// Package sigstore signs repository metadata with Sigstore through the
+// cosign CLI, next to the signatures package managers verify, for
+// supply-chain verification workflows (cosign verify-blob, policy engines)
+package sigstore
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Signature and certificate suffixes, as cosign sign-blob writes them
+const (
+	SignatureExt   = ".sig"
+	CertificateExt = ".pem"
+)
+
+// metadataNames are the metadata files signed: the roots of trust of each
+// format, which checksum everything else
+var metadataNames = map[string]bool{
+	"Release":         true, // Debian
+	"repomd.xml":      true, // RPM
+	"APKINDEX.tar.gz": true, // Alpine
+	"SHA256SUMS":      true, // Package files, for direct downloads
+}
+
+// Signer signs files with cosign sign-blob
+type Signer struct {
+	Key      string // cosign private key file or KMS URI (e.g. awskms:///alias/release); keyless with a Fulcio certificate when empty
+	RekorURL string // Rekor instance, the public one when empty
+	NoTlog   bool   // Don't record the signatures in the Rekor transparency log
+	Binary   string // cosign executable, "cosign" when empty
+}
+
+// Check verifies that cosign can be run
+func (s *Signer) Check() error {
+	if _, err := exec.LookPath(s.binary()); err != nil {
+		return fmt.Errorf("cosign not found: %w", err)
+	}
+	return nil
+}
+
+// SignFile signs path into path.sig, and path.pem holding the certificate
+// of keyless signatures, and returns the files written
+func (s *Signer) SignFile(path string) ([]string, error) {
+	cmd := exec.Command(s.binary(), s.args(path)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("cosign sign-blob %s failed: %w\nOutput: %s", path, err, stderr.String())
+	}
+
+	written := []string{path + SignatureExt}
+	if s.Key == "" {
+		written = append(written, path+CertificateExt)
+	}
+	return written, nil
+}
+
+// UpToDate reports whether path was signed since it last changed, so runs
+// leaving it alone don't record it in the transparency log again
+func UpToDate(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	sig, err := os.Stat(path + SignatureExt)
+	return err == nil && !sig.ModTime().Before(info.ModTime())
+}
+
+// args returns the cosign arguments signing path
+func (s *Signer) args(path string) []string {
+	args := []string{"sign-blob", "--yes", "--output-signature", path + SignatureExt}
+	if s.Key != "" {
+		args = append(args, "--key", s.Key)
+	} else {
+		args = append(args, "--output-certificate", path+CertificateExt)
+	}
+	if s.NoTlog {
+		args = append(args, "--tlog-upload=false")
+	} else if s.RekorURL != "" {
+		args = append(args, "--rekor-url", s.RekorURL)
+	}
+	return append(args, path)
+}
+
+func (s *Signer) binary() string {
+	if s.Binary != "" {
+		return s.Binary
+	}
+	return "cosign"
+}
+
+// MetadataFiles returns the metadata files of the repository in repoDir to
+// sign, skipping the directories of skip (e.g. snapshots) relative to it
+func MetadataFiles(repoDir string, skip ...string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(repoDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(repoDir, path)
+		if d.IsDir() {
+			for _, dir := range skip {
+				if rel == dir {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !metadataNames[d.Name()] || utils.IsInternalPath(filepath.ToSlash(rel)) {
+			return nil
+		}
+		// Only the Release of suites, not those of components
+		if d.Name() == "Release" && !suiteRelease(filepath.ToSlash(rel)) {
+			return nil
+		}
+		if d.Name() == "SHA256SUMS" && rel != "SHA256SUMS" {
+			return nil
+		}
+		files = append(files, path)
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// suiteRelease reports whether the Release file at rel describes a suite,
+// or a flat repository
+func suiteRelease(rel string) bool {
+	if rel == "Release" {
+		return true
+	}
+	matched, _ := path.Match("dists/*/Release", rel)
+	return matched
+}
@@ -0,0 +1,118 @@
+package sigstore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArgs(t *testing.T) {
+	tests := []struct {
+		signer Signer
+		want   string
+	}{
+		{Signer{}, "sign-blob --yes --output-signature Release.sig --output-certificate Release.pem Release"},
+		{Signer{Key: "cosign.key", NoTlog: true}, "sign-blob --yes --output-signature Release.sig --key cosign.key --tlog-upload=false Release"},
+		{Signer{Key: "awskms:///alias/release", RekorURL: "https://rekor.example.com"}, "sign-blob --yes --output-signature Release.sig --key awskms:///alias/release --rekor-url https://rekor.example.com Release"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(tt.signer.args("Release"), " "); got != tt.want {
+			t.Errorf("args(%+v) = %q, want %q", tt.signer, got, tt.want)
+		}
+	}
+}
+
+func TestMetadataFiles(t *testing.T) {
+	repo := t.TempDir()
+	for _, name := range []string{
+		"dists/stable/Release",
+		"dists/stable/main/binary-amd64/Release",
+		"Release",
+		"40/x86_64/repodata/repomd.xml",
+		"x86_64/APKINDEX.tar.gz",
+		"SHA256SUMS",
+		"v1.0/SHA256SUMS",
+		"snapshots/v1/dists/stable/Release",
+		".repogen/Release",
+	} {
+		path := filepath.Join(repo, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := MetadataFiles(repo, "snapshots")
+	if err != nil {
+		t.Fatalf("MetadataFiles failed: %v", err)
+	}
+	var rel []string
+	for _, f := range files {
+		r, _ := filepath.Rel(repo, f)
+		rel = append(rel, filepath.ToSlash(r))
+	}
+	want := []string{"40/x86_64/repodata/repomd.xml", "Release", "SHA256SUMS", "dists/stable/Release", "x86_64/APKINDEX.tar.gz"}
+	if !reflect.DeepEqual(rel, want) {
+		t.Errorf("MetadataFiles = %v, want %v", rel, want)
+	}
+}
+
+func TestSignFile(t *testing.T) {
+	dir := t.TempDir()
+
+	// A cosign writing the files it is asked for
+	cosign := filepath.Join(dir, "cosign")
+	script := `#!/bin/sh
+while [ $# -gt 1 ]; do
+	case "$1" in
+	--output-signature) echo signature > "$2" ;;
+	--output-certificate) echo certificate > "$2" ;;
+	esac
+	shift
+done
+`
+	if err := os.WriteFile(cosign, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s := &Signer{Binary: cosign}
+	if err := s.Check(); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	release := filepath.Join(dir, "Release")
+	os.WriteFile(release, []byte("Origin: Test\n"), 0644)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(release, old, old)
+	if UpToDate(release) {
+		t.Error("Unsigned file is up to date")
+	}
+
+	written, err := s.SignFile(release)
+	if err != nil {
+		t.Fatalf("SignFile failed: %v", err)
+	}
+	if !reflect.DeepEqual(written, []string{release + ".sig", release + ".pem"}) {
+		t.Errorf("SignFile wrote %v", written)
+	}
+	for _, path := range written {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s missing: %v", path, err)
+		}
+	}
+	if !UpToDate(release) {
+		t.Error("Signed file is not up to date")
+	}
+
+	// Rewriting the file calls for a new signature
+	os.Chtimes(release, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	if UpToDate(release) {
+		t.Error("Changed file is up to date")
+	}
+
+	if _, err := (&Signer{Binary: filepath.Join(dir, "missing")}).SignFile(release); err == nil {
+		t.Error("SignFile succeeded without cosign")
+	}
+}
@@ -0,0 +1,343 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ralt/repogen/internal/lock"
+	"github.com/ralt/repogen/internal/manifest"
+	"github.com/ralt/repogen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Dir holds the snapshots of a repository, relative to its root. Each one
+// is a complete repository that can be served as is
+const Dir = "snapshots"
+
+// InfoPath describes a snapshot, relative to its root. It is kept when the
+// snapshot is published, recording what the published tree is
+const InfoPath = ".repogen/snapshot.json"
+
+// validName keeps snapshot names usable as a single path segment and URL
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+// Info describes a snapshot
+type Info struct {
+	Name     string    `json:"name"`
+	Created  time.Time `json:"created"`
+	Packages int       `json:"packages"`
+	Files    int       `json:"files"`
+}
+
+// ValidateName checks that name can name a snapshot
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid snapshot name %q: expected letters, digits, '.', '_', '+' and '-'", name)
+	}
+	return nil
+}
+
+// Create captures the repository in repoDir as the snapshot name, created
+// at created. Package files are hardlinked, so a snapshot only costs the
+// space of its metadata, which is copied as generations rewrite it in place
+func Create(repoDir, name string, created time.Time) (*Info, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	dest := filepath.Join(repoDir, Dir, name)
+	if _, err := os.Lstat(dest); err == nil {
+		return nil, fmt.Errorf("snapshot %q already exists", name)
+	}
+
+	packages, err := packageFiles(repoDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Join(repoDir, Dir), 0755); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(filepath.Join(repoDir, Dir), "."+name+".create-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	// Snapshots and the state of runs writing the repository aren't part of it
+	files, err := clone(repoDir, staging, packages, func(rel string) bool {
+		return rel == Dir || utils.IsInternalPath(rel)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{Name: name, Created: created.UTC(), Packages: len(packages), Files: files}
+	if err := writeInfo(staging, info); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(staging, dest); err != nil {
+		return nil, fmt.Errorf("failed to move snapshot into %s: %w", dest, err)
+	}
+	return info, nil
+}
+
+// List returns the snapshots of the repository in repoDir, oldest first
+func List(repoDir string) ([]Info, error) {
+	entries, err := os.ReadDir(filepath.Join(repoDir, Dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Info
+	for _, entry := range entries {
+		// Staging directories of snapshots being created are hidden
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := readInfo(filepath.Join(repoDir, Dir, entry.Name()))
+		if err != nil {
+			logrus.Warnf("Skipping snapshot %s: %v", entry.Name(), err)
+			continue
+		}
+		info.Name = entry.Name()
+		snapshots = append(snapshots, *info)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		if !snapshots[i].Created.Equal(snapshots[j].Created) {
+			return snapshots[i].Created.Before(snapshots[j].Created)
+		}
+		return snapshots[i].Name < snapshots[j].Name
+	})
+	return snapshots, nil
+}
+
+// Publish makes the snapshot name of the repository in repoDir the
+// repository served from dest, built in a staging directory next to it and
+// swapped into place. dest may be repoDir itself, rolling it back to the
+// snapshot while keeping its snapshots. An existing, non-empty dest is only
+// replaced when it holds a repository
+func Publish(repoDir, name, dest string) (*Info, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	src := filepath.Join(repoDir, Dir, name)
+	info, err := readInfo(src)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %q not found: %w", name, err)
+	}
+	info.Name = name
+
+	absRepo, err := filepath.Abs(repoDir)
+	if err != nil {
+		return nil, err
+	}
+	absDest, err := filepath.Abs(dest)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(filepath.Join(absRepo, Dir), absDest); err == nil && !strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("can't publish into the snapshots of %s", repoDir)
+	}
+	rollback := absDest == absRepo
+
+	if !rollback && !replaceable(absDest) {
+		return nil, fmt.Errorf("%s is not empty and holds no repository", dest)
+	}
+
+	packages, err := packageFiles(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(absDest), 0755); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(absDest), "."+filepath.Base(absDest)+".publish-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	// The published tree is copied too, so regenerating it leaves the
+	// snapshot as it was
+	if _, err := clone(src, staging, packages, func(rel string) bool { return false }); err != nil {
+		return nil, err
+	}
+
+	// The lock held on dest carries over, and rolling back keeps the snapshots
+	kept := []string{lock.Path}
+	if rollback {
+		kept = append(kept, Dir)
+	}
+	for _, rel := range kept {
+		if err := os.Rename(filepath.Join(absDest, rel), filepath.Join(staging, rel)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			restore(kept, staging, absDest)
+			return nil, fmt.Errorf("failed to move %s aside: %w", rel, err)
+		}
+	}
+
+	// Swap the published tree into place
+	old := ""
+	if _, err := os.Stat(absDest); err == nil {
+		old = staging + ".old"
+		if err := os.Rename(absDest, old); err != nil {
+			restore(kept, staging, absDest)
+			return nil, fmt.Errorf("failed to move %s aside: %w", dest, err)
+		}
+	}
+	if err := os.Rename(staging, absDest); err != nil {
+		if old != "" {
+			os.Rename(old, absDest)
+		}
+		restore(kept, staging, absDest)
+		return nil, fmt.Errorf("failed to move snapshot into %s: %w", dest, err)
+	}
+	if old != "" {
+		if err := os.RemoveAll(old); err != nil {
+			logrus.Warnf("Failed to remove previous repository %s: %v", old, err)
+		}
+	}
+
+	return info, nil
+}
+
+// replaceable reports whether publishing may replace dir: when it is
+// missing, empty but for its lock, or holds a repository
+func replaceable(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return true
+	}
+	for _, entry := range entries {
+		if entry.Name() == ".repogen" {
+			return true
+		}
+	}
+	return len(entries) == 0 || len(entries) == 1 && entries[0].Name() == lock.Path
+}
+
+// restore moves the files kept from dest back after a failed publish
+func restore(kept []string, staging, dest string) {
+	for _, rel := range kept {
+		os.Rename(filepath.Join(staging, rel), filepath.Join(dest, rel))
+	}
+}
+
+// packageFiles returns the package files listed in the manifest of the
+// repository in dir, relative to it. Without a manifest every file is copied
+func packageFiles(dir string) (map[string]bool, error) {
+	m, err := manifest.Read(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		logrus.Warnf("%s has no package manifest: copying its packages instead of linking them", dir)
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]bool, len(m.Packages))
+	for _, entry := range m.Packages {
+		if entry.Path != "" {
+			files[filepath.FromSlash(entry.Path)] = true
+		}
+	}
+	return files, nil
+}
+
+// clone recreates the tree below src in dst, hardlinking the files of
+// linked and copying the others, and returns the number of files. Paths
+// relative to src for which skip is true are left out
+func clone(src, dst string, linked map[string]bool, skip func(rel string) bool) (int, error) {
+	files := 0
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if skip(filepath.ToSlash(rel)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case d.Type()&fs.ModeSymlink != 0:
+			// Links such as Pacman's myrepo.db are relative, and stay valid
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !d.Type().IsRegular():
+			return nil
+		}
+
+		files++
+		if linked[rel] {
+			if err := os.Link(p, target); err == nil {
+				return nil
+			}
+			logrus.Debugf("Failed to link %s, copying it", p)
+		}
+		return copyFile(p, target)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return files, nil
+}
+
+// copyFile copies src to dst, keeping its modification time so the
+// metadata served from a snapshot keeps its Last-Modified date
+func copyFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := utils.CopyFile(context.Background(), src, dst); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+func readInfo(dir string) (*Info, error) {
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(InfoPath)))
+	if err != nil {
+		return nil, err
+	}
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("invalid snapshot description: %w", err)
+	}
+	return &info, nil
+}
+
+func writeInfo(dir string, info *Info) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFile(filepath.Join(dir, filepath.FromSlash(InfoPath)), append(data, '\n'), 0644)
+}
@@ -0,0 +1,162 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ralt/repogen/internal/lock"
+	"github.com/ralt/repogen/internal/manifest"
+)
+
+// writeRepo creates a repository in dir publishing pool/hello_1.0.deb
+func writeRepo(t *testing.T, dir, release string) {
+	t.Helper()
+	files := map[string]string{
+		"pool/hello_1.0.deb":   "package",
+		"dists/stable/Release": release,
+		lock.Path:              "",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("stable", filepath.Join(dir, "dists", "bookworm")); err != nil {
+		t.Fatal(err)
+	}
+	entries := []manifest.Entry{{Name: "hello", Version: "1.0", Path: "pool/hello_1.0.deb"}}
+	if err := manifest.Record(dir, "deb", entries, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCreate(t *testing.T) {
+	repo := t.TempDir()
+	writeRepo(t, repo, "v1")
+
+	info, err := Create(repo, "v1", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if info.Packages != 1 || info.Files != 3 {
+		t.Errorf("Info is %+v", info)
+	}
+
+	snap := filepath.Join(repo, Dir, "v1")
+
+	// Packages are linked, metadata is copied
+	pkgInfo, _ := os.Stat(filepath.Join(repo, "pool", "hello_1.0.deb"))
+	snapPkgInfo, err := os.Stat(filepath.Join(snap, "pool", "hello_1.0.deb"))
+	if err != nil || !os.SameFile(pkgInfo, snapPkgInfo) {
+		t.Errorf("Package file is not linked: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "dists", "stable", "Release"), []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(snap, "dists", "bookworm", "Release")); got != "v1" {
+		t.Errorf("Snapshot Release is %q after the repository changed", got)
+	}
+	if _, err := os.Lstat(filepath.Join(snap, lock.Path)); err == nil {
+		t.Error("Snapshot holds the lock file")
+	}
+
+	// Snapshots are immutable
+	if _, err := Create(repo, "v1", time.Now()); err == nil {
+		t.Error("Creating an existing snapshot succeeded")
+	}
+	if _, err := Create(repo, "../v1", time.Now()); err == nil {
+		t.Error("Creating a snapshot named ../v1 succeeded")
+	}
+
+	// A later snapshot doesn't include the earlier ones
+	if _, err := Create(repo, "v2", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(repo, Dir, "v2", Dir)); err == nil {
+		t.Error("Snapshot v2 includes snapshots")
+	}
+
+	snapshots, err := List(repo)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name != "v1" || snapshots[1].Name != "v2" {
+		t.Errorf("List returned %+v", snapshots)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	repo := t.TempDir()
+	writeRepo(t, repo, "v1")
+	if _, err := Create(repo, "v1", time.Now()); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "dists", "stable", "Release"), []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Publishing to another directory
+	dest := filepath.Join(t.TempDir(), "public")
+	if _, err := Publish(repo, "v1", dest); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if got := readFile(t, filepath.Join(dest, "dists", "stable", "Release")); got != "v1" {
+		t.Errorf("Published Release is %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dest, filepath.FromSlash(InfoPath))); err != nil {
+		t.Errorf("Published tree doesn't record its snapshot: %v", err)
+	}
+
+	// Republishing replaces the published tree, but not unrelated directories
+	if _, err := Publish(repo, "v1", dest); err != nil {
+		t.Errorf("Republishing failed: %v", err)
+	}
+	other := t.TempDir()
+	if err := os.WriteFile(filepath.Join(other, "notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Publish(repo, "v1", other); err == nil || !strings.Contains(err.Error(), "holds no repository") {
+		t.Errorf("Publishing over an unrelated directory returned %v", err)
+	}
+	if _, err := Publish(repo, "v1", filepath.Join(repo, Dir, "v2")); err == nil {
+		t.Error("Publishing into the snapshots succeeded")
+	}
+	if _, err := Publish(repo, "missing", dest); err == nil {
+		t.Error("Publishing a missing snapshot succeeded")
+	}
+
+	// Rolling the repository back keeps its snapshots
+	if _, err := Publish(repo, "v1", repo); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if got := readFile(t, filepath.Join(repo, "dists", "stable", "Release")); got != "v1" {
+		t.Errorf("Rolled back Release is %q", got)
+	}
+	if snapshots, err := List(repo); err != nil || len(snapshots) != 1 {
+		t.Errorf("Snapshots after rollback are %+v: %v", snapshots, err)
+	}
+
+	// Regenerating the published tree leaves the snapshot as it was
+	if err := os.WriteFile(filepath.Join(repo, "dists", "stable", "Release"), []byte("v3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(repo, Dir, "v1", "dists", "stable", "Release")); got != "v1" {
+		t.Errorf("Snapshot Release is %q after regenerating the rolled back repository", got)
+	}
+}
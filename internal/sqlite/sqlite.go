@@ -0,0 +1,338 @@
+// Package sqlite writes SQLite database files, for metadata formats that
+// ship databases rather than documents. Tables are written once, in full:
+// there is no query engine, no indexes and no updates
+package sqlite
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	pageSize   = 4096
+	headerSize = 100 // Database header, at the start of page 1
+
+	// Payload kept on a leaf page before spilling to overflow pages, as
+	// defined by the file format for table b-trees
+	maxLocal = pageSize - 35
+	minLocal = (pageSize-12)*32/255 - 23
+
+	leafPage     = 0x0d
+	interiorPage = 0x05
+)
+
+// Database is a database built in memory
+type Database struct {
+	tables []*Table
+}
+
+// Table is a table of a Database
+type Table struct {
+	name string
+	sql  string
+	rows [][]byte
+}
+
+// New creates an empty database
+func New() *Database {
+	return &Database{}
+}
+
+// CreateTable adds a table; sql is its CREATE TABLE statement
+func (db *Database) CreateTable(name, sql string) *Table {
+	t := &Table{name: name, sql: sql}
+	db.tables = append(db.tables, t)
+	return t
+}
+
+// Insert appends a row to the table. Values are nil, integers or strings.
+// Row ids count from 1, and an INTEGER PRIMARY KEY column is an alias of
+// the row id, so its value must be nil
+func (t *Table) Insert(values ...interface{}) error {
+	record, err := encodeRecord(values)
+	if err != nil {
+		return fmt.Errorf("table %s: %w", t.name, err)
+	}
+	t.rows = append(t.rows, record)
+	return nil
+}
+
+// Bytes returns the database file
+func (db *Database) Bytes() ([]byte, error) {
+	w := &writer{pages: [][]byte{make([]byte, pageSize)}} // Page 1 holds the schema
+
+	master := &Table{}
+	for _, t := range db.tables {
+		root := w.writeTree(t.rows, 0)
+		if err := master.Insert("table", t.name, t.name, int64(root), t.sql); err != nil {
+			return nil, err
+		}
+	}
+	w.writeTree(master.rows, 1)
+
+	file := make([]byte, 0, len(w.pages)*pageSize)
+	for _, page := range w.pages {
+		file = append(file, page...)
+	}
+	writeHeader(file, len(w.pages))
+	return file, nil
+}
+
+// writeHeader fills in the database header
+func writeHeader(file []byte, pages int) {
+	copy(file, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(file[16:], pageSize)
+	file[18] = 1 // Legacy (rollback journal) file format
+	file[19] = 1
+	file[21] = 64 // Payload fractions, fixed by the format
+	file[22] = 32
+	file[23] = 32
+	binary.BigEndian.PutUint32(file[24:], 1)             // File change counter
+	binary.BigEndian.PutUint32(file[28:], uint32(pages)) // Database size in pages
+	binary.BigEndian.PutUint32(file[40:], 1)             // Schema cookie
+	binary.BigEndian.PutUint32(file[44:], 4)             // Schema format
+	binary.BigEndian.PutUint32(file[56:], 1)             // UTF-8
+	binary.BigEndian.PutUint32(file[92:], 1)             // Version-valid-for, matches the change counter
+	binary.BigEndian.PutUint32(file[96:], 3040001)       // SQLite version number
+}
+
+// writer lays out pages, numbered from 1
+type writer struct {
+	pages [][]byte
+}
+
+// allocate adds an empty page and returns its number
+func (w *writer) allocate() int {
+	w.pages = append(w.pages, make([]byte, pageSize))
+	return len(w.pages)
+}
+
+// node is a b-tree page being filled
+type node struct {
+	cells     [][]byte
+	size      int
+	maxKey    int64
+	rightmost int
+}
+
+// writeTree writes a table b-tree holding records, the row ids counting
+// from 1, and returns its root page. The root is written to page root if
+// it isn't 0
+func (w *writer) writeTree(records [][]byte, root int) int {
+	// Page 1 starts with the database header, so every page of a tree
+	// that might end up there leaves room for it
+	capacity := pageSize
+	if root == 1 {
+		capacity -= headerSize
+	}
+
+	var level []*node
+	current := &node{}
+	for i, record := range records {
+		cell := w.leafCell(int64(i+1), record)
+		if len(current.cells) > 0 && current.size+len(cell)+2 > capacity-8 {
+			level = append(level, current)
+			current = &node{}
+		}
+		current.cells = append(current.cells, cell)
+		current.size += len(cell) + 2
+		current.maxKey = int64(i + 1)
+	}
+	level = append(level, current)
+
+	kind := byte(leafPage)
+	for {
+		if len(level) == 1 {
+			number := root
+			if number == 0 {
+				number = w.allocate()
+			}
+			w.writePage(number, kind, level[0])
+			return number
+		}
+
+		// Write this level and build the interior level above it: every
+		// child but the last of a page gets a cell keyed by its largest
+		// row id, the last is the right-most pointer
+		var parents []*node
+		parent := &node{}
+		for i, n := range level {
+			number := w.allocate()
+			w.writePage(number, kind, n)
+
+			if parent.rightmost != 0 {
+				cell := interiorCell(parent.rightmost, parent.maxKey)
+				if parent.size+len(cell)+2 > capacity-12 {
+					parents = append(parents, parent)
+					parent = &node{}
+				} else {
+					parent.cells = append(parent.cells, cell)
+					parent.size += len(cell) + 2
+				}
+			}
+			parent.rightmost = number
+			parent.maxKey = n.maxKey
+			if i == len(level)-1 {
+				parents = append(parents, parent)
+			}
+		}
+		level = parents
+		kind = interiorPage
+	}
+}
+
+// writePage writes the cells of n to page number, in order, the cell
+// content growing down from the end of the page
+func (w *writer) writePage(number int, kind byte, n *node) {
+	page := w.pages[number-1]
+	offset := 0
+	if number == 1 {
+		offset = headerSize
+	}
+
+	header := 8
+	if kind == interiorPage {
+		header = 12
+		binary.BigEndian.PutUint32(page[offset+8:], uint32(n.rightmost))
+	}
+	page[offset] = kind
+
+	content := pageSize
+	pointer := offset + header
+	for _, cell := range n.cells {
+		content -= len(cell)
+		copy(page[content:], cell)
+		binary.BigEndian.PutUint16(page[pointer:], uint16(content))
+		pointer += 2
+	}
+	binary.BigEndian.PutUint16(page[offset+3:], uint16(len(n.cells)))
+	binary.BigEndian.PutUint16(page[offset+5:], uint16(content))
+}
+
+// leafCell returns the cell of a row, spilling the end of a large record to
+// a chain of overflow pages
+func (w *writer) leafCell(rowid int64, record []byte) []byte {
+	cell := appendVarint(nil, uint64(len(record)))
+	cell = appendVarint(cell, uint64(rowid))
+
+	local := len(record)
+	if local > maxLocal {
+		local = minLocal + (len(record)-minLocal)%(pageSize-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	cell = append(cell, record[:local]...)
+	if local == len(record) {
+		return cell
+	}
+
+	first := 0
+	var previous []byte
+	for rest := record[local:]; len(rest) > 0; {
+		number := w.allocate()
+		page := w.pages[number-1]
+		if previous == nil {
+			first = number
+		} else {
+			binary.BigEndian.PutUint32(previous, uint32(number))
+		}
+		n := copy(page[4:], rest)
+		rest = rest[n:]
+		previous = page
+	}
+	return binary.BigEndian.AppendUint32(cell, uint32(first))
+}
+
+// interiorCell returns the cell pointing to child, whose row ids are at
+// most key
+func interiorCell(child int, key int64) []byte {
+	cell := binary.BigEndian.AppendUint32(nil, uint32(child))
+	return appendVarint(cell, uint64(key))
+}
+
+// encodeRecord encodes values in the record format: a header of serial
+// types, then the values
+func encodeRecord(values []interface{}) ([]byte, error) {
+	var types, body []byte
+	for _, value := range values {
+		switch v := value.(type) {
+		case nil:
+			types = appendVarint(types, 0)
+		case int:
+			types, body = appendInteger(types, body, int64(v))
+		case int64:
+			types, body = appendInteger(types, body, v)
+		case bool:
+			var i int64
+			if v {
+				i = 1
+			}
+			types, body = appendInteger(types, body, i)
+		case string:
+			types = appendVarint(types, uint64(len(v))*2+13)
+			body = append(body, v...)
+		default:
+			return nil, fmt.Errorf("unsupported value type %T", value)
+		}
+	}
+
+	// The header size counts its own varint
+	size := len(types) + 1
+	for len(appendVarint(nil, uint64(size)))+len(types) != size {
+		size++
+	}
+	record := appendVarint(nil, uint64(size))
+	record = append(record, types...)
+	return append(record, body...), nil
+}
+
+// appendInteger encodes an integer with the smallest serial type holding it
+func appendInteger(types, body []byte, v int64) ([]byte, []byte) {
+	switch {
+	case v == 0:
+		return appendVarint(types, 8), body
+	case v == 1:
+		return appendVarint(types, 9), body
+	}
+
+	sizes := []struct {
+		serial uint64
+		bytes  int
+	}{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 6}}
+	for _, s := range sizes {
+		limit := int64(1) << (s.bytes*8 - 1)
+		if v >= -limit && v < limit {
+			for i := s.bytes - 1; i >= 0; i-- {
+				body = append(body, byte(v>>(i*8)))
+			}
+			return appendVarint(types, s.serial), body
+		}
+	}
+	return appendVarint(types, 6), binary.BigEndian.AppendUint64(body, uint64(v))
+}
+
+// appendVarint appends v as a big-endian variable length integer: 7 bits
+// a byte with the high bit set on all but the last, and a 9th byte holding
+// 8 bits
+func appendVarint(b []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+
+	var buf [8]byte
+	i := len(buf) - 1
+	buf[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		buf[i] = byte(v&0x7f) | 0x80
+	}
+	return append(b, buf[i:]...)
+}
@@ -0,0 +1,67 @@
+package sqlite
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDatabaseReadBySQLite(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not available")
+	}
+
+	db := New()
+	info := db.CreateTable("info", "CREATE TABLE info (version INTEGER, checksum TEXT)")
+	if err := info.Insert(10, "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Enough rows for interior pages, and a value needing overflow pages
+	items := db.CreateTable("items", "CREATE TABLE items (key INTEGER PRIMARY KEY, name TEXT, size INTEGER, note TEXT)")
+	for i := 0; i < 5000; i++ {
+		note := interface{}(nil)
+		if i == 1234 {
+			note = strings.Repeat("long ", 3000)
+		}
+		if err := items.Insert(nil, "item"+strings.Repeat("x", i%50), int64(i)*100000, note); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.CreateTable("empty", "CREATE TABLE empty (name TEXT)")
+
+	data, err := db.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(sql string) string {
+		out, err := exec.Command("sqlite3", path, sql).CombinedOutput()
+		if err != nil {
+			t.Fatalf("sqlite3 %q failed: %v\n%s", sql, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	if got := query("PRAGMA integrity_check"); got != "ok" {
+		t.Errorf("Integrity check failed: %s", got)
+	}
+	if got := query("SELECT version, checksum FROM info"); got != "10|abc" {
+		t.Errorf("Unexpected info row %q", got)
+	}
+	if got := query("SELECT count(*), max(key), sum(size) FROM items"); got != "5000|5000|1249750000000" {
+		t.Errorf("Unexpected items summary %q", got)
+	}
+	if got := query("SELECT key, length(note) FROM items WHERE note IS NOT NULL"); got != "1235|15000" {
+		t.Errorf("Unexpected overflow row %q", got)
+	}
+	if got := query("SELECT count(*) FROM empty"); got != "0" {
+		t.Errorf("Unexpected empty table count %q", got)
+	}
+}
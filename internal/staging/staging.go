@@ -0,0 +1,106 @@
+// Package staging lists the files of a repository generated in a local
+// staging directory, and orders their upload to where it is served from
+package staging
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ralt/repogen/internal/sigstore"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// signatureExts are the suffixes of signatures of other files
+var signatureExts = []string{".gpg", ".asc", ".sig", ".minisig", ".pem"}
+
+// Result counts what a transfer did
+type Result struct {
+	Transferred int   // Files uploaded or downloaded
+	Unchanged   int   // Files already the same on both sides
+	Removed     int   // Files removed as the other side doesn't have them
+	Bytes       int64 // Size of the files transferred
+}
+
+// Stages orders the upload of files, slash-separated paths relative to
+// localDir: packages (and their signatures and checksums), then the other
+// files, then the metadata signed at the root of each repository, then
+// the signatures of other files. Each stage is only uploaded once the
+// previous one is complete
+func Stages(localDir string, files []string, packages []string) ([][]string, error) {
+	isPackage := make(map[string]bool)
+	for _, p := range packages {
+		isPackage[p] = true
+	}
+	exists := make(map[string]bool)
+	for _, f := range files {
+		exists[f] = true
+	}
+	roots, err := sigstore.MetadataFiles(localDir)
+	if err != nil {
+		return nil, err
+	}
+	isRoot := make(map[string]bool)
+	for _, r := range roots {
+		rel, _ := filepath.Rel(localDir, r)
+		isRoot[filepath.ToSlash(rel)] = true
+	}
+
+	stages := make([][]string, 4)
+	for _, f := range files {
+		base := f
+		if ext := path.Ext(f); ext != "" && exists[strings.TrimSuffix(f, ext)] {
+			base = strings.TrimSuffix(f, ext)
+		}
+		switch {
+		case isPackage[f] || isPackage[base]:
+			stages[0] = append(stages[0], f)
+		case isRoot[f]:
+			stages[2] = append(stages[2], f)
+		case path.Base(f) == "InRelease" || (base != f && isSignatureExt(path.Ext(f))):
+			stages[3] = append(stages[3], f)
+		default:
+			stages[1] = append(stages[1], f)
+		}
+	}
+	for _, stage := range stages {
+		sort.Strings(stage)
+	}
+	return stages, nil
+}
+
+func isSignatureExt(ext string) bool {
+	for _, e := range signatureExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Files lists the files of the repository in dir, as slash-separated
+// relative paths, leaving out the lock and parse cache of runs writing it
+func Files(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		if d.IsDir() || utils.IsInternalPath(filepath.ToSlash(rel)) {
+			return nil
+		}
+		// Symlinked files (e.g. Pacman's myrepo.db) are uploaded as copies
+		info, err := os.Stat(p)
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
@@ -0,0 +1,71 @@
+package staging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/lock"
+)
+
+func writeFile(t *testing.T, dir, rel, data string) {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(rel))
+	os.MkdirAll(filepath.Dir(p), 0755)
+	if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStages(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		"dists/stable/InRelease",
+		"dists/stable/Release",
+		"dists/stable/Release.gpg",
+		"dists/stable/main/binary-amd64/Packages",
+		"keys/repo.asc",
+		"pool/main/h/hello.deb",
+		"pool/main/h/hello.deb.sha256",
+		"x86_64/repo.db",
+		"x86_64/repo.db.sig",
+		"x86_64/hello.pkg.tar.zst",
+		"x86_64/hello.pkg.tar.zst.sig",
+	}
+	for _, f := range files {
+		writeFile(t, dir, f, f)
+	}
+
+	stages, err := Stages(dir, files, []string{"pool/main/h/hello.deb", "x86_64/hello.pkg.tar.zst"})
+	if err != nil {
+		t.Fatalf("Stages failed: %v", err)
+	}
+	want := [][]string{
+		{"pool/main/h/hello.deb", "pool/main/h/hello.deb.sha256", "x86_64/hello.pkg.tar.zst", "x86_64/hello.pkg.tar.zst.sig"},
+		{"dists/stable/main/binary-amd64/Packages", "keys/repo.asc", "x86_64/repo.db"},
+		{"dists/stable/Release"},
+		{"dists/stable/InRelease", "dists/stable/Release.gpg", "x86_64/repo.db.sig"},
+	}
+	for i := range want {
+		if strings.Join(stages[i], " ") != strings.Join(want[i], " ") {
+			t.Errorf("Stage %d = %v, want %v", i, stages[i], want[i])
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "pool/hello.deb", "package")
+	writeFile(t, dir, "dists/stable/Release", "Release")
+	writeFile(t, dir, lock.Path, "")
+	os.Symlink("hello.deb", filepath.Join(dir, "pool/latest.deb"))
+
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	if got := strings.Join(files, " "); got != "dists/stable/Release pool/hello.deb pool/latest.deb" {
+		t.Errorf("Files = %s", got)
+	}
+}
@@ -0,0 +1,268 @@
+// Package stats summarizes the packages published in a repository: how many
+// there are and how much space they take per package type, which packages
+// take the most, which keep several versions, how old their builds are and
+// which architectures they cover
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ralt/repogen/internal/models"
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Build is a package built at a known time
+type Build struct {
+	Type         string    `json:"type"`
+	Name         string    `json:"name"`
+	Version      string    `json:"version"`
+	Architecture string    `json:"arch,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// Arch counts the packages of an architecture of a package type
+type Arch struct {
+	Architecture string   `json:"arch"`
+	Packages     int      `json:"packages"`
+	Missing      []string `json:"missing,omitempty"` // Packages other architectures of the type have
+}
+
+// Type summarizes the packages of a package type
+type Type struct {
+	Type          string `json:"type"`
+	Packages      int    `json:"packages"`
+	Size          int64  `json:"size"`
+	Architectures []Arch `json:"architectures,omitempty"`
+	Oldest        *Build `json:"oldest,omitempty"`
+	Newest        *Build `json:"newest,omitempty"`
+}
+
+// Package is the space taken by all the versions and architectures of a
+// package
+type Package struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Versions int    `json:"versions"`
+	Files    int    `json:"files"`
+	Size     int64  `json:"size"`
+}
+
+// Duplicate is a package published in several versions for an architecture
+type Duplicate struct {
+	Type         string   `json:"type"`
+	Name         string   `json:"name"`
+	Architecture string   `json:"arch,omitempty"`
+	Versions     []string `json:"versions"` // Oldest first
+	Size         int64    `json:"size"`     // Taken by all but the newest version
+}
+
+// Report summarizes the packages of a repository
+type Report struct {
+	Packages   int         `json:"packages"`
+	Size       int64       `json:"size"`
+	Types      []Type      `json:"types"`
+	Largest    []Package   `json:"largest"`    // Largest first
+	Duplicates []Duplicate `json:"duplicates"` // Most reclaimable space first
+	Oldest     *Build      `json:"oldest,omitempty"`
+	Newest     *Build      `json:"newest,omitempty"`
+}
+
+// Collector gathers the packages of a repository; register them with Add
+type Collector struct {
+	packages map[string][]models.Package // Keyed by package type
+}
+
+// NewCollector creates an empty collector
+func NewCollector() *Collector {
+	return &Collector{packages: make(map[string][]models.Package)}
+}
+
+// Add registers the packages of pkgType
+func (c *Collector) Add(pkgType string, packages []models.Package) {
+	c.packages[pkgType] = append(c.packages[pkgType], packages...)
+}
+
+// Report summarizes the packages added so far, listing the top largest
+// packages (all of them when top isn't positive)
+func (c *Collector) Report(top int) *Report {
+	report := &Report{Largest: []Package{}, Duplicates: []Duplicate{}}
+
+	pkgTypes := make([]string, 0, len(c.packages))
+	for pkgType := range c.packages {
+		pkgTypes = append(pkgTypes, pkgType)
+	}
+	sort.Strings(pkgTypes)
+
+	for _, pkgType := range pkgTypes {
+		packages := c.packages[pkgType]
+		t := Type{Type: pkgType, Packages: len(packages), Architectures: architectures(packages)}
+		for _, pkg := range packages {
+			t.Size += pkg.Size
+			if built, ok := utils.BuildTime(pkg); ok {
+				b := &Build{Type: pkgType, Name: pkg.Name, Version: fullVersion(pkg), Architecture: pkg.Architecture, Time: built.UTC()}
+				t.Oldest, t.Newest = earliest(t.Oldest, b), latest(t.Newest, b)
+			}
+		}
+
+		report.Packages += t.Packages
+		report.Size += t.Size
+		report.Oldest, report.Newest = earliest(report.Oldest, t.Oldest), latest(report.Newest, t.Newest)
+		report.Types = append(report.Types, t)
+		report.Largest = append(report.Largest, largest(pkgType, packages)...)
+		report.Duplicates = append(report.Duplicates, duplicates(pkgType, packages)...)
+	}
+
+	sort.SliceStable(report.Largest, func(i, j int) bool {
+		return report.Largest[i].Size > report.Largest[j].Size
+	})
+	if top > 0 && len(report.Largest) > top {
+		report.Largest = report.Largest[:top]
+	}
+	sort.SliceStable(report.Duplicates, func(i, j int) bool {
+		return report.Duplicates[i].Size > report.Duplicates[j].Size
+	})
+
+	return report
+}
+
+// largest sums the size of the files of each package of pkgType, by name
+func largest(pkgType string, packages []models.Package) []Package {
+	byName := make(map[string]*Package)
+	versions := make(map[string]map[string]bool)
+	var names []string
+	for _, pkg := range packages {
+		p, ok := byName[pkg.Name]
+		if !ok {
+			p = &Package{Type: pkgType, Name: pkg.Name}
+			byName[pkg.Name] = p
+			versions[pkg.Name] = make(map[string]bool)
+			names = append(names, pkg.Name)
+		}
+		p.Files++
+		p.Size += pkg.Size
+		versions[pkg.Name][fullVersion(pkg)] = true
+	}
+
+	sort.Strings(names)
+	result := make([]Package, 0, len(names))
+	for _, name := range names {
+		byName[name].Versions = len(versions[name])
+		result = append(result, *byName[name])
+	}
+	return result
+}
+
+// duplicates returns the packages of pkgType published in several versions
+// for the same architecture
+func duplicates(pkgType string, packages []models.Package) []Duplicate {
+	type key struct{ name, arch string }
+	groups := make(map[key][]models.Package)
+	var keys []key
+	for _, pkg := range packages {
+		k := key{pkg.Name, pkg.Architecture}
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], pkg)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].arch < keys[j].arch
+	})
+
+	var result []Duplicate
+	for _, k := range keys {
+		group := groups[k]
+		if len(group) < 2 {
+			continue
+		}
+		compare := utils.VersionComparator(pkgType)
+		sort.SliceStable(group, func(i, j int) bool {
+			return compare(fullVersion(group[i]), fullVersion(group[j])) < 0
+		})
+
+		d := Duplicate{Type: pkgType, Name: k.name, Architecture: k.arch}
+		for i, pkg := range group {
+			d.Versions = append(d.Versions, fullVersion(pkg))
+			if i < len(group)-1 {
+				d.Size += pkg.Size
+			}
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+// architectures counts the packages of each architecture, listing the
+// packages other architectures have. Architecture-independent packages
+// are installed on every architecture, so they are never missing
+func architectures(packages []models.Package) []Arch {
+	names := make(map[string]map[string]bool) // Package names by architecture
+	counts := make(map[string]int)
+	all := make(map[string]bool)
+	for _, pkg := range packages {
+		counts[pkg.Architecture]++
+		if independent(pkg.Architecture) {
+			continue
+		}
+		if names[pkg.Architecture] == nil {
+			names[pkg.Architecture] = make(map[string]bool)
+		}
+		names[pkg.Architecture][pkg.Name] = true
+		all[pkg.Name] = true
+	}
+
+	var result []Arch
+	for arch, count := range counts {
+		a := Arch{Architecture: arch, Packages: count}
+		if !independent(arch) {
+			for name := range all {
+				if !names[arch][name] {
+					a.Missing = append(a.Missing, name)
+				}
+			}
+			sort.Strings(a.Missing)
+		}
+		result = append(result, a)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Architecture < result[j].Architecture
+	})
+	return result
+}
+
+// independent reports whether arch is the architecture of packages
+// installable on any architecture
+func independent(arch string) bool {
+	switch arch {
+	case "", "all", "noarch", "any":
+		return true
+	}
+	return false
+}
+
+// fullVersion returns the version of pkg, followed by its release for
+// formats keeping it apart like RPM
+func fullVersion(pkg models.Package) string {
+	if release, ok := pkg.Metadata["Release"].(string); ok && release != "" {
+		return pkg.Version + "-" + release
+	}
+	return pkg.Version
+}
+
+func earliest(a, b *Build) *Build {
+	if a == nil || (b != nil && b.Time.Before(a.Time)) {
+		return b
+	}
+	return a
+}
+
+func latest(a, b *Build) *Build {
+	if a == nil || (b != nil && b.Time.After(a.Time)) {
+		return b
+	}
+	return a
+}
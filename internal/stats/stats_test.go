@@ -0,0 +1,90 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+func pkg(name, version, arch string, size int64, built time.Time) models.Package {
+	p := models.Package{Name: name, Version: version, Architecture: arch, Size: size, Metadata: map[string]interface{}{}}
+	if !built.IsZero() {
+		p.Metadata["BuildTime"] = built.Unix()
+	}
+	return p
+}
+
+func TestReport(t *testing.T) {
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	jun := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	c := NewCollector()
+	c.Add("deb", []models.Package{
+		pkg("hello", "1.0", "amd64", 100, time.Time{}),
+		pkg("hello", "1.10", "amd64", 300, time.Time{}),
+		pkg("hello", "1.2", "amd64", 200, time.Time{}),
+		pkg("hello", "1.10", "arm64", 300, time.Time{}),
+		pkg("tool", "2.0", "amd64", 50, time.Time{}),
+		pkg("docs", "1.0", "all", 10, time.Time{}),
+	})
+	c.Add("rpm", []models.Package{
+		pkg("hello", "1.0", "x86_64", 1000, jun),
+		pkg("hello", "0.9", "x86_64", 900, jan),
+	})
+
+	report := c.Report(2)
+	if report.Packages != 8 || report.Size != 2860 {
+		t.Errorf("Totals = %d packages, %d bytes", report.Packages, report.Size)
+	}
+
+	if len(report.Types) != 2 || report.Types[0].Type != "deb" || report.Types[0].Packages != 6 || report.Types[0].Size != 960 {
+		t.Fatalf("Types = %+v", report.Types)
+	}
+	var coverage []string
+	for _, a := range report.Types[0].Architectures {
+		coverage = append(coverage, a.Architecture+":"+strings.Join(a.Missing, ","))
+	}
+	if got := strings.Join(coverage, " "); got != "all: amd64: arm64:tool" {
+		t.Errorf("Architectures = %s", got)
+	}
+	if report.Types[0].Oldest != nil {
+		t.Errorf("Oldest deb build = %+v, want none", report.Types[0].Oldest)
+	}
+
+	if len(report.Largest) != 2 || report.Largest[0].Type != "rpm" || report.Largest[0].Size != 1900 || report.Largest[1].Name != "hello" || report.Largest[1].Versions != 3 || report.Largest[1].Files != 4 {
+		t.Errorf("Largest = %+v", report.Largest)
+	}
+
+	if len(report.Duplicates) != 2 {
+		t.Fatalf("Duplicates = %+v", report.Duplicates)
+	}
+	if d := report.Duplicates[0]; d.Type != "rpm" || d.Size != 900 {
+		t.Errorf("First duplicate = %+v", d)
+	}
+	if d := report.Duplicates[1]; d.Architecture != "amd64" || strings.Join(d.Versions, " ") != "1.0 1.2 1.10" || d.Size != 300 {
+		t.Errorf("Second duplicate = %+v", d)
+	}
+
+	if report.Oldest == nil || !report.Oldest.Time.Equal(jan) || report.Oldest.Version != "0.9" {
+		t.Errorf("Oldest = %+v", report.Oldest)
+	}
+	if report.Newest == nil || !report.Newest.Time.Equal(jun) {
+		t.Errorf("Newest = %+v", report.Newest)
+	}
+}
+
+func TestReportRelease(t *testing.T) {
+	first := pkg("hello", "1.0", "x86_64", 10, time.Time{})
+	first.Metadata["Release"] = "1"
+	second := pkg("hello", "1.0", "x86_64", 10, time.Time{})
+	second.Metadata["Release"] = "2"
+
+	c := NewCollector()
+	c.Add("rpm", []models.Package{second, first})
+	report := c.Report(0)
+	if len(report.Duplicates) != 1 || strings.Join(report.Duplicates[0].Versions, " ") != "1.0-1 1.0-2" {
+		t.Errorf("Duplicates = %+v", report.Duplicates)
+	}
+}
@@ -0,0 +1,101 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Path is the generation status file, relative to the repository root
+const Path = ".repogen/status.json"
+
+// Generation describes the last successful generation of the repository of
+// one package type
+type Generation struct {
+	GeneratedAt    time.Time `json:"generated_at"`
+	Packages       int       `json:"packages"`
+	VerifiedWrites bool      `json:"verified_writes"` // Every copied file was read back and checked
+	RepogenVersion string    `json:"repogen_version"`
+}
+
+// Status is the last generation of each package type published in a repository
+type Status struct {
+	Repositories map[string]Generation `json:"repositories"` // By package type
+}
+
+// Types returns the package types of the status, sorted
+func (s *Status) Types() []string {
+	types := make([]string, 0, len(s.Repositories))
+	for pkgType := range s.Repositories {
+		types = append(types, pkgType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// LastGeneration returns the time of the most recent generation
+func (s *Status) LastGeneration() time.Time {
+	var last time.Time
+	for _, gen := range s.Repositories {
+		if gen.GeneratedAt.After(last) {
+			last = gen.GeneratedAt
+		}
+	}
+	return last
+}
+
+// Read loads the status of the repository in outputDir
+func Read(outputDir string) (*Status, error) {
+	data, err := os.ReadFile(filepath.Join(outputDir, filepath.FromSlash(Path)))
+	if err != nil {
+		return nil, err
+	}
+
+	var s Status
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid status file: %w", err)
+	}
+	if s.Repositories == nil {
+		s.Repositories = make(map[string]Generation)
+	}
+	return &s, nil
+}
+
+// recordMu keeps the package types generated at once from losing each
+// other's generation
+var recordMu sync.Mutex
+
+// Record stores gen as the last generation of pkgType, keeping the other types
+func Record(outputDir, pkgType string, gen Generation) error {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+
+	s, err := Read(outputDir)
+	if err != nil {
+		s = &Status{Repositories: make(map[string]Generation)}
+	}
+	s.Repositories[pkgType] = gen
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Servers read the file at any time: replace it in one step
+	file := filepath.Join(outputDir, filepath.FromSlash(Path))
+	tmp := file + ".tmp"
+	if err := utils.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write status: %w", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write status: %w", err)
+	}
+	return nil
+}
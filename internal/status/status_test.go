@@ -0,0 +1,50 @@
+package status
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := Read(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected no status, got %v", err)
+	}
+
+	first := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := Record(dir, "deb", Generation{GeneratedAt: first, Packages: 3, VerifiedWrites: true, RepogenVersion: "1.0.0"}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	if err := Record(dir, "rpm", Generation{GeneratedAt: first.Add(time.Hour), Packages: 1}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	s, err := Read(dir)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if types := s.Types(); len(types) != 2 || types[0] != "deb" || types[1] != "rpm" {
+		t.Errorf("unexpected types %v", types)
+	}
+	if deb := s.Repositories["deb"]; deb.Packages != 3 || !deb.VerifiedWrites || !deb.GeneratedAt.Equal(first) {
+		t.Errorf("unexpected deb generation %+v", deb)
+	}
+	if last := s.LastGeneration(); !last.Equal(first.Add(time.Hour)) {
+		t.Errorf("unexpected last generation %v", last)
+	}
+
+	// A new generation replaces the previous one of its type
+	if err := Record(dir, "deb", Generation{GeneratedAt: first, Packages: 2}); err != nil {
+		t.Fatal(err)
+	}
+	s, _ = Read(dir)
+	if s.Repositories["deb"].Packages != 2 || len(s.Repositories) != 2 {
+		t.Errorf("unexpected status %+v", s)
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(Path)+".tmp")); !os.IsNotExist(err) {
+		t.Error("temporary status file left behind")
+	}
+}
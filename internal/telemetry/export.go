@@ -0,0 +1,186 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ralt/repogen/internal/utils"
+)
+
+// Job is the Pushgateway job metrics are pushed under
+const Job = "repogen"
+
+// WriteFile writes the run to path as JSON
+func WriteFile(path string, run *Run) error {
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
+
+// Prometheus returns the metrics of the run in the Prometheus text format
+func (run *Run) Prometheus() []byte {
+	var b bytes.Buffer
+	for _, m := range run.Metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n", m.Name, m.Help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", m.Name, m.Type)
+		for _, s := range m.Samples {
+			b.WriteString(m.Name)
+			if len(s.Labels) > 0 {
+				names := make([]string, 0, len(s.Labels))
+				for name := range s.Labels {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				pairs := make([]string, len(names))
+				for i, name := range names {
+					pairs[i] = fmt.Sprintf("%s=%q", name, s.Labels[name])
+				}
+				b.WriteString("{" + strings.Join(pairs, ",") + "}")
+			}
+			b.WriteString(" " + strconv.FormatFloat(s.Value, 'g', -1, 64) + "\n")
+		}
+	}
+	return b.Bytes()
+}
+
+// Push replaces the metrics of the group of the Pushgateway at gatewayURL
+// with those of the run. The group is the Job, refined by grouping labels
+func Push(ctx context.Context, client *http.Client, gatewayURL string, grouping Labels, run *Run) error {
+	path := "/metrics/job/" + url.PathEscape(Job)
+	names := make([]string, 0, len(grouping))
+	for name := range grouping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path += "/" + url.PathEscape(name) + "/" + url.PathEscape(grouping[name])
+	}
+
+	return send(ctx, client, http.MethodPut, strings.TrimRight(gatewayURL, "/")+path, "text/plain; version=0.0.4", run.Prometheus())
+}
+
+// OTLP/HTTP JSON encoding of traces
+type (
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // 0 unset, 2 error
+		Message string `json:"message,omitempty"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"` // 1 internal
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpScopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpResourceSpans struct {
+		Resource struct {
+			Attributes []otlpAttribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+)
+
+// attributes encodes labels as sorted OTLP attributes
+func attributes(labels Labels) []otlpAttribute {
+	var attrs []otlpAttribute
+	for key, value := range labels {
+		attrs = append(attrs, otlpAttribute{Key: key, Value: otlpValue{StringValue: value}})
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
+
+// ExportTraces sends the spans of the run to the OpenTelemetry collector at
+// endpoint over OTLP/HTTP, resource describing the run (service.name is set)
+func ExportTraces(ctx context.Context, client *http.Client, endpoint string, resource Labels, run *Run) error {
+	if len(run.Spans) == 0 {
+		return nil
+	}
+
+	scope := otlpScopeSpans{}
+	scope.Scope.Name = Job
+	for _, s := range run.Spans {
+		span := otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentID,
+			Name:              s.Name,
+			Kind:              1,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        attributes(s.Attributes),
+		}
+		if s.Error != "" {
+			span.Status = otlpStatus{Code: 2, Message: s.Error}
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+
+	rs := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	res := Labels{"service.name": Job}
+	for key, value := range resource {
+		res[key] = value
+	}
+	rs.Resource.Attributes = attributes(res)
+
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{rs}})
+	if err != nil {
+		return err
+	}
+	return send(ctx, client, http.MethodPost, strings.TrimRight(endpoint, "/")+"/v1/traces", "application/json", body)
+}
+
+// send sends body to target, failing unless the response is a success
+func send(ctx context.Context, client *http.Client, method, target, contentType string, body []byte) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, target, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
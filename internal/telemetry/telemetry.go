@@ -0,0 +1,292 @@
+// Package telemetry records the metrics and trace of a run: counters fed by
+// the events stream, and spans timing the stages of the pipeline. They are
+// written to a JSON file, pushed to a Prometheus Pushgateway, or exported to
+// an OpenTelemetry collector, so long-running publish jobs can be monitored
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ralt/repogen/internal/events"
+)
+
+// Metric names
+const (
+	packagesParsed    = "repogen_packages_parsed_total"
+	filesCopied       = "repogen_files_copied_total"
+	bytesCopied       = "repogen_bytes_copied_total"
+	metadataWritten   = "repogen_metadata_files_written_total"
+	metadataBytes     = "repogen_metadata_bytes_written_total"
+	signatures        = "repogen_signatures_total"
+	packagesPublished = "repogen_packages_published"
+	stageDuration     = "repogen_stage_duration_seconds"
+	runDuration       = "repogen_run_duration_seconds"
+	runSuccess        = "repogen_run_success"
+	runTimestamp      = "repogen_run_timestamp_seconds"
+)
+
+// descriptors holds the Prometheus type and help of each metric
+var descriptors = map[string]struct{ typ, help string }{
+	packagesParsed:    {"counter", "Package files read, per package type."},
+	filesCopied:       {"counter", "Package files copied into the repository."},
+	bytesCopied:       {"counter", "Bytes of package files copied into the repository."},
+	metadataWritten:   {"counter", "Metadata files written."},
+	metadataBytes:     {"counter", "Bytes of metadata files written."},
+	signatures:        {"counter", "Signatures created, per kind."},
+	packagesPublished: {"gauge", "Packages in the repositories generated, per package type."},
+	stageDuration:     {"gauge", "Time spent in each stage of the run, per package type for generate."},
+	runDuration:       {"gauge", "Duration of the run."},
+	runSuccess:        {"gauge", "Whether the run succeeded."},
+	runTimestamp:      {"gauge", "Time the run ended."},
+}
+
+// Labels are the labels of a sample, or the attributes of a span
+type Labels map[string]string
+
+// key encodes labels as sorted name=value pairs
+func (l Labels) key() string {
+	pairs := make([]string, 0, len(l))
+	for name, value := range l {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Sample is a value of a metric
+type Sample struct {
+	Labels Labels  `json:"labels,omitempty"`
+	Value  float64 `json:"value"`
+}
+
+// Metric is a metric and its samples
+type Metric struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"` // counter or gauge
+	Help    string   `json:"help"`
+	Samples []Sample `json:"samples"`
+}
+
+// SpanData is a finished span
+type SpanData struct {
+	TraceID    string    `json:"trace_id"`
+	SpanID     string    `json:"span_id"`
+	ParentID   string    `json:"parent_span_id,omitempty"`
+	Name       string    `json:"name"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Attributes Labels    `json:"attributes,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Run is what was recorded of a finished run
+type Run struct {
+	StartedAt time.Time  `json:"started_at"`
+	Duration  float64    `json:"duration_seconds"`
+	Success   bool       `json:"success"`
+	Error     string     `json:"error,omitempty"`
+	Metrics   []Metric   `json:"metrics"`
+	Spans     []SpanData `json:"spans"`
+}
+
+// Recorder records the metrics and spans of a run
+type Recorder struct {
+	mu      sync.Mutex
+	start   time.Time
+	traceID string
+	samples map[string]map[string]*Sample // By metric name, then labels key
+	spans   []SpanData
+}
+
+// New starts recording a run
+func New() *Recorder {
+	return &Recorder{
+		start:   time.Now(),
+		traceID: randomID(16),
+		samples: make(map[string]map[string]*Sample),
+	}
+}
+
+// add adds v to the sample of name with labels
+func (r *Recorder) add(name string, labels Labels, v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sample(name, labels).Value += v
+}
+
+// set sets the sample of name with labels to v
+func (r *Recorder) set(name string, labels Labels, v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sample(name, labels).Value = v
+}
+
+func (r *Recorder) sample(name string, labels Labels) *Sample {
+	byLabels := r.samples[name]
+	if byLabels == nil {
+		byLabels = make(map[string]*Sample)
+		r.samples[name] = byLabels
+	}
+	key := labels.key()
+	s := byLabels[key]
+	if s == nil {
+		s = &Sample{Labels: labels}
+		byLabels[key] = s
+	}
+	return s
+}
+
+// Observe counts an event of the events stream
+func (r *Recorder) Observe(t events.Type, fields events.Fields) {
+	switch t {
+	case events.PackageParsed:
+		r.add(packagesParsed, Labels{"type": str(fields["type"])}, 1)
+	case events.FileCopied:
+		r.add(filesCopied, nil, 1)
+		r.add(bytesCopied, nil, number(fields["size"]))
+	case events.MetadataWritten:
+		r.add(metadataWritten, nil, 1)
+		r.add(metadataBytes, nil, number(fields["size"]))
+	case events.Signed:
+		r.add(signatures, Labels{"kind": str(fields["kind"])}, 1)
+	case events.Published:
+		r.set(packagesPublished, Labels{"type": str(fields["type"])}, number(fields["packages"]))
+	}
+}
+
+func str(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func number(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+// Finish ends the run, err being its outcome, and returns what was recorded
+func (r *Recorder) Finish(err error) *Run {
+	end := time.Now()
+	duration := end.Sub(r.start).Seconds()
+	r.set(runDuration, nil, duration)
+	r.set(runTimestamp, nil, float64(end.Unix()))
+	success := 0.0
+	if err == nil {
+		success = 1
+	}
+	r.set(runSuccess, nil, success)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run := &Run{StartedAt: r.start.UTC(), Duration: duration, Success: err == nil, Spans: append([]SpanData(nil), r.spans...)}
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	names := make([]string, 0, len(r.samples))
+	for name := range r.samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := Metric{Name: name, Type: descriptors[name].typ, Help: descriptors[name].help}
+		keys := make([]string, 0, len(r.samples[name]))
+		for key := range r.samples[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			m.Samples = append(m.Samples, *r.samples[name][key])
+		}
+		run.Metrics = append(run.Metrics, m)
+	}
+	return run
+}
+
+type recorderKey struct{}
+type spanKey struct{}
+
+// WithRecorder returns a context recording spans into r
+func WithRecorder(ctx context.Context, r *Recorder) context.Context {
+	return context.WithValue(ctx, recorderKey{}, r)
+}
+
+// Span times a stage of the run
+type Span struct {
+	r    *Recorder
+	data SpanData
+}
+
+// Start starts the span of stage, child of the span of ctx, and returns the
+// context of its children. The span is nil, and ends as a no-op, when ctx
+// records nothing
+func Start(ctx context.Context, stage string, attrs Labels) (context.Context, *Span) {
+	r, _ := ctx.Value(recorderKey{}).(*Recorder)
+	if r == nil {
+		return ctx, nil
+	}
+	s := &Span{r: r, data: SpanData{
+		TraceID:    r.traceID,
+		SpanID:     randomID(8),
+		Name:       stage,
+		Start:      time.Now(),
+		Attributes: attrs,
+	}}
+	if parent, _ := ctx.Value(spanKey{}).(*Span); parent != nil {
+		s.data.ParentID = parent.data.SpanID
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// End ends the span, err being the outcome of its stage, and adds its
+// duration to the duration of the stage
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Error = err.Error()
+	}
+
+	labels := Labels{"stage": s.data.Name}
+	for name, value := range s.data.Attributes {
+		labels[name] = value
+	}
+	s.r.add(stageDuration, labels, s.data.End.Sub(s.data.Start).Seconds())
+
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.r.spans = append(s.r.spans, s.data)
+}
+
+// randomID returns n random bytes in hex, as trace and span IDs
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidateURL checks that raw, the value of flag, is an HTTP(S) URL
+func ValidateURL(flag, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("--%s must be an http:// or https:// URL, got %q", flag, raw)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ralt/repogen/internal/events"
+)
+
+// record runs a small pipeline against a new recorder
+func record(t *testing.T) *Run {
+	t.Helper()
+	r := New()
+	ctx, run := Start(WithRecorder(context.Background(), r), "run", nil)
+
+	r.Observe(events.PackageParsed, events.Fields{"type": "deb"})
+	r.Observe(events.PackageParsed, events.Fields{"type": "deb"})
+	r.Observe(events.FileCopied, events.Fields{"size": int64(1000)})
+	r.Observe(events.MetadataWritten, events.Fields{"size": 200})
+	r.Observe(events.Signed, events.Fields{"kind": "detached"})
+	r.Observe(events.Published, events.Fields{"type": "deb", "packages": 2})
+
+	_, generate := Start(ctx, "generate", Labels{"type": "deb"})
+	generate.End(errors.New("disk full"))
+	err := errors.New("generate failed")
+	run.End(err)
+	return r.Finish(err)
+}
+
+func TestRecorder(t *testing.T) {
+	run := record(t)
+
+	if run.Success || run.Error != "generate failed" {
+		t.Errorf("Run outcome = %v %q", run.Success, run.Error)
+	}
+	values := make(map[string]float64)
+	for _, m := range run.Metrics {
+		if m.Type == "" || m.Help == "" {
+			t.Errorf("%s has no descriptor", m.Name)
+		}
+		for _, s := range m.Samples {
+			values[m.Name+"{"+s.Labels.key()+"}"] = s.Value
+		}
+	}
+	for name, want := range map[string]float64{
+		"repogen_packages_parsed_total{type=deb}": 2,
+		"repogen_bytes_copied_total{}":            1000,
+		"repogen_metadata_bytes_written_total{}":  200,
+		"repogen_signatures_total{kind=detached}": 1,
+		"repogen_packages_published{type=deb}":    2,
+		"repogen_run_success{}":                   0,
+		"repogen_files_copied_total{}":            1,
+		"repogen_metadata_files_written_total{}":  1,
+	} {
+		if got, ok := values[name]; !ok || got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	if _, ok := values["repogen_stage_duration_seconds{stage=generate,type=deb}"]; !ok {
+		t.Errorf("No duration for the generate stage: %v", values)
+	}
+
+	if len(run.Spans) != 2 {
+		t.Fatalf("Recorded %d spans, want 2", len(run.Spans))
+	}
+	generate, root := run.Spans[0], run.Spans[1]
+	if generate.ParentID != root.SpanID || generate.TraceID != root.TraceID || root.ParentID != "" {
+		t.Errorf("Spans not linked: %+v", run.Spans)
+	}
+	if generate.Error != "disk full" || len(generate.TraceID) != 32 || len(generate.SpanID) != 16 {
+		t.Errorf("Unexpected span: %+v", generate)
+	}
+}
+
+func TestStartWithoutRecorder(t *testing.T) {
+	ctx, span := Start(context.Background(), "scan", nil)
+	if span != nil || ctx != context.Background() {
+		t.Error("Span started without a recorder")
+	}
+	span.End(nil)
+}
+
+func TestPrometheus(t *testing.T) {
+	run := &Run{Metrics: []Metric{
+		{Name: "repogen_packages_parsed_total", Type: "counter", Help: "Package files read.", Samples: []Sample{{Labels: Labels{"type": "deb"}, Value: 2}}},
+		{Name: "repogen_run_duration_seconds", Type: "gauge", Help: "Duration of the run.", Samples: []Sample{{Value: 1.5}}},
+	}}
+	want := `# HELP repogen_packages_parsed_total Package files read.
+# TYPE repogen_packages_parsed_total counter
+repogen_packages_parsed_total{type="deb"} 2
+# HELP repogen_run_duration_seconds Duration of the run.
+# TYPE repogen_run_duration_seconds gauge
+repogen_run_duration_seconds 1.5
+`
+	if got := string(run.Prometheus()); got != want {
+		t.Errorf("Prometheus() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPush(t *testing.T) {
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.EscapedPath(), string(data)
+	}))
+	defer server.Close()
+
+	run := record(t)
+	if err := Push(context.Background(), server.Client(), server.URL+"/", Labels{"repository": "my repo"}, run); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/repogen/repository/my%20repo" {
+		t.Errorf("Pushed with %s %s", method, path)
+	}
+	if !strings.Contains(body, `repogen_packages_parsed_total{type="deb"} 2`) {
+		t.Errorf("Unexpected body:\n%s", body)
+	}
+
+	if err := Push(context.Background(), server.Client(), "http://127.0.0.1:0", nil, run); err == nil {
+		t.Error("Push succeeded without a gateway")
+	}
+}
+
+func TestExportTraces(t *testing.T) {
+	var traces otlpTraces
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&traces); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	run := record(t)
+	if err := ExportTraces(context.Background(), server.Client(), server.URL, Labels{"service.version": "1.0"}, run); err != nil {
+		t.Fatalf("ExportTraces failed: %v", err)
+	}
+	if path != "/v1/traces" || len(traces.ResourceSpans) != 1 {
+		t.Fatalf("Exported to %s: %+v", path, traces)
+	}
+	rs := traces.ResourceSpans[0]
+	if len(rs.Resource.Attributes) != 2 || rs.Resource.Attributes[0].Key != "service.name" || rs.Resource.Attributes[0].Value.StringValue != "repogen" {
+		t.Errorf("Unexpected resource: %+v", rs.Resource)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[0].Name != "generate" || spans[0].Status.Code != 2 || spans[0].ParentSpanID != spans[1].SpanID {
+		t.Errorf("Unexpected spans: %+v", spans)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ci", "metrics.json")
+	if err := WriteFile(path, record(t)); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var run Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		t.Fatalf("Invalid metrics file: %v", err)
+	}
+	if len(run.Metrics) == 0 || len(run.Spans) != 2 {
+		t.Errorf("Unexpected metrics file:\n%s", data)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	for raw, valid := range map[string]bool{
+		"http://pushgateway:9091":  true,
+		"https://otel.example.com": true,
+		"pushgateway:9091":         false,
+		"ftp://example.com":        false,
+	} {
+		if err := ValidateURL("metrics-push", raw); (err == nil) != valid {
+			t.Errorf("ValidateURL(%q) = %v", raw, err)
+		}
+	}
+}
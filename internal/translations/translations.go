@@ -0,0 +1,74 @@
+package translations
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ralt/repogen/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// languagePattern matches the language codes apt uses in Translation-<lang>
+// file names (e.g. "de", "pt_BR")
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}(_[A-Z]{2})?$`)
+
+// Translations maps a language code to localized package descriptions,
+// keyed by package name. A description's first line is the synopsis, the
+// remaining lines are the long description, as in Debian control files
+type Translations map[string]map[string]string
+
+// Load reads a translations file (YAML or JSON)
+func Load(path string) (Translations, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read translations file: %w", err)
+	}
+
+	var t Translations
+	if err := yaml.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse translations file %s: %w", path, err)
+	}
+
+	for lang := range t {
+		if !languagePattern.MatchString(lang) {
+			return nil, fmt.Errorf("invalid language code %q in %s (expected e.g. \"de\" or \"pt_BR\")", lang, path)
+		}
+	}
+
+	return t, nil
+}
+
+// Apply attaches the localized descriptions to packages in place
+func (t Translations) Apply(packages []models.Package) {
+	for i := range packages {
+		for lang, descriptions := range t {
+			description, ok := descriptions[packages[i].Name]
+			if !ok {
+				continue
+			}
+			if packages[i].Translations == nil {
+				packages[i].Translations = make(map[string]string)
+			}
+			packages[i].Translations[lang] = strings.TrimRight(description, "\n")
+		}
+	}
+}
+
+// Languages returns the sorted language codes used by at least one package
+func Languages(packages []models.Package) []string {
+	seen := make(map[string]bool)
+	var langs []string
+	for _, pkg := range packages {
+		for lang := range pkg.Translations {
+			if !seen[lang] {
+				seen[lang] = true
+				langs = append(langs, lang)
+			}
+		}
+	}
+	sort.Strings(langs)
+	return langs
+}
@@ -28,33 +28,48 @@ func CalculateChecksums(path string) (*Checksum, error) {
 	}
 	defer f.Close()
 
-	// Get file info for size
-	info, err := f.Stat()
-	if err != nil {
+	// Stream file through all hashes
+	h := newChecksumHasher()
+	if _, err := io.CopyBuffer(h, f, make([]byte, copyBufferSize)); err != nil {
 		return nil, err
 	}
 
-	// Create all hash writers
-	md5Hash := md5.New()
-	sha1Hash := sha1.New()
-	sha256Hash := sha256.New()
-	sha512Hash := sha512.New()
+	return h.Checksum(), nil
+}
 
-	// Use MultiWriter to calculate all hashes at once
-	multiWriter := io.MultiWriter(md5Hash, sha1Hash, sha256Hash, sha512Hash)
+// checksumHasher computes every checksum of the data written to it at once
+type checksumHasher struct {
+	md5, sha1, sha256, sha512 hash.Hash
+	writer                    io.Writer
+	size                      int64
+}
 
-	// Stream file through all hashes
-	if _, err := io.Copy(multiWriter, f); err != nil {
-		return nil, err
+func newChecksumHasher() *checksumHasher {
+	h := &checksumHasher{
+		md5:    md5.New(),
+		sha1:   sha1.New(),
+		sha256: sha256.New(),
+		sha512: sha512.New(),
 	}
+	h.writer = io.MultiWriter(h.md5, h.sha1, h.sha256, h.sha512)
+	return h
+}
 
+func (h *checksumHasher) Write(p []byte) (int, error) {
+	n, err := h.writer.Write(p)
+	h.size += int64(n)
+	return n, err
+}
+
+// Checksum returns the checksums of the data written so far
+func (h *checksumHasher) Checksum() *Checksum {
 	return &Checksum{
-		MD5:    hex.EncodeToString(md5Hash.Sum(nil)),
-		SHA1:   hex.EncodeToString(sha1Hash.Sum(nil)),
-		SHA256: hex.EncodeToString(sha256Hash.Sum(nil)),
-		SHA512: hex.EncodeToString(sha512Hash.Sum(nil)),
-		Size:   info.Size(),
-	}, nil
+		MD5:    hex.EncodeToString(h.md5.Sum(nil)),
+		SHA1:   hex.EncodeToString(h.sha1.Sum(nil)),
+		SHA256: hex.EncodeToString(h.sha256.Sum(nil)),
+		SHA512: hex.EncodeToString(h.sha512.Sum(nil)),
+		Size:   h.size,
+	}
 }
 
 // CalculateChecksum calculates a specific checksum for data
@@ -2,11 +2,129 @@ package utils
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 
 	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
+// Compression algorithms of repository indexes
+const (
+	CompressionZstd = "zstd"
+	CompressionXz   = "xz"
+	CompressionGzip = "gz"
+	CompressionNone = "none"
+)
+
+// xzDictCaps are the dictionary sizes of the xz presets 0 to 9
+var xzDictCaps = []int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// ValidateCompression checks that algorithm is a known compression and level
+// one of its levels, 0 being the algorithm's default
+func ValidateCompression(algorithm string, level int) error {
+	maxLevel := 9
+	switch algorithm {
+	case "", CompressionXz, CompressionGzip:
+	case CompressionZstd:
+		maxLevel = 22
+	case CompressionNone:
+		maxLevel = 0
+	default:
+		return fmt.Errorf("unknown compression %q (expected %s, %s, %s or %s)", algorithm, CompressionZstd, CompressionXz, CompressionGzip, CompressionNone)
+	}
+	if level < 0 || level > maxLevel {
+		name := algorithm
+		if name == "" {
+			name = "the default compression"
+		}
+		return fmt.Errorf("compression level %d out of range for %s (0 to %d)", level, name, maxLevel)
+	}
+	return nil
+}
+
+// CompressionSuffix returns the file extension of a compression algorithm
+func CompressionSuffix(algorithm string) string {
+	switch algorithm {
+	case CompressionZstd:
+		return ".zst"
+	case CompressionXz:
+		return ".xz"
+	case CompressionGzip:
+		return ".gz"
+	}
+	return ""
+}
+
+// Compress compresses data with algorithm at level, 0 for its default
+func Compress(algorithm string, level int, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+
+	switch algorithm {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		w, err = gzip.NewWriterLevel(&buf, level)
+	case CompressionXz:
+		config := xz.WriterConfig{}
+		if level != 0 {
+			config.DictCap = xzDictCaps[level]
+		}
+		w, err = config.NewWriter(&buf)
+	case CompressionZstd:
+		var options []zstd.EOption
+		if level != 0 {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		w, err = zstd.NewWriter(&buf, options...)
+	default:
+		return nil, fmt.Errorf("unknown compression %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses gzip, xz or zstd data, detected from its magic
+// bytes, and returns other data as is
+func Decompress(data []byte) ([]byte, error) {
+	var r io.Reader
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return GzipDecompress(data)
+	case bytes.HasPrefix(data, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		xr, err := xz.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		r = xr
+	case bytes.HasPrefix(data, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return data, nil
+	}
+	return io.ReadAll(r)
+}
+
 // GzipCompress compresses data using gzip
 func GzipCompress(data []byte) ([]byte, error) {
 	var buf bytes.Buffer
@@ -1,54 +1,327 @@
 package utils
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/ralt/repogen/internal/events"
 	"github.com/ralt/repogen/internal/models"
+	"github.com/sirupsen/logrus"
 )
 
+const (
+	// copyBufferSize is the chunk size used to stream files through copies
+	// and hashing, so memory use doesn't depend on the package size
+	copyBufferSize = 4 << 20
+
+	// progressThreshold is the file size above which copies report progress
+	progressThreshold = 64 << 20
+
+	// progressInterval is the minimum delay between two progress reports
+	progressInterval = 5 * time.Second
+)
+
+// pathLocks serializes writes to the same file, like the architectures of
+// a repository generated at once publishing the same arch-independent package.
+// Entries are removed once no write holds or waits for them, so that long
+// running daemons don't keep one per file ever written
+var (
+	pathLocksMu sync.Mutex
+	pathLocks   = make(map[string]*pathLock)
+)
+
+// pathLock is the lock of a path, with the number of writes holding or
+// waiting for it
+type pathLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockPath waits for the other writes to path to finish, and returns the
+// function ending the write
+func lockPath(path string) func() {
+	path = filepath.Clean(path)
+	pathLocksMu.Lock()
+	l, ok := pathLocks[path]
+	if !ok {
+		l = &pathLock{}
+		pathLocks[path] = l
+	}
+	l.refs++
+	pathLocksMu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		pathLocksMu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(pathLocks, path)
+		}
+		pathLocksMu.Unlock()
+	}
+}
+
 // CopyFile copies a file from src to dst
-func CopyFile(src, dst string) error {
+func CopyFile(ctx context.Context, src, dst string) error {
+	_, err := CopyFileWithChecksums(ctx, src, dst)
+	return err
+}
+
+// CopyFileWithChecksums copies a file from src to dst in fixed-size chunks,
+// calculating the checksums of the copied data on the way.
+//
+// The data is written to dst.part and renamed to dst once complete, so dst
+// never holds a partial file. If a previous copy was interrupted, the bytes
+// of dst.part that still match src are kept and the copy resumes after them.
+// If dst already holds the contents of src, it is left untouched.
+//
+// The copy stops between two chunks once ctx is done, leaving dst.part for
+// the next copy to resume.
+func CopyFileWithChecksums(ctx context.Context, src, dst string) (*Checksum, error) {
+	unlock := lockPath(dst)
+	defer unlock()
+
 	// Create destination directory if it doesn't exist
 	dstDir := filepath.Dir(dst)
 	if err := os.MkdirAll(dstDir, 0755); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Open source file
 	srcFile, err := os.Open(src)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer srcFile.Close()
 
-	// Create destination file
-	dstFile, err := os.Create(dst)
+	srcInfo, err := srcFile.Stat()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	hasher := newChecksumHasher()
+	buf := make([]byte, copyBufferSize)
+
+	// Rewriting an identical file would only cost I/O and change its
+	// modification time, which incremental syncs go by
+	partPath := dst + ".part"
+	identical, err := sameContents(srcFile, srcInfo.Size(), dst, hasher, buf)
+	if err != nil {
+		return nil, err
+	}
+	if identical {
+		logrus.Debugf("Keeping %s, identical to %s", dst, src)
+		os.Remove(partPath)
+		return hasher.Checksum(), nil
+	}
+
+	// Open (or reopen) the partial destination file
+	partFile, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer partFile.Close()
+
+	// Keep what an interrupted copy already wrote
+	offset, err := matchingPrefix(srcFile, partFile, srcInfo.Size(), hasher, buf)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		logrus.Infof("Resuming copy of %s at %s", filepath.Base(src), FormatBytes(offset))
+	}
+	if err := partFile.Truncate(offset); err != nil {
+		return nil, err
+	}
+	if _, err := srcFile.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := partFile.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	// Copy the remaining contents
+	reader := newProgressReader(contextReader{ctx, srcFile}, filepath.Base(src), offset, srcInfo.Size())
+	if _, err := io.CopyBuffer(io.MultiWriter(partFile, hasher), reader, buf); err != nil {
+		return nil, err
+	}
+
+	// Sync to disk before exposing the file under its final name
+	if err := partFile.Sync(); err != nil {
+		return nil, err
+	}
+	if err := partFile.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(partPath, dst); err != nil {
+		return nil, err
+	}
+
+	checksums := hasher.Checksum()
+	events.Emit(events.FileCopied, events.Fields{"src": src, "dst": dst, "size": checksums.Size, "sha256": checksums.SHA256})
+	return checksums, nil
+}
+
+// sameContents reports whether dst is a regular file holding the same bytes
+// as src, feeding them to hasher. Otherwise, src and hasher are reset
+func sameContents(src *os.File, srcSize int64, dst string, hasher *checksumHasher, buf []byte) (bool, error) {
+	// Symlinks are replaced by a copy, whatever they point to
+	dstInfo, err := os.Lstat(dst)
+	if err != nil || !dstInfo.Mode().IsRegular() || dstInfo.Size() != srcSize {
+		return false, nil
+	}
+	dstFile, err := os.Open(dst)
+	if err != nil {
+		return false, nil
 	}
 	defer dstFile.Close()
 
-	// Copy contents
-	if _, err := io.Copy(dstFile, srcFile); err != nil {
-		return err
+	matched, err := matchingPrefix(src, dstFile, srcSize, hasher, buf)
+	if err != nil {
+		return false, err
 	}
+	if matched == srcSize {
+		return true, nil
+	}
+
+	*hasher = *newChecksumHasher()
+	_, err = src.Seek(0, io.SeekStart)
+	return false, err
+}
+
+// matchingPrefix returns how many leading bytes of part match src, at chunk
+// granularity, feeding the matching source bytes to hasher
+func matchingPrefix(src, part *os.File, srcSize int64, hasher io.Writer, buf []byte) (int64, error) {
+	partInfo, err := part.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if partInfo.Size() == 0 || partInfo.Size() > srcSize {
+		return 0, nil
+	}
+
+	partBuf := make([]byte, len(buf))
+	var offset int64
+	for offset < partInfo.Size() {
+		chunk := int64(len(buf))
+		if remaining := partInfo.Size() - offset; remaining < chunk {
+			chunk = remaining
+		}
+
+		if _, err := io.ReadFull(src, buf[:chunk]); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(part, partBuf[:chunk]); err != nil {
+			return 0, err
+		}
+		if !bytes.Equal(buf[:chunk], partBuf[:chunk]) {
+			break
+		}
+
+		hasher.Write(buf[:chunk])
+		offset += chunk
+	}
+
+	return offset, nil
+}
+
+// contextReader fails reads once its context is done, so copies can be
+// interrupted between two chunks
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
+
+// progressReader logs the progress of large copies
+type progressReader struct {
+	r          io.Reader
+	name       string
+	done       int64
+	total      int64
+	lastReport time.Time
+}
 
-	// Sync to disk
-	return dstFile.Sync()
+func newProgressReader(r io.Reader, name string, done, total int64) io.Reader {
+	if total < progressThreshold {
+		return r
+	}
+	return &progressReader{r: r, name: name, done: done, total: total, lastReport: time.Now()}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.done += int64(n)
+
+	if time.Since(p.lastReport) >= progressInterval || err == io.EOF {
+		p.lastReport = time.Now()
+		logrus.Infof("Copying %s: %s / %s (%d%%)", p.name, FormatBytes(p.done), FormatBytes(p.total), p.done*100/p.total)
+	}
+
+	return n, err
+}
+
+// FormatBytes formats a size in bytes for humans
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// VerifyCopy re-reads dst and compares its checksums to the ones calculated
+// from the source while copying. This catches silent corruption on flaky
+// storage before the copy gets referenced in repository metadata
+func VerifyCopy(dst string, expected *Checksum) error {
+	dstChecksums, err := CalculateChecksums(dst)
+	if err != nil {
+		return fmt.Errorf("cannot read back destination: %w", err)
+	}
+
+	if expected.Size != dstChecksums.Size || expected.SHA256 != dstChecksums.SHA256 {
+		return fmt.Errorf("checksum mismatch after copy: %s has sha256 %s (%d bytes), source had sha256 %s (%d bytes)",
+			dst, dstChecksums.SHA256, dstChecksums.Size, expected.SHA256, expected.Size)
+	}
+
+	return nil
 }
 
 // WriteFile writes data to a file, creating directories as needed
 func WriteFile(path string, data []byte, perm os.FileMode) error {
+	unlock := lockPath(path)
+	defer unlock()
+
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
 
-	return os.WriteFile(path, data, perm)
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return err
+	}
+
+	events.Emit(events.MetadataWritten, events.Fields{"path": path, "size": len(data)})
+	return nil
 }
 
 // EnsureDir ensures a directory exists, creating it if necessary
@@ -56,6 +329,21 @@ func EnsureDir(path string) error {
 	return os.MkdirAll(path, 0755)
 }
 
+// IsInternalPath reports whether the file rel, slash-separated and relative
+// to an output directory, is state repogen keeps for its own runs rather
+// than part of the repository: the lock (lock.Path), the parse cache
+// (parsecache.Path) and the .repogen directory. The package manifest
+// (manifest.Path) is published, as search reads it
+func IsInternalPath(rel string) bool {
+	switch {
+	case rel == ".repogen.lock" || rel == ".repogen-cache":
+		return true
+	case rel == ".repogen/packages.json":
+		return false
+	}
+	return strings.HasPrefix(rel, ".repogen/")
+}
+
 // ShouldCopyPackage determines if a package file needs to be copied.
 // It handles both new packages (from input directory) and existing packages (from metadata).
 // Returns: (srcPath, dstPath, needsCopy, error)
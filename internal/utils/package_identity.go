@@ -2,6 +2,8 @@ package utils
 
 import (
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/ralt/repogen/internal/models"
 	"github.com/ralt/repogen/internal/scanner"
@@ -10,16 +12,28 @@ import (
 // PackageIdentity returns a unique identifier for a package based on format
 func PackageIdentity(pkg models.Package, pkgType scanner.PackageType) string {
 	switch pkgType {
-	case scanner.TypeDeb, scanner.TypeApk, scanner.TypePacman:
+	case scanner.TypeDeb, scanner.TypeApk, scanner.TypePacman, scanner.TypeHomebrewBottle, scanner.TypeHomebrewCask, scanner.TypePypi, scanner.TypeRubygem, scanner.TypeXbps, scanner.TypeTerraform:
 		return fmt.Sprintf("%s:%s:%s", pkg.Name, pkg.Version, pkg.Architecture)
 	case scanner.TypeRpm:
 		release := "1"
 		if r, ok := pkg.Metadata["Release"].(string); ok {
 			release = r
 		}
-		return fmt.Sprintf("%s:%s:%s:%s", pkg.Name, pkg.Version, release, pkg.Architecture)
-	case scanner.TypeHomebrewBottle:
-		return fmt.Sprintf("%s:%s", pkg.Name, pkg.Version)
+		// Packages read from primary.xml and from RPM headers both carry
+		// an epoch, "0" when the package has none
+		epoch := "0"
+		if e, ok := pkg.Metadata["Epoch"].(string); ok && e != "" {
+			epoch = e
+		}
+		return fmt.Sprintf("%s:%s:%s:%s:%s", pkg.Name, epoch, pkg.Version, release, pkg.Architecture)
+	case scanner.TypeConda:
+		// Builds of a version differ by the Python or library they target
+		build, _ := pkg.Metadata["Build"].(string)
+		return fmt.Sprintf("%s:%s:%s:%s", pkg.Name, pkg.Version, build, pkg.Architecture)
+	case scanner.TypeFdroid:
+		// The versionCode orders versions, the versionName is only shown
+		code, _ := pkg.Metadata["VersionCode"].(int64)
+		return fmt.Sprintf("%s:%d", pkg.Name, code)
 	default:
 		return fmt.Sprintf("%s:%s", pkg.Name, pkg.Version)
 	}
@@ -40,3 +54,16 @@ func DetectConflicts(existing, newPackages []models.Package, pkgType scanner.Pac
 	}
 	return conflicts
 }
+
+// BuildTime returns when a package was built, if its metadata says
+func BuildTime(pkg models.Package) (time.Time, bool) {
+	if bt, ok := pkg.Metadata["BuildTime"].(int64); ok && bt > 0 {
+		return time.Unix(bt, 0), true
+	}
+	if bd, ok := pkg.Metadata["BuildDate"].(string); ok {
+		if sec, err := strconv.ParseInt(bd, 10, 64); err == nil {
+			return time.Unix(sec, 0), true
+		}
+	}
+	return time.Time{}, false
+}
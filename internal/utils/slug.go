@@ -0,0 +1,32 @@
+package utils
+
+import "github.com/ralt/repogen/internal/models"
+
+// Slug creates a valid repository ID from a string
+func Slug(s string) string {
+	// Convert to lowercase and replace spaces/special chars with hyphens
+	result := ""
+	for _, ch := range s {
+		if (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') {
+			result += string(ch)
+		} else if ch >= 'A' && ch <= 'Z' {
+			result += string(ch - 'A' + 'a')
+		} else if ch == ' ' || ch == '_' || ch == '.' {
+			result += "-"
+		}
+	}
+	return result
+}
+
+// RepoSlug names the files identifying the repository as a whole, such as
+// its published public keys: the repository name, or else its origin
+func RepoSlug(config *models.RepositoryConfig) string {
+	slug := Slug(config.RepoName)
+	if slug == "" {
+		slug = Slug(config.Origin)
+	}
+	if slug == "" {
+		slug = "repogen"
+	}
+	return slug
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ralt/repogen/internal/models"
+)
+
+// Timestamp returns the time recorded in generated metadata: the fixed
+// --timestamp of reproducible runs, else now, in UTC
+func Timestamp(config *models.RepositoryConfig) time.Time {
+	if !config.Timestamp.IsZero() {
+		return config.Timestamp.UTC()
+	}
+	return time.Now().UTC()
+}
+
+// ParseTimestamp parses a --timestamp or SOURCE_DATE_EPOCH value: seconds
+// since the Unix epoch, or an RFC 3339 time
+func ParseTimestamp(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q (expected seconds since the epoch or an RFC 3339 time)", value)
+	}
+	return t.UTC(), nil
+}
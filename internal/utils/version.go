@@ -0,0 +1,334 @@
+package utils
+
+import (
+	"cmp"
+	"strings"
+	"unicode"
+
+	"github.com/ralt/repogen/internal/scanner"
+)
+
+// VersionComparator returns the function ordering the versions of packages
+// of pkgType, as named by scanner.PackageType.String
+func VersionComparator(pkgType string) func(a, b string) int {
+	switch pkgType {
+	case scanner.TypeDeb.String():
+		return CompareDebianVersions
+	case scanner.TypePypi.String(), scanner.TypeRubygem.String(), scanner.TypeCargo.String(),
+		scanner.TypeNuget.String(), scanner.TypeConda.String(), scanner.TypeTerraform.String(),
+		scanner.TypeHomebrewCask.String():
+		return CompareSemanticVersions
+	}
+	return CompareVersions
+}
+
+// CompareVersions compares two package version strings, returning -1, 0 or 1.
+// It follows rpmvercmp: versions are split into alternating numeric and
+// alphabetic segments, numeric segments compare numerically and win over
+// alphabetic ones, and "~" sorts before anything (pre-releases). An optional
+// "epoch:" prefix is compared first
+func CompareVersions(a, b string) int {
+	epochA, restA := splitEpoch(a)
+	epochB, restB := splitEpoch(b)
+	if c := compareSegments(epochA, epochB); c != 0 {
+		return c
+	}
+	return compareSegments(restA, restB)
+}
+
+// splitEpoch splits "2:1.0" into "2" and "1.0"; the epoch defaults to "0"
+func splitEpoch(version string) (string, string) {
+	if i := strings.Index(version, ":"); i > 0 {
+		return version[:i], version[i+1:]
+	}
+	return "0", version
+}
+
+func compareSegments(a, b string) int {
+	for a != "" || b != "" {
+		// Skip separators
+		a = strings.TrimLeftFunc(a, isVersionSeparator)
+		b = strings.TrimLeftFunc(b, isVersionSeparator)
+
+		// Tilde sorts before everything, even the end of the string
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if a == "" || b == "" {
+			break
+		}
+
+		// Take the next segment of the same kind from both strings
+		numeric := unicode.IsDigit(rune(a[0]))
+		segA, restA := nextSegment(a, numeric)
+		segB, restB := nextSegment(b, numeric)
+
+		if segB == "" {
+			// Numeric segments are newer than alphabetic ones
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+
+		if numeric {
+			if c := compareNumeric(segA, segB); c != 0 {
+				return c
+			}
+		} else if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+
+		a, b = restA, restB
+	}
+
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+func nextSegment(s string, numeric bool) (string, string) {
+	i := 0
+	for i < len(s) {
+		r := rune(s[i])
+		if numeric && !unicode.IsDigit(r) || !numeric && !unicode.IsLetter(r) {
+			break
+		}
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isVersionSeparator(r rune) bool {
+	return r != '~' && !unicode.IsDigit(r) && !unicode.IsLetter(r)
+}
+
+// compareNumeric compares two strings of digits by their value
+func compareNumeric(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if c := cmp.Compare(len(a), len(b)); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// CompareDebianVersions compares two Debian versions like dpkg: the epoch
+// first, then the upstream version, then the revision after the last "-".
+// Letters sort before other characters, and "~" before anything, even the
+// end of the version: 1.0~rc1 < 1.0 < 1.0-1 < 1.0+b1 < 1.0.1
+func CompareDebianVersions(a, b string) int {
+	epochA, upstreamA, revisionA := splitDebianVersion(a)
+	epochB, upstreamB, revisionB := splitDebianVersion(b)
+	if c := compareNumeric(epochA, epochB); c != 0 {
+		return c
+	}
+	if c := compareDebianPart(upstreamA, upstreamB); c != 0 {
+		return c
+	}
+	return compareDebianPart(revisionA, revisionB)
+}
+
+// splitDebianVersion splits "1:2.0-3" into "1", "2.0" and "3"
+func splitDebianVersion(version string) (epoch, upstream, revision string) {
+	epoch, version = splitEpoch(version)
+	if i := strings.LastIndex(version, "-"); i >= 0 {
+		return epoch, version[:i], version[i+1:]
+	}
+	return epoch, version, ""
+}
+
+// compareDebianPart is dpkg's verrevcmp: runs of non-digits compare
+// character by character, and runs of digits by their value
+func compareDebianPart(a, b string) int {
+	for a != "" || b != "" {
+		for a != "" && !isDigit(a[0]) || b != "" && !isDigit(b[0]) {
+			orderA, orderB := debianOrder(a), debianOrder(b)
+			if orderA != orderB {
+				return cmp.Compare(orderA, orderB)
+			}
+			a, b = a[1:], b[1:]
+		}
+		var digitsA, digitsB string
+		digitsA, a = leadingDigits(a)
+		digitsB, b = leadingDigits(b)
+		if c := compareNumeric(digitsA, digitsB); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// debianOrder ranks the first character of s among non-digits, the end of
+// the string ranking like a digit
+func debianOrder(s string) int {
+	switch {
+	case s == "" || isDigit(s[0]):
+		return 0
+	case isLetter(s[0]):
+		return int(s[0])
+	case s[0] == '~':
+		return -1
+	default:
+		return int(s[0]) + 256
+	}
+}
+
+func leadingDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// Phases of a release, in the order they sort
+const (
+	phaseDev = iota
+	phasePre
+	phaseFinal
+	phasePost
+)
+
+// semanticVersion is a semver or PEP 440 version
+type semanticVersion struct {
+	epoch   string
+	release []string // Numeric components, e.g. 1, 2 and 3 for 1.2.3
+	phase   int
+	tag     []string // Identifiers of the pre- or post-release, e.g. rc and 1
+	build   string   // Build metadata or PEP 440 local version, after "+"
+}
+
+// CompareSemanticVersions compares two versions following semver and
+// PEP 440, where pre-releases sort before their release: 1.0.0-rc.1 < 1.0.0
+// and 1.0rc1 < 1.0. Development releases sort first and post-releases last:
+// 1.0.dev1 < 1.0a1 < 1.0b1 < 1.0rc1 < 1.0 < 1.0.post1. A "v" prefix is
+// ignored, and what follows "+" only breaks ties
+func CompareSemanticVersions(a, b string) int {
+	versionA, versionB := parseSemanticVersion(a), parseSemanticVersion(b)
+	if c := compareNumeric(versionA.epoch, versionB.epoch); c != 0 {
+		return c
+	}
+	for i := 0; i < len(versionA.release) || i < len(versionB.release); i++ {
+		// Missing components are zeros: 1.0 == 1.0.0
+		componentA, componentB := "0", "0"
+		if i < len(versionA.release) {
+			componentA = versionA.release[i]
+		}
+		if i < len(versionB.release) {
+			componentB = versionB.release[i]
+		}
+		if c := compareNumeric(componentA, componentB); c != 0 {
+			return c
+		}
+	}
+	if c := cmp.Compare(versionA.phase, versionB.phase); c != 0 {
+		return c
+	}
+	if c := compareIdentifiers(versionA.tag, versionB.tag); c != 0 {
+		return c
+	}
+	return compareSegments(versionA.build, versionB.build)
+}
+
+func parseSemanticVersion(version string) semanticVersion {
+	v := semanticVersion{epoch: "0", phase: phaseFinal}
+	version = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(version)), "v")
+	if epoch, rest, ok := strings.Cut(version, "!"); ok {
+		v.epoch, version = epoch, rest
+	}
+	version, v.build, _ = strings.Cut(version, "+")
+
+	i := 0
+	for i < len(version) && (isDigit(version[i]) || version[i] == '.' && i+1 < len(version) && isDigit(version[i+1])) {
+		i++
+	}
+	v.release = strings.Split(version[:i], ".")
+	v.tag = identifiers(version[i:])
+	if len(v.tag) == 0 {
+		return v
+	}
+
+	switch v.tag[0] {
+	case "dev":
+		v.phase = phaseDev
+	case "post", "rev", "r":
+		v.phase = phasePost
+	default:
+		v.phase = phasePre
+		// PEP 440 spellings of alpha, beta and release candidates
+		switch v.tag[0] {
+		case "a":
+			v.tag[0] = "alpha"
+		case "b":
+			v.tag[0] = "beta"
+		case "c", "pre", "preview":
+			v.tag[0] = "rc"
+		}
+	}
+	return v
+}
+
+// identifiers splits s into runs of letters and runs of digits: "rc.1"
+// and "rc1" both give rc and 1
+func identifiers(s string) []string {
+	var ids []string
+	for i := 0; i < len(s); {
+		if !isDigit(s[i]) && !isLetter(s[i]) {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(s) && (isDigit(s[j]) || isLetter(s[j])) && isDigit(s[j]) == isDigit(s[i]) {
+			j++
+		}
+		ids = append(ids, s[i:j])
+		i = j
+	}
+	return ids
+}
+
+// compareIdentifiers compares pre-release identifiers like semver: numeric
+// ones by their value and before alphanumeric ones, and a longer list of
+// otherwise equal identifiers last
+func compareIdentifiers(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		numericA, numericB := isDigit(a[i][0]), isDigit(b[i][0])
+		switch {
+		case numericA && numericB:
+			if c := compareNumeric(a[i], b[i]); c != 0 {
+				return c
+			}
+		case numericA:
+			return -1
+		case numericB:
+			return 1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,123 @@
+package utils
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		{"1.0a", "1.0", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1:1.0", "2.0", 1},
+		{"1.0-2", "1.0-10", -1},
+		{"2.0.1", "2.0.1a", -1},
+	}
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := CompareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestCompareDebianVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.00", 0},
+		{"1.0-1", "1.0-1", 0},
+		{"1.0-1", "1.0.1", -1},
+		{"1.0+1", "1.0-1", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc1~1", 1},
+		{"1.0", "1.0-1", -1},
+		{"1.0-1", "1.0+b1", -1},
+		{"1.0+b1", "1.0.1", -1},
+		{"1.0a", "1.0+", -1},
+		{"1.0a", "1.0", 1},
+		{"1.2-3-4", "1.2-3-10", -1},
+		{"1:1.0", "2.0", 1},
+		{"0:1.0", "1.0", 0},
+		{"1.0-1ubuntu1", "1.0-1", 1},
+		{"1.10", "1.9", 1},
+	}
+	for _, tt := range tests {
+		if got := CompareDebianVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareDebianVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := CompareDebianVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareDebianVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestCompareSemanticVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0", "1.0.0", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.0.0", "1.0.1", -1},
+		{"1.10.0", "1.9.0", 1},
+		// semver pre-releases
+		{"1.0.0-rc1", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-rc.1", "0.9.9", 1},
+		{"1.0.0+build.1", "1.0.0", 1},
+		{"1.0.0-rc.1+build.5", "1.0.0", -1},
+		// PEP 440
+		{"1.0rc1", "1.0", -1},
+		{"1.0.dev1", "1.0a1", -1},
+		{"1.0a1", "1.0b1", -1},
+		{"1.0b2", "1.0rc1", -1},
+		{"1.0c1", "1.0rc1", 0},
+		{"1.0", "1.0.post1", -1},
+		{"1.0.post1", "1.0.1", -1},
+		{"1!1.0", "2.0", 1},
+		// RubyGems pre-releases
+		{"2.0.0.pre", "2.0.0", -1},
+		{"2.0.0.beta1", "2.0.0.rc1", -1},
+	}
+	for _, tt := range tests {
+		if got := CompareSemanticVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareSemanticVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := CompareSemanticVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareSemanticVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestVersionComparator(t *testing.T) {
+	tests := []struct {
+		pkgType string
+		a, b    string
+		want    int
+	}{
+		{"deb", "1.0-1", "1.0.1", -1},
+		{"rpm", "1.0~rc1", "1.0", -1},
+		{"rpm", "1.0rc1", "1.0", 1},
+		{"pypi", "1.0rc1", "1.0", -1},
+		{"cargo", "1.0.0-beta.1", "1.0.0", -1},
+		{"cask", "1.0.0-rc1", "1.0.0", -1},
+	}
+	for _, tt := range tests {
+		if got := VersionComparator(tt.pkgType)(tt.a, tt.b); got != tt.want {
+			t.Errorf("VersionComparator(%q)(%q, %q) = %d, want %d", tt.pkgType, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
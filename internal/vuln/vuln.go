@@ -0,0 +1,236 @@
+// Package vuln checks packages against known vulnerabilities before they
+// are published: through the OSV API, or the JSON report of a scanner
+// (grype, trivy) run on them beforehand
+package vuln
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Policies: what to do when packages to publish are vulnerable
+const (
+	PolicyFail   = "fail"   // Publish nothing
+	PolicyWarn   = "warn"   // Publish, logging the vulnerabilities
+	PolicyIgnore = "ignore" // Don't check packages
+)
+
+// DefaultOSVURL is the public OSV API
+const DefaultOSVURL = "https://api.osv.dev"
+
+// osvBatchSize is the most queries the OSV API takes in a batch
+const osvBatchSize = 1000
+
+// ValidatePolicy checks that policy is a known policy
+func ValidatePolicy(policy string) error {
+	switch policy {
+	case "", PolicyFail, PolicyWarn, PolicyIgnore:
+		return nil
+	}
+	return fmt.Errorf("unknown vulnerability policy %q (expected %s, %s or %s)", policy, PolicyFail, PolicyWarn, PolicyIgnore)
+}
+
+// Package is a package to check
+type Package struct {
+	Ecosystem string // OSV ecosystem, empty when OSV doesn't track the package's format
+	Name      string
+	Version   string
+}
+
+// String returns the package as name-version
+func (p Package) String() string {
+	return p.Name + "-" + p.Version
+}
+
+// Finding lists the vulnerabilities of a package
+type Finding struct {
+	Package Package
+	IDs     []string // OSV, CVE or GHSA identifiers
+}
+
+// ecosystems maps repogen package types to OSV ecosystems
+var ecosystems = map[string]string{
+	"deb":     "Debian",
+	"apk":     "Alpine",
+	"pypi":    "PyPI",
+	"rubygem": "RubyGems",
+	"cargo":   "crates.io",
+	"nuget":   "NuGet",
+}
+
+// Ecosystem returns the OSV ecosystem of packages of pkgType, empty when
+// OSV doesn't track them
+func Ecosystem(pkgType string) string {
+	return ecosystems[pkgType]
+}
+
+type osvQuery struct {
+	Package struct {
+		Name      string `json:"name"`
+		Ecosystem string `json:"ecosystem"`
+	} `json:"package"`
+	Version string `json:"version"`
+}
+
+type osvBatchResponse struct {
+	Results []struct {
+		Vulns []struct {
+			ID string `json:"id"`
+		} `json:"vulns"`
+	} `json:"results"`
+}
+
+// QueryOSV looks the packages up in the OSV API at baseURL, and returns the
+// vulnerable ones. Packages without an ecosystem are skipped
+func QueryOSV(ctx context.Context, client *http.Client, baseURL string, packages []Package) ([]Finding, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var queried []Package
+	for _, pkg := range packages {
+		if pkg.Ecosystem != "" {
+			queried = append(queried, pkg)
+		}
+	}
+
+	var findings []Finding
+	for start := 0; start < len(queried); start += osvBatchSize {
+		batch := queried[start:min(start+osvBatchSize, len(queried))]
+		results, err := queryBatch(ctx, client, baseURL, batch)
+		if err != nil {
+			return nil, err
+		}
+		for i, pkg := range batch {
+			if ids := results[i]; len(ids) > 0 {
+				findings = append(findings, Finding{Package: pkg, IDs: ids})
+			}
+		}
+	}
+	return findings, nil
+}
+
+// queryBatch returns the vulnerability IDs of each package of batch
+func queryBatch(ctx context.Context, client *http.Client, baseURL string, batch []Package) ([][]string, error) {
+	queries := make([]osvQuery, len(batch))
+	for i, pkg := range batch {
+		queries[i].Package.Name = pkg.Name
+		queries[i].Package.Ecosystem = pkg.Ecosystem
+		queries[i].Version = pkg.Version
+	}
+	body, err := json.Marshal(map[string][]osvQuery{"queries": queries})
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimRight(baseURL, "/") + "/v1/querybatch"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("OSV query failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("POST %s: %s: %s", url, resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var response osvBatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("invalid OSV response: %w", err)
+	}
+	if len(response.Results) != len(batch) {
+		return nil, fmt.Errorf("invalid OSV response: %d results for %d queries", len(response.Results), len(batch))
+	}
+
+	results := make([][]string, len(batch))
+	for i, result := range response.Results {
+		for _, v := range result.Vulns {
+			results[i] = append(results[i], v.ID)
+		}
+	}
+	return results, nil
+}
+
+// Report holds the vulnerabilities a scanner found, by package
+type Report struct {
+	vulns map[string][]string // By name@version
+}
+
+// scannerReport decodes the reports of grype (-o json) and trivy (--format json)
+type scannerReport struct {
+	Matches []struct {
+		Vulnerability struct {
+			ID string `json:"id"`
+		} `json:"vulnerability"`
+		Artifact struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"artifact"`
+	} `json:"matches"`
+	Results []struct {
+		Vulnerabilities []struct {
+			VulnerabilityID  string
+			PkgName          string
+			InstalledVersion string
+		}
+	}
+}
+
+// LoadReport reads a grype or trivy JSON report
+func LoadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sr scannerReport
+	if err := json.Unmarshal(data, &sr); err != nil {
+		return nil, fmt.Errorf("invalid vulnerability report %s: %w", path, err)
+	}
+
+	r := &Report{vulns: make(map[string][]string)}
+	for _, m := range sr.Matches {
+		r.add(m.Artifact.Name, m.Artifact.Version, m.Vulnerability.ID)
+	}
+	for _, result := range sr.Results {
+		for _, v := range result.Vulnerabilities {
+			r.add(v.PkgName, v.InstalledVersion, v.VulnerabilityID)
+		}
+	}
+	return r, nil
+}
+
+func (r *Report) add(name, version, id string) {
+	key := name + "@" + version
+	for _, known := range r.vulns[key] {
+		if known == id {
+			return
+		}
+	}
+	r.vulns[key] = append(r.vulns[key], id)
+}
+
+// Check returns the packages the report lists vulnerabilities for, whatever
+// their ecosystem
+func (r *Report) Check(packages []Package) []Finding {
+	var findings []Finding
+	for _, pkg := range packages {
+		if ids := r.vulns[pkg.Name+"@"+pkg.Version]; len(ids) > 0 {
+			ids = append([]string(nil), ids...)
+			sort.Strings(ids)
+			findings = append(findings, Finding{Package: pkg, IDs: ids})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,103 @@
+package vuln
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValidatePolicy(t *testing.T) {
+	for _, policy := range []string{"", PolicyFail, PolicyWarn, PolicyIgnore} {
+		if err := ValidatePolicy(policy); err != nil {
+			t.Errorf("ValidatePolicy(%q) failed: %v", policy, err)
+		}
+	}
+	if err := ValidatePolicy("block"); err == nil {
+		t.Error("ValidatePolicy accepted an unknown policy")
+	}
+}
+
+func TestQueryOSV(t *testing.T) {
+	var queries []osvQuery
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/querybatch" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		var body struct {
+			Queries []osvQuery `json:"queries"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		queries = body.Queries
+		w.Write([]byte(`{"results": [{"vulns": [{"id": "DSA-1234-1"}, {"id": "CVE-2024-0001"}]}, {}]}`))
+	}))
+	defer server.Close()
+
+	packages := []Package{
+		{Ecosystem: "Debian", Name: "openssl", Version: "1.1.1"},
+		{Name: "hello", Version: "1.0-1"}, // RPM, not tracked by OSV
+		{Ecosystem: "PyPI", Name: "requests", Version: "2.31.0"},
+	}
+	findings, err := QueryOSV(context.Background(), server.Client(), server.URL+"/", packages)
+	if err != nil {
+		t.Fatalf("QueryOSV failed: %v", err)
+	}
+
+	if len(queries) != 2 || queries[0].Package.Name != "openssl" || queries[0].Package.Ecosystem != "Debian" || queries[1].Version != "2.31.0" {
+		t.Errorf("Unexpected queries: %+v", queries)
+	}
+	want := []Finding{{Package: packages[0], IDs: []string{"DSA-1234-1", "CVE-2024-0001"}}}
+	if !reflect.DeepEqual(findings, want) {
+		t.Errorf("QueryOSV = %+v, want %+v", findings, want)
+	}
+
+	if _, err := QueryOSV(context.Background(), server.Client(), server.URL+"/missing", packages); err == nil {
+		t.Error("QueryOSV succeeded against a failing API")
+	}
+}
+
+func TestLoadReport(t *testing.T) {
+	dir := t.TempDir()
+	reports := map[string]string{
+		"grype.json": `{"matches": [
+			{"vulnerability": {"id": "CVE-2024-0002"}, "artifact": {"name": "hello", "version": "1.0-1"}},
+			{"vulnerability": {"id": "CVE-2024-0001"}, "artifact": {"name": "hello", "version": "1.0-1"}},
+			{"vulnerability": {"id": "CVE-2024-0001"}, "artifact": {"name": "hello", "version": "1.0-1"}},
+			{"vulnerability": {"id": "CVE-2024-0003"}, "artifact": {"name": "hello", "version": "0.9-1"}}
+		]}`,
+		"trivy.json": `{"Results": [{"Vulnerabilities": [
+			{"VulnerabilityID": "CVE-2024-0002", "PkgName": "hello", "InstalledVersion": "1.0-1"},
+			{"VulnerabilityID": "CVE-2024-0001", "PkgName": "hello", "InstalledVersion": "1.0-1"}
+		]}]}`,
+	}
+
+	packages := []Package{{Name: "hello", Version: "1.0-1"}, {Name: "world", Version: "1.0"}}
+	want := []Finding{{Package: packages[0], IDs: []string{"CVE-2024-0001", "CVE-2024-0002"}}}
+	for name, data := range reports {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		report, err := LoadReport(path)
+		if err != nil {
+			t.Fatalf("LoadReport(%s) failed: %v", name, err)
+		}
+		if findings := report.Check(packages); !reflect.DeepEqual(findings, want) {
+			t.Errorf("%s: Check = %+v, want %+v", name, findings, want)
+		}
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte("not json"), 0644)
+	if _, err := LoadReport(invalid); err == nil {
+		t.Error("LoadReport accepted an invalid report")
+	}
+}
@@ -0,0 +1,83 @@
+// Package zchunk writes zchunk files: data compressed in independent
+// chunks, listed with their checksums in a header, so clients holding an
+// earlier version only download the chunks that changed
+package zchunk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Checksum types
+const (
+	checksumSHA256    = 1
+	checksumSHA512128 = 3 // First 128 bits of SHA-512, for chunks
+
+	chunkChecksumSize = 16
+)
+
+const compressionZstd = 2
+
+// Compress compresses each chunk with zstd and returns the zchunk file, and
+// the size of its header, which starts the file
+func Compress(chunks [][]byte) ([]byte, int, error) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer enc.Close()
+
+	// There is no dictionary: its entry is zeros
+	var index, data []byte
+	index = appendInt(index, checksumSHA512128)
+	index = appendInt(index, uint64(len(chunks)+1))
+	index = append(index, make([]byte, chunkChecksumSize)...)
+	index = appendInt(index, 0)
+	index = appendInt(index, 0)
+	for _, chunk := range chunks {
+		compressed := enc.EncodeAll(chunk, nil)
+		sum := sha512.Sum512(compressed)
+		index = append(index, sum[:chunkChecksumSize]...)
+		index = appendInt(index, uint64(len(compressed)))
+		index = appendInt(index, uint64(len(chunk)))
+		data = append(data, compressed...)
+	}
+
+	dataSum := sha256.Sum256(data)
+	var header []byte
+	header = append(header, dataSum[:]...)
+	header = appendInt(header, 0) // Flags
+	header = appendInt(header, compressionZstd)
+	header = appendInt(header, uint64(len(index)))
+	header = append(header, index...)
+	header = appendInt(header, 0) // Signatures
+
+	lead := []byte("\x00ZCK1")
+	lead = appendInt(lead, checksumSHA256)
+	lead = appendInt(lead, uint64(len(header)))
+
+	// The header checksum covers everything up to the end of the header
+	// but itself
+	headerSum := sha256.Sum256(append(append([]byte{}, lead...), header...))
+
+	var file bytes.Buffer
+	file.Write(lead)
+	file.Write(headerSum[:])
+	file.Write(header)
+	headerSize := file.Len()
+	file.Write(data)
+	return file.Bytes(), headerSize, nil
+}
+
+// appendInt appends v as a zchunk compressed integer: 7 bits a byte, least
+// significant first, the high bit marking the last byte
+func appendInt(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v&0x7f))
+		v >>= 7
+	}
+	return append(b, byte(v)|0x80)
+}
@@ -0,0 +1,111 @@
+package zchunk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// reader walks a zchunk file
+type reader struct {
+	data []byte
+	pos  int
+}
+
+func (r *reader) int() uint64 {
+	var v uint64
+	for shift := 0; ; shift += 7 {
+		b := r.data[r.pos]
+		r.pos++
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 != 0 {
+			return v
+		}
+	}
+}
+
+func (r *reader) bytes(n int) []byte {
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func TestCompress(t *testing.T) {
+	chunks := [][]byte{
+		[]byte("<?xml version=\"1.0\"?>\n<metadata>"),
+		bytes.Repeat([]byte("<package>a</package>"), 100),
+		[]byte("</metadata>\n"),
+	}
+	file, headerSize, err := Compress(chunks)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+
+	r := &reader{data: file}
+	if id := r.bytes(5); string(id) != "\x00ZCK1" {
+		t.Fatalf("Unexpected ID %q", id)
+	}
+	if r.int() != checksumSHA256 {
+		t.Fatal("Unexpected header checksum type")
+	}
+	size := int(r.int())
+	leadEnd := r.pos
+	headerSum := r.bytes(sha256.Size)
+	if r.pos+size != headerSize {
+		t.Fatalf("Header size %d doesn't match %d", r.pos+size, headerSize)
+	}
+	sum := sha256.Sum256(append(append([]byte{}, file[:leadEnd]...), file[r.pos:headerSize]...))
+	if !bytes.Equal(sum[:], headerSum) {
+		t.Error("Header checksum mismatch")
+	}
+
+	dataSum := r.bytes(sha256.Size)
+	if sum := sha256.Sum256(file[headerSize:]); !bytes.Equal(sum[:], dataSum) {
+		t.Error("Data checksum mismatch")
+	}
+	if flags, compression := r.int(), r.int(); flags != 0 || compression != compressionZstd {
+		t.Errorf("Unexpected flags %d, compression %d", flags, compression)
+	}
+
+	indexSize := int(r.int())
+	indexEnd := r.pos + indexSize
+	if r.int() != checksumSHA512128 {
+		t.Fatal("Unexpected chunk checksum type")
+	}
+	if count := r.int(); count != uint64(len(chunks)+1) {
+		t.Fatalf("Chunk count %d, want %d", count, len(chunks)+1)
+	}
+	if dict := r.bytes(chunkChecksumSize); !bytes.Equal(dict, make([]byte, chunkChecksumSize)) || r.int() != 0 || r.int() != 0 {
+		t.Error("Unexpected dictionary entry")
+	}
+
+	dec, _ := zstd.NewReader(nil)
+	defer dec.Close()
+	offset := headerSize
+	for i, chunk := range chunks {
+		chunkSum := r.bytes(chunkChecksumSize)
+		length, uncompressed := int(r.int()), int(r.int())
+		compressed := file[offset : offset+length]
+		offset += length
+
+		if sum := sha512.Sum512(compressed); !bytes.Equal(sum[:chunkChecksumSize], chunkSum) {
+			t.Errorf("Chunk %d checksum mismatch", i)
+		}
+		got, err := dec.DecodeAll(compressed, nil)
+		if err != nil || !bytes.Equal(got, chunk) || uncompressed != len(chunk) {
+			t.Errorf("Chunk %d = %q (%v), want %q", i, got, err, chunk)
+		}
+	}
+	if r.pos != indexEnd {
+		t.Errorf("Index ends at %d, want %d", r.pos, indexEnd)
+	}
+	if signatures := r.int(); signatures != 0 || r.pos != headerSize {
+		t.Errorf("Unexpected signatures %d, header end %d", signatures, r.pos)
+	}
+	if offset != len(file) {
+		t.Errorf("Chunks end at %d, file at %d", offset, len(file))
+	}
+}
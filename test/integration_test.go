@@ -10,6 +10,33 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/ralt/repogen/internal/fixture"
+	"github.com/ralt/repogen/internal/keygen"
+)
+
+// Test packages, built in pure Go when build-test-packages.sh wasn't run
+var (
+	debFixtures = []fixture.Package{
+		{Type: "deb", Name: "repogen-test", Version: "1.0.0"},
+		{Type: "deb", Name: "repogen-utils", Version: "2.0.0"},
+		{Type: "deb", Name: "repogen-gzipped", Version: "3.0.0", Compression: "gzip"},
+	}
+	rpmFixtures = []fixture.Package{
+		{Type: "rpm", Name: "repogen-test", Version: "1.0.0"},
+		{Type: "rpm", Name: "repogen-utils", Version: "2.0.0"},
+	}
+	apkFixtures = []fixture.Package{
+		{Type: "apk", Name: "repogen-test", Version: "1.0.0"},
+		{Type: "apk", Name: "repogen-utils", Version: "2.0.0"},
+	}
+	bottleFixtures = []fixture.Package{
+		{Type: "bottle", Name: "repogen-test", Version: "1.0.0"},
+		{Type: "bottle", Name: "repogen-utils", Version: "2.0.0"},
+	}
+	pacmanFixtures = []fixture.Package{
+		{Type: "pacman", Name: "repogen-test", Version: "1.0.0"},
+	}
 )
 
 // TestIntegration runs Docker-based integration tests for all repository types
@@ -69,6 +96,10 @@ func TestIntegration(t *testing.T) {
 		testAlpineRepository(t, projectRoot, testDir)
 	})
 
+	t.Run("AlpineSigned", func(t *testing.T) {
+		testAlpineSignedRepository(t, projectRoot, testDir)
+	})
+
 	t.Run("Homebrew", func(t *testing.T) {
 		testHomebrewRepository(t, projectRoot, testDir)
 	})
@@ -86,9 +117,9 @@ func testDebianRepository(t *testing.T, projectRoot, testDir string) {
 	repoDir := filepath.Join(testDir, "debian-repo")
 	fixturesDir := filepath.Join(projectRoot, "test", "fixtures", "debs")
 
-	// Check if test packages exist
+	// Build the test packages unless build-test-packages.sh did
 	if _, err := os.Stat(filepath.Join(fixturesDir, "repogen-test_1.0.0_amd64.deb")); os.IsNotExist(err) {
-		t.Skip("Debian test packages not found, run build-test-packages.sh first")
+		buildFixtures(t, fixturesDir, debFixtures)
 	}
 
 	// Generate repository
@@ -160,9 +191,9 @@ func testDebianSignedRepository(t *testing.T, projectRoot, testDir string) {
 	fixturesDir := filepath.Join(projectRoot, "test", "fixtures", "debs")
 	gpgFixturesDir := filepath.Join(projectRoot, "test", "fixtures", "gpg-keys")
 
-	// Check if test packages exist
+	// Build the test packages unless build-test-packages.sh did
 	if _, err := os.Stat(filepath.Join(fixturesDir, "repogen-test_1.0.0_amd64.deb")); os.IsNotExist(err) {
-		t.Skip("Debian test packages not found, run build-test-packages.sh first")
+		buildFixtures(t, fixturesDir, debFixtures)
 	}
 
 	// Use fixture keys
@@ -271,9 +302,9 @@ func testDebianSignedTrixieRepository(t *testing.T, projectRoot, testDir string)
 	fixturesDir := filepath.Join(projectRoot, "test", "fixtures", "debs")
 	gpgFixturesDir := filepath.Join(projectRoot, "test", "fixtures", "gpg-keys")
 
-	// Check if test packages exist
+	// Build the test packages unless build-test-packages.sh did
 	if _, err := os.Stat(filepath.Join(fixturesDir, "repogen-test_1.0.0_amd64.deb")); os.IsNotExist(err) {
-		t.Skip("Debian test packages not found, run build-test-packages.sh first")
+		buildFixtures(t, fixturesDir, debFixtures)
 	}
 
 	// Use fixture keys
@@ -375,9 +406,9 @@ func testDebianTrixieRepository(t *testing.T, projectRoot, testDir string) {
 	repoDir := filepath.Join(testDir, "debian-trixie-repo")
 	fixturesDir := filepath.Join(projectRoot, "test", "fixtures", "debs")
 
-	// Check if test packages exist
+	// Build the test packages unless build-test-packages.sh did
 	if _, err := os.Stat(filepath.Join(fixturesDir, "repogen-test_1.0.0_amd64.deb")); os.IsNotExist(err) {
-		t.Skip("Debian test packages not found, run build-test-packages.sh first")
+		buildFixtures(t, fixturesDir, debFixtures)
 	}
 
 	// Generate unsigned repository (no GPG flags)
@@ -456,10 +487,10 @@ func testRPMRepository(t *testing.T, projectRoot, testDir string) {
 	repoDir := filepath.Join(testDir, "rpm-repo")
 	fixturesDir := filepath.Join(projectRoot, "test", "fixtures", "rpms")
 
-	// Check if test packages exist
+	// Build the test packages unless build-test-packages.sh did
 	rpms, _ := filepath.Glob(filepath.Join(fixturesDir, "*.rpm"))
 	if len(rpms) < 2 {
-		t.Skip("RPM test packages not found (need 2), run build-test-packages.sh first")
+		buildFixtures(t, fixturesDir, rpmFixtures)
 	}
 
 	// Generate repository
@@ -538,10 +569,10 @@ func testAlpineRepository(t *testing.T, projectRoot, testDir string) {
 	repoDir := filepath.Join(testDir, "alpine-repo")
 	fixturesDir := filepath.Join(projectRoot, "test", "fixtures", "apks")
 
-	// Check if test packages exist
+	// Build the test packages unless build-test-packages.sh did
 	apks, _ := filepath.Glob(filepath.Join(fixturesDir, "*.apk"))
 	if len(apks) < 2 {
-		t.Skip("Alpine test packages not found (need 2), run build-test-packages.sh first")
+		buildFixtures(t, fixturesDir, apkFixtures)
 	}
 
 	// Generate repository
@@ -608,14 +639,83 @@ func testAlpineRepository(t *testing.T, projectRoot, testDir string) {
 	t.Log("Note: Full package installation requires GPG/RSA signing")
 }
 
+func testAlpineSignedRepository(t *testing.T, projectRoot, testDir string) {
+	repoDir := filepath.Join(testDir, "alpine-signed-repo")
+	fixturesDir := filepath.Join(projectRoot, "test", "fixtures", "apks")
+	keysDir := filepath.Join(testDir, "alpine-keys")
+
+	// Build the test packages unless build-test-packages.sh did
+	apks, _ := filepath.Glob(filepath.Join(fixturesDir, "*.apk"))
+	if len(apks) < 2 {
+		buildFixtures(t, fixturesDir, apkFixtures)
+	}
+
+	// Generate RSA key pair (equivalent to abuild-keygen)
+	if err := os.MkdirAll(keysDir, 0755); err != nil {
+		t.Fatalf("Failed to create keys directory: %v", err)
+	}
+	privateKeyPath := filepath.Join(keysDir, "repogen-test.rsa")
+	publicKeyPath := filepath.Join(keysDir, "repogen-test.rsa.pub")
+	if err := generateTestRSAKey(privateKeyPath, publicKeyPath); err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+
+	// Generate signed repository
+	t.Log("Generating signed Alpine repository...")
+	repoGenBin := filepath.Join(projectRoot, "repogen")
+	cmd := exec.Command(repoGenBin, "generate",
+		"--input-dir", fixturesDir,
+		"--output-dir", repoDir,
+		"--arch", "x86_64",
+		"--rsa-key", privateKeyPath,
+		"--key-name", "repogen-test",
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Failed to generate repository: %v\nOutput: %s", err, output)
+	}
+
+	// Detached signature files are not understood by apk and must not be generated
+	sigFiles, _ := filepath.Glob(filepath.Join(repoDir, "x86_64", "APKINDEX.tar.gz.SIGN.*"))
+	if len(sigFiles) > 0 {
+		t.Errorf("Unexpected detached signature files: %v", sigFiles)
+	}
+
+	// Install packages without --allow-untrusted, relying on the embedded signature
+	t.Log("Testing signed repository in Alpine container...")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	dockerCmd := exec.CommandContext(ctx, "docker", "run", "--rm",
+		"-v", fmt.Sprintf("%s:/repo:ro", repoDir),
+		"-v", fmt.Sprintf("%s:/etc/apk/keys/repogen-test.rsa.pub:ro", publicKeyPath),
+		"alpine:3.20",
+		"sh", "-c", `
+set -e
+echo "/repo" > /etc/apk/repositories
+apk update
+apk add repogen-test repogen-utils
+repogen-test
+repogen-utils
+`,
+	)
+	dockerCmd.Stdout = os.Stdout
+	dockerCmd.Stderr = os.Stderr
+
+	if err := dockerCmd.Run(); err != nil {
+		t.Fatalf("Docker test failed: %v", err)
+	}
+
+	t.Log("✓ Signed Alpine repository test passed")
+}
+
 func testHomebrewRepository(t *testing.T, projectRoot, testDir string) {
 	repoDir := filepath.Join(testDir, "homebrew-repo")
 	fixturesDir := filepath.Join(projectRoot, "test", "fixtures", "bottles")
 
-	// Check if test bottles exist
+	// Build the test bottles unless build-test-packages.sh did
 	bottles, _ := filepath.Glob(filepath.Join(fixturesDir, "*.bottle.tar.gz"))
 	if len(bottles) < 2 {
-		t.Skip("Homebrew test bottles not found (need 2), run build-test-packages.sh first")
+		buildFixtures(t, fixturesDir, bottleFixtures)
 	}
 
 	// Generate repository
@@ -672,10 +772,10 @@ func testPacmanRepository(t *testing.T, projectRoot, testDir string) {
 	repoDir := filepath.Join(testDir, "pacman-repo")
 	fixturesDir := filepath.Join(projectRoot, "test", "fixtures", "pacman")
 
-	// Check if test packages exist
+	// Build the test packages unless build-test-packages.sh did
 	pkgs, _ := filepath.Glob(filepath.Join(fixturesDir, "*.pkg.tar.*"))
 	if len(pkgs) == 0 {
-		t.Skip("Pacman test packages not found, run build-test-packages.sh first")
+		pkgs = buildFixtures(t, fixturesDir, pacmanFixtures)
 	}
 
 	// Generate repository
@@ -833,7 +933,7 @@ func verifyPacmanChecksums(t *testing.T, projectRoot, testDir string) {
 	repoDir := filepath.Join(testDir, "checksum-pacman-repo")
 	fixturesDir := filepath.Join(projectRoot, "test", "fixtures", "pacman")
 
-	// Check if test packages exist
+	// Build the test packages unless build-test-packages.sh did
 	pkgs, _ := filepath.Glob(filepath.Join(fixturesDir, "*.pkg.tar.*"))
 	if len(pkgs) == 0 {
 		t.Skip("Pacman test packages not found")
@@ -881,7 +981,7 @@ func verifyDebianChecksums(t *testing.T, projectRoot, testDir string) {
 	repoDir := filepath.Join(testDir, "checksum-deb-repo")
 	fixturesDir := filepath.Join(projectRoot, "test", "fixtures", "debs")
 
-	// Check if test packages exist
+	// Build the test packages unless build-test-packages.sh did
 	if _, err := os.Stat(filepath.Join(fixturesDir, "repogen-test_1.0.0_amd64.deb")); os.IsNotExist(err) {
 		t.Skip("Debian test packages not found")
 	}
@@ -928,7 +1028,7 @@ func verifyRPMChecksums(t *testing.T, projectRoot, testDir string) {
 	repoDir := filepath.Join(testDir, "checksum-rpm-repo")
 	fixturesDir := filepath.Join(projectRoot, "test", "fixtures", "rpms")
 
-	// Check if test packages exist
+	// Build the test packages unless build-test-packages.sh did
 	rpms, _ := filepath.Glob(filepath.Join(fixturesDir, "*.rpm"))
 	if len(rpms) == 0 {
 		t.Skip("RPM test packages not found")
@@ -985,7 +1085,7 @@ func verifyAPKChecksums(t *testing.T, projectRoot, testDir string) {
 	repoDir := filepath.Join(testDir, "checksum-apk-repo")
 	fixturesDir := filepath.Join(projectRoot, "test", "fixtures", "apks")
 
-	// Check if test packages exist
+	// Build the test packages unless build-test-packages.sh did
 	apks, _ := filepath.Glob(filepath.Join(fixturesDir, "*.apk"))
 	if len(apks) == 0 {
 		t.Skip("APK test packages not found")
@@ -1082,60 +1182,29 @@ func findInString(s, substr string) bool {
 
 // generateTestGPGKey creates a test GPG key pair for repository signing tests
 func generateTestGPGKey(privateKeyPath, publicKeyPath string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	// Create GPG batch file for unattended key generation
-	batchContent := `
-%no-protection
-Key-Type: RSA
-Key-Length: 2048
-Name-Real: Repogen Test Key
-Name-Email: test@repogen.local
-Expire-Date: 0
-%commit
-`
-
-	tmpDir := filepath.Dir(privateKeyPath)
-	batchFile := filepath.Join(tmpDir, "gpg-batch.txt")
-	if err := os.WriteFile(batchFile, []byte(batchContent), 0600); err != nil {
-		return fmt.Errorf("failed to create batch file: %w", err)
-	}
-	defer os.Remove(batchFile)
-
-	// Generate key using gpg with temporary home directory
-	gpgHome := filepath.Join(tmpDir, "gpg-home")
-	if err := os.MkdirAll(gpgHome, 0700); err != nil {
-		return fmt.Errorf("failed to create GPG home: %w", err)
-	}
-
-	// Generate the key
-	cmd := exec.CommandContext(ctx, "gpg", "--homedir", gpgHome, "--batch", "--gen-key", batchFile)
-	output, err := cmd.CombinedOutput()
+	privateKey, publicKey, err := keygen.GPG(keygen.Options{Name: "Repogen Test Key", Email: "test@repogen.local", Bits: 2048})
 	if err != nil {
-		return fmt.Errorf("failed to generate GPG key: %w\nOutput: %s", err, output)
+		return err
 	}
+	return writeTestKeyPair(privateKeyPath, publicKeyPath, privateKey, publicKey)
+}
 
-	// Export private key
-	cmd = exec.CommandContext(ctx, "gpg", "--homedir", gpgHome, "--armor", "--export-secret-keys", "test@repogen.local")
-	privateKey, err := cmd.Output()
+// generateTestRSAKey creates a test RSA key pair for Alpine repository signing tests
+func generateTestRSAKey(privateKeyPath, publicKeyPath string) error {
+	privateKey, publicKey, err := keygen.RSA(keygen.Options{Bits: 2048})
 	if err != nil {
-		return fmt.Errorf("failed to export private key: %w", err)
+		return err
 	}
+	return writeTestKeyPair(privateKeyPath, publicKeyPath, privateKey, publicKey)
+}
+
+func writeTestKeyPair(privateKeyPath, publicKeyPath string, privateKey, publicKey []byte) error {
 	if err := os.WriteFile(privateKeyPath, privateKey, 0600); err != nil {
 		return fmt.Errorf("failed to write private key: %w", err)
 	}
-
-	// Export public key
-	cmd = exec.CommandContext(ctx, "gpg", "--homedir", gpgHome, "--armor", "--export", "test@repogen.local")
-	publicKey, err := cmd.Output()
-	if err != nil {
-		return fmt.Errorf("failed to export public key: %w", err)
-	}
 	if err := os.WriteFile(publicKeyPath, publicKey, 0644); err != nil {
 		return fmt.Errorf("failed to write public key: %w", err)
 	}
-
 	return nil
 }
 
@@ -1418,3 +1487,18 @@ func calculateSHA256(path string) (string, error) {
 
 	return parts[0], nil
 }
+
+// buildFixtures writes the test packages pkgs into dir and returns their paths
+func buildFixtures(t *testing.T, dir string, pkgs []fixture.Package) []string {
+	t.Helper()
+
+	var paths []string
+	for _, pkg := range pkgs {
+		path, err := fixture.Build(pkg, dir)
+		if err != nil {
+			t.Fatalf("Failed to build %s test package %s: %v", pkg.Type, pkg.Name, err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}